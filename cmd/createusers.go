@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/config"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/httpd"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+var (
+	createUsersTemplate string
+	createUsersCSV      string
+	createUsersCmd      = &cobra.Command{
+		Use:   "createusers",
+		Short: "Create users from a template",
+		Long: `This command creates the users listed in a CSV file using a JSON user template.
+The users are added directly to the configured data provider, so SFTPGo does not
+need to be running.
+
+The template is a user, as JSON, and it can contain the "%username%" and
+"%password%" placeholders. Each CSV record contains the username and, optionally,
+the password and the public key. A header, if present, is ignored.
+
+All the users are validated before adding them, the command stops at the first
+error adding a user.
+
+Usage example:
+
+$ sftpgo createusers --template user.json --users users.csv
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			templateContent, err := ioutil.ReadFile(createUsersTemplate)
+			if err != nil {
+				logger.ErrorToConsole("unable to read the user template: %v", err)
+				os.Exit(1)
+			}
+			usersContent, err := ioutil.ReadFile(createUsersCSV)
+			if err != nil {
+				logger.ErrorToConsole("unable to read the users to add: %v", err)
+				os.Exit(1)
+			}
+			configDir = utils.CleanDirInput(configDir)
+			err = config.LoadConfig(configDir, configFile)
+			if err != nil {
+				logger.ErrorToConsole("Unable to load configuration: %v", err)
+				os.Exit(1)
+			}
+			kmsConfig := config.GetKMSConfig()
+			err = kmsConfig.Initialize()
+			if err != nil {
+				logger.ErrorToConsole("unable to initialize KMS: %v", err)
+				os.Exit(1)
+			}
+			providerConf := config.GetProviderConf()
+			if providerConf.Driver == dataprovider.MemoryDataProviderName {
+				logger.ErrorToConsole("the memory provider is not supported, the users will be lost on exit")
+				os.Exit(1)
+			}
+			err = dataprovider.Initialize(providerConf, configDir, false)
+			if err != nil {
+				logger.ErrorToConsole("unable to initialize the data provider: %v", err)
+				os.Exit(1)
+			}
+			users, err := httpd.GetUsersFromTemplate(templateContent, usersContent)
+			if err != nil {
+				logger.ErrorToConsole("%v", err)
+				os.Exit(1)
+			}
+			for idx := range users {
+				if err := dataprovider.AddUser(&users[idx]); err != nil {
+					logger.ErrorToConsole("unable to add user %#v, users added before this error: %v, error: %v",
+						users[idx].Username, idx, err)
+					os.Exit(1)
+				}
+				logger.InfoToConsole("user %#v added", users[idx].Username)
+			}
+			logger.InfoToConsole("%v users added", len(users))
+		},
+	}
+)
+
+func init() {
+	addConfigFlags(createUsersCmd)
+	createUsersCmd.Flags().StringVar(&createUsersTemplate, "template", "", `Path to the JSON user template`)
+	createUsersCmd.MarkFlagRequired("template") //nolint:errcheck
+	createUsersCmd.Flags().StringVar(&createUsersCSV, "users", "", `Path to the CSV file with the users to add`)
+	createUsersCmd.MarkFlagRequired("users") //nolint:errcheck
+
+	rootCmd.AddCommand(createUsersCmd)
+}
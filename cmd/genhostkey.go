@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+var (
+	hostKeyType      string
+	hostKeyBits      int
+	hostKeyOutput    string
+	hostKeyOverwrite bool
+	genHostKeyCmd    = &cobra.Command{
+		Use:   "hostkey",
+		Short: "Generate an SSH host key",
+		Long: `This command generates an SSH host key, the private key is written to
+the specified output path and the public key to the same path adding the
+".pub" suffix. The generated keys can be configured using the "host_keys"
+setting in the "sftpd" configuration section.
+
+Supported key types and sizes:
+
+- rsa: 2048, 3072 or 4096 bits. Default 4096
+- ecdsa: 256, 384 or 521 bits. Default 256
+- ed25519: fixed size
+
+If the output path is not specified, the key is written to "id_<type>" inside
+the current directory. Existing keys are not overwritten unless you set the
+"--force" flag, for example:
+
+$ sftpgo gen hostkey --type ecdsa --bits 384 --output /etc/sftpgo/id_ecdsa
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			if !utils.IsStringInSlice(hostKeyType, []string{utils.SSHKeyTypeRSA, utils.SSHKeyTypeECDSA,
+				utils.SSHKeyTypeEd25519}) {
+				logger.ErrorToConsole("Unsupported key type %#v", hostKeyType)
+				os.Exit(1)
+			}
+			output := hostKeyOutput
+			if output == "" {
+				output = fmt.Sprintf("id_%v", hostKeyType)
+			}
+			output, err := filepath.Abs(output)
+			if err != nil {
+				logger.ErrorToConsole("Invalid output path %#v: %v", hostKeyOutput, err)
+				os.Exit(1)
+			}
+			if !hostKeyOverwrite {
+				for _, p := range []string{output, output + ".pub"} {
+					if _, err := os.Stat(p); err == nil {
+						logger.ErrorToConsole("%#v already exists, use the --force flag to overwrite it", p)
+						os.Exit(1)
+					}
+				}
+			}
+			err = utils.WriteSSHKeyPair(output, hostKeyType, hostKeyBits)
+			if err != nil {
+				logger.ErrorToConsole("Unable to generate the host key: %v", err)
+				os.Exit(1)
+			}
+			fingerprint, err := getPublicKeyFingerprint(output + ".pub")
+			if err != nil {
+				logger.WarnToConsole("Host key generated but unable to get its fingerprint: %v", err)
+				os.Exit(1)
+			}
+			logger.InfoToConsole("Host key %#v generated, fingerprint: %v", output, fingerprint)
+		},
+	}
+)
+
+func getPublicKeyFingerprint(name string) (string, error) {
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(content)
+	if err != nil {
+		return "", err
+	}
+	return ssh.FingerprintSHA256(pubKey), nil
+}
+
+func init() {
+	genHostKeyCmd.Flags().StringVarP(&hostKeyType, "type", "t", utils.SSHKeyTypeEd25519,
+		"Key type: rsa, ecdsa or ed25519")
+	genHostKeyCmd.Flags().IntVarP(&hostKeyBits, "bits", "b", 0,
+		"Key size in bits. 0 means the default size for the key type")
+	genHostKeyCmd.Flags().StringVarP(&hostKeyOutput, "output", "o", "",
+		`Path for the private key, the public key is
+written to the same path adding the ".pub"
+suffix. Default "id_<type>" inside the current
+directory`)
+	genHostKeyCmd.Flags().BoolVar(&hostKeyOverwrite, "force", false, "Overwrite existing keys")
+	genCmd.AddCommand(genHostKeyCmd)
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/drakkan/sftpgo/config"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+var (
+	hashPasswordCmd = &cobra.Command{
+		Use:   "hashpassword",
+		Short: "Hash a password using the configured algorithm",
+		Long: `This command reads the password hashing settings from the specified
+configuration file and prints the hash for the given password, so users and
+admins can be pre-provisioned, for example in SQL or dump files, without
+storing the plain text password.
+
+If the standard input is a terminal the password is read, twice, without
+echoing it, otherwise the first line of the standard input is used, for
+example:
+
+$ sftpgo hashpassword
+$ echo "my password" | sftpgo hashpassword
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			configDir = utils.CleanDirInput(configDir)
+			err := config.LoadConfig(configDir, configFile)
+			if err != nil {
+				logger.WarnToConsole("Unable to load configuration: %v", err)
+				os.Exit(1)
+			}
+			password, err := readPasswordToHash()
+			if err != nil {
+				logger.ErrorToConsole("Unable to read the password: %v", err)
+				os.Exit(1)
+			}
+			hash, err := dataprovider.HashPassword(config.GetProviderConf().PasswordHashing, password)
+			if err != nil {
+				logger.ErrorToConsole("Unable to hash the password: %v", err)
+				os.Exit(1)
+			}
+			fmt.Println(hash)
+		},
+	}
+)
+
+func readPasswordToHash() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		reader := bufio.NewReader(os.Stdin)
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Confirm password: ")
+	confirmation, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(password) != string(confirmation) {
+		return "", errors.New("the passwords do not match")
+	}
+	return string(password), nil
+}
+
+func init() {
+	addConfigFlags(hashPasswordCmd)
+
+	rootCmd.AddCommand(hashPasswordCmd)
+}
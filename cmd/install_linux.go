@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/service"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+var (
+	serviceUser    string
+	serviceGroup   string
+	serviceUnitDir string
+	installCmd     = &cobra.Command{
+		Use:   "install",
+		Short: "Install SFTPGo as systemd service",
+		Long: `To install the SFTPGo systemd service, running as the "sftpgo" user, with the
+default values for the command line flags simply use:
+
+sudo sftpgo service install --user sftpgo --group sftpgo
+
+The unit file is written to "/etc/systemd/system/sftpgo.service" and it
+executes the "serve" command using the current executable path. The
+configuration directory is the unit working directory and an optional
+"sftpgo.env" file inside it is loaded as environment file.
+The service is enabled but not started, use "sftpgo service start" to start it.
+
+Please take a look at the usage below to customize the startup options`,
+		Run: func(cmd *cobra.Command, args []string) {
+			absConfigDir, err := filepath.Abs(utils.CleanDirInput(configDir))
+			if err != nil {
+				fmt.Printf("Invalid config dir %#v: %v\n", configDir, err)
+				os.Exit(1)
+			}
+			configDir = absConfigDir
+			systemdService := service.SystemdService{
+				Service: service.Service{
+					ConfigDir: configDir,
+					Shutdown:  make(chan bool),
+				},
+				User:    serviceUser,
+				Group:   serviceGroup,
+				UnitDir: serviceUnitDir,
+			}
+			serviceArgs := []string{"serve"}
+			customFlags := getCustomServeFlags()
+			if len(customFlags) > 0 {
+				serviceArgs = append(serviceArgs, customFlags...)
+			}
+			err = systemdService.Install(serviceArgs...)
+			if err != nil {
+				fmt.Printf("Error installing service: %v\n", err)
+				os.Exit(1)
+			} else {
+				fmt.Printf("Service installed!\n")
+			}
+		},
+	}
+)
+
+func init() {
+	serviceCmd.AddCommand(installCmd)
+	addServeFlags(installCmd)
+	installCmd.Flags().StringVar(&serviceUser, "user", "", `User to run the service as.
+Leave empty to run as root`)
+	installCmd.Flags().StringVar(&serviceGroup, "group", "", `Group to run the service as.
+Leave empty to use the user's primary group`)
+	installCmd.Flags().StringVar(&serviceUnitDir, "unit-dir", "/etc/systemd/system", `Directory to write the systemd
+unit file to`)
+}
@@ -0,0 +1,33 @@
+// +build linux
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallFlags(t *testing.T) {
+	for _, name := range []string{"user", "group", "unit-dir", configDirFlag, logToJournalDFlag} {
+		assert.NotNil(t, installCmd.Flags().Lookup(name), name)
+	}
+	assert.Equal(t, "/etc/systemd/system", installCmd.Flags().Lookup("unit-dir").DefValue)
+}
+
+func TestCustomServeFlags(t *testing.T) {
+	assert.Empty(t, getCustomServeFlags())
+
+	configFile = "sftpgo.yaml"
+	logMaxSize = 20
+	logVerbose = false
+	logToJournalD = true
+	defer func() {
+		configFile = defaultConfigFile
+		logMaxSize = defaultLogMaxSize
+		logVerbose = defaultLogVerbose
+		logToJournalD = defaultLogToJournalD
+	}()
+	assert.Equal(t, []string{"--" + configFileFlag, "sftpgo.yaml", "--" + logMaxSizeFlag, "20",
+		"--" + logVerboseFlag + "=false", "--" + logToJournalDFlag + "=true"}, getCustomServeFlags())
+}
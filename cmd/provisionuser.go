@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/config"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+var (
+	provisionUserFile string
+	provisionUserCmd  = &cobra.Command{
+		Use:   "provisionuser",
+		Short: "Create or update a user from a JSON file",
+		Long: `This command creates or updates the user defined in a JSON file.
+The user is saved directly in the configured data provider, so SFTPGo does not
+need to be running.
+
+The JSON file contains a user in the same format used by the REST API. If a user
+with the same username already exists it is replaced with the given one, as for
+the data restore, otherwise a new user is added. The password can be in plain
+text or already hashed.
+
+Usage example:
+
+$ sftpgo provisionuser --user user.json
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			content, err := ioutil.ReadFile(provisionUserFile)
+			if err != nil {
+				logger.ErrorToConsole("unable to read the user to provision: %v", err)
+				os.Exit(1)
+			}
+			var user dataprovider.User
+			if err := json.Unmarshal(content, &user); err != nil {
+				logger.ErrorToConsole("unable to parse the user to provision: %v", err)
+				os.Exit(1)
+			}
+			configDir = utils.CleanDirInput(configDir)
+			err = config.LoadConfig(configDir, configFile)
+			if err != nil {
+				logger.ErrorToConsole("Unable to load configuration: %v", err)
+				os.Exit(1)
+			}
+			kmsConfig := config.GetKMSConfig()
+			err = kmsConfig.Initialize()
+			if err != nil {
+				logger.ErrorToConsole("unable to initialize KMS: %v", err)
+				os.Exit(1)
+			}
+			providerConf := config.GetProviderConf()
+			if providerConf.Driver == dataprovider.MemoryDataProviderName {
+				logger.ErrorToConsole("the memory provider is not supported, the user will be lost on exit")
+				os.Exit(1)
+			}
+			err = dataprovider.Initialize(providerConf, configDir, false)
+			if err != nil {
+				logger.ErrorToConsole("unable to initialize the data provider: %v", err)
+				os.Exit(1)
+			}
+			user.SetEmptySecretsIfNil()
+			existingUser, err := dataprovider.UserExists(user.Username)
+			if err == nil {
+				user.ID = existingUser.ID
+				if err := dataprovider.UpdateUser(&user); err != nil {
+					logger.ErrorToConsole("unable to update user %#v: %v", user.Username, err)
+					os.Exit(1)
+				}
+				logger.InfoToConsole("user %#v updated", user.Username)
+				return
+			}
+			if _, ok := err.(*dataprovider.RecordNotFoundError); !ok {
+				logger.ErrorToConsole("unable to check if user %#v exists: %v", user.Username, err)
+				os.Exit(1)
+			}
+			if err := dataprovider.AddUser(&user); err != nil {
+				logger.ErrorToConsole("unable to add user %#v: %v", user.Username, err)
+				os.Exit(1)
+			}
+			logger.InfoToConsole("user %#v added", user.Username)
+		},
+	}
+)
+
+func init() {
+	addConfigFlags(provisionUserCmd)
+	provisionUserCmd.Flags().StringVar(&provisionUserFile, "user", "", `Path to the JSON file with the user to
+create or update`)
+	provisionUserCmd.MarkFlagRequired("user") //nolint:errcheck
+
+	rootCmd.AddCommand(provisionUserCmd)
+}
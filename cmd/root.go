@@ -4,10 +4,13 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/version"
 )
 
@@ -28,6 +31,16 @@ const (
 	logCompressKey           = "log_compress"
 	logVerboseFlag           = "log-verbose"
 	logVerboseKey            = "log_verbose"
+	logSyslogURLFlag         = "log-syslog-url"
+	logSyslogURLKey          = "log_syslog_url"
+	logSyslogFacilityFlag    = "log-syslog-facility"
+	logSyslogFacilityKey     = "log_syslog_facility"
+	logSyslogTagFlag         = "log-syslog-tag"
+	logSyslogTagKey          = "log_syslog_tag"
+	logToJournalDFlag        = "log-to-journald"
+	logToJournalDKey         = "log_to_journald"
+	logModuleLevelsFlag      = "log-levels"
+	logModuleLevelsKey       = "log_levels"
 	loadDataFromFlag         = "loaddata-from"
 	loadDataFromKey          = "loaddata_from"
 	loadDataModeFlag         = "loaddata-mode"
@@ -44,6 +57,11 @@ const (
 	defaultLogMaxAge         = 28
 	defaultLogCompress       = false
 	defaultLogVerbose        = true
+	defaultLogSyslogURL      = ""
+	defaultLogSyslogFacility = logger.DefaultSyslogFacility
+	defaultLogSyslogTag      = logger.DefaultSyslogTag
+	defaultLogToJournalD     = false
+	defaultLogModuleLevels   = ""
 	defaultLoadDataFrom      = ""
 	defaultLoadDataMode      = 1
 	defaultLoadDataQuotaScan = 0
@@ -59,6 +77,11 @@ var (
 	logMaxAge         int
 	logCompress       bool
 	logVerbose        bool
+	logSyslogURL      string
+	logSyslogFacility string
+	logSyslogTag      string
+	logToJournalD     bool
+	logModuleLevels   string
 	loadDataFrom      string
 	loadDataMode      int
 	loadDataQuotaScan int
@@ -179,6 +202,63 @@ using SFTPGO_LOG_VERBOSE env var too.
 `)
 	viper.BindPFlag(logVerboseKey, cmd.Flags().Lookup(logVerboseFlag)) //nolint:errcheck
 
+	viper.SetDefault(logSyslogURLKey, defaultLogSyslogURL)
+	viper.BindEnv(logSyslogURLKey, "SFTPGO_LOG_SYSLOG_URL") //nolint:errcheck
+	cmd.Flags().StringVar(&logSyslogURL, logSyslogURLFlag, viper.GetString(logSyslogURLKey),
+		`Send logs to this syslog server, using the
+RFC 5424 format, instead of writing them to
+the log file. The supported schemes are "udp",
+"tcp" and "tls", for example:
+"udp://127.0.0.1:514". This flag can be set
+using SFTPGO_LOG_SYSLOG_URL env var too.
+`)
+	viper.BindPFlag(logSyslogURLKey, cmd.Flags().Lookup(logSyslogURLFlag)) //nolint:errcheck
+
+	viper.SetDefault(logSyslogFacilityKey, defaultLogSyslogFacility)
+	viper.BindEnv(logSyslogFacilityKey, "SFTPGO_LOG_SYSLOG_FACILITY") //nolint:errcheck
+	cmd.Flags().StringVar(&logSyslogFacility, logSyslogFacilityFlag, viper.GetString(logSyslogFacilityKey),
+		`Syslog facility, for example "daemon" or
+"local0". This flag can be set using
+SFTPGO_LOG_SYSLOG_FACILITY env var too. It is
+unused if log-syslog-url is empty.`)
+	viper.BindPFlag(logSyslogFacilityKey, cmd.Flags().Lookup(logSyslogFacilityFlag)) //nolint:errcheck
+
+	viper.SetDefault(logSyslogTagKey, defaultLogSyslogTag)
+	viper.BindEnv(logSyslogTagKey, "SFTPGO_LOG_SYSLOG_TAG") //nolint:errcheck
+	cmd.Flags().StringVar(&logSyslogTag, logSyslogTagFlag, viper.GetString(logSyslogTagKey),
+		`Syslog tag, it is sent as APP-NAME. This flag
+can be set using SFTPGO_LOG_SYSLOG_TAG env var
+too. It is unused if log-syslog-url is empty.`)
+	viper.BindPFlag(logSyslogTagKey, cmd.Flags().Lookup(logSyslogTagFlag)) //nolint:errcheck
+
+	viper.SetDefault(logToJournalDKey, defaultLogToJournalD)
+	viper.BindEnv(logToJournalDKey, "SFTPGO_LOG_TO_JOURNALD") //nolint:errcheck
+	cmd.Flags().BoolVar(&logToJournalD, logToJournalDFlag, viper.GetBool(logToJournalDKey),
+		`Send logs to journald instead of writing
+them to the log file. The log fields are sent
+as journal fields so they can be used to filter
+the logs using journalctl, for example:
+$ journalctl -u sftpgo USERNAME=user
+Only available on Linux. This flag can be set
+using SFTPGO_LOG_TO_JOURNALD env var too.
+`)
+	viper.BindPFlag(logToJournalDKey, cmd.Flags().Lookup(logToJournalDFlag)) //nolint:errcheck
+
+	viper.SetDefault(logModuleLevelsKey, defaultLogModuleLevels)
+	viper.BindEnv(logModuleLevelsKey, "SFTPGO_LOG_LEVELS") //nolint:errcheck
+	cmd.Flags().StringVar(&logModuleLevels, logModuleLevelsFlag, viper.GetString(logModuleLevelsKey),
+		`Comma separated list of module=level pairs
+to override the log level for specific modules,
+for example: "sftpd=debug,dataprovider=warn".
+The supported modules are "sftpd", "ftpd",
+"webdavd", "httpd" and "dataprovider", the
+supported levels are "debug", "info", "warn"
+and "error". The modules not included use the
+log level defined by log-verbose. This flag
+can be set using SFTPGO_LOG_LEVELS env var too.
+`)
+	viper.BindPFlag(logModuleLevelsKey, cmd.Flags().Lookup(logModuleLevelsFlag)) //nolint:errcheck
+
 	viper.SetDefault(loadDataFromKey, defaultLoadDataFrom)
 	viper.BindEnv(loadDataFromKey, "SFTPGO_LOADDATA_FROM") //nolint:errcheck
 	cmd.Flags().StringVar(&loadDataFrom, loadDataFromFlag, viper.GetString(loadDataFromKey),
@@ -199,6 +279,10 @@ env var too.
       updated
   1 - New users are added, existing users are
 	  not modified
+  3 - new users are added, existing users are
+      updated, users, folders, admins and API
+      keys not included in the data to load are
+      removed
 This flag can be set using SFTPGO_LOADDATA_MODE
 env var too.
 `)
@@ -226,3 +310,58 @@ too. (default "false")
 `)
 	viper.BindPFlag(logCompressKey, cmd.Flags().Lookup(logCompressFlag)) //nolint:errcheck
 }
+
+func getCustomServeFlags() []string {
+	result := []string{}
+	if configDir != defaultConfigDir {
+		configDir = utils.CleanDirInput(configDir)
+		result = append(result, "--"+configDirFlag)
+		result = append(result, configDir)
+	}
+	if configFile != defaultConfigFile {
+		result = append(result, "--"+configFileFlag)
+		result = append(result, configFile)
+	}
+	if logFilePath != defaultLogFile {
+		result = append(result, "--"+logFilePathFlag)
+		result = append(result, logFilePath)
+	}
+	if logMaxSize != defaultLogMaxSize {
+		result = append(result, "--"+logMaxSizeFlag)
+		result = append(result, strconv.Itoa(logMaxSize))
+	}
+	if logMaxBackups != defaultLogMaxBackup {
+		result = append(result, "--"+logMaxBackupFlag)
+		result = append(result, strconv.Itoa(logMaxBackups))
+	}
+	if logMaxAge != defaultLogMaxAge {
+		result = append(result, "--"+logMaxAgeFlag)
+		result = append(result, strconv.Itoa(logMaxAge))
+	}
+	if logVerbose != defaultLogVerbose {
+		result = append(result, "--"+logVerboseFlag+"=false")
+	}
+	if logCompress != defaultLogCompress {
+		result = append(result, "--"+logCompressFlag+"=true")
+	}
+	if logSyslogURL != defaultLogSyslogURL {
+		result = append(result, "--"+logSyslogURLFlag)
+		result = append(result, logSyslogURL)
+	}
+	if logSyslogFacility != defaultLogSyslogFacility {
+		result = append(result, "--"+logSyslogFacilityFlag)
+		result = append(result, logSyslogFacility)
+	}
+	if logSyslogTag != defaultLogSyslogTag {
+		result = append(result, "--"+logSyslogTagFlag)
+		result = append(result, logSyslogTag)
+	}
+	if logToJournalD != defaultLogToJournalD {
+		result = append(result, "--"+logToJournalDFlag+"=true")
+	}
+	if logModuleLevels != defaultLogModuleLevels {
+		result = append(result, "--"+logModuleLevelsFlag)
+		result = append(result, logModuleLevels)
+	}
+	return result
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/config"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+var (
+	rotateKMSOldURL           string
+	rotateKMSOldMasterKey     string
+	rotateKMSOldMasterKeyPath string
+	rotateKMSCmd              = &cobra.Command{
+		Use:   "rotatekms",
+		Short: "Re-encrypt the stored secrets using the configured KMS",
+		Long: `This command decrypts the secrets stored inside the data provider, such as
+Cloud Storage credentials, using the old KMS settings specified via the command
+line flags and encrypts them using the KMS settings defined in the configuration
+file. This way you can rotate the master key or switch to a different KMS.
+
+All the secrets are decrypted before saving any change, nothing is modified if a
+secret cannot be decrypted using the old settings. Only the re-encrypted secrets
+are saved: users and admins are not validated again and the configured actions,
+hooks and webhooks are not executed. The SQL and bolt data providers save all the
+secrets inside a single transaction. The REST data provider updates the remote
+users one at a time and the GCS credentials stored in files are saved after
+updating the data provider. The secrets already encrypted using the new settings
+are skipped, so the command can be safely executed again if it is interrupted.
+
+SFTPGo should be stopped while this command is running, the secrets updated
+concurrently could be lost.
+
+Usage example, rotate the master key for the local provider:
+
+$ sftpgo rotatekms --old-master-key-path /etc/sftpgo/old_master_key
+
+the new master key is read from the configuration file or from the
+SFTPGO_KMS__SECRETS__MASTER_KEY_PATH/SFTPGO_KMS__SECRETS__MASTER_KEY
+environment variables.
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			configDir = utils.CleanDirInput(configDir)
+			err := config.LoadConfig(configDir, configFile)
+			if err != nil {
+				logger.ErrorToConsole("Unable to load configuration: %v", err)
+				os.Exit(1)
+			}
+			oldKMSConfig := kms.Configuration{
+				Secrets: kms.Secrets{
+					URL:             rotateKMSOldURL,
+					MasterKeyString: rotateKMSOldMasterKey,
+					MasterKeyPath:   rotateKMSOldMasterKeyPath,
+				},
+			}
+			// validate the old KMS settings
+			err = oldKMSConfig.Initialize()
+			if err != nil {
+				logger.ErrorToConsole("unable to initialize the old KMS: %v", err)
+				os.Exit(1)
+			}
+			kmsConfig := config.GetKMSConfig()
+			err = kmsConfig.Initialize()
+			if err != nil {
+				logger.ErrorToConsole("unable to initialize KMS: %v", err)
+				os.Exit(1)
+			}
+			providerConf := config.GetProviderConf()
+			if providerConf.Driver == dataprovider.MemoryDataProviderName {
+				logger.ErrorToConsole("the memory provider is not supported, the secrets will be lost on exit")
+				os.Exit(1)
+			}
+			err = dataprovider.Initialize(providerConf, configDir, false)
+			if err != nil {
+				logger.ErrorToConsole("unable to initialize the data provider: %v", err)
+				os.Exit(1)
+			}
+			numUsers, numAdmins, err := dataprovider.ReencryptSecrets(oldKMSConfig, kmsConfig)
+			if err != nil {
+				logger.ErrorToConsole("unable to re-encrypt the secrets, updated users: %v, updated admins: %v: %v",
+					numUsers, numAdmins, err)
+				os.Exit(1)
+			}
+			logger.InfoToConsole("secrets re-encrypted, updated users: %v, updated admins: %v", numUsers, numAdmins)
+		},
+	}
+)
+
+func init() {
+	addConfigFlags(rotateKMSCmd)
+	rotateKMSCmd.Flags().StringVar(&rotateKMSOldURL, "old-url", "", `KMS URL used to encrypt the stored secrets.
+Empty means the local provider`)
+	rotateKMSCmd.Flags().StringVar(&rotateKMSOldMasterKeyPath, "old-master-key-path", "", `Path to the file containing the master key
+used to encrypt the stored secrets`)
+	rotateKMSCmd.Flags().StringVar(&rotateKMSOldMasterKey, "old-master-key", "", `Master key used to encrypt the stored
+secrets. It is ignored if
+"--old-master-key-path" is set`)
+
+	rootCmd.AddCommand(rotateKMSCmd)
+}
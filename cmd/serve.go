@@ -29,6 +29,11 @@ Please take a look at the usage below to customize the startup options`,
 				LogMaxAge:         logMaxAge,
 				LogCompress:       logCompress,
 				LogVerbose:        logVerbose,
+				LogSyslogURL:      logSyslogURL,
+				LogSyslogFacility: logSyslogFacility,
+				LogSyslogTag:      logSyslogTag,
+				LogToJournalD:     logToJournalD,
+				LogModuleLevels:   logModuleLevels,
 				LoadDataFrom:      loadDataFrom,
 				LoadDataMode:      loadDataMode,
 				LoadDataQuotaScan: loadDataQuotaScan,
@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var (
+	serviceCmd = &cobra.Command{
+		Use:   "service",
+		Short: "Manage SFTPGo systemd service",
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(serviceCmd)
+}
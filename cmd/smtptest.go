@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/drakkan/sftpgo/config"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/smtp"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/version"
+)
+
+var (
+	smtpTestRecipient string
+	smtpTestCmd       = &cobra.Command{
+		Use:   "smtptest",
+		Short: "Test the SMTP configuration",
+		Long: `This command reads the SMTP configuration from the specified configuration
+file and sends a test email to the specified recipient.
+If the email cannot be sent the error is reported together with a hint, for
+example to distinguish TLS and authentication errors.
+
+To send a test email using the configuration from the configuration directory
+simply use:
+
+$ sftpgo smtptest --recipient admin@example.com
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			configDir = utils.CleanDirInput(configDir)
+			err := config.LoadConfig(configDir, configFile)
+			if err != nil {
+				logger.WarnToConsole("Unable to load configuration: %v", err)
+				os.Exit(1)
+			}
+			smtpConfig := config.GetSMTPConfig()
+			err = smtpConfig.Initialize()
+			if err != nil {
+				logger.ErrorToConsole("Invalid SMTP configuration: %v", err)
+				os.Exit(1)
+			}
+			if !smtp.IsEnabled() {
+				logger.ErrorToConsole("No SMTP server configured, config file: %#v", viper.ConfigFileUsed())
+				os.Exit(1)
+			}
+			logger.InfoToConsole("Sending test email to %#v using the SMTP server %v:%v, config file: %#v",
+				smtpTestRecipient, smtpConfig.Host, smtpConfig.Port, viper.ConfigFileUsed())
+			hostname, _ := os.Hostname()
+			body := fmt.Sprintf("This is a test email sent from SFTPGo %v running on %#v.\n"+
+				"If you received this message your SMTP configuration is working.\n", version.Get().Version, hostname)
+			err = smtp.SendEmail([]string{smtpTestRecipient}, "SFTPGo test email", body, smtp.EmailContentTypeTextPlain)
+			if err != nil {
+				logger.ErrorToConsole("Unable to send the test email: %v", err)
+				if hint := smtp.GetErrorHint(err); hint != "" {
+					logger.ErrorToConsole("%v", hint)
+				}
+				os.Exit(1)
+			}
+			logger.InfoToConsole("Test email successfully sent")
+		},
+	}
+)
+
+func init() {
+	addConfigFlags(smtpTestCmd)
+	smtpTestCmd.Flags().StringVar(&smtpTestRecipient, "recipient", "", "Email address to send the test email to")
+	smtpTestCmd.MarkFlagRequired("recipient") //nolint:errcheck
+
+	rootCmd.AddCommand(smtpTestCmd)
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/service"
+)
+
+var (
+	startCmd = &cobra.Command{
+		Use:   "start",
+		Short: "Start SFTPGo systemd service",
+		Run: func(cmd *cobra.Command, args []string) {
+			s := service.SystemdService{
+				Service: service.Service{
+					Shutdown: make(chan bool),
+				},
+			}
+			err := s.Start()
+			if err != nil {
+				fmt.Printf("Error starting service: %v\n", err)
+				os.Exit(1)
+			} else {
+				fmt.Printf("Service started!\n")
+			}
+		},
+	}
+)
+
+func init() {
+	serviceCmd.AddCommand(startCmd)
+}
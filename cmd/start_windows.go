@@ -21,15 +21,19 @@ var (
 				logFilePath = filepath.Join(configDir, logFilePath)
 			}
 			s := service.Service{
-				ConfigDir:     configDir,
-				ConfigFile:    configFile,
-				LogFilePath:   logFilePath,
-				LogMaxSize:    logMaxSize,
-				LogMaxBackups: logMaxBackups,
-				LogMaxAge:     logMaxAge,
-				LogCompress:   logCompress,
-				LogVerbose:    logVerbose,
-				Shutdown:      make(chan bool),
+				ConfigDir:         configDir,
+				ConfigFile:        configFile,
+				LogFilePath:       logFilePath,
+				LogMaxSize:        logMaxSize,
+				LogMaxBackups:     logMaxBackups,
+				LogMaxAge:         logMaxAge,
+				LogCompress:       logCompress,
+				LogVerbose:        logVerbose,
+				LogSyslogURL:      logSyslogURL,
+				LogSyslogFacility: logSyslogFacility,
+				LogSyslogTag:      logSyslogTag,
+				LogModuleLevels:   logModuleLevels,
+				Shutdown:          make(chan bool),
 			}
 			winService := service.WindowsService{
 				Service: s,
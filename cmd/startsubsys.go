@@ -66,6 +66,16 @@ Command-line flags should be specified in the Subsystem declaration.
 			// idle connection are managed externally
 			commonConfig.IdleTimeout = 0
 			config.SetCommonConfig(commonConfig)
+			smtpConfig := config.GetSMTPConfig()
+			if err := smtpConfig.Initialize(); err != nil {
+				logger.Error(logSender, connectionID, "unable to initialize SMTP configuration: %v", err)
+				os.Exit(1)
+			}
+			geoIPConfig := config.GetGeoIPConfig()
+			if err := geoIPConfig.Initialize(configDir); err != nil {
+				logger.Error(logSender, connectionID, "unable to initialize GeoIP: %v", err)
+				os.Exit(1)
+			}
 			if err := common.Initialize(config.GetCommonConfig()); err != nil {
 				logger.Error(logSender, connectionID, "%v", err)
 				os.Exit(1)
@@ -75,6 +85,11 @@ Command-line flags should be specified in the Subsystem declaration.
 				logger.Error(logSender, connectionID, "unable to initialize KMS: %v", err)
 				os.Exit(1)
 			}
+			httpConfig := config.GetHTTPConfig()
+			if err := httpConfig.Initialize(configDir); err != nil {
+				logger.Error(logSender, connectionID, "unable to initialize http client: %v", err)
+				os.Exit(1)
+			}
 			dataProviderConf := config.GetProviderConf()
 			if dataProviderConf.Driver == dataprovider.SQLiteDataProviderName || dataProviderConf.Driver == dataprovider.BoltDataProviderName {
 				logger.Debug(logSender, connectionID, "data provider %#v not supported in subsystem mode, using %#v provider",
@@ -89,11 +104,6 @@ Command-line flags should be specified in the Subsystem declaration.
 				logger.Error(logSender, connectionID, "unable to initialize the data provider: %v", err)
 				os.Exit(1)
 			}
-			httpConfig := config.GetHTTPConfig()
-			if err := httpConfig.Initialize(configDir); err != nil {
-				logger.Error(logSender, connectionID, "unable to initialize http client: %v", err)
-				os.Exit(1)
-			}
 			user, err := dataprovider.UserExists(username)
 			if err == nil {
 				if user.HomeDir != filepath.Clean(homedir) && !preserveHomeDir {
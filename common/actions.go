@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/diagnostics"
 	"github.com/drakkan/sftpgo/httpclient"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/utils"
@@ -32,6 +33,12 @@ type ProtocolActions struct {
 	ExecuteOn []string `json:"execute_on" mapstructure:"execute_on"`
 	// Absolute path to an external program or an HTTP URL
 	Hook string `json:"hook" mapstructure:"hook"`
+	// Persistent queue for the notifications to an HTTP hook
+	Queue ActionsQueueConfig `json:"queue" mapstructure:"queue"`
+	// Message brokers where the notifications are published, in addition to the hook
+	Brokers []BrokerConfig `json:"brokers" mapstructure:"brokers"`
+	// Email notifications, they are sent, in addition to the hook, using the SMTP configuration
+	Emails []ActionEmailConfig `json:"emails" mapstructure:"emails"`
 }
 
 var actionHandler ActionHandler = &defaultActionHandler{}
@@ -68,6 +75,8 @@ type ActionNotification struct {
 	Endpoint   string `json:"endpoint,omitempty"`
 	Status     int    `json:"status"`
 	Protocol   string `json:"protocol"`
+	UserInfo   string `json:"user_info,omitempty"`
+	Checksum   string `json:"checksum,omitempty"`
 }
 
 func newActionNotification(
@@ -93,7 +102,7 @@ func newActionNotification(
 		}
 	}
 
-	if err == ErrQuotaExceeded {
+	if err == ErrQuotaExceeded || err == ErrReadQuotaExceeded {
 		status = 2
 	} else if err != nil {
 		status = 0
@@ -111,6 +120,7 @@ func newActionNotification(
 		Endpoint:   endpoint,
 		Status:     status,
 		Protocol:   protocol,
+		UserInfo:   user.AdditionalInfo,
 	}
 }
 
@@ -121,23 +131,60 @@ func (h *defaultActionHandler) Handle(notification *ActionNotification) error {
 		return errUnconfiguredAction
 	}
 
-	if Config.Actions.Hook == "" {
+	// pre-delete notifications are not published to the brokers nor sent by email,
+	// they are used to decide if a file must be deleted
+	var notifiersErr error
+	hasNotifiers := notification.Action != operationPreDelete &&
+		(len(Config.brokers) > 0 || len(Config.actionEmails) > 0)
+	if hasNotifiers {
+		notifiersErr = h.handleNotifiers(notification)
+	}
+
+	hook := Config.getHooks().actions
+	if hook == "" {
+		if hasNotifiers {
+			return notifiersErr
+		}
 		logger.Warn(notification.Protocol, "", "Unable to send notification, no hook is defined")
 
 		return errNoHook
 	}
 
-	if strings.HasPrefix(Config.Actions.Hook, "http") {
-		return h.handleHTTP(notification)
+	if Config.Actions.isQueueable(notification) {
+		if err := enqueueNotification(notification); err == nil {
+			return nil
+		}
+		// the notification cannot be queued, try to deliver it now
+	}
+
+	if strings.HasPrefix(hook, "http") {
+		return h.handleHTTP(hook, notification)
 	}
 
-	return h.handleCommand(notification)
+	return h.handleCommand(hook, notification)
+}
+
+func (h *defaultActionHandler) handleNotifiers(notification *ActionNotification) error {
+	var result error
+	if len(Config.brokers) > 0 {
+		payload, err := json.Marshal(notification)
+		if err != nil {
+			return err
+		}
+		if err := publishToBrokers(notification, payload); err != nil {
+			result = err
+		}
+	}
+	if err := sendActionEmails(notification); err != nil {
+		result = err
+	}
+	return result
 }
 
-func (h *defaultActionHandler) handleHTTP(notification *ActionNotification) error {
-	u, err := url.Parse(Config.Actions.Hook)
+func (h *defaultActionHandler) handleHTTP(hook string, notification *ActionNotification) error {
+	u, err := url.Parse(hook)
 	if err != nil {
-		logger.Warn(notification.Protocol, "", "Invalid hook %#v for operation %#v: %v", Config.Actions.Hook, notification.Action, err)
+		logger.Warn(notification.Protocol, "", "Invalid hook %#v for operation %#v: %v", hook, notification.Action, err)
 
 		return err
 	}
@@ -160,14 +207,15 @@ func (h *defaultActionHandler) handleHTTP(notification *ActionNotification) erro
 		}
 	}
 
+	diagnostics.HookExecuted(diagnostics.HookActions, time.Since(startTime), err)
 	logger.Debug(notification.Protocol, "", "notified operation %#v to URL: %v status code: %v, elapsed: %v err: %v", notification.Action, u.String(), respCode, time.Since(startTime), err)
 
 	return err
 }
 
-func (h *defaultActionHandler) handleCommand(notification *ActionNotification) error {
-	if !filepath.IsAbs(Config.Actions.Hook) {
-		err := fmt.Errorf("invalid notification command %#v", Config.Actions.Hook)
+func (h *defaultActionHandler) handleCommand(hook string, notification *ActionNotification) error {
+	if !filepath.IsAbs(hook) {
+		err := fmt.Errorf("invalid notification command %#v", hook)
 		logger.Warn(notification.Protocol, "", "unable to execute notification command: %v", err)
 
 		return err
@@ -176,14 +224,15 @@ func (h *defaultActionHandler) handleCommand(notification *ActionNotification) e
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, Config.Actions.Hook, notification.Action, notification.Username, notification.Path, notification.TargetPath, notification.SSHCmd)
+	cmd := exec.CommandContext(ctx, hook, notification.Action, notification.Username, notification.Path, notification.TargetPath, notification.SSHCmd)
 	cmd.Env = append(os.Environ(), notificationAsEnvVars(notification)...)
 
 	startTime := time.Now()
 	err := cmd.Run()
+	diagnostics.HookExecuted(diagnostics.HookActions, time.Since(startTime), err)
 
 	logger.Debug(notification.Protocol, "", "executed command %#v with arguments: %#v, %#v, %#v, %#v, %#v, elapsed: %v, error: %v",
-		Config.Actions.Hook, notification.Action, notification.Username, notification.Path, notification.TargetPath, notification.SSHCmd, time.Since(startTime), err)
+		hook, notification.Action, notification.Username, notification.Path, notification.TargetPath, notification.SSHCmd, time.Since(startTime), err)
 
 	return err
 }
@@ -201,5 +250,7 @@ func notificationAsEnvVars(notification *ActionNotification) []string {
 		fmt.Sprintf("SFTPGO_ACTION_ENDPOINT=%v", notification.Endpoint),
 		fmt.Sprintf("SFTPGO_ACTION_STATUS=%v", notification.Status),
 		fmt.Sprintf("SFTPGO_ACTION_PROTOCOL=%v", notification.Protocol),
+		fmt.Sprintf("SFTPGO_ACTION_USER_INFO=%v", notification.UserInfo),
+		fmt.Sprintf("SFTPGO_ACTION_CHECKSUM=%v", notification.Checksum),
 	}
 }
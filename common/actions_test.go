@@ -33,8 +33,10 @@ func TestNewActionNotification(t *testing.T) {
 		SASURL:    "azsasurl",
 		Endpoint:  "azendpoint",
 	}
+	user.AdditionalInfo = "external id"
 	a := newActionNotification(user, operationDownload, "path", "target", "", ProtocolSFTP, 123, errors.New("fake error"))
 	assert.Equal(t, user.Username, a.Username)
+	assert.Equal(t, user.AdditionalInfo, a.UserInfo)
 	assert.Equal(t, 0, len(a.Bucket))
 	assert.Equal(t, 0, len(a.Endpoint))
 	assert.Equal(t, 0, a.Status)
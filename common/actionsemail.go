@@ -0,0 +1,121 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"text/template"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/smtp"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	defaultActionEmailSubject = `SFTPGo {{.Action}} notification for user "{{.Username}}"`
+	defaultActionEmailBody    = `Action: {{.Action}}
+Username: {{.Username}}
+Protocol: {{.Protocol}}
+Path: {{.Path}}{{if .TargetPath}}
+Target path: {{.TargetPath}}{{end}}{{if .SSHCmd}}
+SSH command: {{.SSHCmd}}{{end}}{{if .FileSize}}
+File size: {{.FileSize}}{{end}}{{if .Checksum}}
+SHA-256: {{.Checksum}}{{end}}
+Status: {{.Status}}
+`
+)
+
+// ActionEmailConfig defines an email notification for the file actions.
+// Subject and body are Go text templates, the action notification fields,
+// for example {{.Username}} and {{.Path}}, are available inside them
+type ActionEmailConfig struct {
+	// Actions to notify. They must also be included in the global execute_on
+	// list, leave empty to notify all of them
+	ExecuteOn []string `json:"execute_on" mapstructure:"execute_on"`
+	// Usernames to notify, leave empty to notify all the users
+	Usernames []string `json:"usernames" mapstructure:"usernames"`
+	// Email addresses to notify
+	Recipients []string `json:"recipients" mapstructure:"recipients"`
+	// Subject template, leave empty to use the default one
+	Subject string `json:"subject" mapstructure:"subject"`
+	// Body template, leave empty to use the default one
+	Body string `json:"body" mapstructure:"body"`
+}
+
+type actionEmail struct {
+	config  ActionEmailConfig
+	subject *template.Template
+	body    *template.Template
+}
+
+func newActionEmail(c ActionEmailConfig) (*actionEmail, error) {
+	if !smtp.IsEnabled() {
+		return nil, errors.New("an SMTP server must be configured to send email notifications")
+	}
+	if len(c.Recipients) == 0 {
+		return nil, errors.New("at least a recipient is required")
+	}
+	subject := c.Subject
+	if subject == "" {
+		subject = defaultActionEmailSubject
+	}
+	body := c.Body
+	if body == "" {
+		body = defaultActionEmailBody
+	}
+	subjectTmpl, err := template.New("subject").Parse(subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject template: %w", err)
+	}
+	bodyTmpl, err := template.New("body").Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+	return &actionEmail{
+		config:  c,
+		subject: subjectTmpl,
+		body:    bodyTmpl,
+	}, nil
+}
+
+func (e *actionEmail) isApplicable(notification *ActionNotification) bool {
+	if len(e.config.ExecuteOn) > 0 && !utils.IsStringInSlice(notification.Action, e.config.ExecuteOn) {
+		return false
+	}
+	if len(e.config.Usernames) > 0 && !utils.IsStringInSlice(notification.Username, e.config.Usernames) {
+		return false
+	}
+	return true
+}
+
+func (e *actionEmail) send(notification *ActionNotification) error {
+	var subject, body bytes.Buffer
+
+	if err := e.subject.Execute(&subject, notification); err != nil {
+		return fmt.Errorf("unable to render email subject: %w", err)
+	}
+	if err := e.body.Execute(&body, notification); err != nil {
+		return fmt.Errorf("unable to render email body: %w", err)
+	}
+	return smtp.SendEmail(e.config.Recipients, subject.String(), body.String(), smtp.EmailContentTypeTextPlain)
+}
+
+// sendActionEmails sends the configured emails for the given notification.
+// The last send error, if any, is returned
+func sendActionEmails(notification *ActionNotification) error {
+	var result error
+	for idx, email := range Config.actionEmails {
+		if !email.isApplicable(notification) {
+			continue
+		}
+		err := email.send(notification)
+		logger.Debug(notification.Protocol, "", "email notification %v for operation %#v sent to %v, err: %v",
+			idx, notification.Action, email.config.Recipients, err)
+		if err != nil {
+			logger.Warn(notification.Protocol, "", "unable to send email notification %v for operation %#v: %v",
+				idx, notification.Action, err)
+			result = err
+		}
+	}
+	return result
+}
@@ -0,0 +1,147 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/smtp"
+)
+
+func initTestSMTP(t *testing.T) {
+	// no SMTP server is listening on this port, emails cannot be sent
+	smtpCfg := smtp.Config{
+		Host: "127.0.0.1",
+		Port: 3525,
+		From: "notification@example.com",
+	}
+	err := smtpCfg.Initialize()
+	require.NoError(t, err)
+}
+
+func resetTestSMTP(t *testing.T) {
+	smtpCfg := smtp.Config{}
+	err := smtpCfg.Initialize()
+	require.NoError(t, err)
+}
+
+func TestActionEmailConfig(t *testing.T) {
+	_, err := newActionEmail(ActionEmailConfig{
+		Recipients: []string{"a@example.com"},
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "SMTP server must be configured")
+	}
+
+	initTestSMTP(t)
+	defer resetTestSMTP(t)
+
+	_, err = newActionEmail(ActionEmailConfig{})
+	assert.Error(t, err)
+	_, err = newActionEmail(ActionEmailConfig{
+		Recipients: []string{"a@example.com"},
+		Subject:    "{{.Username",
+	})
+	assert.Error(t, err)
+	_, err = newActionEmail(ActionEmailConfig{
+		Recipients: []string{"a@example.com"},
+		Body:       "{{if .Username}}",
+	})
+	assert.Error(t, err)
+
+	email, err := newActionEmail(ActionEmailConfig{
+		Recipients: []string{"a@example.com"},
+	})
+	require.NoError(t, err)
+	notification := &ActionNotification{
+		Action:   operationUpload,
+		Username: "partner",
+		Path:     "/file.csv",
+		Protocol: ProtocolSFTP,
+	}
+	assert.True(t, email.isApplicable(notification))
+
+	email.config.ExecuteOn = []string{operationDownload}
+	assert.False(t, email.isApplicable(notification))
+	email.config.ExecuteOn = []string{operationDownload, operationUpload}
+	assert.True(t, email.isApplicable(notification))
+	email.config.Usernames = []string{"user"}
+	assert.False(t, email.isApplicable(notification))
+	email.config.Usernames = []string{"user", "partner"}
+	assert.True(t, email.isApplicable(notification))
+
+	// the smtp server is not reachable
+	err = email.send(notification)
+	assert.Error(t, err)
+
+	email, err = newActionEmail(ActionEmailConfig{
+		Recipients: []string{"a@example.com"},
+		Subject:    "{{.Missing}}",
+	})
+	require.NoError(t, err)
+	err = email.send(notification)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "subject")
+
+	email, err = newActionEmail(ActionEmailConfig{
+		Recipients: []string{"a@example.com"},
+		Body:       "{{.Missing}}",
+	})
+	require.NoError(t, err)
+	err = email.send(notification)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "body")
+}
+
+func TestActionEmails(t *testing.T) {
+	configCopy := Config
+
+	c := Configuration{}
+	c.Actions.ExecuteOn = []string{operationUpload, operationPreDelete}
+	c.Actions.Emails = []ActionEmailConfig{
+		{
+			Recipients: []string{"a@example.com"},
+			Usernames:  []string{"partner"},
+		},
+		{},
+	}
+	err := Initialize(c)
+	assert.Error(t, err)
+
+	c.Actions.Emails = c.Actions.Emails[:1]
+	// smtp is not configured
+	err = Initialize(c)
+	assert.Error(t, err)
+
+	initTestSMTP(t)
+	defer resetTestSMTP(t)
+
+	err = Initialize(c)
+	require.NoError(t, err)
+	assert.Len(t, Config.actionEmails, 1)
+
+	notification := &ActionNotification{
+		Action:   operationUpload,
+		Username: "partner",
+		Path:     "/file.csv",
+		Protocol: ProtocolFTP,
+	}
+	// the smtp server is not reachable, the email cannot be sent
+	err = actionHandler.Handle(notification)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errNoHook)
+	// no email is configured for this user
+	notification.Username = "user"
+	err = actionHandler.Handle(notification)
+	assert.NoError(t, err)
+	// pre-delete notifications are not sent by email
+	notification.Action = operationPreDelete
+	err = actionHandler.Handle(notification)
+	assert.ErrorIs(t, err, errNoHook)
+
+	err = Initialize(configCopy)
+	require.NoError(t, err)
+	assert.Len(t, Config.actionEmails, 0)
+	Config = configCopy
+}
@@ -0,0 +1,176 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const actionsQueueBatchSize = 100
+
+var (
+	actionsQueueOnce sync.Once
+	actionsQueueWake = make(chan bool, 1)
+	// interval for checking the events ready for a new delivery attempt
+	actionsQueueCheckInterval = 10 * time.Second
+)
+
+// ActionsQueueConfig defines the configuration for the persistent queue used to deliver
+// the asynchronous action notifications to an HTTP hook. The notifications are stored
+// in the data provider and delivered in background, the failed deliveries are retried
+// with an exponential backoff
+type ActionsQueueConfig struct {
+	// Enable the persistent queue. It is used only if the hook is an HTTP URL
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Maximum number of delivery attempts for each notification, then the notification
+	// is discarded. 0 means retry until the notification is delivered
+	MaxAttempts int `json:"max_attempts" mapstructure:"max_attempts"`
+	// Delay, as seconds, before the first retry. It doubles after each failed attempt
+	BaseDelay int `json:"base_delay" mapstructure:"base_delay"`
+	// Maximum delay, as seconds, between two attempts
+	MaxDelay int `json:"max_delay" mapstructure:"max_delay"`
+}
+
+func (c *ActionsQueueConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.MaxAttempts < 0 {
+		return fmt.Errorf("invalid max_attempts %v", c.MaxAttempts)
+	}
+	if c.BaseDelay <= 0 {
+		return fmt.Errorf("invalid base_delay %v", c.BaseDelay)
+	}
+	if c.MaxDelay < c.BaseDelay {
+		return fmt.Errorf("invalid max_delay %v must be >= %v", c.MaxDelay, c.BaseDelay)
+	}
+	return nil
+}
+
+// getRetryDelay returns the delay before the next attempt after the given failed attempts
+func (c *ActionsQueueConfig) getRetryDelay(attempts int) time.Duration {
+	delay := time.Duration(c.BaseDelay) * time.Second
+	maxDelay := time.Duration(c.MaxDelay) * time.Second
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// isQueueable returns true if the notification must be delivered using the persistent queue.
+// pre-delete notifications are synchronous and they are never queued
+func (p *ProtocolActions) isQueueable(notification *ActionNotification) bool {
+	return p.Queue.Enabled && strings.HasPrefix(p.Hook, "http") && notification.Action != operationPreDelete
+}
+
+// StartActionsQueue starts the background delivery of the queued action notifications.
+// It must be called after the data provider initialization, it does nothing if already
+// started. The queue is also started as soon as a new notification is queued
+func StartActionsQueue() {
+	actionsQueueOnce.Do(func() {
+		logger.Debug(logSender, "", "start actions queue, check interval: %v", actionsQueueCheckInterval)
+		go func() {
+			ticker := time.NewTicker(actionsQueueCheckInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+				case <-actionsQueueWake:
+				}
+				processActionsQueue()
+			}
+		}()
+	})
+}
+
+// enqueueNotification stores the given notification in the persistent queue
+// and wakes up the delivery goroutine
+func enqueueNotification(notification *ActionNotification) error {
+	asJSON, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	if _, err := dataprovider.AddQueuedEvent(string(asJSON)); err != nil {
+		logger.Warn(notification.Protocol, "", "unable to queue notification for operation %#v: %v",
+			notification.Action, err)
+		return err
+	}
+	StartActionsQueue()
+	select {
+	case actionsQueueWake <- true:
+	default:
+	}
+	return nil
+}
+
+// processActionsQueue tries to deliver the queued notifications ready for a new attempt
+func processActionsQueue() {
+	if !Config.Actions.Queue.Enabled {
+		return
+	}
+	for {
+		events, err := dataprovider.GetQueuedEvents(actionsQueueBatchSize)
+		if err != nil {
+			logger.Warn(logSender, "", "unable to get queued notifications: %v", err)
+			return
+		}
+		for idx := range events {
+			if err := deliverQueuedEvent(&events[idx]); err != nil {
+				// the data provider is not working, we'll retry later
+				return
+			}
+		}
+		if len(events) < actionsQueueBatchSize {
+			return
+		}
+	}
+}
+
+// deliverQueuedEvent tries to deliver the given event. The returned error is not nil
+// only if the queue cannot be updated
+func deliverQueuedEvent(event *dataprovider.QueuedEvent) error {
+	var notification ActionNotification
+	if err := json.Unmarshal([]byte(event.Payload), &notification); err != nil {
+		logger.Error(logSender, "", "discarding invalid queued notification %v: %v", event.ID, err)
+		return removeQueuedEvent(event.ID)
+	}
+	handler := defaultActionHandler{}
+	err := handler.handleHTTP(Config.getHooks().actions, &notification)
+	if err == nil {
+		return removeQueuedEvent(event.ID)
+	}
+	event.Attempts++
+	maxAttempts := Config.Actions.Queue.MaxAttempts
+	if maxAttempts > 0 && event.Attempts >= maxAttempts {
+		logger.Error(logSender, "", "discarding queued notification %v after %v attempts, last error: %v, payload: %v",
+			event.ID, event.Attempts, err, event.Payload)
+		return removeQueuedEvent(event.ID)
+	}
+	delay := Config.Actions.Queue.getRetryDelay(event.Attempts)
+	event.NextAttemptAt = utils.GetTimeAsMsSinceEpoch(time.Now().Add(delay))
+	logger.Debug(logSender, "", "unable to deliver queued notification %v, attempts: %v, next attempt in %v, error: %v",
+		event.ID, event.Attempts, delay, err)
+	if err := dataprovider.UpdateQueuedEvent(event); err != nil {
+		logger.Warn(logSender, "", "unable to update queued notification %v: %v", event.ID, err)
+		return err
+	}
+	return nil
+}
+
+func removeQueuedEvent(id int64) error {
+	err := dataprovider.DeleteQueuedEvent(id)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to remove notification %v from the queue: %v", id, err)
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+)
+
+func isEventQueued(t *testing.T, id int64) (dataprovider.QueuedEvent, bool) {
+	events, err := dataprovider.GetQueuedEvents(actionsQueueBatchSize)
+	require.NoError(t, err)
+	for _, event := range events {
+		if event.ID == id {
+			return event, true
+		}
+	}
+	return dataprovider.QueuedEvent{}, false
+}
+
+func TestActionsQueueConfig(t *testing.T) {
+	c := ActionsQueueConfig{
+		MaxAttempts: -1,
+	}
+	assert.NoError(t, c.validate())
+	c.Enabled = true
+	assert.Error(t, c.validate())
+	c.MaxAttempts = 0
+	assert.Error(t, c.validate())
+	c.BaseDelay = 10
+	c.MaxDelay = 5
+	assert.Error(t, c.validate())
+	c.MaxDelay = 60
+	assert.NoError(t, c.validate())
+
+	assert.Equal(t, 10*time.Second, c.getRetryDelay(1))
+	assert.Equal(t, 20*time.Second, c.getRetryDelay(2))
+	assert.Equal(t, 40*time.Second, c.getRetryDelay(3))
+	assert.Equal(t, 60*time.Second, c.getRetryDelay(4))
+	assert.Equal(t, 60*time.Second, c.getRetryDelay(100))
+
+	p := ProtocolActions{
+		Hook:  fmt.Sprintf("http://%v", httpAddr),
+		Queue: c,
+	}
+	assert.True(t, p.isQueueable(&ActionNotification{Action: operationUpload}))
+	assert.False(t, p.isQueueable(&ActionNotification{Action: operationPreDelete}))
+	p.Hook = "/absolute/path"
+	assert.False(t, p.isQueueable(&ActionNotification{Action: operationUpload}))
+	p.Hook = fmt.Sprintf("http://%v", httpAddr)
+	p.Queue.Enabled = false
+	assert.False(t, p.isQueueable(&ActionNotification{Action: operationUpload}))
+}
+
+func TestQueuedEventDelivery(t *testing.T) {
+	actionsCopy := Config.Actions
+
+	Config.Actions = ProtocolActions{
+		ExecuteOn: []string{operationUpload},
+		Hook:      fmt.Sprintf("http://%v/404", httpAddr),
+		Queue: ActionsQueueConfig{
+			Enabled:     true,
+			MaxAttempts: 2,
+			BaseDelay:   10,
+			MaxDelay:    20,
+		},
+	}
+	_, err := dataprovider.AddQueuedEvent("")
+	assert.Error(t, err)
+
+	payload, err := json.Marshal(&ActionNotification{
+		Action:   operationUpload,
+		Username: "queue_user",
+		Path:     "/file",
+		Protocol: ProtocolSFTP,
+	})
+	require.NoError(t, err)
+	event, err := dataprovider.AddQueuedEvent(string(payload))
+	require.NoError(t, err)
+	_, ok := isEventQueued(t, event.ID)
+	assert.True(t, ok)
+
+	err = deliverQueuedEvent(&event)
+	assert.NoError(t, err)
+	// the failed event is delayed
+	_, ok = isEventQueued(t, event.ID)
+	assert.False(t, ok)
+	assert.Equal(t, 1, event.Attempts)
+	assert.Greater(t, event.NextAttemptAt, event.CreatedAt)
+
+	event.NextAttemptAt = 0
+	err = dataprovider.UpdateQueuedEvent(&event)
+	assert.NoError(t, err)
+	queued, ok := isEventQueued(t, event.ID)
+	assert.True(t, ok)
+	assert.Equal(t, 1, queued.Attempts)
+	// max attempts reached, the event is discarded
+	err = deliverQueuedEvent(&queued)
+	assert.NoError(t, err)
+	event.NextAttemptAt = 0
+	err = dataprovider.UpdateQueuedEvent(&event)
+	if err == nil {
+		_, ok = isEventQueued(t, event.ID)
+		assert.False(t, ok)
+	}
+
+	event.Attempts = -1
+	err = dataprovider.UpdateQueuedEvent(&event)
+	assert.Error(t, err)
+
+	event, err = dataprovider.AddQueuedEvent("invalid json")
+	require.NoError(t, err)
+	err = deliverQueuedEvent(&event)
+	assert.NoError(t, err)
+	_, ok = isEventQueued(t, event.ID)
+	assert.False(t, ok)
+
+	Config.Actions.Hook = fmt.Sprintf("http://%v", httpAddr)
+	event, err = dataprovider.AddQueuedEvent(string(payload))
+	require.NoError(t, err)
+	err = deliverQueuedEvent(&event)
+	assert.NoError(t, err)
+	_, ok = isEventQueued(t, event.ID)
+	assert.False(t, ok)
+
+	Config.Actions = actionsCopy
+}
+
+func TestActionsQueue(t *testing.T) {
+	actionsCopy := Config.Actions
+
+	Config.Actions = ProtocolActions{
+		ExecuteOn: []string{operationUpload},
+		Hook:      fmt.Sprintf("http://%v", httpAddr),
+		Queue: ActionsQueueConfig{
+			Enabled:   true,
+			BaseDelay: 1,
+			MaxDelay:  1,
+		},
+	}
+	err := actionHandler.Handle(&ActionNotification{
+		Action:   operationUpload,
+		Username: "queue_user",
+		Path:     "/file",
+		Protocol: ProtocolFTP,
+	})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		events, err := dataprovider.GetQueuedEvents(actionsQueueBatchSize)
+		return err == nil && len(events) == 0
+	}, 2*time.Second, 100*time.Millisecond)
+
+	Config.Actions = actionsCopy
+}
@@ -0,0 +1,175 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+// audit log actions not already defined as action notifications
+const (
+	auditActionMkdir    = "mkdir"
+	auditActionRmdir    = "rmdir"
+	auditActionSymlink  = "symlink"
+	auditActionChmod    = "chmod"
+	auditActionChown    = "chown"
+	auditActionChtimes  = "chtimes"
+	auditActionTruncate = "truncate"
+)
+
+// audit record results
+const (
+	auditResultSuccess = "success"
+	auditResultDenied  = "denied"
+	auditResultError   = "error"
+)
+
+// AuditLogConfig defines the configuration for the file operations audit log.
+// The audit log is a separate stream, distinct from the application log,
+// with one JSON record for each file operation
+type AuditLogConfig struct {
+	// Absolute path to the audit log file. Empty means disabled
+	FilePath string `json:"file_path" mapstructure:"file_path"`
+	// Maximum size, as megabytes, of the audit log file before it gets rotated. 0 means 100
+	MaxSize int `json:"max_size" mapstructure:"max_size"`
+	// Maximum number of rotated audit log files to retain. 0 means retain all
+	MaxBackups int `json:"max_backups" mapstructure:"max_backups"`
+	// Maximum number of days to retain the rotated audit log files. 0 means retain all
+	MaxAge int `json:"max_age" mapstructure:"max_age"`
+	// Determine if the rotated audit log files must be compressed using gzip
+	Compress bool `json:"compress" mapstructure:"compress"`
+}
+
+func (c *AuditLogConfig) isEnabled() bool {
+	return c.FilePath != ""
+}
+
+func (c *AuditLogConfig) validate() error {
+	if !c.isEnabled() {
+		return nil
+	}
+	if !filepath.IsAbs(c.FilePath) {
+		return fmt.Errorf("invalid file path %#v: it must be an absolute path", c.FilePath)
+	}
+	if c.MaxSize < 0 || c.MaxBackups < 0 || c.MaxAge < 0 {
+		return errors.New("max size, max backups and max age cannot be negative")
+	}
+	return nil
+}
+
+// AuditRecord defines a file operation audit record
+type AuditRecord struct {
+	Timestamp    string `json:"timestamp"`
+	Username     string `json:"username"`
+	IP           string `json:"ip"`
+	Protocol     string `json:"protocol"`
+	ConnectionID string `json:"connection_id"`
+	Action       string `json:"action"`
+	Path         string `json:"path"`
+	TargetPath   string `json:"target_path,omitempty"`
+	Size         int64  `json:"size"`
+	Result       string `json:"result"`
+	Error        string `json:"error,omitempty"`
+}
+
+type auditLog struct {
+	writer *lumberjack.Logger
+}
+
+func newAuditLog(config AuditLogConfig) (*auditLog, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(config.FilePath), 0700); err != nil {
+		return nil, err
+	}
+	maxSize := config.MaxSize
+	if maxSize == 0 {
+		maxSize = 100
+	}
+	return &auditLog{
+		writer: &lumberjack.Logger{
+			Filename:   config.FilePath,
+			MaxSize:    maxSize,
+			MaxBackups: config.MaxBackups,
+			MaxAge:     config.MaxAge,
+			Compress:   config.Compress,
+		},
+	}, nil
+}
+
+func (l *auditLog) write(record *AuditRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		logger.Warn(logSender, record.ConnectionID, "unable to marshal audit record: %v", err)
+		return
+	}
+	// lumberjack serializes the writes so each record is written as a single line
+	if _, err := l.writer.Write(append(data, '\n')); err != nil {
+		logger.Warn(logSender, record.ConnectionID, "unable to write audit record: %v", err)
+	}
+}
+
+func (l *auditLog) close() {
+	if err := l.writer.Close(); err != nil {
+		logger.Warn(logSender, "", "unable to close the audit log: %v", err)
+	}
+}
+
+func closeAuditLog() {
+	if Config.auditLog != nil {
+		Config.auditLog.close()
+		Config.auditLog = nil
+	}
+}
+
+// RotateAuditLog closes the existing audit log file and immediately creates a new one
+func RotateAuditLog() error {
+	if Config.auditLog == nil {
+		return errors.New("the audit log is disabled")
+	}
+	return Config.auditLog.writer.Rotate()
+}
+
+func getAuditResult(err error) string {
+	if err == nil {
+		return auditResultSuccess
+	}
+	if errors.Is(err, os.ErrPermission) || errors.Is(err, sftp.ErrSSHFxPermissionDenied) ||
+		errors.Is(err, ErrPermissionDenied) {
+		return auditResultDenied
+	}
+	return auditResultError
+}
+
+// auditFileOperation writes an audit record for a file operation, if the audit log is enabled.
+// The paths are the virtual paths as seen by the user, size is -1 if not applicable
+func (c *BaseConnection) auditFileOperation(action, virtualPath, virtualTargetPath string, size int64, err error) {
+	if Config.auditLog == nil {
+		return
+	}
+	record := &AuditRecord{
+		Timestamp:    time.Now().UTC().Format(time.RFC3339Nano),
+		Username:     c.User.Username,
+		IP:           c.remoteIP,
+		Protocol:     c.protocol,
+		ConnectionID: c.ID,
+		Action:       action,
+		Path:         virtualPath,
+		TargetPath:   virtualTargetPath,
+		Size:         size,
+		Result:       getAuditResult(err),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	Config.auditLog.write(record)
+}
@@ -0,0 +1,146 @@
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+)
+
+func readAuditRecords(t *testing.T, name string) []AuditRecord {
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record AuditRecord
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		require.NoError(t, err)
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func TestAuditLogConfig(t *testing.T) {
+	c := AuditLogConfig{}
+	assert.False(t, c.isEnabled())
+	assert.NoError(t, c.validate())
+	c.FilePath = "relative.log"
+	assert.Error(t, c.validate())
+	c.FilePath = filepath.Join(os.TempDir(), "audit.log")
+	assert.NoError(t, c.validate())
+	c.MaxBackups = -1
+	assert.Error(t, c.validate())
+	_, err := newAuditLog(c)
+	assert.Error(t, err)
+
+	err = RotateAuditLog()
+	assert.Error(t, err)
+
+	configCopy := Config
+	config := configCopy
+	config.AuditLog = AuditLogConfig{
+		FilePath: "relative.log",
+	}
+	err = Initialize(config)
+	assert.Error(t, err)
+	config.AuditLog = AuditLogConfig{
+		FilePath: filepath.Join(os.TempDir(), "audit.log"),
+	}
+	err = Initialize(config)
+	require.NoError(t, err)
+	assert.NotNil(t, Config.auditLog)
+	assert.NoError(t, RotateAuditLog())
+
+	err = Initialize(configCopy)
+	require.NoError(t, err)
+	assert.Nil(t, Config.auditLog)
+	Config = configCopy
+	err = os.Remove(filepath.Join(os.TempDir(), "audit.log"))
+	assert.NoError(t, err)
+}
+
+func TestAuditLog(t *testing.T) {
+	auditLogPath := filepath.Join(os.TempDir(), "audit", "audit.log")
+	auditLog, err := newAuditLog(AuditLogConfig{
+		FilePath: auditLogPath,
+	})
+	require.NoError(t, err)
+	Config.auditLog = auditLog
+	defer closeAuditLog()
+
+	user := dataprovider.User{
+		Username: userTestUsername,
+		HomeDir:  filepath.Join(os.TempDir(), "home"),
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	user.Permissions["/sub"] = []string{dataprovider.PermListItems}
+	err = os.Mkdir(user.GetHomeDir(), os.ModePerm)
+	assert.NoError(t, err)
+	fs, err := user.GetFilesystem("")
+	assert.NoError(t, err)
+	c := NewBaseConnection("", ProtocolSFTP, user, fs)
+	c.SetRemoteAddress("172.16.1.1:12345")
+
+	err = c.CreateDir("", "/sub/dir")
+	assert.Error(t, err)
+	err = c.CreateDir(filepath.Join(user.GetHomeDir(), "dir"), "/dir")
+	assert.NoError(t, err)
+	err = c.Rename(filepath.Join(user.GetHomeDir(), "dir"), filepath.Join(user.GetHomeDir(), "dir1"), "/dir", "/dir1")
+	assert.NoError(t, err)
+	err = c.RemoveDir(filepath.Join(user.GetHomeDir(), "missing"), "/missing")
+	assert.Error(t, err)
+
+	records := readAuditRecords(t, auditLogPath)
+	if assert.Len(t, records, 4) {
+		assert.Equal(t, userTestUsername, records[0].Username)
+		assert.Equal(t, "172.16.1.1", records[0].IP)
+		assert.Equal(t, ProtocolSFTP, records[0].Protocol)
+		assert.Equal(t, c.ID, records[0].ConnectionID)
+		assert.Equal(t, auditActionMkdir, records[0].Action)
+		assert.Equal(t, "/sub/dir", records[0].Path)
+		assert.Equal(t, int64(-1), records[0].Size)
+		assert.Equal(t, auditResultDenied, records[0].Result)
+		assert.NotEmpty(t, records[0].Error)
+		assert.NotEmpty(t, records[0].Timestamp)
+
+		assert.Equal(t, auditActionMkdir, records[1].Action)
+		assert.Equal(t, "/dir", records[1].Path)
+		assert.Equal(t, auditResultSuccess, records[1].Result)
+		assert.Empty(t, records[1].Error)
+
+		assert.Equal(t, operationRename, records[2].Action)
+		assert.Equal(t, "/dir", records[2].Path)
+		assert.Equal(t, "/dir1", records[2].TargetPath)
+		assert.Equal(t, auditResultSuccess, records[2].Result)
+
+		assert.Equal(t, auditActionRmdir, records[3].Action)
+		assert.Equal(t, "/missing", records[3].Path)
+		assert.Equal(t, auditResultError, records[3].Result)
+	}
+
+	err = RotateAuditLog()
+	assert.NoError(t, err)
+	err = c.RemoveDir(filepath.Join(user.GetHomeDir(), "dir1"), "/dir1")
+	assert.NoError(t, err)
+	records = readAuditRecords(t, auditLogPath)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, auditActionRmdir, records[0].Action)
+		assert.Equal(t, auditResultSuccess, records[0].Result)
+	}
+
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(filepath.Dir(auditLogPath))
+	assert.NoError(t, err)
+}
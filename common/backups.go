@@ -0,0 +1,318 @@
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/minio/sio"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+const (
+	backupFilePrefix = "sftpgo-backup-"
+	backupFileSuffix = ".json.enc"
+	// the encrypted backups have the same format as the files stored by the local
+	// encrypted filesystem: a version byte, a random nonce and the sio encrypted data
+	backupEncryptionVersion byte = 0x10
+	backupNonceSize              = 32
+	backupHeaderSize             = 1 + backupNonceSize
+)
+
+var (
+	backupTicker     *time.Ticker
+	backupTickerDone chan bool
+	backupInProgress int32
+)
+
+// BackupS3Config defines an S3 compatible bucket to store the scheduled backups
+type BackupS3Config struct {
+	Bucket string `json:"bucket" mapstructure:"bucket"`
+	// Optional prefix for the backup objects, for example "backups/"
+	KeyPrefix    string `json:"key_prefix" mapstructure:"key_prefix"`
+	Region       string `json:"region" mapstructure:"region"`
+	AccessKey    string `json:"access_key" mapstructure:"access_key"`
+	AccessSecret string `json:"access_secret" mapstructure:"access_secret"`
+	Endpoint     string `json:"endpoint" mapstructure:"endpoint"`
+	StorageClass string `json:"storage_class" mapstructure:"storage_class"`
+}
+
+// BackupScheduleConfig defines when to periodically dump the data provider contents.
+// The backups are encrypted and stored inside a local directory or an S3 bucket.
+// Interval and Cron are mutually exclusive
+type BackupScheduleConfig struct {
+	// Interval, as minutes, between two consecutive backups. 0 means disabled
+	Interval int `json:"interval" mapstructure:"interval"`
+	// Cron expression, in UTC time, with the standard five fields:
+	// minute, hour, day of month, month and day of week. Empty means disabled
+	Cron string `json:"cron" mapstructure:"cron"`
+	// Absolute path to the local directory where the backups are stored.
+	// Ignored if an S3 bucket is configured
+	OutputDir string `json:"output_dir" mapstructure:"output_dir"`
+	// S3 bucket where the backups are stored
+	S3 BackupS3Config `json:"s3" mapstructure:"s3"`
+	// Passphrase used to encrypt the backups, it is required to restore them
+	Passphrase string `json:"passphrase" mapstructure:"passphrase"`
+	// Number of backups to keep, the oldest ones are removed. 0 means keep all
+	Keep int `json:"keep" mapstructure:"keep"`
+	// parsed cron expression
+	schedule *cronSchedule
+}
+
+func (c *BackupScheduleConfig) isEnabled() bool {
+	return c.Interval > 0 || c.Cron != ""
+}
+
+func (c *BackupScheduleConfig) validate() error {
+	schedule, err := parseScheduleConfig(c.Interval, c.Cron)
+	if err != nil {
+		return err
+	}
+	c.schedule = schedule
+	if !c.isEnabled() {
+		return nil
+	}
+	if c.Passphrase == "" {
+		return errors.New("a passphrase is required to encrypt the backups")
+	}
+	if c.Keep < 0 {
+		return fmt.Errorf("invalid number of backups to keep: %v", c.Keep)
+	}
+	if c.S3.Bucket == "" {
+		if c.OutputDir == "" {
+			return errors.New("an output directory or an S3 bucket is required")
+		}
+		if !filepath.IsAbs(c.OutputDir) {
+			return fmt.Errorf("invalid output directory %#v: it must be an absolute path", c.OutputDir)
+		}
+	}
+	return nil
+}
+
+func (c *BackupScheduleConfig) getFilesystem() (vfs.Fs, error) {
+	if c.S3.Bucket != "" {
+		config := vfs.S3FsConfig{
+			Bucket:       c.S3.Bucket,
+			KeyPrefix:    c.S3.KeyPrefix,
+			Region:       c.S3.Region,
+			AccessKey:    c.S3.AccessKey,
+			Endpoint:     c.S3.Endpoint,
+			StorageClass: c.S3.StorageClass,
+		}
+		if c.S3.AccessSecret != "" {
+			config.AccessSecret = kms.NewPlainSecret(c.S3.AccessSecret)
+		} else {
+			config.AccessSecret = kms.NewEmptySecret()
+		}
+		return vfs.NewS3Fs("backup", os.TempDir(), config)
+	}
+	if err := os.MkdirAll(c.OutputDir, 0700); err != nil {
+		return nil, err
+	}
+	return vfs.NewOsFs("backup", c.OutputDir, nil), nil
+}
+
+// the ticker cannot be started/stopped from multiple goroutines
+func startBackupTicker(config BackupScheduleConfig) {
+	stopBackupTicker()
+	duration := cronCheckInterval
+	if config.Interval > 0 {
+		duration = time.Duration(config.Interval) * time.Minute
+	}
+	backupTicker = time.NewTicker(duration)
+	backupTickerDone = make(chan bool)
+	logger.Info(logSender, "", "scheduled backups started, interval: %v, cron: %#v", config.Interval, config.Cron)
+	go func() {
+		for {
+			select {
+			case <-backupTickerDone:
+				return
+			case t := <-backupTicker.C:
+				if config.schedule != nil && !config.schedule.matches(t.UTC()) {
+					continue
+				}
+				if err := runScheduledBackup(&config); err != nil {
+					logger.Warn(logSender, "", "scheduled backup error: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+func stopBackupTicker() {
+	if backupTicker != nil {
+		backupTicker.Stop()
+		backupTickerDone <- true
+		backupTicker = nil
+	}
+}
+
+// runScheduledBackup dumps the data provider contents, encrypts them and stores
+// the result. The oldest backups are then removed. The backup is skipped if the
+// previous one is still running
+func runScheduledBackup(config *BackupScheduleConfig) error {
+	if !atomic.CompareAndSwapInt32(&backupInProgress, 0, 1) {
+		logger.Debug(logSender, "", "scheduled backup skipped, the previous one is still running")
+		return nil
+	}
+	defer atomic.StoreInt32(&backupInProgress, 0)
+
+	startTime := time.Now()
+	dump, err := dataprovider.DumpData()
+	if err != nil {
+		return fmt.Errorf("unable to dump data: %w", err)
+	}
+	data, err := json.Marshal(dump)
+	if err != nil {
+		return err
+	}
+	data, err = EncryptBackup(data, config.Passphrase)
+	if err != nil {
+		return fmt.Errorf("unable to encrypt the backup: %w", err)
+	}
+	fs, err := config.getFilesystem()
+	if err != nil {
+		return fmt.Errorf("unable to get the backup filesystem: %w", err)
+	}
+	defer fs.Close()
+
+	name := backupFilePrefix + startTime.UTC().Format("20060102T150405") + backupFileSuffix
+	fsPath, err := fs.ResolvePath("/" + name)
+	if err != nil {
+		return err
+	}
+	if err := writeBackupFile(fs, fsPath, data); err != nil {
+		return fmt.Errorf("unable to write the backup %#v: %w", name, err)
+	}
+	logger.Info(logSender, "", "scheduled backup %#v completed, size: %v, elapsed: %v", name, len(data),
+		time.Since(startTime))
+	if config.Keep > 0 {
+		return removeOldBackups(fs, config.Keep)
+	}
+	return nil
+}
+
+func writeBackupFile(fs vfs.Fs, fsPath string, data []byte) error {
+	file, w, cancelFn, err := fs.Create(fsPath, 0)
+	if err != nil {
+		return err
+	}
+	if w != nil {
+		if _, err = w.Write(data); err != nil {
+			cancelFn()
+			w.Close()
+			return err
+		}
+		return w.Close()
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func removeOldBackups(fs vfs.Fs, keep int) error {
+	dirPath, err := fs.ResolvePath("/")
+	if err != nil {
+		return err
+	}
+	contents, err := fs.ReadDir(dirPath)
+	if err != nil {
+		return fmt.Errorf("unable to list the backups: %w", err)
+	}
+	var backups []string
+	for _, info := range contents {
+		name := info.Name()
+		if !info.IsDir() && strings.HasPrefix(name, backupFilePrefix) && strings.HasSuffix(name, backupFileSuffix) {
+			backups = append(backups, name)
+		}
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+	// the backup names include the creation time, so sorting them by name
+	// sorts them by time too
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-keep] {
+		if err := fs.Remove(fs.Join(dirPath, name), false); err != nil {
+			logger.Warn(logSender, "", "unable to remove old backup %#v: %v", name, err)
+			continue
+		}
+		logger.Debug(logSender, "", "old backup %#v removed", name)
+	}
+	return nil
+}
+
+// IsEncryptedBackup returns true if the given backup content is encrypted.
+// A plain backup is a JSON document so it cannot start with the version byte
+func IsEncryptedBackup(content []byte) bool {
+	return len(content) > backupHeaderSize && content[0] == backupEncryptionVersion
+}
+
+// EncryptBackup encrypts the given backup content using a key derived from the
+// given passphrase
+func EncryptBackup(content []byte, passphrase string) ([]byte, error) {
+	nonce := make([]byte, backupNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	config, err := getBackupSIOConfig(passphrase, nonce)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(backupEncryptionVersion)
+	buf.Write(nonce)
+	if _, err := sio.Encrypt(&buf, bytes.NewReader(content), config); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecryptBackup decrypts a backup encrypted using EncryptBackup
+func DecryptBackup(content []byte, passphrase string) ([]byte, error) {
+	if !IsEncryptedBackup(content) {
+		return nil, errors.New("the backup is not encrypted or it has an unsupported format")
+	}
+	if passphrase == "" {
+		return nil, errors.New("a passphrase is required to decrypt the backup")
+	}
+	config, err := getBackupSIOConfig(passphrase, content[1:backupHeaderSize])
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := sio.Decrypt(&buf, bytes.NewReader(content[backupHeaderSize:]), config); err != nil {
+		return nil, fmt.Errorf("unable to decrypt the backup, wrong passphrase?: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func getBackupSIOConfig(passphrase string, nonce []byte) (sio.Config, error) {
+	var key [32]byte
+	kdf := hkdf.New(sha256.New, []byte(passphrase), nonce, nil)
+	if _, err := io.ReadFull(kdf, key[:]); err != nil {
+		return sio.Config{}, err
+	}
+	return sio.Config{
+		MinVersion: sio.Version20,
+		MaxVersion: sio.Version20,
+		Key:        key[:],
+	}, nil
+}
@@ -0,0 +1,125 @@
+package common
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+)
+
+func TestBackupScheduleValidation(t *testing.T) {
+	c := BackupScheduleConfig{}
+	assert.False(t, c.isEnabled())
+	assert.NoError(t, c.validate())
+	c.Interval = 60
+	assert.Error(t, c.validate())
+	c.Passphrase = "secret"
+	assert.Error(t, c.validate())
+	c.OutputDir = "relative"
+	assert.Error(t, c.validate())
+	c.OutputDir = filepath.Join(os.TempDir(), "backups")
+	c.Keep = -1
+	assert.Error(t, c.validate())
+	c.Keep = 2
+	assert.NoError(t, c.validate())
+	c.Cron = "0 1 * * *"
+	assert.Error(t, c.validate())
+	c.Interval = 0
+	assert.NoError(t, c.validate())
+	assert.NotNil(t, c.schedule)
+	c.OutputDir = ""
+	c.S3.Bucket = "bucket"
+	assert.NoError(t, c.validate())
+}
+
+func TestBackupEncryption(t *testing.T) {
+	content := []byte(`{"users":[]}`)
+	assert.False(t, IsEncryptedBackup(content))
+	_, err := DecryptBackup(content, "secret")
+	assert.Error(t, err)
+
+	encrypted, err := EncryptBackup(content, "secret")
+	require.NoError(t, err)
+	assert.True(t, IsEncryptedBackup(encrypted))
+	assert.NotContains(t, string(encrypted), "users")
+
+	_, err = DecryptBackup(encrypted, "")
+	assert.Error(t, err)
+	_, err = DecryptBackup(encrypted, "wrong")
+	assert.Error(t, err)
+	decrypted, err := DecryptBackup(encrypted, "secret")
+	require.NoError(t, err)
+	assert.Equal(t, content, decrypted)
+	// a random nonce is used for each backup
+	encrypted1, err := EncryptBackup(content, "secret")
+	require.NoError(t, err)
+	assert.NotEqual(t, encrypted, encrypted1)
+}
+
+func TestScheduledBackups(t *testing.T) {
+	backupsDir := filepath.Join(os.TempDir(), "scheduled_backups")
+	err := os.RemoveAll(backupsDir)
+	require.NoError(t, err)
+
+	config := BackupScheduleConfig{
+		Interval:   60,
+		OutputDir:  backupsDir,
+		Passphrase: "backup passphrase",
+		Keep:       2,
+	}
+	require.NoError(t, config.validate())
+	// simulate some older backups
+	require.NoError(t, os.MkdirAll(backupsDir, 0700))
+	oldBackups := []string{"sftpgo-backup-20210101T000000.json.enc", "sftpgo-backup-20210102T000000.json.enc"}
+	for _, name := range oldBackups {
+		err = os.WriteFile(filepath.Join(backupsDir, name), []byte("old"), 0600)
+		require.NoError(t, err)
+	}
+	err = os.WriteFile(filepath.Join(backupsDir, "other.json"), []byte("{}"), 0600)
+	require.NoError(t, err)
+
+	err = runScheduledBackup(&config)
+	require.NoError(t, err)
+
+	assert.NoFileExists(t, filepath.Join(backupsDir, oldBackups[0]))
+	assert.FileExists(t, filepath.Join(backupsDir, oldBackups[1]))
+	assert.FileExists(t, filepath.Join(backupsDir, "other.json"))
+	matches, err := filepath.Glob(filepath.Join(backupsDir, backupFilePrefix+"*"+backupFileSuffix))
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+	var backupFile string
+	for _, m := range matches {
+		if filepath.Base(m) != oldBackups[1] {
+			backupFile = m
+		}
+	}
+	content, err := os.ReadFile(backupFile)
+	require.NoError(t, err)
+	assert.True(t, IsEncryptedBackup(content))
+	content, err = DecryptBackup(content, config.Passphrase)
+	require.NoError(t, err)
+	var dump dataprovider.BackupData
+	err = json.Unmarshal(content, &dump)
+	assert.NoError(t, err)
+	// a backup in progress prevents a new one
+	backupInProgress = 1
+	err = runScheduledBackup(&config)
+	assert.NoError(t, err)
+	matches, err = filepath.Glob(filepath.Join(backupsDir, backupFilePrefix+"*"+backupFileSuffix))
+	require.NoError(t, err)
+	assert.Len(t, matches, 2)
+	backupInProgress = 0
+
+	startBackupTicker(config)
+	assert.NotNil(t, backupTicker)
+	stopBackupTicker()
+	assert.Nil(t, backupTicker)
+
+	err = os.RemoveAll(backupsDir)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,300 @@
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testBrokerMessage struct {
+	topic   string
+	payload []byte
+}
+
+// startTestNATSServer starts a minimal NATS server supporting the client handshake,
+// PING and PUB. The published messages are sent to the returned channel
+func startTestNATSServer(t *testing.T) (net.Listener, chan testBrokerMessage) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	messages := make(chan testBrokerMessage, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleTestNATSConn(conn, messages)
+		}
+	}()
+	return listener, messages
+}
+
+func handleTestNATSConn(conn net.Conn, messages chan testBrokerMessage) {
+	defer conn.Close()
+
+	addr := conn.LocalAddr().(*net.TCPAddr)
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"version\":\"2.2.0\",\"host\":\"127.0.0.1\",\"port\":%v,"+
+		"\"max_payload\":1048576,\"proto\":1}\r\n", addr.Port)
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case "PUB":
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				return
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			messages <- testBrokerMessage{
+				topic:   fields[1],
+				payload: payload[:size],
+			}
+		}
+	}
+}
+
+// startTestMQTTServer starts a minimal MQTT server supporting CONNECT, PUBLISH
+// with QoS 1 and PINGREQ. The published messages are sent to the returned channel
+func startTestMQTTServer(t *testing.T) (net.Listener, chan testBrokerMessage) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	messages := make(chan testBrokerMessage, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleTestMQTTConn(conn, messages)
+		}
+	}()
+	return listener, messages
+}
+
+func handleTestMQTTConn(conn net.Conn, messages chan testBrokerMessage) {
+	defer conn.Close()
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			connack.ReturnCode = packets.Accepted
+			err = connack.Write(conn)
+		case *packets.PublishPacket:
+			messages <- testBrokerMessage{
+				topic:   p.TopicName,
+				payload: p.Payload,
+			}
+			if p.Qos == 1 {
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				err = puback.Write(conn)
+			}
+		case *packets.PingreqPacket:
+			err = packets.NewControlPacket(packets.Pingresp).Write(conn)
+		case *packets.DisconnectPacket:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestBrokerConfigValidation(t *testing.T) {
+	c := BrokerConfig{
+		Type: "unknown",
+	}
+	assert.Error(t, c.validate())
+	c.Type = " NATS "
+	assert.Error(t, c.validate())
+	c.URL = "nats://127.0.0.1:4222"
+	assert.Error(t, c.validate())
+	c.Topic = "sftpgo.events"
+	assert.NoError(t, c.validate())
+	assert.Equal(t, BrokerTypeNATS, c.Type)
+
+	c = BrokerConfig{
+		Type: BrokerTypeAMQP,
+		URL:  "amqp://127.0.0.1:5672",
+	}
+	// the default exchange is allowed for AMQP
+	assert.NoError(t, c.validate())
+
+	for _, brokerType := range supportedBrokerTypes {
+		publisher, err := newBrokerPublisher(BrokerConfig{
+			Type:  brokerType,
+			URL:   "127.0.0.1:9092",
+			Topic: "topic",
+		})
+		assert.NoError(t, err)
+		assert.NotNil(t, publisher)
+		assert.NoError(t, publisher.close())
+	}
+}
+
+func TestBrokersInitialization(t *testing.T) {
+	configCopy := Config
+
+	c := Configuration{}
+	c.Actions.Brokers = []BrokerConfig{
+		{
+			Type:  BrokerTypeMQTT,
+			URL:   "tcp://127.0.0.1:1883",
+			Topic: "sftpgo/events",
+		},
+		{
+			Type: BrokerTypeKafka,
+			URL:  "127.0.0.1:9092",
+		},
+	}
+	err := Initialize(c)
+	assert.Error(t, err)
+	assert.Len(t, Config.brokers, 0)
+
+	c.Actions.Brokers[1].Topic = "sftpgo-events"
+	err = Initialize(c)
+	require.NoError(t, err)
+	assert.Len(t, Config.brokers, 2)
+
+	err = Initialize(configCopy)
+	require.NoError(t, err)
+	assert.Len(t, Config.brokers, 0)
+	Config = configCopy
+}
+
+func TestPublishToUnreachableBrokers(t *testing.T) {
+	actionsCopy := Config.Actions
+
+	Config.Actions = ProtocolActions{
+		ExecuteOn: []string{operationUpload, operationPreDelete},
+		Brokers: []BrokerConfig{
+			{
+				Type:  BrokerTypeNATS,
+				URL:   "nats://127.0.0.1:1",
+				Topic: "sftpgo.events",
+			},
+			{
+				Type:  BrokerTypeMQTT,
+				URL:   "tcp://127.0.0.1:1",
+				Topic: "sftpgo/events",
+			},
+			{
+				Type:  BrokerTypeAMQP,
+				URL:   "amqp://127.0.0.1:1",
+				Topic: "sftpgo",
+			},
+		},
+	}
+	for _, broker := range Config.Actions.Brokers {
+		publisher, err := newBrokerPublisher(broker)
+		require.NoError(t, err)
+		Config.brokers = append(Config.brokers, publisher)
+	}
+	notification := &ActionNotification{
+		Action:   operationUpload,
+		Username: "broker_user",
+		Path:     "/file",
+		Protocol: ProtocolSFTP,
+	}
+	startTime := time.Now()
+	err := actionHandler.Handle(notification)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errNoHook)
+	assert.Less(t, time.Since(startTime), brokerTimeout)
+	// pre-delete notifications are not published
+	notification.Action = operationPreDelete
+	err = actionHandler.Handle(notification)
+	assert.ErrorIs(t, err, errNoHook)
+
+	closeBrokers()
+	Config.Actions = actionsCopy
+}
+
+func TestPublishToBrokers(t *testing.T) {
+	actionsCopy := Config.Actions
+
+	natsListener, natsMessages := startTestNATSServer(t)
+	defer natsListener.Close()
+	mqttListener, mqttMessages := startTestMQTTServer(t)
+	defer mqttListener.Close()
+
+	Config.Actions = ProtocolActions{
+		ExecuteOn: []string{operationUpload},
+		Brokers: []BrokerConfig{
+			{
+				Type:  BrokerTypeNATS,
+				URL:   fmt.Sprintf("nats://%v", natsListener.Addr().String()),
+				Topic: "sftpgo.events",
+			},
+			{
+				Type:  BrokerTypeMQTT,
+				URL:   fmt.Sprintf("tcp://%v", mqttListener.Addr().String()),
+				Topic: "sftpgo/events",
+			},
+		},
+	}
+	for _, broker := range Config.Actions.Brokers {
+		publisher, err := newBrokerPublisher(broker)
+		require.NoError(t, err)
+		Config.brokers = append(Config.brokers, publisher)
+	}
+	notification := &ActionNotification{
+		Action:   operationUpload,
+		Username: "broker_user",
+		Path:     "/file",
+		FileSize: 123,
+		Protocol: ProtocolSFTP,
+	}
+	checkMessage := func(messages chan testBrokerMessage, topic string) {
+		select {
+		case msg := <-messages:
+			assert.Equal(t, topic, msg.topic)
+			var received ActionNotification
+			err := json.Unmarshal(msg.payload, &received)
+			assert.NoError(t, err)
+			assert.Equal(t, notification.Action, received.Action)
+			assert.Equal(t, notification.Username, received.Username)
+			assert.Equal(t, notification.Path, received.Path)
+			assert.Equal(t, notification.FileSize, received.FileSize)
+		case <-time.After(2 * time.Second):
+			assert.Fail(t, "notification not published", "topic %v", topic)
+		}
+	}
+	// the connections are reused for the next notifications
+	for i := 0; i < 2; i++ {
+		err := actionHandler.Handle(notification)
+		assert.NoError(t, err)
+		checkMessage(natsMessages, "sftpgo.events")
+		checkMessage(mqttMessages, "sftpgo/events")
+	}
+
+	closeBrokers()
+	Config.Actions = actionsCopy
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -19,6 +20,8 @@ import (
 	"github.com/pires/go-proxyproto"
 
 	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/diagnostics"
+	"github.com/drakkan/sftpgo/geoip"
 	"github.com/drakkan/sftpgo/httpclient"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/metrics"
@@ -47,6 +50,8 @@ const (
 	operationSSHCmd          = "ssh_cmd"
 	chtimesFormat            = "2006-01-02T15:04:05" // YYYY-MM-DDTHH:MM:SS
 	idleTimeoutCheckInterval = 3 * time.Minute
+	// interval to check for stalled transfers
+	stalledTransfersCheckInterval = 30 * time.Second
 )
 
 // Stat flags
@@ -70,6 +75,7 @@ const (
 	ProtocolSSH    = "SSH"
 	ProtocolFTP    = "FTP"
 	ProtocolWebDAV = "DAV"
+	ProtocolHTTP   = "HTTP"
 )
 
 // Upload modes
@@ -86,10 +92,17 @@ var (
 	ErrOpUnsupported        = errors.New("operation unsupported")
 	ErrGenericFailure       = errors.New("failure")
 	ErrQuotaExceeded        = errors.New("denying write due to space limit")
+	ErrReadQuotaExceeded    = errors.New("denying read due to data transfer limit")
 	ErrSkipPermissionsCheck = errors.New("permission check skipped")
 	ErrConnectionDenied     = errors.New("you are not allowed to connect")
 	ErrNoBinding            = errors.New("no binding configured")
 	ErrCrtRevoked           = errors.New("your certificate has been revoked")
+	ErrLoginRateLimited     = errors.New("too many login attempts, please retry later")
+	ErrRateLimited          = errors.New("rate limit exceeded, please retry later")
+	ErrTooManyTransfers     = errors.New("too many concurrent transfers, please retry later")
+	ErrTransferStalled      = errors.New("transfer aborted, no data transferred for too long")
+	ErrShuttingDown         = errors.New("the server is shutting down, please retry later")
+	ErrDirectoryFull        = errors.New("the maximum number of entries for this directory has been reached")
 	errNoTransfer           = errors.New("requested transfer not found")
 	errTransferMismatch     = errors.New("transfer mismatch")
 )
@@ -103,17 +116,54 @@ var (
 	QuotaScans            ActiveScans
 	idleTimeoutTicker     *time.Ticker
 	idleTimeoutTickerDone chan bool
+	stalledTicker         *time.Ticker
+	stalledTickerDone     chan bool
 	supportedProtocols    = []string{ProtocolSFTP, ProtocolSCP, ProtocolSSH, ProtocolFTP, ProtocolWebDAV}
+	// protects the hooks that can be reloaded at runtime
+	hooksLock sync.RWMutex
 )
 
 // Initialize sets the common configuration
 func Initialize(c Configuration) error {
+	closeBrokers()
+	closeAuditLog()
+	closeTransferLog()
 	Config = c
 	Config.idleLoginTimeout = 2 * time.Minute
 	Config.idleTimeoutAsDuration = time.Duration(Config.IdleTimeout) * time.Minute
 	if Config.IdleTimeout > 0 {
 		startIdleTimeoutTicker(idleTimeoutCheckInterval)
 	}
+	Config.stalledTransferTimeout = time.Duration(Config.StalledTransferTimeout) * time.Second
+	if Config.StalledTransferTimeout > 0 {
+		startStalledTransfersTicker(stalledTransfersCheckInterval)
+	} else {
+		stopStalledTransfersTicker()
+	}
+	if err := Config.QuotaScanSchedule.validate(); err != nil {
+		return fmt.Errorf("quota scan schedule initialization error: %v", err)
+	}
+	if Config.QuotaScanSchedule.isEnabled() {
+		startQuotaScanTicker(Config.QuotaScanSchedule)
+	} else {
+		stopQuotaScanTicker()
+	}
+	if err := Config.DataRetentionSchedule.validate(); err != nil {
+		return fmt.Errorf("data retention schedule initialization error: %v", err)
+	}
+	if Config.DataRetentionSchedule.isEnabled() {
+		startRetentionCheckTicker(Config.DataRetentionSchedule)
+	} else {
+		stopRetentionCheckTicker()
+	}
+	if err := Config.BackupSchedule.validate(); err != nil {
+		return fmt.Errorf("backup schedule initialization error: %v", err)
+	}
+	if Config.BackupSchedule.isEnabled() {
+		startBackupTicker(Config.BackupSchedule)
+	} else {
+		stopBackupTicker()
+	}
 	Config.defender = nil
 	if c.DefenderConfig.Enabled {
 		defender, err := newInMemoryDefender(&c.DefenderConfig)
@@ -123,9 +173,121 @@ func Initialize(c Configuration) error {
 		logger.Info(logSender, "", "defender initialized with config %+v", c.DefenderConfig)
 		Config.defender = defender
 	}
+	Config.loginThrottler = nil
+	if c.LoginThrottling.isEnabled() {
+		throttler, err := newLoginThrottler(&c.LoginThrottling)
+		if err != nil {
+			return fmt.Errorf("login throttling initialization error: %v", err)
+		}
+		logger.Info(logSender, "", "login throttling initialized with config %+v", c.LoginThrottling)
+		Config.loginThrottler = throttler
+	}
+	Config.rateLimiters = nil
+	for idx := range c.RateLimiters {
+		if !c.RateLimiters[idx].isEnabled() {
+			continue
+		}
+		limiter, err := newRateLimiter(&c.RateLimiters[idx])
+		if err != nil {
+			return fmt.Errorf("rate limiter %v initialization error: %v", idx, err)
+		}
+		logger.Info(logSender, "", "rate limiter %v initialized with config %+v", idx, limiter.config)
+		Config.rateLimiters = append(Config.rateLimiters, limiter)
+	}
+	if err := c.Actions.Queue.validate(); err != nil {
+		return fmt.Errorf("actions queue configuration error: %v", err)
+	}
+	for idx := range c.Actions.Brokers {
+		publisher, err := newBrokerPublisher(c.Actions.Brokers[idx])
+		if err != nil {
+			closeBrokers()
+			return fmt.Errorf("broker %v initialization error: %v", idx, err)
+		}
+		logger.Info(logSender, "", "broker %v initialized, type: %v, topic: %#v", idx, c.Actions.Brokers[idx].Type,
+			c.Actions.Brokers[idx].Topic)
+		Config.brokers = append(Config.brokers, publisher)
+	}
+	Config.actionEmails = nil
+	for idx := range c.Actions.Emails {
+		email, err := newActionEmail(c.Actions.Emails[idx])
+		if err != nil {
+			return fmt.Errorf("email action %v initialization error: %v", idx, err)
+		}
+		Config.actionEmails = append(Config.actionEmails, email)
+	}
+	Config.ipLists = nil
+	if c.IPLists.isEnabled() {
+		lists, err := newIPLists(c.IPLists)
+		if err != nil {
+			return fmt.Errorf("ip lists initialization error: %v", err)
+		}
+		logger.Info(logSender, "", "ip lists initialized with config %+v", c.IPLists)
+		Config.ipLists = lists
+	}
+	if c.AuditLog.isEnabled() {
+		auditLog, err := newAuditLog(c.AuditLog)
+		if err != nil {
+			return fmt.Errorf("audit log initialization error: %v", err)
+		}
+		logger.Info(logSender, "", "audit log initialized with config %+v", c.AuditLog)
+		Config.auditLog = auditLog
+	}
+	if c.TransferLog.isEnabled() {
+		transferLog, err := newTransferLog(c.TransferLog)
+		if err != nil {
+			return fmt.Errorf("transfer log initialization error: %v", err)
+		}
+		logger.Info(logSender, "", "transfer log initialized with config %+v", c.TransferLog)
+		Config.transferLog = transferLog
+	}
 	return nil
 }
 
+// LimitRate returns ErrRateLimited if one of the configured rate limiters
+// for the given target, protocol and source IP is exceeded
+func LimitRate(target RateLimiterTarget, protocol, ip string) error {
+	for _, limiter := range Config.rateLimiters {
+		if limiter.isApplicable(target, protocol, ip) && !limiter.allow(ip) {
+			logger.Debug(logSender, "", "rate limit exceeded for protocol %v, ip %#v, target %v", protocol, ip, target)
+			return ErrRateLimited
+		}
+	}
+	return nil
+}
+
+// LimitAPIRate returns ErrRateLimited if one of the configured REST API rate limiters
+// for the given source IP and token is exceeded. The token ID must identify an already
+// verified token, an empty token ID means that the request is not authenticated and so
+// the source IP is used. The returned duration is the time to wait before retrying
+func LimitAPIRate(ip, tokenID string) (time.Duration, error) {
+	for _, limiter := range Config.rateLimiters {
+		if !limiter.isApplicable(RateLimiterTargetAPI, ProtocolHTTP, ip) {
+			continue
+		}
+		key := ip
+		if limiter.config.Type == int(RateLimiterTypeToken) && tokenID != "" {
+			key = tokenID
+		}
+		if allowed, delay := limiter.reserve(key); !allowed {
+			logger.Debug(logSender, "", "REST API rate limit exceeded for ip %#v, retry after %v", ip, delay)
+			return delay, ErrRateLimited
+		}
+	}
+	return 0, nil
+}
+
+// ReloadHooks updates the actions, post-connect and session end hooks using
+// the given configuration. The hooks already running are not affected
+func ReloadHooks(c Configuration) {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+
+	Config.Actions.Hook = c.Actions.Hook
+	Config.PostConnectHook = c.PostConnectHook
+	Config.SessionEndHook = c.SessionEndHook
+	logger.Info(logSender, "", "hooks reloaded")
+}
+
 // ReloadDefender reloads the defender's block and safe lists
 func ReloadDefender() error {
 	if Config.defender == nil {
@@ -154,6 +316,21 @@ func GetDefenderBanTime(ip string) *time.Time {
 	return Config.defender.GetBanTime(ip)
 }
 
+// Ban bans the specified IP address for the configured ban time.
+// It returns false if the defender is disabled or the IP address is safe listed
+func Ban(ip string) bool {
+	if Config.defender == nil {
+		return false
+	}
+
+	return Config.defender.Ban(ip)
+}
+
+// IsDefenderEnabled returns true if the defender is enabled
+func IsDefenderEnabled() bool {
+	return Config.defender != nil
+}
+
 // Unban removes the specified IP address from the banned ones
 func Unban(ip string) bool {
 	if Config.defender == nil {
@@ -181,6 +358,42 @@ func AddDefenderEvent(ip string, event HostEvent) {
 	Config.defender.AddEvent(ip, event)
 }
 
+// CheckLoginAttempt records a login attempt from the given IP and returns
+// an error if the configured login rate limit or authentication rate limiters
+// are exceeded
+func CheckLoginAttempt(ip, protocol string) error {
+	if err := LimitRate(RateLimiterTargetAuth, protocol, ip); err != nil {
+		return err
+	}
+	if Config.loginThrottler == nil {
+		return nil
+	}
+
+	return Config.loginThrottler.addAttempt(ip)
+}
+
+// DelayFailedLogin records a failed login for the given IP and username and waits
+// for the delay configured for the consecutive failed logins from the given IP
+func DelayFailedLogin(ip, username string) {
+	if Config.loginThrottler == nil {
+		return
+	}
+
+	if delay := Config.loginThrottler.addFailure(ip, username); delay > 0 {
+		logger.Debug(logSender, "", "delaying failed login from ip %#v for %v", ip, delay)
+		time.Sleep(delay)
+	}
+}
+
+// ResetFailedLogins resets the consecutive failed logins for the given IP and username
+func ResetFailedLogins(ip, username string) {
+	if Config.loginThrottler == nil {
+		return
+	}
+
+	Config.loginThrottler.reset(ip, username)
+}
+
 // the ticker cannot be started/stopped from multiple goroutines
 func startIdleTimeoutTicker(duration time.Duration) {
 	stopIdleTimeoutTicker()
@@ -206,14 +419,43 @@ func stopIdleTimeoutTicker() {
 	}
 }
 
+// the ticker cannot be started/stopped from multiple goroutines
+func startStalledTransfersTicker(duration time.Duration) {
+	stopStalledTransfersTicker()
+	stalledTicker = time.NewTicker(duration)
+	stalledTickerDone = make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-stalledTickerDone:
+				return
+			case <-stalledTicker.C:
+				Connections.checkStalledTransfers()
+			}
+		}
+	}()
+}
+
+func stopStalledTransfersTicker() {
+	if stalledTicker != nil {
+		stalledTicker.Stop()
+		stalledTickerDone <- true
+		stalledTicker = nil
+	}
+}
+
 // ActiveTransfer defines the interface for the current active transfers
 type ActiveTransfer interface {
 	GetID() uint64
 	GetType() int
 	GetSize() int64
+	GetExpectedSize() int64
+	GetSpeed() int64
 	GetVirtualPath() string
 	GetStartTime() time.Time
 	SignalClose()
+	TransferError(err error)
+	IsStalled(timeout time.Duration) bool
 	Truncate(fsPath string, size int64) (int64, error)
 	GetRealFsPath(fsPath string) string
 }
@@ -229,10 +471,13 @@ type ActiveConnection interface {
 	GetLastActivity() time.Time
 	GetCommand() string
 	Disconnect() error
-	AddTransfer(t ActiveTransfer)
+	AddTransfer(t ActiveTransfer) error
 	RemoveTransfer(t ActiveTransfer)
 	GetTransfers() []ConnectionTransfer
 	CloseFS() error
+	SetCloseReason(reason string)
+	GetSessionStats() SessionStats
+	SignalStalledTransfers(timeout time.Duration) int
 }
 
 // StatAttributes defines the attributes for set stat commands
@@ -253,6 +498,24 @@ type ConnectionTransfer struct {
 	StartTime     int64  `json:"start_time"`
 	Size          int64  `json:"size"`
 	VirtualPath   string `json:"path"`
+	// current transfer speed as bytes per second
+	Speed int64 `json:"speed"`
+	// bytes this transfer is expected to move, 0 if unknown
+	ExpectedSize int64 `json:"expected_size,omitempty"`
+	// estimated remaining time as seconds, -1 if unknown
+	ETA int64 `json:"eta"`
+}
+
+func (t *ConnectionTransfer) setETA() {
+	t.ETA = -1
+	if t.ExpectedSize <= 0 || t.Speed <= 0 {
+		return
+	}
+	remaining := t.ExpectedSize - t.Size
+	if remaining < 0 {
+		remaining = 0
+	}
+	t.ETA = remaining / t.Speed
 }
 
 func (t *ConnectionTransfer) getConnectionTransferAsString() string {
@@ -266,9 +529,11 @@ func (t *ConnectionTransfer) getConnectionTransferAsString() string {
 	result += fmt.Sprintf("%#v ", t.VirtualPath)
 	if t.Size > 0 {
 		elapsed := time.Since(utils.GetTimeFromMsecSinceEpoch(t.StartTime))
-		speed := float64(t.Size) / float64(utils.GetTimeAsMsSinceEpoch(time.Now())-t.StartTime)
 		result += fmt.Sprintf("Size: %#v Elapsed: %#v Speed: \"%.1f KB/s\"", utils.ByteCountIEC(t.Size),
-			utils.GetDurationAsString(elapsed), speed)
+			utils.GetDurationAsString(elapsed), float64(t.Speed)/1024)
+		if t.ETA >= 0 {
+			result += fmt.Sprintf(" ETA: %#v", utils.GetDurationAsString(time.Duration(t.ETA)*time.Second))
+		}
 	}
 	return result
 }
@@ -288,6 +553,9 @@ type Configuration struct {
 	// file is renamed to the requested path and not deleted, this way a client can reconnect and resume
 	// the upload.
 	UploadMode int `json:"upload_mode" mapstructure:"upload_mode"`
+	// If enabled, the SHA-256 checksum of the uploaded files is computed while they are
+	// uploaded and it is included in the action notifications and in the transfer logs
+	UploadChecksum bool `json:"upload_checksum" mapstructure:"upload_checksum"`
 	// Actions to execute for SFTP file operations and SSH commands
 	Actions ProtocolActions `json:"actions" mapstructure:"actions"`
 	// SetstatMode 0 means "normal mode": requests for changing permissions and owner/group are executed.
@@ -316,13 +584,46 @@ type Configuration struct {
 	// and before he tries to login. It allows you to reject the connection based on the source
 	// ip address. Leave empty do disable.
 	PostConnectHook string `json:"post_connect_hook" mapstructure:"post_connect_hook"`
+	// Absolute path to an external program or an HTTP URL to invoke when a connection ends.
+	// It receives the session statistics. Leave empty to disable
+	SessionEndHook string `json:"session_end_hook" mapstructure:"session_end_hook"`
+	// Time, as seconds, after which a transfer that does not move any data is aborted.
+	// It is independent from the idle timeout. 0 means disabled
+	StalledTransferTimeout int `json:"stalled_transfer_timeout" mapstructure:"stalled_transfer_timeout"`
+	// Periodic scans of the users and virtual folders quotas
+	QuotaScanSchedule QuotaScanScheduleConfig `json:"quota_scan_schedule" mapstructure:"quota_scan_schedule"`
+	// Periodic data retention checks for the users with retention policies
+	DataRetentionSchedule DataRetentionScheduleConfig `json:"data_retention_schedule" mapstructure:"data_retention_schedule"`
+	// Periodic encrypted backups of the data provider contents
+	BackupSchedule BackupScheduleConfig `json:"backup_schedule" mapstructure:"backup_schedule"`
+	// Maximum time, as seconds, to wait for the active transfers to complete on shutdown.
+	// 0 means the connections are closed without waiting
+	GracefulShutdownTimeout int `json:"graceful_shutdown_timeout" mapstructure:"graceful_shutdown_timeout"`
 	// Maximum number of concurrent client connections. 0 means unlimited
 	MaxTotalConnections int `json:"max_total_connections" mapstructure:"max_total_connections"`
 	// Defender configuration
-	DefenderConfig        DefenderConfig `json:"defender" mapstructure:"defender"`
-	idleTimeoutAsDuration time.Duration
-	idleLoginTimeout      time.Duration
-	defender              Defender
+	DefenderConfig DefenderConfig `json:"defender" mapstructure:"defender"`
+	// Delays after failed authentications and per-IP login rate limit
+	LoginThrottling LoginThrottlingConfig `json:"login_throttling" mapstructure:"login_throttling"`
+	// Token bucket rate limiters for new connections and authentication attempts
+	RateLimiters []RateLimiterConfig `json:"rate_limiters" mapstructure:"rate_limiters"`
+	// Global allow and deny lists for the client IP addresses
+	IPLists IPListsConfig `json:"ip_lists" mapstructure:"ip_lists"`
+	// Structured audit log for the file operations
+	AuditLog AuditLogConfig `json:"audit_log" mapstructure:"audit_log"`
+	// Transfer log in xferlog or CEF format
+	TransferLog            TransferLogConfig `json:"transfer_log" mapstructure:"transfer_log"`
+	idleTimeoutAsDuration  time.Duration
+	idleLoginTimeout       time.Duration
+	stalledTransferTimeout time.Duration
+	defender               Defender
+	loginThrottler         *loginThrottler
+	rateLimiters           []*rateLimiter
+	ipLists                *ipLists
+	brokers                []brokerPublisher
+	actionEmails           []*actionEmail
+	auditLog               *auditLog
+	transferLog            *transferLog
 }
 
 // IsAtomicUploadEnabled returns true if atomic upload is enabled
@@ -363,23 +664,49 @@ func (c *Configuration) GetProxyListener(listener net.Listener) (*proxyproto.Lis
 	return proxyListener, nil
 }
 
+// configHooks defines the hooks that can be reloaded at runtime
+type configHooks struct {
+	actions     string
+	postConnect string
+	sessionEnd  string
+}
+
+func (c *Configuration) getHooks() configHooks {
+	hooksLock.RLock()
+	defer hooksLock.RUnlock()
+
+	return configHooks{
+		actions:     c.Actions.Hook,
+		postConnect: c.PostConnectHook,
+		sessionEnd:  c.SessionEndHook,
+	}
+}
+
 // ExecutePostConnectHook executes the post connect hook if defined
-func (c *Configuration) ExecutePostConnectHook(ipAddr, protocol string) error {
-	if c.PostConnectHook == "" {
+func (c *Configuration) ExecutePostConnectHook(ipAddr, protocol string) (err error) {
+	hook := c.getHooks().postConnect
+	if hook == "" {
 		return nil
 	}
-	if strings.HasPrefix(c.PostConnectHook, "http") {
+	startTime := time.Now()
+	defer func() {
+		diagnostics.HookExecuted(diagnostics.HookPostConnect, time.Since(startTime), err)
+	}()
+
+	country := geoip.GetCountry(ipAddr)
+	if strings.HasPrefix(hook, "http") {
 		var url *url.URL
-		url, err := url.Parse(c.PostConnectHook)
+		url, err := url.Parse(hook)
 		if err != nil {
 			logger.Warn(protocol, "", "Login from ip %#v denied, invalid post connect hook %#v: %v",
-				ipAddr, c.PostConnectHook, err)
+				ipAddr, hook, err)
 			return err
 		}
 		httpClient := httpclient.GetRetraybleHTTPClient()
 		q := url.Query()
 		q.Add("ip", ipAddr)
 		q.Add("protocol", protocol)
+		q.Add("country", country)
 		url.RawQuery = q.Encode()
 
 		resp, err := httpClient.Get(url.String())
@@ -394,18 +721,19 @@ func (c *Configuration) ExecutePostConnectHook(ipAddr, protocol string) error {
 		}
 		return nil
 	}
-	if !filepath.IsAbs(c.PostConnectHook) {
-		err := fmt.Errorf("invalid post connect hook %#v", c.PostConnectHook)
+	if !filepath.IsAbs(hook) {
+		err := fmt.Errorf("invalid post connect hook %#v", hook)
 		logger.Warn(protocol, "", "Login from ip %#v denied: %v", ipAddr, err)
 		return err
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, c.PostConnectHook)
+	cmd := exec.CommandContext(ctx, hook)
 	cmd.Env = append(os.Environ(),
 		fmt.Sprintf("SFTPGO_CONNECTION_IP=%v", ipAddr),
-		fmt.Sprintf("SFTPGO_CONNECTION_PROTOCOL=%v", protocol))
-	err := cmd.Run()
+		fmt.Sprintf("SFTPGO_CONNECTION_PROTOCOL=%v", protocol),
+		fmt.Sprintf("SFTPGO_CONNECTION_COUNTRY=%v", country))
+	err = cmd.Run()
 	if err != nil {
 		logger.Warn(protocol, "", "Login from ip %#v denied, connect hook error: %v", ipAddr, err)
 	}
@@ -454,6 +782,12 @@ type ActiveConnections struct {
 	sync.RWMutex
 	connections    []ActiveConnection
 	sshConnections []*SSHConnection
+	// users with debug logs enabled for all their connections
+	debugUsers map[string]bool
+	// active transfers for each username, protected by transfersMu so the
+	// concurrent transfers limit can be checked and reserved atomically
+	transfersMu sync.Mutex
+	transfers   map[string]int
 }
 
 // GetActiveSessions returns the number of active sessions for the given username.
@@ -471,12 +805,73 @@ func (conns *ActiveConnections) GetActiveSessions(username string) int {
 	return numSessions
 }
 
+// GetActiveProtocolSessions returns the number of active sessions for the given username
+// and login protocol: SSH, FTP or DAV. SFTP, SCP and SSH commands sessions are counted as SSH
+func (conns *ActiveConnections) GetActiveProtocolSessions(username, protocol string) int {
+	conns.RLock()
+	defer conns.RUnlock()
+
+	numSessions := 0
+	for _, c := range conns.connections {
+		if c.GetUsername() == username && getLoginProtocol(c.GetProtocol()) == protocol {
+			numSessions++
+		}
+	}
+	return numSessions
+}
+
+// getLoginProtocol returns the protocol used to login for the given connection protocol
+func getLoginProtocol(protocol string) string {
+	switch protocol {
+	case ProtocolSFTP, ProtocolSCP, ProtocolSSH:
+		return ProtocolSSH
+	default:
+		return protocol
+	}
+}
+
+// GetActiveTransfers returns the number of active transfers for the given username.
+// We return the transfers for any session and protocol
+func (conns *ActiveConnections) GetActiveTransfers(username string) int {
+	conns.transfersMu.Lock()
+	defer conns.transfersMu.Unlock()
+
+	return conns.transfers[username]
+}
+
+// addTransfer adds a transfer for the given username if the user has less than
+// maxTransfers active transfers, 0 means unlimited
+func (conns *ActiveConnections) addTransfer(username string, maxTransfers int) error {
+	conns.transfersMu.Lock()
+	defer conns.transfersMu.Unlock()
+
+	if maxTransfers > 0 && conns.transfers[username] >= maxTransfers {
+		return ErrTooManyTransfers
+	}
+	if conns.transfers == nil {
+		conns.transfers = make(map[string]int)
+	}
+	conns.transfers[username]++
+	return nil
+}
+
+func (conns *ActiveConnections) removeTransfer(username string) {
+	conns.transfersMu.Lock()
+	defer conns.transfersMu.Unlock()
+
+	conns.transfers[username]--
+	if conns.transfers[username] <= 0 {
+		delete(conns.transfers, username)
+	}
+}
+
 // Add adds a new connection to the active ones
 func (conns *ActiveConnections) Add(c ActiveConnection) {
 	conns.Lock()
 	defer conns.Unlock()
 
 	conns.connections = append(conns.connections, c)
+	conns.setConnectionDebug(c)
 	metrics.UpdateActiveConnectionsSize(len(conns.connections))
 	logger.Debug(c.GetProtocol(), c.GetID(), "connection added, num open connections: %v", len(conns.connections))
 }
@@ -493,6 +888,7 @@ func (conns *ActiveConnections) Swap(c ActiveConnection) error {
 		if conn.GetID() == c.GetID() {
 			conn = nil
 			conns.connections[idx] = c
+			conns.setConnectionDebug(c)
 			return nil
 		}
 	}
@@ -514,12 +910,68 @@ func (conns *ActiveConnections) Remove(connectionID string) {
 			metrics.UpdateActiveConnectionsSize(lastIdx)
 			logger.Debug(conn.GetProtocol(), conn.GetID(), "connection removed, close fs error: %v, num open connections: %v",
 				err, lastIdx)
+			logger.DisableConnectionDebug(connectionID)
+			notifySessionEnd(conn)
+			saveConnectionRecord(conn)
 			return
 		}
 	}
 	logger.Warn(logSender, "", "connection id %#v to remove not found!", connectionID)
 }
 
+// setConnectionDebug enables the debug logs for the given connection if they
+// are enabled for its user. It must be called with the lock held
+func (conns *ActiveConnections) setConnectionDebug(c ActiveConnection) {
+	if username := c.GetUsername(); username != "" && conns.debugUsers[username] {
+		logger.EnableConnectionDebug(c.GetID())
+	}
+}
+
+// EnableUserDebug enables the debug logs for the current and future connections of the given user
+func (conns *ActiveConnections) EnableUserDebug(username string) {
+	conns.Lock()
+	defer conns.Unlock()
+
+	if conns.debugUsers == nil {
+		conns.debugUsers = make(map[string]bool)
+	}
+	conns.debugUsers[username] = true
+	for _, c := range conns.connections {
+		conns.setConnectionDebug(c)
+	}
+}
+
+// DisableUserDebug disables the debug logs for the connections of the given user.
+// It returns false if the debug logs were not enabled for this user
+func (conns *ActiveConnections) DisableUserDebug(username string) bool {
+	conns.Lock()
+	defer conns.Unlock()
+
+	if !conns.debugUsers[username] {
+		return false
+	}
+	delete(conns.debugUsers, username)
+	for _, c := range conns.connections {
+		if c.GetUsername() == username {
+			logger.DisableConnectionDebug(c.GetID())
+		}
+	}
+	return true
+}
+
+// GetDebugUsers returns the users with debug logs enabled
+func (conns *ActiveConnections) GetDebugUsers() []string {
+	conns.RLock()
+	defer conns.RUnlock()
+
+	result := make([]string, 0, len(conns.debugUsers))
+	for username := range conns.debugUsers {
+		result = append(result, username)
+	}
+	sort.Strings(result)
+	return result
+}
+
 // Close closes an active connection.
 // It returns true on success
 func (conns *ActiveConnections) Close(connectionID string) bool {
@@ -528,6 +980,7 @@ func (conns *ActiveConnections) Close(connectionID string) bool {
 
 	for _, c := range conns.connections {
 		if c.GetID() == connectionID {
+			c.SetCloseReason(SessionEndReasonClosed)
 			defer func(conn ActiveConnection) {
 				err := conn.Disconnect()
 				logger.Debug(conn.GetProtocol(), conn.GetID(), "close connection requested, close err: %v", err)
@@ -598,16 +1051,18 @@ func (conns *ActiveConnections) checkIdles() {
 		isUnauthenticatedFTPUser := (c.GetProtocol() == ProtocolFTP && c.GetUsername() == "")
 
 		if idleTime > Config.idleTimeoutAsDuration || (isUnauthenticatedFTPUser && idleTime > Config.idleLoginTimeout) {
+			c.SetCloseReason(SessionEndReasonIdleTimeout)
 			defer func(conn ActiveConnection, isFTPNoAuth bool) {
 				err := conn.Disconnect()
 				logger.Debug(conn.GetProtocol(), conn.GetID(), "close idle connection, idle time: %v, username: %#v close err: %v",
 					time.Since(conn.GetLastActivity()), conn.GetUsername(), err)
 				if isFTPNoAuth {
-					ip := utils.GetIPFromRemoteAddress(c.GetRemoteAddress())
-					logger.ConnectionFailedLog("", ip, dataprovider.LoginMethodNoAuthTryed, c.GetProtocol(), "client idle")
+					ip := utils.GetIPFromRemoteAddress(conn.GetRemoteAddress())
+					logger.ConnectionFailedLog("", ip, geoip.GetCountry(ip), dataprovider.LoginMethodNoAuthTryed,
+						conn.GetProtocol(), "client idle")
 					metrics.AddNoAuthTryed()
 					AddDefenderEvent(ip, HostEventNoLoginTried)
-					dataprovider.ExecutePostLoginHook(&dataprovider.User{}, dataprovider.LoginMethodNoAuthTryed, ip, c.GetProtocol(),
+					dataprovider.ExecutePostLoginHook(&dataprovider.User{}, dataprovider.LoginMethodNoAuthTryed, ip, conn.GetProtocol(),
 						dataprovider.ErrNoAuthTryed)
 				}
 			}(c, isUnauthenticatedFTPUser)
@@ -617,6 +1072,22 @@ func (conns *ActiveConnections) checkIdles() {
 	conns.RUnlock()
 }
 
+func (conns *ActiveConnections) checkStalledTransfers() {
+	conns.RLock()
+
+	for _, c := range conns.connections {
+		// SSH commands, for example rsync or git, can have streams without data for a long time
+		if c.GetProtocol() == ProtocolSSH {
+			continue
+		}
+		// only the stalled transfers are aborted, the other transfers for the
+		// same connection are not affected
+		c.SignalStalledTransfers(Config.stalledTransferTimeout)
+	}
+
+	conns.RUnlock()
+}
+
 // IsNewConnectionAllowed returns false if the maximum number of concurrent allowed connections is exceeded
 func (conns *ActiveConnections) IsNewConnectionAllowed() bool {
 	if Config.MaxTotalConnections == 0 {
@@ -794,6 +1265,19 @@ func (s *ActiveScans) GetVFoldersQuotaScans() []ActiveVirtualFolderQuotaScan {
 	return scans
 }
 
+// GetVFolderQuotaScan returns the active quota scan for the given virtual folder, if any
+func (s *ActiveScans) GetVFolderQuotaScan(folderName string) (ActiveVirtualFolderQuotaScan, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, scan := range s.FolderScans {
+		if scan.Name == folderName {
+			return scan, true
+		}
+	}
+	return ActiveVirtualFolderQuotaScan{}, false
+}
+
 // AddVFolderQuotaScan adds a virtual folder to the ones with active quota scans.
 // Returns false if the folder has a quota scan already running
 func (s *ActiveScans) AddVFolderQuotaScan(folderName string) bool {
@@ -244,6 +244,8 @@ func TestDefenderIntegration(t *testing.T) {
 
 	assert.Nil(t, GetDefenderBanTime(ip))
 	assert.False(t, Unban(ip))
+	assert.False(t, Ban(ip))
+	assert.False(t, IsDefenderEnabled())
 	assert.Equal(t, 0, GetDefenderScore(ip))
 
 	Config.DefenderConfig = DefenderConfig{
@@ -278,6 +280,11 @@ func TestDefenderIntegration(t *testing.T) {
 	assert.Nil(t, GetDefenderBanTime(ip))
 	assert.False(t, Unban(ip))
 
+	assert.True(t, IsDefenderEnabled())
+	assert.True(t, Ban(ip))
+	assert.True(t, IsBanned(ip))
+	assert.True(t, Unban(ip))
+
 	Config = configCopy
 }
 
@@ -343,6 +350,8 @@ func TestIdleConnections(t *testing.T) {
 	Connections.AddSSHConnection(sshConn2)
 	Connections.Add(fakeConn)
 	assert.Equal(t, Connections.GetActiveSessions(username), 2)
+	assert.Equal(t, 2, Connections.GetActiveProtocolSessions(username, ProtocolSSH))
+	assert.Equal(t, 0, Connections.GetActiveProtocolSessions(username, ProtocolFTP))
 
 	cFTP := NewBaseConnection("id2", ProtocolFTP, dataprovider.User{}, nil)
 	cFTP.lastActivity = time.Now().UnixNano()
@@ -382,6 +391,62 @@ func TestIdleConnections(t *testing.T) {
 	Config = configCopy
 }
 
+func TestStalledTransfers(t *testing.T) {
+	configCopy := Config
+
+	Config.StalledTransferTimeout = 1
+	err := Initialize(Config)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, Config.stalledTransferTimeout)
+	stopStalledTransfersTicker()
+
+	fs := vfs.NewOsFs("", os.TempDir(), nil)
+	c := NewBaseConnection("id_stalled", ProtocolSFTP, dataprovider.User{}, fs)
+	fakeConn := &fakeConnection{
+		BaseConnection: c,
+	}
+	cSSH := NewBaseConnection("id_ssh_stalled", ProtocolSSH, dataprovider.User{}, fs)
+	fakeSSHConn := &fakeConnection{
+		BaseConnection: cSSH,
+	}
+	Connections.Add(fakeConn)
+	Connections.Add(fakeSSHConn)
+	stalledTransfer, err := NewBaseTransfer(nil, c, nil, "", "/stalled", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	activeTransfer, err := NewBaseTransfer(nil, c, nil, "", "/active", TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
+	sshTransfer, err := NewBaseTransfer(nil, cSSH, nil, "", "/ssh", TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
+	stalledTransfer.lastProgress = time.Now().Add(-2 * time.Second)
+	activeTransfer.lastProgress = stalledTransfer.lastProgress
+	sshTransfer.lastProgress = stalledTransfer.lastProgress
+	activeTransfer.BytesSent = 100
+
+	assert.False(t, activeTransfer.IsStalled(time.Second))
+	assert.True(t, stalledTransfer.IsStalled(time.Second))
+	assert.False(t, stalledTransfer.IsStalled(time.Minute))
+	activeTransfer.lastProgress = stalledTransfer.lastProgress
+
+	startStalledTransfersTicker(100 * time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&stalledTransfer.AbortTransfer) == 1
+	}, 1*time.Second, 50*time.Millisecond)
+	stopStalledTransfersTicker()
+	assert.ErrorIs(t, stalledTransfer.ErrTransfer, ErrTransferStalled)
+	assert.ErrorIs(t, activeTransfer.ErrTransfer, ErrTransferStalled)
+	assert.NoError(t, sshTransfer.ErrTransfer)
+	// the connection is not closed, only the stalled transfers are aborted
+	assert.Len(t, Connections.GetStats(), 2)
+	assert.Equal(t, SessionEndReasonClient, c.GetSessionStats().Reason)
+	// failed transfers are not reported as stalled
+	assert.False(t, stalledTransfer.IsStalled(time.Second))
+	assert.Equal(t, 0, c.SignalStalledTransfers(time.Second))
+
+	Connections.Remove(fakeConn.GetID())
+	Connections.Remove(fakeSSHConn.GetID())
+	Config = configCopy
+}
+
 func TestCloseConnection(t *testing.T) {
 	c := NewBaseConnection("id", ProtocolSFTP, dataprovider.User{}, nil)
 	fakeConn := &fakeConnection{
@@ -425,6 +490,57 @@ func TestSwapConnection(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestConnectionDebugLogs(t *testing.T) {
+	username := "test_user_debug"
+	c1 := NewBaseConnection("id_debug1", ProtocolSFTP, dataprovider.User{Username: username}, nil)
+	fakeConn1 := &fakeConnection{
+		BaseConnection: c1,
+	}
+	Connections.Add(fakeConn1)
+	assert.False(t, logger.IsConnectionDebugEnabled(fakeConn1.GetID()))
+	assert.False(t, Connections.DisableUserDebug(username))
+
+	Connections.EnableUserDebug(username)
+	assert.Equal(t, []string{username}, Connections.GetDebugUsers())
+	assert.True(t, logger.IsConnectionDebugEnabled(fakeConn1.GetID()))
+	// a connection authenticated after enabling the debug logs for its user
+	c2 := NewBaseConnection("id_debug2", ProtocolFTP, dataprovider.User{}, nil)
+	fakeConn2 := &fakeConnection{
+		BaseConnection: c2,
+	}
+	Connections.Add(fakeConn2)
+	assert.False(t, logger.IsConnectionDebugEnabled(fakeConn2.GetID()))
+	c2 = NewBaseConnection("id_debug2", ProtocolFTP, dataprovider.User{Username: username}, nil)
+	fakeConn2 = &fakeConnection{
+		BaseConnection: c2,
+	}
+	err := Connections.Swap(fakeConn2)
+	assert.NoError(t, err)
+	assert.True(t, logger.IsConnectionDebugEnabled(fakeConn2.GetID()))
+	assert.Equal(t, []string{fakeConn2.GetID(), fakeConn1.GetID()}, logger.GetDebugConnections())
+
+	assert.True(t, Connections.DisableUserDebug(username))
+	assert.Len(t, Connections.GetDebugUsers(), 0)
+	assert.False(t, logger.IsConnectionDebugEnabled(fakeConn1.GetID()))
+	assert.False(t, logger.IsConnectionDebugEnabled(fakeConn2.GetID()))
+	// the override is removed when the connection ends
+	logger.EnableConnectionDebug(fakeConn1.GetID())
+	Connections.Remove(fakeConn1.GetID())
+	assert.False(t, logger.IsConnectionDebugEnabled(fakeConn1.GetID()))
+	assert.False(t, logger.DisableConnectionDebug(fakeConn1.GetID()))
+	Connections.Remove(fakeConn2.GetID())
+	assert.Len(t, Connections.GetStats(), 0)
+	assert.Len(t, logger.GetDebugConnections(), 0)
+}
+
+func TestRuntimeLogLevel(t *testing.T) {
+	level := logger.GetLevel()
+	logger.SetLevel(zerolog.WarnLevel)
+	assert.Equal(t, zerolog.WarnLevel, logger.GetLevel())
+	logger.SetLevel(level)
+	assert.Equal(t, level, logger.GetLevel())
+}
+
 func TestAtomicUpload(t *testing.T) {
 	configCopy := Config
 
@@ -438,6 +554,71 @@ func TestAtomicUpload(t *testing.T) {
 	Config = configCopy
 }
 
+func TestConcurrentTransfersLimit(t *testing.T) {
+	username := "test_user_transfers"
+	user := dataprovider.User{
+		Username: username,
+	}
+	user.Filters.MaxConcurrentTransfers = 2
+	fs := vfs.NewOsFs("", os.TempDir(), nil)
+	c1 := NewBaseConnection("id_transfers1", ProtocolSFTP, user, fs)
+	fakeConn1 := &fakeConnection{
+		BaseConnection: c1,
+	}
+	c2 := NewBaseConnection("id_transfers2", ProtocolFTP, user, fs)
+	fakeConn2 := &fakeConnection{
+		BaseConnection: c2,
+	}
+	Connections.Add(fakeConn1)
+	Connections.Add(fakeConn2)
+
+	assert.NoError(t, c1.CheckTransfersLimit())
+	t1, err := NewBaseTransfer(nil, c1, nil, "/p1", "/r1", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	assert.NoError(t, c2.CheckTransfersLimit())
+	t2, err := NewBaseTransfer(nil, c2, nil, "/p2", "/r2", TransferDownload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	assert.Equal(t, 2, Connections.GetActiveTransfers(username))
+	assert.ErrorIs(t, c1.CheckTransfersLimit(), ErrTooManyTransfers)
+	assert.ErrorIs(t, c2.CheckTransfersLimit(), ErrTooManyTransfers)
+	// the limit is enforced when the transfer is added, even if the check is skipped
+	_, err = NewBaseTransfer(nil, c1, nil, "/p4", "/r4", TransferDownload, 0, 0, 0, false, fs)
+	assert.ErrorIs(t, err, ErrTooManyTransfers)
+	// the file created for a denied upload is closed and removed
+	uploadPath := filepath.Join(os.TempDir(), "denied_upload")
+	file, err := os.Create(uploadPath)
+	require.NoError(t, err)
+	cancelled := false
+	_, err = NewBaseTransfer(file, c2, func() { cancelled = true }, uploadPath, "/denied_upload", TransferUpload,
+		0, 0, 0, true, fs)
+	assert.ErrorIs(t, err, ErrTooManyTransfers)
+	assert.True(t, cancelled)
+	assert.NoFileExists(t, uploadPath)
+	assert.Equal(t, 2, Connections.GetActiveTransfers(username))
+	assert.Len(t, c1.GetTransfers(), 1)
+
+	err = t1.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, Connections.GetActiveTransfers(username))
+	assert.NoError(t, c2.CheckTransfersLimit())
+
+	c2.User.Filters.MaxConcurrentTransfers = 0
+	t3, err := NewBaseTransfer(nil, c2, nil, "/p3", "/r3", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	assert.NoError(t, c2.CheckTransfersLimit())
+	assert.ErrorIs(t, c1.CheckTransfersLimit(), ErrTooManyTransfers)
+
+	err = t2.Close()
+	assert.NoError(t, err)
+	err = t3.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, Connections.GetActiveTransfers(username))
+
+	Connections.Remove(fakeConn1.GetID())
+	Connections.Remove(fakeConn2.GetID())
+	assert.Len(t, Connections.GetStats(), 0)
+}
+
 func TestConnectionStatus(t *testing.T) {
 	username := "test_user"
 	user := dataprovider.User{
@@ -448,9 +629,11 @@ func TestConnectionStatus(t *testing.T) {
 	fakeConn1 := &fakeConnection{
 		BaseConnection: c1,
 	}
-	t1 := NewBaseTransfer(nil, c1, nil, "/p1", "/r1", TransferUpload, 0, 0, 0, true, fs)
+	t1, err := NewBaseTransfer(nil, c1, nil, "/p1", "/r1", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
 	t1.BytesReceived = 123
-	t2 := NewBaseTransfer(nil, c1, nil, "/p2", "/r2", TransferDownload, 0, 0, 0, true, fs)
+	t2, err := NewBaseTransfer(nil, c1, nil, "/p2", "/r2", TransferDownload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
 	t2.BytesSent = 456
 	c2 := NewBaseConnection("id2", ProtocolSSH, user, nil)
 	fakeConn2 := &fakeConnection{
@@ -462,7 +645,8 @@ func TestConnectionStatus(t *testing.T) {
 		BaseConnection: c3,
 		command:        "PROPFIND",
 	}
-	t3 := NewBaseTransfer(nil, c3, nil, "/p2", "/r2", TransferDownload, 0, 0, 0, true, fs)
+	t3, err := NewBaseTransfer(nil, c3, nil, "/p2", "/r2", TransferDownload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
 	Connections.Add(fakeConn1)
 	Connections.Add(fakeConn2)
 	Connections.Add(fakeConn3)
@@ -491,7 +675,7 @@ func TestConnectionStatus(t *testing.T) {
 		}
 	}
 
-	err := t1.Close()
+	err = t1.Close()
 	assert.NoError(t, err)
 	err = t2.Close()
 	assert.NoError(t, err)
@@ -536,10 +720,18 @@ func TestQuotaScans(t *testing.T) {
 	if assert.Len(t, QuotaScans.GetVFoldersQuotaScans(), 1) {
 		assert.Equal(t, QuotaScans.GetVFoldersQuotaScans()[0].Name, folderName)
 	}
+	scan, ok := QuotaScans.GetVFolderQuotaScan(folderName)
+	assert.True(t, ok)
+	assert.Equal(t, folderName, scan.Name)
+	assert.Greater(t, scan.StartTime, int64(0))
+	_, ok = QuotaScans.GetVFolderQuotaScan("missing")
+	assert.False(t, ok)
 
 	assert.True(t, QuotaScans.RemoveVFolderQuotaScan(folderName))
 	assert.False(t, QuotaScans.RemoveVFolderQuotaScan(folderName))
 	assert.Len(t, QuotaScans.GetVFoldersQuotaScans(), 0)
+	_, ok = QuotaScans.GetVFolderQuotaScan(folderName)
+	assert.False(t, ok)
 }
 
 func TestProxyProtocolVersion(t *testing.T) {
@@ -612,6 +804,29 @@ func TestPostConnectHook(t *testing.T) {
 	Config.PostConnectHook = ""
 }
 
+func TestReloadHooks(t *testing.T) {
+	oldConfig := Config
+	defer func() {
+		Config = oldConfig
+	}()
+
+	Config.IdleTimeout = 10
+	c := Configuration{
+		IdleTimeout:     20,
+		PostConnectHook: fmt.Sprintf("http://%v", httpAddr),
+		SessionEndHook:  "/path/to/session_end",
+	}
+	c.Actions.Hook = "/path/to/action"
+	ReloadHooks(c)
+	hooks := Config.getHooks()
+	assert.Equal(t, c.Actions.Hook, hooks.actions)
+	assert.Equal(t, c.PostConnectHook, hooks.postConnect)
+	assert.Equal(t, c.SessionEndHook, hooks.sessionEnd)
+	// only the hooks are reloaded
+	assert.Equal(t, 10, Config.IdleTimeout)
+	assert.NoError(t, Config.ExecutePostConnectHook("127.0.0.1", ProtocolFTP))
+}
+
 func TestCryptoConvertFileInfo(t *testing.T) {
 	name := "name"
 	fs, err := vfs.NewCryptFs("connID1", os.TempDir(), vfs.CryptFsConfig{Passphrase: kms.NewPlainSecret("secret")})
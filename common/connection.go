@@ -23,6 +23,11 @@ type BaseConnection struct {
 	// last activity for this connection.
 	// Since this is accessed atomically we put as first element of the struct achieve 64 bit alignment
 	lastActivity int64
+	// transfer statistics for this session, accessed atomically
+	bytesUploaded   int64
+	bytesDownloaded int64
+	filesUploaded   int64
+	filesDownloaded int64
 	// Unique identifier for the connection
 	ID string
 	// user associated with this connection if any
@@ -31,9 +36,12 @@ type BaseConnection struct {
 	startTime time.Time
 	protocol  string
 	Fs        vfs.Fs
+	// client IP address, used to evaluate the per-source bandwidth limits
+	remoteIP string
 	sync.RWMutex
 	transferID      uint64
 	activeTransfers []ActiveTransfer
+	closeReason     string
 }
 
 // NewBaseConnection returns a new BaseConnection
@@ -55,7 +63,7 @@ func NewBaseConnection(id, protocol string, user dataprovider.User, fs vfs.Fs) *
 
 // Log outputs a log entry to the configured logger
 func (c *BaseConnection) Log(level logger.LogLevel, format string, v ...interface{}) {
-	logger.Log(level, c.protocol, c.ID, format, v...)
+	logger.ConnectionLog(level, c.protocol, c.ID, c.User.Username, format, v...)
 }
 
 // GetTransferID returns an unique transfer ID for this connection
@@ -78,6 +86,12 @@ func (c *BaseConnection) GetProtocol() string {
 	return c.protocol
 }
 
+// SetRemoteAddress sets the client remote address for this connection.
+// It must be called before starting any transfer
+func (c *BaseConnection) SetRemoteAddress(remoteAddr string) {
+	c.remoteIP = utils.GetIPFromRemoteAddress(remoteAddr)
+}
+
 // SetProtocol sets the protocol for this connection
 func (c *BaseConnection) SetProtocol(protocol string) {
 	c.protocol = protocol
@@ -91,6 +105,56 @@ func (c *BaseConnection) GetConnectionTime() time.Time {
 	return c.startTime
 }
 
+// SetCloseReason sets the reason for closing this connection.
+// Only the first reason set is retained
+func (c *BaseConnection) SetCloseReason(reason string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closeReason == "" {
+		c.closeReason = reason
+	}
+}
+
+// GetSessionStats returns the statistics for this connection
+func (c *BaseConnection) GetSessionStats() SessionStats {
+	c.RLock()
+	reason := c.closeReason
+	c.RUnlock()
+
+	if reason == "" {
+		reason = SessionEndReasonClient
+	}
+	now := time.Now()
+	return SessionStats{
+		ConnectionID:    c.ID,
+		Username:        c.User.Username,
+		Protocol:        c.protocol,
+		StartTime:       utils.GetTimeAsMsSinceEpoch(c.startTime),
+		EndTime:         utils.GetTimeAsMsSinceEpoch(now),
+		Duration:        now.Sub(c.startTime).Milliseconds(),
+		BytesUploaded:   atomic.LoadInt64(&c.bytesUploaded),
+		BytesDownloaded: atomic.LoadInt64(&c.bytesDownloaded),
+		FilesUploaded:   atomic.LoadInt64(&c.filesUploaded),
+		FilesDownloaded: atomic.LoadInt64(&c.filesDownloaded),
+		Reason:          reason,
+	}
+}
+
+// updateSessionStats adds the given transfer to the session statistics
+func (c *BaseConnection) updateSessionStats(transferType int, bytesReceived, bytesSent int64, completed bool) {
+	atomic.AddInt64(&c.bytesUploaded, bytesReceived)
+	atomic.AddInt64(&c.bytesDownloaded, bytesSent)
+	if !completed {
+		return
+	}
+	if transferType == TransferUpload {
+		atomic.AddInt64(&c.filesUploaded, 1)
+	} else {
+		atomic.AddInt64(&c.filesDownloaded, 1)
+	}
+}
+
 // UpdateLastActivity updates last activity for this connection
 func (c *BaseConnection) UpdateLastActivity() {
 	atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
@@ -109,13 +173,48 @@ func (c *BaseConnection) CloseFS() error {
 	return nil
 }
 
-// AddTransfer associates a new transfer to this connection
-func (c *BaseConnection) AddTransfer(t ActiveTransfer) {
+// AddTransfer associates a new transfer to this connection. The transfer is denied
+// if the user already has the maximum allowed concurrent transfers, for all the
+// user sessions, or if the server is shutting down
+func (c *BaseConnection) AddTransfer(t ActiveTransfer) error {
+	if IsShuttingDown() {
+		c.Log(logger.LevelInfo, "transfer denied, the server is shutting down")
+		return ErrShuttingDown
+	}
+	if err := Connections.addTransfer(c.User.Username, c.User.Filters.MaxConcurrentTransfers); err != nil {
+		c.Log(logger.LevelInfo, "transfer denied, max allowed concurrent transfers: %v",
+			c.User.Filters.MaxConcurrentTransfers)
+		return err
+	}
+
 	c.Lock()
 	defer c.Unlock()
 
 	c.activeTransfers = append(c.activeTransfers, t)
 	c.Log(logger.LevelDebug, "transfer added, id: %v, active transfers: %v", t.GetID(), len(c.activeTransfers))
+	return nil
+}
+
+// CheckTransfersLimit returns an error if the user already has the maximum allowed
+// concurrent transfers or if the server is shutting down. The transfers for all the
+// user sessions are counted. It should be called before opening the file for a new
+// upload or download, so a denied upload does not truncate an existing file. The
+// limit is enforced atomically when the transfer is added
+func (c *BaseConnection) CheckTransfersLimit() error {
+	if IsShuttingDown() {
+		c.Log(logger.LevelInfo, "transfer denied, the server is shutting down")
+		return ErrShuttingDown
+	}
+	if c.User.Filters.MaxConcurrentTransfers <= 0 {
+		return nil
+	}
+	activeTransfers := Connections.GetActiveTransfers(c.User.Username)
+	if activeTransfers >= c.User.Filters.MaxConcurrentTransfers {
+		c.Log(logger.LevelInfo, "transfer denied, active transfers: %v, max allowed: %v", activeTransfers,
+			c.User.Filters.MaxConcurrentTransfers)
+		return ErrTooManyTransfers
+	}
+	return nil
 }
 
 // RemoveTransfer removes the specified transfer from the active ones
@@ -134,6 +233,7 @@ func (c *BaseConnection) RemoveTransfer(t ActiveTransfer) {
 		c.activeTransfers[indexToRemove] = c.activeTransfers[len(c.activeTransfers)-1]
 		c.activeTransfers[len(c.activeTransfers)-1] = nil
 		c.activeTransfers = c.activeTransfers[:len(c.activeTransfers)-1]
+		Connections.removeTransfer(c.User.Username)
 		c.Log(logger.LevelDebug, "transfer removed, id: %v active transfers: %v", t.GetID(), len(c.activeTransfers))
 	} else {
 		c.Log(logger.LevelWarn, "transfer to remove not found!")
@@ -154,18 +254,41 @@ func (c *BaseConnection) GetTransfers() []ConnectionTransfer {
 		case TransferUpload:
 			operationType = operationUpload
 		}
-		transfers = append(transfers, ConnectionTransfer{
+		transfer := ConnectionTransfer{
 			ID:            t.GetID(),
 			OperationType: operationType,
 			StartTime:     utils.GetTimeAsMsSinceEpoch(t.GetStartTime()),
 			Size:          t.GetSize(),
 			VirtualPath:   t.GetVirtualPath(),
-		})
+			Speed:         t.GetSpeed(),
+			ExpectedSize:  t.GetExpectedSize(),
+		}
+		transfer.setETA()
+		transfers = append(transfers, transfer)
 	}
 
 	return transfers
 }
 
+// SignalStalledTransfers marks as failed the transfers that did not move any data
+// within the given timeout and returns the number of stalled transfers
+func (c *BaseConnection) SignalStalledTransfers(timeout time.Duration) int {
+	c.RLock()
+	defer c.RUnlock()
+
+	stalled := 0
+	for _, t := range c.activeTransfers {
+		if t.IsStalled(timeout) {
+			c.Log(logger.LevelInfo, "transfer id %v for path %#v stalled, size: %v, aborting", t.GetID(),
+				t.GetVirtualPath(), t.GetSize())
+			t.TransferError(ErrTransferStalled)
+			t.SignalClose()
+			stalled++
+		}
+	}
+	return stalled
+}
+
 // SignalTransfersAbort signals to the active transfers to exit as soon as possible
 func (c *BaseConnection) SignalTransfersAbort() error {
 	c.RLock()
@@ -220,8 +343,37 @@ func (c *BaseConnection) ListDir(fsPath, virtualPath string) ([]os.FileInfo, err
 	return c.User.AddVirtualDirs(files, virtualPath), nil
 }
 
+// RestorePartialUpload restores, as the given fsPath, the partial file kept by an interrupted
+// atomic upload for the "keep" partial uploads policy, so the client can resume the upload.
+// It returns the info for the restored file or an error if there is nothing to restore
+func (c *BaseConnection) RestorePartialUpload(fsPath, virtualPath string) (os.FileInfo, error) {
+	if c.User.Filters.PartialUploads.Policy != dataprovider.PartialUploadsKeep ||
+		!Config.IsAtomicUploadEnabled() || !c.Fs.IsAtomicUploadSupported() {
+		return nil, c.GetNotExistError()
+	}
+	partialPath := fsPath + partialUploadSuffix
+	info, err := c.Fs.Lstat(partialPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, c.GetOpUnsupportedError()
+	}
+	if err := c.Fs.Rename(partialPath, fsPath); err != nil {
+		c.Log(logger.LevelWarn, "unable to restore partial upload %#v as %#v: %v", partialPath, fsPath, err)
+		return nil, err
+	}
+	c.Log(logger.LevelDebug, "partial upload %#v restored to resume the upload for %#v, size: %v", partialPath,
+		virtualPath, info.Size())
+	return info, nil
+}
+
 // CreateDir creates a new directory at the specified fsPath
-func (c *BaseConnection) CreateDir(fsPath, virtualPath string) error {
+func (c *BaseConnection) CreateDir(fsPath, virtualPath string) (err error) {
+	defer func() {
+		c.auditFileOperation(auditActionMkdir, virtualPath, "", -1, err)
+	}()
+
 	if !c.User.HasPerm(dataprovider.PermCreateDirs, path.Dir(virtualPath)) {
 		return c.GetPermissionDeniedError()
 	}
@@ -229,6 +381,9 @@ func (c *BaseConnection) CreateDir(fsPath, virtualPath string) error {
 		c.Log(logger.LevelWarn, "mkdir not allowed %#v is a virtual folder", virtualPath)
 		return c.GetPermissionDeniedError()
 	}
+	if err := c.CheckDirectoryLimit(virtualPath); err != nil {
+		return err
+	}
 	if err := c.Fs.Mkdir(fsPath); err != nil {
 		c.Log(logger.LevelWarn, "error creating dir: %#v error: %+v", fsPath, err)
 		return c.GetFsError(err)
@@ -239,6 +394,34 @@ func (c *BaseConnection) CreateDir(fsPath, virtualPath string) error {
 	return nil
 }
 
+// CheckDirectoryLimit returns an error if the directory that should contain the
+// given virtual path already has the maximum number of entries allowed
+func (c *BaseConnection) CheckDirectoryLimit(virtualPath string) error {
+	virtualDir := path.Dir(virtualPath)
+	maxFiles := c.User.GetDirectoryLimit(virtualDir)
+	if maxFiles <= 0 {
+		return nil
+	}
+	fsDir, err := c.Fs.ResolvePath(virtualDir)
+	if err != nil {
+		return c.GetFsError(err)
+	}
+	files, err := c.Fs.ReadDir(fsDir)
+	if err != nil {
+		if c.Fs.IsNotExist(err) {
+			return nil
+		}
+		c.Log(logger.LevelWarn, "unable to check directory limit for %#v: %v", virtualDir, err)
+		return c.GetFsError(err)
+	}
+	if len(files) >= maxFiles {
+		c.Log(logger.LevelInfo, "denying new entry %#v, directory %#v has %v entries, limit: %v", virtualPath,
+			virtualDir, len(files), maxFiles)
+		return c.GetGenericError(ErrDirectoryFull)
+	}
+	return nil
+}
+
 // IsRemoveFileAllowed returns an error if removing this file is not allowed
 func (c *BaseConnection) IsRemoveFileAllowed(fsPath, virtualPath string) error {
 	if !c.User.HasPerm(dataprovider.PermDelete, path.Dir(virtualPath)) {
@@ -252,11 +435,16 @@ func (c *BaseConnection) IsRemoveFileAllowed(fsPath, virtualPath string) error {
 }
 
 // RemoveFile removes a file at the specified fsPath
-func (c *BaseConnection) RemoveFile(fsPath, virtualPath string, info os.FileInfo) error {
+func (c *BaseConnection) RemoveFile(fsPath, virtualPath string, info os.FileInfo) (err error) {
+	size := int64(-1)
+	defer func() {
+		c.auditFileOperation(operationDelete, virtualPath, "", size, err)
+	}()
+
 	if err := c.IsRemoveFileAllowed(fsPath, virtualPath); err != nil {
 		return err
 	}
-	size := info.Size()
+	size = info.Size()
 	action := newActionNotification(&c.User, operationPreDelete, fsPath, "", "", c.protocol, size, nil)
 	actionErr := actionHandler.Handle(action)
 	if actionErr == nil {
@@ -312,13 +500,16 @@ func (c *BaseConnection) IsRemoveDirAllowed(fsPath, virtualPath string) error {
 }
 
 // RemoveDir removes a directory at the specified fsPath
-func (c *BaseConnection) RemoveDir(fsPath, virtualPath string) error {
+func (c *BaseConnection) RemoveDir(fsPath, virtualPath string) (err error) {
+	defer func() {
+		c.auditFileOperation(auditActionRmdir, virtualPath, "", -1, err)
+	}()
+
 	if err := c.IsRemoveDirAllowed(fsPath, virtualPath); err != nil {
 		return err
 	}
 
 	var fi os.FileInfo
-	var err error
 	if fi, err = c.Fs.Lstat(fsPath); err != nil {
 		// see #149
 		if c.Fs.IsNotExist(err) && c.Fs.HasVirtualFolders() {
@@ -342,7 +533,11 @@ func (c *BaseConnection) RemoveDir(fsPath, virtualPath string) error {
 }
 
 // Rename renames (moves) fsSourcePath to fsTargetPath
-func (c *BaseConnection) Rename(fsSourcePath, fsTargetPath, virtualSourcePath, virtualTargetPath string) error {
+func (c *BaseConnection) Rename(fsSourcePath, fsTargetPath, virtualSourcePath, virtualTargetPath string) (err error) {
+	defer func() {
+		c.auditFileOperation(operationRename, virtualSourcePath, virtualTargetPath, -1, err)
+	}()
+
 	if c.User.IsMappedPath(fsSourcePath) {
 		c.Log(logger.LevelWarn, "renaming a directory mapped as virtual folder is not allowed: %#v", fsSourcePath)
 		return c.GetPermissionDeniedError()
@@ -359,7 +554,8 @@ func (c *BaseConnection) Rename(fsSourcePath, fsTargetPath, virtualSourcePath, v
 		return c.GetPermissionDeniedError()
 	}
 	initialSize := int64(-1)
-	if dstInfo, err := c.Fs.Lstat(fsTargetPath); err == nil {
+	dstInfo, err := c.Fs.Lstat(fsTargetPath)
+	if err == nil {
 		if dstInfo.IsDir() {
 			c.Log(logger.LevelWarn, "attempted to rename %#v overwriting an existing directory %#v",
 				fsSourcePath, fsTargetPath)
@@ -374,6 +570,11 @@ func (c *BaseConnection) Rename(fsSourcePath, fsTargetPath, virtualSourcePath, v
 				"has no overwrite permission", virtualSourcePath, virtualTargetPath)
 			return c.GetPermissionDeniedError()
 		}
+	} else if path.Dir(virtualSourcePath) != path.Dir(virtualTargetPath) {
+		// a new entry will be added to the target directory
+		if err := c.CheckDirectoryLimit(virtualTargetPath); err != nil {
+			return err
+		}
 	}
 	if srcInfo.IsDir() {
 		if c.User.HasVirtualFoldersInside(virtualSourcePath) {
@@ -407,7 +608,11 @@ func (c *BaseConnection) Rename(fsSourcePath, fsTargetPath, virtualSourcePath, v
 }
 
 // CreateSymlink creates fsTargetPath as a symbolic link to fsSourcePath
-func (c *BaseConnection) CreateSymlink(fsSourcePath, fsTargetPath, virtualSourcePath, virtualTargetPath string) error {
+func (c *BaseConnection) CreateSymlink(fsSourcePath, fsTargetPath, virtualSourcePath, virtualTargetPath string) (err error) {
+	defer func() {
+		c.auditFileOperation(auditActionSymlink, virtualSourcePath, virtualTargetPath, -1, err)
+	}()
+
 	if c.Fs.GetRelativePath(fsSourcePath) == "/" {
 		c.Log(logger.LevelWarn, "symlinking root dir is not allowed")
 		return c.GetPermissionDeniedError()
@@ -531,32 +736,44 @@ func (c *BaseConnection) SetStat(fsPath, virtualPath string, attributes *StatAtt
 	pathForPerms := c.getPathForSetStatPerms(fsPath, virtualPath)
 
 	if attributes.Flags&StatAttrPerms != 0 {
-		return c.handleChmod(fsPath, pathForPerms, attributes)
+		err := c.handleChmod(fsPath, pathForPerms, attributes)
+		c.auditFileOperation(auditActionChmod, virtualPath, "", -1, err)
+		return err
 	}
 
 	if attributes.Flags&StatAttrUIDGID != 0 {
-		return c.handleChown(fsPath, pathForPerms, attributes)
+		err := c.handleChown(fsPath, pathForPerms, attributes)
+		c.auditFileOperation(auditActionChown, virtualPath, "", -1, err)
+		return err
 	}
 
 	if attributes.Flags&StatAttrTimes != 0 {
-		return c.handleChtimes(fsPath, pathForPerms, attributes)
+		err := c.handleChtimes(fsPath, pathForPerms, attributes)
+		c.auditFileOperation(auditActionChtimes, virtualPath, "", -1, err)
+		return err
 	}
 
 	if attributes.Flags&StatAttrSize != 0 {
-		if !c.User.HasPerm(dataprovider.PermOverwrite, pathForPerms) {
-			return c.GetPermissionDeniedError()
-		}
-
-		if err := c.truncateFile(fsPath, virtualPath, attributes.Size); err != nil {
-			c.Log(logger.LevelWarn, "failed to truncate path %#v, size: %v, err: %+v", fsPath, attributes.Size, err)
-			return c.GetFsError(err)
-		}
-		logger.CommandLog(truncateLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", attributes.Size)
+		err := c.handleTruncate(fsPath, virtualPath, pathForPerms, attributes)
+		c.auditFileOperation(auditActionTruncate, virtualPath, "", attributes.Size, err)
+		return err
 	}
 
 	return nil
 }
 
+func (c *BaseConnection) handleTruncate(fsPath, virtualPath, pathForPerms string, attributes *StatAttributes) error {
+	if !c.User.HasPerm(dataprovider.PermOverwrite, pathForPerms) {
+		return c.GetPermissionDeniedError()
+	}
+	if err := c.truncateFile(fsPath, virtualPath, attributes.Size); err != nil {
+		c.Log(logger.LevelWarn, "failed to truncate path %#v, size: %v, err: %+v", fsPath, attributes.Size, err)
+		return c.GetFsError(err)
+	}
+	logger.CommandLog(truncateLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", attributes.Size)
+	return nil
+}
+
 func (c *BaseConnection) truncateFile(fsPath, virtualPath string, size int64) error {
 	// check first if we have an open transfer for the given path and try to truncate the file already opened
 	// if we found no transfer we truncate by path.
@@ -804,7 +1021,7 @@ func (c *BaseConnection) HasSpace(checkFiles, getUsage bool, requestPath string)
 		}
 		result.QuotaSize = c.User.QuotaSize
 		result.QuotaFiles = c.User.QuotaFiles
-		result.UsedFiles, result.UsedSize, err = dataprovider.GetUsedQuota(c.User.Username)
+		result.UsedFiles, result.UsedSize, err = dataprovider.GetUsedQuota(c.User.GetQuotaUsername())
 	}
 	if err != nil {
 		c.Log(logger.LevelWarn, "error getting used quota for %#v request path %#v: %v", c.User.Username, requestPath, err)
@@ -987,7 +1204,8 @@ func (c *BaseConnection) GetGenericError(err error) error {
 		return sftp.ErrSSHFxFailure
 	default:
 		if err == ErrPermissionDenied || err == ErrNotExist || err == ErrOpUnsupported ||
-			err == ErrQuotaExceeded || err == vfs.ErrStorageSizeUnavailable {
+			err == ErrQuotaExceeded || err == ErrReadQuotaExceeded || err == vfs.ErrStorageSizeUnavailable ||
+			err == ErrDirectoryFull {
 			return err
 		}
 		return ErrGenericFailure
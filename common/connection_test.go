@@ -125,6 +125,69 @@ func TestCreateDir(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestDirectoryLimits(t *testing.T) {
+	user := dataprovider.User{
+		Username: userTestUsername,
+		HomeDir:  filepath.Join(os.TempDir(), "home"),
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	user.Filters.DirectoryLimits = []dataprovider.DirectoryLimit{
+		{
+			Path:     "/uploads/*",
+			MaxFiles: 1,
+		},
+		{
+			Path:     "/uploads",
+			MaxFiles: 2,
+		},
+	}
+	assert.Equal(t, 0, user.GetDirectoryLimit("/"))
+	assert.Equal(t, 2, user.GetDirectoryLimit("/uploads"))
+	assert.Equal(t, 1, user.GetDirectoryLimit("/uploads/sub"))
+	assert.Equal(t, 0, user.GetDirectoryLimit("/uploads/sub/dir"))
+	err := os.MkdirAll(filepath.Join(user.GetHomeDir(), "uploads"), os.ModePerm)
+	assert.NoError(t, err)
+	fs, err := user.GetFilesystem("")
+	assert.NoError(t, err)
+	c := NewBaseConnection("", ProtocolFTP, user, fs)
+	// missing directories have no entries
+	assert.NoError(t, c.CheckDirectoryLimit("/uploads/missing/file"))
+	err = c.CreateDir(filepath.Join(user.GetHomeDir(), "uploads", "sub"), "/uploads/sub")
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(user.GetHomeDir(), "uploads", "file"), []byte("data"), os.ModePerm)
+	assert.NoError(t, err)
+	err = c.CreateDir(filepath.Join(user.GetHomeDir(), "uploads", "sub1"), "/uploads/sub1")
+	assert.ErrorIs(t, err, ErrDirectoryFull)
+	err = c.CheckDirectoryLimit("/uploads/file1")
+	assert.ErrorIs(t, err, ErrDirectoryFull)
+	assert.NoError(t, c.CheckDirectoryLimit("/uploads/sub/file"))
+	err = ioutil.WriteFile(filepath.Join(user.GetHomeDir(), "uploads", "sub", "file"), []byte("data"), os.ModePerm)
+	assert.NoError(t, err)
+	err = c.CheckDirectoryLimit("/uploads/sub/file1")
+	assert.ErrorIs(t, err, ErrDirectoryFull)
+	assert.NoError(t, c.CheckDirectoryLimit("/file"))
+	// renaming to a full directory is not allowed
+	err = ioutil.WriteFile(filepath.Join(user.GetHomeDir(), "file"), []byte("data"), os.ModePerm)
+	assert.NoError(t, err)
+	err = c.Rename(filepath.Join(user.GetHomeDir(), "file"), filepath.Join(user.GetHomeDir(), "uploads", "sub", "file1"),
+		"/file", "/uploads/sub/file1")
+	assert.ErrorIs(t, err, ErrDirectoryFull)
+	// renaming inside the same directory or overwriting an existing file does not add entries
+	err = c.Rename(filepath.Join(user.GetHomeDir(), "uploads", "sub", "file"), filepath.Join(user.GetHomeDir(), "uploads", "sub", "file1"),
+		"/uploads/sub/file", "/uploads/sub/file1")
+	assert.NoError(t, err)
+	err = c.Rename(filepath.Join(user.GetHomeDir(), "file"), filepath.Join(user.GetHomeDir(), "uploads", "sub", "file1"),
+		"/file", "/uploads/sub/file1")
+	assert.NoError(t, err)
+	c.SetProtocol(ProtocolSFTP)
+	err = c.CheckDirectoryLimit("/uploads/file1")
+	assert.ErrorIs(t, err, sftp.ErrSSHFxFailure)
+
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestRemoveFile(t *testing.T) {
 	user := dataprovider.User{
 		Username: userTestUsername,
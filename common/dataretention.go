@@ -0,0 +1,638 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/smtp"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+// RetentionCheckNotificationEmail defines the notification method to send the retention check results by email
+const RetentionCheckNotificationEmail = "Email"
+
+// ProtocolDataRetention is the protocol used for the connections created by the data retention checks
+const ProtocolDataRetention = "DataRetention"
+
+// users are loaded from the data provider in pages of this size
+const retentionCheckPageSize = 100
+
+var (
+	// RetentionChecks is the list of active retention checks and the reports for the completed ones
+	RetentionChecks ActiveRetentionChecks
+
+	retentionCheckTicker     *time.Ticker
+	retentionCheckTickerDone chan bool
+)
+
+// DataRetentionScheduleConfig defines when to periodically run the retention checks
+// for the users with data retention policies.
+// Interval and Cron are mutually exclusive
+type DataRetentionScheduleConfig struct {
+	// Interval, as minutes, between two consecutive runs. 0 means disabled
+	Interval int `json:"interval" mapstructure:"interval"`
+	// Cron expression, in UTC time, with the standard five fields:
+	// minute, hour, day of month, month and day of week. Empty means disabled
+	Cron string `json:"cron" mapstructure:"cron"`
+	// parsed cron expression
+	schedule *cronSchedule
+}
+
+func (c *DataRetentionScheduleConfig) isEnabled() bool {
+	return c.Interval > 0 || c.Cron != ""
+}
+
+func (c *DataRetentionScheduleConfig) validate() error {
+	schedule, err := parseScheduleConfig(c.Interval, c.Cron)
+	c.schedule = schedule
+	return err
+}
+
+// the ticker cannot be started/stopped from multiple goroutines
+func startRetentionCheckTicker(config DataRetentionScheduleConfig) {
+	stopRetentionCheckTicker()
+	duration := cronCheckInterval
+	if config.Interval > 0 {
+		duration = time.Duration(config.Interval) * time.Minute
+	}
+	retentionCheckTicker = time.NewTicker(duration)
+	retentionCheckTickerDone = make(chan bool)
+	logger.Info(logSender, "", "scheduled retention checks started, interval: %v, cron: %#v", config.Interval, config.Cron)
+	go func() {
+		for {
+			select {
+			case <-retentionCheckTickerDone:
+				return
+			case t := <-retentionCheckTicker.C:
+				if config.schedule != nil && !config.schedule.matches(t.UTC()) {
+					continue
+				}
+				runScheduledRetentionChecks()
+			}
+		}
+	}()
+}
+
+func stopRetentionCheckTicker() {
+	if retentionCheckTicker != nil {
+		retentionCheckTicker.Stop()
+		retentionCheckTickerDone <- true
+		retentionCheckTicker = nil
+	}
+}
+
+// runScheduledRetentionChecks runs, one at a time, the retention checks for the users
+// with data retention policies. Users with a check already in progress are skipped
+func runScheduledRetentionChecks() {
+	startTime := time.Now()
+	numChecks := 0
+	for offset := 0; ; offset += retentionCheckPageSize {
+		users, err := dataprovider.GetUsers(retentionCheckPageSize, offset, dataprovider.OrderASC)
+		if err != nil {
+			logger.Warn(logSender, "", "scheduled retention checks, unable to get users: %v", err)
+			break
+		}
+		for idx := range users {
+			if len(users[idx].Filters.DataRetention) == 0 {
+				continue
+			}
+			// the listed users have the confidential data hidden
+			user, err := dataprovider.UserExists(users[idx].Username)
+			if err != nil {
+				continue
+			}
+			check := RetentionCheck{
+				Folders: user.Filters.DataRetention,
+			}
+			if err := check.Validate(); err != nil {
+				logger.Debug(logSender, "", "scheduled retention check skipped for user %#v: %v", user.Username, err)
+				continue
+			}
+			if c := RetentionChecks.Add(check, &user); c != nil {
+				c.Start()
+				numChecks++
+			}
+		}
+		if len(users) < retentionCheckPageSize {
+			break
+		}
+	}
+	logger.Info(logSender, "", "scheduled retention checks completed, checked users: %v, elapsed: %v",
+		numChecks, time.Since(startTime))
+}
+
+// ActiveRetentionChecks holds the active retention checks and the last report
+// for each user and virtual folder
+type ActiveRetentionChecks struct {
+	sync.RWMutex
+	Checks        []RetentionCheck
+	reports       map[string]RetentionCheck
+	folderReports map[string]RetentionCheck
+}
+
+// Get returns the active retention checks for users
+func (c *ActiveRetentionChecks) Get() []RetentionCheck {
+	return c.getChecks(false)
+}
+
+// GetFolderChecks returns the active retention checks for virtual folders
+func (c *ActiveRetentionChecks) GetFolderChecks() []RetentionCheck {
+	return c.getChecks(true)
+}
+
+func (c *ActiveRetentionChecks) getChecks(folderChecks bool) []RetentionCheck {
+	c.RLock()
+	defer c.RUnlock()
+
+	checks := make([]RetentionCheck, 0, len(c.Checks))
+	for _, check := range c.Checks {
+		if check.isFolderCheck() != folderChecks {
+			continue
+		}
+		foldersCopy := make([]dataprovider.FolderRetention, len(check.Folders))
+		copy(foldersCopy, check.Folders)
+		checks = append(checks, RetentionCheck{
+			Username:   check.Username,
+			FolderName: check.FolderName,
+			StartTime:  check.StartTime,
+			DryRun:     check.DryRun,
+			Folders:    foldersCopy,
+		})
+	}
+	return checks
+}
+
+// GetReport returns the report for the last completed retention check for the given user
+func (c *ActiveRetentionChecks) GetReport(username string) (RetentionCheck, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	report, ok := c.reports[username]
+	return report, ok
+}
+
+// GetFolderReport returns the report for the last completed retention check for the given virtual folder
+func (c *ActiveRetentionChecks) GetFolderReport(name string) (RetentionCheck, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	report, ok := c.folderReports[name]
+	return report, ok
+}
+
+// Add a new retention check, returns nil if a retention check for the given
+// username is already active. The returned result can be used to start the check
+func (c *ActiveRetentionChecks) Add(check RetentionCheck, user *dataprovider.User) *RetentionCheck {
+	check.FolderName = ""
+	return c.add(check, user)
+}
+
+// AddFolder adds a new retention check for the given virtual folder, returns nil
+// if a retention check for this folder is already active. The folder paths to check
+// are relative to the folder root. The returned result can be used to start the check.
+// The files are removed as a user with full permissions and the folder as home directory,
+// the quota is updated for the folder only and not for the users that include it
+func (c *ActiveRetentionChecks) AddFolder(check RetentionCheck, folder *vfs.BaseVirtualFolder) *RetentionCheck {
+	user := dataprovider.User{
+		HomeDir:     folder.MappedPath,
+		Status:      1,
+		Permissions: map[string][]string{"/": {dataprovider.PermAny}},
+	}
+	check.FolderName = folder.Name
+	return c.add(check, &user)
+}
+
+func (c *ActiveRetentionChecks) add(check RetentionCheck, user *dataprovider.User) *RetentionCheck {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, val := range c.Checks {
+		if val.Username == user.Username && val.FolderName == check.FolderName {
+			return nil
+		}
+	}
+	// we silently ignore file patterns and extensions
+	user.Filters.FilePatterns = nil
+	user.Filters.FileExtensions = nil
+	// the permissions could be updated for this check only
+	permissions := make(map[string][]string)
+	for k, v := range user.Permissions {
+		permissions[k] = v
+	}
+	user.Permissions = permissions
+	conn := NewBaseConnection("", ProtocolDataRetention, *user, nil)
+	conn.SetRemoteAddress("127.0.0.1")
+	check.conn = conn
+	check.Username = user.Username
+	check.StartTime = utils.GetTimeAsMsSinceEpoch(time.Now())
+	check.EndTime = 0
+	check.Results = nil
+	check.Error = ""
+	check.results = nil
+	check.updateUserPermissions()
+	c.Checks = append(c.Checks, check)
+
+	return &check
+}
+
+// remove a user from the ones with active retention checks
+// and stores the report for the completed check
+func (c *ActiveRetentionChecks) remove(check *RetentionCheck) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.reports == nil {
+		c.reports = make(map[string]RetentionCheck)
+	}
+	if c.folderReports == nil {
+		c.folderReports = make(map[string]RetentionCheck)
+	}
+	if check.isFolderCheck() {
+		c.folderReports[check.FolderName] = check.getReport()
+	} else {
+		c.reports[check.Username] = check.getReport()
+	}
+
+	for idx, val := range c.Checks {
+		if val.Username == check.Username && val.FolderName == check.FolderName {
+			lastIdx := len(c.Checks) - 1
+			c.Checks[idx] = c.Checks[lastIdx]
+			c.Checks = c.Checks[:lastIdx]
+			return true
+		}
+	}
+
+	return false
+}
+
+// FolderRetentionCheckResult defines the result of a retention check for a directory
+type FolderRetentionCheckResult struct {
+	Path         string `json:"path"`
+	Retention    int    `json:"retention"`
+	DeletedFiles int    `json:"deleted_files"`
+	DeletedSize  int64  `json:"deleted_size"`
+	DeletedDirs  int    `json:"deleted_dirs"`
+	// virtual paths of the files to delete, reported in dry run mode only
+	Files []string `json:"files,omitempty"`
+	// elapsed time as milliseconds
+	Elapsed int64  `json:"elapsed"`
+	Info    string `json:"info,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RetentionCheck defines an active retention check
+type RetentionCheck struct {
+	// Username to which the retention check refers, empty for virtual folder checks
+	Username string `json:"username,omitempty"`
+	// Name of the virtual folder to which the retention check refers, empty for user checks
+	FolderName string `json:"folder_name,omitempty"`
+	// retention check start time as unix timestamp in milliseconds
+	StartTime int64 `json:"start_time"`
+	// retention check end time as unix timestamp in milliseconds, 0 for active checks
+	EndTime int64 `json:"end_time,omitempty"`
+	// in dry run mode the files to delete are reported but they are not deleted
+	DryRun bool `json:"dry_run,omitempty"`
+	// affected folders
+	Folders []dataprovider.FolderRetention `json:"folders"`
+	// how cleanup results will be notified
+	Notifications []string `json:"notifications,omitempty"`
+	// email to use if the notification method is set to email
+	Email string `json:"email,omitempty"`
+	// results for each checked directory, available for completed checks only
+	Results []FolderRetentionCheckResult `json:"results,omitempty"`
+	// the error that stopped the check, if any
+	Error string `json:"error,omitempty"`
+	// Cleanup results
+	results []*FolderRetentionCheckResult
+	conn    *BaseConnection
+}
+
+func (c *RetentionCheck) isFolderCheck() bool {
+	return c.FolderName != ""
+}
+
+// Validate returns an error if the specified folders are not valid
+func (c *RetentionCheck) Validate() error {
+	folderPaths := make(map[string]bool)
+	nothingToDo := true
+	for idx := range c.Folders {
+		f := &c.Folders[idx]
+		if err := f.Validate(); err != nil {
+			return err
+		}
+		if f.Retention > 0 {
+			nothingToDo = false
+		}
+		if _, ok := folderPaths[f.Path]; ok {
+			return dataprovider.NewValidationError(fmt.Sprintf("duplicated folder path %#v", f.Path))
+		}
+		folderPaths[f.Path] = true
+	}
+	if nothingToDo {
+		return dataprovider.NewValidationError("nothing to delete!")
+	}
+	for _, notification := range c.Notifications {
+		switch notification {
+		case RetentionCheckNotificationEmail:
+			if !smtp.IsEnabled() {
+				return dataprovider.NewValidationError("in order to notify results via email you must configure an SMTP server")
+			}
+			if c.Email == "" {
+				return dataprovider.NewValidationError("in order to notify results via email the admin starting the check must have an email address")
+			}
+		default:
+			return dataprovider.NewValidationError(fmt.Sprintf("invalid notification %#v", notification))
+		}
+	}
+	return nil
+}
+
+func (c *RetentionCheck) updateUserPermissions() {
+	for _, folder := range c.Folders {
+		if folder.IgnoreUserPermissions {
+			c.conn.User.Permissions[folder.Path] = []string{dataprovider.PermAny}
+		}
+	}
+}
+
+// getFolderRetention returns the retention policy for the given virtual path,
+// the most specific policy wins
+func (c *RetentionCheck) getFolderRetention(folderPath string) (dataprovider.FolderRetention, error) {
+	dirPath := folderPath
+	for {
+		for _, folder := range c.Folders {
+			if folder.Path == dirPath {
+				return folder, nil
+			}
+		}
+		if dirPath == "/" {
+			break
+		}
+		dirPath = path.Dir(dirPath)
+	}
+
+	return dataprovider.FolderRetention{}, fmt.Errorf("unable to find folder retention for %#v", folderPath)
+}
+
+// isCoveredByParent returns true if the given folder will be checked recursively
+// starting from a parent folder with a retention policy
+func (c *RetentionCheck) isCoveredByParent(folder dataprovider.FolderRetention) bool {
+	if folder.Path == "/" {
+		return false
+	}
+	parent, err := c.getFolderRetention(path.Dir(folder.Path))
+	if err != nil {
+		return false
+	}
+	return parent.Retention > 0
+}
+
+func (c *RetentionCheck) removeFile(virtualPath string, info os.FileInfo) error {
+	if c.DryRun {
+		c.conn.Log(logger.LevelDebug, "dry run, file %#v not removed", virtualPath)
+		return nil
+	}
+	fsPath, err := c.conn.Fs.ResolvePath(virtualPath)
+	if err != nil {
+		return err
+	}
+	return c.conn.RemoveFile(fsPath, virtualPath, info)
+}
+
+func (c *RetentionCheck) cleanupFolder(folderPath string) error {
+	startTime := time.Now()
+	result := &FolderRetentionCheckResult{
+		Path: folderPath,
+	}
+	c.results = append(c.results, result)
+	defer func() {
+		result.Elapsed = time.Since(startTime).Milliseconds()
+	}()
+
+	if !c.conn.User.HasPerm(dataprovider.PermListItems, folderPath) || !c.conn.User.HasPerm(dataprovider.PermDelete, folderPath) {
+		result.Info = "data retention check skipped: no permissions"
+		c.conn.Log(logger.LevelInfo, "user %#v does not have permissions to check retention on %#v, retention check skipped",
+			c.conn.User.Username, folderPath)
+		return nil
+	}
+
+	folderRetention, err := c.getFolderRetention(folderPath)
+	if err != nil {
+		result.Error = "unable to get folder retention"
+		c.conn.Log(logger.LevelError, "unable to get folder retention for path %#v", folderPath)
+		return err
+	}
+	result.Retention = folderRetention.Retention
+	if folderRetention.Retention == 0 {
+		result.Info = "data retention check skipped: retention is set to 0"
+		c.conn.Log(logger.LevelDebug, "retention check skipped for folder %#v, retention is set to 0", folderPath)
+		return nil
+	}
+	c.conn.Log(logger.LevelDebug, "start retention check for folder %#v, retention: %v hours, delete empty dirs? %v, ignore user perms? %v, dry run? %v",
+		folderPath, folderRetention.Retention, folderRetention.DeleteEmptyDirs, folderRetention.IgnoreUserPermissions, c.DryRun)
+	fsPath, err := c.conn.Fs.ResolvePath(folderPath)
+	if err != nil {
+		result.Error = "unable to resolve the folder path"
+		return err
+	}
+	files, err := c.conn.ListDir(fsPath, folderPath)
+	if err != nil {
+		if err == c.conn.GetNotExistError() {
+			result.Info = "data retention check skipped, folder does not exist"
+			c.conn.Log(logger.LevelDebug, "folder %#v does not exist, retention check skipped", folderPath)
+			return nil
+		}
+		result.Error = fmt.Sprintf("unable to list directory %#v", folderPath)
+		c.conn.Log(logger.LevelWarn, result.Error)
+		return err
+	}
+	for _, info := range files {
+		virtualPath := path.Join(folderPath, info.Name())
+		if info.IsDir() {
+			if err := c.cleanupFolder(virtualPath); err != nil {
+				result.Error = fmt.Sprintf("unable to check folder: %v", err)
+				c.conn.Log(logger.LevelWarn, "unable to cleanup folder %#v: %v", virtualPath, err)
+				return err
+			}
+			continue
+		}
+		retentionTime := info.ModTime().Add(time.Duration(folderRetention.Retention) * time.Hour)
+		if retentionTime.Before(time.Now()) {
+			if err := c.removeFile(virtualPath, info); err != nil {
+				result.Error = fmt.Sprintf("unable to remove file %#v: %v", virtualPath, err)
+				c.conn.Log(logger.LevelWarn, "unable to remove file %#v, retention %v: %v",
+					virtualPath, retentionTime, err)
+				return err
+			}
+			c.conn.Log(logger.LevelDebug, "removed file %#v, modification time: %v, retention: %v hours, retention time: %v, dry run? %v",
+				virtualPath, info.ModTime(), folderRetention.Retention, retentionTime, c.DryRun)
+			result.DeletedFiles++
+			result.DeletedSize += info.Size()
+			if c.DryRun {
+				result.Files = append(result.Files, virtualPath)
+			}
+		}
+	}
+
+	if folderRetention.DeleteEmptyDirs && !c.DryRun {
+		if c.checkEmptyDirRemoval(folderPath) {
+			result.DeletedDirs++
+		}
+	}
+	c.conn.Log(logger.LevelDebug, "retention check completed for folder %#v, deleted files: %v, deleted size: %v bytes",
+		folderPath, result.DeletedFiles, result.DeletedSize)
+
+	return nil
+}
+
+// checkEmptyDirRemoval removes the given directory if it is empty, returns true if the directory is removed
+func (c *RetentionCheck) checkEmptyDirRemoval(folderPath string) bool {
+	if folderPath == "/" {
+		return false
+	}
+	for _, folder := range c.Folders {
+		if folderPath == folder.Path {
+			return false
+		}
+	}
+	if !c.conn.User.HasPerm(dataprovider.PermDelete, path.Dir(folderPath)) {
+		return false
+	}
+	fsPath, err := c.conn.Fs.ResolvePath(folderPath)
+	if err != nil {
+		return false
+	}
+	files, err := c.conn.ListDir(fsPath, folderPath)
+	if err != nil || len(files) > 0 {
+		return false
+	}
+	err = c.conn.RemoveDir(fsPath, folderPath)
+	c.conn.Log(logger.LevelDebug, "tried to remove empty dir %#v, error: %v", folderPath, err)
+	return err == nil
+}
+
+// Start starts the retention check
+func (c *RetentionCheck) Start() {
+	c.conn.Log(logger.LevelInfo, "retention check started, dry run? %v", c.DryRun)
+	defer RetentionChecks.remove(c)
+	defer c.conn.CloseFS() //nolint:errcheck
+
+	startTime := time.Now()
+	var checkErr error
+	fs, err := c.conn.User.GetFilesystem(c.conn.ID)
+	if err != nil {
+		c.conn.Log(logger.LevelError, "unable to get the filesystem: %v", err)
+		checkErr = err
+	} else {
+		c.conn.Fs = fs
+		for _, folder := range c.Folders {
+			if folder.Retention == 0 || c.isCoveredByParent(folder) {
+				continue
+			}
+			if err := c.cleanupFolder(folder.Path); err != nil {
+				c.conn.Log(logger.LevelWarn, "retention check failed, unable to cleanup folder %#v", folder.Path)
+				checkErr = err
+				break
+			}
+		}
+	}
+	if checkErr != nil {
+		c.Error = checkErr.Error()
+	}
+	c.EndTime = utils.GetTimeAsMsSinceEpoch(time.Now())
+	c.updateFolderQuota()
+
+	c.conn.Log(logger.LevelInfo, "retention check completed, dry run? %v, elapsed: %v, error: %v",
+		c.DryRun, time.Since(startTime), checkErr)
+	c.sendNotifications(time.Since(startTime), checkErr)
+}
+
+// updateFolderQuota updates the used quota for virtual folder checks,
+// the removed files are not inside a virtual folder of the check user
+func (c *RetentionCheck) updateFolderQuota() {
+	if !c.isFolderCheck() || c.DryRun {
+		return
+	}
+	deletedFiles := 0
+	deletedSize := int64(0)
+	for _, result := range c.results {
+		deletedFiles += result.DeletedFiles
+		deletedSize += result.DeletedSize
+	}
+	folder := vfs.BaseVirtualFolder{Name: c.FolderName}
+	dataprovider.UpdateVirtualFolderQuota(&folder, -deletedFiles, -deletedSize, false) //nolint:errcheck
+}
+
+func (c *RetentionCheck) getReport() RetentionCheck {
+	report := RetentionCheck{
+		Username:      c.Username,
+		FolderName:    c.FolderName,
+		StartTime:     c.StartTime,
+		EndTime:       c.EndTime,
+		DryRun:        c.DryRun,
+		Folders:       c.Folders,
+		Notifications: c.Notifications,
+		Email:         c.Email,
+		Error:         c.Error,
+	}
+	for _, result := range c.results {
+		report.Results = append(report.Results, *result)
+	}
+	return report
+}
+
+func (c *RetentionCheck) sendNotifications(elapsed time.Duration, err error) {
+	for _, notification := range c.Notifications {
+		switch notification {
+		case RetentionCheckNotificationEmail:
+			c.sendEmailNotification(elapsed, err) //nolint:errcheck
+		}
+	}
+}
+
+func (c *RetentionCheck) sendEmailNotification(elapsed time.Duration, errCheck error) error {
+	var body strings.Builder
+
+	target := fmt.Sprintf("user %#v", c.conn.User.Username)
+	if c.isFolderCheck() {
+		target = fmt.Sprintf("virtual folder %#v", c.FolderName)
+	}
+	subject := fmt.Sprintf("Retention check completed for %v", target)
+	if c.DryRun {
+		subject = fmt.Sprintf("Retention check (dry run) completed for %v", target)
+	}
+	if errCheck != nil {
+		subject += " with errors"
+		fmt.Fprintf(&body, "Error: %v\n\n", errCheck)
+	}
+	fmt.Fprintf(&body, "Elapsed: %v\n", elapsed)
+	for _, result := range c.results {
+		fmt.Fprintf(&body, "\nPath: %v\nRetention: %v hours\nDeleted files: %v\nDeleted size: %v\nDeleted dirs: %v\n",
+			result.Path, result.Retention, result.DeletedFiles, utils.ByteCountIEC(result.DeletedSize), result.DeletedDirs)
+		for _, file := range result.Files {
+			fmt.Fprintf(&body, "File to delete: %v\n", file)
+		}
+		if result.Info != "" {
+			fmt.Fprintf(&body, "Info: %v\n", result.Info)
+		}
+		if result.Error != "" {
+			fmt.Fprintf(&body, "Error: %v\n", result.Error)
+		}
+	}
+	startTime := time.Now()
+	err := smtp.SendEmail([]string{c.Email}, subject, body.String(), smtp.EmailContentTypeTextPlain)
+	if err != nil {
+		c.conn.Log(logger.LevelError, "unable to notify retention check result via email: %v, elapsed: %v", err,
+			time.Since(startTime))
+		return err
+	}
+	c.conn.Log(logger.LevelInfo, "retention check result successfully notified via email, elapsed: %v", time.Since(startTime))
+	return nil
+}
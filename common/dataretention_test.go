@@ -0,0 +1,352 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+func TestRetentionValidation(t *testing.T) {
+	check := RetentionCheck{}
+	check.Folders = append(check.Folders, dataprovider.FolderRetention{
+		Path:      "relative",
+		Retention: 10,
+	})
+	err := check.Validate()
+	assert.NoError(t, err)
+	assert.Equal(t, "/relative", check.Folders[0].Path)
+
+	check.Folders = append(check.Folders, dataprovider.FolderRetention{
+		Path:      "/relative",
+		Retention: 10,
+	})
+	err = check.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "duplicated folder path")
+
+	check.Folders = []dataprovider.FolderRetention{
+		{
+			Path:      "/",
+			Retention: -1,
+		},
+	}
+	err = check.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid folder retention")
+
+	check.Folders = []dataprovider.FolderRetention{
+		{
+			Path:      "/",
+			Retention: 0,
+		},
+	}
+	err = check.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "nothing to delete")
+
+	check.Folders[0].Retention = 24
+	check.Notifications = []string{"invalid"}
+	err = check.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid notification")
+
+	check.Notifications = []string{RetentionCheckNotificationEmail}
+	err = check.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "you must configure an SMTP server")
+}
+
+func TestRetentionFolderMatching(t *testing.T) {
+	check := RetentionCheck{
+		Folders: []dataprovider.FolderRetention{
+			{
+				Path:      "/",
+				Retention: 24,
+			},
+			{
+				Path:      "/sub",
+				Retention: 0,
+			},
+			{
+				Path:      "/sub/dir",
+				Retention: 48,
+			},
+			{
+				Path:      "/a/b",
+				Retention: 12,
+			},
+		},
+	}
+	folder, err := check.getFolderRetention("/")
+	assert.NoError(t, err)
+	assert.Equal(t, 24, folder.Retention)
+	folder, err = check.getFolderRetention("/a")
+	assert.NoError(t, err)
+	assert.Equal(t, 24, folder.Retention)
+	folder, err = check.getFolderRetention("/a/b/c")
+	assert.NoError(t, err)
+	assert.Equal(t, 12, folder.Retention)
+	folder, err = check.getFolderRetention("/sub/other")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, folder.Retention)
+	folder, err = check.getFolderRetention("/sub/dir/other")
+	assert.NoError(t, err)
+	assert.Equal(t, 48, folder.Retention)
+
+	assert.False(t, check.isCoveredByParent(check.Folders[0]))
+	assert.True(t, check.isCoveredByParent(check.Folders[1]))
+	assert.False(t, check.isCoveredByParent(check.Folders[2]))
+	assert.True(t, check.isCoveredByParent(check.Folders[3]))
+
+	check.Folders = check.Folders[2:]
+	_, err = check.getFolderRetention("/other")
+	assert.Error(t, err)
+}
+
+func TestRetentionCheckAddRemove(t *testing.T) {
+	username := "retention_user"
+	user := dataprovider.User{
+		Username: username,
+		HomeDir:  filepath.Join(os.TempDir(), username),
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermListItems}
+	check := RetentionCheck{
+		Folders: []dataprovider.FolderRetention{
+			{
+				Path:                  "/",
+				Retention:             48,
+				IgnoreUserPermissions: true,
+			},
+		},
+	}
+	assert.Len(t, RetentionChecks.Get(), 0)
+	c := RetentionChecks.Add(check, &user)
+	require.NotNil(t, c)
+	assert.True(t, c.conn.User.HasPerm(dataprovider.PermDelete, "/"))
+	checks := RetentionChecks.Get()
+	require.Len(t, checks, 1)
+	assert.Equal(t, username, checks[0].Username)
+	assert.Greater(t, checks[0].StartTime, int64(0))
+	require.Len(t, checks[0].Folders, 1)
+	assert.Equal(t, check.Folders[0].Path, checks[0].Folders[0].Path)
+	assert.Equal(t, check.Folders[0].Retention, checks[0].Folders[0].Retention)
+
+	assert.Nil(t, RetentionChecks.Add(check, &user))
+	assert.True(t, RetentionChecks.remove(c))
+	assert.Len(t, RetentionChecks.Get(), 0)
+	assert.False(t, RetentionChecks.remove(c))
+	report, ok := RetentionChecks.GetReport(username)
+	assert.True(t, ok)
+	assert.Equal(t, username, report.Username)
+}
+
+func TestFolderRetentionCheckAddRemove(t *testing.T) {
+	name := "retention_name"
+	folder := vfs.BaseVirtualFolder{
+		Name:       name,
+		MappedPath: filepath.Join(os.TempDir(), name),
+	}
+	user := dataprovider.User{
+		Username: name,
+		HomeDir:  filepath.Join(os.TempDir(), name),
+	}
+	check := RetentionCheck{
+		Folders: []dataprovider.FolderRetention{
+			{
+				Path:      "/",
+				Retention: 48,
+			},
+		},
+	}
+	c := RetentionChecks.AddFolder(check, &folder)
+	require.NotNil(t, c)
+	assert.Empty(t, c.Username)
+	assert.Equal(t, folder.MappedPath, c.conn.User.GetHomeDir())
+	assert.True(t, c.conn.User.HasPerm(dataprovider.PermDelete, "/"))
+	assert.Nil(t, RetentionChecks.AddFolder(check, &folder))
+	// a user with the same name is checked independently
+	userCheck := RetentionChecks.Add(check, &user)
+	require.NotNil(t, userCheck)
+	assert.Len(t, RetentionChecks.Get(), 1)
+	checks := RetentionChecks.GetFolderChecks()
+	require.Len(t, checks, 1)
+	assert.Equal(t, name, checks[0].FolderName)
+
+	assert.True(t, RetentionChecks.remove(c))
+	assert.Len(t, RetentionChecks.GetFolderChecks(), 0)
+	assert.Len(t, RetentionChecks.Get(), 1)
+	report, ok := RetentionChecks.GetFolderReport(name)
+	assert.True(t, ok)
+	assert.Equal(t, name, report.FolderName)
+	_, ok = RetentionChecks.GetReport(name)
+	assert.False(t, ok)
+	assert.True(t, RetentionChecks.remove(userCheck))
+	assert.Len(t, RetentionChecks.Get(), 0)
+}
+
+func TestRetentionCheckStart(t *testing.T) {
+	username := "retention_check_user"
+	homeDir := filepath.Join(os.TempDir(), username)
+	err := os.MkdirAll(filepath.Join(homeDir, "sub", "empty"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(homeDir, "keep"), os.ModePerm)
+	assert.NoError(t, err)
+	oldTime := time.Now().Add(-72 * time.Hour)
+	for _, name := range []string{"file1", filepath.Join("sub", "file2"), filepath.Join("keep", "file3")} {
+		err = os.WriteFile(filepath.Join(homeDir, name), []byte("data"), os.ModePerm)
+		assert.NoError(t, err)
+		err = os.Chtimes(filepath.Join(homeDir, name), oldTime, oldTime)
+		assert.NoError(t, err)
+	}
+	err = os.WriteFile(filepath.Join(homeDir, "recent"), []byte("data"), os.ModePerm)
+	assert.NoError(t, err)
+
+	user := dataprovider.User{
+		Username: username,
+		HomeDir:  homeDir,
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	check := RetentionCheck{
+		DryRun: true,
+		Folders: []dataprovider.FolderRetention{
+			{
+				Path:            "/",
+				Retention:       48,
+				DeleteEmptyDirs: true,
+			},
+			{
+				Path:      "/keep",
+				Retention: 0,
+			},
+		},
+	}
+	c := RetentionChecks.Add(check, &user)
+	require.NotNil(t, c)
+	c.Start()
+	assert.Len(t, RetentionChecks.Get(), 0)
+	report, ok := RetentionChecks.GetReport(username)
+	require.True(t, ok)
+	assert.True(t, report.DryRun)
+	assert.Empty(t, report.Error)
+	assert.Greater(t, report.EndTime, int64(0))
+	deletedFiles := 0
+	var files []string
+	for _, result := range report.Results {
+		deletedFiles += result.DeletedFiles
+		files = append(files, result.Files...)
+	}
+	assert.Equal(t, 2, deletedFiles)
+	assert.ElementsMatch(t, []string{"/file1", "/sub/file2"}, files)
+	assert.FileExists(t, filepath.Join(homeDir, "file1"))
+	assert.FileExists(t, filepath.Join(homeDir, "sub", "file2"))
+	assert.DirExists(t, filepath.Join(homeDir, "sub", "empty"))
+
+	check.DryRun = false
+	c = RetentionChecks.Add(check, &user)
+	require.NotNil(t, c)
+	c.Start()
+	report, ok = RetentionChecks.GetReport(username)
+	require.True(t, ok)
+	assert.False(t, report.DryRun)
+	assert.Empty(t, report.Error)
+	for _, result := range report.Results {
+		assert.Empty(t, result.Files)
+	}
+	assert.NoFileExists(t, filepath.Join(homeDir, "file1"))
+	assert.NoFileExists(t, filepath.Join(homeDir, "sub", "file2"))
+	assert.NoDirExists(t, filepath.Join(homeDir, "sub", "empty"))
+	assert.FileExists(t, filepath.Join(homeDir, "keep", "file3"))
+	assert.FileExists(t, filepath.Join(homeDir, "recent"))
+
+	err = os.RemoveAll(homeDir)
+	assert.NoError(t, err)
+}
+
+func TestDataRetentionScheduleValidation(t *testing.T) {
+	c := DataRetentionScheduleConfig{}
+	assert.False(t, c.isEnabled())
+	assert.NoError(t, c.validate())
+	c.Interval = -1
+	assert.Error(t, c.validate())
+	c.Interval = 10
+	c.Cron = "0 * * * *"
+	assert.True(t, c.isEnabled())
+	assert.Error(t, c.validate())
+	c.Interval = 0
+	assert.NoError(t, c.validate())
+	assert.NotNil(t, c.schedule)
+	c.Cron = "invalid"
+	assert.Error(t, c.validate())
+
+	err := Initialize(Configuration{DataRetentionSchedule: c})
+	assert.Error(t, err)
+
+	c.Cron = "0 2 * * *"
+	err = Initialize(Configuration{DataRetentionSchedule: c})
+	assert.NoError(t, err)
+	assert.NotNil(t, retentionCheckTicker)
+	err = Initialize(Configuration{})
+	assert.NoError(t, err)
+	assert.Nil(t, retentionCheckTicker)
+}
+
+func TestScheduledRetentionChecks(t *testing.T) {
+	username := "scheduled_retention_user"
+	homeDir := filepath.Join(os.TempDir(), username)
+	err := os.MkdirAll(filepath.Join(homeDir, "inbox"), os.ModePerm)
+	assert.NoError(t, err)
+	oldTime := time.Now().Add(-72 * time.Hour)
+	for _, name := range []string{"file1", filepath.Join("inbox", "file2")} {
+		err = os.WriteFile(filepath.Join(homeDir, name), []byte("data"), os.ModePerm)
+		assert.NoError(t, err)
+		err = os.Chtimes(filepath.Join(homeDir, name), oldTime, oldTime)
+		assert.NoError(t, err)
+	}
+
+	user := dataprovider.User{
+		Username: username,
+		Password: "password",
+		HomeDir:  homeDir,
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	user.Filters.DataRetention = []dataprovider.FolderRetention{
+		{
+			Path:      "/inbox",
+			Retention: 48,
+		},
+		{
+			Path:      "/inbox",
+			Retention: 24,
+		},
+	}
+	err = dataprovider.AddUser(&user)
+	assert.Error(t, err)
+	user.Filters.DataRetention = user.Filters.DataRetention[:1]
+	err = dataprovider.AddUser(&user)
+	require.NoError(t, err)
+
+	runScheduledRetentionChecks()
+
+	assert.Len(t, RetentionChecks.Get(), 0)
+	report, ok := RetentionChecks.GetReport(username)
+	require.True(t, ok)
+	assert.False(t, report.DryRun)
+	assert.Empty(t, report.Error)
+	assert.FileExists(t, filepath.Join(homeDir, "file1"))
+	assert.NoFileExists(t, filepath.Join(homeDir, "inbox", "file2"))
+
+	err = dataprovider.DeleteUser(username)
+	assert.NoError(t, err)
+	err = os.RemoveAll(homeDir)
+	assert.NoError(t, err)
+}
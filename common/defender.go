@@ -12,6 +12,7 @@ import (
 
 	"github.com/yl2chen/cidranger"
 
+	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/utils"
 )
@@ -24,6 +25,7 @@ const (
 	HostEventLoginFailed HostEvent = iota
 	HostEventUserNotFound
 	HostEventNoLoginTried
+	HostEventProtocolViolation
 )
 
 // Defender defines the interface that a defender must implements
@@ -32,6 +34,7 @@ type Defender interface {
 	IsBanned(ip string) bool
 	GetBanTime(ip string) *time.Time
 	GetScore(ip string) int
+	Ban(ip string) bool
 	Unban(ip string) bool
 	Reload() error
 }
@@ -51,6 +54,9 @@ type DefenderConfig struct {
 	ScoreInvalid int `json:"score_invalid" mapstructure:"score_invalid"`
 	// Score for valid login attempts, eg. user accounts that exist
 	ScoreValid int `json:"score_valid" mapstructure:"score_valid"`
+	// Score for protocol violations, eg. clients sending malformed or unexpected
+	// data before authenticating. 0 means ScoreInvalid is used
+	ScoreProtocolViolation int `json:"score_protocol_violation" mapstructure:"score_protocol_violation"`
 	// Defines the time window, in minutes, for tracking client errors.
 	// A host is banned if it has exceeded the defined threshold during
 	// the last observation time minutes
@@ -63,6 +69,8 @@ type DefenderConfig struct {
 	SafeListFile string `json:"safelist_file" mapstructure:"safelist_file"`
 	// Path to a file containing a list of ip addresses and/or networks to always ban
 	BlockListFile string `json:"blocklist_file" mapstructure:"blocklist_file"`
+	// Tarpit configuration for the connections from banned hosts
+	Tarpit TarpitConfig `json:"tarpit" mapstructure:"tarpit"`
 }
 
 type memoryDefender struct {
@@ -124,6 +132,10 @@ func (c *DefenderConfig) validate() error {
 	if c.ScoreValid >= c.Threshold {
 		return fmt.Errorf("score_valid %v cannot be greater than threshold %v", c.ScoreValid, c.Threshold)
 	}
+	if c.ScoreProtocolViolation < 0 || (c.ScoreProtocolViolation > 0 && c.ScoreProtocolViolation >= c.Threshold) {
+		return fmt.Errorf("invalid score_protocol_violation %v, it must be >= 0 and less than threshold %v",
+			c.ScoreProtocolViolation, c.Threshold)
+	}
 	if c.BanTime <= 0 {
 		return fmt.Errorf("invalid ban_time %v", c.BanTime)
 	}
@@ -140,7 +152,7 @@ func (c *DefenderConfig) validate() error {
 		return fmt.Errorf("invalid entries_hard_limit %v must be > %v", c.EntriesHardLimit, c.EntriesSoftLimit)
 	}
 
-	return nil
+	return c.Tarpit.validate()
 }
 
 func newInMemoryDefender(config *DefenderConfig) (Defender, error) {
@@ -221,6 +233,23 @@ func (d *memoryDefender) IsBanned(ip string) bool {
 	return false
 }
 
+// Ban bans the specified IP address for the configured ban time.
+// IP addresses in the safe list are never banned
+func (d *memoryDefender) Ban(ip string) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.safeList != nil && d.safeList.isListed(ip) {
+		return false
+	}
+
+	d.banned[ip] = time.Now().Add(time.Duration(d.config.BanTime) * time.Minute)
+	delete(d.hosts, ip)
+	d.cleanupBanned()
+
+	return true
+}
+
 // Unban removes the specified IP address from the banned ones
 func (d *memoryDefender) Unban(ip string) bool {
 	d.Lock()
@@ -251,6 +280,11 @@ func (d *memoryDefender) AddEvent(ip string, event HostEvent) {
 		score = d.config.ScoreValid
 	case HostEventUserNotFound, HostEventNoLoginTried:
 		score = d.config.ScoreInvalid
+	case HostEventProtocolViolation:
+		score = d.config.ScoreProtocolViolation
+		if score == 0 {
+			score = d.config.ScoreInvalid
+		}
 	}
 
 	ev := hostEvent{
@@ -273,9 +307,16 @@ func (d *memoryDefender) AddEvent(ip string, event HostEvent) {
 
 		hs.Events = hs.Events[:idx]
 		if hs.TotalScore >= d.config.Threshold {
-			d.banned[ip] = time.Now().Add(time.Duration(d.config.BanTime) * time.Minute)
+			banTime := time.Now().Add(time.Duration(d.config.BanTime) * time.Minute)
+			d.banned[ip] = banTime
 			delete(d.hosts, ip)
 			d.cleanupBanned()
+			// the event is stored without holding the lock
+			go dataprovider.AddAuditEvent(dataprovider.AuditEvent{
+				Action:  dataprovider.AuditActionHostBanned,
+				IP:      ip,
+				Details: fmt.Sprintf("score %v, banned until %v", hs.TotalScore, banTime.UTC().Format(time.RFC3339)),
+			})
 		} else {
 			d.hosts[ip] = hs
 		}
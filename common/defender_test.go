@@ -144,6 +144,12 @@ func TestBasicDefender(t *testing.T) {
 
 	assert.True(t, defender.Unban(testIP3))
 	assert.False(t, defender.Unban(testIP3))
+	// safe listed IP addresses cannot be banned
+	assert.False(t, defender.Ban("172.16.1.4"))
+	assert.Nil(t, defender.GetBanTime("172.16.1.4"))
+	assert.True(t, defender.Ban(testIP3))
+	assert.True(t, defender.IsBanned(testIP3))
+	assert.True(t, defender.Unban(testIP3))
 
 	err = os.Remove(slFile)
 	assert.NoError(t, err)
@@ -350,6 +356,64 @@ func TestDefenderConfig(t *testing.T) {
 	c.EntriesHardLimit = 20
 	err = c.validate()
 	require.NoError(t, err)
+
+	c.ScoreProtocolViolation = -1
+	err = c.validate()
+	require.Error(t, err)
+
+	c.ScoreProtocolViolation = 10
+	err = c.validate()
+	require.Error(t, err)
+
+	c.ScoreProtocolViolation = 3
+	err = c.validate()
+	require.NoError(t, err)
+
+	c.Tarpit.Enabled = true
+	err = c.validate()
+	require.Error(t, err)
+
+	c.Tarpit.Interval = 10
+	c.Tarpit.MaxDuration = -1
+	err = c.validate()
+	require.Error(t, err)
+
+	c.Tarpit.MaxDuration = 0
+	err = c.validate()
+	require.Error(t, err)
+
+	c.Tarpit.MaxConnections = 10
+	err = c.validate()
+	require.NoError(t, err)
+}
+
+func TestDefenderProtocolViolation(t *testing.T) {
+	config := &DefenderConfig{
+		Enabled:          true,
+		BanTime:          10,
+		BanTimeIncrement: 2,
+		Threshold:        8,
+		ScoreInvalid:     2,
+		ScoreValid:       1,
+		ObservationTime:  15,
+		EntriesSoftLimit: 10,
+		EntriesHardLimit: 20,
+	}
+	d, err := newInMemoryDefender(config)
+	require.NoError(t, err)
+	testIP := "12.34.56.90"
+	// if not configured the score for invalid logins is used
+	d.AddEvent(testIP, HostEventProtocolViolation)
+	assert.Equal(t, 2, d.GetScore(testIP))
+
+	config.ScoreProtocolViolation = 5
+	d, err = newInMemoryDefender(config)
+	require.NoError(t, err)
+	d.AddEvent(testIP, HostEventProtocolViolation)
+	assert.Equal(t, 5, d.GetScore(testIP))
+	assert.False(t, d.IsBanned(testIP))
+	d.AddEvent(testIP, HostEventProtocolViolation)
+	assert.True(t, d.IsBanned(testIP))
 }
 
 func BenchmarkDefenderBannedSearch(b *testing.B) {
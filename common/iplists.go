@@ -0,0 +1,96 @@
+package common
+
+import (
+	"sync"
+
+	"github.com/drakkan/sftpgo/geoip"
+	"github.com/drakkan/sftpgo/logger"
+)
+
+// IPListsConfig defines the global allow and deny lists for the client IP addresses.
+// They are checked as soon as a client connects, before the defender, and they
+// can be reloaded at runtime without affecting the existing sessions
+type IPListsConfig struct {
+	// Path to a file containing the IP addresses and/or networks allowed to connect.
+	// If set, the clients not included in this list are refused
+	AllowListFile string `json:"allowlist_file" mapstructure:"allowlist_file"`
+	// Path to a file containing the IP addresses and/or networks not allowed to connect.
+	// The deny list is evaluated before the allow list
+	DenyListFile string `json:"denylist_file" mapstructure:"denylist_file"`
+}
+
+func (c *IPListsConfig) isEnabled() bool {
+	return c.AllowListFile != "" || c.DenyListFile != ""
+}
+
+type ipLists struct {
+	config IPListsConfig
+	sync.RWMutex
+	allowList *HostList
+	denyList  *HostList
+}
+
+func newIPLists(config IPListsConfig) (*ipLists, error) {
+	l := &ipLists{
+		config: config,
+	}
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// reload loads the configured lists. If an error is returned the
+// previously loaded lists are not modified
+func (l *ipLists) reload() error {
+	allowList, err := loadHostListFromFile(l.config.AllowListFile)
+	if err != nil {
+		return err
+	}
+	denyList, err := loadHostListFromFile(l.config.DenyListFile)
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.allowList = allowList
+	l.denyList = denyList
+	return nil
+}
+
+// isAllowed returns true if the given IP is allowed to connect
+func (l *ipLists) isAllowed(ip string) bool {
+	l.RLock()
+	defer l.RUnlock()
+
+	if l.denyList != nil && l.denyList.isListed(ip) {
+		return false
+	}
+	if l.config.AllowListFile != "" {
+		return l.allowList != nil && l.allowList.isListed(ip)
+	}
+	return true
+}
+
+// IsIPAllowed returns false if the given IP is not allowed to connect
+// based on the configured allow and deny lists and on the allowed and
+// denied countries
+func IsIPAllowed(ip string) bool {
+	if Config.ipLists != nil && !Config.ipLists.isAllowed(ip) {
+		logger.Debug(logSender, "", "ip %#v is not allowed by the configured ip lists", ip)
+		return false
+	}
+	return geoip.IsIPAllowed(ip)
+}
+
+// ReloadIPLists reloads the allow and deny lists.
+// The existing sessions are not affected
+func ReloadIPLists() error {
+	if Config.ipLists == nil {
+		return nil
+	}
+
+	return Config.ipLists.reload()
+}
@@ -0,0 +1,119 @@
+package common
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeHostListFile(t *testing.T, name string, hl HostListFile) {
+	data, err := json.Marshal(hl)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(name, data, os.ModePerm)
+	require.NoError(t, err)
+}
+
+func TestIPLists(t *testing.T) {
+	allowListFile := filepath.Join(os.TempDir(), "allowlist.json")
+	denyListFile := filepath.Join(os.TempDir(), "denylist.json")
+	writeHostListFile(t, allowListFile, HostListFile{
+		IPAddresses:  []string{"172.18.1.1"},
+		CIDRNetworks: []string{"10.9.0.0/16"},
+	})
+	writeHostListFile(t, denyListFile, HostListFile{
+		IPAddresses:  []string{"10.9.1.1"},
+		CIDRNetworks: []string{"10.9.2.0/24"},
+	})
+
+	lists, err := newIPLists(IPListsConfig{
+		AllowListFile: allowListFile,
+		DenyListFile:  denyListFile,
+	})
+	require.NoError(t, err)
+	assert.True(t, lists.isAllowed("172.18.1.1"))
+	assert.True(t, lists.isAllowed("10.9.3.1"))
+	assert.False(t, lists.isAllowed("172.18.1.2"))
+	// the deny list is evaluated before the allow list
+	assert.False(t, lists.isAllowed("10.9.1.1"))
+	assert.False(t, lists.isAllowed("10.9.2.5"))
+
+	writeHostListFile(t, allowListFile, HostListFile{
+		IPAddresses: []string{"172.18.1.2"},
+	})
+	assert.NoError(t, lists.reload())
+	assert.False(t, lists.isAllowed("172.18.1.1"))
+	assert.True(t, lists.isAllowed("172.18.1.2"))
+	// an invalid list does not replace the loaded ones
+	err = ioutil.WriteFile(denyListFile, []byte("not a json"), os.ModePerm)
+	require.NoError(t, err)
+	assert.Error(t, lists.reload())
+	assert.True(t, lists.isAllowed("172.18.1.2"))
+	assert.False(t, lists.isAllowed("10.9.1.1"))
+
+	lists, err = newIPLists(IPListsConfig{
+		DenyListFile: denyListFile,
+	})
+	assert.Error(t, err)
+	assert.Nil(t, lists)
+
+	writeHostListFile(t, denyListFile, HostListFile{
+		IPAddresses: []string{"10.9.1.1"},
+	})
+	lists, err = newIPLists(IPListsConfig{
+		DenyListFile: denyListFile,
+	})
+	require.NoError(t, err)
+	// without an allow list any IP not denied is allowed
+	assert.True(t, lists.isAllowed("172.18.1.1"))
+	assert.False(t, lists.isAllowed("10.9.1.1"))
+
+	err = os.Remove(allowListFile)
+	assert.NoError(t, err)
+	err = os.Remove(denyListFile)
+	assert.NoError(t, err)
+}
+
+func TestIPListsHelpers(t *testing.T) {
+	configCopy := Config
+
+	Config.ipLists = nil
+	assert.True(t, IsIPAllowed("172.19.1.1"))
+	assert.NoError(t, ReloadIPLists())
+
+	allowListFile := filepath.Join(os.TempDir(), "allowlist.json")
+	c := Configuration{
+		IPLists: IPListsConfig{
+			AllowListFile: allowListFile,
+		},
+	}
+	err := Initialize(c)
+	assert.Error(t, err)
+
+	writeHostListFile(t, allowListFile, HostListFile{
+		IPAddresses: []string{"172.19.1.1"},
+	})
+	err = Initialize(c)
+	require.NoError(t, err)
+	require.NotNil(t, Config.ipLists)
+	assert.True(t, IsIPAllowed("172.19.1.1"))
+	assert.False(t, IsIPAllowed("172.19.1.2"))
+
+	writeHostListFile(t, allowListFile, HostListFile{
+		IPAddresses: []string{"172.19.1.2"},
+	})
+	assert.NoError(t, ReloadIPLists())
+	assert.False(t, IsIPAllowed("172.19.1.1"))
+	assert.True(t, IsIPAllowed("172.19.1.2"))
+
+	err = os.Remove(allowListFile)
+	assert.NoError(t, err)
+	assert.Error(t, ReloadIPLists())
+	assert.True(t, IsIPAllowed("172.19.1.2"))
+
+	Config = configCopy
+}
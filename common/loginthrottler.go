@@ -0,0 +1,214 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// maximum number of tracked hosts, the least recently seen host is evicted
+	// if the limit is reached and no host is expired
+	throttlerEntriesLimit = 5000
+	// maximum number of usernames tracked for each host, failures for other
+	// usernames are still counted but they cannot be reset by a successful login
+	throttlerUsernamesLimit = 20
+)
+
+// LoginThrottlingConfig defines the configuration for the delays applied after failed
+// authentications and for the per-IP login rate limit. They are applied to all the
+// supported protocols and they are independent of the defender
+type LoginThrottlingConfig struct {
+	// Delay, as milliseconds, to apply after a failed authentication. The delay doubles for each
+	// consecutive failed authentication from the same IP address, up to MaxDelay.
+	// 0 means disabled
+	Delay int `json:"delay" mapstructure:"delay"`
+	// Maximum delay, as milliseconds, to apply after a failed authentication
+	MaxDelay int `json:"max_delay" mapstructure:"max_delay"`
+	// Maximum number of login attempts allowed from the same IP address within
+	// the configured period. 0 means unlimited
+	MaxAttempts int `json:"max_attempts" mapstructure:"max_attempts"`
+	// Time window, as seconds, for tracking login attempts and consecutive failures
+	Period int `json:"period" mapstructure:"period"`
+}
+
+func (c *LoginThrottlingConfig) isEnabled() bool {
+	return c.Delay > 0 || c.MaxAttempts > 0
+}
+
+func (c *LoginThrottlingConfig) validate() error {
+	if !c.isEnabled() {
+		return nil
+	}
+	if c.Delay < 0 {
+		return fmt.Errorf("invalid delay %v", c.Delay)
+	}
+	if c.MaxDelay < c.Delay {
+		return fmt.Errorf("invalid max_delay %v must be >= %v", c.MaxDelay, c.Delay)
+	}
+	if c.MaxAttempts < 0 {
+		return fmt.Errorf("invalid max_attempts %v", c.MaxAttempts)
+	}
+	if c.Period <= 0 {
+		return fmt.Errorf("invalid period %v", c.Period)
+	}
+	return nil
+}
+
+type throttledHost struct {
+	attempts []time.Time
+	// consecutive failures within the configured period for all the usernames
+	failures int
+	// consecutive failures for each username, a successful login for
+	// a username only clears the failures recorded for it
+	userFailures map[string]int
+	lastFailure  time.Time
+	lastSeen     time.Time
+}
+
+type loginThrottler struct {
+	config LoginThrottlingConfig
+	period time.Duration
+	sync.Mutex
+	// the key is the host IP
+	hosts map[string]*throttledHost
+}
+
+func newLoginThrottler(config *LoginThrottlingConfig) (*loginThrottler, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &loginThrottler{
+		config: *config,
+		period: time.Duration(config.Period) * time.Second,
+		hosts:  make(map[string]*throttledHost),
+	}, nil
+}
+
+// getHost returns the entry for the given IP, creating it if needed.
+// The lock must be held by the caller
+func (t *loginThrottler) getHost(ip string, now time.Time) *throttledHost {
+	h, ok := t.hosts[ip]
+	if !ok {
+		if len(t.hosts) >= throttlerEntriesLimit {
+			t.removeExpired(now)
+			if len(t.hosts) >= throttlerEntriesLimit {
+				t.removeLeastRecentlySeen()
+			}
+		}
+		h = &throttledHost{}
+		t.hosts[ip] = h
+	}
+	h.lastSeen = now
+	return h
+}
+
+// removeExpired removes the hosts with no attempts or failures within the
+// configured period. The lock must be held by the caller
+func (t *loginThrottler) removeExpired(now time.Time) {
+	for ip, h := range t.hosts {
+		if now.Sub(h.lastFailure) > t.period && !h.hasAttemptsAfter(now.Add(-t.period)) {
+			delete(t.hosts, ip)
+		}
+	}
+}
+
+// removeLeastRecentlySeen removes the host with the oldest activity.
+// The lock must be held by the caller
+func (t *loginThrottler) removeLeastRecentlySeen() {
+	var oldestIP string
+	var oldest time.Time
+	for ip, h := range t.hosts {
+		if oldestIP == "" || h.lastSeen.Before(oldest) {
+			oldestIP = ip
+			oldest = h.lastSeen
+		}
+	}
+	delete(t.hosts, oldestIP)
+}
+
+func (h *throttledHost) hasAttemptsAfter(limit time.Time) bool {
+	return len(h.attempts) > 0 && h.attempts[len(h.attempts)-1].After(limit)
+}
+
+// addAttempt records a login attempt for the given IP and returns an error
+// if the configured rate limit is exceeded
+func (t *loginThrottler) addAttempt(ip string) error {
+	if t.config.MaxAttempts <= 0 {
+		return nil
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	now := time.Now()
+	h := t.getHost(ip, now)
+	limit := now.Add(-t.period)
+	idx := 0
+	for idx < len(h.attempts) && !h.attempts[idx].After(limit) {
+		idx++
+	}
+	h.attempts = h.attempts[idx:]
+	if len(h.attempts) >= t.config.MaxAttempts {
+		return ErrLoginRateLimited
+	}
+	h.attempts = append(h.attempts, now)
+	return nil
+}
+
+// addFailure records a failed login for the given IP and username and returns the delay to apply
+func (t *loginThrottler) addFailure(ip, username string) time.Duration {
+	if t.config.Delay <= 0 {
+		return 0
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	now := time.Now()
+	h := t.getHost(ip, now)
+	if now.Sub(h.lastFailure) > t.period {
+		h.failures = 0
+		h.userFailures = nil
+	}
+	h.failures++
+	h.lastFailure = now
+	if h.userFailures == nil {
+		h.userFailures = make(map[string]int)
+	}
+	if _, ok := h.userFailures[username]; ok || len(h.userFailures) < throttlerUsernamesLimit {
+		h.userFailures[username]++
+	}
+
+	delay := time.Duration(t.config.Delay) * time.Millisecond
+	maxDelay := time.Duration(t.config.MaxDelay) * time.Millisecond
+	for i := 1; i < h.failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// reset clears the consecutive failures recorded for the given IP and username.
+// The failures for other usernames are preserved, so a successful login for
+// a valid account does not reset the delays for a credential stuffing attack
+// from the same IP
+func (t *loginThrottler) reset(ip, username string) {
+	t.Lock()
+	defer t.Unlock()
+
+	h, ok := t.hosts[ip]
+	if !ok {
+		return
+	}
+	h.failures -= h.userFailures[username]
+	delete(h.userFailures, username)
+	if h.failures <= 0 {
+		h.failures = 0
+		h.userFailures = nil
+		h.lastFailure = time.Time{}
+		if len(h.attempts) == 0 {
+			delete(t.hosts, ip)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginThrottlingConfig(t *testing.T) {
+	config := LoginThrottlingConfig{}
+	assert.False(t, config.isEnabled())
+	assert.NoError(t, config.validate())
+
+	config.Delay = 100
+	config.MaxDelay = 50
+	assert.Error(t, config.validate())
+	config.MaxDelay = 1000
+	assert.Error(t, config.validate())
+	config.Period = 10
+	assert.NoError(t, config.validate())
+	config.MaxAttempts = -1
+	assert.Error(t, config.validate())
+	config.Delay = 0
+	config.MaxAttempts = 10
+	assert.NoError(t, config.validate())
+	config.Delay = -1
+	assert.Error(t, config.validate())
+
+	_, err := newLoginThrottler(&config)
+	assert.Error(t, err)
+}
+
+func TestLoginRateLimit(t *testing.T) {
+	throttler, err := newLoginThrottler(&LoginThrottlingConfig{
+		MaxAttempts: 3,
+		Period:      1,
+	})
+	require.NoError(t, err)
+	ip := "127.1.1.1"
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, throttler.addAttempt(ip))
+	}
+	assert.ErrorIs(t, throttler.addAttempt(ip), ErrLoginRateLimited)
+	assert.NoError(t, throttler.addAttempt("127.1.1.2"))
+	// delays are disabled
+	assert.Equal(t, time.Duration(0), throttler.addFailure(ip, "user"))
+	// a successful login does not reset the attempts
+	throttler.reset(ip, "user")
+	assert.ErrorIs(t, throttler.addAttempt(ip), ErrLoginRateLimited)
+	time.Sleep(1100 * time.Millisecond)
+	assert.NoError(t, throttler.addAttempt(ip))
+	throttler.Lock()
+	assert.Len(t, throttler.hosts[ip].attempts, 1)
+	throttler.Unlock()
+}
+
+func TestLoginDelay(t *testing.T) {
+	throttler, err := newLoginThrottler(&LoginThrottlingConfig{
+		Delay:    100,
+		MaxDelay: 500,
+		Period:   1,
+	})
+	require.NoError(t, err)
+	ip := "127.2.2.2"
+	// the rate limit is disabled
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, throttler.addAttempt(ip))
+	}
+	assert.Equal(t, 100*time.Millisecond, throttler.addFailure(ip, "user1"))
+	assert.Equal(t, 200*time.Millisecond, throttler.addFailure(ip, "user1"))
+	assert.Equal(t, 400*time.Millisecond, throttler.addFailure(ip, "user2"))
+	assert.Equal(t, 500*time.Millisecond, throttler.addFailure(ip, "user3"))
+	assert.Equal(t, 500*time.Millisecond, throttler.addFailure(ip, "user1"))
+	assert.Equal(t, 100*time.Millisecond, throttler.addFailure("127.2.2.3", "user1"))
+	// a successful login only resets the failures for the same username
+	throttler.reset(ip, "user1")
+	throttler.reset("127.2.2.4", "user1")
+	throttler.Lock()
+	assert.Equal(t, 2, throttler.hosts[ip].failures)
+	throttler.Unlock()
+	assert.Equal(t, 400*time.Millisecond, throttler.addFailure(ip, "user4"))
+	throttler.reset(ip, "user2")
+	throttler.reset(ip, "user3")
+	throttler.reset(ip, "user4")
+	throttler.Lock()
+	_, ok := throttler.hosts[ip]
+	throttler.Unlock()
+	assert.False(t, ok)
+	assert.Equal(t, 100*time.Millisecond, throttler.addFailure(ip, "user1"))
+	assert.Equal(t, 200*time.Millisecond, throttler.addFailure(ip, "user1"))
+	// consecutive failures expire after the configured period
+	time.Sleep(1100 * time.Millisecond)
+	assert.Equal(t, 100*time.Millisecond, throttler.addFailure(ip, "user1"))
+	// failures for usernames over the limit cannot be reset
+	ip = "127.2.2.5"
+	for i := 0; i < throttlerUsernamesLimit+2; i++ {
+		throttler.addFailure(ip, fmt.Sprintf("user%v", i))
+	}
+	for i := 0; i < throttlerUsernamesLimit+2; i++ {
+		throttler.reset(ip, fmt.Sprintf("user%v", i))
+	}
+	throttler.Lock()
+	assert.Equal(t, 2, throttler.hosts[ip].failures)
+	assert.Len(t, throttler.hosts[ip].userFailures, 0)
+	throttler.Unlock()
+}
+
+func TestLoginThrottlerExpiredEntries(t *testing.T) {
+	throttler, err := newLoginThrottler(&LoginThrottlingConfig{
+		Delay:       10,
+		MaxDelay:    10,
+		MaxAttempts: 10,
+		Period:      1,
+	})
+	require.NoError(t, err)
+	for i := 0; i < throttlerEntriesLimit; i++ {
+		ip := fmt.Sprintf("10.%v.%v.%v", i/65536, (i/256)%256, i%256)
+		if i%2 == 0 {
+			assert.NoError(t, throttler.addAttempt(ip))
+		} else {
+			throttler.addFailure(ip, "user")
+		}
+	}
+	throttler.Lock()
+	assert.Len(t, throttler.hosts, throttlerEntriesLimit)
+	throttler.Unlock()
+	// the least recently seen host is evicted if no host is expired
+	assert.NoError(t, throttler.addAttempt("127.3.3.3"))
+	throttler.Lock()
+	assert.Len(t, throttler.hosts, throttlerEntriesLimit)
+	_, ok := throttler.hosts["10.0.0.0"]
+	assert.False(t, ok)
+	_, ok = throttler.hosts["127.3.3.3"]
+	assert.True(t, ok)
+	throttler.Unlock()
+
+	time.Sleep(1100 * time.Millisecond)
+	assert.NoError(t, throttler.addAttempt("127.3.3.4"))
+	throttler.Lock()
+	assert.Len(t, throttler.hosts, 1)
+	throttler.Unlock()
+}
+
+func TestLoginThrottlingHelpers(t *testing.T) {
+	configCopy := Config
+
+	ip := "127.4.4.4"
+	Config.loginThrottler = nil
+	assert.NoError(t, CheckLoginAttempt(ip, ProtocolSSH))
+	DelayFailedLogin(ip, "user")
+	ResetFailedLogins(ip, "user")
+
+	c := Configuration{
+		LoginThrottling: LoginThrottlingConfig{
+			Delay:       100,
+			MaxDelay:    50,
+			MaxAttempts: 1,
+			Period:      10,
+		},
+	}
+	err := Initialize(c)
+	assert.Error(t, err)
+
+	c.LoginThrottling.MaxDelay = 200
+	err = Initialize(c)
+	require.NoError(t, err)
+	require.NotNil(t, Config.loginThrottler)
+	assert.NoError(t, CheckLoginAttempt(ip, ProtocolSSH))
+	assert.ErrorIs(t, CheckLoginAttempt(ip, ProtocolSSH), ErrLoginRateLimited)
+	start := time.Now()
+	DelayFailedLogin(ip, "user")
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	start = time.Now()
+	DelayFailedLogin(ip, "user")
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	ResetFailedLogins(ip, "user")
+	start = time.Now()
+	DelayFailedLogin(ip, "user")
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+
+	Config = configCopy
+}
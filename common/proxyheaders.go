@@ -0,0 +1,132 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// Supported request headers to get the client IP address from
+const (
+	HeaderXForwardedFor  = "X-Forwarded-For"
+	HeaderXRealIP        = "X-Real-IP"
+	HeaderCFConnectingIP = "CF-Connecting-IP"
+)
+
+var supportedClientIPHeaders = []string{HeaderXForwardedFor, HeaderXRealIP, HeaderCFConnectingIP}
+
+// TrustedProxies allows to get the client IP address from a request header
+// set by one of the configured reverse proxies
+type TrustedProxies struct {
+	allowed []func(net.IP) bool
+	header  string
+	depth   int
+}
+
+// NewTrustedProxies returns the trusted proxies for the given IP addresses and/or
+// CIDR networks. The client IP address is read from the specified header, an empty
+// header means X-Forwarded-For. For X-Forwarded-For, depth defines the number of
+// addresses to skip starting from the right, 0 means the address added by the
+// trusted proxy itself
+func NewTrustedProxies(proxies []string, header string, depth int) (*TrustedProxies, error) {
+	allowed, err := parseAllowedIPAndRanges(proxies)
+	if err != nil {
+		return nil, err
+	}
+	if header == "" {
+		header = HeaderXForwardedFor
+	}
+	found := false
+	for _, h := range supportedClientIPHeaders {
+		if strings.EqualFold(h, header) {
+			header = h
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("unsupported client IP header %#v, supported headers: %v", header,
+			strings.Join(supportedClientIPHeaders, ", "))
+	}
+	if depth < 0 {
+		return nil, fmt.Errorf("invalid client IP header depth: %v", depth)
+	}
+	return &TrustedProxies{
+		allowed: allowed,
+		header:  header,
+		depth:   depth,
+	}, nil
+}
+
+// IsTrusted returns true if the given IP address is a trusted proxy
+func (p *TrustedProxies) IsTrusted(ip string) bool {
+	if p == nil {
+		return false
+	}
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+	for _, allow := range p.allowed {
+		if allow(parsedIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetClientIP returns the client IP address set in the configured header, if the given
+// request comes from a trusted proxy. An empty string is returned if the request does
+// not come from a trusted proxy or the header does not contain a valid IP address
+func (p *TrustedProxies) GetClientIP(r *http.Request) string {
+	if !p.IsTrusted(utils.GetIPFromRemoteAddress(r.RemoteAddr)) {
+		return ""
+	}
+	var ip string
+	if p.header == HeaderXForwardedFor {
+		// the header can be repeated, the proxies append the address to the last one
+		var addresses []string
+		for _, value := range r.Header.Values(p.header) {
+			for _, addr := range strings.Split(value, ",") {
+				addresses = append(addresses, strings.TrimSpace(addr))
+			}
+		}
+		idx := len(addresses) - 1 - p.depth
+		if idx < 0 {
+			return ""
+		}
+		ip = addresses[idx]
+	} else {
+		ip = strings.TrimSpace(r.Header.Get(p.header))
+	}
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	return ip
+}
+
+// parseAllowedIPAndRanges returns a list of functions that allow to find if an
+// IP is equal or is contained within the allowed list
+func parseAllowedIPAndRanges(allowed []string) ([]func(net.IP) bool, error) {
+	res := make([]func(net.IP) bool, 0, len(allowed))
+	for _, allowFrom := range allowed {
+		allowFrom = strings.TrimSpace(allowFrom)
+		if strings.Contains(allowFrom, "/") {
+			_, ipRange, err := net.ParseCIDR(allowFrom)
+			if err != nil {
+				return nil, fmt.Errorf("given string %#v is not a valid IP range: %v", allowFrom, err)
+			}
+			res = append(res, ipRange.Contains)
+		} else {
+			allowedIP := net.ParseIP(allowFrom)
+			if allowedIP == nil {
+				return nil, fmt.Errorf("given string %#v is not a valid IP address", allowFrom)
+			}
+			res = append(res, allowedIP.Equal)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,80 @@
+package common
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTrustedProxies(t *testing.T) {
+	_, err := NewTrustedProxies([]string{"invalid ip"}, "", 0)
+	assert.Error(t, err)
+	_, err = NewTrustedProxies([]string{"192.168.1.0/33"}, "", 0)
+	assert.Error(t, err)
+	_, err = NewTrustedProxies(nil, "X-Client-IP", 0)
+	assert.Error(t, err)
+	_, err = NewTrustedProxies(nil, "", -1)
+	assert.Error(t, err)
+
+	proxies, err := NewTrustedProxies([]string{" 10.8.0.1", "192.168.1.0/24", "2001:db8::/32"}, "x-real-ip", 0)
+	require.NoError(t, err)
+	assert.Equal(t, HeaderXRealIP, proxies.header)
+	assert.True(t, proxies.IsTrusted("10.8.0.1"))
+	assert.False(t, proxies.IsTrusted("10.8.0.2"))
+	assert.True(t, proxies.IsTrusted("192.168.1.12"))
+	assert.True(t, proxies.IsTrusted("2001:db8::1"))
+	assert.False(t, proxies.IsTrusted("invalid ip"))
+
+	proxies, err = NewTrustedProxies(nil, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, HeaderXForwardedFor, proxies.header)
+	assert.False(t, proxies.IsTrusted("10.8.0.1"))
+
+	proxies = nil
+	assert.False(t, proxies.IsTrusted("10.8.0.1"))
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	req.RemoteAddr = "10.8.0.1:1234"
+	req.Header.Set(HeaderXForwardedFor, "172.16.1.1")
+	assert.Empty(t, proxies.GetClientIP(req))
+}
+
+func TestGetClientIPFromProxyHeaders(t *testing.T) {
+	proxies, err := NewTrustedProxies([]string{"10.8.0.0/24"}, "", 0)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	req.RemoteAddr = "10.9.0.1:1234"
+	req.Header.Set(HeaderXForwardedFor, "172.16.1.1")
+	assert.Empty(t, proxies.GetClientIP(req))
+
+	req.RemoteAddr = "10.8.0.1:1234"
+	assert.Equal(t, "172.16.1.1", proxies.GetClientIP(req))
+	req.Header.Set(HeaderXForwardedFor, "192.168.1.1, 172.16.1.2")
+	assert.Equal(t, "172.16.1.2", proxies.GetClientIP(req))
+	req.Header.Add(HeaderXForwardedFor, "172.16.1.3")
+	assert.Equal(t, "172.16.1.3", proxies.GetClientIP(req))
+	req.Header.Set(HeaderXForwardedFor, "invalid ip")
+	assert.Empty(t, proxies.GetClientIP(req))
+	req.Header.Del(HeaderXForwardedFor)
+	assert.Empty(t, proxies.GetClientIP(req))
+	// other headers are ignored
+	req.Header.Set(HeaderXRealIP, "172.16.1.1")
+	assert.Empty(t, proxies.GetClientIP(req))
+
+	proxies, err = NewTrustedProxies([]string{"10.8.0.0/24"}, "", 1)
+	require.NoError(t, err)
+	req.Header.Set(HeaderXForwardedFor, "192.168.1.1, 172.16.1.2")
+	assert.Equal(t, "192.168.1.1", proxies.GetClientIP(req))
+	req.Header.Set(HeaderXForwardedFor, "172.16.1.2")
+	assert.Empty(t, proxies.GetClientIP(req))
+
+	proxies, err = NewTrustedProxies([]string{"10.8.0.0/24"}, HeaderCFConnectingIP, 0)
+	require.NoError(t, err)
+	assert.Empty(t, proxies.GetClientIP(req))
+	req.Header.Set(HeaderCFConnectingIP, " 2001:db8::2 ")
+	assert.Equal(t, "2001:db8::2", proxies.GetClientIP(req))
+}
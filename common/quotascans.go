@@ -0,0 +1,300 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+const (
+	// the cron schedule is evaluated once per minute
+	cronCheckInterval = 1 * time.Minute
+	// users and folders are loaded from the data provider in pages of this size
+	quotaScanPageSize = 100
+)
+
+var (
+	quotaScanTicker     *time.Ticker
+	quotaScanTickerDone chan bool
+)
+
+// QuotaScanScheduleConfig defines when to periodically scan the users and virtual folders quotas.
+// The scans update the used quota stored in the data provider, so any drift caused by changes
+// made outside SFTPGo is automatically corrected.
+// Interval and Cron are mutually exclusive
+type QuotaScanScheduleConfig struct {
+	// Interval, as minutes, between two consecutive scans. 0 means disabled
+	Interval int `json:"interval" mapstructure:"interval"`
+	// Cron expression, in UTC time, with the standard five fields:
+	// minute, hour, day of month, month and day of week. Empty means disabled
+	Cron string `json:"cron" mapstructure:"cron"`
+	// parsed cron expression
+	schedule *cronSchedule
+}
+
+func (c *QuotaScanScheduleConfig) isEnabled() bool {
+	return c.Interval > 0 || c.Cron != ""
+}
+
+func (c *QuotaScanScheduleConfig) validate() error {
+	schedule, err := parseScheduleConfig(c.Interval, c.Cron)
+	c.schedule = schedule
+	return err
+}
+
+// parseScheduleConfig validates a schedule defined using an interval, as minutes,
+// or a cron expression and returns the parsed cron expression, if any
+func parseScheduleConfig(interval int, cron string) (*cronSchedule, error) {
+	if interval < 0 {
+		return nil, fmt.Errorf("invalid interval: %v", interval)
+	}
+	if cron == "" {
+		return nil, nil
+	}
+	if interval > 0 {
+		return nil, errors.New("interval and cron expression cannot be set together")
+	}
+	return parseCronExpression(cron)
+}
+
+// the ticker cannot be started/stopped from multiple goroutines
+func startQuotaScanTicker(config QuotaScanScheduleConfig) {
+	stopQuotaScanTicker()
+	duration := cronCheckInterval
+	if config.Interval > 0 {
+		duration = time.Duration(config.Interval) * time.Minute
+	}
+	quotaScanTicker = time.NewTicker(duration)
+	quotaScanTickerDone = make(chan bool)
+	logger.Info(logSender, "", "scheduled quota scans started, interval: %v, cron: %#v", config.Interval, config.Cron)
+	go func() {
+		for {
+			select {
+			case <-quotaScanTickerDone:
+				return
+			case t := <-quotaScanTicker.C:
+				if config.schedule != nil && !config.schedule.matches(t.UTC()) {
+					continue
+				}
+				runScheduledQuotaScans()
+			}
+		}
+	}()
+}
+
+func stopQuotaScanTicker() {
+	if quotaScanTicker != nil {
+		quotaScanTicker.Stop()
+		quotaScanTickerDone <- true
+		quotaScanTicker = nil
+	}
+}
+
+// runScheduledQuotaScans scans, one at a time, the users and the virtual folders.
+// Users and folders with a scan already in progress are skipped
+func runScheduledQuotaScans() {
+	quotaTracking := dataprovider.GetQuotaTracking()
+	if quotaTracking == 0 {
+		logger.Debug(logSender, "", "quota tracking is disabled, scheduled quota scans skipped")
+		return
+	}
+	startTime := time.Now()
+	numUsers := 0
+	numFolders := 0
+	for offset := 0; ; offset += quotaScanPageSize {
+		users, err := dataprovider.GetUsers(quotaScanPageSize, offset, dataprovider.OrderASC)
+		if err != nil {
+			logger.Warn(logSender, "", "scheduled quota scans, unable to get users: %v", err)
+			break
+		}
+		for idx := range users {
+			if quotaTracking == 2 && !users[idx].HasQuotaRestrictions() {
+				continue
+			}
+			// the listed users have the confidential data hidden
+			user, err := dataprovider.UserExists(users[idx].Username)
+			if err != nil {
+				continue
+			}
+			if QuotaScans.AddUserQuotaScan(user.Username) {
+				if DoUserQuotaScan(user) == nil {
+					numUsers++
+				}
+			}
+		}
+		if len(users) < quotaScanPageSize {
+			break
+		}
+	}
+	for offset := 0; ; offset += quotaScanPageSize {
+		folders, err := dataprovider.GetFolders(quotaScanPageSize, offset, dataprovider.OrderASC)
+		if err != nil {
+			logger.Warn(logSender, "", "scheduled quota scans, unable to get folders: %v", err)
+			break
+		}
+		for idx := range folders {
+			if QuotaScans.AddVFolderQuotaScan(folders[idx].Name) {
+				if DoFolderQuotaScan(folders[idx]) == nil {
+					numFolders++
+				}
+			}
+		}
+		if len(folders) < quotaScanPageSize {
+			break
+		}
+	}
+	logger.Info(logSender, "", "scheduled quota scans completed, scanned users: %v, scanned folders: %v, elapsed: %v",
+		numUsers, numFolders, time.Since(startTime))
+}
+
+// DoUserQuotaScan scans the user's home directory and resets the used quota.
+// The scan must be already registered using QuotaScans.AddUserQuotaScan,
+// it is removed when this method returns
+func DoUserQuotaScan(user dataprovider.User) error {
+	defer QuotaScans.RemoveUserQuotaScan(user.Username)
+	fs, err := user.GetFilesystem("")
+	if err != nil {
+		logger.Warn(logSender, "", "unable scan quota for user %#v error creating filesystem: %v", user.Username, err)
+		return err
+	}
+	defer fs.Close()
+	numFiles, size, err := fs.ScanRootDirContents()
+	if err != nil {
+		logger.Warn(logSender, "", "error scanning user home dir %#v: %v", user.Username, err)
+		return err
+	}
+	err = dataprovider.UpdateUserQuota(&user, numFiles, size, true)
+	logger.Debug(logSender, "", "user home dir scanned, user: %#v, error: %v", user.Username, err)
+	return err
+}
+
+// DoFolderQuotaScan scans the virtual folder and resets the used quota.
+// The scan must be already registered using QuotaScans.AddVFolderQuotaScan,
+// it is removed when this method returns
+func DoFolderQuotaScan(folder vfs.BaseVirtualFolder) error {
+	defer QuotaScans.RemoveVFolderQuotaScan(folder.Name)
+	fs := vfs.NewOsFs("", "", nil).(*vfs.OsFs)
+	numFiles, size, err := fs.GetDirSize(folder.MappedPath)
+	if err != nil {
+		logger.Warn(logSender, "", "error scanning folder %#v: %v", folder.MappedPath, err)
+		return err
+	}
+	err = dataprovider.UpdateVirtualFolderQuota(&folder, numFiles, size, true)
+	logger.Debug(logSender, "", "virtual folder %#v scanned, error: %v", folder.Name, err)
+	return err
+}
+
+// cronSchedule is a parsed five fields cron expression,
+// each field is a bit set of the allowed values
+type cronSchedule struct {
+	minutes     uint64
+	hours       uint64
+	daysOfMonth uint64
+	months      uint64
+	daysOfWeek  uint64
+	// true if the day of month/week field is "*"
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+// matches returns true if the given time, truncated to the minute, matches the schedule.
+// As in the standard cron, if both day of month and day of week are restricted
+// a time matching either one matches
+func (s *cronSchedule) matches(t time.Time) bool {
+	if s.minutes&(1<<uint(t.Minute())) == 0 || s.hours&(1<<uint(t.Hour())) == 0 ||
+		s.months&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domMatch := s.daysOfMonth&(1<<uint(t.Day())) != 0
+	dowMatch := s.daysOfWeek&(1<<uint(t.Weekday())) != 0
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func parseCronExpression(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %#v: five fields are required", expr)
+	}
+	var err error
+	s := &cronSchedule{
+		anyDayOfMonth: fields[2] == "*",
+		anyDayOfWeek:  fields[4] == "*",
+	}
+	if s.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %#v, minutes: %w", expr, err)
+	}
+	if s.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %#v, hours: %w", expr, err)
+	}
+	if s.daysOfMonth, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %#v, day of month: %w", expr, err)
+	}
+	if s.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %#v, month: %w", expr, err)
+	}
+	// 7 is an alias for Sunday
+	if s.daysOfWeek, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %#v, day of week: %w", expr, err)
+	}
+	if s.daysOfWeek&(1<<7) != 0 {
+		s.daysOfWeek |= 1
+	}
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of "*", "value" or "start-end"
+// items, each one can be followed by a "/step"
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rangeExpr := item
+		step := 1
+		if idx := strings.Index(item, "/"); idx >= 0 {
+			rangeExpr = item[:idx]
+			val, err := strconv.Atoi(item[idx+1:])
+			if err != nil || val <= 0 {
+				return 0, fmt.Errorf("invalid step in %#v", item)
+			}
+			step = val
+		}
+		start, end := min, max
+		switch {
+		case rangeExpr == "*":
+		case strings.Contains(rangeExpr, "-"):
+			parts := strings.SplitN(rangeExpr, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(parts[0]); err != nil {
+				return 0, fmt.Errorf("invalid range %#v", item)
+			}
+			if end, err = strconv.Atoi(parts[1]); err != nil {
+				return 0, fmt.Errorf("invalid range %#v", item)
+			}
+		default:
+			val, err := strconv.Atoi(rangeExpr)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %#v", item)
+			}
+			start = val
+			end = val
+			if step > 1 {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("%#v is out of range [%v-%v]", item, min, max)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
@@ -0,0 +1,171 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+func TestCronExpression(t *testing.T) {
+	_, err := parseCronExpression("* * * *")
+	assert.Error(t, err)
+	_, err = parseCronExpression("60 * * * *")
+	assert.Error(t, err)
+	_, err = parseCronExpression("* 5-2 * * *")
+	assert.Error(t, err)
+	_, err = parseCronExpression("*/0 * * * *")
+	assert.Error(t, err)
+	_, err = parseCronExpression("* * 0 * *")
+	assert.Error(t, err)
+	_, err = parseCronExpression("* * * a *")
+	assert.Error(t, err)
+	_, err = parseCronExpression("* * * * 1-a")
+	assert.Error(t, err)
+
+	s, err := parseCronExpression("*/15 2,4-5 * * *")
+	require.NoError(t, err)
+	assert.True(t, s.matches(time.Date(2021, 6, 10, 2, 30, 0, 0, time.UTC)))
+	assert.True(t, s.matches(time.Date(2021, 6, 10, 5, 45, 0, 0, time.UTC)))
+	assert.False(t, s.matches(time.Date(2021, 6, 10, 3, 0, 0, 0, time.UTC)))
+	assert.False(t, s.matches(time.Date(2021, 6, 10, 4, 10, 0, 0, time.UTC)))
+	// 2021-06-13 is a Sunday
+	s, err = parseCronExpression("0 0 * * 7")
+	require.NoError(t, err)
+	assert.True(t, s.matches(time.Date(2021, 6, 13, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, s.matches(time.Date(2021, 6, 14, 0, 0, 0, 0, time.UTC)))
+	// day of month and day of week restricted, either one matches
+	s, err = parseCronExpression("0 0 1 * 1")
+	require.NoError(t, err)
+	assert.True(t, s.matches(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, s.matches(time.Date(2021, 6, 14, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, s.matches(time.Date(2021, 6, 15, 0, 0, 0, 0, time.UTC)))
+	s, err = parseCronExpression("30 1 1/10 1-6/2 *")
+	require.NoError(t, err)
+	assert.True(t, s.matches(time.Date(2021, 5, 21, 1, 30, 0, 0, time.UTC)))
+	assert.False(t, s.matches(time.Date(2021, 6, 21, 1, 30, 0, 0, time.UTC)))
+	assert.False(t, s.matches(time.Date(2021, 5, 20, 1, 30, 0, 0, time.UTC)))
+}
+
+func TestQuotaScanScheduleValidation(t *testing.T) {
+	c := QuotaScanScheduleConfig{}
+	assert.False(t, c.isEnabled())
+	assert.NoError(t, c.validate())
+	c.Interval = -1
+	assert.Error(t, c.validate())
+	c.Interval = 10
+	c.Cron = "0 * * * *"
+	assert.True(t, c.isEnabled())
+	assert.Error(t, c.validate())
+	c.Interval = 0
+	assert.NoError(t, c.validate())
+	assert.NotNil(t, c.schedule)
+	c.Cron = "invalid"
+	assert.Error(t, c.validate())
+	assert.Nil(t, c.schedule)
+
+	err := Initialize(Configuration{QuotaScanSchedule: c})
+	assert.Error(t, err)
+
+	c.Cron = "*/5 * * * *"
+	err = Initialize(Configuration{QuotaScanSchedule: c})
+	assert.NoError(t, err)
+	assert.NotNil(t, quotaScanTicker)
+	err = Initialize(Configuration{})
+	assert.NoError(t, err)
+	assert.Nil(t, quotaScanTicker)
+}
+
+func TestScheduledQuotaScans(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "quota_scan_user")
+	mappedPath := filepath.Join(os.TempDir(), "quota_scan_folder")
+	folderName := filepath.Base(mappedPath)
+	err := os.MkdirAll(homeDir, os.ModePerm)
+	assert.NoError(t, err)
+	err = os.MkdirAll(mappedPath, os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(homeDir, "file"), []byte("data"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(mappedPath, "file"), []byte("folder data"), os.ModePerm)
+	assert.NoError(t, err)
+
+	user := dataprovider.User{
+		Username:   "quota_scan_user",
+		Password:   "password",
+		HomeDir:    homeDir,
+		QuotaFiles: 100,
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			MappedPath: mappedPath,
+			Name:       folderName,
+		},
+		VirtualPath: "/vdir",
+		// not included in the user quota
+		QuotaFiles: 0,
+		QuotaSize:  0,
+	})
+	err = dataprovider.AddUser(&user)
+	require.NoError(t, err)
+	user, err = dataprovider.UserExists(user.Username)
+	assert.NoError(t, err)
+	folder, err := dataprovider.GetFolderByName(folderName)
+	assert.NoError(t, err)
+	err = dataprovider.UpdateUserQuota(&user, 10, 1000, true)
+	assert.NoError(t, err)
+	err = dataprovider.UpdateVirtualFolderQuota(&folder, 10, 1000, true)
+	assert.NoError(t, err)
+
+	runScheduledQuotaScans()
+
+	user, err = dataprovider.UserExists(user.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, user.UsedQuotaFiles)
+	assert.Equal(t, int64(4), user.UsedQuotaSize)
+	folder, err = dataprovider.GetFolderByName(folderName)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, folder.UsedQuotaFiles)
+	assert.Equal(t, int64(11), folder.UsedQuotaSize)
+	assert.Len(t, QuotaScans.GetUsersQuotaScans(), 0)
+	assert.Len(t, QuotaScans.GetVFoldersQuotaScans(), 0)
+	// a scan already in progress is skipped
+	assert.True(t, QuotaScans.AddUserQuotaScan(user.Username))
+	err = dataprovider.UpdateUserQuota(&user, 10, 1000, true)
+	assert.NoError(t, err)
+	runScheduledQuotaScans()
+	user, err = dataprovider.UserExists(user.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, user.UsedQuotaFiles)
+	assert.True(t, QuotaScans.RemoveUserQuotaScan(user.Username))
+
+	err = dataprovider.DeleteUser(user.Username)
+	assert.NoError(t, err)
+	err = dataprovider.DeleteFolder(folderName)
+	assert.NoError(t, err)
+	err = os.RemoveAll(homeDir)
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+}
+
+func TestQuotaScanInvalidFs(t *testing.T) {
+	user := dataprovider.User{
+		Username: "test",
+		HomeDir:  os.TempDir(),
+		FsConfig: dataprovider.Filesystem{
+			Provider: dataprovider.S3FilesystemProvider,
+		},
+	}
+	QuotaScans.AddUserQuotaScan(user.Username)
+	err := DoUserQuotaScan(user)
+	assert.Error(t, err)
+	assert.Len(t, QuotaScans.GetUsersQuotaScans(), 0)
+}
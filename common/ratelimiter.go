@@ -0,0 +1,252 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// RateLimiterType defines the supported rate limiters types
+type RateLimiterType int
+
+// Supported rate limiter types
+const (
+	// one token bucket shared by all the matching clients
+	RateLimiterTypeGlobal RateLimiterType = iota + 1
+	// one token bucket for each source IP address
+	RateLimiterTypeSource
+	// one token bucket for each verified REST API JWT token. The other requests,
+	// including the ones authenticated using basic auth or API keys, use the
+	// source IP address
+	RateLimiterTypeToken
+)
+
+// RateLimiterTarget defines what a rate limiter limits
+type RateLimiterTarget int
+
+// Supported rate limiter targets
+const (
+	// new client connections, checked before the protocol handshake
+	RateLimiterTargetConnections RateLimiterTarget = iota + 1
+	// authentication attempts
+	RateLimiterTargetAuth
+	// REST API requests
+	RateLimiterTargetAPI
+)
+
+// RateLimiterConfig defines the configuration for a token bucket rate limiter
+type RateLimiterConfig struct {
+	// Average defines the maximum allowed rate as events per period. 0 means disabled
+	Average int64 `json:"average" mapstructure:"average"`
+	// Period defines the period as milliseconds. The rate is Average divided by Period
+	Period int64 `json:"period" mapstructure:"period"`
+	// Burst defines the maximum number of events allowed at once
+	Burst int `json:"burst" mapstructure:"burst"`
+	// Type defines the rate limiter type:
+	// - 1, global: a single token bucket is shared by all the matching clients
+	// - 2, source: each source IP address has its own token bucket
+	// - 3, token: each verified REST API JWT token has its own token bucket, the
+	//   other requests use the source IP. Only supported for the REST API target
+	Type int `json:"type" mapstructure:"type"`
+	// Target defines what is limited:
+	// - 1, new connections. The limit is applied before the protocol handshake
+	// - 2, authentication attempts
+	// - 3, REST API requests
+	Target int `json:"target" mapstructure:"target"`
+	// Protocols defines the protocols this rate limiter applies to, the supported
+	// values are: SSH, FTP, DAV. Empty means all the supported protocols.
+	// The REST API target only supports HTTP
+	Protocols []string `json:"protocols" mapstructure:"protocols"`
+	// Networks defines the source networks, as CIDR, this rate limiter applies to.
+	// Empty means any source address
+	Networks []string `json:"networks" mapstructure:"networks"`
+	// The number of source IPs, or tokens, tracked by a rate limiter of type source or token will vary
+	// between the soft and hard limit
+	EntriesSoftLimit int `json:"entries_soft_limit" mapstructure:"entries_soft_limit"`
+	EntriesHardLimit int `json:"entries_hard_limit" mapstructure:"entries_hard_limit"`
+}
+
+var rateLimiterProtocolValues = []string{ProtocolSSH, ProtocolFTP, ProtocolWebDAV}
+
+func (r *RateLimiterConfig) isEnabled() bool {
+	return r.Average > 0
+}
+
+// validate returns an error if the configuration is invalid.
+// Empty protocols are replaced with all the supported protocols
+func (r *RateLimiterConfig) validate() error {
+	if !r.isEnabled() {
+		return nil
+	}
+	if r.Burst < 1 {
+		return fmt.Errorf("invalid burst %v, it must be greater than 0", r.Burst)
+	}
+	if r.Period < 100 {
+		return fmt.Errorf("invalid period %v, it must be at least 100 milliseconds", r.Period)
+	}
+	if r.Type < int(RateLimiterTypeGlobal) || r.Type > int(RateLimiterTypeToken) {
+		return fmt.Errorf("invalid type %v", r.Type)
+	}
+	if r.Target < int(RateLimiterTargetConnections) || r.Target > int(RateLimiterTargetAPI) {
+		return fmt.Errorf("invalid target %v", r.Target)
+	}
+	if r.Type == int(RateLimiterTypeToken) && r.Target != int(RateLimiterTargetAPI) {
+		return fmt.Errorf("invalid type %v, it is only supported for the REST API target", r.Type)
+	}
+	if r.Type != int(RateLimiterTypeGlobal) {
+		if r.EntriesSoftLimit <= 0 {
+			return fmt.Errorf("invalid entries_soft_limit %v", r.EntriesSoftLimit)
+		}
+		if r.EntriesHardLimit <= r.EntriesSoftLimit {
+			return fmt.Errorf("invalid entries_hard_limit %v must be > %v", r.EntriesHardLimit, r.EntriesSoftLimit)
+		}
+	}
+	protocols := rateLimiterProtocolValues
+	if r.Target == int(RateLimiterTargetAPI) {
+		protocols = []string{ProtocolHTTP}
+	}
+	if len(r.Protocols) == 0 {
+		r.Protocols = append(r.Protocols, protocols...)
+	}
+	for _, p := range r.Protocols {
+		if !utils.IsStringInSlice(p, protocols) {
+			return fmt.Errorf("invalid protocol %#v", p)
+		}
+	}
+	for _, n := range r.Networks {
+		if _, _, err := net.ParseCIDR(n); err != nil {
+			return fmt.Errorf("invalid network %#v: %v", n, err)
+		}
+	}
+	return nil
+}
+
+type sourceRateLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type rateLimiter struct {
+	config   RateLimiterConfig
+	limit    rate.Limit
+	networks []*net.IPNet
+	sync.Mutex
+	// used for the global type
+	globalLimiter *rate.Limiter
+	// used for the source and token types, the key is the source IP or the token ID
+	sources map[string]sourceRateLimiter
+}
+
+func newRateLimiter(config *RateLimiterConfig) (*rateLimiter, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	r := &rateLimiter{
+		config:  *config,
+		limit:   rate.Limit(float64(config.Average) / (float64(config.Period) / 1000)),
+		sources: make(map[string]sourceRateLimiter),
+	}
+	for _, n := range config.Networks {
+		_, ipNet, _ := net.ParseCIDR(n)
+		r.networks = append(r.networks, ipNet)
+	}
+	if config.Type == int(RateLimiterTypeGlobal) {
+		r.globalLimiter = rate.NewLimiter(r.limit, config.Burst)
+	}
+	return r, nil
+}
+
+// isApplicable returns true if the rate limiter applies to the given target, protocol and source IP
+func (r *rateLimiter) isApplicable(target RateLimiterTarget, protocol, ip string) bool {
+	if r.config.Target != int(target) || !utils.IsStringInSlice(protocol, r.config.Protocols) {
+		return false
+	}
+	if len(r.networks) == 0 {
+		return true
+	}
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+	for _, n := range r.networks {
+		if n.Contains(parsedIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// allow returns true if an event for the given key, the source IP or the
+// token ID, is allowed now
+func (r *rateLimiter) allow(key string) bool {
+	allowed, _ := r.reserve(key)
+	return allowed
+}
+
+// reserve returns true if an event for the given key is allowed now, otherwise
+// it returns the time to wait before the next event will be allowed
+func (r *rateLimiter) reserve(key string) (bool, time.Duration) {
+	now := time.Now()
+	reservation := r.getLimiter(key, now).ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return true, 0
+	}
+	reservation.CancelAt(now)
+	return false, delay
+}
+
+func (r *rateLimiter) getLimiter(key string, now time.Time) *rate.Limiter {
+	if r.globalLimiter != nil {
+		return r.globalLimiter
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	source, ok := r.sources[key]
+	if !ok {
+		source.limiter = rate.NewLimiter(r.limit, r.config.Burst)
+	}
+	source.lastSeen = now
+	r.sources[key] = source
+	if !ok {
+		r.cleanupSources()
+	}
+	return source.limiter
+}
+
+// cleanupSources removes the least recently seen sources if the hard limit is exceeded.
+// The lock must be held by the caller
+func (r *rateLimiter) cleanupSources() {
+	if len(r.sources) <= r.config.EntriesHardLimit {
+		return
+	}
+	type sourceAge struct {
+		ip       string
+		lastSeen time.Time
+	}
+	ages := make([]sourceAge, 0, len(r.sources))
+	for ip, s := range r.sources {
+		ages = append(ages, sourceAge{ip: ip, lastSeen: s.lastSeen})
+	}
+	sort.Slice(ages, func(i, j int) bool {
+		return ages[i].lastSeen.Before(ages[j].lastSeen)
+	})
+	for _, s := range ages[:len(ages)-r.config.EntriesSoftLimit] {
+		delete(r.sources, s.ip)
+	}
+}
+
+func (r *rateLimiter) countSources() int {
+	r.Lock()
+	defer r.Unlock()
+
+	return len(r.sources)
+}
@@ -0,0 +1,249 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiterConfig(t *testing.T) {
+	config := RateLimiterConfig{}
+	assert.False(t, config.isEnabled())
+	assert.NoError(t, config.validate())
+
+	config.Average = 1
+	assert.Error(t, config.validate())
+	config.Burst = 1
+	assert.Error(t, config.validate())
+	config.Period = 10
+	assert.Error(t, config.validate())
+	config.Period = 1000
+	assert.Error(t, config.validate())
+	config.Type = int(RateLimiterTypeSource)
+	assert.Error(t, config.validate())
+	config.Target = int(RateLimiterTargetAuth)
+	assert.Error(t, config.validate())
+	config.EntriesSoftLimit = 10
+	assert.Error(t, config.validate())
+	config.EntriesHardLimit = 10
+	assert.Error(t, config.validate())
+	config.EntriesHardLimit = 15
+	assert.NoError(t, config.validate())
+	assert.Equal(t, rateLimiterProtocolValues, config.Protocols)
+	config.Protocols = []string{ProtocolSFTP}
+	assert.Error(t, config.validate())
+	config.Protocols = []string{ProtocolFTP}
+	config.Networks = []string{"192.168.1.1"}
+	assert.Error(t, config.validate())
+	config.Networks = []string{"192.168.0.0/16"}
+	assert.NoError(t, config.validate())
+
+	config.Type = int(RateLimiterTypeGlobal)
+	config.EntriesSoftLimit = 0
+	assert.NoError(t, config.validate())
+	config.Target = 4
+	assert.Error(t, config.validate())
+	config.Target = int(RateLimiterTargetAPI)
+	assert.Error(t, config.validate())
+	config.Protocols = nil
+	assert.NoError(t, config.validate())
+	assert.Equal(t, []string{ProtocolHTTP}, config.Protocols)
+	config.Type = int(RateLimiterTypeToken)
+	assert.Error(t, config.validate())
+	config.EntriesSoftLimit = 10
+	assert.NoError(t, config.validate())
+	config.Target = int(RateLimiterTargetAuth)
+	config.Protocols = nil
+	assert.Error(t, config.validate())
+	config.Type = int(RateLimiterTypeGlobal)
+	config.EntriesSoftLimit = 0
+	config.Protocols = []string{ProtocolHTTP}
+	assert.Error(t, config.validate())
+	config.Protocols = []string{ProtocolFTP}
+	config.Target = 4
+
+	_, err := newRateLimiter(&config)
+	assert.Error(t, err)
+}
+
+func TestRateLimiterApplicable(t *testing.T) {
+	limiter, err := newRateLimiter(&RateLimiterConfig{
+		Average:   1,
+		Period:    1000,
+		Burst:     1,
+		Type:      int(RateLimiterTypeGlobal),
+		Target:    int(RateLimiterTargetConnections),
+		Protocols: []string{ProtocolSSH, ProtocolWebDAV},
+		Networks:  []string{"192.168.1.0/24", "::1/128"},
+	})
+	require.NoError(t, err)
+	assert.True(t, limiter.isApplicable(RateLimiterTargetConnections, ProtocolSSH, "192.168.1.20"))
+	assert.True(t, limiter.isApplicable(RateLimiterTargetConnections, ProtocolWebDAV, "::1"))
+	assert.False(t, limiter.isApplicable(RateLimiterTargetAuth, ProtocolSSH, "192.168.1.20"))
+	assert.False(t, limiter.isApplicable(RateLimiterTargetConnections, ProtocolFTP, "192.168.1.20"))
+	assert.False(t, limiter.isApplicable(RateLimiterTargetConnections, ProtocolSSH, "192.168.2.20"))
+	assert.False(t, limiter.isApplicable(RateLimiterTargetConnections, ProtocolSSH, "invalid ip"))
+
+	limiter, err = newRateLimiter(&RateLimiterConfig{
+		Average: 1,
+		Period:  1000,
+		Burst:   1,
+		Type:    int(RateLimiterTypeGlobal),
+		Target:  int(RateLimiterTargetAuth),
+	})
+	require.NoError(t, err)
+	for _, protocol := range rateLimiterProtocolValues {
+		assert.True(t, limiter.isApplicable(RateLimiterTargetAuth, protocol, "10.1.2.3"))
+	}
+}
+
+func TestGlobalRateLimiter(t *testing.T) {
+	limiter, err := newRateLimiter(&RateLimiterConfig{
+		Average: 2,
+		Period:  200,
+		Burst:   2,
+		Type:    int(RateLimiterTypeGlobal),
+		Target:  int(RateLimiterTargetConnections),
+	})
+	require.NoError(t, err)
+	assert.True(t, limiter.allow("127.0.0.1"))
+	assert.True(t, limiter.allow("127.0.0.2"))
+	assert.False(t, limiter.allow("127.0.0.3"))
+	assert.Equal(t, 0, limiter.countSources())
+	time.Sleep(150 * time.Millisecond)
+	assert.True(t, limiter.allow("127.0.0.1"))
+}
+
+func TestSourceRateLimiter(t *testing.T) {
+	limiter, err := newRateLimiter(&RateLimiterConfig{
+		Average:          1,
+		Period:           100,
+		Burst:            1,
+		Type:             int(RateLimiterTypeSource),
+		Target:           int(RateLimiterTargetAuth),
+		EntriesSoftLimit: 5,
+		EntriesHardLimit: 10,
+	})
+	require.NoError(t, err)
+	assert.True(t, limiter.allow("127.0.0.1"))
+	assert.False(t, limiter.allow("127.0.0.1"))
+	assert.True(t, limiter.allow("127.0.0.2"))
+	time.Sleep(150 * time.Millisecond)
+	assert.True(t, limiter.allow("127.0.0.1"))
+
+	for i := 0; i < 8; i++ {
+		assert.True(t, limiter.allow(fmt.Sprintf("10.0.0.%v", i)))
+	}
+	assert.Equal(t, 10, limiter.countSources())
+	assert.True(t, limiter.allow("10.0.1.1"))
+	assert.Equal(t, 5, limiter.countSources())
+	limiter.Lock()
+	_, ok := limiter.sources["127.0.0.1"]
+	assert.False(t, ok)
+	_, ok = limiter.sources["10.0.1.1"]
+	assert.True(t, ok)
+	limiter.Unlock()
+}
+
+func TestRateLimiterHelpers(t *testing.T) {
+	configCopy := Config
+
+	ip := "127.5.5.5"
+	Config.rateLimiters = nil
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, LimitRate(RateLimiterTargetConnections, ProtocolSSH, ip))
+	}
+
+	c := Configuration{
+		RateLimiters: []RateLimiterConfig{
+			{
+				Average: 1,
+				Period:  10,
+				Burst:   1,
+				Type:    int(RateLimiterTypeGlobal),
+				Target:  int(RateLimiterTargetConnections),
+			},
+		},
+	}
+	err := Initialize(c)
+	assert.Error(t, err)
+
+	c.RateLimiters[0].Period = 10000
+	c.RateLimiters = append(c.RateLimiters, RateLimiterConfig{
+		Average:          1,
+		Period:           10000,
+		Burst:            2,
+		Type:             int(RateLimiterTypeSource),
+		Target:           int(RateLimiterTargetAuth),
+		Protocols:        []string{ProtocolFTP},
+		EntriesSoftLimit: 10,
+		EntriesHardLimit: 20,
+	}, RateLimiterConfig{})
+	err = Initialize(c)
+	require.NoError(t, err)
+	assert.Len(t, Config.rateLimiters, 2)
+
+	assert.NoError(t, LimitRate(RateLimiterTargetConnections, ProtocolSSH, ip))
+	assert.ErrorIs(t, LimitRate(RateLimiterTargetConnections, ProtocolFTP, ip), ErrRateLimited)
+
+	assert.NoError(t, CheckLoginAttempt(ip, ProtocolSSH))
+	assert.NoError(t, CheckLoginAttempt(ip, ProtocolFTP))
+	assert.NoError(t, CheckLoginAttempt(ip, ProtocolFTP))
+	assert.ErrorIs(t, CheckLoginAttempt(ip, ProtocolFTP), ErrRateLimited)
+	assert.NoError(t, CheckLoginAttempt("127.5.5.6", ProtocolFTP))
+	assert.NoError(t, CheckLoginAttempt(ip, ProtocolWebDAV))
+
+	Config = configCopy
+}
+
+func TestAPIRateLimiter(t *testing.T) {
+	configCopy := Config
+
+	c := Configuration{
+		RateLimiters: []RateLimiterConfig{
+			{
+				Average:          1,
+				Period:           10000,
+				Burst:            2,
+				Type:             int(RateLimiterTypeToken),
+				Target:           int(RateLimiterTargetAPI),
+				EntriesSoftLimit: 10,
+				EntriesHardLimit: 20,
+			},
+		},
+	}
+	err := Initialize(c)
+	require.NoError(t, err)
+
+	ip := "127.6.6.6"
+	for i := 0; i < 2; i++ {
+		_, err = LimitAPIRate(ip, "token1")
+		assert.NoError(t, err)
+	}
+	delay, err := LimitAPIRate(ip, "token1")
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Greater(t, int64(delay), int64(0))
+	assert.LessOrEqual(t, int64(delay), int64(10*time.Second))
+	// a rejected request does not consume tokens
+	delay1, err := LimitAPIRate(ip, "token1")
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.LessOrEqual(t, int64(delay1), int64(delay))
+	// each token has its own bucket
+	_, err = LimitAPIRate(ip, "token2")
+	assert.NoError(t, err)
+	// requests without a verified token use the source IP
+	for i := 0; i < 2; i++ {
+		_, err = LimitAPIRate(ip, "")
+		assert.NoError(t, err)
+	}
+	_, err = LimitAPIRate(ip, "")
+	assert.ErrorIs(t, err, ErrRateLimited)
+	// the API rate limiters do not apply to the other protocols
+	assert.NoError(t, LimitRate(RateLimiterTargetConnections, ProtocolSSH, ip))
+	assert.NoError(t, CheckLoginAttempt(ip, ProtocolFTP))
+
+	Config = configCopy
+}
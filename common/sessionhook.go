@@ -0,0 +1,153 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/diagnostics"
+	"github.com/drakkan/sftpgo/geoip"
+	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// Supported session end reasons
+const (
+	// the connection was closed by the client or by a network error
+	SessionEndReasonClient = "client"
+	// the connection was closed because it was idle
+	SessionEndReasonIdleTimeout = "idle_timeout"
+	// the connection was closed by an administrator or because the user was removed
+	SessionEndReasonClosed = "closed"
+	// the connection was closed because the service is shutting down
+	SessionEndReasonShutdown = "shutdown"
+)
+
+// SessionStats defines the statistics for a connection, they are notified to the session end hook
+type SessionStats struct {
+	ConnectionID string `json:"connection_id"`
+	Username     string `json:"username"`
+	Protocol     string `json:"protocol"`
+	IP           string `json:"ip"`
+	// ISO 3166-1 alpha-2 country code for the client IP, empty if unknown
+	Country string `json:"country,omitempty"`
+	// start and end time as unix timestamp in milliseconds
+	StartTime int64 `json:"start_time"`
+	EndTime   int64 `json:"end_time"`
+	// session duration as milliseconds
+	Duration        int64  `json:"duration"`
+	BytesUploaded   int64  `json:"bytes_uploaded"`
+	BytesDownloaded int64  `json:"bytes_downloaded"`
+	FilesUploaded   int64  `json:"files_uploaded"`
+	FilesDownloaded int64  `json:"files_downloaded"`
+	Reason          string `json:"reason"`
+}
+
+// notifySessionEnd executes the session end hook, if defined, for the given connection.
+// Connections without an authenticated user are not notified
+func notifySessionEnd(conn ActiveConnection) {
+	if Config.getHooks().sessionEnd == "" || conn.GetUsername() == "" {
+		return
+	}
+	stats := conn.GetSessionStats()
+	stats.IP = utils.GetIPFromRemoteAddress(conn.GetRemoteAddress())
+	stats.Country = geoip.GetCountry(stats.IP)
+
+	go Config.executeSessionEndHook(&stats) //nolint:errcheck
+}
+
+// saveConnectionRecord stores the given connection in the connection history, if enabled.
+// Connections without an authenticated user are not stored
+func saveConnectionRecord(conn ActiveConnection) {
+	if !dataprovider.IsConnectionHistoryEnabled() || conn.GetUsername() == "" {
+		return
+	}
+	stats := conn.GetSessionStats()
+	record := dataprovider.ConnectionRecord{
+		ConnectionID:    stats.ConnectionID,
+		Username:        stats.Username,
+		IP:              utils.GetIPFromRemoteAddress(conn.GetRemoteAddress()),
+		Protocol:        stats.Protocol,
+		StartTime:       stats.StartTime,
+		EndTime:         stats.EndTime,
+		BytesUploaded:   stats.BytesUploaded,
+		BytesDownloaded: stats.BytesDownloaded,
+	}
+
+	go func() {
+		if err := dataprovider.AddConnectionRecord(&record); err != nil {
+			logger.Warn(record.Protocol, record.ConnectionID, "unable to save the connection record: %v", err)
+		}
+	}()
+}
+
+func (c *Configuration) executeSessionEndHook(stats *SessionStats) error {
+	hook := c.getHooks().sessionEnd
+	if hook == "" {
+		return nil
+	}
+	if strings.HasPrefix(hook, "http") {
+		return c.executeSessionEndHTTPHook(hook, stats)
+	}
+	if !filepath.IsAbs(hook) {
+		err := fmt.Errorf("invalid session end hook %#v", hook)
+		logger.Warn(stats.Protocol, stats.ConnectionID, "unable to notify session end: %v", err)
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	startTime := time.Now()
+	cmd := exec.CommandContext(ctx, hook)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("SFTPGO_SESSION_ID=%v", stats.ConnectionID),
+		fmt.Sprintf("SFTPGO_SESSION_USERNAME=%v", stats.Username),
+		fmt.Sprintf("SFTPGO_SESSION_PROTOCOL=%v", stats.Protocol),
+		fmt.Sprintf("SFTPGO_SESSION_IP=%v", stats.IP),
+		fmt.Sprintf("SFTPGO_SESSION_COUNTRY=%v", stats.Country),
+		fmt.Sprintf("SFTPGO_SESSION_START_TIME=%v", stats.StartTime),
+		fmt.Sprintf("SFTPGO_SESSION_END_TIME=%v", stats.EndTime),
+		fmt.Sprintf("SFTPGO_SESSION_DURATION=%v", stats.Duration),
+		fmt.Sprintf("SFTPGO_SESSION_BYTES_UPLOADED=%v", stats.BytesUploaded),
+		fmt.Sprintf("SFTPGO_SESSION_BYTES_DOWNLOADED=%v", stats.BytesDownloaded),
+		fmt.Sprintf("SFTPGO_SESSION_FILES_UPLOADED=%v", stats.FilesUploaded),
+		fmt.Sprintf("SFTPGO_SESSION_FILES_DOWNLOADED=%v", stats.FilesDownloaded),
+		fmt.Sprintf("SFTPGO_SESSION_REASON=%v", stats.Reason))
+	err := cmd.Run()
+	diagnostics.HookExecuted(diagnostics.HookSessionEnd, time.Since(startTime), err)
+	logger.Debug(stats.Protocol, stats.ConnectionID, "executed session end hook %#v, elapsed: %v, error: %v",
+		hook, time.Since(startTime), err)
+	return err
+}
+
+func (c *Configuration) executeSessionEndHTTPHook(hook string, stats *SessionStats) error {
+	asJSON, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	startTime := time.Now()
+	respCode := 0
+
+	httpClient := httpclient.GetRetraybleHTTPClient()
+	resp, err := httpClient.Post(hook, "application/json", bytes.NewBuffer(asJSON))
+	if err == nil {
+		respCode = resp.StatusCode
+		resp.Body.Close()
+		if respCode != http.StatusOK {
+			err = errUnexpectedHTTResponse
+		}
+	}
+	diagnostics.HookExecuted(diagnostics.HookSessionEnd, time.Since(startTime), err)
+	logger.Debug(stats.Protocol, stats.ConnectionID, "session end notified to %#v, elapsed: %v, response code: %v, error: %v",
+		hook, time.Since(startTime), respCode, err)
+	return err
+}
@@ -0,0 +1,166 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+func TestSessionStats(t *testing.T) {
+	u := dataprovider.User{
+		Username: "session_stats_user",
+	}
+	fs := vfs.NewOsFs("", os.TempDir(), nil)
+	conn := NewBaseConnection("id", ProtocolSFTP, u, fs)
+	stats := conn.GetSessionStats()
+	assert.Equal(t, conn.GetID(), stats.ConnectionID)
+	assert.Equal(t, u.Username, stats.Username)
+	assert.Equal(t, ProtocolSFTP, stats.Protocol)
+	assert.Equal(t, SessionEndReasonClient, stats.Reason)
+	assert.Equal(t, int64(0), stats.BytesUploaded)
+	assert.Equal(t, int64(0), stats.FilesUploaded)
+
+	transfer, err := NewBaseTransfer(nil, conn, nil, "", "", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	atomic.StoreInt64(&transfer.BytesReceived, 100)
+	assert.NoError(t, transfer.Close())
+	transfer, err = NewBaseTransfer(nil, conn, nil, "", "", TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
+	atomic.StoreInt64(&transfer.BytesSent, 50)
+	assert.NoError(t, transfer.Close())
+	transfer, err = NewBaseTransfer(nil, conn, nil, "", "", TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
+	atomic.StoreInt64(&transfer.BytesSent, 20)
+	transfer.TransferError(ErrGenericFailure)
+	assert.Error(t, transfer.Close())
+
+	time.Sleep(10 * time.Millisecond)
+	conn.SetCloseReason(SessionEndReasonIdleTimeout)
+	conn.SetCloseReason(SessionEndReasonClosed)
+	stats = conn.GetSessionStats()
+	assert.Equal(t, int64(100), stats.BytesUploaded)
+	assert.Equal(t, int64(70), stats.BytesDownloaded)
+	assert.Equal(t, int64(1), stats.FilesUploaded)
+	// a failed download is not counted as a transferred file
+	assert.Equal(t, int64(1), stats.FilesDownloaded)
+	assert.Equal(t, SessionEndReasonIdleTimeout, stats.Reason)
+	assert.GreaterOrEqual(t, stats.Duration, int64(10))
+	assert.InDelta(t, stats.EndTime-stats.StartTime, stats.Duration, 1)
+}
+
+func TestSessionEndReasonClosed(t *testing.T) {
+	c := NewBaseConnection("id", ProtocolFTP, dataprovider.User{}, nil)
+	fakeConn := &fakeConnection{
+		BaseConnection: c,
+	}
+	Connections.Add(fakeConn)
+	assert.True(t, Connections.Close(fakeConn.GetID()))
+	assert.Eventually(t, func() bool { return len(Connections.GetStats()) == 0 }, 300*time.Millisecond, 50*time.Millisecond)
+	assert.Equal(t, SessionEndReasonClosed, fakeConn.GetSessionStats().Reason)
+}
+
+func TestSessionEndHook(t *testing.T) {
+	stats := &SessionStats{
+		ConnectionID: "SFTP_id",
+		Username:     "user",
+		Protocol:     ProtocolSFTP,
+		IP:           "127.0.0.1",
+		Reason:       SessionEndReasonClient,
+	}
+	Config.SessionEndHook = ""
+	assert.NoError(t, Config.executeSessionEndHook(stats))
+
+	Config.SessionEndHook = "http://foo\x7f.com/"
+	assert.Error(t, Config.executeSessionEndHook(stats))
+
+	Config.SessionEndHook = "http://invalid:1234/"
+	assert.Error(t, Config.executeSessionEndHook(stats))
+
+	Config.SessionEndHook = fmt.Sprintf("http://%v/404", httpAddr)
+	assert.ErrorIs(t, Config.executeSessionEndHook(stats), errUnexpectedHTTResponse)
+
+	Config.SessionEndHook = fmt.Sprintf("http://%v", httpAddr)
+	assert.NoError(t, Config.executeSessionEndHook(stats))
+
+	Config.SessionEndHook = "invalid"
+	assert.Error(t, Config.executeSessionEndHook(stats))
+
+	if runtime.GOOS == osWindows {
+		Config.SessionEndHook = "C:\\bad\\command"
+		assert.Error(t, Config.executeSessionEndHook(stats))
+	} else {
+		Config.SessionEndHook = "/invalid/path"
+		assert.Error(t, Config.executeSessionEndHook(stats))
+
+		hookCmd, err := exec.LookPath("true")
+		assert.NoError(t, err)
+		Config.SessionEndHook = hookCmd
+		assert.NoError(t, Config.executeSessionEndHook(stats))
+	}
+
+	Config.SessionEndHook = ""
+}
+
+func TestConnectionHistoryRecord(t *testing.T) {
+	err := closeDataprovider()
+	require.NoError(t, err)
+	var cfg providerConf
+	err = viper.Unmarshal(&cfg)
+	require.NoError(t, err)
+	cfg.Config.ConnectionHistoryRetention = 1
+	err = dataprovider.Initialize(cfg.Config, configDir, true)
+	require.NoError(t, err)
+
+	// the database could contain the records stored by previous test runs
+	filters := dataprovider.ConnectionHistoryFilters{
+		StartTimeFrom: utils.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+	// connections without an authenticated user are not stored
+	c := NewBaseConnection("id_anonymous", ProtocolFTP, dataprovider.User{}, nil)
+	fakeConn := &fakeConnection{
+		BaseConnection: c,
+	}
+	Connections.Add(fakeConn)
+	Connections.Remove(fakeConn.GetID())
+
+	u := dataprovider.User{
+		Username: "history_record_user",
+	}
+	c = NewBaseConnection("id_history", ProtocolFTP, u, nil)
+	fakeConn = &fakeConnection{
+		BaseConnection: c,
+	}
+	atomic.StoreInt64(&c.bytesUploaded, 123)
+	Connections.Add(fakeConn)
+	Connections.Remove(fakeConn.GetID())
+
+	var records []dataprovider.ConnectionRecord
+	assert.Eventually(t, func() bool {
+		records, err = dataprovider.SearchConnectionRecords(filters, 10, 0, dataprovider.OrderASC)
+		return err == nil && len(records) > 0
+	}, 1*time.Second, 50*time.Millisecond)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, fakeConn.GetID(), records[0].ConnectionID)
+		assert.Equal(t, u.Username, records[0].Username)
+		assert.Equal(t, ProtocolFTP, records[0].Protocol)
+		assert.Equal(t, int64(123), records[0].BytesUploaded)
+		assert.GreaterOrEqual(t, records[0].EndTime, records[0].StartTime)
+	}
+
+	err = closeDataprovider()
+	require.NoError(t, err)
+	_, err = initializeDataprovider(-1)
+	require.NoError(t, err)
+}
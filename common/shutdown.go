@@ -0,0 +1,77 @@
+package common
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+const shutdownCheckInterval = 500 * time.Millisecond
+
+var shuttingDown int32
+
+// IsShuttingDown returns true if a graceful shutdown is in progress.
+// New connections must be refused while shutting down
+func IsShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+// GracefulShutdown stops accepting new connections and waits for the active transfers
+// to complete, up to the configured graceful shutdown timeout. The connections without
+// active transfers are closed as soon as possible, the remaining ones are closed when
+// the timeout expires. It returns the number of transfers interrupted
+func GracefulShutdown() int {
+	atomic.StoreInt32(&shuttingDown, 1)
+	timeout := time.Duration(Config.GracefulShutdownTimeout) * time.Second
+	logger.Info(logSender, "", "graceful shutdown started, active transfers: %v, timeout: %v",
+		Connections.getNumActiveTransfers(), timeout)
+
+	deadline := time.Now().Add(timeout)
+	for {
+		numTransfers := Connections.closeForShutdown(false)
+		if numTransfers == 0 || !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(shutdownCheckInterval)
+	}
+	interrupted := Connections.closeForShutdown(true)
+	logger.Info(logSender, "", "graceful shutdown completed, interrupted transfers: %v", interrupted)
+	return interrupted
+}
+
+func (conns *ActiveConnections) getNumActiveTransfers() int {
+	conns.RLock()
+	defer conns.RUnlock()
+
+	result := 0
+	for _, c := range conns.connections {
+		result += len(c.GetTransfers())
+	}
+	return result
+}
+
+// closeForShutdown closes the connections without active transfers, or all the
+// connections if force is true, and returns the number of active transfers
+// for the connections left open or, if force is true, for the closed ones
+func (conns *ActiveConnections) closeForShutdown(force bool) int {
+	conns.RLock()
+
+	numTransfers := 0
+	for _, c := range conns.connections {
+		transfers := len(c.GetTransfers())
+		numTransfers += transfers
+		if transfers > 0 && !force {
+			continue
+		}
+		c.SetCloseReason(SessionEndReasonShutdown)
+		defer func(conn ActiveConnection) {
+			err := conn.Disconnect()
+			logger.Debug(conn.GetProtocol(), conn.GetID(), "close connection for shutdown, username: %#v close err: %v",
+				conn.GetUsername(), err)
+		}(c)
+	}
+
+	conns.RUnlock()
+	return numTransfers
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	configCopy := Config
+	defer func() {
+		atomic.StoreInt32(&shuttingDown, 0)
+		Config = configCopy
+	}()
+
+	user := dataprovider.User{
+		Username: "test_user_shutdown",
+	}
+	fs := vfs.NewOsFs("", os.TempDir(), nil)
+	c1 := NewBaseConnection("id_shutdown1", ProtocolSFTP, user, fs)
+	fakeConn1 := &fakeConnection{
+		BaseConnection: c1,
+	}
+	c2 := NewBaseConnection("id_shutdown2", ProtocolFTP, user, fs)
+	fakeConn2 := &fakeConnection{
+		BaseConnection: c2,
+	}
+	Connections.Add(fakeConn1)
+	Connections.Add(fakeConn2)
+	tr, err := NewBaseTransfer(nil, c2, nil, "/p1", "/r1", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+
+	assert.False(t, IsShuttingDown())
+	Config.GracefulShutdownTimeout = 10
+	done := make(chan int, 1)
+	go func() {
+		done <- GracefulShutdown()
+	}()
+	// the connection without transfers is closed immediately
+	assert.Eventually(t, func() bool { return len(Connections.GetStats()) == 1 }, 1*time.Second, 50*time.Millisecond)
+	assert.True(t, IsShuttingDown())
+	// new transfers are refused
+	assert.ErrorIs(t, c2.CheckTransfersLimit(), ErrShuttingDown)
+	_, err = NewBaseTransfer(nil, c2, nil, "/p3", "/r3", TransferDownload, 0, 0, 0, false, fs)
+	assert.ErrorIs(t, err, ErrShuttingDown)
+	assert.Equal(t, fakeConn2.GetID(), Connections.GetStats()[0].ConnectionID)
+	err = tr.Close()
+	assert.NoError(t, err)
+	select {
+	case interrupted := <-done:
+		assert.Equal(t, 0, interrupted)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "graceful shutdown not completed")
+	}
+	assert.Len(t, Connections.GetStats(), 0)
+	assert.Equal(t, SessionEndReasonShutdown, c2.GetSessionStats().Reason)
+
+	// the timeout expires with an active transfer
+	atomic.StoreInt32(&shuttingDown, 0)
+	c1 = NewBaseConnection("id_shutdown3", ProtocolSFTP, user, fs)
+	fakeConn1 = &fakeConnection{
+		BaseConnection: c1,
+	}
+	Connections.Add(fakeConn1)
+	tr, err = NewBaseTransfer(nil, c1, nil, "/p2", "/r2", TransferDownload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	Config.GracefulShutdownTimeout = 0
+	assert.Equal(t, 1, GracefulShutdown())
+	assert.Len(t, Connections.GetStats(), 0)
+	err = tr.Close()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,98 @@
+package common
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// number of connections currently kept in the tarpit
+var tarpitConnections int32
+
+// TarpitConfig defines the configuration to keep the connections from banned hosts open,
+// sending the banner very slowly, instead of closing them
+type TarpitConfig struct {
+	// Set to true to tarpit the connections from banned hosts
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Interval, as seconds, between the banner lines sent to the tarpitted clients
+	Interval int `json:"interval" mapstructure:"interval"`
+	// Maximum time, as seconds, a client is kept in the tarpit. 0 means until the
+	// client disconnects
+	MaxDuration int `json:"max_duration" mapstructure:"max_duration"`
+	// Maximum number of concurrent tarpitted connections. The connections exceeding
+	// this limit are closed immediately
+	MaxConnections int `json:"max_connections" mapstructure:"max_connections"`
+}
+
+func (c *TarpitConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("invalid tarpit interval %v", c.Interval)
+	}
+	if c.MaxDuration < 0 {
+		return fmt.Errorf("invalid tarpit max_duration %v", c.MaxDuration)
+	}
+	if c.MaxConnections <= 0 {
+		return fmt.Errorf("invalid tarpit max_connections %v", c.MaxConnections)
+	}
+	return nil
+}
+
+// getTarpitLine returns a banner line for the given protocol. SSH clients ignore
+// the lines sent before the version identification string and FTP clients wait
+// for the end of a multi-line reply, so they will wait for the next line
+func getTarpitLine(protocol string) []byte {
+	line := hex.EncodeToString(utils.GenerateRandomBytes(8))
+	if protocol == ProtocolFTP {
+		return []byte(fmt.Sprintf("220-%v\r\n", line))
+	}
+	return []byte(fmt.Sprintf("%v\r\n", line))
+}
+
+// Tarpit keeps the connection from a banned host open, sending the banner for the
+// given protocol very slowly, to waste the attacker's resources.
+// It returns false, without writing anything, if the defender or the tarpit are
+// disabled or if the maximum number of tarpitted connections is reached, otherwise
+// it returns true when the client disconnects, the maximum duration expires or the
+// service is shutting down. The connection is not closed
+func Tarpit(conn net.Conn, protocol string) bool {
+	if Config.defender == nil || !Config.DefenderConfig.Tarpit.Enabled {
+		return false
+	}
+	config := Config.DefenderConfig.Tarpit
+	if atomic.AddInt32(&tarpitConnections, 1) > int32(config.MaxConnections) {
+		atomic.AddInt32(&tarpitConnections, -1)
+		logger.Debug(logSender, "", "tarpit limit reached, closing connection from %#v", conn.RemoteAddr().String())
+		return false
+	}
+	defer atomic.AddInt32(&tarpitConnections, -1)
+
+	remoteAddr := conn.RemoteAddr().String()
+	interval := time.Duration(config.Interval) * time.Second
+	startTime := time.Now()
+	logger.Debug(logSender, "", "tarpit started for %v connection from %#v", protocol, remoteAddr)
+
+	for !IsShuttingDown() {
+		if config.MaxDuration > 0 && time.Since(startTime) >= time.Duration(config.MaxDuration)*time.Second {
+			break
+		}
+		if err := conn.SetWriteDeadline(time.Now().Add(interval)); err != nil {
+			break
+		}
+		if _, err := conn.Write(getTarpitLine(protocol)); err != nil {
+			break
+		}
+		time.Sleep(interval)
+	}
+
+	logger.Debug(logSender, "", "tarpit ended for %v connection from %#v, elapsed: %v", protocol, remoteAddr,
+		time.Since(startTime))
+	return true
+}
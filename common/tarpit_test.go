@@ -0,0 +1,97 @@
+package common
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarpit(t *testing.T) {
+	configCopy := Config
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	// the defender is disabled
+	assert.False(t, Tarpit(server, ProtocolSSH))
+
+	Config.DefenderConfig = DefenderConfig{
+		Enabled:          true,
+		BanTime:          10,
+		BanTimeIncrement: 50,
+		Threshold:        3,
+		ScoreInvalid:     2,
+		ScoreValid:       1,
+		ObservationTime:  15,
+		EntriesSoftLimit: 100,
+		EntriesHardLimit: 150,
+	}
+	err := Initialize(Config)
+	require.NoError(t, err)
+	// the tarpit is disabled
+	assert.False(t, Tarpit(server, ProtocolSSH))
+
+	Config.DefenderConfig.Tarpit = TarpitConfig{
+		Enabled:        true,
+		Interval:       1,
+		MaxConnections: 1,
+	}
+	err = Initialize(Config)
+	require.NoError(t, err)
+
+	atomic.StoreInt32(&tarpitConnections, 1)
+	assert.False(t, Tarpit(server, ProtocolSSH))
+	atomic.StoreInt32(&tarpitConnections, 0)
+
+	done := make(chan bool)
+	go func() {
+		done <- Tarpit(server, ProtocolFTP)
+	}()
+
+	reader := bufio.NewReader(client)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "220-"))
+	assert.True(t, strings.HasSuffix(line, "\r\n"))
+	// the tarpit ends when the client disconnects
+	err = client.Close()
+	assert.NoError(t, err)
+	select {
+	case res := <-done:
+		assert.True(t, res)
+	case <-time.After(3 * time.Second):
+		assert.Fail(t, "tarpit must end when the client disconnects")
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&tarpitConnections))
+
+	server1, client1 := net.Pipe()
+	defer server1.Close()
+	defer client1.Close()
+	Config.DefenderConfig.Tarpit.MaxDuration = 1
+	err = Initialize(Config)
+	require.NoError(t, err)
+	go func() {
+		done <- Tarpit(server1, ProtocolSSH)
+	}()
+	line, err = bufio.NewReader(client1).ReadString('\n')
+	assert.NoError(t, err)
+	// SSH clients ignore the lines sent before the version identification string
+	assert.False(t, strings.HasPrefix(line, "SSH-"))
+	assert.False(t, strings.HasPrefix(line, "220-"))
+	select {
+	case res := <-done:
+		assert.True(t, res)
+	case <-time.After(3 * time.Second):
+		assert.Fail(t, "tarpit must end when the max duration expires")
+	}
+
+	Config = configCopy
+	err = Initialize(Config)
+	assert.NoError(t, err)
+}
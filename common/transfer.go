@@ -1,7 +1,11 @@
 package common
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"hash"
 	"path"
 	"sync"
 	"sync/atomic"
@@ -10,6 +14,7 @@ import (
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/metrics"
+	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/vfs"
 )
 
@@ -18,6 +23,15 @@ var (
 	ErrTransferClosed = errors.New("transfer already closed")
 )
 
+const (
+	// maximum size, as bytes, of the out of order writes buffered to compute the upload checksum
+	maxChecksumPendingSize = 4 * 1024 * 1024
+	// suffix added to the interrupted atomic uploads that are not deleted
+	partialUploadSuffix = ".partial"
+	// the current transfer speed is computed from the size samples collected within this window
+	transferSpeedWindow = 10 * time.Second
+)
+
 // BaseTransfer contains protocols common transfer details for an upload or a download.
 type BaseTransfer struct { //nolint:maligned
 	ID             uint64
@@ -38,11 +52,40 @@ type BaseTransfer struct { //nolint:maligned
 	AbortTransfer  int32
 	sync.Mutex
 	ErrTransfer error
+
+	// data transfer quota usage for the user, nil if the user has no data transfer limits
+	transferQuota *transferQuotaUsage
+	// bandwidth limits as KB/s, 0 means unlimited
+	uploadBandwidth   int64
+	downloadBandwidth int64
+	// SHA-256 of the uploaded data, nil if it is not computed
+	checksum            hash.Hash
+	checksumOffset      int64
+	checksumPending     map[int64][]byte
+	checksumPendingSize int
+	// last time the transferred size changed and the size at that time,
+	// used to detect stalled transfers
+	lastProgress     time.Time
+	lastProgressSize int64
+	// path where an interrupted atomic upload was moved, if it was not deleted
+	partialFsPath string
+	// number of bytes this transfer is expected to move, 0 if unknown. Accessed atomically
+	expectedSize int64
+	// size samples used to compute the current transfer speed
+	speedMu      sync.Mutex
+	speedSamples []transferSpeedSample
 }
 
-// NewBaseTransfer returns a new BaseTransfer and adds it to the given connection
+type transferSpeedSample struct {
+	time time.Time
+	size int64
+}
+
+// NewBaseTransfer returns a new BaseTransfer and adds it to the given connection.
+// If the connection does not allow a new transfer an error is returned, the given
+// file is closed and the new or temporary file created for an upload is removed
 func NewBaseTransfer(file vfs.File, conn *BaseConnection, cancelFn func(), fsPath, requestPath string, transferType int,
-	minWriteOffset, initialSize, maxWriteSize int64, isNewFile bool, fs vfs.Fs) *BaseTransfer {
+	minWriteOffset, initialSize, maxWriteSize int64, isNewFile bool, fs vfs.Fs) (*BaseTransfer, error) {
 	t := &BaseTransfer{
 		ID:             conn.GetTransferID(),
 		File:           file,
@@ -60,10 +103,69 @@ func NewBaseTransfer(file vfs.File, conn *BaseConnection, cancelFn func(), fsPat
 		MaxWriteSize:   maxWriteSize,
 		AbortTransfer:  0,
 		Fs:             fs,
+		lastProgress:   time.Now(),
+	}
+	if conn.User.Filters.TransferQuota.HasLimits() {
+		t.transferQuota = transferQuotas.add(t)
+	}
+	t.uploadBandwidth, t.downloadBandwidth = conn.User.GetBandwidthForIP(conn.remoteIP, conn.ID)
+	// the checksum cannot be computed for resumed uploads, we don't have the existing data
+	if transferType == TransferUpload && Config.UploadChecksum && minWriteOffset == 0 {
+		t.checksum = sha256.New()
+	}
+	// for local downloads the expected size is cheap to get, the protocol handlers
+	// can set it for the other transfers if they know it
+	if transferType == TransferDownload && file != nil {
+		if info, err := file.Stat(); err == nil {
+			t.expectedSize = info.Size()
+		}
 	}
 
-	conn.AddTransfer(t)
-	return t
+	if err := conn.AddTransfer(t); err != nil {
+		if t.transferQuota != nil {
+			transferQuotas.remove(t, t.transferQuota, 0, 0)
+		}
+		t.discard()
+		return nil, err
+	}
+	return t, nil
+}
+
+// NewStreamTransfer returns a transfer for an additional data stream of the same operation,
+// for example the stdout of an SSH system command. The returned transfer is not added to
+// the connection, it uses the concurrent transfers slot of t
+func (t *BaseTransfer) NewStreamTransfer(transferType int) *BaseTransfer {
+	s := &BaseTransfer{
+		ID:                t.Connection.GetTransferID(),
+		Connection:        t.Connection,
+		fsPath:            t.fsPath,
+		start:             time.Now(),
+		transferType:      transferType,
+		requestPath:       t.requestPath,
+		Fs:                t.Fs,
+		uploadBandwidth:   t.uploadBandwidth,
+		downloadBandwidth: t.downloadBandwidth,
+		lastProgress:      time.Now(),
+	}
+	if t.Connection.User.Filters.TransferQuota.HasLimits() {
+		s.transferQuota = transferQuotas.add(s)
+	}
+	return s
+}
+
+// discard releases the resources for a transfer that was not added to its connection
+func (t *BaseTransfer) discard() {
+	if t.cancelFn != nil {
+		t.cancelFn()
+	}
+	if t.File == nil {
+		return
+	}
+	t.File.Close() //nolint:errcheck
+	if t.transferType == TransferUpload && (t.isNewFile || t.File.Name() != t.fsPath) {
+		err := t.Fs.Remove(t.File.Name(), false)
+		t.Connection.Log(logger.LevelDebug, "transfer denied, removed file %#v, error: %v", t.File.Name(), err)
+	}
 }
 
 // GetID returns the transfer ID
@@ -89,6 +191,85 @@ func (t *BaseTransfer) GetStartTime() time.Time {
 	return t.start
 }
 
+// SetExpectedSize sets the number of bytes this transfer is expected to move.
+// It is used to estimate the remaining time
+func (t *BaseTransfer) SetExpectedSize(size int64) {
+	atomic.StoreInt64(&t.expectedSize, size)
+}
+
+// GetExpectedSize returns the number of bytes this transfer is expected to move, 0 if unknown
+func (t *BaseTransfer) GetExpectedSize() int64 {
+	return atomic.LoadInt64(&t.expectedSize)
+}
+
+// GetSpeed returns the current transfer speed as bytes per second.
+// Each call adds a size sample, the speed is computed from the samples collected
+// within the last 10 seconds, the transfer start is the first sample
+func (t *BaseTransfer) GetSpeed() int64 {
+	t.speedMu.Lock()
+	defer t.speedMu.Unlock()
+
+	now := time.Now()
+	size := t.GetSize()
+	if len(t.speedSamples) == 0 {
+		t.speedSamples = append(t.speedSamples, transferSpeedSample{time: t.start})
+	}
+	// drop the samples older than the window, the newest of them is kept as base,
+	// this way the speed is computed over the whole window
+	idx := 0
+	for idx < len(t.speedSamples)-1 && now.Sub(t.speedSamples[idx+1].time) > transferSpeedWindow {
+		idx++
+	}
+	t.speedSamples = t.speedSamples[idx:]
+	base := t.speedSamples[0]
+	t.speedSamples = append(t.speedSamples, transferSpeedSample{time: now, size: size})
+
+	elapsed := now.Sub(base.time)
+	if elapsed < time.Millisecond {
+		return 0
+	}
+	return int64(float64(size-base.size) / elapsed.Seconds())
+}
+
+// CheckRead returns an error if the downloaded bytes exceed the
+// data transfer quota
+func (t *BaseTransfer) CheckRead() error {
+	if t.transferQuota != nil && t.transferQuota.isDownloadExceeded(t.Connection.User.Filters.TransferQuota.DownloadSize) {
+		return ErrReadQuotaExceeded
+	}
+	return nil
+}
+
+// CheckWrite returns an error if the uploaded bytes exceed the maximum
+// write size or the data transfer quota
+func (t *BaseTransfer) CheckWrite() error {
+	if t.MaxWriteSize > 0 && atomic.LoadInt64(&t.BytesReceived) > t.MaxWriteSize {
+		return ErrQuotaExceeded
+	}
+	if t.transferQuota != nil && t.transferQuota.isUploadExceeded(t.Connection.User.Filters.TransferQuota.UploadSize) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// IsStalled returns true if the transfer did not move any data within the given timeout.
+// Failed transfers are never reported as stalled
+func (t *BaseTransfer) IsStalled(timeout time.Duration) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.ErrTransfer != nil {
+		return false
+	}
+	size := t.GetSize()
+	if size != t.lastProgressSize || t.lastProgress.IsZero() {
+		t.lastProgressSize = size
+		t.lastProgress = time.Now()
+		return false
+	}
+	return time.Since(t.lastProgress) > timeout
+}
+
 // SignalClose signals that the transfer should be closed.
 // For same protocols, for example WebDAV, we have no
 // access to the network connection, so we use this method
@@ -134,6 +315,9 @@ func (t *BaseTransfer) Truncate(fsPath string, size int64) (int64, error) {
 			if err == nil {
 				t.Lock()
 				t.InitialSize = size
+				if size > 0 || t.checksumOffset > 0 {
+					t.disableChecksum("the file was truncated")
+				}
 				if t.MaxWriteSize > 0 {
 					sizeDiff := initialSize - size
 					t.MaxWriteSize += sizeDiff
@@ -155,6 +339,74 @@ func (t *BaseTransfer) Truncate(fsPath string, size int64) (int64, error) {
 	return 0, errTransferMismatch
 }
 
+// UpdateChecksum adds the uploaded data, written at the specified offset,
+// to the upload checksum. Out of order writes are buffered, up to a limit,
+// until the missing data is received
+func (t *BaseTransfer) UpdateChecksum(p []byte, off int64) {
+	if len(p) == 0 {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	if t.checksum == nil {
+		return
+	}
+	if off != t.checksumOffset {
+		if _, ok := t.checksumPending[off]; ok || off < t.checksumOffset {
+			t.disableChecksum("overlapping write")
+			return
+		}
+		if t.checksumPendingSize+len(p) > maxChecksumPendingSize {
+			t.disableChecksum("too many out of order writes")
+			return
+		}
+		if t.checksumPending == nil {
+			t.checksumPending = make(map[int64][]byte)
+		}
+		data := make([]byte, len(p))
+		copy(data, p)
+		t.checksumPending[off] = data
+		t.checksumPendingSize += len(data)
+		return
+	}
+	t.checksum.Write(p) //nolint:errcheck
+	t.checksumOffset += int64(len(p))
+	for {
+		data, ok := t.checksumPending[t.checksumOffset]
+		if !ok {
+			break
+		}
+		delete(t.checksumPending, t.checksumOffset)
+		t.checksumPendingSize -= len(data)
+		t.checksum.Write(data) //nolint:errcheck
+		t.checksumOffset += int64(len(data))
+	}
+}
+
+// disableChecksum must be called with the lock held
+func (t *BaseTransfer) disableChecksum(reason string) {
+	if t.checksum == nil {
+		return
+	}
+	t.Connection.Log(logger.LevelDebug, "upload checksum disabled for file %#v: %v", t.fsPath, reason)
+	t.checksum = nil
+	t.checksumPending = nil
+	t.checksumPendingSize = 0
+}
+
+// getChecksum returns the hex encoded SHA-256 of the uploaded file or an empty string
+// if the checksum is not available
+func (t *BaseTransfer) getChecksum(fileSize int64) string {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.checksum == nil || t.ErrTransfer != nil || len(t.checksumPending) > 0 || t.checksumOffset != fileSize {
+		return ""
+	}
+	return hex.EncodeToString(t.checksum.Sum(nil))
+}
+
 // TransferError is called if there is an unexpected error.
 // For example network or client issues
 func (t *BaseTransfer) TransferError(err error) {
@@ -173,9 +425,66 @@ func (t *BaseTransfer) TransferError(err error) {
 		atomic.LoadInt64(&t.BytesReceived), elapsed)
 }
 
+// getPartialUploadPath returns the filesystem path for an interrupted atomic upload.
+// An empty string means that the temporary file must be deleted
+func (t *BaseTransfer) getPartialUploadPath() string {
+	partialUploads := t.Connection.User.Filters.PartialUploads
+	switch partialUploads.Policy {
+	case dataprovider.PartialUploadsDelete:
+		return ""
+	case dataprovider.PartialUploadsKeep:
+		return t.fsPath + partialUploadSuffix
+	case dataprovider.PartialUploadsQuarantine:
+		quarantineDir, err := t.createQuarantineDir(partialUploads.QuarantinePath)
+		if err != nil {
+			t.Connection.Log(logger.LevelWarn, "unable to create quarantine dir %#v: %v", partialUploads.QuarantinePath, err)
+			return ""
+		}
+		name := fmt.Sprintf("%v_%v%v", time.Now().UTC().Format("20060102T150405.000"), path.Base(t.requestPath),
+			partialUploadSuffix)
+		return t.Connection.Fs.Join(quarantineDir, name)
+	default:
+		if Config.UploadMode == UploadModeAtomicWithResume {
+			return t.fsPath
+		}
+		return ""
+	}
+}
+
+// createQuarantineDir creates the quarantine directory, and any missing parent,
+// and returns its filesystem path
+func (t *BaseTransfer) createQuarantineDir(quarantinePath string) (string, error) {
+	var fsPath string
+	dirs := utils.GetDirsForSFTPPath(quarantinePath)
+	for idx := len(dirs) - 1; idx >= 0; idx-- {
+		p, err := t.Connection.Fs.ResolvePath(dirs[idx])
+		if err != nil {
+			return "", err
+		}
+		fsPath = p
+		if dirs[idx] == "/" {
+			continue
+		}
+		_, err = t.Connection.Fs.Stat(p)
+		if t.Connection.Fs.IsNotExist(err) {
+			if err = t.Connection.Fs.Mkdir(p); err != nil {
+				return "", err
+			}
+			vfs.SetPathPermissions(t.Connection.Fs, p, t.Connection.User.GetUID(), t.Connection.User.GetGID())
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return fsPath, nil
+}
+
 func (t *BaseTransfer) getUploadFileSize() (int64, error) {
 	var fileSize int64
-	info, err := t.Fs.Stat(t.fsPath)
+	fsPath := t.fsPath
+	if t.partialFsPath != "" {
+		fsPath = t.partialFsPath
+	}
+	info, err := t.Fs.Stat(fsPath)
 	if err == nil {
 		fileSize = info.Size()
 	}
@@ -201,7 +510,14 @@ func (t *BaseTransfer) Close() error {
 	if t.isNewFile {
 		numFiles = 1
 	}
-	metrics.TransferCompleted(atomic.LoadInt64(&t.BytesSent), atomic.LoadInt64(&t.BytesReceived), t.transferType, t.ErrTransfer)
+	// the transferred bytes count against the data transfer quota even if the upload is removed
+	bytesReceived := atomic.LoadInt64(&t.BytesReceived)
+	bytesSent := atomic.LoadInt64(&t.BytesSent)
+	dataprovider.UpdateUserTransferQuota(&t.Connection.User, bytesReceived, bytesSent) //nolint:errcheck
+	if t.transferQuota != nil {
+		transferQuotas.remove(t, t.transferQuota, bytesReceived, bytesSent)
+	}
+	metrics.TransferCompleted(bytesSent, bytesReceived, t.transferType, t.ErrTransfer)
 	if t.ErrTransfer == ErrQuotaExceeded && t.File != nil {
 		// if quota is exceeded we try to remove the partial file for uploads to local filesystem
 		err = t.Connection.Fs.Remove(t.File.Name(), false)
@@ -213,25 +529,37 @@ func (t *BaseTransfer) Close() error {
 		t.Connection.Log(logger.LevelWarn, "upload denied due to space limit, delete temporary file: %#v, deletion error: %v",
 			t.File.Name(), err)
 	} else if t.transferType == TransferUpload && t.File != nil && t.File.Name() != t.fsPath {
-		if t.ErrTransfer == nil || Config.UploadMode == UploadModeAtomicWithResume {
+		if t.ErrTransfer == nil {
 			err = t.Connection.Fs.Rename(t.File.Name(), t.fsPath)
 			t.Connection.Log(logger.LevelDebug, "atomic upload completed, rename: %#v -> %#v, error: %v",
 				t.File.Name(), t.fsPath, err)
 		} else {
-			err = t.Connection.Fs.Remove(t.File.Name(), false)
-			t.Connection.Log(logger.LevelWarn, "atomic upload completed with error: \"%v\", delete temporary file: %#v, "+
-				"deletion error: %v", t.ErrTransfer, t.File.Name(), err)
-			if err == nil {
-				numFiles--
-				atomic.StoreInt64(&t.BytesReceived, 0)
-				t.MinWriteOffset = 0
+			partialPath := t.getPartialUploadPath()
+			if partialPath != "" {
+				err = t.Connection.Fs.Rename(t.File.Name(), partialPath)
+				t.Connection.Log(logger.LevelWarn, "atomic upload completed with error: \"%v\", keep partial file, rename: %#v -> %#v, "+
+					"error: %v", t.ErrTransfer, t.File.Name(), partialPath, err)
+				if err == nil {
+					t.partialFsPath = partialPath
+				}
+			}
+			// the temporary file is deleted if it cannot be kept
+			if partialPath == "" || err != nil {
+				err = t.Connection.Fs.Remove(t.File.Name(), false)
+				t.Connection.Log(logger.LevelWarn, "atomic upload completed with error: \"%v\", delete temporary file: %#v, "+
+					"deletion error: %v", t.ErrTransfer, t.File.Name(), err)
+				if err == nil {
+					numFiles--
+					atomic.StoreInt64(&t.BytesReceived, 0)
+					t.MinWriteOffset = 0
+				}
 			}
 		}
 	}
 	elapsed := time.Since(t.start).Nanoseconds() / 1000000
 	if t.transferType == TransferDownload {
 		logger.TransferLog(downloadLogSender, t.fsPath, elapsed, atomic.LoadInt64(&t.BytesSent), t.Connection.User.Username,
-			t.Connection.ID, t.Connection.protocol)
+			t.Connection.ID, t.Connection.protocol, "")
 		action := newActionNotification(&t.Connection.User, operationDownload, t.fsPath, "", "", t.Connection.protocol,
 			atomic.LoadInt64(&t.BytesSent), t.ErrTransfer)
 		go actionHandler.Handle(action) //nolint:errcheck
@@ -242,10 +570,12 @@ func (t *BaseTransfer) Close() error {
 		}
 		t.Connection.Log(logger.LevelDebug, "uploaded file size %v", fileSize)
 		t.updateQuota(numFiles, fileSize)
+		checksum := t.getChecksum(fileSize)
 		logger.TransferLog(uploadLogSender, t.fsPath, elapsed, atomic.LoadInt64(&t.BytesReceived), t.Connection.User.Username,
-			t.Connection.ID, t.Connection.protocol)
+			t.Connection.ID, t.Connection.protocol, checksum)
 		action := newActionNotification(&t.Connection.User, operationUpload, t.fsPath, "", "", t.Connection.protocol,
 			fileSize, t.ErrTransfer)
+		action.Checksum = checksum
 		go actionHandler.Handle(action) //nolint:errcheck
 	}
 	if t.ErrTransfer != nil {
@@ -254,6 +584,15 @@ func (t *BaseTransfer) Close() error {
 			err = t.ErrTransfer
 		}
 	}
+	t.Connection.updateSessionStats(t.transferType, bytesReceived, bytesSent, err == nil)
+	if t.transferType == TransferDownload {
+		t.Connection.auditFileOperation(operationDownload, t.requestPath, "", atomic.LoadInt64(&t.BytesSent), err)
+		t.Connection.logTransfer(t.transferType, t.requestPath, atomic.LoadInt64(&t.BytesSent), time.Since(t.start), err)
+	} else {
+		t.Connection.auditFileOperation(operationUpload, t.requestPath, "", atomic.LoadInt64(&t.BytesReceived)+
+			t.MinWriteOffset, err)
+		t.Connection.logTransfer(t.transferType, t.requestPath, atomic.LoadInt64(&t.BytesReceived), time.Since(t.start), err)
+	}
 	return err
 }
 
@@ -284,10 +623,10 @@ func (t *BaseTransfer) HandleThrottle() {
 	var wantedBandwidth int64
 	var trasferredBytes int64
 	if t.transferType == TransferDownload {
-		wantedBandwidth = t.Connection.User.DownloadBandwidth
+		wantedBandwidth = t.downloadBandwidth
 		trasferredBytes = atomic.LoadInt64(&t.BytesSent)
 	} else {
-		wantedBandwidth = t.Connection.User.UploadBandwidth
+		wantedBandwidth = t.uploadBandwidth
 		trasferredBytes = atomic.LoadInt64(&t.BytesReceived)
 	}
 	if wantedBandwidth > 0 {
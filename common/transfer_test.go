@@ -1,10 +1,13 @@
 package common
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -63,17 +66,19 @@ func TestTransferThrottling(t *testing.T) {
 	wantedUploadElapsed -= wantedDownloadElapsed / 10
 	wantedDownloadElapsed -= wantedDownloadElapsed / 10
 	conn := NewBaseConnection("id", ProtocolSCP, u, nil)
-	transfer := NewBaseTransfer(nil, conn, nil, "", "", TransferUpload, 0, 0, 0, true, fs)
+	transfer, err := NewBaseTransfer(nil, conn, nil, "", "", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
 	transfer.BytesReceived = testFileSize
 	transfer.Connection.UpdateLastActivity()
 	startTime := transfer.Connection.GetLastActivity()
 	transfer.HandleThrottle()
 	elapsed := time.Since(startTime).Nanoseconds() / 1000000
 	assert.GreaterOrEqual(t, elapsed, wantedUploadElapsed, "upload bandwidth throttling not respected")
-	err := transfer.Close()
+	err = transfer.Close()
 	assert.NoError(t, err)
 
-	transfer = NewBaseTransfer(nil, conn, nil, "", "", TransferDownload, 0, 0, 0, true, fs)
+	transfer, err = NewBaseTransfer(nil, conn, nil, "", "", TransferDownload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
 	transfer.BytesSent = testFileSize
 	transfer.Connection.UpdateLastActivity()
 	startTime = transfer.Connection.GetLastActivity()
@@ -85,6 +90,60 @@ func TestTransferThrottling(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestTransferBandwidthLimits(t *testing.T) {
+	u := dataprovider.User{
+		Username:          "test",
+		UploadBandwidth:   50,
+		DownloadBandwidth: 40,
+	}
+	u.Filters.BandwidthLimits = []dataprovider.BandwidthLimit{
+		{
+			Sources:           []string{"192.168.1.0/24", "10.1.0.0/16"},
+			UploadBandwidth:   0,
+			DownloadBandwidth: 30,
+		},
+		{
+			Sources:         []string{"10.0.0.0/8"},
+			UploadBandwidth: 20,
+		},
+	}
+	fs := vfs.NewOsFs("", os.TempDir(), nil)
+	conn := NewBaseConnection("id", ProtocolSFTP, u, nil)
+	transfer, err := NewBaseTransfer(nil, conn, nil, "", "", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	assert.Equal(t, int64(50), transfer.uploadBandwidth)
+	assert.Equal(t, int64(40), transfer.downloadBandwidth)
+	assert.NoError(t, transfer.Close())
+
+	conn.SetRemoteAddress("192.168.1.5:4567")
+	transfer, err = NewBaseTransfer(nil, conn, nil, "", "", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), transfer.uploadBandwidth)
+	assert.Equal(t, int64(30), transfer.downloadBandwidth)
+	assert.NoError(t, transfer.Close())
+
+	conn.SetRemoteAddress("10.1.2.3:4567")
+	transfer, err = NewBaseTransfer(nil, conn, nil, "", "", TransferDownload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), transfer.uploadBandwidth)
+	assert.Equal(t, int64(30), transfer.downloadBandwidth)
+	assert.NoError(t, transfer.Close())
+
+	conn.SetRemoteAddress("10.2.2.3:4567")
+	transfer, err = NewBaseTransfer(nil, conn, nil, "", "", TransferDownload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), transfer.uploadBandwidth)
+	assert.Equal(t, int64(0), transfer.downloadBandwidth)
+	assert.NoError(t, transfer.Close())
+
+	conn.SetRemoteAddress("172.16.1.1:4567")
+	transfer, err = NewBaseTransfer(nil, conn, nil, "", "", TransferDownload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	assert.Equal(t, int64(50), transfer.uploadBandwidth)
+	assert.Equal(t, int64(40), transfer.downloadBandwidth)
+	assert.NoError(t, transfer.Close())
+}
+
 func TestRealPath(t *testing.T) {
 	testFile := filepath.Join(os.TempDir(), "afile.txt")
 	fs := vfs.NewOsFs("123", os.TempDir(), nil)
@@ -97,7 +156,8 @@ func TestRealPath(t *testing.T) {
 	file, err := os.Create(testFile)
 	require.NoError(t, err)
 	conn := NewBaseConnection(fs.ConnectionID(), ProtocolSFTP, u, fs)
-	transfer := NewBaseTransfer(file, conn, nil, testFile, "/transfer_test_file", TransferUpload, 0, 0, 0, true, fs)
+	transfer, err := NewBaseTransfer(file, conn, nil, testFile, "/transfer_test_file", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
 	rPath := transfer.GetRealFsPath(testFile)
 	assert.Equal(t, testFile, rPath)
 	rPath = conn.getRealFsPath(testFile)
@@ -132,7 +192,8 @@ func TestTruncate(t *testing.T) {
 	_, err = file.Write([]byte("hello"))
 	assert.NoError(t, err)
 	conn := NewBaseConnection(fs.ConnectionID(), ProtocolSFTP, u, fs)
-	transfer := NewBaseTransfer(file, conn, nil, testFile, "/transfer_test_file", TransferUpload, 0, 5, 100, false, fs)
+	transfer, err := NewBaseTransfer(file, conn, nil, testFile, "/transfer_test_file", TransferUpload, 0, 5, 100, false, fs)
+	require.NoError(t, err)
 
 	err = conn.SetStat(testFile, "/transfer_test_file", &StatAttributes{
 		Size:  2,
@@ -149,7 +210,8 @@ func TestTruncate(t *testing.T) {
 		assert.Equal(t, int64(2), fi.Size())
 	}
 
-	transfer = NewBaseTransfer(file, conn, nil, testFile, "/transfer_test_file", TransferUpload, 0, 0, 100, true, fs)
+	transfer, err = NewBaseTransfer(file, conn, nil, testFile, "/transfer_test_file", TransferUpload, 0, 0, 100, true, fs)
+	require.NoError(t, err)
 	// file.Stat will fail on a closed file
 	err = conn.SetStat(testFile, "/transfer_test_file", &StatAttributes{
 		Size:  2,
@@ -159,7 +221,8 @@ func TestTruncate(t *testing.T) {
 	err = transfer.Close()
 	assert.NoError(t, err)
 
-	transfer = NewBaseTransfer(nil, conn, nil, testFile, "", TransferUpload, 0, 0, 0, true, fs)
+	transfer, err = NewBaseTransfer(nil, conn, nil, testFile, "", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
 	_, err = transfer.Truncate("mismatch", 0)
 	assert.EqualError(t, err, errTransferMismatch.Error())
 	_, err = transfer.Truncate(testFile, 0)
@@ -194,7 +257,8 @@ func TestTransferErrors(t *testing.T) {
 		assert.FailNow(t, "unable to open test file")
 	}
 	conn := NewBaseConnection("id", ProtocolSFTP, u, fs)
-	transfer := NewBaseTransfer(file, conn, nil, testFile, "/transfer_test_file", TransferUpload, 0, 0, 0, true, fs)
+	transfer, err := NewBaseTransfer(file, conn, nil, testFile, "/transfer_test_file", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
 	assert.Nil(t, transfer.cancelFn)
 	assert.Equal(t, testFile, transfer.GetFsPath())
 	transfer.SetCancelFn(cancelFn)
@@ -220,7 +284,8 @@ func TestTransferErrors(t *testing.T) {
 		assert.FailNow(t, "unable to open test file")
 	}
 	fsPath := filepath.Join(os.TempDir(), "test_file")
-	transfer = NewBaseTransfer(file, conn, nil, fsPath, "/test_file", TransferUpload, 0, 0, 0, true, fs)
+	transfer, err = NewBaseTransfer(file, conn, nil, fsPath, "/test_file", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
 	transfer.BytesReceived = 9
 	transfer.TransferError(errFake)
 	assert.Error(t, transfer.ErrTransfer, errFake.Error())
@@ -239,7 +304,8 @@ func TestTransferErrors(t *testing.T) {
 	if !assert.NoError(t, err) {
 		assert.FailNow(t, "unable to open test file")
 	}
-	transfer = NewBaseTransfer(file, conn, nil, fsPath, "/test_file", TransferUpload, 0, 0, 0, true, fs)
+	transfer, err = NewBaseTransfer(file, conn, nil, fsPath, "/test_file", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
 	transfer.BytesReceived = 9
 	// the file is closed from the embedding struct before to call close
 	err = file.Close()
@@ -263,7 +329,8 @@ func TestRemovePartialCryptoFile(t *testing.T) {
 		HomeDir:  os.TempDir(),
 	}
 	conn := NewBaseConnection(fs.ConnectionID(), ProtocolSFTP, u, fs)
-	transfer := NewBaseTransfer(nil, conn, nil, testFile, "/transfer_test_file", TransferUpload, 0, 0, 0, true, fs)
+	transfer, err := NewBaseTransfer(nil, conn, nil, testFile, "/transfer_test_file", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
 	transfer.ErrTransfer = errors.New("test error")
 	_, err = transfer.getUploadFileSize()
 	assert.Error(t, err)
@@ -274,3 +341,255 @@ func TestRemovePartialCryptoFile(t *testing.T) {
 	assert.Equal(t, int64(9), size)
 	assert.NoFileExists(t, testFile)
 }
+
+func TestUploadChecksum(t *testing.T) {
+	Config.UploadChecksum = true
+	defer func() {
+		Config.UploadChecksum = false
+	}()
+
+	data := []byte("data to upload for the checksum test")
+	expected := sha256.Sum256(data)
+	fs := vfs.NewOsFs("", os.TempDir(), nil)
+	conn := NewBaseConnection("", ProtocolSFTP, dataprovider.User{}, fs)
+	transfer, err := NewBaseTransfer(nil, conn, nil, "", "", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	// out of order writes
+	transfer.UpdateChecksum(data[20:], 20)
+	transfer.UpdateChecksum(data[10:20], 10)
+	assert.Equal(t, "", transfer.getChecksum(int64(len(data))))
+	transfer.UpdateChecksum(data[:10], 0)
+	assert.Len(t, transfer.checksumPending, 0)
+	assert.Equal(t, 0, transfer.checksumPendingSize)
+	assert.Equal(t, "", transfer.getChecksum(int64(len(data)-1)))
+	assert.Equal(t, hex.EncodeToString(expected[:]), transfer.getChecksum(int64(len(data))))
+	conn.RemoveTransfer(transfer)
+	// overlapping writes
+	transfer, err = NewBaseTransfer(nil, conn, nil, "", "", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	transfer.UpdateChecksum(data[:10], 0)
+	transfer.UpdateChecksum(data[5:], 5)
+	assert.Nil(t, transfer.checksum)
+	assert.Equal(t, "", transfer.getChecksum(int64(len(data))))
+	conn.RemoveTransfer(transfer)
+	// too many pending writes
+	transfer, err = NewBaseTransfer(nil, conn, nil, "", "", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	transfer.UpdateChecksum(make([]byte, maxChecksumPendingSize), 1)
+	assert.NotNil(t, transfer.checksum)
+	transfer.UpdateChecksum(data, maxChecksumPendingSize+1)
+	assert.Nil(t, transfer.checksum)
+	conn.RemoveTransfer(transfer)
+	// resumed upload
+	transfer, err = NewBaseTransfer(nil, conn, nil, "", "", TransferUpload, 10, 10, 0, false, fs)
+	require.NoError(t, err)
+	assert.Nil(t, transfer.checksum)
+	conn.RemoveTransfer(transfer)
+	// the checksum is not available if the upload fails
+	transfer, err = NewBaseTransfer(nil, conn, nil, "", "", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	transfer.UpdateChecksum(data, 0)
+	transfer.TransferError(ErrGenericFailure)
+	assert.Equal(t, "", transfer.getChecksum(int64(len(data))))
+	conn.RemoveTransfer(transfer)
+
+	transfer, err = NewBaseTransfer(nil, conn, nil, "", "", TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
+	assert.Nil(t, transfer.checksum)
+	conn.RemoveTransfer(transfer)
+}
+
+func TestPartialUploadsPolicy(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "partial_uploads_home")
+	err := os.MkdirAll(homeDir, os.ModePerm)
+	require.NoError(t, err)
+	fs := vfs.NewOsFs("id", homeDir, nil)
+	u := dataprovider.User{
+		Username: "test",
+		HomeDir:  homeDir,
+	}
+	tempFile := filepath.Join(homeDir, ".upload_temp")
+	fsPath := filepath.Join(homeDir, "upload_file")
+	errFake := errors.New("err fake")
+
+	upload := func(user dataprovider.User) error {
+		err := ioutil.WriteFile(tempFile, []byte("test data"), os.ModePerm)
+		require.NoError(t, err)
+		file, err := os.Open(tempFile)
+		require.NoError(t, err)
+		conn := NewBaseConnection("id", ProtocolSFTP, user, fs)
+		transfer, err := NewBaseTransfer(file, conn, nil, fsPath, "/upload_file", TransferUpload, 0, 0, 0, true, fs)
+		require.NoError(t, err)
+		transfer.BytesReceived = 9
+		transfer.TransferError(errFake)
+		err = file.Close()
+		require.NoError(t, err)
+		return transfer.Close()
+	}
+
+	u.Filters.PartialUploads.Policy = dataprovider.PartialUploadsKeep
+	err = upload(u)
+	assert.Error(t, err)
+	assert.NoFileExists(t, tempFile)
+	assert.NoFileExists(t, fsPath)
+	assert.FileExists(t, fsPath+partialUploadSuffix)
+
+	u.Filters.PartialUploads.Policy = dataprovider.PartialUploadsDelete
+	err = upload(u)
+	assert.Error(t, err)
+	assert.NoFileExists(t, tempFile)
+	assert.NoFileExists(t, fsPath)
+
+	u.Filters.PartialUploads.Policy = dataprovider.PartialUploadsQuarantine
+	u.Filters.PartialUploads.QuarantinePath = "/quarantine"
+	err = upload(u)
+	assert.Error(t, err)
+	assert.NoFileExists(t, tempFile)
+	assert.NoFileExists(t, fsPath)
+	files, err := ioutil.ReadDir(filepath.Join(homeDir, "quarantine"))
+	assert.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		assert.True(t, strings.HasSuffix(files[0].Name(), "_upload_file"+partialUploadSuffix))
+		assert.Equal(t, int64(9), files[0].Size())
+	}
+
+	// missing parent directories are created
+	u.Filters.PartialUploads.QuarantinePath = "/quarantine/sub/dir"
+	err = upload(u)
+	assert.Error(t, err)
+	assert.NoFileExists(t, tempFile)
+	files, err = ioutil.ReadDir(filepath.Join(homeDir, "quarantine", "sub", "dir"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	// the temporary file is deleted if it cannot be kept
+	err = os.Remove(fsPath + partialUploadSuffix)
+	require.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(fsPath+partialUploadSuffix, "sub"), os.ModePerm)
+	require.NoError(t, err)
+	u.Filters.PartialUploads = dataprovider.PartialUploads{
+		Policy: dataprovider.PartialUploadsKeep,
+	}
+	err = upload(u)
+	assert.Error(t, err)
+	assert.NoFileExists(t, tempFile)
+	assert.DirExists(t, fsPath+partialUploadSuffix)
+	err = os.RemoveAll(fsPath + partialUploadSuffix)
+	require.NoError(t, err)
+
+	u.Filters.PartialUploads = dataprovider.PartialUploads{}
+	Config.UploadMode = UploadModeAtomicWithResume
+	err = upload(u)
+	Config.UploadMode = UploadModeStandard
+	assert.Error(t, err)
+	assert.NoFileExists(t, tempFile)
+	assert.FileExists(t, fsPath)
+
+	err = os.RemoveAll(homeDir)
+	assert.NoError(t, err)
+}
+
+func TestRestorePartialUpload(t *testing.T) {
+	homeDir := filepath.Join(os.TempDir(), "restore_partial_home")
+	err := os.MkdirAll(homeDir, os.ModePerm)
+	require.NoError(t, err)
+	fs := vfs.NewOsFs("id", homeDir, nil)
+	u := dataprovider.User{
+		Username: "test",
+		HomeDir:  homeDir,
+	}
+	fsPath := filepath.Join(homeDir, "file")
+	err = ioutil.WriteFile(fsPath+partialUploadSuffix, []byte("data"), os.ModePerm)
+	require.NoError(t, err)
+
+	conn := NewBaseConnection("id", ProtocolSFTP, u, fs)
+	_, err = conn.RestorePartialUpload(fsPath, "/file")
+	assert.Error(t, err)
+	u.Filters.PartialUploads.Policy = dataprovider.PartialUploadsKeep
+	conn = NewBaseConnection("id", ProtocolSFTP, u, fs)
+	// atomic uploads are disabled
+	_, err = conn.RestorePartialUpload(fsPath, "/file")
+	assert.Error(t, err)
+
+	oldUploadMode := Config.UploadMode
+	Config.UploadMode = UploadModeAtomic
+	defer func() {
+		Config.UploadMode = oldUploadMode
+	}()
+	_, err = conn.RestorePartialUpload(filepath.Join(homeDir, "missing"), "/missing")
+	assert.Error(t, err)
+	info, err := conn.RestorePartialUpload(fsPath, "/file")
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(4), info.Size())
+	}
+	assert.FileExists(t, fsPath)
+	assert.NoFileExists(t, fsPath+partialUploadSuffix)
+
+	err = os.Mkdir(filepath.Join(homeDir, "dir"+partialUploadSuffix), os.ModePerm)
+	require.NoError(t, err)
+	_, err = conn.RestorePartialUpload(filepath.Join(homeDir, "dir"), "/dir")
+	assert.Error(t, err)
+
+	err = os.RemoveAll(homeDir)
+	assert.NoError(t, err)
+}
+
+func TestTransferSpeed(t *testing.T) {
+	testFilePath := filepath.Join(os.TempDir(), "speed_test_file")
+	err := ioutil.WriteFile(testFilePath, make([]byte, 4096), os.ModePerm)
+	require.NoError(t, err)
+	file, err := os.Open(testFilePath)
+	require.NoError(t, err)
+
+	fs := vfs.NewOsFs("", os.TempDir(), nil)
+	conn := NewBaseConnection("id", ProtocolSFTP, dataprovider.User{}, fs)
+	transfer, err := NewBaseTransfer(file, conn, nil, testFilePath, "/speed_test_file", TransferDownload, 0, 0, 0,
+		false, fs)
+	require.NoError(t, err)
+	// the expected size is set from the local file
+	assert.Equal(t, int64(4096), transfer.GetExpectedSize())
+
+	transfer.start = time.Now().Add(-2 * time.Second)
+	transfer.BytesSent = 2048
+	speed := transfer.GetSpeed()
+	assert.InDelta(t, 1024, speed, 50)
+	transfers := conn.GetTransfers()
+	if assert.Len(t, transfers, 1) {
+		assert.Greater(t, transfers[0].Speed, int64(0))
+		assert.Equal(t, int64(4096), transfers[0].ExpectedSize)
+		assert.GreaterOrEqual(t, transfers[0].ETA, int64(1))
+		assert.Contains(t, transfers[0].getConnectionTransferAsString(), "ETA:")
+	}
+	// the samples older than the window are removed, the newest of them is kept
+	transfer.speedSamples = []transferSpeedSample{
+		{time: time.Now().Add(-30 * time.Second), size: 0},
+		{time: time.Now().Add(-20 * time.Second), size: 1024},
+		{time: time.Now().Add(-4 * time.Second), size: 2048},
+	}
+	transfer.BytesSent = 3072
+	speed = transfer.GetSpeed()
+	assert.InDelta(t, 2048/20, speed, 10)
+	assert.Len(t, transfer.speedSamples, 3)
+
+	ct := ConnectionTransfer{
+		Size:         100,
+		Speed:        0,
+		ExpectedSize: 1000,
+	}
+	ct.setETA()
+	assert.Equal(t, int64(-1), ct.ETA)
+	ct.Speed = 100
+	ct.setETA()
+	assert.Equal(t, int64(9), ct.ETA)
+	ct.Size = 2000
+	ct.setETA()
+	assert.Equal(t, int64(0), ct.ETA)
+	ct.ExpectedSize = 0
+	ct.setETA()
+	assert.Equal(t, int64(-1), ct.ETA)
+	assert.NotContains(t, ct.getConnectionTransferAsString(), "ETA:")
+
+	err = transfer.Close()
+	assert.NoError(t, err)
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+}
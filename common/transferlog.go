@@ -0,0 +1,223 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/version"
+)
+
+// Supported transfer log formats
+const (
+	// TransferLogFormatXferlog is the wu-ftpd xferlog format
+	TransferLogFormatXferlog = "xferlog"
+	// TransferLogFormatCEF is the ArcSight Common Event Format
+	TransferLogFormatCEF = "cef"
+)
+
+const xferlogTimeFormat = "Mon Jan _2 15:04:05 2006"
+
+var (
+	transferLogFormats = []string{TransferLogFormatXferlog, TransferLogFormatCEF}
+	cefHeaderReplacer  = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	cefValueReplacer   = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+// TransferLogConfig defines the configuration for the optional transfer log.
+// Each completed upload or download is written to a dedicated file using a
+// format understood by the SIEM parsers
+type TransferLogConfig struct {
+	// Log format, "xferlog" or "cef". Empty means disabled
+	Format string `json:"format" mapstructure:"format"`
+	// Absolute path to the transfer log file
+	FilePath string `json:"file_path" mapstructure:"file_path"`
+	// Maximum size, as megabytes, of the transfer log file before it gets rotated. 0 means 100
+	MaxSize int `json:"max_size" mapstructure:"max_size"`
+	// Maximum number of rotated transfer log files to retain. 0 means retain all
+	MaxBackups int `json:"max_backups" mapstructure:"max_backups"`
+	// Maximum number of days to retain the rotated transfer log files. 0 means retain all
+	MaxAge int `json:"max_age" mapstructure:"max_age"`
+	// Determine if the rotated transfer log files must be compressed using gzip
+	Compress bool `json:"compress" mapstructure:"compress"`
+}
+
+func (c *TransferLogConfig) isEnabled() bool {
+	return c.Format != ""
+}
+
+func (c *TransferLogConfig) validate() error {
+	if !c.isEnabled() {
+		return nil
+	}
+	if !utils.IsStringInSlice(c.Format, transferLogFormats) {
+		return fmt.Errorf("invalid format %#v, valid formats: %v", c.Format, strings.Join(transferLogFormats, ", "))
+	}
+	if !filepath.IsAbs(c.FilePath) {
+		return fmt.Errorf("invalid file path %#v: it must be an absolute path", c.FilePath)
+	}
+	if c.MaxSize < 0 || c.MaxBackups < 0 || c.MaxAge < 0 {
+		return errors.New("max size, max backups and max age cannot be negative")
+	}
+	return nil
+}
+
+// transferLogEntry defines a completed transfer
+type transferLogEntry struct {
+	time         time.Time
+	elapsed      time.Duration
+	transferType int
+	username     string
+	ip           string
+	protocol     string
+	connectionID string
+	// virtual path
+	path string
+	size int64
+	err  error
+}
+
+type transferLog struct {
+	format string
+	writer *lumberjack.Logger
+}
+
+func newTransferLog(config TransferLogConfig) (*transferLog, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(config.FilePath), 0700); err != nil {
+		return nil, err
+	}
+	maxSize := config.MaxSize
+	if maxSize == 0 {
+		maxSize = 100
+	}
+	return &transferLog{
+		format: config.Format,
+		writer: &lumberjack.Logger{
+			Filename:   config.FilePath,
+			MaxSize:    maxSize,
+			MaxBackups: config.MaxBackups,
+			MaxAge:     config.MaxAge,
+			Compress:   config.Compress,
+		},
+	}, nil
+}
+
+func (l *transferLog) write(entry *transferLogEntry) {
+	var line string
+	switch l.format {
+	case TransferLogFormatCEF:
+		line = entry.getAsCEF()
+	default:
+		line = entry.getAsXferlog()
+	}
+	if _, err := l.writer.Write([]byte(line + "\n")); err != nil {
+		logger.Warn(logSender, entry.connectionID, "unable to write transfer log entry: %v", err)
+	}
+}
+
+func (l *transferLog) close() {
+	if err := l.writer.Close(); err != nil {
+		logger.Warn(logSender, "", "unable to close the transfer log: %v", err)
+	}
+}
+
+// getAsXferlog returns the entry in wu-ftpd xferlog format:
+// current-time transfer-time remote-host file-size filename transfer-type special-action-flag
+// direction access-mode username service-name authentication-method authenticated-user-id completion-status
+func (e *transferLogEntry) getAsXferlog() string {
+	direction := "i"
+	if e.transferType == TransferDownload {
+		direction = "o"
+	}
+	status := "c"
+	if e.err != nil {
+		status = "i"
+	}
+	transferTime := int64(e.elapsed.Round(time.Second) / time.Second)
+	// the fields are space separated, the spaces within the file name are replaced as wu-ftpd does
+	fileName := strings.ReplaceAll(e.path, " ", "_")
+	username := strings.ReplaceAll(e.username, " ", "_")
+	return fmt.Sprintf("%v %v %v %v %v b _ %v r %v %v 0 * %v", e.time.Format(xferlogTimeFormat), transferTime,
+		e.ip, e.size, fileName, direction, username, strings.ToLower(e.protocol), status)
+}
+
+// getAsCEF returns the entry in ArcSight Common Event Format
+func (e *transferLogEntry) getAsCEF() string {
+	signatureID := "upload"
+	name := "File upload"
+	if e.transferType == TransferDownload {
+		signatureID = "download"
+		name = "File download"
+	}
+	severity := 3
+	outcome := "success"
+	if e.err != nil {
+		severity = 6
+		outcome = "failure"
+	}
+	extensions := []string{
+		"rt=" + cefValueReplacer.Replace(fmt.Sprintf("%v", utils.GetTimeAsMsSinceEpoch(e.time))),
+		"suser=" + cefValueReplacer.Replace(e.username),
+		"src=" + cefValueReplacer.Replace(e.ip),
+		"app=" + cefValueReplacer.Replace(e.protocol),
+		"fname=" + cefValueReplacer.Replace(path.Base(e.path)),
+		"filePath=" + cefValueReplacer.Replace(e.path),
+		"fsize=" + cefValueReplacer.Replace(fmt.Sprintf("%v", e.size)),
+		"outcome=" + outcome,
+		"cn1Label=elapsedMs",
+		"cn1=" + fmt.Sprintf("%v", e.elapsed.Milliseconds()),
+		"cs1Label=connectionId",
+		"cs1=" + cefValueReplacer.Replace(e.connectionID),
+	}
+	if e.err != nil {
+		extensions = append(extensions, "msg="+cefValueReplacer.Replace(e.err.Error()))
+	}
+	return fmt.Sprintf("CEF:0|SFTPGo|SFTPGo|%v|%v|%v|%v|%v", cefHeaderReplacer.Replace(version.Get().Version),
+		signatureID, name, severity, strings.Join(extensions, " "))
+}
+
+func closeTransferLog() {
+	if Config.transferLog != nil {
+		Config.transferLog.close()
+		Config.transferLog = nil
+	}
+}
+
+// RotateTransferLog closes the existing transfer log file and immediately creates a new one
+func RotateTransferLog() error {
+	if Config.transferLog == nil {
+		return errors.New("the transfer log is disabled")
+	}
+	return Config.transferLog.writer.Rotate()
+}
+
+// logTransfer writes a transfer log entry, if the transfer log is enabled.
+// The path is the virtual path as seen by the user
+func (c *BaseConnection) logTransfer(transferType int, virtualPath string, size int64, elapsed time.Duration, err error) {
+	if Config.transferLog == nil {
+		return
+	}
+	Config.transferLog.write(&transferLogEntry{
+		time:         time.Now(),
+		elapsed:      elapsed,
+		transferType: transferType,
+		username:     c.User.Username,
+		ip:           c.remoteIP,
+		protocol:     c.protocol,
+		connectionID: c.ID,
+		path:         virtualPath,
+		size:         size,
+		err:          err,
+	})
+}
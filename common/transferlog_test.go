@@ -0,0 +1,139 @@
+package common
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+func readTransferLogLines(t *testing.T, name string) []string {
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	require.NoError(t, scanner.Err())
+	return lines
+}
+
+func TestTransferLogConfig(t *testing.T) {
+	c := TransferLogConfig{}
+	assert.False(t, c.isEnabled())
+	assert.NoError(t, c.validate())
+	c.Format = "w3c"
+	assert.Error(t, c.validate())
+	c.Format = TransferLogFormatXferlog
+	c.FilePath = "relative.log"
+	assert.Error(t, c.validate())
+	c.FilePath = filepath.Join(os.TempDir(), "xferlog")
+	assert.NoError(t, c.validate())
+	c.MaxAge = -1
+	assert.Error(t, c.validate())
+	_, err := newTransferLog(c)
+	assert.Error(t, err)
+
+	err = RotateTransferLog()
+	assert.Error(t, err)
+
+	configCopy := Config
+	config := configCopy
+	config.TransferLog = TransferLogConfig{
+		Format: TransferLogFormatCEF,
+	}
+	err = Initialize(config)
+	assert.Error(t, err)
+	config.TransferLog.FilePath = filepath.Join(os.TempDir(), "xferlog")
+	err = Initialize(config)
+	require.NoError(t, err)
+	assert.NotNil(t, Config.transferLog)
+	assert.NoError(t, RotateTransferLog())
+
+	err = Initialize(configCopy)
+	require.NoError(t, err)
+	assert.Nil(t, Config.transferLog)
+	Config = configCopy
+	err = os.Remove(filepath.Join(os.TempDir(), "xferlog"))
+	assert.NoError(t, err)
+}
+
+func TestTransferLogFormats(t *testing.T) {
+	entry := transferLogEntry{
+		time:         time.Date(2021, time.March, 1, 10, 15, 2, 0, time.Local),
+		elapsed:      3012 * time.Millisecond,
+		transferType: TransferUpload,
+		username:     "user 1",
+		ip:           "192.168.1.10",
+		protocol:     ProtocolSFTP,
+		connectionID: "SFTP_id",
+		path:         "/my dir/file.zip",
+		size:         1048576,
+	}
+	assert.Equal(t, "Mon Mar  1 10:15:02 2021 3 192.168.1.10 1048576 /my_dir/file.zip b _ i r user_1 sftp 0 * c",
+		entry.getAsXferlog())
+	line := entry.getAsCEF()
+	assert.True(t, strings.HasPrefix(line, "CEF:0|SFTPGo|SFTPGo|"))
+	assert.Contains(t, line, "|upload|File upload|3|")
+	assert.Contains(t, line, "suser=user 1 src=192.168.1.10 app=SFTP fname=file.zip filePath=/my dir/file.zip fsize=1048576 outcome=success")
+	assert.Contains(t, line, "cn1=3012")
+	assert.NotContains(t, line, "msg=")
+
+	entry.transferType = TransferDownload
+	entry.err = errors.New("a=b\nc")
+	assert.True(t, strings.HasSuffix(entry.getAsXferlog(), " o r user_1 sftp 0 * i"))
+	line = entry.getAsCEF()
+	assert.Contains(t, line, "|download|File download|6|")
+	assert.Contains(t, line, "outcome=failure")
+	assert.Contains(t, line, `msg=a\=b\nc`)
+}
+
+func TestTransferLog(t *testing.T) {
+	transferLogPath := filepath.Join(os.TempDir(), "xfer", "xferlog")
+	transferLog, err := newTransferLog(TransferLogConfig{
+		Format:   TransferLogFormatXferlog,
+		FilePath: transferLogPath,
+	})
+	require.NoError(t, err)
+	Config.transferLog = transferLog
+	defer closeTransferLog()
+
+	u := dataprovider.User{
+		Username: userTestUsername,
+	}
+	fs := vfs.NewOsFs("", os.TempDir(), nil)
+	conn := NewBaseConnection("id", ProtocolFTP, u, fs)
+	conn.SetRemoteAddress("172.16.1.1:12345")
+	transfer, err := NewBaseTransfer(nil, conn, nil, "", "/file", TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	transfer.BytesReceived = 123
+	err = transfer.Close()
+	assert.NoError(t, err)
+	transfer, err = NewBaseTransfer(nil, conn, nil, "", "/file", TransferDownload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
+	transfer.BytesSent = 100
+	transfer.TransferError(errors.New("fake error"))
+	err = transfer.Close()
+	assert.Error(t, err)
+
+	lines := readTransferLogLines(t, transferLogPath)
+	if assert.Len(t, lines, 2) {
+		assert.True(t, strings.HasSuffix(lines[0], " 172.16.1.1 123 /file b _ i r "+userTestUsername+" ftp 0 * c"), lines[0])
+		assert.True(t, strings.HasSuffix(lines[1], " 172.16.1.1 100 /file b _ o r "+userTestUsername+" ftp 0 * i"), lines[1])
+	}
+
+	err = os.RemoveAll(filepath.Dir(transferLogPath))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,131 @@
+package common
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+)
+
+var transferQuotas = newTransferQuotaTracker()
+
+// transferQuotaUsage tracks the data transfer quota usage for a user with active transfers.
+// The bytes moved by the active transfers are stored in the data provider only when the
+// transfers are closed, so we track them here and check them, together with the usage
+// loaded from the data provider, against the user's limits
+type transferQuotaUsage struct {
+	sync.Mutex
+	// uploaded and downloaded bytes stored in the data provider when the first active
+	// transfer started plus the bytes moved by the transfers closed since then
+	usedUL    int64
+	usedDL    int64
+	transfers map[*BaseTransfer]bool
+	// number of active transfers referencing this usage, protected by the tracker lock
+	refs int
+}
+
+func (u *transferQuotaUsage) getUsed() (int64, int64) {
+	u.Lock()
+	defer u.Unlock()
+
+	usedUL := u.usedUL
+	usedDL := u.usedDL
+	for t := range u.transfers {
+		usedUL += atomic.LoadInt64(&t.BytesReceived)
+		usedDL += atomic.LoadInt64(&t.BytesSent)
+	}
+	return usedUL, usedDL
+}
+
+// isUploadExceeded returns true if the uploaded bytes exceed the limit, a limit <= 0 means unlimited
+func (u *transferQuotaUsage) isUploadExceeded(limit int64) bool {
+	if limit <= 0 {
+		return false
+	}
+	usedUL, _ := u.getUsed()
+	return usedUL > limit
+}
+
+// isDownloadExceeded returns true if the downloaded bytes exceed the limit, a limit <= 0 means unlimited
+func (u *transferQuotaUsage) isDownloadExceeded(limit int64) bool {
+	if limit <= 0 {
+		return false
+	}
+	_, usedDL := u.getUsed()
+	return usedDL > limit
+}
+
+type transferQuotaTracker struct {
+	sync.Mutex
+	users map[string]*transferQuotaUsage
+}
+
+func newTransferQuotaTracker() *transferQuotaTracker {
+	return &transferQuotaTracker{
+		users: make(map[string]*transferQuotaUsage),
+	}
+}
+
+// add adds the given transfer to the usage for its user and returns the usage.
+// The used data transfer is loaded from the data provider if the user has no
+// other active transfers
+func (q *transferQuotaTracker) add(t *BaseTransfer) *transferQuotaUsage {
+	user := &t.Connection.User
+
+	// temporary credentials share the usage with their parent user
+	username := user.GetQuotaUsername()
+
+	q.Lock()
+	usage, ok := q.users[username]
+	if !ok {
+		usage = &transferQuotaUsage{
+			transfers: make(map[*BaseTransfer]bool),
+		}
+		q.users[username] = usage
+	}
+	usage.refs++
+	// the usage lock is acquired before releasing the tracker lock so concurrent
+	// transfers for the same user wait for the usage to be loaded
+	usage.Lock()
+	q.Unlock()
+
+	if !ok {
+		usedUL, usedDL, err := dataprovider.GetUsedTransferQuota(user)
+		if err != nil {
+			t.Connection.Log(logger.LevelWarn, "error getting used data transfer for %#v: %v", user.Username, err)
+			// deny the transfers, we are unable to check the limits
+			usedUL = user.Filters.TransferQuota.UploadSize
+			usedDL = user.Filters.TransferQuota.DownloadSize
+		}
+		usage.usedUL = usedUL
+		usage.usedDL = usedDL
+	}
+	usage.transfers[t] = true
+	usage.Unlock()
+
+	return usage
+}
+
+// remove removes the given transfer from the usage for its user and adds the specified
+// transferred bytes to the used data transfer. The usage is removed if the user has no
+// other active transfers, it will be loaded again from the data provider
+func (q *transferQuotaTracker) remove(t *BaseTransfer, usage *transferQuotaUsage, uploadSize, downloadSize int64) {
+	usage.Lock()
+	if !usage.transfers[t] {
+		usage.Unlock()
+		return
+	}
+	delete(usage.transfers, t)
+	usage.usedUL += uploadSize
+	usage.usedDL += downloadSize
+	usage.Unlock()
+
+	q.Lock()
+	defer q.Unlock()
+
+	usage.refs--
+	if usage.refs <= 0 {
+		delete(q.users, t.Connection.User.GetQuotaUsername())
+	}
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+func TestConcurrentTransfersQuota(t *testing.T) {
+	user := dataprovider.User{
+		Username: userTestUsername,
+		HomeDir:  filepath.Join(os.TempDir(), "home"),
+		Password: userTestPwd,
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	user.Filters.TransferQuota = dataprovider.TransferQuota{
+		Period:       dataprovider.TransferQuotaPeriodDay,
+		UploadSize:   100,
+		DownloadSize: 100,
+	}
+	err := dataprovider.AddUser(&user)
+	require.NoError(t, err)
+	err = dataprovider.UpdateUserTransferQuota(&user, 20, 0)
+	assert.NoError(t, err)
+
+	fs := vfs.NewOsFs("", os.TempDir(), nil)
+	conn1 := NewBaseConnection("id1", ProtocolSFTP, user, fs)
+	conn2 := NewBaseConnection("id2", ProtocolSFTP, user, fs)
+	transfer1, err := NewBaseTransfer(nil, conn1, nil, filepath.Join(os.TempDir(), "f1"), "/f1", TransferUpload,
+		0, 0, 0, true, fs)
+	require.NoError(t, err)
+	transfer2, err := NewBaseTransfer(nil, conn2, nil, filepath.Join(os.TempDir(), "f2"), "/f2", TransferUpload,
+		0, 0, 0, true, fs)
+	require.NoError(t, err)
+	// each transfer is within the allowance but together they exceed it
+	atomic.StoreInt64(&transfer1.BytesReceived, 50)
+	assert.NoError(t, transfer1.CheckWrite())
+	atomic.StoreInt64(&transfer2.BytesReceived, 40)
+	assert.ErrorIs(t, transfer1.CheckWrite(), ErrQuotaExceeded)
+	assert.ErrorIs(t, transfer2.CheckWrite(), ErrQuotaExceeded)
+	assert.NoError(t, transfer1.CheckRead())
+
+	transfer1.TransferError(ErrQuotaExceeded)
+	err = transfer1.Close()
+	assert.Error(t, err)
+	// the bytes of the closed transfer are still accounted while other transfers are active
+	transfer3, err := NewBaseTransfer(nil, conn1, nil, filepath.Join(os.TempDir(), "f3"), "/f3", TransferDownload,
+		0, 0, 0, false, fs)
+	require.NoError(t, err)
+	assert.ErrorIs(t, transfer3.CheckWrite(), ErrQuotaExceeded)
+	atomic.StoreInt64(&transfer2.BytesReceived, 0)
+	assert.NoError(t, transfer3.CheckWrite())
+	atomic.StoreInt64(&transfer3.BytesSent, 101)
+	assert.ErrorIs(t, transfer3.CheckRead(), ErrReadQuotaExceeded)
+	atomic.StoreInt64(&transfer3.BytesSent, 60)
+	assert.NoError(t, transfer3.CheckRead())
+
+	err = transfer2.Close()
+	assert.NoError(t, err)
+	err = transfer3.Close()
+	assert.NoError(t, err)
+
+	transferQuotas.Lock()
+	assert.Len(t, transferQuotas.users, 0)
+	transferQuotas.Unlock()
+
+	usedUL, usedDL, err := dataprovider.GetUsedTransferQuota(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(70), usedUL)
+	assert.Equal(t, int64(60), usedDL)
+
+	err = dataprovider.DeleteUser(user.Username)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
@@ -4,9 +4,10 @@ package config
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strconv"
+	"reflect"
 	"strings"
 
 	"github.com/spf13/viper"
@@ -14,11 +15,15 @@ import (
 	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/ftpd"
+	"github.com/drakkan/sftpgo/geoip"
 	"github.com/drakkan/sftpgo/httpclient"
 	"github.com/drakkan/sftpgo/httpd"
 	"github.com/drakkan/sftpgo/kms"
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/logshipper"
+	"github.com/drakkan/sftpgo/notifier"
 	"github.com/drakkan/sftpgo/sftpd"
+	"github.com/drakkan/sftpgo/smtp"
 	"github.com/drakkan/sftpgo/telemetry"
 	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/version"
@@ -33,6 +38,9 @@ const (
 	configName = "sftpgo"
 	// ConfigEnvPrefix defines a prefix that environment variables will use
 	configEnvPrefix = "sftpgo"
+	// overlayDirName defines the name of the directory, inside the config dir, with the
+	// configuration files to merge over the main configuration
+	overlayDirName = "conf.d"
 )
 
 var (
@@ -54,19 +62,26 @@ var (
 		TLSCipherSuites:  nil,
 	}
 	defaultWebDAVDBinding = webdavd.Binding{
-		Address:         "",
-		Port:            0,
-		EnableHTTPS:     false,
-		ClientAuthType:  0,
-		TLSCipherSuites: nil,
+		Address:             "",
+		Port:                0,
+		EnableHTTPS:         false,
+		ClientAuthType:      0,
+		TLSCipherSuites:     nil,
+		ProxyAllowed:        nil,
+		ClientIPProxyHeader: "",
+		ClientIPHeaderDepth: 0,
 	}
 	defaultHTTPDBinding = httpd.Binding{
-		Address:         "127.0.0.1",
-		Port:            8080,
-		EnableWebAdmin:  true,
-		EnableHTTPS:     false,
-		ClientAuthType:  0,
-		TLSCipherSuites: nil,
+		Address:             "127.0.0.1",
+		Port:                8080,
+		EnableWebAdmin:      true,
+		RenderOpenAPI:       true,
+		EnableHTTPS:         false,
+		ClientAuthType:      0,
+		TLSCipherSuites:     nil,
+		ProxyAllowed:        nil,
+		ClientIPProxyHeader: "",
+		ClientIPHeaderDepth: 0,
 	}
 )
 
@@ -80,6 +95,10 @@ type globalConfig struct {
 	HTTPConfig      httpclient.Config     `json:"http" mapstructure:"http"`
 	KMSConfig       kms.Configuration     `json:"kms" mapstructure:"kms"`
 	TelemetryConfig telemetry.Conf        `json:"telemetry" mapstructure:"telemetry"`
+	SMTPConfig      smtp.Config           `json:"smtp" mapstructure:"smtp"`
+	GeoIPConfig     geoip.Config          `json:"geoip" mapstructure:"geoip"`
+	LogShipper      logshipper.Config     `json:"log_shipper" mapstructure:"log_shipper"`
+	Notifications   notifier.Config       `json:"notifications" mapstructure:"notifications"`
 }
 
 func init() {
@@ -93,44 +112,115 @@ func Init() {
 	// create a default configuration to use if no config file is provided
 	globalConf = globalConfig{
 		Common: common.Configuration{
-			IdleTimeout: 15,
-			UploadMode:  0,
+			IdleTimeout:    15,
+			UploadMode:     0,
+			UploadChecksum: false,
 			Actions: common.ProtocolActions{
 				ExecuteOn: []string{},
 				Hook:      "",
+				Queue: common.ActionsQueueConfig{
+					Enabled:     false,
+					MaxAttempts: 0,
+					BaseDelay:   10,
+					MaxDelay:    3600,
+				},
+				Brokers: []common.BrokerConfig{},
+				Emails:  []common.ActionEmailConfig{},
+			},
+			SetstatMode:            0,
+			ProxyProtocol:          0,
+			ProxyAllowed:           []string{},
+			PostConnectHook:        "",
+			SessionEndHook:         "",
+			StalledTransferTimeout: 0,
+			QuotaScanSchedule: common.QuotaScanScheduleConfig{
+				Interval: 0,
+				Cron:     "",
+			},
+			DataRetentionSchedule: common.DataRetentionScheduleConfig{
+				Interval: 0,
+				Cron:     "",
+			},
+			BackupSchedule: common.BackupScheduleConfig{
+				Interval:  0,
+				Cron:      "",
+				OutputDir: "",
+				S3: common.BackupS3Config{
+					Bucket:       "",
+					KeyPrefix:    "",
+					Region:       "",
+					AccessKey:    "",
+					AccessSecret: "",
+					Endpoint:     "",
+					StorageClass: "",
+				},
+				Passphrase: "",
+				Keep:       0,
 			},
-			SetstatMode:         0,
-			ProxyProtocol:       0,
-			ProxyAllowed:        []string{},
-			PostConnectHook:     "",
-			MaxTotalConnections: 0,
+			GracefulShutdownTimeout: 0,
+			MaxTotalConnections:     0,
 			DefenderConfig: common.DefenderConfig{
-				Enabled:          false,
-				BanTime:          30,
-				BanTimeIncrement: 50,
-				Threshold:        15,
-				ScoreInvalid:     2,
-				ScoreValid:       1,
-				ObservationTime:  30,
-				EntriesSoftLimit: 100,
-				EntriesHardLimit: 150,
-				SafeListFile:     "",
-				BlockListFile:    "",
+				Enabled:                false,
+				BanTime:                30,
+				BanTimeIncrement:       50,
+				Threshold:              15,
+				ScoreInvalid:           2,
+				ScoreValid:             1,
+				ScoreProtocolViolation: 0,
+				ObservationTime:        30,
+				EntriesSoftLimit:       100,
+				EntriesHardLimit:       150,
+				SafeListFile:           "",
+				BlockListFile:          "",
+				Tarpit: common.TarpitConfig{
+					Enabled:        false,
+					Interval:       10,
+					MaxDuration:    0,
+					MaxConnections: 100,
+				},
+			},
+			LoginThrottling: common.LoginThrottlingConfig{
+				Delay:       0,
+				MaxDelay:    10000,
+				MaxAttempts: 0,
+				Period:      60,
+			},
+			RateLimiters: []common.RateLimiterConfig{},
+			IPLists: common.IPListsConfig{
+				AllowListFile: "",
+				DenyListFile:  "",
+			},
+			AuditLog: common.AuditLogConfig{
+				FilePath:   "",
+				MaxSize:    100,
+				MaxBackups: 0,
+				MaxAge:     0,
+				Compress:   false,
+			},
+			TransferLog: common.TransferLogConfig{
+				Format:     "",
+				FilePath:   "",
+				MaxSize:    100,
+				MaxBackups: 0,
+				MaxAge:     0,
+				Compress:   false,
 			},
 		},
 		SFTPD: sftpd.Configuration{
-			Banner:                  defaultSFTPDBanner,
-			Bindings:                []sftpd.Binding{defaultSFTPDBinding},
-			MaxAuthTries:            0,
-			HostKeys:                []string{},
-			KexAlgorithms:           []string{},
-			Ciphers:                 []string{},
-			MACs:                    []string{},
-			TrustedUserCAKeys:       []string{},
-			LoginBannerFile:         "",
-			EnabledSSHCommands:      sftpd.GetDefaultSSHCommands(),
-			KeyboardInteractiveHook: "",
-			PasswordAuthentication:  true,
+			Banner:                            defaultSFTPDBanner,
+			Bindings:                          []sftpd.Binding{defaultSFTPDBinding},
+			MaxAuthTries:                      0,
+			HostKeys:                          []string{},
+			KexAlgorithms:                     []string{},
+			Ciphers:                           []string{},
+			MACs:                              []string{},
+			TrustedUserCAKeys:                 []string{},
+			CertPrincipalMappings:             []sftpd.CertPrincipalMapping{},
+			LoginBannerFile:                   "",
+			EnabledSSHCommands:                sftpd.GetDefaultSSHCommands(),
+			KeyboardInteractiveHook:           "",
+			KeyboardInteractiveAuthentication: false,
+			PasswordAuthentication:            true,
 		},
 		FTPD: ftpd.Configuration{
 			Bindings:                 []ftpd.Binding{defaultFTPDBinding},
@@ -177,47 +267,100 @@ func Init() {
 			},
 		},
 		ProviderConf: dataprovider.Config{
-			Driver:           "sqlite",
-			Name:             "sftpgo.db",
-			Host:             "",
-			Port:             5432,
-			Username:         "",
-			Password:         "",
-			ConnectionString: "",
-			SQLTablesPrefix:  "",
-			SSLMode:          0,
-			TrackQuota:       1,
-			PoolSize:         0,
-			UsersBaseDir:     "",
+			Driver:                "sqlite",
+			Name:                  "sftpgo.db",
+			Host:                  "",
+			Port:                  5432,
+			Username:              "",
+			Password:              "",
+			ConnectionString:      "",
+			ReadReplicas:          []string{},
+			SQLTablesPrefix:       "",
+			SSLMode:               0,
+			TrackQuota:            1,
+			PoolSize:              0,
+			MaxIdleConnections:    0,
+			ConnectionMaxLifetime: 240,
+			AuthRetries:           2,
+			AuthRetryInterval:     100,
+			UsersBaseDir:          "",
 			Actions: dataprovider.UserActions{
 				ExecuteOn: []string{},
 				Hook:      "",
 			},
-			ExternalAuthHook:   "",
-			ExternalAuthScope:  0,
-			CredentialsPath:    "credentials",
-			PreLoginHook:       "",
-			PostLoginHook:      "",
-			PostLoginScope:     0,
-			CheckPasswordHook:  "",
-			CheckPasswordScope: 0,
+			ExternalAuthHook:         "",
+			ExternalAuthScope:        0,
+			CredentialsPath:          "credentials",
+			PreLoginHook:             "",
+			PostLoginHook:            "",
+			PostLoginScope:           0,
+			CheckPasswordHook:        "",
+			CheckPasswordScope:       0,
+			CaseInsensitiveUsernames: false,
+			UsersCache: dataprovider.UsersCacheConfig{
+				ExpirationTime: 0,
+				MaxSize:        1000,
+			},
 			PasswordHashing: dataprovider.PasswordHashing{
+				BcryptOptions: dataprovider.BcryptOptions{
+					Cost: 10,
+				},
 				Argon2Options: dataprovider.Argon2Options{
 					Memory:      65536,
 					Iterations:  1,
 					Parallelism: 2,
 				},
+				Algo: dataprovider.HashingAlgoArgon2ID,
 			},
-			UpdateMode:                0,
-			PreferDatabaseCredentials: false,
+			LDAPAuth: dataprovider.LDAPAuthConfig{
+				Domains: []dataprovider.LDAPDomain{},
+			},
+			UpdateMode:                     0,
+			PreferDatabaseCredentials:      false,
+			SharedCredentialsPath:          "",
+			SharedCredentialsEnvPrefix:     "",
+			ExpiredUsersCheckInterval:      0,
+			DisableInactiveUsersAfter:      0,
+			TempCredentialsCleanupInterval: 10,
+			ConnectionHistoryRetention:     0,
+			AuditEventsRetention:           0,
+			Webhooks:                       []dataprovider.WebhookConfig{},
 		},
 		HTTPDConfig: httpd.Conf{
 			Bindings:           []httpd.Binding{defaultHTTPDBinding},
 			TemplatesPath:      "templates",
 			StaticFilesPath:    "static",
+			OpenAPIPath:        "openapi",
 			BackupsPath:        "backups",
 			CertificateFile:    "",
 			CertificateKeyFile: "",
+			OIDC: httpd.OIDC{
+				ClientID:        "",
+				ClientSecret:    "",
+				ConfigURL:       "",
+				RedirectBaseURL: "",
+				UsernameField:   "",
+				Scopes:          []string{"profile", "email"},
+			},
+			WebAuthn: httpd.WebAuthn{
+				RPID:          "",
+				RPOrigin:      "",
+				RPDisplayName: "",
+			},
+			Branding: httpd.Branding{
+				Path:        "",
+				Name:        "",
+				ShortName:   "",
+				FaviconPath: "",
+				LogoPath:    "",
+				ExtraCSS:    []string{},
+			},
+			ResumableUploads: httpd.ResumableUploads{
+				Path:       "",
+				MaxAge:     24,
+				MaxSize:    0,
+				MaxPerUser: 10,
+			},
 		},
 		HTTPConfig: httpclient.Config{
 			Timeout:        20,
@@ -230,8 +373,9 @@ func Init() {
 		},
 		KMSConfig: kms.Configuration{
 			Secrets: kms.Secrets{
-				URL:           "",
-				MasterKeyPath: "",
+				URL:             "",
+				MasterKeyString: "",
+				MasterKeyPath:   "",
 			},
 		},
 		TelemetryConfig: telemetry.Conf{
@@ -242,6 +386,44 @@ func Init() {
 			CertificateFile:    "",
 			CertificateKeyFile: "",
 			TLSCipherSuites:    nil,
+			HealthCheck: telemetry.HealthCheckConfig{
+				DataDir:            "",
+				MinFreeSpace:       0,
+				CheckCloudBackends: false,
+				CheckHooks:         false,
+				Timeout:            10,
+			},
+		},
+		SMTPConfig: smtp.Config{
+			Host:       "",
+			Port:       25,
+			From:       "",
+			User:       "",
+			Password:   "",
+			AuthType:   0,
+			Encryption: 0,
+			Domain:     "",
+		},
+		GeoIPConfig: geoip.Config{
+			DatabaseFile:     "",
+			AllowedCountries: nil,
+			DeniedCountries:  nil,
+		},
+		LogShipper: logshipper.Config{
+			Type:          "",
+			URL:           "",
+			Index:         "sftpgo",
+			Labels:        map[string]string{"job": "sftpgo"},
+			Username:      "",
+			Password:      "",
+			BatchSize:     500,
+			FlushInterval: 5,
+			QueueSize:     10000,
+		},
+		Notifications: notifier.Config{
+			Hook:            "",
+			EmailRecipients: nil,
+			Events:          nil,
 		},
 	}
 
@@ -334,6 +516,26 @@ func GetTelemetryConfig() telemetry.Conf {
 	return globalConf.TelemetryConfig
 }
 
+// GetSMTPConfig returns the SMTP configuration
+func GetSMTPConfig() smtp.Config {
+	return globalConf.SMTPConfig
+}
+
+// GetGeoIPConfig returns the GeoIP configuration
+func GetGeoIPConfig() geoip.Config {
+	return globalConf.GeoIPConfig
+}
+
+// GetLogShipperConfig returns the log shipper configuration
+func GetLogShipperConfig() logshipper.Config {
+	return globalConf.LogShipper
+}
+
+// GetNotifierConfig returns the service notifications configuration
+func GetNotifierConfig() notifier.Config {
+	return globalConf.Notifications
+}
+
 // SetTelemetryConfig sets the telemetry configuration
 func SetTelemetryConfig(config telemetry.Conf) {
 	globalConf.TelemetryConfig = config
@@ -357,6 +559,9 @@ func HasServicesToStart() bool {
 func getRedactedGlobalConf() globalConfig {
 	conf := globalConf
 	conf.ProviderConf.Password = "[redacted]"
+	if conf.KMSConfig.Secrets.MasterKeyString != "" {
+		conf.KMSConfig.Secrets.MasterKeyString = "[redacted]"
+	}
 	return conf
 }
 
@@ -370,6 +575,14 @@ func setConfigFile(configDir, configFile string) {
 	viper.SetConfigFile(configFile)
 }
 
+// ReloadConfig resets the configuration to the defaults and loads it again
+// from the given config dir and file. The services only apply the settings
+// that can be changed at runtime
+func ReloadConfig(configDir, configFile string) error {
+	Init()
+	return LoadConfig(configDir, configFile)
+}
+
 // LoadConfig loads the configuration
 // configDir will be added to the configuration search paths.
 // The search path contains by default the current directory and on linux it contains
@@ -393,14 +606,19 @@ func LoadConfig(configDir, configFile string) error {
 			logger.WarnToConsole("error loading configuration file: %v", err)
 		}
 	}
-	err = viper.Unmarshal(&globalConf)
+	mergeConfigOverlays(filepath.Join(configDir, overlayDirName))
+	err = viper.Unmarshal(&globalConf, viper.DecodeHook(getDecodeHook()))
 	if err != nil {
 		logger.Warn(logSender, "", "error parsing configuration file: %v", err)
 		logger.WarnToConsole("error parsing configuration file: %v", err)
 		return err
 	}
-	// viper only supports slice of strings from env vars, so we use our custom method
-	loadBindingsFromEnv()
+	// viper does not support lists of structs from env vars, so we use our custom method
+	if err = loadBindingsFromEnv(); err != nil {
+		logger.Warn(logSender, "", "error loading configuration from env vars: %v", err)
+		logger.WarnToConsole("error loading configuration from env vars: %v", err)
+		return err
+	}
 	if strings.TrimSpace(globalConf.SFTPD.Banner) == "" {
 		globalConf.SFTPD.Banner = defaultSFTPDBanner
 	}
@@ -443,6 +661,45 @@ func LoadConfig(configDir, configFile string) error {
 	return nil
 }
 
+// mergeConfigOverlays merges the configuration files inside the given directory,
+// in lexical order, over the main configuration. Files with an unsupported extension
+// are ignored
+func mergeConfigOverlays(dir string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn(logSender, "", "unable to read the configuration overlay dir %#v: %v", dir, err)
+			logger.WarnToConsole("unable to read the configuration overlay dir %#v: %v", dir, err)
+		}
+		return
+	}
+	// ReadDir returns the files sorted by name
+	for _, info := range files {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		ext := strings.TrimPrefix(filepath.Ext(info.Name()), ".")
+		if !utils.IsStringInSlice(strings.ToLower(ext), viper.SupportedExts) {
+			logger.Debug(logSender, "", "ignoring configuration overlay %#v: unsupported extension", info.Name())
+			continue
+		}
+		overlayPath := filepath.Join(dir, info.Name())
+		v := viper.New()
+		v.SetConfigFile(overlayPath)
+		if err := v.ReadInConfig(); err != nil {
+			logger.Warn(logSender, "", "error loading configuration overlay %#v: %v", overlayPath, err)
+			logger.WarnToConsole("error loading configuration overlay %#v: %v", overlayPath, err)
+			continue
+		}
+		if err := viper.MergeConfigMap(v.AllSettings()); err != nil {
+			logger.Warn(logSender, "", "error merging configuration overlay %#v: %v", overlayPath, err)
+			logger.WarnToConsole("error merging configuration overlay %#v: %v", overlayPath, err)
+			continue
+		}
+		logger.Debug(logSender, "", "configuration overlay %#v merged", overlayPath)
+	}
+}
+
 func checkSFTPDBindingsCompatibility() {
 	if globalConf.SFTPD.BindPort == 0 { //nolint:staticcheck
 		return
@@ -513,6 +770,7 @@ func checkHTTPDBindingCompatibility() {
 
 	binding := httpd.Binding{
 		EnableWebAdmin: globalConf.HTTPDConfig.StaticFilesPath != "" && globalConf.HTTPDConfig.TemplatesPath != "",
+		RenderOpenAPI:  globalConf.HTTPDConfig.OpenAPIPath != "",
 		EnableHTTPS:    globalConf.HTTPDConfig.CertificateFile != "" && globalConf.HTTPDConfig.CertificateKeyFile != "",
 	}
 
@@ -526,381 +784,15 @@ func checkHTTPDBindingCompatibility() {
 	globalConf.HTTPDConfig.Bindings = []httpd.Binding{binding}
 }
 
-func loadBindingsFromEnv() {
+func loadBindingsFromEnv() error {
 	checkSFTPDBindingsCompatibility()
 	checkFTPDBindingCompatibility()
 	checkWebDAVDBindingCompatibility()
 	checkHTTPDBindingCompatibility()
 
-	maxBindings := make([]int, 10)
-	for idx := range maxBindings {
-		getSFTPDBindindFromEnv(idx)
-		getFTPDBindingFromEnv(idx)
-		getWebDAVDBindingFromEnv(idx)
-		getHTTPDBindingFromEnv(idx)
-		getHTTPClientCertificatesFromEnv(idx)
-	}
-}
-
-func getSFTPDBindindFromEnv(idx int) {
-	binding := sftpd.Binding{}
-	if len(globalConf.SFTPD.Bindings) > idx {
-		binding = globalConf.SFTPD.Bindings[idx]
-	}
-
-	isSet := false
-
-	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__PORT", idx))
-	if ok {
-		binding.Port = port
-		isSet = true
-	}
-
-	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__ADDRESS", idx))
-	if ok {
-		binding.Address = address
-		isSet = true
-	}
-
-	applyProxyConfig, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__APPLY_PROXY_CONFIG", idx))
-	if ok {
-		binding.ApplyProxyConfig = applyProxyConfig
-		isSet = true
-	}
-
-	if isSet {
-		if len(globalConf.SFTPD.Bindings) > idx {
-			globalConf.SFTPD.Bindings[idx] = binding
-		} else {
-			globalConf.SFTPD.Bindings = append(globalConf.SFTPD.Bindings, binding)
-		}
-	}
-}
-
-func getFTPDBindingFromEnv(idx int) {
-	binding := ftpd.Binding{}
-	if len(globalConf.FTPD.Bindings) > idx {
-		binding = globalConf.FTPD.Bindings[idx]
-	}
-
-	isSet := false
-
-	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__PORT", idx))
-	if ok {
-		binding.Port = port
-		isSet = true
-	}
-
-	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__ADDRESS", idx))
-	if ok {
-		binding.Address = address
-		isSet = true
-	}
-
-	applyProxyConfig, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__APPLY_PROXY_CONFIG", idx))
-	if ok {
-		binding.ApplyProxyConfig = applyProxyConfig
-		isSet = true
-	}
-
-	tlsMode, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__TLS_MODE", idx))
-	if ok {
-		binding.TLSMode = tlsMode
-		isSet = true
-	}
-
-	passiveIP, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__FORCE_PASSIVE_IP", idx))
-	if ok {
-		binding.ForcePassiveIP = passiveIP
-		isSet = true
-	}
-
-	clientAuthType, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__CLIENT_AUTH_TYPE", idx))
-	if ok {
-		binding.ClientAuthType = clientAuthType
-		isSet = true
-	}
-
-	tlsCiphers, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__TLS_CIPHER_SUITES", idx))
-	if ok {
-		binding.TLSCipherSuites = tlsCiphers
-		isSet = true
-	}
-
-	if isSet {
-		if len(globalConf.FTPD.Bindings) > idx {
-			globalConf.FTPD.Bindings[idx] = binding
-		} else {
-			globalConf.FTPD.Bindings = append(globalConf.FTPD.Bindings, binding)
-		}
-	}
-}
-
-func getWebDAVDBindingFromEnv(idx int) {
-	binding := webdavd.Binding{}
-	if len(globalConf.WebDAVD.Bindings) > idx {
-		binding = globalConf.WebDAVD.Bindings[idx]
-	}
-
-	isSet := false
-
-	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__PORT", idx))
-	if ok {
-		binding.Port = port
-		isSet = true
-	}
-
-	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__ADDRESS", idx))
-	if ok {
-		binding.Address = address
-		isSet = true
-	}
-
-	enableHTTPS, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__ENABLE_HTTPS", idx))
-	if ok {
-		binding.EnableHTTPS = enableHTTPS
-		isSet = true
-	}
-
-	clientAuthType, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__CLIENT_AUTH_TYPE", idx))
-	if ok {
-		binding.ClientAuthType = clientAuthType
-		isSet = true
-	}
-
-	tlsCiphers, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__TLS_CIPHER_SUITES", idx))
-	if ok {
-		binding.TLSCipherSuites = tlsCiphers
-		isSet = true
-	}
-
-	if isSet {
-		if len(globalConf.WebDAVD.Bindings) > idx {
-			globalConf.WebDAVD.Bindings[idx] = binding
-		} else {
-			globalConf.WebDAVD.Bindings = append(globalConf.WebDAVD.Bindings, binding)
-		}
-	}
-}
-
-func getHTTPDBindingFromEnv(idx int) {
-	binding := httpd.Binding{}
-	if len(globalConf.HTTPDConfig.Bindings) > idx {
-		binding = globalConf.HTTPDConfig.Bindings[idx]
-	}
-
-	isSet := false
-
-	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__PORT", idx))
-	if ok {
-		binding.Port = port
-		isSet = true
-	}
-
-	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__ADDRESS", idx))
-	if ok {
-		binding.Address = address
-		isSet = true
-	}
-
-	enableWebAdmin, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__ENABLE_WEB_ADMIN", idx))
-	if ok {
-		binding.EnableWebAdmin = enableWebAdmin
-		isSet = true
-	}
-
-	enableHTTPS, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__ENABLE_HTTPS", idx))
-	if ok {
-		binding.EnableHTTPS = enableHTTPS
-		isSet = true
-	}
-
-	clientAuthType, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__CLIENT_AUTH_TYPE", idx))
-	if ok {
-		binding.ClientAuthType = clientAuthType
-		isSet = true
-	}
-
-	tlsCiphers, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__TLS_CIPHER_SUITES", idx))
-	if ok {
-		binding.TLSCipherSuites = tlsCiphers
-		isSet = true
-	}
-
-	if isSet {
-		if len(globalConf.HTTPDConfig.Bindings) > idx {
-			globalConf.HTTPDConfig.Bindings[idx] = binding
-		} else {
-			globalConf.HTTPDConfig.Bindings = append(globalConf.HTTPDConfig.Bindings, binding)
-		}
-	}
-}
-
-func getHTTPClientCertificatesFromEnv(idx int) {
-	tlsCert := httpclient.TLSKeyPair{}
-
-	cert, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTP__CERTIFICATES__%v__CERT", idx))
-	if ok {
-		tlsCert.Cert = cert
-	}
-
-	key, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTP__CERTIFICATES__%v__KEY", idx))
-	if ok {
-		tlsCert.Key = key
-	}
-
-	if tlsCert.Cert != "" && tlsCert.Key != "" {
-		if len(globalConf.HTTPConfig.Certificates) > idx {
-			globalConf.HTTPConfig.Certificates[idx] = tlsCert
-		} else {
-			globalConf.HTTPConfig.Certificates = append(globalConf.HTTPConfig.Certificates, tlsCert)
-		}
-	}
+	return loadListsFromEnv(reflect.ValueOf(&globalConf).Elem(), "")
 }
 
 func setViperDefaults() {
-	viper.SetDefault("common.idle_timeout", globalConf.Common.IdleTimeout)
-	viper.SetDefault("common.upload_mode", globalConf.Common.UploadMode)
-	viper.SetDefault("common.actions.execute_on", globalConf.Common.Actions.ExecuteOn)
-	viper.SetDefault("common.actions.hook", globalConf.Common.Actions.Hook)
-	viper.SetDefault("common.setstat_mode", globalConf.Common.SetstatMode)
-	viper.SetDefault("common.proxy_protocol", globalConf.Common.ProxyProtocol)
-	viper.SetDefault("common.proxy_allowed", globalConf.Common.ProxyAllowed)
-	viper.SetDefault("common.post_connect_hook", globalConf.Common.PostConnectHook)
-	viper.SetDefault("common.max_total_connections", globalConf.Common.MaxTotalConnections)
-	viper.SetDefault("common.defender.enabled", globalConf.Common.DefenderConfig.Enabled)
-	viper.SetDefault("common.defender.ban_time", globalConf.Common.DefenderConfig.BanTime)
-	viper.SetDefault("common.defender.ban_time_increment", globalConf.Common.DefenderConfig.BanTimeIncrement)
-	viper.SetDefault("common.defender.threshold", globalConf.Common.DefenderConfig.Threshold)
-	viper.SetDefault("common.defender.score_invalid", globalConf.Common.DefenderConfig.ScoreInvalid)
-	viper.SetDefault("common.defender.score_valid", globalConf.Common.DefenderConfig.ScoreValid)
-	viper.SetDefault("common.defender.observation_time", globalConf.Common.DefenderConfig.ObservationTime)
-	viper.SetDefault("common.defender.entries_soft_limit", globalConf.Common.DefenderConfig.EntriesSoftLimit)
-	viper.SetDefault("common.defender.entries_hard_limit", globalConf.Common.DefenderConfig.EntriesHardLimit)
-	viper.SetDefault("common.defender.safelist_file", globalConf.Common.DefenderConfig.SafeListFile)
-	viper.SetDefault("common.defender.blocklist_file", globalConf.Common.DefenderConfig.BlockListFile)
-	viper.SetDefault("sftpd.max_auth_tries", globalConf.SFTPD.MaxAuthTries)
-	viper.SetDefault("sftpd.banner", globalConf.SFTPD.Banner)
-	viper.SetDefault("sftpd.host_keys", globalConf.SFTPD.HostKeys)
-	viper.SetDefault("sftpd.kex_algorithms", globalConf.SFTPD.KexAlgorithms)
-	viper.SetDefault("sftpd.ciphers", globalConf.SFTPD.Ciphers)
-	viper.SetDefault("sftpd.macs", globalConf.SFTPD.MACs)
-	viper.SetDefault("sftpd.trusted_user_ca_keys", globalConf.SFTPD.TrustedUserCAKeys)
-	viper.SetDefault("sftpd.login_banner_file", globalConf.SFTPD.LoginBannerFile)
-	viper.SetDefault("sftpd.enabled_ssh_commands", globalConf.SFTPD.EnabledSSHCommands)
-	viper.SetDefault("sftpd.keyboard_interactive_auth_hook", globalConf.SFTPD.KeyboardInteractiveHook)
-	viper.SetDefault("sftpd.password_authentication", globalConf.SFTPD.PasswordAuthentication)
-	viper.SetDefault("ftpd.banner", globalConf.FTPD.Banner)
-	viper.SetDefault("ftpd.banner_file", globalConf.FTPD.BannerFile)
-	viper.SetDefault("ftpd.active_transfers_port_non_20", globalConf.FTPD.ActiveTransfersPortNon20)
-	viper.SetDefault("ftpd.passive_port_range.start", globalConf.FTPD.PassivePortRange.Start)
-	viper.SetDefault("ftpd.passive_port_range.end", globalConf.FTPD.PassivePortRange.End)
-	viper.SetDefault("ftpd.disable_active_mode", globalConf.FTPD.DisableActiveMode)
-	viper.SetDefault("ftpd.enable_site", globalConf.FTPD.EnableSite)
-	viper.SetDefault("ftpd.hash_support", globalConf.FTPD.HASHSupport)
-	viper.SetDefault("ftpd.combine_support", globalConf.FTPD.CombineSupport)
-	viper.SetDefault("ftpd.certificate_file", globalConf.FTPD.CertificateFile)
-	viper.SetDefault("ftpd.certificate_key_file", globalConf.FTPD.CertificateKeyFile)
-	viper.SetDefault("ftpd.ca_certificates", globalConf.FTPD.CACertificates)
-	viper.SetDefault("ftpd.ca_revocation_lists", globalConf.FTPD.CARevocationLists)
-	viper.SetDefault("webdavd.certificate_file", globalConf.WebDAVD.CertificateFile)
-	viper.SetDefault("webdavd.certificate_key_file", globalConf.WebDAVD.CertificateKeyFile)
-	viper.SetDefault("webdavd.ca_certificates", globalConf.WebDAVD.CACertificates)
-	viper.SetDefault("webdavd.ca_revocation_lists", globalConf.WebDAVD.CARevocationLists)
-	viper.SetDefault("webdavd.cors.enabled", globalConf.WebDAVD.Cors.Enabled)
-	viper.SetDefault("webdavd.cors.allowed_origins", globalConf.WebDAVD.Cors.AllowedOrigins)
-	viper.SetDefault("webdavd.cors.allowed_methods", globalConf.WebDAVD.Cors.AllowedMethods)
-	viper.SetDefault("webdavd.cors.allowed_headers", globalConf.WebDAVD.Cors.AllowedHeaders)
-	viper.SetDefault("webdavd.cors.exposed_headers", globalConf.WebDAVD.Cors.ExposedHeaders)
-	viper.SetDefault("webdavd.cors.allow_credentials", globalConf.WebDAVD.Cors.AllowCredentials)
-	viper.SetDefault("webdavd.cors.max_age", globalConf.WebDAVD.Cors.MaxAge)
-	viper.SetDefault("webdavd.cache.users.expiration_time", globalConf.WebDAVD.Cache.Users.ExpirationTime)
-	viper.SetDefault("webdavd.cache.users.max_size", globalConf.WebDAVD.Cache.Users.MaxSize)
-	viper.SetDefault("webdavd.cache.mime_types.enabled", globalConf.WebDAVD.Cache.MimeTypes.Enabled)
-	viper.SetDefault("webdavd.cache.mime_types.max_size", globalConf.WebDAVD.Cache.MimeTypes.MaxSize)
-	viper.SetDefault("data_provider.driver", globalConf.ProviderConf.Driver)
-	viper.SetDefault("data_provider.name", globalConf.ProviderConf.Name)
-	viper.SetDefault("data_provider.host", globalConf.ProviderConf.Host)
-	viper.SetDefault("data_provider.port", globalConf.ProviderConf.Port)
-	viper.SetDefault("data_provider.username", globalConf.ProviderConf.Username)
-	viper.SetDefault("data_provider.password", globalConf.ProviderConf.Password)
-	viper.SetDefault("data_provider.sslmode", globalConf.ProviderConf.SSLMode)
-	viper.SetDefault("data_provider.connection_string", globalConf.ProviderConf.ConnectionString)
-	viper.SetDefault("data_provider.sql_tables_prefix", globalConf.ProviderConf.SQLTablesPrefix)
-	viper.SetDefault("data_provider.track_quota", globalConf.ProviderConf.TrackQuota)
-	viper.SetDefault("data_provider.pool_size", globalConf.ProviderConf.PoolSize)
-	viper.SetDefault("data_provider.users_base_dir", globalConf.ProviderConf.UsersBaseDir)
-	viper.SetDefault("data_provider.actions.execute_on", globalConf.ProviderConf.Actions.ExecuteOn)
-	viper.SetDefault("data_provider.actions.hook", globalConf.ProviderConf.Actions.Hook)
-	viper.SetDefault("data_provider.external_auth_hook", globalConf.ProviderConf.ExternalAuthHook)
-	viper.SetDefault("data_provider.external_auth_scope", globalConf.ProviderConf.ExternalAuthScope)
-	viper.SetDefault("data_provider.credentials_path", globalConf.ProviderConf.CredentialsPath)
-	viper.SetDefault("data_provider.prefer_database_credentials", globalConf.ProviderConf.PreferDatabaseCredentials)
-	viper.SetDefault("data_provider.pre_login_hook", globalConf.ProviderConf.PreLoginHook)
-	viper.SetDefault("data_provider.post_login_hook", globalConf.ProviderConf.PostLoginHook)
-	viper.SetDefault("data_provider.post_login_scope", globalConf.ProviderConf.PostLoginScope)
-	viper.SetDefault("data_provider.check_password_hook", globalConf.ProviderConf.CheckPasswordHook)
-	viper.SetDefault("data_provider.check_password_scope", globalConf.ProviderConf.CheckPasswordScope)
-	viper.SetDefault("data_provider.password_hashing.argon2_options.memory", globalConf.ProviderConf.PasswordHashing.Argon2Options.Memory)
-	viper.SetDefault("data_provider.password_hashing.argon2_options.iterations", globalConf.ProviderConf.PasswordHashing.Argon2Options.Iterations)
-	viper.SetDefault("data_provider.password_hashing.argon2_options.parallelism", globalConf.ProviderConf.PasswordHashing.Argon2Options.Parallelism)
-	viper.SetDefault("data_provider.update_mode", globalConf.ProviderConf.UpdateMode)
-	viper.SetDefault("httpd.templates_path", globalConf.HTTPDConfig.TemplatesPath)
-	viper.SetDefault("httpd.static_files_path", globalConf.HTTPDConfig.StaticFilesPath)
-	viper.SetDefault("httpd.backups_path", globalConf.HTTPDConfig.BackupsPath)
-	viper.SetDefault("httpd.certificate_file", globalConf.HTTPDConfig.CertificateFile)
-	viper.SetDefault("httpd.certificate_key_file", globalConf.HTTPDConfig.CertificateKeyFile)
-	viper.SetDefault("httpd.ca_certificates", globalConf.HTTPDConfig.CACertificates)
-	viper.SetDefault("httpd.ca_revocation_lists", globalConf.HTTPDConfig.CARevocationLists)
-	viper.SetDefault("http.timeout", globalConf.HTTPConfig.Timeout)
-	viper.SetDefault("http.retry_wait_min", globalConf.HTTPConfig.RetryWaitMin)
-	viper.SetDefault("http.retry_wait_max", globalConf.HTTPConfig.RetryWaitMax)
-	viper.SetDefault("http.retry_max", globalConf.HTTPConfig.RetryMax)
-	viper.SetDefault("http.ca_certificates", globalConf.HTTPConfig.CACertificates)
-	viper.SetDefault("http.skip_tls_verify", globalConf.HTTPConfig.SkipTLSVerify)
-	viper.SetDefault("kms.secrets.url", globalConf.KMSConfig.Secrets.URL)
-	viper.SetDefault("kms.secrets.master_key_path", globalConf.KMSConfig.Secrets.MasterKeyPath)
-	viper.SetDefault("telemetry.bind_port", globalConf.TelemetryConfig.BindPort)
-	viper.SetDefault("telemetry.bind_address", globalConf.TelemetryConfig.BindAddress)
-	viper.SetDefault("telemetry.enable_profiler", globalConf.TelemetryConfig.EnableProfiler)
-	viper.SetDefault("telemetry.auth_user_file", globalConf.TelemetryConfig.AuthUserFile)
-	viper.SetDefault("telemetry.certificate_file", globalConf.TelemetryConfig.CertificateFile)
-	viper.SetDefault("telemetry.certificate_key_file", globalConf.TelemetryConfig.CertificateKeyFile)
-	viper.SetDefault("telemetry.tls_cipher_suites", globalConf.TelemetryConfig.TLSCipherSuites)
-}
-
-func lookupBoolFromEnv(envName string) (bool, bool) {
-	value, ok := os.LookupEnv(envName)
-	if ok {
-		converted, err := strconv.ParseBool(value)
-		if err == nil {
-			return converted, ok
-		}
-	}
-
-	return false, false
-}
-
-func lookupIntFromEnv(envName string) (int, bool) {
-	value, ok := os.LookupEnv(envName)
-	if ok {
-		converted, err := strconv.ParseInt(value, 10, 16)
-		if err == nil {
-			return int(converted), ok
-		}
-	}
-
-	return 0, false
-}
-
-func lookupStringListFromEnv(envName string) ([]string, bool) {
-	value, ok := os.LookupEnv(envName)
-	if ok {
-		var result []string
-		for _, v := range strings.Split(value, ",") {
-			result = append(result, strings.TrimSpace(v))
-		}
-		return result, true
-	}
-	return nil, false
+	setViperDefaultsFromStruct(reflect.ValueOf(globalConf), "")
 }
@@ -0,0 +1,267 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// maxEnvListItems defines the maximum number of list items, such as the bindings,
+// that can be defined using environment variables
+const maxEnvListItems = 10
+
+var (
+	// envListRequiredFields defines, for the lists of structs, the fields that must
+	// be not empty to add or replace an item using environment variables
+	envListRequiredFields = map[string][]string{
+		"http.certificates": {"cert", "key"},
+	}
+	stringMapType = reflect.TypeOf(map[string]string{})
+)
+
+// getConfigKey returns the configuration key for the given struct field
+func getConfigKey(field reflect.StructField) string {
+	if field.PkgPath != "" {
+		// unexported field
+		return ""
+	}
+	key := strings.Split(field.Tag.Get("mapstructure"), ",")[0]
+	if key == "-" {
+		return ""
+	}
+	if key == "" {
+		return strings.ToLower(field.Name)
+	}
+	return key
+}
+
+// getEnvName returns the environment variable name for the given configuration key,
+// for example "sftpd.bindings.0.port" is mapped to "SFTPGO_SFTPD__BINDINGS__0__PORT"
+func getEnvName(key string) string {
+	return strings.ToUpper(fmt.Sprintf("%v_%v", configEnvPrefix, strings.ReplaceAll(key, ".", "__")))
+}
+
+func isStructList(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct
+}
+
+// setViperDefaultsFromStruct registers a viper default for each configuration key
+// so it can be overridden using an environment variable. The lists of structs are
+// not supported by viper, they are loaded by loadListsFromEnv
+func setViperDefaultsFromStruct(value reflect.Value, prefix string) {
+	for i := 0; i < value.NumField(); i++ {
+		key := getConfigKey(value.Type().Field(i))
+		if key == "" {
+			continue
+		}
+		field := value.Field(i)
+		switch {
+		case field.Kind() == reflect.Struct:
+			setViperDefaultsFromStruct(field, prefix+key+".")
+		case isStructList(field.Type()):
+			continue
+		default:
+			viper.SetDefault(prefix+key, field.Interface())
+		}
+	}
+}
+
+// loadListsFromEnv loads the lists of structs, such as the bindings, from the
+// environment variables. An error is returned if an environment variable has an invalid value
+func loadListsFromEnv(value reflect.Value, prefix string) error {
+	for i := 0; i < value.NumField(); i++ {
+		key := getConfigKey(value.Type().Field(i))
+		if key == "" {
+			continue
+		}
+		field := value.Field(i)
+		switch {
+		case field.Kind() == reflect.Struct:
+			if err := loadListsFromEnv(field, prefix+key+"."); err != nil {
+				return err
+			}
+		case isStructList(field.Type()):
+			if _, err := loadListFromEnv(field, prefix+key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadListFromEnv replaces or appends the list items defined using environment
+// variables. It returns true if at least an item was set
+func loadListFromEnv(list reflect.Value, key string) (bool, error) {
+	isSet := false
+	for idx := 0; idx < maxEnvListItems; idx++ {
+		item := reflect.New(list.Type().Elem()).Elem()
+		if list.Len() > idx {
+			item.Set(list.Index(idx))
+		}
+		itemSet, err := loadStructFromEnv(item, fmt.Sprintf("%v.%v", key, idx))
+		if err != nil {
+			return isSet, err
+		}
+		if !itemSet {
+			continue
+		}
+		if !hasRequiredFields(item, envListRequiredFields[key]) {
+			continue
+		}
+		if list.Len() > idx {
+			list.Index(idx).Set(item)
+		} else {
+			list.Set(reflect.Append(list, item))
+		}
+		isSet = true
+	}
+	return isSet, nil
+}
+
+// loadStructFromEnv sets the struct fields defined using environment variables.
+// It returns true if at least a field was set
+func loadStructFromEnv(value reflect.Value, prefix string) (bool, error) {
+	isSet := false
+	for i := 0; i < value.NumField(); i++ {
+		key := getConfigKey(value.Type().Field(i))
+		if key == "" {
+			continue
+		}
+		key = prefix + "." + key
+		field := value.Field(i)
+		switch {
+		case field.Kind() == reflect.Struct:
+			fieldSet, err := loadStructFromEnv(field, key)
+			if err != nil {
+				return isSet, err
+			}
+			if fieldSet {
+				isSet = true
+			}
+		case isStructList(field.Type()):
+			fieldSet, err := loadListFromEnv(field, key)
+			if err != nil {
+				return isSet, err
+			}
+			if fieldSet {
+				isSet = true
+			}
+		default:
+			envName := getEnvName(key)
+			envValue, ok := os.LookupEnv(envName)
+			if !ok {
+				continue
+			}
+			if err := setFieldFromString(field, envValue); err != nil {
+				return isSet, fmt.Errorf("invalid value for env var %#v: %w", envName, err)
+			}
+			isSet = true
+		}
+	}
+	return isSet, nil
+}
+
+func hasRequiredFields(value reflect.Value, required []string) bool {
+	for _, name := range required {
+		for i := 0; i < value.NumField(); i++ {
+			if getConfigKey(value.Type().Field(i)) == name && value.Field(i).IsZero() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func setFieldFromString(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		converted, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(converted)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		converted, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(converted)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		converted, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(converted)
+	case reflect.Float32, reflect.Float64:
+		converted, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(converted)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %v", field.Type())
+		}
+		var result []string
+		for _, v := range strings.Split(value, ",") {
+			result = append(result, strings.TrimSpace(v))
+		}
+		field.Set(reflect.ValueOf(result))
+	case reflect.Map:
+		if field.Type() != stringMapType {
+			return fmt.Errorf("unsupported map type %v", field.Type())
+		}
+		result, err := parseStringMap(value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(result))
+	default:
+		return fmt.Errorf("unsupported type %v", field.Type())
+	}
+	return nil
+}
+
+// parseStringMap parses a string such as "key1=value1,key2=value2"
+func parseStringMap(value string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("invalid key=value pair %#v", pair)
+		}
+		result[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return result, nil
+}
+
+// stringToStringMapHookFunc allows to define a map[string]string using an
+// environment variable, the format is "key1=value1,key2=value2"
+func stringToStringMapHookFunc() mapstructure.DecodeHookFuncType {
+	return func(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+		if f.Kind() != reflect.String || t != stringMapType {
+			return data, nil
+		}
+		return parseStringMap(data.(string))
+	}
+}
+
+// getDecodeHook returns the viper default decode hooks and our custom ones
+func getDecodeHook() mapstructure.DecodeHookFunc {
+	return mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		stringToStringMapHookFunc(),
+	)
+}
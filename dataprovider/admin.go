@@ -1,6 +1,7 @@
 package dataprovider
 
 import (
+	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -8,7 +9,6 @@ import (
 	"regexp"
 	"strings"
 
-	"github.com/alexedwards/argon2id"
 	"github.com/minio/sha256-simd"
 
 	"github.com/drakkan/sftpgo/utils"
@@ -29,6 +29,10 @@ const (
 	PermAdminManageSystem     = "manage_system"
 	PermAdminManageDefender   = "manage_defender"
 	PermAdminViewDefender     = "view_defender"
+	PermAdminManageAPIKeys    = "manage_apikeys"
+	PermAdminRetentionChecks  = "retention_checks"
+	PermAdminViewEvents       = "view_events"
+	PermAdminBrowseFiles      = "browse_files"
 )
 
 var (
@@ -36,7 +40,8 @@ var (
 	validAdminPerms = []string{PermAdminAny, PermAdminAddUsers, PermAdminChangeUsers, PermAdminDeleteUsers,
 		PermAdminViewUsers, PermAdminViewConnections, PermAdminCloseConnections, PermAdminViewServerStatus,
 		PermAdminManageAdmins, PermAdminQuotaScans, PermAdminManageSystem, PermAdminManageDefender,
-		PermAdminViewDefender}
+		PermAdminViewDefender, PermAdminManageAPIKeys, PermAdminRetentionChecks,
+		PermAdminViewEvents, PermAdminBrowseFiles}
 )
 
 // AdminFilters defines additional restrictions for SFTPGo admins
@@ -45,6 +50,26 @@ type AdminFilters struct {
 	// IP/Mask must be in CIDR notation as defined in RFC 4632 and RFC 4291
 	// for example "192.0.2.0/24" or "2001:db8::/32"
 	AllowList []string `json:"allow_list,omitempty"`
+	// if not empty the admin can only view and manage users belonging
+	// to at least one of these groups
+	Groups []string `json:"groups,omitempty"`
+	// API key authentication allows to impersonate this administrator
+	// using an API key not bound to any admin
+	AllowAPIKeyAuth bool `json:"allow_api_key_auth,omitempty"`
+	// if not empty the admin can only authenticate over a TLS connection
+	// with a verified client certificate having one of these common names
+	TLSCertCommonNames []string `json:"tls_cert_common_names,omitempty"`
+	// time-based one time password configuration, if enabled a passcode is
+	// required in addition to the password
+	TOTPConfig TOTPConfig `json:"totp_config,omitempty"`
+	// recovery codes to use if the TOTP device is lost
+	RecoveryCodes []RecoveryCode `json:"recovery_codes,omitempty"`
+	// FIDO2/WebAuthn credentials, if any is registered it is required
+	// as second factor for web admin logins
+	WebAuthnCredentials []WebAuthnCredential `json:"webauthn_credentials,omitempty"`
+	// if enabled the admin can login to the web admin using a
+	// WebAuthn credential without providing the password
+	WebAuthnPasswordless bool `json:"webauthn_passwordless,omitempty"`
 }
 
 // Admin defines a SFTPGo admin
@@ -72,8 +97,8 @@ func (a *Admin) validate() error {
 	if !usernameRegex.MatchString(a.Username) {
 		return &ValidationError{err: fmt.Sprintf("username %#v is not valid, the following characters are allowed: a-zA-Z0-9-_.~", a.Username)}
 	}
-	if a.Password != "" && !strings.HasPrefix(a.Password, argonPwdPrefix) {
-		pwd, err := argon2id.CreateHash(a.Password, argon2Params)
+	if a.Password != "" && !isHashedWithSupportedAlgo(a.Password) {
+		pwd, err := hashPlainPassword(a.Password)
 		if err != nil {
 			return err
 		}
@@ -100,13 +125,25 @@ func (a *Admin) validate() error {
 			return &ValidationError{err: fmt.Sprintf("could not parse allow list entry %#v : %v", IPMask, err)}
 		}
 	}
+	groups, err := validateGroups(a.Filters.Groups)
+	if err != nil {
+		return err
+	}
+	a.Filters.Groups = groups
+	a.Filters.TLSCertCommonNames = removeEmptyAndDuplicates(a.Filters.TLSCertCommonNames)
 
-	return nil
+	if err := validateWebAuthnCredentials(a.Filters.WebAuthnCredentials); err != nil {
+		return err
+	}
+	if len(a.Filters.WebAuthnCredentials) == 0 {
+		a.Filters.WebAuthnPasswordless = false
+	}
+	return a.Filters.TOTPConfig.validate(a.Username, false)
 }
 
 // CheckPassword verifies the admin password
 func (a *Admin) CheckPassword(password string) (bool, error) {
-	return argon2id.ComparePasswordAndHash(password, a.Password)
+	return compareSupportedAlgoHash(password, a.Password)
 }
 
 // CanLoginFromIP returns true if login from the given IP is allowed
@@ -131,6 +168,30 @@ func (a *Admin) CanLoginFromIP(ip string) bool {
 	return false
 }
 
+// CanLoginWithCertificate returns true if the admin can login using a connection
+// authenticated with the given verified client certificate
+func (a *Admin) CanLoginWithCertificate(crt *x509.Certificate) bool {
+	if len(a.Filters.TLSCertCommonNames) == 0 {
+		return true
+	}
+	if crt == nil {
+		return false
+	}
+	return utils.IsStringInSlice(crt.Subject.CommonName, a.Filters.TLSCertCommonNames)
+}
+
+// removeEmptyAndDuplicates trims the given values and removes the empty and duplicated ones
+func removeEmptyAndDuplicates(values []string) []string {
+	var result []string
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value != "" && !utils.IsStringInSlice(value, result) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
 func (a *Admin) checkUserAndPass(password, ip string) error {
 	if a.Status != 1 {
 		return fmt.Errorf("admin %#v is disabled", a.Username)
@@ -154,6 +215,10 @@ func (a *Admin) checkUserAndPass(password, ip string) error {
 // HideConfidentialData hides admin confidential data
 func (a *Admin) HideConfidentialData() {
 	a.Password = ""
+	if a.Filters.TOTPConfig.Secret != nil {
+		a.Filters.TOTPConfig.Secret.Hide()
+	}
+	a.Filters.RecoveryCodes = nil
 }
 
 // HasPermission returns true if the admin has the specified permission
@@ -164,6 +229,14 @@ func (a *Admin) HasPermission(perm string) bool {
 	return utils.IsStringInSlice(perm, a.Permissions)
 }
 
+// CanManageUser returns true if the specified user is within the admin's groups scope
+func (a *Admin) CanManageUser(user *User) bool {
+	if len(a.Filters.Groups) == 0 {
+		return true
+	}
+	return user.IsInGroups(a.Filters.Groups)
+}
+
 // GetPermissionsAsString returns permission as string
 func (a *Admin) GetPermissionsAsString() string {
 	return strings.Join(a.Permissions, ", ")
@@ -174,6 +247,16 @@ func (a *Admin) GetAllowedIPAsString() string {
 	return strings.Join(a.Filters.AllowList, ",")
 }
 
+// GetGroupsAsString returns the admin groups as comma separated string
+func (a *Admin) GetGroupsAsString() string {
+	return strings.Join(a.Filters.Groups, ",")
+}
+
+// GetTLSCertCommonNamesAsString returns the allowed TLS certificate common names as comma separated string
+func (a *Admin) GetTLSCertCommonNamesAsString() string {
+	return strings.Join(a.Filters.TLSCertCommonNames, ",")
+}
+
 // GetValidPerms returns the allowed admin permissions
 func (a *Admin) GetValidPerms() []string {
 	return validAdminPerms
@@ -188,6 +271,12 @@ func (a *Admin) GetInfoString() string {
 	if len(a.Filters.AllowList) > 0 {
 		result += fmt.Sprintf("Allowed IP/Mask: %v. ", len(a.Filters.AllowList))
 	}
+	if len(a.Filters.Groups) > 0 {
+		result += fmt.Sprintf("Groups: %v. ", strings.Join(a.Filters.Groups, ","))
+	}
+	if len(a.Filters.TLSCertCommonNames) > 0 {
+		result += "TLS client certificate required. "
+	}
 	return result
 }
 
@@ -206,6 +295,19 @@ func (a *Admin) getACopy() Admin {
 	filters := AdminFilters{}
 	filters.AllowList = make([]string, len(a.Filters.AllowList))
 	copy(filters.AllowList, a.Filters.AllowList)
+	filters.Groups = make([]string, len(a.Filters.Groups))
+	copy(filters.Groups, a.Filters.Groups)
+	filters.AllowAPIKeyAuth = a.Filters.AllowAPIKeyAuth
+	filters.TLSCertCommonNames = make([]string, len(a.Filters.TLSCertCommonNames))
+	copy(filters.TLSCertCommonNames, a.Filters.TLSCertCommonNames)
+	filters.TOTPConfig = a.Filters.TOTPConfig.getACopy()
+	filters.RecoveryCodes = make([]RecoveryCode, len(a.Filters.RecoveryCodes))
+	copy(filters.RecoveryCodes, a.Filters.RecoveryCodes)
+	filters.WebAuthnCredentials = make([]WebAuthnCredential, 0, len(a.Filters.WebAuthnCredentials))
+	for idx := range a.Filters.WebAuthnCredentials {
+		filters.WebAuthnCredentials = append(filters.WebAuthnCredentials, a.Filters.WebAuthnCredentials[idx].getACopy())
+	}
+	filters.WebAuthnPasswordless = a.Filters.WebAuthnPasswordless
 
 	return Admin{
 		ID:             a.ID,
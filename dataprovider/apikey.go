@@ -0,0 +1,303 @@
+package dataprovider
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// APIKeyScope defines the supported API key scopes
+type APIKeyScope int
+
+// Supported API key scopes
+const (
+	// the API key will be used for an admin
+	APIKeyScopeAdmin APIKeyScope = iota + 1
+	// the API key will be used for a user
+	APIKeyScopeUser
+)
+
+// Supported API key permission classes. A class groups the admin permissions
+// needed for a specific task
+const (
+	APIKeyPermReadOnly = "read_only"
+	APIKeyPermUsers    = "users"
+	APIKeyPermDefender = "defender"
+	APIKeyPermQuota    = "quota"
+)
+
+var (
+	// admin permissions granted by each API key permission class
+	apiKeyPermClasses = map[string][]string{
+		APIKeyPermReadOnly: {PermAdminViewUsers, PermAdminViewConnections, PermAdminViewServerStatus,
+			PermAdminViewDefender, PermAdminViewEvents},
+		APIKeyPermUsers:    {PermAdminAddUsers, PermAdminChangeUsers, PermAdminDeleteUsers, PermAdminViewUsers},
+		APIKeyPermDefender: {PermAdminManageDefender, PermAdminViewDefender},
+		APIKeyPermQuota:    {PermAdminQuotaScans},
+	}
+	// ValidAPIKeyPermClasses defines all the valid API key permission classes
+	ValidAPIKeyPermClasses = []string{APIKeyPermReadOnly, APIKeyPermUsers, APIKeyPermDefender, APIKeyPermQuota}
+)
+
+// APIKeyRestrictions defines the optional restrictions for API keys with admin scope.
+// The token generated for a restricted key only has the permissions of the admin
+// included in the specified classes and it can only manage the specified users
+// and/or the users in the specified groups
+type APIKeyRestrictions struct {
+	// Permission classes, if empty all the admin permissions are allowed
+	Permissions []string `json:"permissions,omitempty"`
+	// Usernames the key is restricted to, if empty any user in the admin scope is allowed
+	Users []string `json:"users,omitempty"`
+	// Groups the key is restricted to. If the admin is restricted to some groups,
+	// only the groups in common are allowed
+	Groups []string `json:"groups,omitempty"`
+}
+
+// IsEmpty returns true if no restriction is defined
+func (r *APIKeyRestrictions) IsEmpty() bool {
+	return len(r.Permissions) == 0 && len(r.Users) == 0 && len(r.Groups) == 0
+}
+
+// GetPermissions returns the given admin permissions allowed by the restricted
+// permission classes
+func (r *APIKeyRestrictions) GetPermissions(adminPerms []string) []string {
+	if len(r.Permissions) == 0 {
+		return adminPerms
+	}
+	var result []string
+	for _, class := range r.Permissions {
+		for _, perm := range apiKeyPermClasses[class] {
+			if utils.IsStringInSlice(perm, result) {
+				continue
+			}
+			if utils.IsStringInSlice(PermAdminAny, adminPerms) || utils.IsStringInSlice(perm, adminPerms) {
+				result = append(result, perm)
+			}
+		}
+	}
+	return result
+}
+
+// GetGroups returns the groups allowed for an admin restricted to the given groups.
+// An error is returned if the admin and the restrictions have no group in common
+func (r *APIKeyRestrictions) GetGroups(adminGroups []string) ([]string, error) {
+	if len(r.Groups) == 0 {
+		return adminGroups, nil
+	}
+	if len(adminGroups) == 0 {
+		return r.Groups, nil
+	}
+	var result []string
+	for _, group := range r.Groups {
+		if utils.IsStringInSlice(group, adminGroups) {
+			result = append(result, group)
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("the restricted groups are not within the admin scope")
+	}
+	return result, nil
+}
+
+// GetPermissionsAsString returns the permission classes as comma separated string
+func (r *APIKeyRestrictions) GetPermissionsAsString() string {
+	return strings.Join(r.Permissions, ",")
+}
+
+// GetUsersAsString returns the restricted users as comma separated string
+func (r *APIKeyRestrictions) GetUsersAsString() string {
+	return strings.Join(r.Users, ",")
+}
+
+// GetGroupsAsString returns the restricted groups as comma separated string
+func (r *APIKeyRestrictions) GetGroupsAsString() string {
+	return strings.Join(r.Groups, ",")
+}
+
+// Validate validates and normalizes the restrictions
+func (r *APIKeyRestrictions) Validate() error {
+	var permissions []string
+	for _, class := range r.Permissions {
+		class = strings.TrimSpace(class)
+		if class == "" || utils.IsStringInSlice(class, permissions) {
+			continue
+		}
+		if !utils.IsStringInSlice(class, ValidAPIKeyPermClasses) {
+			return &ValidationError{err: fmt.Sprintf("invalid permission class: %#v", class)}
+		}
+		permissions = append(permissions, class)
+	}
+	r.Permissions = permissions
+	r.Users = removeEmptyAndDuplicates(r.Users)
+	groups, err := validateGroups(removeEmptyAndDuplicates(r.Groups))
+	if err != nil {
+		return err
+	}
+	r.Groups = groups
+	return nil
+}
+
+func (r *APIKeyRestrictions) getACopy() APIKeyRestrictions {
+	permissions := make([]string, len(r.Permissions))
+	copy(permissions, r.Permissions)
+	users := make([]string, len(r.Users))
+	copy(users, r.Users)
+	groups := make([]string, len(r.Groups))
+	copy(groups, r.Groups)
+
+	return APIKeyRestrictions{
+		Permissions: permissions,
+		Users:       users,
+		Groups:      groups,
+	}
+}
+
+// APIKey defines a SFTPGo API key.
+// API keys can be used as authentication alternative to short lived tokens
+// for REST API
+type APIKey struct {
+	// Database unique identifier
+	ID int64 `json:"-"`
+	// Unique key identifier, used for key lookups.
+	// The key returned to the client is in the format `Key.KeyID` so we can split
+	// and lookup by KeyID and then verify if the key matches the recorded hash
+	KeyID string `json:"id"`
+	// User friendly key name
+	Name string `json:"name"`
+	// we store the hash of the key, this is just like a password.
+	// The plain key is returned only after creation
+	Key       string      `json:"key,omitempty"`
+	Scope     APIKeyScope `json:"scope"`
+	CreatedAt int64       `json:"created_at"`
+	UpdatedAt int64       `json:"updated_at"`
+	// 0 means never used
+	LastUseAt int64 `json:"last_use_at"`
+	// 0 means never expire
+	ExpiresAt   int64  `json:"expires_at"`
+	Description string `json:"description,omitempty"`
+	// Username associated with this API key.
+	// If empty and the scope is APIKeyScopeUser the key is valid for any user
+	// allowed to use API keys, the username to impersonate must be specified
+	// in the key
+	User string `json:"user,omitempty"`
+	// Admin username associated with this API key.
+	// If empty and the scope is APIKeyScopeAdmin the key is valid for any admin
+	// allowed to use API keys, the username to impersonate must be specified
+	// in the key
+	Admin string `json:"admin,omitempty"`
+	// Optional restrictions, supported for API keys with admin scope
+	Restrictions APIKeyRestrictions `json:"restrictions,omitempty"`
+	// the plain key, available only after creation
+	plainKey string
+}
+
+func (k *APIKey) getACopy() APIKey {
+	return APIKey{
+		ID:           k.ID,
+		KeyID:        k.KeyID,
+		Name:         k.Name,
+		Key:          k.Key,
+		Scope:        k.Scope,
+		CreatedAt:    k.CreatedAt,
+		UpdatedAt:    k.UpdatedAt,
+		LastUseAt:    k.LastUseAt,
+		ExpiresAt:    k.ExpiresAt,
+		Description:  k.Description,
+		User:         k.User,
+		Admin:        k.Admin,
+		Restrictions: k.Restrictions.getACopy(),
+	}
+}
+
+// HideConfidentialData hides API key confidential data
+func (k *APIKey) HideConfidentialData() {
+	k.Key = ""
+}
+
+func (k *APIKey) hashKey() error {
+	if k.Key != "" && !isHashedWithSupportedAlgo(k.Key) {
+		hashed, err := hashPlainPassword(k.Key)
+		if err != nil {
+			return err
+		}
+		k.plainKey = k.Key
+		k.Key = hashed
+	}
+	return nil
+}
+
+func (k *APIKey) generateKey() {
+	if k.KeyID != "" || k.Key != "" {
+		return
+	}
+	k.KeyID = xid.New().String()
+	k.Key = base64.RawURLEncoding.EncodeToString(utils.GenerateRandomBytes(32))
+	k.plainKey = k.Key
+}
+
+// DisplayKey returns the key to show to the user, the key is available only
+// after its creation
+func (k *APIKey) DisplayKey() string {
+	return fmt.Sprintf("%v.%v", k.plainKey, k.KeyID)
+}
+
+func (k *APIKey) validate() error {
+	if k.Name == "" {
+		return &ValidationError{err: "name is mandatory"}
+	}
+	if k.Scope != APIKeyScopeAdmin && k.Scope != APIKeyScopeUser {
+		return &ValidationError{err: fmt.Sprintf("invalid scope: %v", k.Scope)}
+	}
+	k.generateKey()
+	if err := k.hashKey(); err != nil {
+		return err
+	}
+	if k.User != "" && k.Admin != "" {
+		return &ValidationError{err: "an API key can be related to a user or an admin, not both"}
+	}
+	if k.Scope == APIKeyScopeAdmin {
+		k.User = ""
+	}
+	if k.Scope == APIKeyScopeUser {
+		k.Admin = ""
+		k.Restrictions = APIKeyRestrictions{}
+	}
+	if err := k.Restrictions.Validate(); err != nil {
+		return err
+	}
+	if k.User != "" {
+		_, err := provider.userExists(k.User)
+		if err != nil {
+			return &ValidationError{err: fmt.Sprintf("unable to check API key user %v: %v", k.User, err)}
+		}
+	}
+	if k.Admin != "" {
+		_, err := provider.adminExists(k.Admin)
+		if err != nil {
+			return &ValidationError{err: fmt.Sprintf("unable to check API key admin %v: %v", k.Admin, err)}
+		}
+	}
+	return nil
+}
+
+// Authenticate tries to authenticate the provided plain key
+func (k *APIKey) Authenticate(plainKey string) error {
+	if k.ExpiresAt > 0 && k.ExpiresAt < utils.GetTimeAsMsSinceEpoch(time.Now()) {
+		return fmt.Errorf("API key %#v is expired, expiration timestamp: %v current timestamp: %v", k.KeyID,
+			k.ExpiresAt, utils.GetTimeAsMsSinceEpoch(time.Now()))
+	}
+	match, err := compareSupportedAlgoHash(plainKey, k.Key)
+	if err != nil {
+		return err
+	}
+	if !match {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
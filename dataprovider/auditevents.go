@@ -0,0 +1,167 @@
+package dataprovider
+
+import (
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// interval between two runs of the audit events cleanup
+const auditEventsCleanupInterval = 1 * time.Hour
+
+// Supported audit event actions
+const (
+	AuditActionLoginFailed  = "login_failed"
+	AuditActionHostBanned   = "host_banned"
+	AuditActionAdminRequest = "admin_request"
+	AuditActionAdd          = "add"
+	AuditActionUpdate       = "update"
+	AuditActionDelete       = "delete"
+)
+
+// Object types for the audit events related to provider changes and admin logins
+const (
+	AuditObjectUser   = "user"
+	AuditObjectFolder = "folder"
+	AuditObjectAdmin  = "admin"
+	AuditObjectAPIKey = "api_key"
+	AuditObjectShare  = "share"
+)
+
+var (
+	auditEventsTicker     *time.Ticker
+	auditEventsTickerDone chan bool
+)
+
+// AuditEvent defines a provider change or a security relevant event
+type AuditEvent struct {
+	ID int64 `json:"id"`
+	// event time as unix timestamp in milliseconds
+	Timestamp int64  `json:"timestamp"`
+	Action    string `json:"action"`
+	// the user or admin related to the event, if any. For admin requests
+	// this is the admin that executed the request
+	Username string `json:"username,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	// type and name of the affected object, if any
+	ObjectType string `json:"object_type,omitempty"`
+	ObjectName string `json:"object_name,omitempty"`
+	Details    string `json:"details,omitempty"`
+}
+
+// AuditEventsFilters defines the filters to search the audit events.
+// Empty or zero values are ignored
+type AuditEventsFilters struct {
+	Username   string
+	Action     string
+	ObjectType string
+	// only the events at or after this time, unix timestamp in milliseconds
+	TimestampFrom int64
+	// only the events at or before this time, unix timestamp in milliseconds
+	TimestampTo int64
+}
+
+func (f *AuditEventsFilters) match(event *AuditEvent) bool {
+	if f.Username != "" && event.Username != f.Username {
+		return false
+	}
+	if f.Action != "" && event.Action != f.Action {
+		return false
+	}
+	if f.ObjectType != "" && event.ObjectType != f.ObjectType {
+		return false
+	}
+	if f.TimestampFrom > 0 && event.Timestamp < f.TimestampFrom {
+		return false
+	}
+	if f.TimestampTo > 0 && event.Timestamp > f.TimestampTo {
+		return false
+	}
+	return true
+}
+
+// IsAuditEnabled returns true if the audit events must be stored
+func IsAuditEnabled() bool {
+	return config.AuditEventsRetention > 0
+}
+
+// AddAuditEvent stores the given event, if the audit is enabled.
+// The event time is set to the current time if not provided.
+// Errors are logged and ignored, the audit must never block the audited operation
+func AddAuditEvent(event AuditEvent) {
+	if !IsAuditEnabled() {
+		return
+	}
+	if event.Timestamp == 0 {
+		event.Timestamp = utils.GetTimeAsMsSinceEpoch(time.Now())
+	}
+	if err := provider.addAuditEvent(&event); err != nil {
+		providerLog(logger.LevelWarn, "unable to add audit event %+v: %v", event, err)
+	}
+}
+
+// SearchAuditEvents returns the stored events matching the given filters.
+// The events are ordered by insertion, that is by time
+func SearchAuditEvents(filters AuditEventsFilters, limit, offset int, order string) ([]AuditEvent, error) {
+	return provider.searchAuditEvents(filters, limit, offset, order)
+}
+
+func addProviderChangeEvent(action, objectType, objectName, username string) {
+	AddAuditEvent(AuditEvent{
+		Action:     action,
+		Username:   username,
+		ObjectType: objectType,
+		ObjectName: objectName,
+	})
+}
+
+func getAPIKeyOwner(apiKey *APIKey) string {
+	if apiKey.User != "" {
+		return apiKey.User
+	}
+	return apiKey.Admin
+}
+
+func addLoginFailedEvent(username, ip, protocol, objectType, details string) {
+	AddAuditEvent(AuditEvent{
+		Action:     AuditActionLoginFailed,
+		Username:   username,
+		IP:         ip,
+		Protocol:   protocol,
+		ObjectType: objectType,
+		ObjectName: username,
+		Details:    details,
+	})
+}
+
+func startAuditEventsCleanupTimer() {
+	if !IsAuditEnabled() {
+		return
+	}
+	auditEventsTicker = time.NewTicker(auditEventsCleanupInterval)
+	auditEventsTickerDone = make(chan bool)
+	providerLog(logger.LevelDebug, "start audit events cleanup, retention: %v days", config.AuditEventsRetention)
+	go func() {
+		removeExpiredAuditEvents()
+		for {
+			select {
+			case <-auditEventsTickerDone:
+				return
+			case <-auditEventsTicker.C:
+				removeExpiredAuditEvents()
+			}
+		}
+	}()
+}
+
+// removeExpiredAuditEvents deletes the events older than the configured retention
+func removeExpiredAuditEvents() {
+	before := utils.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Duration(config.AuditEventsRetention) * 24 * time.Hour))
+	if err := provider.cleanupAuditEvents(before); err != nil {
+		providerLog(logger.LevelWarn, "unable to remove expired audit events: %v", err)
+		return
+	}
+	providerLog(logger.LevelDebug, "audit events before %v removed", before)
+}
@@ -0,0 +1,122 @@
+package dataprovider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+func TestAuditEvents(t *testing.T) {
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+
+	// the audit is disabled
+	AddAuditEvent(AuditEvent{Action: AuditActionLoginFailed, Username: "audit_user"})
+	res, err := SearchAuditEvents(AuditEventsFilters{Username: "audit_user"}, 10, 0, OrderASC)
+	assert.NoError(t, err)
+	assert.Len(t, res, 0)
+
+	config.AuditEventsRetention = 1
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	events := []AuditEvent{
+		{
+			Timestamp:  now - 3*24*3600*1000,
+			Action:     AuditActionLoginFailed,
+			Username:   "audit_user1",
+			IP:         "127.0.0.1",
+			Protocol:   "SSH",
+			ObjectType: AuditObjectUser,
+			ObjectName: "audit_user1",
+			Details:    "login method password: invalid credentials",
+		},
+		{
+			Timestamp:  now - 1000,
+			Action:     AuditActionAdd,
+			Username:   "audit_user1",
+			ObjectType: AuditObjectShare,
+			ObjectName: "share_id",
+		},
+		{
+			Action: AuditActionHostBanned,
+			IP:     "127.0.0.2",
+		},
+	}
+	for _, event := range events {
+		AddAuditEvent(event)
+	}
+	res, err = SearchAuditEvents(AuditEventsFilters{TimestampFrom: now - 4*24*3600*1000}, 10, 0, OrderASC)
+	require.NoError(t, err)
+	require.Len(t, res, 3)
+	for idx := range res {
+		assert.Greater(t, res[idx].ID, int64(0))
+		assert.Equal(t, events[idx].Action, res[idx].Action)
+	}
+	assert.Equal(t, events[0], AuditEvent{
+		Timestamp:  res[0].Timestamp,
+		Action:     res[0].Action,
+		Username:   res[0].Username,
+		IP:         res[0].IP,
+		Protocol:   res[0].Protocol,
+		ObjectType: res[0].ObjectType,
+		ObjectName: res[0].ObjectName,
+		Details:    res[0].Details,
+	})
+	// the event time is set if missing
+	assert.GreaterOrEqual(t, res[2].Timestamp, now)
+	ids := []int64{res[0].ID, res[1].ID, res[2].ID}
+
+	filters := AuditEventsFilters{
+		Username:      "audit_user1",
+		TimestampFrom: now - 4*24*3600*1000,
+	}
+	res, err = SearchAuditEvents(filters, 10, 0, OrderDESC)
+	assert.NoError(t, err)
+	if assert.Len(t, res, 2) {
+		assert.Equal(t, ids[1], res[0].ID)
+		assert.Equal(t, ids[0], res[1].ID)
+	}
+	res, err = SearchAuditEvents(filters, 10, 1, OrderDESC)
+	assert.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, ids[0], res[0].ID)
+	}
+	filters.ObjectType = AuditObjectShare
+	res, err = SearchAuditEvents(filters, 10, 0, OrderASC)
+	assert.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, ids[1], res[0].ID)
+	}
+	res, err = SearchAuditEvents(AuditEventsFilters{Action: AuditActionHostBanned, TimestampFrom: now}, 10, 0, OrderASC)
+	assert.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, ids[2], res[0].ID)
+	}
+	filters = AuditEventsFilters{
+		TimestampFrom: now - 4*24*3600*1000,
+		TimestampTo:   now - 500,
+	}
+	res, err = SearchAuditEvents(filters, 1, 0, OrderASC)
+	assert.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, ids[0], res[0].ID)
+	}
+	// the first event is older than the retention
+	removeExpiredAuditEvents()
+	res, err = SearchAuditEvents(AuditEventsFilters{TimestampFrom: now - 4*24*3600*1000}, 10, 0, OrderASC)
+	assert.NoError(t, err)
+	if assert.Len(t, res, 2) {
+		assert.Equal(t, ids[1], res[0].ID)
+		assert.Equal(t, ids[2], res[1].ID)
+	}
+	err = provider.cleanupAuditEvents(utils.GetTimeAsMsSinceEpoch(time.Now()) + 1)
+	assert.NoError(t, err)
+	res, err = SearchAuditEvents(AuditEventsFilters{}, 10, 0, OrderASC)
+	assert.NoError(t, err)
+	assert.Len(t, res, 0)
+}
@@ -3,6 +3,7 @@
 package dataprovider
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -22,11 +23,16 @@ const (
 )
 
 var (
-	usersBucket     = []byte("users")
-	foldersBucket   = []byte("folders")
-	adminsBucket    = []byte("admins")
-	dbVersionBucket = []byte("db_version")
-	dbVersionKey    = []byte("version")
+	usersBucket       = []byte("users")
+	foldersBucket     = []byte("folders")
+	adminsBucket      = []byte("admins")
+	apiKeysBucket     = []byte("api_keys")
+	eventsQueueBucket = []byte("events_queue")
+	connHistoryBucket = []byte("connection_history")
+	sharesBucket      = []byte("shares")
+	auditEventsBucket = []byte("audit_events")
+	dbVersionBucket   = []byte("db_version")
+	dbVersionKey      = []byte("version")
 )
 
 // BoltProvider auth provider for bolt key/value store
@@ -82,6 +88,46 @@ func initializeBoltProvider(basePath string) error {
 			providerLog(logger.LevelWarn, "error creating admins bucket: %v", err)
 			return err
 		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(apiKeysBucket)
+			return e
+		})
+		if err != nil {
+			providerLog(logger.LevelWarn, "error creating api keys bucket: %v", err)
+			return err
+		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(eventsQueueBucket)
+			return e
+		})
+		if err != nil {
+			providerLog(logger.LevelWarn, "error creating events queue bucket: %v", err)
+			return err
+		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(connHistoryBucket)
+			return e
+		})
+		if err != nil {
+			providerLog(logger.LevelWarn, "error creating connection history bucket: %v", err)
+			return err
+		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(auditEventsBucket)
+			return e
+		})
+		if err != nil {
+			providerLog(logger.LevelWarn, "error creating audit events bucket: %v", err)
+			return err
+		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(sharesBucket)
+			return e
+		})
+		if err != nil {
+			providerLog(logger.LevelWarn, "error creating shares bucket: %v", err)
+			return err
+		}
 		err = dbHandle.Update(func(tx *bolt.Tx) error {
 			_, e := tx.CreateBucketIfNotExists(dbVersionBucket)
 			return e
@@ -138,7 +184,7 @@ func (p *BoltProvider) validateUserAndPubKey(username string, pubKey []byte) (Us
 	return checkUserAndPubKey(&user, pubKey)
 }
 
-func (p *BoltProvider) updateLastLogin(username string) error {
+func (p *BoltProvider) updateLastLogin(username, protocol string) error {
 	return p.dbHandle.Update(func(tx *bolt.Tx) error {
 		bucket, err := getUsersBucket(tx)
 		if err != nil {
@@ -154,6 +200,7 @@ func (p *BoltProvider) updateLastLogin(username string) error {
 			return err
 		}
 		user.LastLogin = utils.GetTimeAsMsSinceEpoch(time.Now())
+		user.LastLoginProtocol = protocol
 		buf, err := json.Marshal(user)
 		if err != nil {
 			return err
@@ -202,6 +249,50 @@ func (p *BoltProvider) updateQuota(username string, filesAdd int, sizeAdd int64,
 	})
 }
 
+func (p *BoltProvider) updateTransferQuota(username string, uploadSize, downloadSize, periodStart int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getUsersBucket(tx)
+		if err != nil {
+			return err
+		}
+		var u []byte
+		if u = bucket.Get([]byte(username)); u == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("username %#v does not exist, unable to update transfer quota",
+				username)}
+		}
+		var user User
+		err = json.Unmarshal(u, &user)
+		if err != nil {
+			return err
+		}
+		if user.DataTransferPeriodStart == periodStart {
+			user.UsedUploadDataTransfer += uploadSize
+			user.UsedDownloadDataTransfer += downloadSize
+		} else {
+			user.UsedUploadDataTransfer = uploadSize
+			user.UsedDownloadDataTransfer = downloadSize
+			user.DataTransferPeriodStart = periodStart
+		}
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put([]byte(username), buf)
+		providerLog(logger.LevelDebug, "transfer quota updated for user %#v, upload increment: %v download increment: %v",
+			username, uploadSize, downloadSize)
+		return err
+	})
+}
+
+func (p *BoltProvider) getUsedTransferQuota(username string) (int64, int64, int64, error) {
+	user, err := p.userExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get transfer quota for user %v error: %v", username, err)
+		return 0, 0, 0, err
+	}
+	return user.UsedUploadDataTransfer, user.UsedDownloadDataTransfer, user.DataTransferPeriodStart, err
+}
+
 func (p *BoltProvider) getUsedQuota(username string) (int, int64, error) {
 	user, err := p.userExists(username)
 	if err != nil {
@@ -235,143 +326,816 @@ func (p *BoltProvider) addAdmin(admin *Admin) error {
 		return err
 	}
 	return p.dbHandle.Update(func(tx *bolt.Tx) error {
-		bucket, err := getAdminBucket(tx)
+		bucket, err := getAdminBucket(tx)
+		if err != nil {
+			return err
+		}
+		if a := bucket.Get([]byte(admin.Username)); a != nil {
+			return fmt.Errorf("admin %v already exists", admin.Username)
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		admin.ID = int64(id)
+		buf, err := json.Marshal(admin)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(admin.Username), buf)
+	})
+}
+
+func (p *BoltProvider) updateAdmin(admin *Admin) error {
+	err := admin.validate()
+	if err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getAdminBucket(tx)
+		if err != nil {
+			return err
+		}
+		var a []byte
+
+		if a = bucket.Get([]byte(admin.Username)); a == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("admin %v does not exist", admin.Username)}
+		}
+		var oldAdmin Admin
+		err = json.Unmarshal(a, &oldAdmin)
+		if err != nil {
+			return err
+		}
+
+		admin.ID = oldAdmin.ID
+		buf, err := json.Marshal(admin)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(admin.Username), buf)
+	})
+}
+
+func (p *BoltProvider) deleteAdmin(admin *Admin) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getAdminBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		if bucket.Get([]byte(admin.Username)) == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("admin %v does not exist", admin.Username)}
+		}
+
+		if err := deleteRelatedAPIKey(tx, admin.Username, APIKeyScopeAdmin); err != nil {
+			return err
+		}
+
+		return bucket.Delete([]byte(admin.Username))
+	})
+}
+
+func (p *BoltProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
+	admins := make([]Admin, 0, limit)
+
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getAdminBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		itNum := 0
+		if order == OrderASC {
+			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var admin Admin
+				err = json.Unmarshal(v, &admin)
+				if err != nil {
+					return err
+				}
+				admin.HideConfidentialData()
+				admins = append(admins, admin)
+				if len(admins) >= limit {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var admin Admin
+				err = json.Unmarshal(v, &admin)
+				if err != nil {
+					return err
+				}
+				admin.HideConfidentialData()
+				admins = append(admins, admin)
+				if len(admins) >= limit {
+					break
+				}
+			}
+		}
+		return err
+	})
+
+	return admins, err
+}
+
+func (p *BoltProvider) dumpAdmins() ([]Admin, error) {
+	admins := make([]Admin, 0, 30)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getAdminBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var admin Admin
+			err = json.Unmarshal(v, &admin)
+			if err != nil {
+				return err
+			}
+			admins = append(admins, admin)
+		}
+		return err
+	})
+
+	return admins, err
+}
+
+func (p *BoltProvider) updateSecrets(users []User, admins []Admin) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		usersBucket, err := getUsersBucket(tx)
+		if err != nil {
+			return err
+		}
+		adminsBucket, err := getAdminBucket(tx)
+		if err != nil {
+			return err
+		}
+		for idx := range users {
+			var u []byte
+			if u = usersBucket.Get([]byte(users[idx].Username)); u == nil {
+				return &RecordNotFoundError{err: fmt.Sprintf("username %v does not exist", users[idx].Username)}
+			}
+			var user User
+			if err := json.Unmarshal(u, &user); err != nil {
+				return err
+			}
+			user.Filters = users[idx].Filters
+			user.FsConfig = users[idx].FsConfig
+			buf, err := json.Marshal(user)
+			if err != nil {
+				return err
+			}
+			if err := usersBucket.Put([]byte(user.Username), buf); err != nil {
+				return err
+			}
+		}
+		for idx := range admins {
+			var a []byte
+			if a = adminsBucket.Get([]byte(admins[idx].Username)); a == nil {
+				return &RecordNotFoundError{err: fmt.Sprintf("admin %v does not exist", admins[idx].Username)}
+			}
+			var admin Admin
+			if err := json.Unmarshal(a, &admin); err != nil {
+				return err
+			}
+			admin.Filters = admins[idx].Filters
+			buf, err := json.Marshal(admin)
+			if err != nil {
+				return err
+			}
+			if err := adminsBucket.Put([]byte(admin.Username), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *BoltProvider) apiKeyExists(keyID string) (APIKey, error) {
+	var apiKey APIKey
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getAPIKeysBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		k := bucket.Get([]byte(keyID))
+		if k == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("API key %v does not exist", keyID)}
+		}
+		return json.Unmarshal(k, &apiKey)
+	})
+	return apiKey, err
+}
+
+func (p *BoltProvider) addAPIKey(apiKey *APIKey) error {
+	err := apiKey.validate()
+	if err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getAPIKeysBucket(tx)
+		if err != nil {
+			return err
+		}
+		if a := bucket.Get([]byte(apiKey.KeyID)); a != nil {
+			return fmt.Errorf("API key %v already exists", apiKey.KeyID)
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		apiKey.ID = int64(id)
+		apiKey.CreatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+		apiKey.UpdatedAt = apiKey.CreatedAt
+		apiKey.LastUseAt = 0
+		buf, err := json.Marshal(apiKey)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(apiKey.KeyID), buf)
+	})
+}
+
+func (p *BoltProvider) updateAPIKey(apiKey *APIKey) error {
+	err := apiKey.validate()
+	if err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getAPIKeysBucket(tx)
+		if err != nil {
+			return err
+		}
+		var a []byte
+
+		if a = bucket.Get([]byte(apiKey.KeyID)); a == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("API key %v does not exist", apiKey.KeyID)}
+		}
+		var oldAPIKey APIKey
+		err = json.Unmarshal(a, &oldAPIKey)
+		if err != nil {
+			return err
+		}
+
+		apiKey.ID = oldAPIKey.ID
+		apiKey.Key = oldAPIKey.Key
+		apiKey.CreatedAt = oldAPIKey.CreatedAt
+		apiKey.LastUseAt = oldAPIKey.LastUseAt
+		apiKey.UpdatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(apiKey)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(apiKey.KeyID), buf)
+	})
+}
+
+func (p *BoltProvider) deleteAPIKey(apiKey *APIKey) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getAPIKeysBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		if bucket.Get([]byte(apiKey.KeyID)) == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("API key %v does not exist", apiKey.KeyID)}
+		}
+
+		return bucket.Delete([]byte(apiKey.KeyID))
+	})
+}
+
+func (p *BoltProvider) getAPIKeys(limit int, offset int, order string) ([]APIKey, error) {
+	apiKeys := make([]APIKey, 0, limit)
+
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getAPIKeysBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		itNum := 0
+		if order == OrderASC {
+			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var apiKey APIKey
+				err = json.Unmarshal(v, &apiKey)
+				if err != nil {
+					return err
+				}
+				apiKey.HideConfidentialData()
+				apiKeys = append(apiKeys, apiKey)
+				if len(apiKeys) >= limit {
+					break
+				}
+			}
+		} else {
+			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+				var apiKey APIKey
+				err = json.Unmarshal(v, &apiKey)
+				if err != nil {
+					return err
+				}
+				apiKey.HideConfidentialData()
+				apiKeys = append(apiKeys, apiKey)
+				if len(apiKeys) >= limit {
+					break
+				}
+			}
+		}
+		return err
+	})
+
+	return apiKeys, err
+}
+
+func (p *BoltProvider) dumpAPIKeys() ([]APIKey, error) {
+	apiKeys := make([]APIKey, 0, 30)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getAPIKeysBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var apiKey APIKey
+			err = json.Unmarshal(v, &apiKey)
+			if err != nil {
+				return err
+			}
+			apiKeys = append(apiKeys, apiKey)
+		}
+		return err
+	})
+
+	return apiKeys, err
+}
+
+func (p *BoltProvider) updateAPIKeyLastUse(keyID string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getAPIKeysBucket(tx)
+		if err != nil {
+			return err
+		}
+		var u []byte
+		if u = bucket.Get([]byte(keyID)); u == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("API key %#v does not exist, unable to update last use", keyID)}
+		}
+		var apiKey APIKey
+		err = json.Unmarshal(u, &apiKey)
+		if err != nil {
+			return err
+		}
+		apiKey.LastUseAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(apiKey)
+		if err != nil {
+			return err
+		}
+		err = bucket.Put([]byte(keyID), buf)
+		if err != nil {
+			providerLog(logger.LevelWarn, "error updating last use for key %#v: %v", keyID, err)
+			return err
+		}
+		providerLog(logger.LevelDebug, "last use updated for key %#v", keyID)
+		return nil
+	})
+}
+
+func (p *BoltProvider) shareExists(shareID string) (Share, error) {
+	var share Share
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getSharesBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		s := bucket.Get([]byte(shareID))
+		if s == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("share %v does not exist", shareID)}
+		}
+		return json.Unmarshal(s, &share)
+	})
+	return share, err
+}
+
+func (p *BoltProvider) addShare(share *Share) error {
+	err := share.validate()
+	if err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getSharesBucket(tx)
+		if err != nil {
+			return err
+		}
+		if s := bucket.Get([]byte(share.ShareID)); s != nil {
+			return fmt.Errorf("share %v already exists", share.ShareID)
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		share.ID = int64(id)
+		share.CreatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+		share.UpdatedAt = share.CreatedAt
+		share.LastUseAt = 0
+		share.UsedTokens = 0
+		buf, err := json.Marshal(share)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(share.ShareID), buf)
+	})
+}
+
+func (p *BoltProvider) updateShare(share *Share) error {
+	err := share.validate()
+	if err != nil {
+		return err
+	}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getSharesBucket(tx)
+		if err != nil {
+			return err
+		}
+		var s []byte
+
+		if s = bucket.Get([]byte(share.ShareID)); s == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("share %v does not exist", share.ShareID)}
+		}
+		var oldShare Share
+		err = json.Unmarshal(s, &oldShare)
+		if err != nil {
+			return err
+		}
+
+		share.ID = oldShare.ID
+		share.CreatedAt = oldShare.CreatedAt
+		share.LastUseAt = oldShare.LastUseAt
+		share.UsedTokens = oldShare.UsedTokens
+		share.UpdatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(share)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(share.ShareID), buf)
+	})
+}
+
+func (p *BoltProvider) deleteShare(share *Share) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getSharesBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		if bucket.Get([]byte(share.ShareID)) == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("share %v does not exist", share.ShareID)}
+		}
+
+		return bucket.Delete([]byte(share.ShareID))
+	})
+}
+
+func (p *BoltProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
+	shares := make([]Share, 0, limit)
+
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getSharesBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		first, next := cursor.First, cursor.Next
+		if order == OrderDESC {
+			first, next = cursor.Last, cursor.Prev
+		}
+		itNum := 0
+		for k, v := first(); k != nil && len(shares) < limit; k, v = next() {
+			var share Share
+			err = json.Unmarshal(v, &share)
+			if err != nil {
+				return err
+			}
+			if username != "" && share.Username != username {
+				continue
+			}
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			share.HideConfidentialData()
+			shares = append(shares, share)
+		}
+		return nil
+	})
+
+	return shares, err
+}
+
+func (p *BoltProvider) dumpShares() ([]Share, error) {
+	shares := make([]Share, 0, 30)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getSharesBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var share Share
+			err = json.Unmarshal(v, &share)
+			if err != nil {
+				return err
+			}
+			shares = append(shares, share)
+		}
+		return err
+	})
+
+	return shares, err
+}
+
+func (p *BoltProvider) updateShareLastUse(shareID string, numTokens int) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getSharesBucket(tx)
+		if err != nil {
+			return err
+		}
+		var s []byte
+		if s = bucket.Get([]byte(shareID)); s == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("share %#v does not exist, unable to update last use", shareID)}
+		}
+		var share Share
+		err = json.Unmarshal(s, &share)
+		if err != nil {
+			return err
+		}
+		if !share.hasTokens(numTokens) {
+			return ErrShareUsedUp
+		}
+		share.LastUseAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+		share.UsedTokens += numTokens
+		buf, err := json.Marshal(share)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(shareID), buf)
+	})
+}
+
+func (p *BoltProvider) addQueuedEvent(event *QueuedEvent) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getEventsQueueBucket(tx)
+		if err != nil {
+			return err
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		event.ID = int64(id)
+		buf, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(getSequenceKey(event.ID), buf)
+	})
+}
+
+func (p *BoltProvider) getQueuedEvents(limit int, before int64) ([]QueuedEvent, error) {
+	events := make([]QueuedEvent, 0, limit)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getEventsQueueBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil && len(events) < limit; k, v = cursor.Next() {
+			var event QueuedEvent
+			err = json.Unmarshal(v, &event)
+			if err != nil {
+				return err
+			}
+			if event.NextAttemptAt <= before {
+				events = append(events, event)
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (p *BoltProvider) updateQueuedEvent(event *QueuedEvent) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getEventsQueueBucket(tx)
+		if err != nil {
+			return err
+		}
+		key := getSequenceKey(event.ID)
+		var e []byte
+		if e = bucket.Get(key); e == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("queued event %v does not exist", event.ID)}
+		}
+		var oldEvent QueuedEvent
+		err = json.Unmarshal(e, &oldEvent)
+		if err != nil {
+			return err
+		}
+		oldEvent.Attempts = event.Attempts
+		oldEvent.NextAttemptAt = event.NextAttemptAt
+		buf, err := json.Marshal(oldEvent)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, buf)
+	})
+}
+
+func (p *BoltProvider) deleteQueuedEvent(id int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getEventsQueueBucket(tx)
+		if err != nil {
+			return err
+		}
+		return bucket.Delete(getSequenceKey(id))
+	})
+}
+
+func (p *BoltProvider) addConnectionRecord(record *ConnectionRecord) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getConnectionHistoryBucket(tx)
 		if err != nil {
 			return err
 		}
-		if a := bucket.Get([]byte(admin.Username)); a != nil {
-			return fmt.Errorf("admin %v already exists", admin.Username)
-		}
 		id, err := bucket.NextSequence()
 		if err != nil {
 			return err
 		}
-		admin.ID = int64(id)
-		buf, err := json.Marshal(admin)
+		record.ID = int64(id)
+		buf, err := json.Marshal(record)
 		if err != nil {
 			return err
 		}
-		return bucket.Put([]byte(admin.Username), buf)
+		return bucket.Put(getSequenceKey(record.ID), buf)
 	})
 }
 
-func (p *BoltProvider) updateAdmin(admin *Admin) error {
-	err := admin.validate()
-	if err != nil {
-		return err
-	}
-	return p.dbHandle.Update(func(tx *bolt.Tx) error {
-		bucket, err := getAdminBucket(tx)
+func (p *BoltProvider) searchConnectionRecords(filters ConnectionHistoryFilters, limit, offset int, order string) ([]ConnectionRecord, error) {
+	records := make([]ConnectionRecord, 0, limit)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getConnectionHistoryBucket(tx)
 		if err != nil {
 			return err
 		}
-		var a []byte
-
-		if a = bucket.Get([]byte(admin.Username)); a == nil {
-			return &RecordNotFoundError{err: fmt.Sprintf("admin %v does not exist", admin.Username)}
+		itNum := 0
+		cursor := bucket.Cursor()
+		first, next := cursor.First, cursor.Next
+		if order == OrderDESC {
+			first, next = cursor.Last, cursor.Prev
 		}
-		var oldAdmin Admin
-		err = json.Unmarshal(a, &oldAdmin)
-		if err != nil {
-			return err
+		for k, v := first(); k != nil && len(records) < limit; k, v = next() {
+			var record ConnectionRecord
+			err = json.Unmarshal(v, &record)
+			if err != nil {
+				return err
+			}
+			if !filters.match(&record) {
+				continue
+			}
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			records = append(records, record)
 		}
+		return nil
+	})
+	return records, err
+}
 
-		admin.ID = oldAdmin.ID
-		buf, err := json.Marshal(admin)
+func (p *BoltProvider) cleanupConnectionRecords(before int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getConnectionHistoryBucket(tx)
 		if err != nil {
 			return err
 		}
-		return bucket.Put([]byte(admin.Username), buf)
+		var toRemove [][]byte
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var record ConnectionRecord
+			err = json.Unmarshal(v, &record)
+			if err != nil {
+				return err
+			}
+			if record.EndTime < before {
+				toRemove = append(toRemove, k)
+			}
+		}
+		for _, k := range toRemove {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 }
 
-func (p *BoltProvider) deleteAdmin(admin *Admin) error {
+func (p *BoltProvider) addAuditEvent(event *AuditEvent) error {
 	return p.dbHandle.Update(func(tx *bolt.Tx) error {
-		bucket, err := getAdminBucket(tx)
+		bucket, err := getAuditEventsBucket(tx)
 		if err != nil {
 			return err
 		}
-
-		if bucket.Get([]byte(admin.Username)) == nil {
-			return &RecordNotFoundError{err: fmt.Sprintf("admin %v does not exist", admin.Username)}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
 		}
-
-		return bucket.Delete([]byte(admin.Username))
+		event.ID = int64(id)
+		buf, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(getSequenceKey(event.ID), buf)
 	})
 }
 
-func (p *BoltProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
-	admins := make([]Admin, 0, limit)
-
+func (p *BoltProvider) searchAuditEvents(filters AuditEventsFilters, limit, offset int, order string) ([]AuditEvent, error) {
+	events := make([]AuditEvent, 0, limit)
 	err := p.dbHandle.View(func(tx *bolt.Tx) error {
-		bucket, err := getAdminBucket(tx)
+		bucket, err := getAuditEventsBucket(tx)
 		if err != nil {
 			return err
 		}
-		cursor := bucket.Cursor()
 		itNum := 0
-		if order == OrderASC {
-			for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-				itNum++
-				if itNum <= offset {
-					continue
-				}
-				var admin Admin
-				err = json.Unmarshal(v, &admin)
-				if err != nil {
-					return err
-				}
-				admin.HideConfidentialData()
-				admins = append(admins, admin)
-				if len(admins) >= limit {
-					break
-				}
+		cursor := bucket.Cursor()
+		first, next := cursor.First, cursor.Next
+		if order == OrderDESC {
+			first, next = cursor.Last, cursor.Prev
+		}
+		for k, v := first(); k != nil && len(events) < limit; k, v = next() {
+			var event AuditEvent
+			err = json.Unmarshal(v, &event)
+			if err != nil {
+				return err
 			}
-		} else {
-			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
-				itNum++
-				if itNum <= offset {
-					continue
-				}
-				var admin Admin
-				err = json.Unmarshal(v, &admin)
-				if err != nil {
-					return err
-				}
-				admin.HideConfidentialData()
-				admins = append(admins, admin)
-				if len(admins) >= limit {
-					break
-				}
+			if !filters.match(&event) {
+				continue
+			}
+			itNum++
+			if itNum <= offset {
+				continue
 			}
+			events = append(events, event)
 		}
-		return err
+		return nil
 	})
-
-	return admins, err
+	return events, err
 }
 
-func (p *BoltProvider) dumpAdmins() ([]Admin, error) {
-	admins := make([]Admin, 0, 30)
-	err := p.dbHandle.View(func(tx *bolt.Tx) error {
-		bucket, err := getAdminBucket(tx)
+func (p *BoltProvider) cleanupAuditEvents(before int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getAuditEventsBucket(tx)
 		if err != nil {
 			return err
 		}
-
+		var toRemove [][]byte
 		cursor := bucket.Cursor()
 		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-			var admin Admin
-			err = json.Unmarshal(v, &admin)
+			var event AuditEvent
+			err = json.Unmarshal(v, &event)
 			if err != nil {
 				return err
 			}
-			admins = append(admins, admin)
+			if event.Timestamp < before {
+				toRemove = append(toRemove, k)
+			}
 		}
-		return err
+		for _, k := range toRemove {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
-
-	return admins, err
 }
 
 func (p *BoltProvider) userExists(username string) (User, error) {
@@ -421,6 +1185,10 @@ func (p *BoltProvider) addUser(user *User) error {
 		user.UsedQuotaSize = 0
 		user.UsedQuotaFiles = 0
 		user.LastLogin = 0
+		user.LastLoginProtocol = ""
+		user.UsedUploadDataTransfer = 0
+		user.UsedDownloadDataTransfer = 0
+		user.DataTransferPeriodStart = 0
 		for _, folder := range user.VirtualFolders {
 			err = addUserToFolderMapping(folder, user, folderBucket)
 			if err != nil {
@@ -475,6 +1243,10 @@ func (p *BoltProvider) updateUser(user *User) error {
 		user.UsedQuotaSize = oldUser.UsedQuotaSize
 		user.UsedQuotaFiles = oldUser.UsedQuotaFiles
 		user.LastLogin = oldUser.LastLogin
+		user.LastLoginProtocol = oldUser.LastLoginProtocol
+		user.UsedUploadDataTransfer = oldUser.UsedUploadDataTransfer
+		user.UsedDownloadDataTransfer = oldUser.UsedDownloadDataTransfer
+		user.DataTransferPeriodStart = oldUser.DataTransferPeriodStart
 		buf, err := json.Marshal(user)
 		if err != nil {
 			return err
@@ -505,6 +1277,12 @@ func (p *BoltProvider) deleteUser(user *User) error {
 		if exists == nil {
 			return &RecordNotFoundError{err: fmt.Sprintf("user %#v does not exist", user.Username)}
 		}
+		if err := deleteRelatedAPIKey(tx, user.Username, APIKeyScopeUser); err != nil {
+			return err
+		}
+		if err := deleteRelatedShares(tx, user.Username); err != nil {
+			return err
+		}
 		return bucket.Delete([]byte(user.Username))
 	})
 }
@@ -590,6 +1368,54 @@ func (p *BoltProvider) getUsers(limit int, offset int, order string) ([]User, er
 	return users, err
 }
 
+func (p *BoltProvider) searchUsers(filters UserSearchFilters, limit, offset int, order string) ([]User, error) {
+	users := make([]User, 0, limit)
+	if limit <= 0 {
+		return users, nil
+	}
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getUsersBucket(tx)
+		if err != nil {
+			return err
+		}
+		folderBucket, err := getFolderBucket(tx)
+		if err != nil {
+			return err
+		}
+		// the users are stored sorted by username, if the users must be sorted by
+		// another field we need to load all the matching users
+		sortedByUsername := filters.isSortedByUsername()
+		itNum := 0
+		cursor := bucket.Cursor()
+		first, next := cursor.First, cursor.Next
+		if order == OrderDESC {
+			first, next = cursor.Last, cursor.Prev
+		}
+		for k, v := first(); k != nil && (!sortedByUsername || len(users) < limit); k, v = next() {
+			user, err := joinUserAndFolders(v, folderBucket)
+			if err != nil {
+				return err
+			}
+			if !filters.match(&user) {
+				continue
+			}
+			if sortedByUsername {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+			}
+			user.HideConfidentialData()
+			users = append(users, user)
+		}
+		if !sortedByUsername {
+			users = sortAndPaginateUsers(users, &filters, limit, offset, order)
+		}
+		return nil
+	})
+	return users, err
+}
+
 func (p *BoltProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 	folders := make([]vfs.BaseVirtualFolder, 0, 50)
 	err := p.dbHandle.View(func(tx *bolt.Tx) error {
@@ -857,6 +1683,14 @@ func (p *BoltProvider) migrateDatabase() error {
 	}
 }
 
+func (p *BoltProvider) checkDatabaseVersion() error {
+	dbVersion, err := getBoltDatabaseVersion(p.dbHandle)
+	if err != nil {
+		return err
+	}
+	return checkSchemaVersion(dbVersion.Version, boltDatabaseVersion)
+}
+
 func (p *BoltProvider) revertDatabase(targetVersion int) error {
 	dbVersion, err := getBoltDatabaseVersion(p.dbHandle)
 	if err != nil {
@@ -871,6 +1705,26 @@ func (p *BoltProvider) revertDatabase(targetVersion int) error {
 	return errors.New("the current version cannot be reverted")
 }
 
+func (p *BoltProvider) resetDatabase() error {
+	logger.InfoToConsole("resetting database")
+	providerLog(logger.LevelInfo, "resetting database")
+	buckets := [][]byte{usersBucket, foldersBucket, adminsBucket, apiKeysBucket, eventsQueueBucket, connHistoryBucket,
+		auditEventsBucket, sharesBucket, dbVersionBucket}
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if tx.Bucket(name) != nil {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func joinUserAndFolders(u []byte, foldersBucket *bolt.Bucket) (User, error) {
 	var user User
 	err := json.Unmarshal(u, &user)
@@ -989,6 +1843,126 @@ func getAdminBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	return bucket, err
 }
 
+func getAPIKeysBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+
+	bucket := tx.Bucket(apiKeysBucket)
+	if bucket == nil {
+		err = errors.New("unable to find api keys bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func getEventsQueueBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+
+	bucket := tx.Bucket(eventsQueueBucket)
+	if bucket == nil {
+		err = errors.New("unable to find events queue bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func getConnectionHistoryBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+
+	bucket := tx.Bucket(connHistoryBucket)
+	if bucket == nil {
+		err = errors.New("unable to find connection history bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func getAuditEventsBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+
+	bucket := tx.Bucket(auditEventsBucket)
+	if bucket == nil {
+		err = errors.New("unable to find audit events bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+// getSequenceKey returns the big endian representation of the given id,
+// so the records are iterated in insertion order
+func getSequenceKey(id int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+// deleteRelatedAPIKey removes the API keys associated to the given username and scope
+func deleteRelatedAPIKey(tx *bolt.Tx, username string, scope APIKeyScope) error {
+	bucket, err := getAPIKeysBucket(tx)
+	if err != nil {
+		return err
+	}
+	var toRemove []string
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		var apiKey APIKey
+		err = json.Unmarshal(v, &apiKey)
+		if err != nil {
+			return err
+		}
+		if scope == APIKeyScopeUser {
+			if apiKey.User == username {
+				toRemove = append(toRemove, apiKey.KeyID)
+			}
+		} else {
+			if apiKey.Admin == username {
+				toRemove = append(toRemove, apiKey.KeyID)
+			}
+		}
+	}
+
+	for _, k := range toRemove {
+		if err := bucket.Delete([]byte(k)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteRelatedShares removes the shares associated to the given username
+func deleteRelatedShares(tx *bolt.Tx, username string) error {
+	bucket, err := getSharesBucket(tx)
+	if err != nil {
+		return err
+	}
+	var toRemove []string
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		var share Share
+		err = json.Unmarshal(v, &share)
+		if err != nil {
+			return err
+		}
+		if share.Username == username {
+			toRemove = append(toRemove, share.ShareID)
+		}
+	}
+
+	for _, k := range toRemove {
+		if err := bucket.Delete([]byte(k)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getSharesBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+
+	bucket := tx.Bucket(sharesBucket)
+	if bucket == nil {
+		err = errors.New("unable to find shares bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
 func getUsersBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	var err error
 	bucket := tx.Bucket(usersBucket)
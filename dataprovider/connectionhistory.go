@@ -0,0 +1,128 @@
+package dataprovider
+
+import (
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// interval between two runs of the connection history cleanup
+const connectionHistoryCleanupInterval = 1 * time.Hour
+
+var (
+	connectionHistoryTicker     *time.Ticker
+	connectionHistoryTickerDone chan bool
+)
+
+// ConnectionRecord defines a completed connection stored in the connection history
+type ConnectionRecord struct {
+	ID           int64  `json:"id"`
+	ConnectionID string `json:"connection_id"`
+	Username     string `json:"username"`
+	IP           string `json:"ip"`
+	Protocol     string `json:"protocol"`
+	// start and end time as unix timestamp in milliseconds
+	StartTime int64 `json:"start_time"`
+	EndTime   int64 `json:"end_time"`
+	// connection duration as milliseconds, it is not stored but computed from
+	// the start and end time
+	Duration        int64 `json:"duration"`
+	BytesUploaded   int64 `json:"bytes_uploaded"`
+	BytesDownloaded int64 `json:"bytes_downloaded"`
+}
+
+// ConnectionHistoryFilters defines the filters to search the connection history.
+// Empty or zero values are ignored
+type ConnectionHistoryFilters struct {
+	Username string
+	IP       string
+	Protocol string
+	// only the connections started at or after this time, unix timestamp in milliseconds
+	StartTimeFrom int64
+	// only the connections started at or before this time, unix timestamp in milliseconds
+	StartTimeTo int64
+}
+
+func (f *ConnectionHistoryFilters) match(record *ConnectionRecord) bool {
+	if f.Username != "" && record.Username != f.Username {
+		return false
+	}
+	if f.IP != "" && record.IP != f.IP {
+		return false
+	}
+	if f.Protocol != "" && record.Protocol != f.Protocol {
+		return false
+	}
+	if f.StartTimeFrom > 0 && record.StartTime < f.StartTimeFrom {
+		return false
+	}
+	if f.StartTimeTo > 0 && record.StartTime > f.StartTimeTo {
+		return false
+	}
+	return true
+}
+
+// IsConnectionHistoryEnabled returns true if the completed connections must be stored
+func IsConnectionHistoryEnabled() bool {
+	return config.ConnectionHistoryRetention > 0
+}
+
+// AddConnectionRecord stores the given completed connection, if the connection
+// history is enabled
+func AddConnectionRecord(record *ConnectionRecord) error {
+	if !IsConnectionHistoryEnabled() {
+		return nil
+	}
+	if record.Username == "" {
+		return &ValidationError{err: "the connection username is mandatory"}
+	}
+	if record.EndTime < record.StartTime {
+		return &ValidationError{err: "the connection end time cannot be before the start time"}
+	}
+	return provider.addConnectionRecord(record)
+}
+
+// SearchConnectionRecords returns the stored connections matching the given filters.
+// The records are ordered by insertion, that is by end time
+func SearchConnectionRecords(filters ConnectionHistoryFilters, limit, offset int, order string) ([]ConnectionRecord, error) {
+	records, err := provider.searchConnectionRecords(filters, limit, offset, order)
+	if err != nil {
+		return records, err
+	}
+	for idx := range records {
+		records[idx].Duration = records[idx].EndTime - records[idx].StartTime
+	}
+	return records, nil
+}
+
+func startConnectionHistoryCleanupTimer() {
+	if !IsConnectionHistoryEnabled() {
+		return
+	}
+	connectionHistoryTicker = time.NewTicker(connectionHistoryCleanupInterval)
+	connectionHistoryTickerDone = make(chan bool)
+	providerLog(logger.LevelDebug, "start connection history cleanup, retention: %v days",
+		config.ConnectionHistoryRetention)
+	go func() {
+		removeExpiredConnectionRecords()
+		for {
+			select {
+			case <-connectionHistoryTickerDone:
+				return
+			case <-connectionHistoryTicker.C:
+				removeExpiredConnectionRecords()
+			}
+		}
+	}()
+}
+
+// removeExpiredConnectionRecords deletes the connections ended before the configured retention
+func removeExpiredConnectionRecords() {
+	before := utils.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Duration(config.ConnectionHistoryRetention) * 24 * time.Hour))
+	if err := provider.cleanupConnectionRecords(before); err != nil {
+		providerLog(logger.LevelWarn, "unable to remove expired connection records: %v", err)
+		return
+	}
+	providerLog(logger.LevelDebug, "connection records ended before %v removed", before)
+}
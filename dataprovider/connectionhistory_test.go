@@ -0,0 +1,127 @@
+package dataprovider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+func TestConnectionHistory(t *testing.T) {
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+
+	record := ConnectionRecord{
+		ConnectionID: "SFTP_1",
+		Username:     "history_user",
+	}
+	// the connection history is disabled
+	err := AddConnectionRecord(&record)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), record.ID)
+
+	config.ConnectionHistoryRetention = 1
+	record.Username = ""
+	err = AddConnectionRecord(&record)
+	assert.Error(t, err)
+	record.Username = "history_user"
+	record.StartTime = 100
+	record.EndTime = 50
+	err = AddConnectionRecord(&record)
+	assert.Error(t, err)
+
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	records := []ConnectionRecord{
+		{
+			ConnectionID:    "SFTP_1",
+			Username:        "history_user1",
+			IP:              "127.0.0.1",
+			Protocol:        "SFTP",
+			StartTime:       now - 3*24*3600*1000,
+			EndTime:         now - 2*24*3600*1000,
+			BytesUploaded:   10,
+			BytesDownloaded: 20,
+		},
+		{
+			ConnectionID: "FTP_2",
+			Username:     "history_user1",
+			IP:           "127.0.0.2",
+			Protocol:     "FTP",
+			StartTime:    now - 2000,
+			EndTime:      now - 1000,
+		},
+		{
+			ConnectionID:  "DAV_3",
+			Username:      "history_user2",
+			IP:            "127.0.0.1",
+			Protocol:      "DAV",
+			StartTime:     now - 1500,
+			EndTime:       now,
+			BytesUploaded: 30,
+		},
+	}
+	for idx := range records {
+		err = AddConnectionRecord(&records[idx])
+		require.NoError(t, err)
+		assert.Greater(t, records[idx].ID, int64(0))
+	}
+	filters := ConnectionHistoryFilters{
+		Username: "history_user1",
+	}
+	res, err := SearchConnectionRecords(filters, 10, 0, OrderASC)
+	assert.NoError(t, err)
+	if assert.Len(t, res, 2) {
+		assert.Equal(t, records[0].ID, res[0].ID)
+		assert.Equal(t, records[0].EndTime-records[0].StartTime, res[0].Duration)
+		assert.Equal(t, int64(10), res[0].BytesUploaded)
+		assert.Equal(t, int64(20), res[0].BytesDownloaded)
+		assert.Equal(t, records[1].ID, res[1].ID)
+	}
+	res, err = SearchConnectionRecords(filters, 10, 0, OrderDESC)
+	assert.NoError(t, err)
+	if assert.Len(t, res, 2) {
+		assert.Equal(t, records[1].ID, res[0].ID)
+	}
+	res, err = SearchConnectionRecords(filters, 10, 1, OrderDESC)
+	assert.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, records[0].ID, res[0].ID)
+	}
+	res, err = SearchConnectionRecords(ConnectionHistoryFilters{IP: "127.0.0.1"}, 1, 0, OrderASC)
+	assert.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, records[0].ID, res[0].ID)
+	}
+	res, err = SearchConnectionRecords(ConnectionHistoryFilters{Protocol: "DAV"}, 10, 0, OrderASC)
+	assert.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, records[2].ID, res[0].ID)
+	}
+	filters = ConnectionHistoryFilters{
+		StartTimeFrom: now - 2000,
+		StartTimeTo:   now - 1800,
+	}
+	res, err = SearchConnectionRecords(filters, 10, 0, OrderASC)
+	assert.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, records[1].ID, res[0].ID)
+	}
+	// the first connection ended before the retention
+	removeExpiredConnectionRecords()
+	res, err = SearchConnectionRecords(ConnectionHistoryFilters{}, 10, 0, OrderASC)
+	assert.NoError(t, err)
+	if assert.Len(t, res, 2) {
+		assert.Equal(t, records[1].ID, res[0].ID)
+		assert.Equal(t, records[2].ID, res[1].ID)
+	}
+	err = provider.cleanupConnectionRecords(now + 1)
+	assert.NoError(t, err)
+	res, err = SearchConnectionRecords(ConnectionHistoryFilters{}, 10, 0, OrderASC)
+	assert.NoError(t, err)
+	assert.Len(t, res, 0)
+}
@@ -0,0 +1,61 @@
+package dataprovider
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const generatedPasswordLength = 24
+
+// ValidSSHKeyTypes defines the supported types for the generated SSH key pairs
+var ValidSSHKeyTypes = []string{utils.SSHKeyTypeRSA, utils.SSHKeyTypeECDSA, utils.SSHKeyTypeEd25519}
+
+// GenerateUserPassword sets a random password for the user with the given username
+// and returns it in plain text. Only the password hash is stored, so it is not
+// possible to get the password again
+func GenerateUserPassword(username string) (string, error) {
+	user, err := provider.userExists(convertUsername(username))
+	if err != nil {
+		return "", err
+	}
+	password := base64.RawURLEncoding.EncodeToString(utils.GenerateRandomBytes(generatedPasswordLength))
+	user.Password = password
+	if err := UpdateUser(&user); err != nil {
+		return "", err
+	}
+	providerLog(logger.LevelInfo, "random password generated for user %#v", user.Username)
+	return password, nil
+}
+
+// GenerateUserKeyPair generates an SSH key pair of the specified type for the user with
+// the given username. The public key is added to the user's public keys, replacing the
+// existing ones if replace is true. The private key is returned PEM encoded together with
+// the public key, the private key is not stored, so it is not possible to get it again
+func GenerateUserKeyPair(username, keyType string, replace bool) (string, string, error) {
+	if !utils.IsStringInSlice(keyType, ValidSSHKeyTypes) {
+		return "", "", &ValidationError{err: fmt.Sprintf("invalid key type %#v", keyType)}
+	}
+	user, err := provider.userExists(convertUsername(username))
+	if err != nil {
+		return "", "", err
+	}
+	privateKey, pubKey, err := utils.GenerateSSHKeyPair(keyType)
+	if err != nil {
+		return "", "", err
+	}
+	publicKey := strings.TrimSpace(string(pubKey))
+	if replace {
+		user.PublicKeys = nil
+	}
+	user.PublicKeys = append(user.PublicKeys, publicKey)
+	if err := UpdateUser(&user); err != nil {
+		return "", "", err
+	}
+	providerLog(logger.LevelInfo, "%v key pair generated for user %#v, existing keys replaced? %v", keyType,
+		user.Username, replace)
+	return string(privateKey), publicKey, nil
+}
@@ -10,6 +10,7 @@ import (
 	"crypto/sha256"
 	"crypto/sha512"
 	"crypto/subtle"
+	"database/sql/driver"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -29,6 +30,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/GehirnInc/crypt"
@@ -42,10 +45,13 @@ import (
 	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/crypto/ssh"
 
+	"github.com/drakkan/sftpgo/diagnostics"
+	"github.com/drakkan/sftpgo/geoip"
 	"github.com/drakkan/sftpgo/httpclient"
 	"github.com/drakkan/sftpgo/kms"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/metrics"
+	"github.com/drakkan/sftpgo/notifier"
 	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/vfs"
 )
@@ -61,9 +67,11 @@ const (
 	BoltDataProviderName = "bolt"
 	// MemoryDataProviderName name for memory provider
 	MemoryDataProviderName = "memory"
+	// RESTDataProviderName name for the provider that stores users in a remote service reachable via HTTP
+	RESTDataProviderName = "rest"
 	// DumpVersion defines the version for the dump.
 	// For restore/load we support the current version and the previous one
-	DumpVersion = 6
+	DumpVersion = 7
 
 	argonPwdPrefix            = "$argon2id$"
 	bcryptPwdPrefix           = "$2a$"
@@ -74,6 +82,11 @@ const (
 	md5cryptPwdPrefix         = "$1$"
 	md5cryptApr1PwdPrefix     = "$apr1$"
 	sha512cryptPwdPrefix      = "$6$"
+	ldapSSHAPwdPrefix         = "{SSHA}"
+	ldapSSHA256PwdPrefix      = "{SSHA256}"
+	ldapSSHA512PwdPrefix      = "{SSHA512}"
+	djangoPbkdf2SHA1Prefix    = "pbkdf2_sha1$"
+	djangoPbkdf2SHA256Prefix  = "pbkdf2_sha256$"
 	trackQuotaDisabledError   = "please enable track_quota in your configuration to use this method"
 	operationAdd              = "add"
 	operationUpdate           = "update"
@@ -81,6 +94,14 @@ const (
 	sqlPrefixValidChars       = "abcdefghijklmnopqrstuvwxyz_"
 )
 
+// supported password hashing algorithms
+const (
+	// HashingAlgoArgon2ID defines the argon2id password hashing algorithm
+	HashingAlgoArgon2ID = "argon2id"
+	// HashingAlgoBcrypt defines the bcrypt password hashing algorithm
+	HashingAlgoBcrypt = "bcrypt"
+)
+
 // ordering constants
 const (
 	OrderASC  = "ASC"
@@ -90,7 +111,7 @@ const (
 var (
 	// SupportedProviders defines the supported data providers
 	SupportedProviders = []string{SQLiteDataProviderName, PGSQLDataProviderName, MySQLDataProviderName,
-		BoltDataProviderName, MemoryDataProviderName}
+		BoltDataProviderName, MemoryDataProviderName, RESTDataProviderName}
 	// ValidPerms defines all the valid permissions for a user
 	ValidPerms = []string{PermAny, PermListItems, PermDownload, PermUpload, PermOverwrite, PermRename, PermDelete,
 		PermCreateDirs, PermCreateSymlinks, PermChmod, PermChown, PermChtimes}
@@ -101,8 +122,10 @@ var (
 	SSHMultiStepsLoginMethods = []string{SSHLoginMethodKeyAndPassword, SSHLoginMethodKeyAndKeyboardInt}
 	// ErrNoAuthTryed defines the error for connection closed before authentication
 	ErrNoAuthTryed = errors.New("no auth tryed")
+	// ErrShareUsedUp defines the error to return if a share reached the maximum number of uses
+	ErrShareUsedUp = errors.New("the share reached the maximum number of uses")
 	// ValidProtocols defines all the valid protcols
-	ValidProtocols = []string{"SSH", "FTP", "DAV"}
+	ValidProtocols = []string{"SSH", "FTP", "DAV", "HTTP"}
 	// ErrNoInitRequired defines the error returned by InitProvider if no inizialization/update is required
 	ErrNoInitRequired = errors.New("The data provider is up to date")
 	// ErrInvalidCredentials defines the error to return if the supplied credentials are invalid
@@ -112,22 +135,40 @@ var (
 	provider              Provider
 	sqlPlaceholders       []string
 	hashPwdPrefixes       = []string{argonPwdPrefix, bcryptPwdPrefix, pbkdf2SHA1Prefix, pbkdf2SHA256Prefix,
-		pbkdf2SHA512Prefix, pbkdf2SHA256B64SaltPrefix, md5cryptPwdPrefix, md5cryptApr1PwdPrefix, sha512cryptPwdPrefix}
+		pbkdf2SHA512Prefix, pbkdf2SHA256B64SaltPrefix, md5cryptPwdPrefix, md5cryptApr1PwdPrefix, sha512cryptPwdPrefix,
+		ldapSSHAPwdPrefix, ldapSSHA256PwdPrefix, ldapSSHA512PwdPrefix, djangoPbkdf2SHA1Prefix, djangoPbkdf2SHA256Prefix}
 	pbkdfPwdPrefixes        = []string{pbkdf2SHA1Prefix, pbkdf2SHA256Prefix, pbkdf2SHA512Prefix, pbkdf2SHA256B64SaltPrefix}
 	pbkdfPwdB64SaltPrefixes = []string{pbkdf2SHA256B64SaltPrefix}
 	unixPwdPrefixes         = []string{md5cryptPwdPrefix, md5cryptApr1PwdPrefix, sha512cryptPwdPrefix}
+	ldapPwdPrefixes         = []string{ldapSSHAPwdPrefix, ldapSSHA256PwdPrefix, ldapSSHA512PwdPrefix}
+	djangoPwdPrefixes       = []string{djangoPbkdf2SHA1Prefix, djangoPbkdf2SHA256Prefix}
 	logSender               = "dataProvider"
 	availabilityTicker      *time.Ticker
 	availabilityTickerDone  chan bool
+	expirationTicker        *time.Ticker
+	expirationTickerDone    chan bool
 	credentialsDirPath      string
 	sqlTableUsers           = "users"
 	sqlTableFolders         = "folders"
 	sqlTableFoldersMapping  = "folders_mapping"
 	sqlTableAdmins          = "admins"
+	sqlTableAPIKeys         = "api_keys"
+	sqlTableEventsQueue     = "events_queue"
+	sqlTableConnHistory     = "connection_history"
+	sqlTableShares          = "shares"
+	sqlTableAuditEvents     = "audit_events"
 	sqlTableSchemaVersion   = "schema_version"
 	argon2Params            *argon2id.Params
 	lastLoginMinDelay       = 10 * time.Minute
+	expirationCheckLimit    = 100
 	usernameRegex           = regexp.MustCompile("^[a-zA-Z0-9-_.~]+$")
+	// protects the hooks that can be reloaded at runtime
+	hooksLock sync.RWMutex
+	// 1 if the last availability check failed
+	isProviderUnavailable int32
+	// driver specific errors for broken connections, they are registered by
+	// the providers enabled at build time
+	transientDriverErrors []error
 )
 
 type schemaVersion struct {
@@ -141,9 +182,17 @@ type Argon2Options struct {
 	Parallelism uint8  `json:"parallelism" mapstructure:"parallelism"`
 }
 
+// BcryptOptions defines the options for bcrypt password hashing
+type BcryptOptions struct {
+	Cost int `json:"cost" mapstructure:"cost"`
+}
+
 // PasswordHashing defines the configuration for password hashing
 type PasswordHashing struct {
+	BcryptOptions BcryptOptions `json:"bcrypt_options" mapstructure:"bcrypt_options"`
 	Argon2Options Argon2Options `json:"argon2_options" mapstructure:"argon2_options"`
+	// Algorithm to use for hashing passwords. Available algorithms: argon2id, bcrypt. Default: argon2id
+	Algo string `json:"algo" mapstructure:"algo"`
 }
 
 // UserActions defines the action to execute on user create, update, delete.
@@ -183,8 +232,15 @@ type Config struct {
 	// 3 set ssl mode to verify-full for driver postgresql and preferred for driver mysql.
 	SSLMode int `json:"sslmode" mapstructure:"sslmode"`
 	// Custom database connection string.
-	// If not empty this connection string will be used instead of build one using the previous parameters
+	// If not empty this connection string will be used instead of build one using the previous parameters.
+	// For driver rest this is the base URL of the remote users service
 	ConnectionString string `json:"connection_string" mapstructure:"connection_string"`
+	// Connection strings for read-only replicas, used for drivers mysql and postgresql.
+	// If not empty, user, folder, admin and API key listings will be served by the replicas,
+	// in a round-robin fashion. Lookups, authentication and writes always hit the primary database.
+	// If a replica returns an error the query is retried on the primary.
+	// Unreachable replicas are logged as warnings and don't make the provider unavailable
+	ReadReplicas []string `json:"read_replicas" mapstructure:"read_replicas"`
 	// prefix for SQL tables
 	SQLTablesPrefix string `json:"sql_tables_prefix" mapstructure:"sql_tables_prefix"`
 	// Set the preferred way to track users quota between the following choices:
@@ -198,6 +254,18 @@ type Config struct {
 	// Sets the maximum number of open connections for mysql and postgresql driver.
 	// Default 0 (unlimited)
 	PoolSize int `json:"pool_size" mapstructure:"pool_size"`
+	// Sets the maximum number of idle connections for mysql and postgresql driver.
+	// 0 means pool_size if it is greater than 0, otherwise 2
+	MaxIdleConnections int `json:"max_idle_connections" mapstructure:"max_idle_connections"`
+	// Maximum amount of time, in seconds, a connection may be reused for mysql and postgresql driver.
+	// 0 means connections are reused forever
+	ConnectionMaxLifetime int `json:"connection_max_lifetime" mapstructure:"connection_max_lifetime"`
+	// Number of retries for transient data provider errors, such as broken connections or timeouts,
+	// while authenticating users and admins. 0 means no retry
+	AuthRetries int `json:"auth_retries" mapstructure:"auth_retries"`
+	// Delay, in milliseconds, before the first authentication retry. The delay is doubled
+	// for each subsequent retry
+	AuthRetryInterval int `json:"auth_retry_interval" mapstructure:"auth_retry_interval"`
 	// Users default base directory.
 	// If no home dir is defined while adding a new user, and this value is
 	// a valid absolute path, then the user home dir will be automatically
@@ -265,12 +333,55 @@ type Config struct {
 	// - 0 means automatically
 	// - 1 means manually using the initprovider sub-command
 	UpdateMode int `json:"update_mode" mapstructure:"update_mode"`
+	// CaseInsensitiveUsernames allows to treat usernames case-insensitively.
+	// If enabled usernames are converted to lowercase when users are saved and
+	// before any lookup, so users can login regardless of the username case.
+	// Existing users must have lowercase usernames to be found
+	CaseInsensitiveUsernames bool `json:"case_insensitive_usernames" mapstructure:"case_insensitive_usernames"`
+	// UsersCache defines the cache configuration for the users used to authenticate.
+	// Cached users are invalidated when they are updated or deleted
+	UsersCache UsersCacheConfig `json:"users_cache" mapstructure:"users_cache"`
 	// PasswordHashing defines the configuration for password hashing
 	PasswordHashing PasswordHashing `json:"password_hashing" mapstructure:"password_hashing"`
+	// LDAPAuth defines the configuration for the built-in LDAP authentication.
+	// It is used for password authentication only and it is ignored if an
+	// external authentication hook is defined for passwords
+	LDAPAuth LDAPAuthConfig `json:"ldap_auth" mapstructure:"ldap_auth"`
 	// PreferDatabaseCredentials indicates whether credential files (currently used for Google
 	// Cloud Storage) should be stored in the database instead of in the directory specified by
 	// CredentialsPath.
 	PreferDatabaseCredentials bool `json:"prefer_database_credentials" mapstructure:"prefer_database_credentials"`
+	// SharedCredentialsPath defines the directory containing the Cloud Storage credentials
+	// files that can be referenced in the users' filesystem configuration. It can be a path
+	// relative to the config dir or an absolute path. Leave empty to not allow credentials files
+	SharedCredentialsPath string `json:"shared_credentials_path" mapstructure:"shared_credentials_path"`
+	// SharedCredentialsEnvPrefix defines the required prefix for the environment variables
+	// containing Cloud Storage credentials that can be referenced in the users' filesystem
+	// configuration. Leave empty to not allow credentials environment variables
+	SharedCredentialsEnvPrefix string `json:"shared_credentials_env_prefix" mapstructure:"shared_credentials_env_prefix"`
+	// Interval, in minutes, for the background job that checks for expired users.
+	// Expired users that are still enabled will be disabled and the "update" action,
+	// if configured, will be executed. 0 means disabled. Login is always denied for
+	// expired users, even if this job is disabled
+	ExpiredUsersCheckInterval int `json:"expired_users_check_interval" mapstructure:"expired_users_check_interval"`
+	// DisableInactiveUsersAfter defines the number of days after which users that have
+	// not logged in are disabled. Inactive users are checked by the same background job
+	// used for expired users so ExpiredUsersCheckInterval must be greater than 0.
+	// Users that never logged in are not checked. 0 means disabled
+	DisableInactiveUsersAfter int `json:"disable_inactive_users_after" mapstructure:"disable_inactive_users_after"`
+	// Interval, in minutes, for the background job that removes the temporary credentials
+	// that are expired, have no remaining uses or whose parent user does not exist anymore.
+	// 0 means disabled. Login is always denied for these temporary credentials,
+	// even if this job is disabled
+	TempCredentialsCleanupInterval int `json:"temp_credentials_cleanup_interval" mapstructure:"temp_credentials_cleanup_interval"`
+	// ConnectionHistoryRetention defines the number of days the completed connections
+	// are kept in the connection history. 0 means the connection history is disabled
+	ConnectionHistoryRetention int `json:"connection_history_retention" mapstructure:"connection_history_retention"`
+	// AuditEventsRetention defines the number of days the audit events, provider changes
+	// and security relevant events, are kept. 0 means the audit is disabled
+	AuditEventsRetention int `json:"audit_events_retention" mapstructure:"audit_events_retention"`
+	// Webhooks to notify when users, folders or admins are added, updated or deleted
+	Webhooks []WebhookConfig `json:"webhooks" mapstructure:"webhooks"`
 }
 
 // BackupData defines the structure for the backup/restore files
@@ -278,6 +389,8 @@ type BackupData struct {
 	Users   []User                  `json:"users"`
 	Folders []vfs.BaseVirtualFolder `json:"folders"`
 	Admins  []Admin                 `json:"admins"`
+	APIKeys []APIKey                `json:"api_keys"`
+	Shares  []Share                 `json:"shares"`
 	Version int                     `json:"version"`
 }
 
@@ -291,6 +404,62 @@ func (d *BackupData) HasFolder(name string) bool {
 	return false
 }
 
+// HasUser returns true if the user with the given username is included
+func (d *BackupData) HasUser(username string) bool {
+	for _, user := range d.Users {
+		if user.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAdmin returns true if the admin with the given username is included
+func (d *BackupData) HasAdmin(username string) bool {
+	for _, admin := range d.Admins {
+		if admin.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAPIKey returns true if the API key with the given key id is included
+func (d *BackupData) HasAPIKey(keyID string) bool {
+	for _, apiKey := range d.APIKeys {
+		if apiKey.KeyID == keyID {
+			return true
+		}
+	}
+	return false
+}
+
+// HasShare returns true if the share with the given share id is included
+func (d *BackupData) HasShare(shareID string) bool {
+	for _, share := range d.Shares {
+		if share.ShareID == shareID {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFolderReferenced returns true if the folder with the given name is included
+// or it is referenced by an included user
+func (d *BackupData) IsFolderReferenced(name string) bool {
+	if d.HasFolder(name) {
+		return true
+	}
+	for _, user := range d.Users {
+		for _, folder := range user.VirtualFolders {
+			if folder.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type keyboardAuthHookRequest struct {
 	RequestID string   `json:"request_id"`
 	Username  string   `json:"username,omitempty"`
@@ -308,6 +477,15 @@ type keyboardAuthHookResponse struct {
 	CheckPwd    int      `json:"check_password"`
 }
 
+// providerHooks defines the hooks that can be reloaded at runtime
+type providerHooks struct {
+	actions       string
+	externalAuth  string
+	preLogin      string
+	postLogin     string
+	checkPassword string
+}
+
 type checkPasswordRequest struct {
 	Username string `json:"username"`
 	IP       string `json:"ip"`
@@ -333,6 +511,11 @@ func (e *ValidationError) Error() string {
 	return fmt.Sprintf("Validation error: %s", e.err)
 }
 
+// GetErrorString returns the unmodified error string
+func (e *ValidationError) GetErrorString() string {
+	return e.err
+}
+
 // NewValidationError returns a validation errors
 func NewValidationError(error string) *ValidationError {
 	return &ValidationError{
@@ -372,13 +555,16 @@ type Provider interface {
 	validateUserAndPubKey(username string, pubKey []byte) (User, string, error)
 	updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error
 	getUsedQuota(username string) (int, int64, error)
+	updateTransferQuota(username string, uploadSize, downloadSize, periodStart int64) error
+	getUsedTransferQuota(username string) (int64, int64, int64, error)
 	userExists(username string) (User, error)
 	addUser(user *User) error
 	updateUser(user *User) error
 	deleteUser(user *User) error
 	getUsers(limit int, offset int, order string) ([]User, error)
+	searchUsers(filters UserSearchFilters, limit, offset int, order string) ([]User, error)
 	dumpUsers() ([]User, error)
-	updateLastLogin(username string) error
+	updateLastLogin(username, protocol string) error
 	getFolders(limit, offset int, order string) ([]vfs.BaseVirtualFolder, error)
 	getFolderByName(name string) (vfs.BaseVirtualFolder, error)
 	addFolder(folder *vfs.BaseVirtualFolder) error
@@ -393,13 +579,40 @@ type Provider interface {
 	deleteAdmin(admin *Admin) error
 	getAdmins(limit int, offset int, order string) ([]Admin, error)
 	dumpAdmins() ([]Admin, error)
+	updateSecrets(users []User, admins []Admin) error
 	validateAdminAndPass(username, password, ip string) (Admin, error)
+	apiKeyExists(keyID string) (APIKey, error)
+	addAPIKey(apiKey *APIKey) error
+	updateAPIKey(apiKey *APIKey) error
+	deleteAPIKey(apiKey *APIKey) error
+	getAPIKeys(limit int, offset int, order string) ([]APIKey, error)
+	dumpAPIKeys() ([]APIKey, error)
+	updateAPIKeyLastUse(keyID string) error
+	shareExists(shareID string) (Share, error)
+	addShare(share *Share) error
+	updateShare(share *Share) error
+	deleteShare(share *Share) error
+	getShares(limit int, offset int, order, username string) ([]Share, error)
+	dumpShares() ([]Share, error)
+	updateShareLastUse(shareID string, numTokens int) error
+	addQueuedEvent(event *QueuedEvent) error
+	getQueuedEvents(limit int, before int64) ([]QueuedEvent, error)
+	updateQueuedEvent(event *QueuedEvent) error
+	deleteQueuedEvent(id int64) error
+	addConnectionRecord(record *ConnectionRecord) error
+	searchConnectionRecords(filters ConnectionHistoryFilters, limit, offset int, order string) ([]ConnectionRecord, error)
+	cleanupConnectionRecords(before int64) error
+	addAuditEvent(event *AuditEvent) error
+	searchAuditEvents(filters AuditEventsFilters, limit, offset int, order string) ([]AuditEvent, error)
+	cleanupAuditEvents(before int64) error
 	checkAvailability() error
 	close() error
 	reloadConfig() error
 	initializeDatabase() error
 	migrateDatabase() error
 	revertDatabase(targetVersion int) error
+	resetDatabase() error
+	checkDatabaseVersion() error
 }
 
 // Initialize the data provider.
@@ -407,14 +620,31 @@ type Provider interface {
 func Initialize(cnf Config, basePath string, checkAdmins bool) error {
 	var err error
 	config = cnf
+	cachedUsers.clear()
 
 	if filepath.IsAbs(config.CredentialsPath) {
 		credentialsDirPath = config.CredentialsPath
 	} else {
 		credentialsDirPath = filepath.Join(basePath, config.CredentialsPath)
 	}
+	sharedCredentialsPath := config.SharedCredentialsPath
+	if sharedCredentialsPath != "" && !filepath.IsAbs(sharedCredentialsPath) {
+		sharedCredentialsPath = filepath.Join(basePath, sharedCredentialsPath)
+	}
+	if err = vfs.SetSharedCredentialsConfig(sharedCredentialsPath, config.SharedCredentialsEnvPrefix); err != nil {
+		return err
+	}
 
-	if err = validateHooks(); err != nil {
+	if err = validateHooks(&config); err != nil {
+		return err
+	}
+	if err = validateWebhooks(&config); err != nil {
+		return err
+	}
+	if err = validatePasswordHashing(); err != nil {
+		return err
+	}
+	if err = validateLDAPConfig(); err != nil {
 		return err
 	}
 	err = createProvider(basePath)
@@ -452,24 +682,97 @@ func Initialize(cnf Config, basePath string, checkAdmins bool) error {
 		}
 	} else {
 		providerLog(logger.LevelInfo, "database initialization/migration skipped, manual mode is configured")
+		err = provider.checkDatabaseVersion()
+		if err != nil {
+			return err
+		}
 	}
 	startAvailabilityTimer()
+	startExpirationTimer()
+	startTempCredentialsCleanupTimer()
+	startConnectionHistoryCleanupTimer()
+	startAuditEventsCleanupTimer()
+	return nil
+}
+
+// checkSchemaVersion returns an error if the current schema version is older than
+// the required one, this can happen if the database update mode is manual and the
+// initprovider sub-command was not executed after upgrading SFTPGo
+func checkSchemaVersion(current, required int) error {
+	if current < required {
+		err := fmt.Errorf("database version %v is older than the required one: %v, please run the \"initprovider\" command",
+			current, required)
+		providerLog(logger.LevelWarn, "%v", err)
+		logger.WarnToConsole("%v", err)
+		return err
+	}
+	return nil
+}
+
+func (p *PasswordHashing) validate() error {
+	switch p.Algo {
+	case "":
+		p.Algo = HashingAlgoArgon2ID
+	case HashingAlgoArgon2ID, HashingAlgoBcrypt:
+	default:
+		return fmt.Errorf("unsupported password hashing algorithm %#v", p.Algo)
+	}
+	if p.BcryptOptions.Cost == 0 {
+		p.BcryptOptions.Cost = bcrypt.DefaultCost
+	}
+	if p.BcryptOptions.Cost < bcrypt.MinCost || p.BcryptOptions.Cost > bcrypt.MaxCost {
+		return fmt.Errorf("invalid bcrypt cost %v, it must be between %v and %v", p.BcryptOptions.Cost,
+			bcrypt.MinCost, bcrypt.MaxCost)
+	}
 	return nil
 }
 
-func validateHooks() error {
+func validatePasswordHashing() error {
+	return config.PasswordHashing.validate()
+}
+
+// HashPassword hashes the given password using the specified hashing configuration.
+// The data provider does not need to be initialized, this allows to generate the
+// password hashes to use for pre-provisioning users
+func HashPassword(hashing PasswordHashing, password string) (string, error) {
+	if password == "" {
+		return "", errors.New("the password cannot be empty")
+	}
+	if err := hashing.validate(); err != nil {
+		return "", err
+	}
+	if hashing.Algo == HashingAlgoBcrypt {
+		pwd, err := bcrypt.GenerateFromPassword([]byte(password), hashing.BcryptOptions.Cost)
+		if err != nil {
+			return "", err
+		}
+		return string(pwd), nil
+	}
+	if hashing.Argon2Options.Memory == 0 || hashing.Argon2Options.Iterations == 0 || hashing.Argon2Options.Parallelism == 0 {
+		return "", errors.New("invalid argon2id options, memory, iterations and parallelism must be greater than 0")
+	}
+	return argon2id.CreateHash(password, &argon2id.Params{
+		Memory:      hashing.Argon2Options.Memory,
+		Iterations:  hashing.Argon2Options.Iterations,
+		Parallelism: hashing.Argon2Options.Parallelism,
+		SaltLength:  16,
+		KeyLength:   32,
+	})
+}
+
+func validateHooks(c *Config) error {
 	var hooks []string
-	if config.PreLoginHook != "" && !strings.HasPrefix(config.PreLoginHook, "http") {
-		hooks = append(hooks, config.PreLoginHook)
+	if c.PreLoginHook != "" && !strings.HasPrefix(c.PreLoginHook, "http") {
+		hooks = append(hooks, c.PreLoginHook)
 	}
-	if config.ExternalAuthHook != "" && !strings.HasPrefix(config.ExternalAuthHook, "http") {
-		hooks = append(hooks, config.ExternalAuthHook)
+	if c.ExternalAuthHook != "" && !strings.HasPrefix(c.ExternalAuthHook, "http") {
+		hooks = append(hooks, c.ExternalAuthHook)
 	}
-	if config.PostLoginHook != "" && !strings.HasPrefix(config.PostLoginHook, "http") {
-		hooks = append(hooks, config.PostLoginHook)
+	if c.PostLoginHook != "" && !strings.HasPrefix(c.PostLoginHook, "http") {
+		hooks = append(hooks, c.PostLoginHook)
 	}
-	if config.CheckPasswordHook != "" && !strings.HasPrefix(config.CheckPasswordHook, "http") {
-		hooks = append(hooks, config.CheckPasswordHook)
+	if c.CheckPasswordHook != "" && !strings.HasPrefix(c.CheckPasswordHook, "http") {
+		hooks = append(hooks, c.CheckPasswordHook)
 	}
 
 	for _, hook := range hooks {
@@ -497,9 +800,16 @@ func validateSQLTablesPrefix() error {
 		sqlTableFolders = config.SQLTablesPrefix + sqlTableFolders
 		sqlTableFoldersMapping = config.SQLTablesPrefix + sqlTableFoldersMapping
 		sqlTableAdmins = config.SQLTablesPrefix + sqlTableAdmins
+		sqlTableAPIKeys = config.SQLTablesPrefix + sqlTableAPIKeys
+		sqlTableEventsQueue = config.SQLTablesPrefix + sqlTableEventsQueue
+		sqlTableConnHistory = config.SQLTablesPrefix + sqlTableConnHistory
+		sqlTableShares = config.SQLTablesPrefix + sqlTableShares
+		sqlTableAuditEvents = config.SQLTablesPrefix + sqlTableAuditEvents
 		sqlTableSchemaVersion = config.SQLTablesPrefix + sqlTableSchemaVersion
-		providerLog(logger.LevelDebug, "sql table for users %#v, folders %#v folders mapping %#v admins %#v schema version %#v",
-			sqlTableUsers, sqlTableFolders, sqlTableFoldersMapping, sqlTableAdmins, sqlTableSchemaVersion)
+		providerLog(logger.LevelDebug, "sql table for users %#v, folders %#v folders mapping %#v admins %#v "+
+			"api keys %#v events queue %#v connection history %#v shares %#v audit events %#v schema version %#v",
+			sqlTableUsers, sqlTableFolders, sqlTableFoldersMapping, sqlTableAdmins, sqlTableAPIKeys, sqlTableEventsQueue,
+			sqlTableConnHistory, sqlTableShares, sqlTableAuditEvents, sqlTableSchemaVersion)
 	}
 	return nil
 }
@@ -561,47 +871,118 @@ func RevertDatabase(cnf Config, basePath string, targetVersion int) error {
 	return provider.revertDatabase(targetVersion)
 }
 
+// ResetDatabase removes all the data and the structures from the configured
+// data provider and then creates the initial database structure again
+func ResetDatabase(cnf Config, basePath string) error {
+	config = cnf
+
+	if filepath.IsAbs(config.CredentialsPath) {
+		credentialsDirPath = config.CredentialsPath
+	} else {
+		credentialsDirPath = filepath.Join(basePath, config.CredentialsPath)
+	}
+
+	err := createProvider(basePath)
+	if err != nil {
+		return err
+	}
+	err = provider.resetDatabase()
+	if err != nil {
+		return err
+	}
+	err = provider.initializeDatabase()
+	if err != nil && err != ErrNoInitRequired {
+		return err
+	}
+	err = provider.migrateDatabase()
+	if err != nil && err != ErrNoInitRequired {
+		return err
+	}
+	return nil
+}
+
 // CheckAdminAndPass validates the given admin and password connecting from ip
 func CheckAdminAndPass(username, password, ip string) (Admin, error) {
-	return provider.validateAdminAndPass(username, password, ip)
+	var admin Admin
+	err := executeWithAuthRetry(func() error {
+		var errValidate error
+		admin, errValidate = provider.validateAdminAndPass(username, password, ip)
+		return errValidate
+	})
+	if err != nil {
+		addLoginFailedEvent(username, ip, "HTTP", AuditObjectAdmin, err.Error())
+	}
+	return admin, err
 }
 
 // CheckUserAndPass retrieves the SFTP user with the given username and password if a match is found or an error
 func CheckUserAndPass(username, password, ip, protocol string) (User, error) {
-	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&1 != 0) {
+	username = convertUsername(username)
+	if getHooks().externalAuth != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&1 != 0) {
 		user, err := doExternalAuth(username, password, nil, "", ip, protocol)
 		if err != nil {
 			return user, err
 		}
 		return checkUserAndPass(&user, password, ip, protocol)
 	}
-	if config.PreLoginHook != "" {
+	if domain, ldapUsername := getLDAPDomain(username); domain != nil {
+		user, err := doLDAPAuth(domain, ldapUsername, password)
+		if err != errLDAPUserNotManaged {
+			if err != nil {
+				return user, err
+			}
+			return checkUserAndPass(&user, password, ip, protocol)
+		}
+	}
+	if getHooks().preLogin != "" {
 		user, err := executePreLoginHook(username, LoginMethodPassword, ip, protocol)
 		if err != nil {
 			return user, err
 		}
 		return checkUserAndPass(&user, password, ip, protocol)
 	}
-	return provider.validateUserAndPass(username, password, ip, protocol)
+	var user User
+	err := executeWithAuthRetry(func() error {
+		var errValidate error
+		if config.UsersCache.isEnabled() {
+			user, errValidate = validateCachedUserAndPass(username, password, ip, protocol)
+		} else {
+			user, errValidate = provider.validateUserAndPass(username, password, ip, protocol)
+		}
+		return errValidate
+	})
+	return user, err
 }
 
 // CheckUserAndPubKey retrieves the SFTP user with the given username and public key if a match is found or an error
 func CheckUserAndPubKey(username string, pubKey []byte, ip, protocol string) (User, string, error) {
-	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&2 != 0) {
+	username = convertUsername(username)
+	if getHooks().externalAuth != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&2 != 0) {
 		user, err := doExternalAuth(username, "", pubKey, "", ip, protocol)
 		if err != nil {
 			return user, "", err
 		}
 		return checkUserAndPubKey(&user, pubKey)
 	}
-	if config.PreLoginHook != "" {
+	if getHooks().preLogin != "" {
 		user, err := executePreLoginHook(username, SSHLoginMethodPublicKey, ip, protocol)
 		if err != nil {
 			return user, "", err
 		}
 		return checkUserAndPubKey(&user, pubKey)
 	}
-	return provider.validateUserAndPubKey(username, pubKey)
+	var user User
+	var keyID string
+	err := executeWithAuthRetry(func() error {
+		var errValidate error
+		if config.UsersCache.isEnabled() {
+			user, keyID, errValidate = validateCachedUserAndPubKey(username, pubKey)
+		} else {
+			user, keyID, errValidate = provider.validateUserAndPubKey(username, pubKey)
+		}
+		return errValidate
+	})
+	return user, keyID, err
 }
 
 // CheckKeyboardInteractiveAuth checks the keyboard interactive authentication and returns
@@ -609,12 +990,17 @@ func CheckUserAndPubKey(username string, pubKey []byte, ip, protocol string) (Us
 func CheckKeyboardInteractiveAuth(username, authHook string, client ssh.KeyboardInteractiveChallenge, ip, protocol string) (User, error) {
 	var user User
 	var err error
-	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&4 != 0) {
+	username = convertUsername(username)
+	if getHooks().externalAuth != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&4 != 0) {
 		user, err = doExternalAuth(username, "", nil, "1", ip, protocol)
-	} else if config.PreLoginHook != "" {
+	} else if getHooks().preLogin != "" {
 		user, err = executePreLoginHook(username, SSHLoginMethodKeyboardInteractive, ip, protocol)
 	} else {
-		user, err = provider.userExists(username)
+		err = executeWithAuthRetry(func() error {
+			var errExists error
+			user, errExists = getUserForAuth(username)
+			return errExists
+		})
 	}
 	if err != nil {
 		return user, err
@@ -622,14 +1008,60 @@ func CheckKeyboardInteractiveAuth(username, authHook string, client ssh.Keyboard
 	return doKeyboardInteractiveAuth(&user, authHook, client, ip, protocol)
 }
 
-// UpdateLastLogin updates the last login fields for the given SFTP user
-func UpdateLastLogin(user *User) error {
+// isTransientProviderError returns true if the given error could be caused by a
+// temporary data provider unavailability: a broken or refused connection, a timeout,
+// a network dial/read failure or a temporary network error
+func isTransientProviderError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	for _, driverErr := range transientDriverErrors {
+		if errors.Is(err, driverErr) {
+			return true
+		}
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "read") {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout() || netErr.Temporary() //nolint:staticcheck
+	}
+	return false
+}
+
+// executeWithAuthRetry executes the given authentication function and retries it,
+// with an exponential backoff, if it fails for a transient data provider error
+func executeWithAuthRetry(fn func() error) error {
+	err := fn()
+	interval := time.Duration(config.AuthRetryInterval) * time.Millisecond
+	for retry := 1; retry <= config.AuthRetries && isTransientProviderError(err); retry++ {
+		providerLog(logger.LevelWarn, "transient data provider error while authenticating, retry %v/%v in %v: %v",
+			retry, config.AuthRetries, interval, err)
+		time.Sleep(interval)
+		interval *= 2
+		err = fn()
+	}
+	return err
+}
+
+// UpdateLastLogin updates the last login fields for the given SFTP user.
+// protocol is the protocol used to login
+func UpdateLastLogin(user *User, protocol string) error {
 	lastLogin := utils.GetTimeFromMsecSinceEpoch(user.LastLogin)
 	diff := -time.Until(lastLogin)
-	if diff < 0 || diff > lastLoginMinDelay {
-		err := provider.updateLastLogin(user.Username)
+	if diff < 0 || diff > lastLoginMinDelay || user.LastLoginProtocol != protocol {
+		err := provider.updateLastLogin(user.Username, protocol)
 		if err == nil {
-			updateWebDavCachedUserLastLogin(user.Username)
+			updateWebDavCachedUserLastLogin(user.Username, protocol)
+			cachedUsers.updateLastLogin(user.Username, protocol)
 		}
 		return err
 	}
@@ -647,7 +1079,34 @@ func UpdateUserQuota(user *User, filesAdd int, sizeAdd int64, reset bool) error
 	if filesAdd == 0 && sizeAdd == 0 && !reset {
 		return nil
 	}
-	return provider.updateQuota(user.Username, filesAdd, sizeAdd, reset)
+	return provider.updateQuota(user.GetQuotaUsername(), filesAdd, sizeAdd, reset)
+}
+
+// UpdateUserTransferQuota adds the given uploaded and downloaded bytes to the data transfer
+// used by the given user in the current period. Data transfer is tracked only for users
+// with transfer quota limits
+func UpdateUserTransferQuota(user *User, uploadSize, downloadSize int64) error {
+	if !user.Filters.TransferQuota.HasLimits() {
+		return nil
+	}
+	if uploadSize == 0 && downloadSize == 0 {
+		return nil
+	}
+	periodStart := user.Filters.TransferQuota.GetPeriodStart(time.Now())
+	return provider.updateTransferQuota(user.GetQuotaUsername(), uploadSize, downloadSize, periodStart)
+}
+
+// GetUsedTransferQuota returns the uploaded and downloaded bytes for the given user
+// in the current data transfer quota period
+func GetUsedTransferQuota(user *User) (int64, int64, error) {
+	uploadSize, downloadSize, periodStart, err := provider.getUsedTransferQuota(convertUsername(user.GetQuotaUsername()))
+	if err != nil {
+		return 0, 0, err
+	}
+	if periodStart != user.Filters.TransferQuota.GetPeriodStart(time.Now()) {
+		return 0, 0, nil
+	}
+	return uploadSize, downloadSize, nil
 }
 
 // UpdateVirtualFolderQuota updates the quota for the given virtual folder adding filesAdd and sizeAdd.
@@ -667,7 +1126,7 @@ func GetUsedQuota(username string) (int, int64, error) {
 	if config.TrackQuota == 0 {
 		return 0, 0, &MethodDisabledError{err: trackQuotaDisabledError}
 	}
-	return provider.getUsedQuota(username)
+	return provider.getUsedQuota(convertUsername(username))
 }
 
 // GetUsedVirtualFolderQuota returns the used quota for the given virtual folder.
@@ -680,12 +1139,23 @@ func GetUsedVirtualFolderQuota(name string) (int, int64, error) {
 
 // AddAdmin adds a new SFTPGo admin
 func AddAdmin(admin *Admin) error {
-	return provider.addAdmin(admin)
+	err := provider.addAdmin(admin)
+	if err == nil {
+		addProviderChangeEvent(AuditActionAdd, AuditObjectAdmin, admin.Username, "")
+		executeWebhooks(AuditActionAdd, AuditObjectAdmin, admin.Username, nil)
+	}
+	return err
 }
 
 // UpdateAdmin updates an existing SFTPGo admin
 func UpdateAdmin(admin *Admin) error {
-	return provider.updateAdmin(admin)
+	previous := getWebhookPreviousObject(AuditActionUpdate, AuditObjectAdmin, admin.Username)
+	err := provider.updateAdmin(admin)
+	if err == nil {
+		addProviderChangeEvent(AuditActionUpdate, AuditObjectAdmin, admin.Username, "")
+		executeWebhooks(AuditActionUpdate, AuditObjectAdmin, admin.Username, previous)
+	}
+	return err
 }
 
 // DeleteAdmin deletes an existing SFTPGo admin
@@ -694,7 +1164,13 @@ func DeleteAdmin(username string) error {
 	if err != nil {
 		return err
 	}
-	return provider.deleteAdmin(&admin)
+	previous := getWebhookPreviousObject(AuditActionDelete, AuditObjectAdmin, admin.Username)
+	err = provider.deleteAdmin(&admin)
+	if err == nil {
+		addProviderChangeEvent(AuditActionDelete, AuditObjectAdmin, admin.Username, "")
+		executeWebhooks(AuditActionDelete, AuditObjectAdmin, admin.Username, previous)
+	}
+	return err
 }
 
 // AdminExists returns the given admins if it exists
@@ -702,9 +1178,63 @@ func AdminExists(username string) (Admin, error) {
 	return provider.adminExists(username)
 }
 
+// UpdateAPIKeyLastUse updates the LastUseAt field for the given API key
+func UpdateAPIKeyLastUse(apiKey *APIKey) error {
+	lastUse := utils.GetTimeFromMsecSinceEpoch(apiKey.LastUseAt)
+	diff := -time.Until(lastUse)
+	if diff < 0 || diff > lastLoginMinDelay {
+		return provider.updateAPIKeyLastUse(apiKey.KeyID)
+	}
+	return nil
+}
+
+// APIKeyExists returns the API key with the given ID if it exists
+func APIKeyExists(keyID string) (APIKey, error) {
+	if keyID == "" {
+		return APIKey{}, &RecordNotFoundError{err: fmt.Sprintf("API key %#v does not exist", keyID)}
+	}
+	return provider.apiKeyExists(keyID)
+}
+
+// AddAPIKey adds a new API key
+func AddAPIKey(apiKey *APIKey) error {
+	err := provider.addAPIKey(apiKey)
+	if err == nil {
+		addProviderChangeEvent(AuditActionAdd, AuditObjectAPIKey, apiKey.KeyID, getAPIKeyOwner(apiKey))
+	}
+	return err
+}
+
+// UpdateAPIKey updates an existing API key
+func UpdateAPIKey(apiKey *APIKey) error {
+	err := provider.updateAPIKey(apiKey)
+	if err == nil {
+		addProviderChangeEvent(AuditActionUpdate, AuditObjectAPIKey, apiKey.KeyID, getAPIKeyOwner(apiKey))
+	}
+	return err
+}
+
+// DeleteAPIKey deletes an existing API key
+func DeleteAPIKey(keyID string) error {
+	apiKey, err := provider.apiKeyExists(keyID)
+	if err != nil {
+		return err
+	}
+	err = provider.deleteAPIKey(&apiKey)
+	if err == nil {
+		addProviderChangeEvent(AuditActionDelete, AuditObjectAPIKey, apiKey.KeyID, getAPIKeyOwner(&apiKey))
+	}
+	return err
+}
+
+// GetAPIKeys returns an array of API keys respecting limit and offset
+func GetAPIKeys(limit, offset int, order string) ([]APIKey, error) {
+	return provider.getAPIKeys(limit, offset, order)
+}
+
 // UserExists checks if the given SFTPGo username exists, returns an error if no match is found
 func UserExists(username string) (User, error) {
-	return provider.userExists(username)
+	return provider.userExists(convertUsername(username))
 }
 
 // AddUser adds a new SFTPGo user.
@@ -712,30 +1242,38 @@ func AddUser(user *User) error {
 	err := provider.addUser(user)
 	if err == nil {
 		executeAction(operationAdd, user)
+		addProviderChangeEvent(AuditActionAdd, AuditObjectUser, user.Username, user.Username)
+		executeWebhooks(AuditActionAdd, AuditObjectUser, user.Username, nil)
 	}
 	return err
 }
 
 // UpdateUser updates an existing SFTPGo user.
 func UpdateUser(user *User) error {
+	previous := getWebhookPreviousObject(AuditActionUpdate, AuditObjectUser, user.Username)
 	err := provider.updateUser(user)
 	if err == nil {
-		RemoveCachedWebDAVUser(user.Username)
+		RemoveCachedUser(user.Username)
 		executeAction(operationUpdate, user)
+		addProviderChangeEvent(AuditActionUpdate, AuditObjectUser, user.Username, user.Username)
+		executeWebhooks(AuditActionUpdate, AuditObjectUser, user.Username, previous)
 	}
 	return err
 }
 
 // DeleteUser deletes an existing SFTPGo user.
 func DeleteUser(username string) error {
-	user, err := provider.userExists(username)
+	user, err := provider.userExists(convertUsername(username))
 	if err != nil {
 		return err
 	}
+	previous := getWebhookPreviousObject(AuditActionDelete, AuditObjectUser, user.Username)
 	err = provider.deleteUser(&user)
 	if err == nil {
-		RemoveCachedWebDAVUser(user.Username)
+		RemoveCachedUser(user.Username)
 		executeAction(operationDelete, &user)
+		addProviderChangeEvent(AuditActionDelete, AuditObjectUser, user.Username, user.Username)
+		executeWebhooks(AuditActionDelete, AuditObjectUser, user.Username, previous)
 	}
 	return err
 }
@@ -744,7 +1282,62 @@ func DeleteUser(username string) error {
 // Currently only implemented for memory provider, allows to reload the users
 // from the configured file, if defined
 func ReloadConfig() error {
-	return provider.reloadConfig()
+	err := provider.reloadConfig()
+	if err == nil {
+		ClearUsersCache()
+	}
+	return err
+}
+
+// ReloadHooks updates the configured hooks using the given configuration.
+// The hooks already running are not affected
+func ReloadHooks(cnf Config) error {
+	if err := validateHooks(&cnf); err != nil {
+		return err
+	}
+
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+
+	config.Actions.Hook = cnf.Actions.Hook
+	config.ExternalAuthHook = cnf.ExternalAuthHook
+	config.PreLoginHook = cnf.PreLoginHook
+	config.PostLoginHook = cnf.PostLoginHook
+	config.CheckPasswordHook = cnf.CheckPasswordHook
+	providerLog(logger.LevelInfo, "hooks reloaded")
+	return nil
+}
+
+func getHooks() providerHooks {
+	hooksLock.RLock()
+	defer hooksLock.RUnlock()
+
+	return providerHooks{
+		actions:       config.Actions.Hook,
+		externalAuth:  config.ExternalAuthHook,
+		preLogin:      config.PreLoginHook,
+		postLogin:     config.PostLoginHook,
+		checkPassword: config.CheckPasswordHook,
+	}
+}
+
+// GetConfiguredHooks returns the configured provider hooks, the map key is the
+// configuration name of the hook
+func GetConfiguredHooks() map[string]string {
+	hooks := getHooks()
+	result := make(map[string]string)
+	for name, hook := range map[string]string{
+		"actions.hook":        hooks.actions,
+		"external_auth_hook":  hooks.externalAuth,
+		"pre_login_hook":      hooks.preLogin,
+		"post_login_hook":     hooks.postLogin,
+		"check_password_hook": hooks.checkPassword,
+	} {
+		if hook != "" {
+			result[name] = hook
+		}
+	}
+	return result
 }
 
 // GetAdmins returns an array of admins respecting limit and offset
@@ -759,12 +1352,23 @@ func GetUsers(limit, offset int, order string) ([]User, error) {
 
 // AddFolder adds a new virtual folder.
 func AddFolder(folder *vfs.BaseVirtualFolder) error {
-	return provider.addFolder(folder)
+	err := provider.addFolder(folder)
+	if err == nil {
+		addProviderChangeEvent(AuditActionAdd, AuditObjectFolder, folder.Name, "")
+		executeWebhooks(AuditActionAdd, AuditObjectFolder, folder.Name, nil)
+	}
+	return err
 }
 
 // UpdateFolder updates the specified virtual folder
 func UpdateFolder(folder *vfs.BaseVirtualFolder) error {
-	return provider.updateFolder(folder)
+	previous := getWebhookPreviousObject(AuditActionUpdate, AuditObjectFolder, folder.Name)
+	err := provider.updateFolder(folder)
+	if err == nil {
+		addProviderChangeEvent(AuditActionUpdate, AuditObjectFolder, folder.Name, "")
+		executeWebhooks(AuditActionUpdate, AuditObjectFolder, folder.Name, previous)
+	}
+	return err
 }
 
 // DeleteFolder deletes an existing folder.
@@ -773,7 +1377,13 @@ func DeleteFolder(folderName string) error {
 	if err != nil {
 		return err
 	}
-	return provider.deleteFolder(&folder)
+	previous := getWebhookPreviousObject(AuditActionDelete, AuditObjectFolder, folder.Name)
+	err = provider.deleteFolder(&folder)
+	if err == nil {
+		addProviderChangeEvent(AuditActionDelete, AuditObjectFolder, folder.Name, "")
+		executeWebhooks(AuditActionDelete, AuditObjectFolder, folder.Name, previous)
+	}
+	return err
 }
 
 // GetFolderByName returns the folder with the specified name if any
@@ -786,7 +1396,7 @@ func GetFolders(limit, offset int, order string) ([]vfs.BaseVirtualFolder, error
 	return provider.getFolders(limit, offset, order)
 }
 
-// DumpData returns all users and folders
+// DumpData returns all users, folders, admins, API keys and shares
 func DumpData() (BackupData, error) {
 	var data BackupData
 	users, err := provider.dumpUsers()
@@ -801,9 +1411,19 @@ func DumpData() (BackupData, error) {
 	if err != nil {
 		return data, err
 	}
+	apiKeys, err := provider.dumpAPIKeys()
+	if err != nil {
+		return data, err
+	}
+	shares, err := provider.dumpShares()
+	if err != nil {
+		return data, err
+	}
 	data.Users = users
 	data.Folders = folders
 	data.Admins = admins
+	data.APIKeys = apiKeys
+	data.Shares = shares
 	data.Version = DumpVersion
 	return data, err
 }
@@ -839,6 +1459,26 @@ func Close() error {
 		availabilityTickerDone <- true
 		availabilityTicker = nil
 	}
+	if expirationTicker != nil {
+		expirationTicker.Stop()
+		expirationTickerDone <- true
+		expirationTicker = nil
+	}
+	if tempCredentialsTicker != nil {
+		tempCredentialsTicker.Stop()
+		tempCredentialsTickerDone <- true
+		tempCredentialsTicker = nil
+	}
+	if connectionHistoryTicker != nil {
+		connectionHistoryTicker.Stop()
+		connectionHistoryTickerDone <- true
+		connectionHistoryTicker = nil
+	}
+	if auditEventsTicker != nil {
+		auditEventsTicker.Stop()
+		auditEventsTickerDone <- true
+		auditEventsTicker = nil
+	}
 	return provider.close()
 }
 
@@ -858,9 +1498,14 @@ func createProvider(basePath string) error {
 		err = initializeBoltProvider(basePath)
 	} else if config.Driver == MemoryDataProviderName {
 		initializeMemoryProvider(basePath)
+	} else if config.Driver == RESTDataProviderName {
+		err = initializeRESTProvider(basePath)
 	} else {
 		err = fmt.Errorf("unsupported data provider: %v", config.Driver)
 	}
+	if err == nil {
+		provider = &timedProvider{Provider: provider}
+	}
 	return err
 }
 
@@ -1131,6 +1776,16 @@ func validateFileFilters(user *User) error {
 	return validateFiltersPatternExtensions(user)
 }
 
+func validateGroups(groups []string) ([]string, error) {
+	groups = utils.RemoveDuplicates(groups)
+	for _, group := range groups {
+		if !usernameRegex.MatchString(group) {
+			return nil, &ValidationError{err: fmt.Sprintf("group %#v is not valid, the following characters are allowed: a-zA-Z0-9-_.~", group)}
+		}
+	}
+	return groups, nil
+}
+
 func validateFilters(user *User) error {
 	if len(user.Filters.AllowedIP) == 0 {
 		user.Filters.AllowedIP = []string{}
@@ -1156,6 +1811,16 @@ func validateFilters(user *User) error {
 			return &ValidationError{err: fmt.Sprintf("could not parse allowed IP/Mask %#v : %v", IPMask, err)}
 		}
 	}
+	allowedCountries, err := geoip.ValidateCountries(user.Filters.AllowedCountries)
+	if err != nil {
+		return &ValidationError{err: fmt.Sprintf("invalid allowed countries: %v", err)}
+	}
+	user.Filters.AllowedCountries = allowedCountries
+	deniedCountries, err := geoip.ValidateCountries(user.Filters.DeniedCountries)
+	if err != nil {
+		return &ValidationError{err: fmt.Sprintf("invalid denied countries: %v", err)}
+	}
+	user.Filters.DeniedCountries = deniedCountries
 	if len(user.Filters.DeniedLoginMethods) >= len(ValidSSHLoginMethods) {
 		return &ValidationError{err: "invalid denied_login_methods"}
 	}
@@ -1172,9 +1837,144 @@ func validateFilters(user *User) error {
 			return &ValidationError{err: fmt.Sprintf("invalid protocol: %#v", p)}
 		}
 	}
+	groups, err := validateGroups(user.Filters.Groups)
+	if err != nil {
+		return err
+	}
+	user.Filters.Groups = groups
+	if err := validateTransferQuota(user); err != nil {
+		return err
+	}
+	if err := validateBandwidthLimits(user); err != nil {
+		return err
+	}
+	if user.Filters.MaxConcurrentTransfers < 0 {
+		return &ValidationError{err: fmt.Sprintf("invalid max concurrent transfers: %v", user.Filters.MaxConcurrentTransfers)}
+	}
+	if err := validateMaxSessionsPerProtocol(user); err != nil {
+		return err
+	}
+	if err := validatePartialUploads(user); err != nil {
+		return err
+	}
+	if err := validateDirectoryLimits(user); err != nil {
+		return err
+	}
+	if err := validateDataRetention(user); err != nil {
+		return err
+	}
+	if err := user.Filters.TOTPConfig.validate(user.Username, true); err != nil {
+		return err
+	}
+	if err := user.Filters.TempCredentials.validate(); err != nil {
+		return err
+	}
 	return validateFileFilters(user)
 }
 
+func validateTransferQuota(user *User) error {
+	quota := &user.Filters.TransferQuota
+	if quota.UploadSize < 0 || quota.DownloadSize < 0 {
+		return &ValidationError{err: "invalid transfer quota, negative sizes are not allowed"}
+	}
+	if !quota.HasLimits() {
+		quota.Period = ""
+		return nil
+	}
+	if quota.Period != TransferQuotaPeriodDay && quota.Period != TransferQuotaPeriodMonth {
+		return &ValidationError{err: fmt.Sprintf("invalid transfer quota period: %#v", quota.Period)}
+	}
+	return nil
+}
+
+func validatePartialUploads(user *User) error {
+	partialUploads := &user.Filters.PartialUploads
+	switch partialUploads.Policy {
+	case "", PartialUploadsDelete, PartialUploadsKeep:
+		partialUploads.QuarantinePath = ""
+	case PartialUploadsQuarantine:
+		if partialUploads.QuarantinePath == "" {
+			return &ValidationError{err: "a quarantine path is required for the quarantine policy"}
+		}
+		partialUploads.QuarantinePath = utils.CleanPath(partialUploads.QuarantinePath)
+		if partialUploads.QuarantinePath == "/" {
+			return &ValidationError{err: "the quarantine path cannot be the root directory"}
+		}
+	default:
+		return &ValidationError{err: fmt.Sprintf("invalid partial uploads policy: %#v", partialUploads.Policy)}
+	}
+	return nil
+}
+
+func validateDirectoryLimits(user *User) error {
+	paths := make(map[string]bool)
+	for idx := range user.Filters.DirectoryLimits {
+		limit := &user.Filters.DirectoryLimits[idx]
+		if limit.Path == "" {
+			return &ValidationError{err: fmt.Sprintf("no path defined for directory limit %v", idx)}
+		}
+		limit.Path = utils.CleanPath(limit.Path)
+		if _, err := path.Match(limit.Path, "/"); err != nil {
+			return &ValidationError{err: fmt.Sprintf("invalid path %#v for directory limit %v: %v", limit.Path, idx, err)}
+		}
+		if limit.MaxFiles <= 0 {
+			return &ValidationError{err: fmt.Sprintf("invalid max files for directory limit %#v: %v", limit.Path, limit.MaxFiles)}
+		}
+		if paths[limit.Path] {
+			return &ValidationError{err: fmt.Sprintf("duplicate directory limit for path %#v", limit.Path)}
+		}
+		paths[limit.Path] = true
+	}
+	return nil
+}
+
+func validateDataRetention(user *User) error {
+	paths := make(map[string]bool)
+	for idx := range user.Filters.DataRetention {
+		folder := &user.Filters.DataRetention[idx]
+		if err := folder.Validate(); err != nil {
+			return err
+		}
+		if paths[folder.Path] {
+			return &ValidationError{err: fmt.Sprintf("duplicate data retention for path %#v", folder.Path)}
+		}
+		paths[folder.Path] = true
+	}
+	return nil
+}
+
+func validateMaxSessionsPerProtocol(user *User) error {
+	for protocol, maxSessions := range user.Filters.MaxSessionsPerProtocol {
+		if !utils.IsStringInSlice(protocol, ValidProtocols) {
+			return &ValidationError{err: fmt.Sprintf("invalid protocol for max sessions: %#v", protocol)}
+		}
+		if maxSessions < 0 {
+			return &ValidationError{err: fmt.Sprintf("invalid max sessions for protocol %#v: %v", protocol, maxSessions)}
+		}
+		if maxSessions == 0 {
+			delete(user.Filters.MaxSessionsPerProtocol, protocol)
+		}
+	}
+	return nil
+}
+
+func validateBandwidthLimits(user *User) error {
+	for idx, limit := range user.Filters.BandwidthLimits {
+		if len(limit.Sources) == 0 {
+			return &ValidationError{err: fmt.Sprintf("no sources defined for bandwidth limit %v", idx)}
+		}
+		for _, source := range limit.Sources {
+			if _, _, err := net.ParseCIDR(source); err != nil {
+				return &ValidationError{err: fmt.Sprintf("could not parse bandwidth limit source %#v : %v", source, err)}
+			}
+		}
+		if limit.UploadBandwidth < 0 || limit.DownloadBandwidth < 0 {
+			return &ValidationError{err: fmt.Sprintf("invalid bandwidth limit %v, negative values are not allowed", idx)}
+		}
+	}
+	return nil
+}
+
 func saveGCSCredentials(user *User) error {
 	if user.FsConfig.Provider != GCSFilesystemProvider {
 		return nil
@@ -1306,7 +2106,7 @@ func validateBaseParams(user *User) error {
 
 func createUserPasswordHash(user *User) error {
 	if user.Password != "" && !user.IsPasswordHashed() {
-		pwd, err := argon2id.CreateHash(user.Password, argon2Params)
+		pwd, err := hashPlainPassword(user.Password)
 		if err != nil {
 			return err
 		}
@@ -1315,6 +2115,38 @@ func createUserPasswordHash(user *User) error {
 	return nil
 }
 
+// hashPlainPassword hashes the given password using the configured algorithm
+func hashPlainPassword(password string) (string, error) {
+	if config.PasswordHashing.Algo == HashingAlgoBcrypt {
+		pwd, err := bcrypt.GenerateFromPassword([]byte(password), config.PasswordHashing.BcryptOptions.Cost)
+		if err != nil {
+			return "", err
+		}
+		return string(pwd), nil
+	}
+	return argon2id.CreateHash(password, argon2Params)
+}
+
+// isHashedWithSupportedAlgo returns true if the given hash was generated using
+// one of the algorithms supported by hashPlainPassword
+func isHashedWithSupportedAlgo(hash string) bool {
+	return strings.HasPrefix(hash, argonPwdPrefix) || strings.HasPrefix(hash, bcryptPwdPrefix)
+}
+
+// compareSupportedAlgoHash compares a plain password with a hash generated by hashPlainPassword
+func compareSupportedAlgoHash(password, hash string) (bool, error) {
+	if strings.HasPrefix(hash, bcryptPwdPrefix) {
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+	return argon2id.ComparePasswordAndHash(password, hash)
+}
+
 // ValidateFolder returns an error if the folder is not valid
 // FIXME: this should be defined as Folder struct method
 func ValidateFolder(folder *vfs.BaseVirtualFolder) error {
@@ -1336,6 +2168,7 @@ func ValidateFolder(folder *vfs.BaseVirtualFolder) error {
 // ValidateUser returns an error if the user is not valid
 // FIXME: this should be defined as User struct method
 func ValidateUser(user *User) error {
+	user.Username = convertUsername(user.Username)
 	user.SetEmptySecretsIfNil()
 	buildUserHomeDir(user)
 	if err := validateBaseParams(user); err != nil {
@@ -1372,10 +2205,13 @@ func checkLoginConditions(user *User) error {
 	if user.Status < 1 {
 		return fmt.Errorf("user %#v is disabled", user.Username)
 	}
-	if user.ExpirationDate > 0 && user.ExpirationDate < utils.GetTimeAsMsSinceEpoch(time.Now()) {
+	if user.IsExpired() {
 		return fmt.Errorf("user %#v is expired, expiration timestamp: %v current timestamp: %v", user.Username,
 			user.ExpirationDate, utils.GetTimeAsMsSinceEpoch(time.Now()))
 	}
+	if user.Filters.TempCredentials.IsTemporary() {
+		return checkTempCredentials(user)
+	}
 	return nil
 }
 
@@ -1404,11 +2240,39 @@ func isPasswordOK(user *User, password string) (bool, error) {
 		if err != nil {
 			return match, err
 		}
+	} else if utils.IsStringPrefixInSlice(user.Password, ldapPwdPrefixes) {
+		match, err = compareLDAPPasswordAndHash(password, user.Password)
+		if err != nil {
+			return match, err
+		}
+	} else if utils.IsStringPrefixInSlice(user.Password, djangoPwdPrefixes) {
+		// Django uses the same format as pbkdf2 with a different prefix, for example:
+		// pbkdf2_sha256$<iterations>$<salt>$<hashed pwd base64 encoded>
+		match, err = comparePbkdf2PasswordAndHash(password, "$"+strings.Replace(user.Password, "_", "-", 1))
+		if err != nil {
+			return match, err
+		}
 	}
 	return match, err
 }
 
 func checkUserAndPass(user *User, password, ip, protocol string) (User, error) {
+	if !user.Filters.TOTPConfig.isRequiredForProtocol(protocol) {
+		return checkUserPassword(user, password, ip, protocol)
+	}
+	password, recoveryCode, err := splitPasswordAndPasscode(user, password)
+	if err != nil {
+		providerLog(logger.LevelDebug, "invalid passcode for user %#v, protocol %v: %v", user.Username, protocol, err)
+		return *user, ErrInvalidCredentials
+	}
+	u, err := checkUserPassword(user, password, ip, protocol)
+	if err != nil || recoveryCode == "" {
+		return u, err
+	}
+	return u, useUserRecoveryCode(&u, recoveryCode)
+}
+
+func checkUserPassword(user *User, password, ip, protocol string) (User, error) {
 	err := checkLoginConditions(user)
 	if err != nil {
 		return *user, err
@@ -1522,6 +2386,38 @@ func comparePbkdf2PasswordAndHash(password, hashedPassword string) (bool, error)
 	return subtle.ConstantTimeCompare(df, expected) == 1, nil
 }
 
+// compareLDAPPasswordAndHash compares a password with a salted SHA hash in the format
+// used by OpenLDAP: {SSHA}<base64 encoded digest+salt>
+func compareLDAPPasswordAndHash(password, hashedPassword string) (bool, error) {
+	var hashFunc func() hash.Hash
+	var encoded string
+	if strings.HasPrefix(hashedPassword, ldapSSHAPwdPrefix) {
+		hashFunc = sha1.New
+		encoded = strings.TrimPrefix(hashedPassword, ldapSSHAPwdPrefix)
+	} else if strings.HasPrefix(hashedPassword, ldapSSHA256PwdPrefix) {
+		hashFunc = sha256.New
+		encoded = strings.TrimPrefix(hashedPassword, ldapSSHA256PwdPrefix)
+	} else if strings.HasPrefix(hashedPassword, ldapSSHA512PwdPrefix) {
+		hashFunc = sha512.New
+		encoded = strings.TrimPrefix(hashedPassword, ldapSSHA512PwdPrefix)
+	} else {
+		return false, errors.New("ldap: invalid or unsupported hash format")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false, err
+	}
+	h := hashFunc()
+	if len(decoded) <= h.Size() {
+		return false, errors.New("ldap: hash is not in the correct format")
+	}
+	expected := decoded[:h.Size()]
+	salt := decoded[h.Size():]
+	h.Write([]byte(password)) //nolint:errcheck
+	h.Write(salt)             //nolint:errcheck
+	return subtle.ConstantTimeCompare(h.Sum(nil), expected) == 1, nil
+}
+
 func addCredentialsToUser(user *User) error {
 	if user.FsConfig.Provider != GCSFilesystemProvider {
 		return nil
@@ -1587,10 +2483,85 @@ func checkDataprovider() {
 	err := provider.checkAvailability()
 	if err != nil {
 		providerLog(logger.LevelWarn, "check availability error: %v", err)
+		// notify only when the provider becomes unreachable and not for each failed check
+		if atomic.CompareAndSwapInt32(&isProviderUnavailable, 0, 1) {
+			go notifier.Notify(notifier.EventFatalError, "the data provider is unreachable", err)
+		}
+	} else if atomic.CompareAndSwapInt32(&isProviderUnavailable, 1, 0) {
+		providerLog(logger.LevelInfo, "the data provider is reachable again")
 	}
 	metrics.UpdateDataProviderAvailability(err)
 }
 
+func startExpirationTimer() {
+	if config.ExpiredUsersCheckInterval <= 0 {
+		return
+	}
+	expirationTicker = time.NewTicker(time.Duration(config.ExpiredUsersCheckInterval) * time.Minute)
+	expirationTickerDone = make(chan bool)
+	providerLog(logger.LevelDebug, "start expired users check, interval: %v minutes", config.ExpiredUsersCheckInterval)
+	go func() {
+		for {
+			select {
+			case <-expirationTickerDone:
+				return
+			case <-expirationTicker.C:
+				disableExpiredUsers()
+			}
+		}
+	}()
+}
+
+// disableExpiredUsers disables the users with an expiration date in the past
+// and, if configured, the users that have not logged in for too long
+func disableExpiredUsers() {
+	var expired []string
+	offset := 0
+	for {
+		users, err := provider.getUsers(expirationCheckLimit, offset, OrderASC)
+		if err != nil {
+			providerLog(logger.LevelWarn, "unable to get users to check for expiration: %v", err)
+			return
+		}
+		for idx := range users {
+			if users[idx].Status == 1 && isUserToDisable(&users[idx]) {
+				expired = append(expired, users[idx].Username)
+			}
+		}
+		if len(users) < expirationCheckLimit {
+			break
+		}
+		offset += len(users)
+	}
+
+	for _, username := range expired {
+		user, err := provider.userExists(username)
+		if err != nil {
+			providerLog(logger.LevelWarn, "unable to get expired user %#v: %v", username, err)
+			continue
+		}
+		if user.Status != 1 || !isUserToDisable(&user) {
+			continue
+		}
+		user.Status = 0
+		if err := UpdateUser(&user); err != nil {
+			providerLog(logger.LevelWarn, "unable to disable expired user %#v: %v", username, err)
+			continue
+		}
+		if user.IsExpired() {
+			providerLog(logger.LevelInfo, "user %#v expired at %v and is now disabled", username,
+				utils.GetTimeFromMsecSinceEpoch(user.ExpirationDate).UTC().Format(time.RFC3339))
+		} else {
+			providerLog(logger.LevelInfo, "user %#v last logged in at %v and is now disabled for inactivity", username,
+				utils.GetTimeFromMsecSinceEpoch(user.LastLogin).UTC().Format(time.RFC3339))
+		}
+	}
+}
+
+func isUserToDisable(user *User) bool {
+	return user.IsExpired() || user.IsInactive(config.DisableInactiveUsersAfter)
+}
+
 func terminateInteractiveAuthProgram(cmd *exec.Cmd, isFinished bool) {
 	if isFinished {
 		return
@@ -1625,16 +2596,21 @@ func sendKeyboardAuthHTTPReq(url *url.URL, request keyboardAuthHookRequest) (key
 		providerLog(logger.LevelWarn, "error serializing keyboard interactive auth request: %v", err)
 		return response, err
 	}
+	startTime := time.Now()
 	resp, err := httpClient.Post(url.String(), "application/json", bytes.NewBuffer(reqAsJSON))
 	if err != nil {
+		diagnostics.HookExecuted(diagnostics.HookKeyboardAuth, time.Since(startTime), err)
 		providerLog(logger.LevelWarn, "error getting keyboard interactive auth hook HTTP response: %v", err)
 		return response, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return response, fmt.Errorf("wrong keyboard interactive auth http status code: %v, expected 200", resp.StatusCode)
+		err = fmt.Errorf("wrong keyboard interactive auth http status code: %v, expected 200", resp.StatusCode)
+		diagnostics.HookExecuted(diagnostics.HookKeyboardAuth, time.Since(startTime), err)
+		return response, err
 	}
 	err = render.DecodeJSON(resp.Body, &response)
+	diagnostics.HookExecuted(diagnostics.HookKeyboardAuth, time.Since(startTime), err)
 	return response, err
 }
 
@@ -1782,10 +2758,51 @@ func executeKeyboardInteractiveProgram(user *User, authHook string, client ssh.K
 	return authResult, err
 }
 
+// doBuiltinKeyboardInteractiveAuth asks for the user password and for the
+// TOTP passcode, if TOTP is enabled for the user
+func doBuiltinKeyboardInteractiveAuth(user *User, client ssh.KeyboardInteractiveChallenge, ip, protocol string) (int, error) {
+	// the built-in keyboard interactive authentication asks for the password so it must be allowed
+	if !user.IsLoginMethodAllowed(LoginMethodPassword, nil) {
+		return 0, fmt.Errorf("login method %#v is not allowed for user %#v", LoginMethodPassword, user.Username)
+	}
+	answers, err := client(user.Username, "", []string{"Password: "}, []bool{false})
+	if err != nil {
+		return 0, err
+	}
+	if len(answers) != 1 {
+		return 0, fmt.Errorf("unexpected number of answers: %v", len(answers))
+	}
+	if _, err := checkUserPassword(user, answers[0], ip, protocol); err != nil {
+		return 0, err
+	}
+	if !user.Filters.TOTPConfig.isRequiredForProtocol(protocol) {
+		return 1, nil
+	}
+	answers, err = client(user.Username, "", []string{"Authentication code: "}, []bool{false})
+	if err != nil {
+		return 0, err
+	}
+	if len(answers) != 1 {
+		return 0, fmt.Errorf("unexpected number of answers: %v", len(answers))
+	}
+	recoveryCode, err := checkUserPasscode(user, answers[0])
+	if err != nil {
+		return 0, err
+	}
+	if recoveryCode != "" {
+		if err := useUserRecoveryCode(user, recoveryCode); err != nil {
+			return 0, err
+		}
+	}
+	return 1, nil
+}
+
 func doKeyboardInteractiveAuth(user *User, authHook string, client ssh.KeyboardInteractiveChallenge, ip, protocol string) (User, error) {
 	var authResult int
 	var err error
-	if strings.HasPrefix(authHook, "http") {
+	if authHook == "" {
+		authResult, err = doBuiltinKeyboardInteractiveAuth(user, client, ip, protocol)
+	} else if strings.HasPrefix(authHook, "http") {
 		authResult, err = executeKeyboardInteractiveHTTPHook(user, authHook, client, ip, protocol)
 	} else {
 		authResult, err = executeKeyboardInteractiveProgram(user, authHook, client, ip, protocol)
@@ -1804,7 +2821,7 @@ func doKeyboardInteractiveAuth(user *User, authHook string, client ssh.KeyboardI
 }
 
 func isCheckPasswordHookDefined(protocol string) bool {
-	if config.CheckPasswordHook == "" {
+	if getHooks().checkPassword == "" {
 		return false
 	}
 	if config.CheckPasswordScope == 0 {
@@ -1823,12 +2840,13 @@ func isCheckPasswordHookDefined(protocol string) bool {
 }
 
 func getPasswordHookResponse(username, password, ip, protocol string) ([]byte, error) {
-	if strings.HasPrefix(config.CheckPasswordHook, "http") {
+	hook := getHooks().checkPassword
+	if strings.HasPrefix(hook, "http") {
 		var result []byte
 		var url *url.URL
-		url, err := url.Parse(config.CheckPasswordHook)
+		url, err := url.Parse(hook)
 		if err != nil {
-			providerLog(logger.LevelWarn, "invalid url for check password hook %#v, error: %v", config.CheckPasswordHook, err)
+			providerLog(logger.LevelWarn, "invalid url for check password hook %#v, error: %v", hook, err)
 			return result, err
 		}
 		req := checkPasswordRequest{
@@ -1855,7 +2873,7 @@ func getPasswordHookResponse(username, password, ip, protocol string) ([]byte, e
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, config.CheckPasswordHook)
+	cmd := exec.CommandContext(ctx, hook)
 	cmd.Env = append(os.Environ(),
 		fmt.Sprintf("SFTPGO_AUTHD_USERNAME=%v", username),
 		fmt.Sprintf("SFTPGO_AUTHD_PASSWORD=%v", password),
@@ -1873,7 +2891,9 @@ func executeCheckPasswordHook(username, password, ip, protocol string) (checkPas
 		return response, nil
 	}
 
+	startTime := time.Now()
 	out, err := getPasswordHookResponse(username, password, ip, protocol)
+	diagnostics.HookExecuted(diagnostics.HookCheckPassword, time.Since(startTime), err)
 	if err != nil {
 		return response, err
 	}
@@ -1882,18 +2902,20 @@ func executeCheckPasswordHook(username, password, ip, protocol string) (checkPas
 }
 
 func getPreLoginHookResponse(loginMethod, ip, protocol string, userAsJSON []byte) ([]byte, error) {
-	if strings.HasPrefix(config.PreLoginHook, "http") {
+	hook := getHooks().preLogin
+	if strings.HasPrefix(hook, "http") {
 		var url *url.URL
 		var result []byte
-		url, err := url.Parse(config.PreLoginHook)
+		url, err := url.Parse(hook)
 		if err != nil {
-			providerLog(logger.LevelWarn, "invalid url for pre-login hook %#v, error: %v", config.PreLoginHook, err)
+			providerLog(logger.LevelWarn, "invalid url for pre-login hook %#v, error: %v", hook, err)
 			return result, err
 		}
 		q := url.Query()
 		q.Add("login_method", loginMethod)
 		q.Add("ip", ip)
 		q.Add("protocol", protocol)
+		q.Add("country", geoip.GetCountry(ip))
 		url.RawQuery = q.Encode()
 		httpClient := httpclient.GetHTTPClient()
 		resp, err := httpClient.Post(url.String(), "application/json", bytes.NewBuffer(userAsJSON))
@@ -1912,12 +2934,13 @@ func getPreLoginHookResponse(loginMethod, ip, protocol string, userAsJSON []byte
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, config.PreLoginHook)
+	cmd := exec.CommandContext(ctx, hook)
 	cmd.Env = append(os.Environ(),
 		fmt.Sprintf("SFTPGO_LOGIND_USER=%v", string(userAsJSON)),
 		fmt.Sprintf("SFTPGO_LOGIND_METHOD=%v", loginMethod),
 		fmt.Sprintf("SFTPGO_LOGIND_IP=%v", ip),
 		fmt.Sprintf("SFTPGO_LOGIND_PROTOCOL=%v", protocol),
+		fmt.Sprintf("SFTPGO_LOGIND_COUNTRY=%v", geoip.GetCountry(ip)),
 	)
 	return cmd.Output()
 }
@@ -1937,7 +2960,9 @@ func executePreLoginHook(username, loginMethod, ip, protocol string) (User, erro
 	if err != nil {
 		return u, err
 	}
+	startTime := time.Now()
 	out, err := getPreLoginHookResponse(loginMethod, ip, protocol, userAsJSON)
+	diagnostics.HookExecuted(diagnostics.HookPreLogin, time.Since(startTime), err)
 	if err != nil {
 		return u, fmt.Errorf("Pre-login hook error: %v", err)
 	}
@@ -1955,6 +2980,10 @@ func executePreLoginHook(username, loginMethod, ip, protocol string) (User, erro
 	userUsedQuotaFiles := u.UsedQuotaFiles
 	userLastQuotaUpdate := u.LastQuotaUpdate
 	userLastLogin := u.LastLogin
+	userLastLoginProtocol := u.LastLoginProtocol
+	userUsedUploadDataTransfer := u.UsedUploadDataTransfer
+	userUsedDownloadDataTransfer := u.UsedDownloadDataTransfer
+	userDataTransferPeriodStart := u.DataTransferPeriodStart
 	err = json.Unmarshal(out, &u)
 	if err != nil {
 		return u, fmt.Errorf("Invalid pre-login hook response %#v, error: %v", string(out), err)
@@ -1964,10 +2993,22 @@ func executePreLoginHook(username, loginMethod, ip, protocol string) (User, erro
 	u.UsedQuotaFiles = userUsedQuotaFiles
 	u.LastQuotaUpdate = userLastQuotaUpdate
 	u.LastLogin = userLastLogin
+	u.LastLoginProtocol = userLastLoginProtocol
+	u.UsedUploadDataTransfer = userUsedUploadDataTransfer
+	u.UsedDownloadDataTransfer = userUsedDownloadDataTransfer
+	u.DataTransferPeriodStart = userDataTransferPeriodStart
 	if userID == 0 {
 		err = provider.addUser(&u)
+		if err == nil {
+			executeWebhooks(AuditActionAdd, AuditObjectUser, u.Username, nil)
+		}
 	} else {
+		previous := getWebhookPreviousObject(AuditActionUpdate, AuditObjectUser, u.Username)
 		err = provider.updateUser(&u)
+		if err == nil {
+			RemoveCachedUser(u.Username)
+			executeWebhooks(AuditActionUpdate, AuditObjectUser, u.Username, previous)
+		}
 	}
 	if err != nil {
 		return u, err
@@ -1981,7 +3022,12 @@ func executePreLoginHook(username, loginMethod, ip, protocol string) (User, erro
 
 // ExecutePostLoginHook executes the post login hook if defined
 func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err error) {
-	if config.PostLoginHook == "" {
+	if err != nil {
+		addLoginFailedEvent(user.Username, ip, protocol, AuditObjectUser,
+			fmt.Sprintf("login method %v: %v", loginMethod, err))
+	}
+	hook := getHooks().postLogin
+	if hook == "" {
 		return
 	}
 	if config.PostLoginScope == 1 && err == nil {
@@ -2003,11 +3049,12 @@ func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err erro
 			providerLog(logger.LevelWarn, "error serializing user in post login hook: %v", err)
 			return
 		}
-		if strings.HasPrefix(config.PostLoginHook, "http") {
+		country := geoip.GetCountry(ip)
+		if strings.HasPrefix(hook, "http") {
 			var url *url.URL
-			url, err := url.Parse(config.PostLoginHook)
+			url, err := url.Parse(hook)
 			if err != nil {
-				providerLog(logger.LevelDebug, "Invalid post-login hook %#v", config.PostLoginHook)
+				providerLog(logger.LevelDebug, "Invalid post-login hook %#v", hook)
 				return
 			}
 			q := url.Query()
@@ -2015,6 +3062,7 @@ func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err erro
 			q.Add("ip", ip)
 			q.Add("protocol", protocol)
 			q.Add("status", status)
+			q.Add("country", country)
 			url.RawQuery = q.Encode()
 
 			startTime := time.Now()
@@ -2025,32 +3073,36 @@ func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err erro
 				respCode = resp.StatusCode
 				resp.Body.Close()
 			}
+			diagnostics.HookExecuted(diagnostics.HookPostLogin, time.Since(startTime), getHTTPHookError(respCode, err))
 			providerLog(logger.LevelDebug, "post login hook executed, response code: %v, elapsed: %v err: %v",
 				respCode, time.Since(startTime), err)
 			return
 		}
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
-		cmd := exec.CommandContext(ctx, config.PostLoginHook)
+		cmd := exec.CommandContext(ctx, hook)
 		cmd.Env = append(os.Environ(),
 			fmt.Sprintf("SFTPGO_LOGIND_USER=%v", string(userAsJSON)),
 			fmt.Sprintf("SFTPGO_LOGIND_IP=%v", ip),
 			fmt.Sprintf("SFTPGO_LOGIND_METHOD=%v", loginMethod),
 			fmt.Sprintf("SFTPGO_LOGIND_STATUS=%v", status),
-			fmt.Sprintf("SFTPGO_LOGIND_PROTOCOL=%v", protocol))
+			fmt.Sprintf("SFTPGO_LOGIND_PROTOCOL=%v", protocol),
+			fmt.Sprintf("SFTPGO_LOGIND_COUNTRY=%v", country))
 		startTime := time.Now()
 		err = cmd.Run()
+		diagnostics.HookExecuted(diagnostics.HookPostLogin, time.Since(startTime), err)
 		providerLog(logger.LevelDebug, "post login hook executed, elapsed %v err: %v", time.Since(startTime), err)
 	}()
 }
 
 func getExternalAuthResponse(username, password, pkey, keyboardInteractive, ip, protocol string) ([]byte, error) {
-	if strings.HasPrefix(config.ExternalAuthHook, "http") {
+	hook := getHooks().externalAuth
+	if strings.HasPrefix(hook, "http") {
 		var url *url.URL
 		var result []byte
-		url, err := url.Parse(config.ExternalAuthHook)
+		url, err := url.Parse(hook)
 		if err != nil {
-			providerLog(logger.LevelWarn, "invalid url for external auth hook %#v, error: %v", config.ExternalAuthHook, err)
+			providerLog(logger.LevelWarn, "invalid url for external auth hook %#v, error: %v", hook, err)
 			return result, err
 		}
 		httpClient := httpclient.GetHTTPClient()
@@ -2079,7 +3131,7 @@ func getExternalAuthResponse(username, password, pkey, keyboardInteractive, ip,
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, config.ExternalAuthHook)
+	cmd := exec.CommandContext(ctx, hook)
 	cmd.Env = append(os.Environ(),
 		fmt.Sprintf("SFTPGO_AUTHD_USERNAME=%v", username),
 		fmt.Sprintf("SFTPGO_AUTHD_IP=%v", ip),
@@ -2100,7 +3152,9 @@ func doExternalAuth(username, password string, pubKey []byte, keyboardInteractiv
 		}
 		pkey = string(ssh.MarshalAuthorizedKey(k))
 	}
+	startTime := time.Now()
 	out, err := getExternalAuthResponse(username, password, pkey, keyboardInteractive, ip, protocol)
+	diagnostics.HookExecuted(diagnostics.HookExternalAuth, time.Since(startTime), err)
 	if err != nil {
 		return user, fmt.Errorf("External auth error: %v", err)
 	}
@@ -2128,13 +3182,23 @@ func doExternalAuth(username, password string, pubKey []byte, keyboardInteractiv
 		user.UsedQuotaFiles = u.UsedQuotaFiles
 		user.LastQuotaUpdate = u.LastQuotaUpdate
 		user.LastLogin = u.LastLogin
+		user.LastLoginProtocol = u.LastLoginProtocol
+		user.UsedUploadDataTransfer = u.UsedUploadDataTransfer
+		user.UsedDownloadDataTransfer = u.UsedDownloadDataTransfer
+		user.DataTransferPeriodStart = u.DataTransferPeriodStart
+		previous := getWebhookPreviousObject(AuditActionUpdate, AuditObjectUser, user.Username)
 		err = provider.updateUser(&user)
+		if err == nil {
+			RemoveCachedUser(user.Username)
+			executeWebhooks(AuditActionUpdate, AuditObjectUser, user.Username, previous)
+		}
 		return user, err
 	}
 	err = provider.addUser(&user)
 	if err != nil {
 		return user, err
 	}
+	executeWebhooks(AuditActionAdd, AuditObjectUser, user.Username, nil)
 	return provider.userExists(user.Username)
 }
 
@@ -2142,9 +3206,21 @@ func providerLog(level logger.LogLevel, format string, v ...interface{}) {
 	logger.Log(level, logSender, "", format, v...)
 }
 
-func executeNotificationCommand(operation string, commandArgs []string, userAsJSON []byte) error {
-	if !filepath.IsAbs(config.Actions.Hook) {
-		err := fmt.Errorf("invalid notification command %#v", config.Actions.Hook)
+// getHTTPHookError returns an error if the HTTP hook request failed or if the
+// response code is not 200
+func getHTTPHookError(respCode int, err error) error {
+	if err != nil {
+		return err
+	}
+	if respCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %v", respCode)
+	}
+	return nil
+}
+
+func executeNotificationCommand(hook, operation string, commandArgs []string, userAsJSON []byte) error {
+	if !filepath.IsAbs(hook) {
+		err := fmt.Errorf("invalid notification command %#v", hook)
 		logger.Warn(logSender, "", "unable to execute notification command: %v", err)
 		return err
 	}
@@ -2152,23 +3228,25 @@ func executeNotificationCommand(operation string, commandArgs []string, userAsJS
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, config.Actions.Hook, commandArgs...)
+	cmd := exec.CommandContext(ctx, hook, commandArgs...)
 	cmd.Env = append(os.Environ(),
 		fmt.Sprintf("SFTPGO_USER_ACTION=%v", operation),
 		fmt.Sprintf("SFTPGO_USER=%v", string(userAsJSON)))
 
 	startTime := time.Now()
 	err := cmd.Run()
+	diagnostics.HookExecuted(diagnostics.HookProviderActions, time.Since(startTime), err)
 	providerLog(logger.LevelDebug, "executed command %#v with arguments: %+v, elapsed: %v, error: %v",
-		config.Actions.Hook, commandArgs, time.Since(startTime), err)
+		hook, commandArgs, time.Since(startTime), err)
 	return err
 }
 
 func executeAction(operation string, user *User) {
+	hook := getHooks().actions
 	if !utils.IsStringInSlice(operation, config.Actions.ExecuteOn) {
 		return
 	}
-	if config.Actions.Hook == "" {
+	if hook == "" {
 		return
 	}
 
@@ -2188,11 +3266,11 @@ func executeAction(operation string, user *User) {
 			providerLog(logger.LevelWarn, "unable to serialize user as JSON for operation %#v: %v", operation, err)
 			return
 		}
-		if strings.HasPrefix(config.Actions.Hook, "http") {
+		if strings.HasPrefix(hook, "http") {
 			var url *url.URL
-			url, err := url.Parse(config.Actions.Hook)
+			url, err := url.Parse(hook)
 			if err != nil {
-				providerLog(logger.LevelWarn, "Invalid http_notification_url %#v for operation %#v: %v", config.Actions.Hook, operation, err)
+				providerLog(logger.LevelWarn, "Invalid http_notification_url %#v for operation %#v: %v", hook, operation, err)
 				return
 			}
 			q := url.Query()
@@ -2206,10 +3284,11 @@ func executeAction(operation string, user *User) {
 				respCode = resp.StatusCode
 				resp.Body.Close()
 			}
+			diagnostics.HookExecuted(diagnostics.HookProviderActions, time.Since(startTime), getHTTPHookError(respCode, err))
 			providerLog(logger.LevelDebug, "notified operation %#v to URL: %v status code: %v, elapsed: %v err: %v",
 				operation, url.String(), respCode, time.Since(startTime), err)
 		} else {
-			executeNotificationCommand(operation, user.getNotificationFieldsAsSlice(operation), userAsJSON) //nolint:errcheck // the error is used in test cases only
+			executeNotificationCommand(hook, operation, user.getNotificationFieldsAsSlice(operation), userAsJSON) //nolint:errcheck // the error is used in test cases only
 		}
 	}()
 }
@@ -2234,11 +3313,12 @@ func executeAction(operation string, user *User) {
 	}
 }*/
 
-func updateWebDavCachedUserLastLogin(username string) {
+func updateWebDavCachedUserLastLogin(username, protocol string) {
 	result, ok := webDAVUsersCache.Load(username)
 	if ok {
 		cachedUser := result.(*CachedUser)
 		cachedUser.User.LastLogin = utils.GetTimeAsMsSinceEpoch(time.Now())
+		cachedUser.User.LastLoginProtocol = protocol
 		webDAVUsersCache.Store(cachedUser.User.Username, cachedUser)
 	}
 }
@@ -2277,12 +3357,20 @@ func CacheWebDAVUser(cachedUser *CachedUser, maxSize int) {
 
 // GetCachedWebDAVUser returns a previously cached WebDAV user
 func GetCachedWebDAVUser(username string) (interface{}, bool) {
-	return webDAVUsersCache.Load(username)
+	return webDAVUsersCache.Load(convertUsername(username))
 }
 
 // RemoveCachedWebDAVUser removes a cached WebDAV user
 func RemoveCachedWebDAVUser(username string) {
 	if username != "" {
-		webDAVUsersCache.Delete(username)
+		webDAVUsersCache.Delete(convertUsername(username))
+	}
+}
+
+// convertUsername returns the username to use for storage and lookups
+func convertUsername(username string) string {
+	if config.CaseInsensitiveUsernames {
+		return strings.ToLower(username)
 	}
+	return username
 }
@@ -0,0 +1,564 @@
+package dataprovider
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger(filepath.Join(os.TempDir(), "dataprovider_test.log"), 5, 1, 28, false, 0)
+	err := Initialize(getTestConfig(), os.TempDir(), true)
+	if err != nil {
+		logger.ErrorToConsole("error initializing data provider: %v", err)
+		os.Exit(1)
+	}
+	httpConfig := httpclient.Config{
+		Timeout: 5,
+	}
+	httpConfig.Initialize(os.TempDir()) //nolint:errcheck
+	exitCode := m.Run()
+	Close() //nolint:errcheck
+	os.Exit(exitCode)
+}
+
+func getTestConfig() Config {
+	return Config{
+		Driver: MemoryDataProviderName,
+		PasswordHashing: PasswordHashing{
+			BcryptOptions: BcryptOptions{
+				Cost: 4,
+			},
+			Algo: HashingAlgoBcrypt,
+		},
+	}
+}
+
+func getTestUser(username string) User {
+	user := User{
+		Username: username,
+		Password: "password",
+		HomeDir:  filepath.Join(os.TempDir(), username),
+		Status:   1,
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{PermAny}
+	return user
+}
+
+func TestDisableExpiredUsers(t *testing.T) {
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+
+	expired := getTestUser("expired_user")
+	expired.ExpirationDate = utils.GetTimeAsMsSinceEpoch(time.Now().Add(-1 * time.Hour))
+	err := AddUser(&expired)
+	require.NoError(t, err)
+	valid := getTestUser("valid_user")
+	valid.ExpirationDate = utils.GetTimeAsMsSinceEpoch(time.Now().Add(1 * time.Hour))
+	err = AddUser(&valid)
+	require.NoError(t, err)
+	inactive := getTestUser("inactive_user")
+	err = AddUser(&inactive)
+	require.NoError(t, err)
+	p := provider.(*timedProvider).Provider.(*MemoryProvider)
+	p.dbHandle.Lock()
+	u := p.dbHandle.users[inactive.Username]
+	u.LastLogin = utils.GetTimeAsMsSinceEpoch(time.Now().Add(-48 * time.Hour))
+	p.dbHandle.users[inactive.Username] = u
+	p.dbHandle.Unlock()
+
+	disableExpiredUsers()
+
+	user, err := UserExists(expired.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, user.Status)
+	user, err = UserExists(valid.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, user.Status)
+	// inactive users are not disabled if not configured
+	user, err = UserExists(inactive.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, user.Status)
+
+	config.DisableInactiveUsersAfter = 1
+	disableExpiredUsers()
+	user, err = UserExists(inactive.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, user.Status)
+	user, err = UserExists(valid.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, user.Status)
+
+	for _, username := range []string{expired.Username, valid.Username, inactive.Username} {
+		err = DeleteUser(username)
+		assert.NoError(t, err)
+	}
+}
+
+func TestExpirationTimer(t *testing.T) {
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+
+	config.ExpiredUsersCheckInterval = 0
+	startExpirationTimer()
+	assert.Nil(t, expirationTicker)
+
+	config.ExpiredUsersCheckInterval = 1
+	startExpirationTimer()
+	require.NotNil(t, expirationTicker)
+	// speed up the check
+	expirationTicker.Reset(50 * time.Millisecond)
+
+	user := getTestUser("expiring_user")
+	user.ExpirationDate = utils.GetTimeAsMsSinceEpoch(time.Now().Add(-1 * time.Minute))
+	err := AddUser(&user)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		user, err := UserExists(user.Username)
+		return err == nil && user.Status == 0
+	}, 2*time.Second, 50*time.Millisecond)
+
+	expirationTicker.Stop()
+	expirationTickerDone <- true
+	expirationTicker = nil
+
+	err = DeleteUser(user.Username)
+	assert.NoError(t, err)
+}
+
+type testNetError struct {
+	timeout   bool
+	temporary bool
+}
+
+func (e *testNetError) Error() string   { return "test net error" }
+func (e *testNetError) Timeout() bool   { return e.timeout }
+func (e *testNetError) Temporary() bool { return e.temporary }
+
+func TestIsTransientProviderError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"nil", nil, false},
+		{"generic", errors.New("generic error"), false},
+		{"not found", &RecordNotFoundError{err: "not found"}, false},
+		{"no rows", sql.ErrNoRows, false},
+		{"permanent net error", &testNetError{}, false},
+		{"write op error", &net.OpError{Op: "write", Err: errors.New("broken pipe")}, false},
+		{"bad conn", driver.ErrBadConn, true},
+		{"wrapped bad conn", fmt.Errorf("wrapped: %w", driver.ErrBadConn), true},
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"net timeout", &testNetError{timeout: true}, true},
+		{"net temporary", &testNetError{temporary: true}, true},
+		{"read timeout", &net.OpError{Op: "read", Err: &testNetError{timeout: true}}, true},
+		{"dial failure", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("no route to host")}, true},
+		{"read failure", &net.OpError{Op: "read", Net: "tcp", Err: errors.New("unexpected EOF")}, true},
+		{"connection refused", syscall.ECONNREFUSED, true},
+		{"connection reset", syscall.ECONNRESET, true},
+		{"wrapped connection refused", &net.OpError{Op: "write", Err: &os.SyscallError{
+			Syscall: "connect", Err: syscall.ECONNREFUSED}}, true},
+		{"wrapped connection reset", fmt.Errorf("query failed: %w", &os.SyscallError{
+			Syscall: "read", Err: syscall.ECONNRESET}), true},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.transient, isTransientProviderError(tc.err), tc.name)
+	}
+}
+
+func TestExecuteWithAuthRetry(t *testing.T) {
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+
+	config.AuthRetries = 2
+	config.AuthRetryInterval = 1
+
+	calls := 0
+	err := executeWithAuthRetry(func() error {
+		calls++
+		if calls == 1 {
+			return driver.ErrBadConn
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	// retries exhausted
+	calls = 0
+	err = executeWithAuthRetry(func() error {
+		calls++
+		return &testNetError{timeout: true}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 3, calls)
+	// non transient errors are never retried
+	calls = 0
+	errAuth := errors.New("invalid credentials")
+	err = executeWithAuthRetry(func() error {
+		calls++
+		return errAuth
+	})
+	assert.ErrorIs(t, err, errAuth)
+	assert.Equal(t, 1, calls)
+	// retries disabled
+	config.AuthRetries = 0
+	calls = 0
+	err = executeWithAuthRetry(func() error {
+		calls++
+		return driver.ErrBadConn
+	})
+	assert.ErrorIs(t, err, driver.ErrBadConn)
+	assert.Equal(t, 1, calls)
+}
+
+func TestReloadHooks(t *testing.T) {
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+
+	cnf := getTestConfig()
+	cnf.PreLoginHook = "relative/path"
+	err := ReloadHooks(cnf)
+	assert.Error(t, err)
+	assert.Empty(t, getHooks().preLogin)
+	cnf.PreLoginHook = "http://127.0.0.1:8080/prelogin"
+	cnf.ExternalAuthHook = "http://127.0.0.1:8080/auth"
+	cnf.PostLoginHook = "http://127.0.0.1:8080/postlogin"
+	cnf.CheckPasswordHook = "http://127.0.0.1:8080/checkpwd"
+	cnf.Actions.Hook = "http://127.0.0.1:8080/actions"
+	cnf.UsersBaseDir = "/new/base/dir"
+	err = ReloadHooks(cnf)
+	require.NoError(t, err)
+	hooks := getHooks()
+	assert.Equal(t, cnf.PreLoginHook, hooks.preLogin)
+	assert.Equal(t, cnf.ExternalAuthHook, hooks.externalAuth)
+	assert.Equal(t, cnf.PostLoginHook, hooks.postLogin)
+	assert.Equal(t, cnf.CheckPasswordHook, hooks.checkPassword)
+	assert.Equal(t, cnf.Actions.Hook, hooks.actions)
+	// only the hooks are reloaded
+	assert.Equal(t, oldConfig.UsersBaseDir, config.UsersBaseDir)
+}
+
+func TestAPIKeyRestrictions(t *testing.T) {
+	r := APIKeyRestrictions{
+		Permissions: []string{" read_only", APIKeyPermQuota, "", APIKeyPermQuota},
+		Users:       []string{"user1 ", "user1"},
+		Groups:      []string{"group1", " group2"},
+	}
+	require.NoError(t, r.Validate())
+	assert.Equal(t, []string{APIKeyPermReadOnly, APIKeyPermQuota}, r.Permissions)
+	assert.Equal(t, []string{"user1"}, r.Users)
+	assert.Equal(t, []string{"group1", "group2"}, r.Groups)
+
+	perms := r.GetPermissions([]string{PermAdminViewUsers, PermAdminQuotaScans, PermAdminManageAdmins})
+	assert.Equal(t, []string{PermAdminViewUsers, PermAdminQuotaScans}, perms)
+	perms = r.GetPermissions([]string{PermAdminAny})
+	assert.Len(t, perms, 6)
+	assert.NotContains(t, perms, PermAdminAny)
+
+	groups, err := r.GetGroups(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, r.Groups, groups)
+	groups, err = r.GetGroups([]string{"group2", "group3"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"group2"}, groups)
+	_, err = r.GetGroups([]string{"group3"})
+	assert.Error(t, err)
+
+	r = APIKeyRestrictions{}
+	assert.True(t, r.IsEmpty())
+	assert.Equal(t, []string{PermAdminManageSystem}, r.GetPermissions([]string{PermAdminManageSystem}))
+	r.Permissions = []string{"invalid"}
+	assert.Error(t, r.Validate())
+}
+
+func TestProviderOperationError(t *testing.T) {
+	assert.False(t, isProviderOperationError(nil))
+	assert.False(t, isProviderOperationError(&RecordNotFoundError{err: "not found"}))
+	assert.False(t, isProviderOperationError(NewValidationError("invalid")))
+	assert.False(t, isProviderOperationError(&MethodDisabledError{err: "disabled"}))
+	assert.False(t, isProviderOperationError(ErrInvalidCredentials))
+	assert.True(t, isProviderOperationError(errors.New("connection refused")))
+	assert.Error(t, getHTTPHookError(http.StatusInternalServerError, nil))
+	assert.NoError(t, getHTTPHookError(http.StatusOK, nil))
+}
+
+func TestResetDatabase(t *testing.T) {
+	savedConfig := config
+	savedProvider := provider
+	defer func() {
+		config = savedConfig
+		provider = savedProvider
+	}()
+
+	for _, driver := range []string{SQLiteDataProviderName, BoltDataProviderName} {
+		dbPath := filepath.Join(os.TempDir(), driver+"_reset_test.db")
+		c := getTestConfig()
+		c.Driver = driver
+		c.Name = dbPath
+		err := InitializeDatabase(c, os.TempDir())
+		if err != nil {
+			require.ErrorIs(t, err, ErrNoInitRequired, driver)
+		}
+		admin := Admin{
+			Username:    "reset_admin",
+			Password:    "password",
+			Permissions: []string{PermAdminAny},
+			Status:      1,
+		}
+		err = provider.addAdmin(&admin)
+		require.NoError(t, err, driver)
+		_, err = provider.adminExists(admin.Username)
+		assert.NoError(t, err, driver)
+		err = provider.close()
+		assert.NoError(t, err, driver)
+
+		err = ResetDatabase(c, os.TempDir())
+		require.NoError(t, err, driver)
+		_, err = provider.adminExists(admin.Username)
+		var recordNotFoundError *RecordNotFoundError
+		assert.ErrorAs(t, err, &recordNotFoundError, driver)
+		assert.NoError(t, provider.checkDatabaseVersion(), driver)
+		// the structure is created again and can be used
+		err = provider.addAdmin(&admin)
+		assert.NoError(t, err, driver)
+
+		err = provider.close()
+		assert.NoError(t, err, driver)
+		err = os.Remove(dbPath)
+		assert.NoError(t, err, driver)
+	}
+
+	c := getTestConfig()
+	err := ResetDatabase(c, os.TempDir())
+	assert.Error(t, err)
+}
+
+func TestHashPassword(t *testing.T) {
+	hashing := PasswordHashing{
+		Algo: "md5",
+	}
+	_, err := HashPassword(hashing, "password")
+	assert.Error(t, err)
+	hashing.Algo = HashingAlgoBcrypt
+	_, err = HashPassword(hashing, "")
+	assert.Error(t, err)
+	hashing.BcryptOptions.Cost = 4
+	hash, err := HashPassword(hashing, "password")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, bcryptPwdPrefix))
+	match, err := compareSupportedAlgoHash("password", hash)
+	assert.NoError(t, err)
+	assert.True(t, match)
+
+	hashing.Algo = ""
+	_, err = HashPassword(hashing, "password")
+	assert.Error(t, err)
+	hashing.Argon2Options = Argon2Options{
+		Memory:      65536,
+		Iterations:  1,
+		Parallelism: 2,
+	}
+	hash, err = HashPassword(hashing, "password")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, argonPwdPrefix))
+	match, err = compareSupportedAlgoHash("password", hash)
+	assert.NoError(t, err)
+	assert.True(t, match)
+	match, err = compareSupportedAlgoHash("wrong", hash)
+	assert.NoError(t, err)
+	assert.False(t, match)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, recoveryCodes, err := GenerateRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, recoveryCodesNumber)
+	require.Len(t, recoveryCodes, recoveryCodesNumber)
+	for _, code := range recoveryCodes {
+		assert.True(t, isHashedWithSupportedAlgo(code.Hash))
+	}
+	assert.Equal(t, 1, getRecoveryCodeIndex(recoveryCodes, strings.ToUpper(codes[1])))
+	assert.Equal(t, -1, getRecoveryCodeIndex(recoveryCodes, "abcdefghij"))
+	assert.Equal(t, -1, getRecoveryCodeIndex(recoveryCodes, codes[1][1:]))
+	recoveryCodes[1].Used = true
+	assert.Equal(t, -1, getRecoveryCodeIndex(recoveryCodes, codes[1]))
+	// unsalted hashes are not accepted
+	recoveryCodes[2].Hash = "4e6f5dd7f2b1d2b2d2a9a2ab0d5e8b0c2a7b6f3e9c1d0f7a6b5c4d3e2f1a0b9c"
+	assert.Equal(t, -1, getRecoveryCodeIndex(recoveryCodes, codes[2]))
+}
+
+func TestUsedPasscodes(t *testing.T) {
+	cache := usedPasscodesCache{
+		passcodes: make(map[string]time.Time),
+	}
+	assert.True(t, cache.add("user_u1", "123456"))
+	assert.False(t, cache.add("user_u1", "123456"))
+	assert.True(t, cache.add("user_u2", "123456"))
+	assert.True(t, cache.add("admin_u1", "123456"))
+	assert.True(t, cache.add("user_u1", "654321"))
+	// expired passcodes are removed
+	cache.passcodes["user_u1\x00123456"] = time.Now().Add(-time.Second)
+	assert.True(t, cache.add("user_u1", "123456"))
+	assert.Len(t, cache.passcodes, 4)
+}
+
+func TestSearchUsers(t *testing.T) {
+	u1 := getTestUser("search_user_b")
+	u1.Filters.Groups = []string{"grp_1"}
+	u1.ExpirationDate = 300
+	u2 := getTestUser("search_user_a")
+	u2.Filters.Groups = []string{"grp_1", "grp.2"}
+	u2.ExpirationDate = 100
+	u3 := getTestUser("Search_User_C")
+	u3.Filters.Groups = []string{"grpA1"}
+	u3.ExpirationDate = 200
+	u3.Status = 0
+	for _, u := range []*User{&u1, &u2, &u3} {
+		err := AddUser(u)
+		require.NoError(t, err)
+	}
+	getUsernames := func(filters UserSearchFilters, limit, offset int, order string) []string {
+		users, err := SearchUsers(filters, limit, offset, order)
+		require.NoError(t, err)
+		var usernames []string
+		for _, user := range users {
+			usernames = append(usernames, user.Username)
+		}
+		return usernames
+	}
+
+	filters := NewUserSearchFilters()
+	filters.Search = " SEARCH_user "
+	assert.Equal(t, []string{u3.Username, u2.Username, u1.Username}, getUsernames(filters, 10, 0, OrderASC))
+	assert.Equal(t, []string{u2.Username}, getUsernames(filters, 1, 1, OrderASC))
+	assert.Equal(t, []string{u1.Username, u2.Username}, getUsernames(filters, 2, 0, OrderDESC))
+	assert.Len(t, getUsernames(filters, 10, 3, OrderASC), 0)
+	filters.SortField = UserSortFieldExpirationDate
+	assert.Equal(t, []string{u2.Username, u3.Username, u1.Username}, getUsernames(filters, 10, 0, OrderASC))
+	assert.Equal(t, []string{u3.Username, u2.Username}, getUsernames(filters, 10, 1, OrderDESC))
+	filters.Group = "grp_1"
+	assert.Equal(t, []string{u2.Username, u1.Username}, getUsernames(filters, 10, 0, OrderASC))
+	filters.Group = ""
+	filters.Groups = []string{"grpA1", "grp.2"}
+	assert.Equal(t, []string{u2.Username, u3.Username}, getUsernames(filters, 10, 0, OrderASC))
+	filters.Status = 1
+	assert.Equal(t, []string{u2.Username}, getUsernames(filters, 10, 0, OrderASC))
+	filters.Usernames = []string{u1.Username, u3.Username}
+	assert.Len(t, getUsernames(filters, 10, 0, OrderASC), 0)
+
+	filters = NewUserSearchFilters()
+	filters.SortField = "password"
+	_, err := SearchUsers(filters, 10, 0, OrderASC)
+	assert.Error(t, err)
+	filters.SortField = ""
+	filters.InactiveDays = -1
+	_, err = SearchUsers(filters, 10, 0, OrderASC)
+	assert.Error(t, err)
+
+	for _, u := range []*User{&u1, &u2, &u3} {
+		err = DeleteUser(u.Username)
+		assert.NoError(t, err)
+	}
+}
+
+func TestSearchUsersQuery(t *testing.T) {
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+
+	assert.Equal(t, "100!%!_!!a", escapeSQLLikePattern("100%_!a"))
+	assert.Equal(t, ",g1,g2,", getUserGroupsSearchValue(&User{Filters: UserFilters{Groups: []string{"g1", "g2"}}}))
+	assert.Empty(t, getUserGroupsSearchValue(&User{}))
+
+	filters := NewUserSearchFilters()
+	filters.Usernames = []string{"u1", "u2"}
+	filters.Groups = []string{"g1"}
+	filters.Group = "g2"
+	filters.Status = 1
+	filters.FsProvider = 0
+	filters.InactiveDays = 10
+	filters.Search = "u"
+	filters.SortField = UserSortFieldLastLogin
+	config.Driver = PGSQLDataProviderName
+	q, args := getSearchUsersQuery(&filters, 10, 5, OrderDESC)
+	assert.Len(t, args, 10)
+	assert.Contains(t, q, "username IN ($1,$2)")
+	assert.Contains(t, q, "ORDER BY last_login DESC,username DESC LIMIT $9 OFFSET $10")
+	assert.Equal(t, "%,g2,%", args[3])
+	assert.Equal(t, "%u%", args[7])
+
+	config.Driver = SQLiteDataProviderName
+	q, args = getSearchUsersQuery(&filters, 10, 5, OrderASC)
+	assert.Len(t, args, 10)
+	assert.Equal(t, 10, strings.Count(q, "?"))
+	assert.Contains(t, q, "ORDER BY last_login ASC,username ASC LIMIT ? OFFSET ?")
+}
+
+func TestShareTokensConcurrency(t *testing.T) {
+	user := getTestUser("share_tokens_user")
+	err := AddUser(&user)
+	require.NoError(t, err)
+	share := Share{
+		Name:      "tokens",
+		Scope:     ShareScopeRead,
+		Paths:     []string{"/"},
+		Username:  user.Username,
+		MaxTokens: 3,
+	}
+	err = AddShare(&share)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	var used, usedUp int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := UpdateShareLastUse(&share, 1)
+			if err == nil {
+				atomic.AddInt32(&used, 1)
+			} else if errors.Is(err, ErrShareUsedUp) {
+				atomic.AddInt32(&usedUp, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), used)
+	assert.Equal(t, int32(7), usedUp)
+	share, err = ShareExists(share.ShareID, "")
+	require.NoError(t, err)
+	assert.Equal(t, 3, share.UsedTokens)
+
+	err = DeleteUser(user.Username)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,55 @@
+package dataprovider
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// QueuedEvent defines an event notification waiting to be delivered.
+// The payload is opaque for the data provider
+type QueuedEvent struct {
+	ID      int64  `json:"id"`
+	Payload string `json:"payload"`
+	// number of failed delivery attempts
+	Attempts int `json:"attempts"`
+	// creation time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+	// the event will not be delivered before this time, unix timestamp in milliseconds
+	NextAttemptAt int64 `json:"next_attempt_at"`
+}
+
+// AddQueuedEvent adds a new event, with the given payload, to the persistent
+// queue. The event is immediately available for delivery
+func AddQueuedEvent(payload string) (QueuedEvent, error) {
+	if payload == "" {
+		return QueuedEvent{}, &ValidationError{err: "the event payload is mandatory"}
+	}
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	event := QueuedEvent{
+		Payload:       payload,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+	err := provider.addQueuedEvent(&event)
+	return event, err
+}
+
+// GetQueuedEvents returns at most limit events ready for delivery, in insertion order
+func GetQueuedEvents(limit int) ([]QueuedEvent, error) {
+	return provider.getQueuedEvents(limit, utils.GetTimeAsMsSinceEpoch(time.Now()))
+}
+
+// UpdateQueuedEvent updates the delivery attempts and the next attempt time for the given event
+func UpdateQueuedEvent(event *QueuedEvent) error {
+	if event.Attempts < 0 {
+		return &ValidationError{err: fmt.Sprintf("invalid attempts: %v", event.Attempts)}
+	}
+	return provider.updateQueuedEvent(event)
+}
+
+// DeleteQueuedEvent removes the event with the given id from the queue
+func DeleteQueuedEvent(id int64) error {
+	return provider.deleteQueuedEvent(id)
+}
@@ -0,0 +1,191 @@
+package dataprovider
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+)
+
+// decryptedSecret defines a stored secret decrypted using the old KMS configuration
+type decryptedSecret struct {
+	target         **kms.Secret
+	payload        string
+	additionalData string
+}
+
+// ReencryptSecrets decrypts the secrets stored inside the data provider using the
+// old KMS configuration and encrypts them using the new one, this way the master
+// key or the KMS provider can be changed. All the secrets are decrypted before
+// saving any change, so nothing is modified if a secret cannot be decrypted.
+// The re-encrypted secrets are saved directly, inside a single transaction if
+// the data provider supports it, without validating the objects and without
+// executing actions, hooks and webhooks.
+// The secrets already encrypted using the new configuration are skipped, so the
+// operation can be safely repeated if it is interrupted.
+// The KMS is left configured using the new configuration.
+// It returns the number of updated users and admins
+func ReencryptSecrets(oldConf, newConf kms.Configuration) (int, int, error) {
+	users, err := provider.dumpUsers()
+	if err != nil {
+		return 0, 0, err
+	}
+	admins, err := provider.dumpAdmins()
+	if err != nil {
+		return 0, 0, err
+	}
+	usersSecrets := make([][]decryptedSecret, len(users))
+	for idx := range users {
+		usersSecrets[idx], err = decryptSecretsForRotation(getUserSecretsForRotation(&users[idx]), &oldConf, &newConf)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unable to decrypt the secrets for user %#v: %w", users[idx].Username, err)
+		}
+	}
+	adminsSecrets := make([][]decryptedSecret, len(admins))
+	for idx := range admins {
+		secrets := []**kms.Secret{&admins[idx].Filters.TOTPConfig.Secret}
+		adminsSecrets[idx], err = decryptSecretsForRotation(secrets, &oldConf, &newConf)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unable to decrypt the secrets for admin %#v: %w", admins[idx].Username, err)
+		}
+	}
+	if err := newConf.Initialize(); err != nil {
+		return 0, 0, err
+	}
+	var updatedUsers []User
+	// GCS credentials stored in files, they are saved after updating the data provider
+	var gcsFileUsers []User
+	for idx := range users {
+		if len(usersSecrets[idx]) == 0 {
+			continue
+		}
+		if err := encryptSecretsForRotation(usersSecrets[idx]); err != nil {
+			return 0, 0, fmt.Errorf("unable to encrypt the secrets for user %#v: %w", users[idx].Username, err)
+		}
+		user := users[idx]
+		if user.FsConfig.Provider == GCSFilesystemProvider && !config.PreferDatabaseCredentials {
+			gcsFileUsers = append(gcsFileUsers, user.getACopy())
+			user.FsConfig.GCSConfig.Credentials = kms.NewEmptySecret()
+		}
+		updatedUsers = append(updatedUsers, user)
+	}
+	var updatedAdmins []Admin
+	for idx := range admins {
+		if len(adminsSecrets[idx]) == 0 {
+			continue
+		}
+		if err := encryptSecretsForRotation(adminsSecrets[idx]); err != nil {
+			return 0, 0, fmt.Errorf("unable to encrypt the secrets for admin %#v: %w", admins[idx].Username, err)
+		}
+		updatedAdmins = append(updatedAdmins, admins[idx])
+	}
+	if len(updatedUsers) == 0 && len(updatedAdmins) == 0 {
+		return 0, 0, nil
+	}
+	if err := provider.updateSecrets(updatedUsers, updatedAdmins); err != nil {
+		return 0, 0, fmt.Errorf("unable to save the re-encrypted secrets: %w", err)
+	}
+	for idx := range gcsFileUsers {
+		if err := saveGCSCredentials(&gcsFileUsers[idx]); err != nil {
+			return len(updatedUsers), len(updatedAdmins), fmt.Errorf("unable to save the GCS credentials for user %#v: %w",
+				gcsFileUsers[idx].Username, err)
+		}
+	}
+	for idx := range updatedUsers {
+		RemoveCachedUser(updatedUsers[idx].Username)
+		providerLog(logger.LevelInfo, "secrets re-encrypted for user %#v", updatedUsers[idx].Username)
+	}
+	for idx := range updatedAdmins {
+		providerLog(logger.LevelInfo, "secrets re-encrypted for admin %#v", updatedAdmins[idx].Username)
+	}
+	return len(updatedUsers), len(updatedAdmins), nil
+}
+
+// getUserSecretsForRotation returns the secrets used by the user, the secrets for
+// the unused filesystem providers are not saved and so they are not returned
+func getUserSecretsForRotation(user *User) []**kms.Secret {
+	secrets := []**kms.Secret{&user.Filters.TOTPConfig.Secret}
+	switch user.FsConfig.Provider {
+	case S3FilesystemProvider:
+		secrets = append(secrets, &user.FsConfig.S3Config.AccessSecret)
+	case GCSFilesystemProvider:
+		secrets = append(secrets, &user.FsConfig.GCSConfig.Credentials)
+	case AzureBlobFilesystemProvider:
+		secrets = append(secrets, &user.FsConfig.AzBlobConfig.AccountKey)
+	case CryptedFilesystemProvider:
+		secrets = append(secrets, &user.FsConfig.CryptConfig.Passphrase)
+	case SFTPFilesystemProvider:
+		secrets = append(secrets, &user.FsConfig.SFTPConfig.Password, &user.FsConfig.SFTPConfig.PrivateKey)
+	}
+	return secrets
+}
+
+func decryptSecretsForRotation(secrets []**kms.Secret, oldConf, newConf *kms.Configuration) ([]decryptedSecret, error) {
+	var result []decryptedSecret
+	for _, target := range secrets {
+		secret := *target
+		if secret == nil || !secret.IsEncrypted() {
+			continue
+		}
+		decrypted, ok, err := decryptSecretForRotation(secret, oldConf, newConf)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		decrypted.target = target
+		result = append(result, decrypted)
+	}
+	return result, nil
+}
+
+// decryptSecretForRotation decrypts the given secret using the old configuration.
+// It returns false if the secret is already encrypted using the new configuration
+func decryptSecretForRotation(secret *kms.Secret, oldConf, newConf *kms.Configuration) (decryptedSecret, bool, error) {
+	var result decryptedSecret
+	// the secret provider is selected when the secret is loaded, so we
+	// reload the secret after initializing the wanted configuration
+	data, err := json.Marshal(secret)
+	if err != nil {
+		return result, false, err
+	}
+	if err := oldConf.Initialize(); err != nil {
+		return result, false, err
+	}
+	oldSecret := kms.NewEmptySecret()
+	if err := json.Unmarshal(data, oldSecret); err != nil {
+		return result, false, err
+	}
+	result.additionalData = oldSecret.GetAdditionalData()
+	decryptErr := oldSecret.Decrypt()
+	if decryptErr == nil {
+		result.payload = oldSecret.GetPayload()
+		return result, true, nil
+	}
+	if err := newConf.Initialize(); err != nil {
+		return result, false, err
+	}
+	newSecret := kms.NewEmptySecret()
+	if err := json.Unmarshal(data, newSecret); err != nil {
+		return result, false, err
+	}
+	if err := newSecret.Decrypt(); err == nil {
+		return result, false, nil
+	}
+	return result, false, decryptErr
+}
+
+// encryptSecretsForRotation encrypts the decrypted secrets using the configured KMS
+func encryptSecretsForRotation(secrets []decryptedSecret) error {
+	for _, s := range secrets {
+		secret := kms.NewPlainSecret(s.payload)
+		secret.SetAdditionalData(s.additionalData)
+		if err := secret.Encrypt(); err != nil {
+			return err
+		}
+		*s.target = secret
+	}
+	return nil
+}
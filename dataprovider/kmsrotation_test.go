@@ -0,0 +1,119 @@
+package dataprovider
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/kms"
+)
+
+func TestReencryptSecrets(t *testing.T) {
+	oldConf := kms.Configuration{}
+	require.NoError(t, oldConf.Initialize())
+	defer func() {
+		c := kms.Configuration{}
+		c.Initialize() //nolint:errcheck
+	}()
+
+	user := User{
+		Username:    "kms_rotation_user",
+		Password:    "password",
+		HomeDir:     filepath.Join(os.TempDir(), "kms_rotation_user"),
+		Status:      1,
+		Permissions: map[string][]string{"/": {PermAny}},
+	}
+	user.FsConfig.Provider = CryptedFilesystemProvider
+	user.FsConfig.CryptConfig.Passphrase = kms.NewPlainSecret("crypt passphrase")
+	err := AddUser(&user)
+	require.NoError(t, err)
+	// the GCS credentials are stored in a file
+	gcsUser := User{
+		Username:    "kms_rotation_gcs_user",
+		Password:    "password",
+		HomeDir:     filepath.Join(os.TempDir(), "kms_rotation_gcs_user"),
+		Status:      1,
+		Permissions: map[string][]string{"/": {PermAny}},
+	}
+	gcsUser.FsConfig.Provider = GCSFilesystemProvider
+	gcsUser.FsConfig.GCSConfig.Bucket = "bucket"
+	gcsUser.FsConfig.GCSConfig.Credentials = kms.NewPlainSecret(`{ "type": "service_account" }`)
+	err = AddUser(&gcsUser)
+	require.NoError(t, err)
+	admin := Admin{
+		Username:    "kms_rotation_admin",
+		Password:    "password",
+		Permissions: []string{PermAdminAny},
+		Status:      1,
+	}
+	admin.Filters.TOTPConfig = TOTPConfig{
+		Enabled: true,
+		Secret:  kms.NewPlainSecret("JBSWY3DPEHPK3PXP"),
+	}
+	err = AddAdmin(&admin)
+	require.NoError(t, err)
+
+	newConf := kms.Configuration{
+		Secrets: kms.Secrets{
+			MasterKeyString: "new master key",
+		},
+	}
+	numUsers, numAdmins, err := ReencryptSecrets(oldConf, newConf)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, numUsers, 1)
+	assert.GreaterOrEqual(t, numAdmins, 1)
+
+	user, err = UserExists(user.Username)
+	require.NoError(t, err)
+	assert.Equal(t, kms.SecretStatusSecretBox, user.FsConfig.CryptConfig.Passphrase.GetStatus())
+	assert.Equal(t, 1, user.FsConfig.CryptConfig.Passphrase.GetMode())
+	err = user.FsConfig.CryptConfig.Passphrase.Decrypt()
+	require.NoError(t, err)
+	assert.Equal(t, "crypt passphrase", user.FsConfig.CryptConfig.Passphrase.GetPayload())
+	admin, err = AdminExists(admin.Username)
+	require.NoError(t, err)
+	assert.Equal(t, 1, admin.Filters.TOTPConfig.Secret.GetMode())
+	err = admin.Filters.TOTPConfig.Secret.Decrypt()
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", admin.Filters.TOTPConfig.Secret.GetPayload())
+	credentials, err := ioutil.ReadFile(gcsUser.getGCSCredentialsFilePath())
+	require.NoError(t, err)
+	secret := kms.NewEmptySecret()
+	err = json.Unmarshal(credentials, secret)
+	require.NoError(t, err)
+	assert.Equal(t, 1, secret.GetMode())
+	err = secret.Decrypt()
+	require.NoError(t, err)
+	assert.Equal(t, `{ "type": "service_account" }`, secret.GetPayload())
+	// the secrets are already encrypted using the new configuration
+	numUsers, numAdmins, err = ReencryptSecrets(oldConf, newConf)
+	require.NoError(t, err)
+	assert.Equal(t, 0, numUsers)
+	assert.Equal(t, 0, numAdmins)
+	// the secrets cannot be decrypted using the old configuration
+	wrongConf := kms.Configuration{
+		Secrets: kms.Secrets{
+			MasterKeyString: "wrong master key",
+		},
+	}
+	_, _, err = ReencryptSecrets(wrongConf, oldConf)
+	assert.Error(t, err)
+	// nothing was changed
+	user, err = UserExists(user.Username)
+	require.NoError(t, err)
+	assert.Equal(t, 1, user.FsConfig.CryptConfig.Passphrase.GetMode())
+
+	err = DeleteUser(user.Username)
+	assert.NoError(t, err)
+	err = DeleteUser(gcsUser.Username)
+	assert.NoError(t, err)
+	err = os.Remove(gcsUser.getGCSCredentialsFilePath())
+	assert.NoError(t, err)
+	err = DeleteAdmin(admin.Username)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,379 @@
+package dataprovider
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	ldapDefaultUserFilter     = "(uid=%s)"
+	ldapDefaultGroupAttribute = "memberOf"
+	ldapDefaultDomainID       = "*"
+	ldapTimeout               = 15 * time.Second
+)
+
+// errLDAPUserNotManaged is returned if the SFTPGo user for an LDAP login exists and
+// it was not added by the same LDAP domain, the user must be authenticated as usual
+var errLDAPUserNotManaged = errors.New("the user is not managed by the LDAP domain")
+
+// LDAPAuthConfig defines the configuration for the built-in LDAP authentication
+type LDAPAuthConfig struct {
+	// Domains defines the LDAP domains to use for password authentication.
+	// Users authenticated against a domain are automatically added/updated
+	// inside the data provider
+	Domains []LDAPDomain `json:"domains" mapstructure:"domains"`
+}
+
+// LDAPPermissions defines the permissions to grant on a path to the
+// members of an LDAP group
+type LDAPPermissions struct {
+	// LDAP group distinguished name or common name. Empty means all the users
+	Group string `json:"group" mapstructure:"group"`
+	// Virtual path, "/" is the user home directory
+	Path string `json:"path" mapstructure:"path"`
+	// Permissions to grant
+	Permissions []string `json:"permissions" mapstructure:"permissions"`
+}
+
+// LDAPDomain defines the LDAP configuration for a domain
+type LDAPDomain struct {
+	// Domain name. Users can login using "user@name" or "name\user".
+	// An empty name defines the default domain used for usernames
+	// that don't match any other domain
+	Name string `json:"name" mapstructure:"name"`
+	// Prefix added to the LDAP username to build the SFTPGo username, so users
+	// with the same name in different domains don't share the same account.
+	// Default "<name>_" for named domains, empty for the default domain
+	UsernamePrefix string `json:"username_prefix" mapstructure:"username_prefix"`
+	// LDAP server URL, for example "ldap://ldap.example.com:389" or "ldaps://ldap.example.com:636"
+	URL string `json:"url" mapstructure:"url"`
+	// Upgrade the connection using StartTLS, for "ldap://" URLs
+	StartTLS bool `json:"start_tls" mapstructure:"start_tls"`
+	// Skip TLS certificate verification, for testing purpose only
+	SkipTLSVerify bool `json:"skip_tls_verify" mapstructure:"skip_tls_verify"`
+	// Distinguished name and password for the account used to search the users.
+	// Leave empty to search anonymously
+	BindDN       string `json:"bind_dn" mapstructure:"bind_dn"`
+	BindPassword string `json:"bind_password" mapstructure:"bind_password"`
+	// Base distinguished name for users search
+	BaseDN string `json:"base_dn" mapstructure:"base_dn"`
+	// Filter to search the users, "%s" is replaced with the escaped username
+	// without the domain. Default "(uid=%s)", for Active Directory you can
+	// use something like "(sAMAccountName=%s)"
+	UserFilter string `json:"user_filter" mapstructure:"user_filter"`
+	// Attribute containing the groups the user belongs to. Default "memberOf"
+	GroupAttribute string `json:"group_attribute" mapstructure:"group_attribute"`
+	// Optional attributes to read the home directory and the quota from.
+	// If empty or not defined for a user, existing values are preserved
+	HomeDirAttribute    string `json:"home_dir_attribute" mapstructure:"home_dir_attribute"`
+	QuotaSizeAttribute  string `json:"quota_size_attribute" mapstructure:"quota_size_attribute"`
+	QuotaFilesAttribute string `json:"quota_files_attribute" mapstructure:"quota_files_attribute"`
+	// Permissions to grant based on the group membership. Permissions for
+	// the same path from multiple matching groups are merged. A user without
+	// permissions for the root directory "/" cannot login
+	Permissions []LDAPPermissions `json:"permissions" mapstructure:"permissions"`
+}
+
+func (d *LDAPDomain) validate() error {
+	if d.Name == ldapDefaultDomainID {
+		return fmt.Errorf("%#v is not a valid domain name", d.Name)
+	}
+	if d.UsernamePrefix == "" && d.Name != "" {
+		d.UsernamePrefix = d.Name + "_"
+	}
+	if d.UsernamePrefix != "" && !usernameRegex.MatchString(d.UsernamePrefix) {
+		return fmt.Errorf("invalid username prefix %#v, the following characters are allowed: a-zA-Z0-9-_.~",
+			d.UsernamePrefix)
+	}
+	if d.URL == "" {
+		return errors.New("the LDAP URL is mandatory")
+	}
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return fmt.Errorf("invalid LDAP URL %#v: %v", d.URL, err)
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return fmt.Errorf("invalid LDAP URL %#v: unsupported scheme %#v", d.URL, u.Scheme)
+	}
+	if d.BaseDN == "" {
+		return errors.New("the LDAP base DN is mandatory")
+	}
+	if d.UserFilter == "" {
+		d.UserFilter = ldapDefaultUserFilter
+	}
+	if strings.Count(d.UserFilter, "%s") != 1 {
+		return fmt.Errorf("invalid LDAP user filter %#v, it must contain the %%s placeholder once", d.UserFilter)
+	}
+	if d.GroupAttribute == "" {
+		d.GroupAttribute = ldapDefaultGroupAttribute
+	}
+	for idx := range d.Permissions {
+		perm := &d.Permissions[idx]
+		perm.Path = utils.CleanPath(perm.Path)
+		if len(perm.Permissions) == 0 {
+			return fmt.Errorf("no permissions defined for the LDAP group %#v, path %#v", perm.Group, perm.Path)
+		}
+		for _, p := range perm.Permissions {
+			if !utils.IsStringInSlice(p, ValidPerms) {
+				return fmt.Errorf("invalid permission %#v for the LDAP group %#v", p, perm.Group)
+			}
+		}
+	}
+	return nil
+}
+
+// getID returns the identifier stored inside the users added by this domain
+func (d *LDAPDomain) getID() string {
+	if d.Name == "" {
+		return ldapDefaultDomainID
+	}
+	return d.Name
+}
+
+func (d *LDAPDomain) getAttributes() []string {
+	attributes := []string{d.GroupAttribute}
+	for _, attr := range []string{d.HomeDirAttribute, d.QuotaSizeAttribute, d.QuotaFilesAttribute} {
+		if attr != "" {
+			attributes = append(attributes, attr)
+		}
+	}
+	return attributes
+}
+
+// getPermissions returns the permissions for a user belonging to the given groups
+func (d *LDAPDomain) getPermissions(groups []string) map[string][]string {
+	permissions := make(map[string][]string)
+	for _, perm := range d.Permissions {
+		if perm.Group != "" && !isLDAPGroupMember(perm.Group, groups) {
+			continue
+		}
+		for _, p := range perm.Permissions {
+			if !utils.IsStringInSlice(p, permissions[perm.Path]) {
+				permissions[perm.Path] = append(permissions[perm.Path], p)
+			}
+		}
+	}
+	return permissions
+}
+
+func (d *LDAPDomain) dial() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: d.SkipTLSVerify, //nolint:gosec
+	}
+	if u, err := url.Parse(d.URL); err == nil {
+		tlsConfig.ServerName = u.Hostname()
+	}
+	conn, err := ldap.DialURL(d.URL, ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapTimeout)
+	if d.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// authenticate checks the given credentials and returns the LDAP entry for the user
+func (d *LDAPDomain) authenticate(username, password string) (*ldap.Entry, error) {
+	conn, err := d.dial()
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to the LDAP server: %v", err)
+	}
+	defer conn.Close()
+
+	if d.BindDN != "" {
+		err = conn.Bind(d.BindDN, d.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to bind to the LDAP server: %v", err)
+	}
+	searchRequest := ldap.NewSearchRequest(d.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(d.UserFilter, ldap.EscapeFilter(username)), d.getAttributes(), nil)
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to search the LDAP user: %v", err)
+	}
+	if len(result.Entries) != 1 {
+		providerLog(logger.LevelDebug, "LDAP search for user %#v returned %v entries, expected 1", username,
+			len(result.Entries))
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+	if err = conn.Bind(entry.DN, password); err != nil {
+		providerLog(logger.LevelDebug, "LDAP bind failed for user %#v: %v", username, err)
+		return nil, ErrInvalidCredentials
+	}
+	return entry, nil
+}
+
+func isLDAPGroupMember(group string, groups []string) bool {
+	for _, g := range groups {
+		if strings.EqualFold(g, group) {
+			return true
+		}
+		dn, err := ldap.ParseDN(g)
+		if err != nil || len(dn.RDNs) == 0 {
+			continue
+		}
+		for _, attr := range dn.RDNs[0].Attributes {
+			if strings.EqualFold(attr.Type, "cn") && strings.EqualFold(attr.Value, group) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func validateLDAPConfig() error {
+	names := make(map[string]bool)
+	prefixes := make(map[string]bool)
+	for idx := range config.LDAPAuth.Domains {
+		domain := &config.LDAPAuth.Domains[idx]
+		domain.Name = strings.ToLower(strings.TrimSpace(domain.Name))
+		if names[domain.Name] {
+			return fmt.Errorf("LDAP domain %#v is duplicated", domain.Name)
+		}
+		names[domain.Name] = true
+		if err := domain.validate(); err != nil {
+			return fmt.Errorf("invalid LDAP domain %#v: %v", domain.Name, err)
+		}
+		if prefixes[domain.UsernamePrefix] {
+			return fmt.Errorf("invalid LDAP domain %#v: username prefix %#v is duplicated", domain.Name,
+				domain.UsernamePrefix)
+		}
+		prefixes[domain.UsernamePrefix] = true
+	}
+	return nil
+}
+
+// getLDAPDomain returns the LDAP domain for the given login username and
+// the username to search within the domain. It returns nil if no domain matches
+func getLDAPDomain(username string) (*LDAPDomain, string) {
+	var defaultDomain *LDAPDomain
+	var domainName, user string
+
+	if idx := strings.LastIndex(username, "@"); idx > 0 {
+		user, domainName = username[:idx], username[idx+1:]
+	} else if idx := strings.Index(username, "\\"); idx > 0 {
+		domainName, user = username[:idx], username[idx+1:]
+	}
+	domainName = strings.ToLower(domainName)
+	for idx := range config.LDAPAuth.Domains {
+		domain := &config.LDAPAuth.Domains[idx]
+		if domain.Name == "" {
+			defaultDomain = domain
+			continue
+		}
+		if domainName != "" && domain.Name == domainName && user != "" {
+			return domain, user
+		}
+	}
+	if defaultDomain != nil {
+		return defaultDomain, username
+	}
+	return nil, ""
+}
+
+// doLDAPAuth authenticates the user against the given LDAP domain. The SFTPGo user
+// is named as the LDAP user with the domain username prefix. Existing users not
+// added by the same domain are never updated, errLDAPUserNotManaged is returned
+// for them so they can be authenticated as usual
+func doLDAPAuth(domain *LDAPDomain, ldapUsername, password string) (User, error) {
+	var user User
+	username := convertUsername(domain.UsernamePrefix + ldapUsername)
+	if password == "" {
+		return user, errors.New("Credentials cannot be null or empty")
+	}
+	u, err := provider.userExists(username)
+	exists := err == nil
+	if !exists {
+		if _, ok := err.(*RecordNotFoundError); !ok {
+			return user, err
+		}
+	} else if u.Filters.LDAPDomain != domain.getID() {
+		providerLog(logger.LevelDebug, "user %#v is not managed by the LDAP domain %#v", username, domain.Name)
+		return user, errLDAPUserNotManaged
+	}
+	entry, err := domain.authenticate(ldapUsername, password)
+	if err != nil {
+		providerLog(logger.LevelWarn, "LDAP authentication error for user %#v, domain %#v: %v", username,
+			domain.Name, err)
+		if err == ErrInvalidCredentials {
+			return user, err
+		}
+		return user, fmt.Errorf("LDAP auth error: %v", err)
+	}
+	permissions := domain.getPermissions(entry.GetAttributeValues(domain.GroupAttribute))
+	if _, ok := permissions["/"]; !ok {
+		providerLog(logger.LevelWarn, "no LDAP permissions for the root directory, user %#v, domain %#v", username,
+			domain.Name)
+		return user, ErrInvalidCredentials
+	}
+
+	if exists {
+		user = u
+		if match, _ := isPasswordOK(&u, password); !match {
+			user.Password = password
+		}
+	} else {
+		user = User{
+			Username: username,
+			Password: password,
+			Status:   1,
+		}
+		user.Filters.LDAPDomain = domain.getID()
+	}
+	user.Permissions = permissions
+	if domain.HomeDirAttribute != "" {
+		if homeDir := entry.GetAttributeValue(domain.HomeDirAttribute); homeDir != "" {
+			user.HomeDir = homeDir
+		}
+	}
+	if domain.QuotaSizeAttribute != "" {
+		if val := entry.GetAttributeValue(domain.QuotaSizeAttribute); val != "" {
+			if user.QuotaSize, err = strconv.ParseInt(val, 10, 64); err != nil {
+				return user, fmt.Errorf("invalid LDAP quota size %#v for user %#v", val, username)
+			}
+		}
+	}
+	if domain.QuotaFilesAttribute != "" {
+		if val := entry.GetAttributeValue(domain.QuotaFilesAttribute); val != "" {
+			if user.QuotaFiles, err = strconv.Atoi(val); err != nil {
+				return user, fmt.Errorf("invalid LDAP quota files %#v for user %#v", val, username)
+			}
+		}
+	}
+	if exists {
+		previous := getWebhookPreviousObject(AuditActionUpdate, AuditObjectUser, user.Username)
+		err = provider.updateUser(&user)
+		if err == nil {
+			RemoveCachedUser(user.Username)
+			executeWebhooks(AuditActionUpdate, AuditObjectUser, user.Username, previous)
+		}
+		return user, err
+	}
+	err = provider.addUser(&user)
+	if err != nil {
+		return user, err
+	}
+	executeWebhooks(AuditActionAdd, AuditObjectUser, user.Username, nil)
+	return provider.userExists(user.Username)
+}
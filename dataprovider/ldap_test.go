@@ -0,0 +1,299 @@
+package dataprovider
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testLDAPEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// testLDAPServer is a minimal LDAP server supporting simple binds and
+// searches using the "(uid=%s)" filter
+type testLDAPServer struct {
+	listener net.Listener
+	entries  map[string]testLDAPEntry
+}
+
+func newTestLDAPServer(t *testing.T, entries map[string]testLDAPEntry) *testLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &testLDAPServer{
+		listener: listener,
+		entries:  entries,
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handleConn(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testLDAPServer) getURL() string {
+	return fmt.Sprintf("ldap://%v", s.listener.Addr().String())
+}
+
+func (s *testLDAPServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Data.String()
+			password := op.Children[2].Data.String()
+			resultCode := ldap.LDAPResultInvalidCredentials
+			if dn == "" {
+				resultCode = ldap.LDAPResultSuccess
+			}
+			for _, entry := range s.entries {
+				if entry.dn == dn && entry.password == password {
+					resultCode = ldap.LDAPResultSuccess
+				}
+			}
+			s.writeResult(conn, messageID, ldap.ApplicationBindResponse, resultCode)
+		case ldap.ApplicationSearchRequest:
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				return
+			}
+			for uid, entry := range s.entries {
+				if filter == fmt.Sprintf("(uid=%s)", ldap.EscapeFilter(uid)) {
+					s.writeEntry(conn, messageID, entry)
+				}
+			}
+			s.writeResult(conn, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)
+		default:
+			return
+		}
+	}
+}
+
+func (s *testLDAPServer) writeResult(conn net.Conn, messageID int64, tag ber.Tag, resultCode int) {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(resultCode),
+		"Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	s.writeMessage(conn, messageID, op)
+}
+
+func (s *testLDAPServer) writeEntry(conn net.Conn, messageID int64, entry testLDAPEntry) {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "DN"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range entry.attributes {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attr.AppendChild(vals)
+		attributes.AppendChild(attr)
+	}
+	op.AppendChild(attributes)
+	s.writeMessage(conn, messageID, op)
+}
+
+func (s *testLDAPServer) writeMessage(conn net.Conn, messageID int64, op *ber.Packet) {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	packet.AppendChild(op)
+	conn.Write(packet.Bytes()) //nolint:errcheck
+}
+
+func TestLDAPAuth(t *testing.T) {
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+
+	server := newTestLDAPServer(t, map[string]testLDAPEntry{
+		"bob": {
+			dn:       "uid=bob,dc=example,dc=com",
+			password: "bob_ldap_pwd",
+			attributes: map[string][]string{
+				"memberOf": {"cn=uploaders,dc=example,dc=com"},
+			},
+		},
+		"alice": {
+			dn:       "uid=alice,dc=example,dc=com",
+			password: "alice_ldap_pwd",
+		},
+		"carol": {
+			dn:       "uid=carol,dc=example,dc=com",
+			password: "carol_ldap_pwd",
+		},
+	})
+	defer server.listener.Close()
+
+	config.UsersBaseDir = os.TempDir()
+	config.LDAPAuth.Domains = []LDAPDomain{
+		{
+			Name:   "Example",
+			URL:    server.getURL(),
+			BaseDN: "dc=example,dc=com",
+			Permissions: []LDAPPermissions{
+				{
+					Path:        "/",
+					Permissions: []string{PermListItems, PermDownload},
+				},
+				{
+					Group:       "uploaders",
+					Path:        "/",
+					Permissions: []string{PermUpload},
+				},
+			},
+		},
+		{
+			URL:    server.getURL(),
+			BaseDN: "dc=example,dc=com",
+			Permissions: []LDAPPermissions{
+				{
+					Path:        "/",
+					Permissions: []string{PermAny},
+				},
+			},
+		},
+	}
+	err := validateLDAPConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "example_", config.LDAPAuth.Domains[0].UsernamePrefix)
+	assert.Empty(t, config.LDAPAuth.Domains[1].UsernamePrefix)
+
+	domain, ldapUsername := getLDAPDomain("bob@example")
+	if assert.NotNil(t, domain) {
+		assert.Equal(t, "example", domain.Name)
+		assert.Equal(t, "bob", ldapUsername)
+	}
+	domain, ldapUsername = getLDAPDomain("Example\\bob")
+	if assert.NotNil(t, domain) {
+		assert.Equal(t, "example", domain.Name)
+		assert.Equal(t, "bob", ldapUsername)
+	}
+	domain, ldapUsername = getLDAPDomain("bob@other")
+	if assert.NotNil(t, domain) {
+		assert.Empty(t, domain.Name)
+		assert.Equal(t, "bob@other", ldapUsername)
+	}
+	// users are namespaced per domain
+	user, err := CheckUserAndPass("bob@example", "bob_ldap_pwd", "127.0.0.1", "SSH")
+	require.NoError(t, err)
+	assert.Equal(t, "example_bob", user.Username)
+	assert.Equal(t, "example", user.Filters.LDAPDomain)
+	assert.ElementsMatch(t, []string{PermListItems, PermDownload, PermUpload}, user.Permissions["/"])
+	user1, err := CheckUserAndPass("example\\bob", "bob_ldap_pwd", "127.0.0.1", "SSH")
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, user1.ID)
+	user2, err := CheckUserAndPass("bob", "bob_ldap_pwd", "127.0.0.1", "SSH")
+	require.NoError(t, err)
+	assert.Equal(t, "bob", user2.Username)
+	assert.Equal(t, ldapDefaultDomainID, user2.Filters.LDAPDomain)
+	assert.Equal(t, []string{PermAny}, user2.Permissions["/"])
+	assert.NotEqual(t, user.ID, user2.ID)
+	_, err = CheckUserAndPass("bob@example", "wrong_pwd", "127.0.0.1", "SSH")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	// existing users not added by the LDAP domain are never updated and they
+	// can still login using the local credentials
+	alice := getTestUser("alice")
+	alice.Password = "alice_local_pwd"
+	alice.Permissions["/"] = []string{PermListItems}
+	err = AddUser(&alice)
+	require.NoError(t, err)
+	alice, err = UserExists(alice.Username)
+	require.NoError(t, err)
+	user, err = CheckUserAndPass("alice", "alice_local_pwd", "127.0.0.1", "SSH")
+	assert.NoError(t, err)
+	assert.Equal(t, alice.ID, user.ID)
+	_, err = CheckUserAndPass("alice", "alice_ldap_pwd", "127.0.0.1", "SSH")
+	assert.Error(t, err)
+	user, err = UserExists(alice.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, alice.Password, user.Password)
+	assert.Equal(t, alice.Permissions, user.Permissions)
+	assert.Empty(t, user.Filters.LDAPDomain)
+
+	carol := getTestUser("example_carol")
+	carol.Password = "carol_local_pwd"
+	err = AddUser(&carol)
+	require.NoError(t, err)
+	carol, err = UserExists(carol.Username)
+	require.NoError(t, err)
+	_, err = CheckUserAndPass("carol@example", "carol_ldap_pwd", "127.0.0.1", "SSH")
+	assert.Error(t, err)
+	user, err = UserExists(carol.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, carol.Password, user.Password)
+	assert.Empty(t, user.Filters.LDAPDomain)
+
+	for _, username := range []string{"example_bob", "bob", "alice", "example_carol"} {
+		err = DeleteUser(username)
+		assert.NoError(t, err)
+		err = os.RemoveAll(filepath.Join(os.TempDir(), username))
+		assert.NoError(t, err)
+	}
+}
+
+func TestLDAPConfigValidation(t *testing.T) {
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+
+	config.LDAPAuth.Domains = []LDAPDomain{
+		{
+			Name:   "*",
+			URL:    "ldap://127.0.0.1:389",
+			BaseDN: "dc=example,dc=com",
+		},
+	}
+	assert.Error(t, validateLDAPConfig())
+	config.LDAPAuth.Domains = []LDAPDomain{
+		{
+			Name:           "example",
+			UsernamePrefix: "invalid prefix",
+			URL:            "ldap://127.0.0.1:389",
+			BaseDN:         "dc=example,dc=com",
+		},
+	}
+	assert.Error(t, validateLDAPConfig())
+	config.LDAPAuth.Domains = []LDAPDomain{
+		{
+			Name:   "example",
+			URL:    "ldap://127.0.0.1:389",
+			BaseDN: "dc=example,dc=com",
+		},
+		{
+			Name:           "other",
+			UsernamePrefix: "example_",
+			URL:            "ldap://127.0.0.1:389",
+			BaseDN:         "dc=example,dc=com",
+		},
+	}
+	err := validateLDAPConfig()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "duplicated")
+	}
+}
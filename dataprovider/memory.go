@@ -36,6 +36,26 @@ type memoryProviderHandle struct {
 	admins map[string]Admin
 	// slice with ordered admins
 	adminsUsernames []string
+	// map for API keys, keyID is the key
+	apiKeys map[string]APIKey
+	// slice with ordered API keys KeyID
+	apiKeysIDs []string
+	// map for shares, shareID is the key
+	shares map[string]Share
+	// slice with ordered shares shareID
+	sharesIDs []string
+	// queued events in insertion order
+	queuedEvents []QueuedEvent
+	// the last assigned queued event id
+	lastQueuedEventID int64
+	// completed connections in insertion order
+	connectionRecords []ConnectionRecord
+	// the last assigned connection record id
+	lastConnectionRecordID int64
+	// audit events in insertion order
+	auditEvents []AuditEvent
+	// the last assigned audit event id
+	lastAuditEventID int64
 }
 
 // MemoryProvider auth provider for a memory store
@@ -53,16 +73,7 @@ func initializeMemoryProvider(basePath string) {
 		}
 	}
 	provider = &MemoryProvider{
-		dbHandle: &memoryProviderHandle{
-			isClosed:        false,
-			usernames:       []string{},
-			users:           make(map[string]User),
-			vfolders:        make(map[string]vfs.BaseVirtualFolder),
-			vfoldersNames:   []string{},
-			admins:          make(map[string]Admin),
-			adminsUsernames: []string{},
-			configFile:      configFile,
-		},
+		dbHandle: newMemoryProviderHandle(configFile),
 	}
 	if err := provider.reloadConfig(); err != nil {
 		logger.Error(logSender, "", "unable to load initial data: %v", err)
@@ -70,6 +81,23 @@ func initializeMemoryProvider(basePath string) {
 	}
 }
 
+func newMemoryProviderHandle(configFile string) *memoryProviderHandle {
+	return &memoryProviderHandle{
+		isClosed:        false,
+		usernames:       []string{},
+		users:           make(map[string]User),
+		vfolders:        make(map[string]vfs.BaseVirtualFolder),
+		vfoldersNames:   []string{},
+		admins:          make(map[string]Admin),
+		adminsUsernames: []string{},
+		apiKeys:         make(map[string]APIKey),
+		apiKeysIDs:      []string{},
+		shares:          make(map[string]Share),
+		sharesIDs:       []string{},
+		configFile:      configFile,
+	}
+}
+
 func (p *MemoryProvider) checkAvailability() error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -125,7 +153,7 @@ func (p *MemoryProvider) validateAdminAndPass(username, password, ip string) (Ad
 	return admin, err
 }
 
-func (p *MemoryProvider) updateLastLogin(username string) error {
+func (p *MemoryProvider) updateLastLogin(username, protocol string) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
 	if p.dbHandle.isClosed {
@@ -136,6 +164,7 @@ func (p *MemoryProvider) updateLastLogin(username string) error {
 		return err
 	}
 	user.LastLogin = utils.GetTimeAsMsSinceEpoch(time.Now())
+	user.LastLoginProtocol = protocol
 	p.dbHandle.users[user.Username] = user
 	return nil
 }
@@ -165,6 +194,45 @@ func (p *MemoryProvider) updateQuota(username string, filesAdd int, sizeAdd int6
 	return nil
 }
 
+func (p *MemoryProvider) updateTransferQuota(username string, uploadSize, downloadSize, periodStart int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	user, err := p.userExistsInternal(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to update transfer quota for user %#v error: %v", username, err)
+		return err
+	}
+	if user.DataTransferPeriodStart == periodStart {
+		user.UsedUploadDataTransfer += uploadSize
+		user.UsedDownloadDataTransfer += downloadSize
+	} else {
+		user.UsedUploadDataTransfer = uploadSize
+		user.UsedDownloadDataTransfer = downloadSize
+		user.DataTransferPeriodStart = periodStart
+	}
+	providerLog(logger.LevelDebug, "transfer quota updated for user %#v, upload increment: %v download increment: %v",
+		username, uploadSize, downloadSize)
+	p.dbHandle.users[user.Username] = user
+	return nil
+}
+
+func (p *MemoryProvider) getUsedTransferQuota(username string) (int64, int64, int64, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return 0, 0, 0, errMemoryProviderClosed
+	}
+	user, err := p.userExistsInternal(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get transfer quota for user %#v error: %v", username, err)
+		return 0, 0, 0, err
+	}
+	return user.UsedUploadDataTransfer, user.UsedDownloadDataTransfer, user.DataTransferPeriodStart, err
+}
+
 func (p *MemoryProvider) getUsedQuota(username string) (int, int64, error) {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -202,6 +270,10 @@ func (p *MemoryProvider) addUser(user *User) error {
 	user.UsedQuotaSize = 0
 	user.UsedQuotaFiles = 0
 	user.LastLogin = 0
+	user.LastLoginProtocol = ""
+	user.UsedUploadDataTransfer = 0
+	user.UsedDownloadDataTransfer = 0
+	user.DataTransferPeriodStart = 0
 	user.VirtualFolders = p.joinVirtualFoldersFields(user)
 	p.dbHandle.users[user.Username] = user.getACopy()
 	p.dbHandle.usernames = append(p.dbHandle.usernames, user.Username)
@@ -235,6 +307,10 @@ func (p *MemoryProvider) updateUser(user *User) error {
 	user.UsedQuotaSize = u.UsedQuotaSize
 	user.UsedQuotaFiles = u.UsedQuotaFiles
 	user.LastLogin = u.LastLogin
+	user.LastLoginProtocol = u.LastLoginProtocol
+	user.UsedUploadDataTransfer = u.UsedUploadDataTransfer
+	user.UsedDownloadDataTransfer = u.UsedDownloadDataTransfer
+	user.DataTransferPeriodStart = u.DataTransferPeriodStart
 	user.ID = u.ID
 	// pre-login and external auth hook will use the passed *user so save a copy
 	p.dbHandle.users[user.Username] = user.getACopy()
@@ -255,6 +331,8 @@ func (p *MemoryProvider) deleteUser(user *User) error {
 		p.removeUserFromFolderMapping(oldFolder.Name, u.Username)
 	}
 	delete(p.dbHandle.users, user.Username)
+	p.deleteAPIKeysWithUser(user.Username)
+	p.deleteSharesWithUser(user.Username)
 	// this could be more efficient
 	p.dbHandle.usernames = make([]string, 0, len(p.dbHandle.users))
 	for username := range p.dbHandle.users {
@@ -297,6 +375,46 @@ func (p *MemoryProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 	return folders, nil
 }
 
+func (p *MemoryProvider) searchUsers(filters UserSearchFilters, limit, offset int, order string) ([]User, error) {
+	users := make([]User, 0, limit)
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return users, errMemoryProviderClosed
+	}
+	if limit <= 0 {
+		return users, nil
+	}
+	// the usernames are sorted, if the users must be sorted by another field
+	// we need to get all the matching users
+	sortedByUsername := filters.isSortedByUsername()
+	itNum := 0
+	numUsers := len(p.dbHandle.usernames)
+	for i := 0; i < numUsers && (!sortedByUsername || len(users) < limit); i++ {
+		idx := i
+		if order == OrderDESC {
+			idx = numUsers - 1 - i
+		}
+		u := p.dbHandle.users[p.dbHandle.usernames[idx]]
+		if !filters.match(&u) {
+			continue
+		}
+		if sortedByUsername {
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+		}
+		user := u.getACopy()
+		user.HideConfidentialData()
+		users = append(users, user)
+	}
+	if !sortedByUsername {
+		users = sortAndPaginateUsers(users, &filters, limit, offset, order)
+	}
+	return users, nil
+}
+
 func (p *MemoryProvider) getUsers(limit int, offset int, order string) ([]User, error) {
 	users := make([]User, 0, limit)
 	var err error
@@ -410,6 +528,7 @@ func (p *MemoryProvider) deleteAdmin(admin *Admin) error {
 	}
 
 	delete(p.dbHandle.admins, admin.Username)
+	p.deleteAPIKeysWithAdmin(admin.Username)
 	// this could be more efficient
 	p.dbHandle.adminsUsernames = make([]string, 0, len(p.dbHandle.admins))
 	for username := range p.dbHandle.admins {
@@ -449,6 +568,37 @@ func (p *MemoryProvider) dumpAdmins() ([]Admin, error) {
 	return admins, nil
 }
 
+func (p *MemoryProvider) updateSecrets(users []User, admins []Admin) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	// check all the objects before applying any change
+	for idx := range users {
+		if _, err := p.userExistsInternal(users[idx].Username); err != nil {
+			return err
+		}
+	}
+	for idx := range admins {
+		if _, err := p.adminExistsInternal(admins[idx].Username); err != nil {
+			return err
+		}
+	}
+	for idx := range users {
+		u := p.dbHandle.users[users[idx].Username]
+		u.Filters = users[idx].Filters
+		u.FsConfig = users[idx].FsConfig
+		p.dbHandle.users[u.Username] = u.getACopy()
+	}
+	for idx := range admins {
+		a := p.dbHandle.admins[admins[idx].Username]
+		a.Filters = admins[idx].Filters
+		p.dbHandle.admins[a.Username] = a.getACopy()
+	}
+	return nil
+}
+
 func (p *MemoryProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
 	admins := make([]Admin, 0, limit)
 
@@ -496,6 +646,540 @@ func (p *MemoryProvider) getAdmins(limit int, offset int, order string) ([]Admin
 	return admins, nil
 }
 
+func (p *MemoryProvider) apiKeyExists(keyID string) (APIKey, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return APIKey{}, errMemoryProviderClosed
+	}
+	k, ok := p.dbHandle.apiKeys[keyID]
+	if !ok {
+		return APIKey{}, &RecordNotFoundError{err: fmt.Sprintf("API key %#v does not exist", keyID)}
+	}
+	return k.getACopy(), nil
+}
+
+func (p *MemoryProvider) addAPIKey(apiKey *APIKey) error {
+	// the validation could check for admin/user existence so we don't hold the lock here
+	err := apiKey.validate()
+	if err != nil {
+		return err
+	}
+
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, ok := p.dbHandle.apiKeys[apiKey.KeyID]; ok {
+		return fmt.Errorf("API key %#v already exists", apiKey.KeyID)
+	}
+	apiKey.ID = p.getNextAPIKeyID()
+	apiKey.CreatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	apiKey.UpdatedAt = apiKey.CreatedAt
+	apiKey.LastUseAt = 0
+	p.dbHandle.apiKeys[apiKey.KeyID] = apiKey.getACopy()
+	p.dbHandle.apiKeysIDs = append(p.dbHandle.apiKeysIDs, apiKey.KeyID)
+	sort.Strings(p.dbHandle.apiKeysIDs)
+	return nil
+}
+
+func (p *MemoryProvider) updateAPIKey(apiKey *APIKey) error {
+	// the validation could check for admin/user existence so we don't hold the lock here
+	err := apiKey.validate()
+	if err != nil {
+		return err
+	}
+
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	k, ok := p.dbHandle.apiKeys[apiKey.KeyID]
+	if !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("API key %#v does not exist", apiKey.KeyID)}
+	}
+	apiKey.ID = k.ID
+	apiKey.Key = k.Key
+	apiKey.CreatedAt = k.CreatedAt
+	apiKey.LastUseAt = k.LastUseAt
+	apiKey.UpdatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.apiKeys[apiKey.KeyID] = apiKey.getACopy()
+	return nil
+}
+
+func (p *MemoryProvider) deleteAPIKey(apiKey *APIKey) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, ok := p.dbHandle.apiKeys[apiKey.KeyID]; !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("API key %#v does not exist", apiKey.KeyID)}
+	}
+
+	delete(p.dbHandle.apiKeys, apiKey.KeyID)
+	p.updateAPIKeysOrdering()
+
+	return nil
+}
+
+func (p *MemoryProvider) getAPIKeys(limit int, offset int, order string) ([]APIKey, error) {
+	apiKeys := make([]APIKey, 0, limit)
+
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	if p.dbHandle.isClosed {
+		return apiKeys, errMemoryProviderClosed
+	}
+	if limit <= 0 {
+		return apiKeys, nil
+	}
+	itNum := 0
+	if order == OrderDESC {
+		for i := len(p.dbHandle.apiKeysIDs) - 1; i >= 0; i-- {
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			keyID := p.dbHandle.apiKeysIDs[i]
+			k := p.dbHandle.apiKeys[keyID]
+			apiKey := k.getACopy()
+			apiKey.HideConfidentialData()
+			apiKeys = append(apiKeys, apiKey)
+			if len(apiKeys) >= limit {
+				break
+			}
+		}
+	} else {
+		for _, keyID := range p.dbHandle.apiKeysIDs {
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			k := p.dbHandle.apiKeys[keyID]
+			apiKey := k.getACopy()
+			apiKey.HideConfidentialData()
+			apiKeys = append(apiKeys, apiKey)
+			if len(apiKeys) >= limit {
+				break
+			}
+		}
+	}
+
+	return apiKeys, nil
+}
+
+func (p *MemoryProvider) dumpAPIKeys() ([]APIKey, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	apiKeys := make([]APIKey, 0, len(p.dbHandle.apiKeys))
+	if p.dbHandle.isClosed {
+		return apiKeys, errMemoryProviderClosed
+	}
+	for _, k := range p.dbHandle.apiKeys {
+		apiKeys = append(apiKeys, k.getACopy())
+	}
+	return apiKeys, nil
+}
+
+func (p *MemoryProvider) updateAPIKeyLastUse(keyID string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	apiKey, ok := p.dbHandle.apiKeys[keyID]
+	if !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("API key %#v does not exist", keyID)}
+	}
+	apiKey.LastUseAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.apiKeys[keyID] = apiKey
+	return nil
+}
+
+func (p *MemoryProvider) shareExists(shareID string) (Share, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return Share{}, errMemoryProviderClosed
+	}
+	s, ok := p.dbHandle.shares[shareID]
+	if !ok {
+		return Share{}, &RecordNotFoundError{err: fmt.Sprintf("share %#v does not exist", shareID)}
+	}
+	return s.getACopy(), nil
+}
+
+func (p *MemoryProvider) addShare(share *Share) error {
+	// the validation checks for the user existence so we don't hold the lock here
+	err := share.validate()
+	if err != nil {
+		return err
+	}
+
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, ok := p.dbHandle.shares[share.ShareID]; ok {
+		return fmt.Errorf("share %#v already exists", share.ShareID)
+	}
+	share.ID = p.getNextShareID()
+	share.CreatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	share.UpdatedAt = share.CreatedAt
+	share.LastUseAt = 0
+	share.UsedTokens = 0
+	p.dbHandle.shares[share.ShareID] = share.getACopy()
+	p.dbHandle.sharesIDs = append(p.dbHandle.sharesIDs, share.ShareID)
+	sort.Strings(p.dbHandle.sharesIDs)
+	return nil
+}
+
+func (p *MemoryProvider) updateShare(share *Share) error {
+	// the validation checks for the user existence so we don't hold the lock here
+	err := share.validate()
+	if err != nil {
+		return err
+	}
+
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	s, ok := p.dbHandle.shares[share.ShareID]
+	if !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("share %#v does not exist", share.ShareID)}
+	}
+	share.ID = s.ID
+	share.CreatedAt = s.CreatedAt
+	share.LastUseAt = s.LastUseAt
+	share.UsedTokens = s.UsedTokens
+	share.UpdatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.shares[share.ShareID] = share.getACopy()
+	return nil
+}
+
+func (p *MemoryProvider) deleteShare(share *Share) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, ok := p.dbHandle.shares[share.ShareID]; !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("share %#v does not exist", share.ShareID)}
+	}
+
+	delete(p.dbHandle.shares, share.ShareID)
+	p.updateSharesOrdering()
+
+	return nil
+}
+
+func (p *MemoryProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
+	shares := make([]Share, 0, limit)
+
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	if p.dbHandle.isClosed {
+		return shares, errMemoryProviderClosed
+	}
+	if limit <= 0 {
+		return shares, nil
+	}
+	itNum := 0
+	numShares := len(p.dbHandle.sharesIDs)
+	for i := 0; i < numShares && len(shares) < limit; i++ {
+		idx := i
+		if order == OrderDESC {
+			idx = numShares - 1 - i
+		}
+		s := p.dbHandle.shares[p.dbHandle.sharesIDs[idx]]
+		if username != "" && s.Username != username {
+			continue
+		}
+		itNum++
+		if itNum <= offset {
+			continue
+		}
+		share := s.getACopy()
+		share.HideConfidentialData()
+		shares = append(shares, share)
+	}
+
+	return shares, nil
+}
+
+func (p *MemoryProvider) dumpShares() ([]Share, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	shares := make([]Share, 0, len(p.dbHandle.shares))
+	if p.dbHandle.isClosed {
+		return shares, errMemoryProviderClosed
+	}
+	for _, s := range p.dbHandle.shares {
+		shares = append(shares, s.getACopy())
+	}
+	return shares, nil
+}
+
+func (p *MemoryProvider) updateShareLastUse(shareID string, numTokens int) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	share, ok := p.dbHandle.shares[shareID]
+	if !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("share %#v does not exist", shareID)}
+	}
+	if !share.hasTokens(numTokens) {
+		return ErrShareUsedUp
+	}
+	share.LastUseAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	share.UsedTokens += numTokens
+	p.dbHandle.shares[shareID] = share
+	return nil
+}
+
+func (p *MemoryProvider) addQueuedEvent(event *QueuedEvent) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	p.dbHandle.lastQueuedEventID++
+	event.ID = p.dbHandle.lastQueuedEventID
+	p.dbHandle.queuedEvents = append(p.dbHandle.queuedEvents, *event)
+	return nil
+}
+
+func (p *MemoryProvider) getQueuedEvents(limit int, before int64) ([]QueuedEvent, error) {
+	events := make([]QueuedEvent, 0, limit)
+
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return events, errMemoryProviderClosed
+	}
+	for _, event := range p.dbHandle.queuedEvents {
+		if len(events) >= limit {
+			break
+		}
+		if event.NextAttemptAt <= before {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (p *MemoryProvider) updateQueuedEvent(event *QueuedEvent) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	for idx := range p.dbHandle.queuedEvents {
+		if p.dbHandle.queuedEvents[idx].ID == event.ID {
+			p.dbHandle.queuedEvents[idx].Attempts = event.Attempts
+			p.dbHandle.queuedEvents[idx].NextAttemptAt = event.NextAttemptAt
+			return nil
+		}
+	}
+	return &RecordNotFoundError{err: fmt.Sprintf("queued event %v does not exist", event.ID)}
+}
+
+func (p *MemoryProvider) deleteQueuedEvent(id int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	for idx := range p.dbHandle.queuedEvents {
+		if p.dbHandle.queuedEvents[idx].ID == id {
+			p.dbHandle.queuedEvents = append(p.dbHandle.queuedEvents[:idx], p.dbHandle.queuedEvents[idx+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *MemoryProvider) addConnectionRecord(record *ConnectionRecord) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	p.dbHandle.lastConnectionRecordID++
+	record.ID = p.dbHandle.lastConnectionRecordID
+	p.dbHandle.connectionRecords = append(p.dbHandle.connectionRecords, *record)
+	return nil
+}
+
+func (p *MemoryProvider) searchConnectionRecords(filters ConnectionHistoryFilters, limit, offset int, order string) ([]ConnectionRecord, error) {
+	records := make([]ConnectionRecord, 0, limit)
+
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return records, errMemoryProviderClosed
+	}
+	itNum := 0
+	numRecords := len(p.dbHandle.connectionRecords)
+	for i := 0; i < numRecords && len(records) < limit; i++ {
+		idx := i
+		if order == OrderDESC {
+			idx = numRecords - 1 - i
+		}
+		record := p.dbHandle.connectionRecords[idx]
+		if !filters.match(&record) {
+			continue
+		}
+		itNum++
+		if itNum <= offset {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (p *MemoryProvider) cleanupConnectionRecords(before int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	records := p.dbHandle.connectionRecords[:0]
+	for _, record := range p.dbHandle.connectionRecords {
+		if record.EndTime >= before {
+			records = append(records, record)
+		}
+	}
+	p.dbHandle.connectionRecords = records
+	return nil
+}
+
+func (p *MemoryProvider) addAuditEvent(event *AuditEvent) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	p.dbHandle.lastAuditEventID++
+	event.ID = p.dbHandle.lastAuditEventID
+	p.dbHandle.auditEvents = append(p.dbHandle.auditEvents, *event)
+	return nil
+}
+
+func (p *MemoryProvider) searchAuditEvents(filters AuditEventsFilters, limit, offset int, order string) ([]AuditEvent, error) {
+	events := make([]AuditEvent, 0, limit)
+
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return events, errMemoryProviderClosed
+	}
+	itNum := 0
+	numEvents := len(p.dbHandle.auditEvents)
+	for i := 0; i < numEvents && len(events) < limit; i++ {
+		idx := i
+		if order == OrderDESC {
+			idx = numEvents - 1 - i
+		}
+		event := p.dbHandle.auditEvents[idx]
+		if !filters.match(&event) {
+			continue
+		}
+		itNum++
+		if itNum <= offset {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (p *MemoryProvider) cleanupAuditEvents(before int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	events := p.dbHandle.auditEvents[:0]
+	for _, event := range p.dbHandle.auditEvents {
+		if event.Timestamp >= before {
+			events = append(events, event)
+		}
+	}
+	p.dbHandle.auditEvents = events
+	return nil
+}
+
+func (p *MemoryProvider) deleteAPIKeysWithUser(username string) {
+	found := false
+	for k, v := range p.dbHandle.apiKeys {
+		if v.User == username {
+			delete(p.dbHandle.apiKeys, k)
+			found = true
+		}
+	}
+	if found {
+		p.updateAPIKeysOrdering()
+	}
+}
+
+func (p *MemoryProvider) deleteAPIKeysWithAdmin(username string) {
+	found := false
+	for k, v := range p.dbHandle.apiKeys {
+		if v.Admin == username {
+			delete(p.dbHandle.apiKeys, k)
+			found = true
+		}
+	}
+	if found {
+		p.updateAPIKeysOrdering()
+	}
+}
+
+func (p *MemoryProvider) deleteSharesWithUser(username string) {
+	found := false
+	for k, v := range p.dbHandle.shares {
+		if v.Username == username {
+			delete(p.dbHandle.shares, k)
+			found = true
+		}
+	}
+	if found {
+		p.updateSharesOrdering()
+	}
+}
+
+func (p *MemoryProvider) updateSharesOrdering() {
+	// this could be more efficient
+	p.dbHandle.sharesIDs = make([]string, 0, len(p.dbHandle.shares))
+	for shareID := range p.dbHandle.shares {
+		p.dbHandle.sharesIDs = append(p.dbHandle.sharesIDs, shareID)
+	}
+	sort.Strings(p.dbHandle.sharesIDs)
+}
+
+func (p *MemoryProvider) updateAPIKeysOrdering() {
+	// this could be more efficient
+	p.dbHandle.apiKeysIDs = make([]string, 0, len(p.dbHandle.apiKeys))
+	for keyID := range p.dbHandle.apiKeys {
+		p.dbHandle.apiKeysIDs = append(p.dbHandle.apiKeysIDs, keyID)
+	}
+	sort.Strings(p.dbHandle.apiKeysIDs)
+}
+
 func (p *MemoryProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -761,6 +1445,26 @@ func (p *MemoryProvider) getNextAdminID() int64 {
 	return nextID
 }
 
+func (p *MemoryProvider) getNextAPIKeyID() int64 {
+	nextID := int64(1)
+	for _, k := range p.dbHandle.apiKeys {
+		if k.ID >= nextID {
+			nextID = k.ID + 1
+		}
+	}
+	return nextID
+}
+
+func (p *MemoryProvider) getNextShareID() int64 {
+	nextID := int64(1)
+	for _, s := range p.dbHandle.shares {
+		if s.ID >= nextID {
+			nextID = s.ID + 1
+		}
+	}
+	return nextID
+}
+
 func (p *MemoryProvider) clear() {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -770,6 +1474,10 @@ func (p *MemoryProvider) clear() {
 	p.dbHandle.vfolders = make(map[string]vfs.BaseVirtualFolder)
 	p.dbHandle.admins = make(map[string]Admin)
 	p.dbHandle.adminsUsernames = []string{}
+	p.dbHandle.apiKeys = make(map[string]APIKey)
+	p.dbHandle.apiKeysIDs = []string{}
+	p.dbHandle.shares = make(map[string]Share)
+	p.dbHandle.sharesIDs = []string{}
 }
 
 func (p *MemoryProvider) reloadConfig() error {
@@ -777,30 +1485,8 @@ func (p *MemoryProvider) reloadConfig() error {
 		providerLog(logger.LevelDebug, "no dump configuration file defined")
 		return nil
 	}
-	providerLog(logger.LevelDebug, "loading dump from file: %#v", p.dbHandle.configFile)
-	fi, err := os.Stat(p.dbHandle.configFile)
+	dump, err := loadDumpFromFile(p.dbHandle.configFile)
 	if err != nil {
-		providerLog(logger.LevelWarn, "error loading dump: %v", err)
-		return err
-	}
-	if fi.Size() == 0 {
-		err = errors.New("dump configuration file is invalid, its size must be > 0")
-		providerLog(logger.LevelWarn, "error loading dump: %v", err)
-		return err
-	}
-	if fi.Size() > 10485760 {
-		err = errors.New("dump configuration file is invalid, its size must be <= 10485760 bytes")
-		providerLog(logger.LevelWarn, "error loading dump: %v", err)
-		return err
-	}
-	content, err := ioutil.ReadFile(p.dbHandle.configFile)
-	if err != nil {
-		providerLog(logger.LevelWarn, "error loading dump: %v", err)
-		return err
-	}
-	dump, err := ParseDumpData(content)
-	if err != nil {
-		providerLog(logger.LevelWarn, "error loading dump: %v", err)
 		return err
 	}
 	p.clear()
@@ -817,6 +1503,14 @@ func (p *MemoryProvider) reloadConfig() error {
 		return err
 	}
 
+	if err := p.restoreAPIKeys(&dump); err != nil {
+		return err
+	}
+
+	if err := p.restoreShares(&dump); err != nil {
+		return err
+	}
+
 	providerLog(logger.LevelDebug, "config loaded from file: %#v", p.dbHandle.configFile)
 	return nil
 }
@@ -843,6 +1537,58 @@ func (p *MemoryProvider) restoreAdmins(dump *BackupData) error {
 	return nil
 }
 
+func (p *MemoryProvider) restoreAPIKeys(dump *BackupData) error {
+	for _, apiKey := range dump.APIKeys {
+		if apiKey.KeyID == "" || apiKey.Key == "" {
+			err := fmt.Errorf("cannot restore API key %#v: id and key are mandatory", apiKey.Name)
+			providerLog(logger.LevelWarn, "error restoring API key: %v", err)
+			return err
+		}
+		_, err := p.apiKeyExists(apiKey.KeyID)
+		apiKey := apiKey // pin
+		if err == nil {
+			err = p.updateAPIKey(&apiKey)
+			if err != nil {
+				providerLog(logger.LevelWarn, "error updating API key %#v: %v", apiKey.KeyID, err)
+				return err
+			}
+		} else {
+			err = p.addAPIKey(&apiKey)
+			if err != nil {
+				providerLog(logger.LevelWarn, "error adding API key %#v: %v", apiKey.KeyID, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *MemoryProvider) restoreShares(dump *BackupData) error {
+	for _, share := range dump.Shares {
+		if share.ShareID == "" {
+			err := fmt.Errorf("cannot restore share %#v: the share id is mandatory", share.Name)
+			providerLog(logger.LevelWarn, "error restoring share: %v", err)
+			return err
+		}
+		_, err := p.shareExists(share.ShareID)
+		share := share // pin
+		if err == nil {
+			err = p.updateShare(&share)
+			if err != nil {
+				providerLog(logger.LevelWarn, "error updating share %#v: %v", share.ShareID, err)
+				return err
+			}
+		} else {
+			err = p.addShare(&share)
+			if err != nil {
+				providerLog(logger.LevelWarn, "error adding share %#v: %v", share.ShareID, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (p *MemoryProvider) restoreFolders(dump *BackupData) error {
 	for _, folder := range dump.Folders {
 		folder := folder // pin
@@ -888,6 +1634,39 @@ func (p *MemoryProvider) restoreUsers(dump *BackupData) error {
 	return nil
 }
 
+// loadDumpFromFile loads and parses the provider dump from the specified file
+func loadDumpFromFile(configFile string) (BackupData, error) {
+	var dump BackupData
+
+	providerLog(logger.LevelDebug, "loading dump from file: %#v", configFile)
+	fi, err := os.Stat(configFile)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error loading dump: %v", err)
+		return dump, err
+	}
+	if fi.Size() == 0 {
+		err = errors.New("dump configuration file is invalid, its size must be > 0")
+		providerLog(logger.LevelWarn, "error loading dump: %v", err)
+		return dump, err
+	}
+	if fi.Size() > 10485760 {
+		err = errors.New("dump configuration file is invalid, its size must be <= 10485760 bytes")
+		providerLog(logger.LevelWarn, "error loading dump: %v", err)
+		return dump, err
+	}
+	content, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error loading dump: %v", err)
+		return dump, err
+	}
+	dump, err = ParseDumpData(content)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error loading dump: %v", err)
+		return dump, err
+	}
+	return dump, nil
+}
+
 // initializeDatabase does nothing, no initilization is needed for memory provider
 func (p *MemoryProvider) initializeDatabase() error {
 	return ErrNoInitRequired
@@ -897,6 +1676,14 @@ func (p *MemoryProvider) migrateDatabase() error {
 	return ErrNoInitRequired
 }
 
+func (p *MemoryProvider) checkDatabaseVersion() error {
+	return nil
+}
+
 func (p *MemoryProvider) revertDatabase(targetVersion int) error {
 	return errors.New("memory provider does not store data, revert not possible")
 }
+
+func (p *MemoryProvider) resetDatabase() error {
+	return errors.New("memory provider does not store data, reset not possible")
+}
@@ -8,10 +8,9 @@ import (
 	"errors"
 	"fmt"
 	"strings"
-	"time"
 
 	// we import go-sql-driver/mysql here to be able to disable MySQL support using a build tag
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/version"
@@ -39,15 +38,66 @@ const (
 		"ALTER TABLE `{{folders_mapping}}` ADD CONSTRAINT `folders_mapping_folder_id_fk_folders_id` FOREIGN KEY (`folder_id`) REFERENCES `{{folders}}` (`id`) ON DELETE CASCADE;" +
 		"ALTER TABLE `{{folders_mapping}}` ADD CONSTRAINT `folders_mapping_user_id_fk_users_id` FOREIGN KEY (`user_id`) REFERENCES `{{users}}` (`id`) ON DELETE CASCADE;" +
 		"INSERT INTO {{schema_version}} (version) VALUES (8);"
+	mysqlV9SQL = "CREATE TABLE `{{api_keys}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `name` varchar(255) NOT NULL, " +
+		"`key_id` varchar(50) NOT NULL UNIQUE, `api_key` varchar(255) NOT NULL UNIQUE, `scope` integer NOT NULL, " +
+		"`created_at` bigint NOT NULL, `updated_at` bigint NOT NULL, `last_use_at` bigint NOT NULL, " +
+		"`expires_at` bigint NOT NULL, `description` longtext NULL, `admin_id` integer NULL, `user_id` integer NULL);" +
+		"ALTER TABLE `{{api_keys}}` ADD CONSTRAINT `api_keys_admin_id_fk_admins_id` FOREIGN KEY (`admin_id`) REFERENCES `{{admins}}` (`id`) ON DELETE CASCADE;" +
+		"ALTER TABLE `{{api_keys}}` ADD CONSTRAINT `api_keys_user_id_fk_users_id` FOREIGN KEY (`user_id`) REFERENCES `{{users}}` (`id`) ON DELETE CASCADE;"
+	mysqlV9DownSQL  = "DROP TABLE `{{api_keys}}` CASCADE;"
+	mysqlV10SQL     = "ALTER TABLE `{{users}}` ADD COLUMN `last_login_protocol` varchar(20) NULL;"
+	mysqlV10DownSQL = "ALTER TABLE `{{users}}` DROP COLUMN `last_login_protocol`;"
+	mysqlV11SQL     = "ALTER TABLE `{{users}}` ADD COLUMN `used_upload_data_transfer` bigint DEFAULT 0 NOT NULL;" +
+		"ALTER TABLE `{{users}}` ADD COLUMN `used_download_data_transfer` bigint DEFAULT 0 NOT NULL;" +
+		"ALTER TABLE `{{users}}` ADD COLUMN `data_transfer_period_start` bigint DEFAULT 0 NOT NULL;"
+	mysqlV11DownSQL = "ALTER TABLE `{{users}}` DROP COLUMN `used_upload_data_transfer`;" +
+		"ALTER TABLE `{{users}}` DROP COLUMN `used_download_data_transfer`;" +
+		"ALTER TABLE `{{users}}` DROP COLUMN `data_transfer_period_start`;"
+	mysqlV12SQL = "CREATE TABLE `{{events_queue}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, `payload` longtext NOT NULL, " +
+		"`attempts` integer NOT NULL, `created_at` bigint NOT NULL, `next_attempt_at` bigint NOT NULL);" +
+		"CREATE INDEX `events_queue_next_attempt_at_idx` ON `{{events_queue}}` (`next_attempt_at`);"
+	mysqlV12DownSQL = "DROP TABLE `{{events_queue}}` CASCADE;"
+	mysqlV13SQL     = "CREATE TABLE `{{connection_history}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`connection_id` varchar(255) NOT NULL, `username` varchar(255) NOT NULL, `ip` varchar(50) NOT NULL, " +
+		"`protocol` varchar(20) NOT NULL, `start_time` bigint NOT NULL, `end_time` bigint NOT NULL, " +
+		"`bytes_uploaded` bigint NOT NULL, `bytes_downloaded` bigint NOT NULL);" +
+		"CREATE INDEX `connection_history_username_idx` ON `{{connection_history}}` (`username`);" +
+		"CREATE INDEX `connection_history_start_time_idx` ON `{{connection_history}}` (`start_time`);" +
+		"CREATE INDEX `connection_history_end_time_idx` ON `{{connection_history}}` (`end_time`);"
+	mysqlV13DownSQL = "DROP TABLE `{{connection_history}}` CASCADE;"
+	mysqlV14SQL     = "CREATE TABLE `{{shares}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`share_id` varchar(60) NOT NULL UNIQUE, `name` varchar(255) NOT NULL, `description` varchar(512) NULL, " +
+		"`scope` integer NOT NULL, `paths` longtext NOT NULL, `created_at` bigint NOT NULL, " +
+		"`updated_at` bigint NOT NULL, `last_use_at` bigint NOT NULL, `expires_at` bigint NOT NULL, " +
+		"`password` longtext NULL, `max_tokens` integer NOT NULL, `used_tokens` integer NOT NULL, " +
+		"`allow_from` longtext NULL, `user_id` integer NOT NULL);" +
+		"ALTER TABLE `{{shares}}` ADD CONSTRAINT `shares_user_id_fk_users_id` FOREIGN KEY (`user_id`) REFERENCES `{{users}}` (`id`) ON DELETE CASCADE;"
+	mysqlV14DownSQL = "DROP TABLE `{{shares}}` CASCADE;"
+	mysqlV15SQL     = "CREATE TABLE `{{audit_events}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`event_time` bigint NOT NULL, `action` varchar(50) NOT NULL, `username` varchar(255) NOT NULL, " +
+		"`ip` varchar(50) NOT NULL, `protocol` varchar(20) NOT NULL, `object_type` varchar(50) NOT NULL, " +
+		"`object_name` varchar(255) NOT NULL, `details` longtext NOT NULL);" +
+		"CREATE INDEX `audit_events_event_time_idx` ON `{{audit_events}}` (`event_time`);" +
+		"CREATE INDEX `audit_events_username_idx` ON `{{audit_events}}` (`username`);" +
+		"CREATE INDEX `audit_events_action_idx` ON `{{audit_events}}` (`action`);"
+	mysqlV15DownSQL = "DROP TABLE `{{audit_events}}` CASCADE;"
+	mysqlV16SQL     = "ALTER TABLE `{{api_keys}}` ADD COLUMN `restrictions` longtext NULL;"
+	mysqlV16DownSQL = "ALTER TABLE `{{api_keys}}` DROP COLUMN `restrictions`;"
+	mysqlV17SQL     = "ALTER TABLE `{{users}}` ADD COLUMN `fs_provider` integer DEFAULT 0 NOT NULL;" +
+		"ALTER TABLE `{{users}}` ADD COLUMN `user_groups` longtext NULL;"
+	mysqlV17DownSQL = "ALTER TABLE `{{users}}` DROP COLUMN `fs_provider`;" +
+		"ALTER TABLE `{{users}}` DROP COLUMN `user_groups`;"
 )
 
 // MySQLProvider auth provider for MySQL/MariaDB database
 type MySQLProvider struct {
 	dbHandle *sql.DB
+	replicas *sqlReadReplicas
 }
 
 func init() {
 	version.AddFeature("+mysql")
+	transientDriverErrors = append(transientDriverErrors, mysql.ErrInvalidConn)
 }
 
 func initializeMySQLProvider() error {
@@ -57,14 +107,13 @@ func initializeMySQLProvider() error {
 	if err == nil {
 		providerLog(logger.LevelDebug, "mysql database handle created, connection string: %#v, pool size: %v",
 			getMySQLConnectionString(true), config.PoolSize)
-		dbHandle.SetMaxOpenConns(config.PoolSize)
-		if config.PoolSize > 0 {
-			dbHandle.SetMaxIdleConns(config.PoolSize)
-		} else {
-			dbHandle.SetMaxIdleConns(2)
+		sqlCommonSetPoolOptions(dbHandle)
+		replicas, errReplicas := newSQLReadReplicas("mysql")
+		if errReplicas != nil {
+			dbHandle.Close() //nolint:errcheck
+			return errReplicas
 		}
-		dbHandle.SetConnMaxLifetime(240 * time.Second)
-		provider = &MySQLProvider{dbHandle: dbHandle}
+		provider = &MySQLProvider{dbHandle: dbHandle, replicas: replicas}
 	} else {
 		providerLog(logger.LevelWarn, "error creating mysql database handler, connection string: %#v, error: %v",
 			getMySQLConnectionString(true), err)
@@ -87,7 +136,11 @@ func getMySQLConnectionString(redactedPwd bool) string {
 }
 
 func (p *MySQLProvider) checkAvailability() error {
-	return sqlCommonCheckAvailability(p.dbHandle)
+	if err := sqlCommonCheckAvailability(p.dbHandle); err != nil {
+		return err
+	}
+	p.replicas.checkAvailability()
+	return nil
 }
 
 func (p *MySQLProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
@@ -102,12 +155,20 @@ func (p *MySQLProvider) updateQuota(username string, filesAdd int, sizeAdd int64
 	return sqlCommonUpdateQuota(username, filesAdd, sizeAdd, reset, p.dbHandle)
 }
 
+func (p *MySQLProvider) updateTransferQuota(username string, uploadSize, downloadSize, periodStart int64) error {
+	return sqlCommonUpdateTransferQuota(username, uploadSize, downloadSize, periodStart, p.dbHandle)
+}
+
+func (p *MySQLProvider) getUsedTransferQuota(username string) (int64, int64, int64, error) {
+	return sqlCommonGetUsedTransferQuota(username, p.dbHandle)
+}
+
 func (p *MySQLProvider) getUsedQuota(username string) (int, int64, error) {
 	return sqlCommonGetUsedQuota(username, p.dbHandle)
 }
 
-func (p *MySQLProvider) updateLastLogin(username string) error {
-	return sqlCommonUpdateLastLogin(username, p.dbHandle)
+func (p *MySQLProvider) updateLastLogin(username, protocol string) error {
+	return sqlCommonUpdateLastLogin(username, protocol, p.dbHandle)
 }
 
 func (p *MySQLProvider) userExists(username string) (User, error) {
@@ -131,7 +192,23 @@ func (p *MySQLProvider) dumpUsers() ([]User, error) {
 }
 
 func (p *MySQLProvider) getUsers(limit int, offset int, order string) ([]User, error) {
-	return sqlCommonGetUsers(limit, offset, order, p.dbHandle)
+	var res []User
+	err := p.replicas.query(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		res, err = sqlCommonGetUsers(limit, offset, order, dbHandle)
+		return err
+	})
+	return res, err
+}
+
+func (p *MySQLProvider) searchUsers(filters UserSearchFilters, limit, offset int, order string) ([]User, error) {
+	var res []User
+	err := p.replicas.query(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		res, err = sqlCommonSearchUsers(filters, limit, offset, order, dbHandle)
+		return err
+	})
+	return res, err
 }
 
 func (p *MySQLProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
@@ -139,7 +216,13 @@ func (p *MySQLProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 }
 
 func (p *MySQLProvider) getFolders(limit, offset int, order string) ([]vfs.BaseVirtualFolder, error) {
-	return sqlCommonGetFolders(limit, offset, order, p.dbHandle)
+	var res []vfs.BaseVirtualFolder
+	err := p.replicas.query(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		res, err = sqlCommonGetFolders(limit, offset, order, dbHandle)
+		return err
+	})
+	return res, err
 }
 
 func (p *MySQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
@@ -185,18 +268,149 @@ func (p *MySQLProvider) deleteAdmin(admin *Admin) error {
 }
 
 func (p *MySQLProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
-	return sqlCommonGetAdmins(limit, offset, order, p.dbHandle)
+	var res []Admin
+	err := p.replicas.query(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		res, err = sqlCommonGetAdmins(limit, offset, order, dbHandle)
+		return err
+	})
+	return res, err
 }
 
 func (p *MySQLProvider) dumpAdmins() ([]Admin, error) {
 	return sqlCommonDumpAdmins(p.dbHandle)
 }
 
+func (p *MySQLProvider) updateSecrets(users []User, admins []Admin) error {
+	return sqlCommonUpdateSecrets(users, admins, p.dbHandle)
+}
+
 func (p *MySQLProvider) validateAdminAndPass(username, password, ip string) (Admin, error) {
 	return sqlCommonValidateAdminAndPass(username, password, ip, p.dbHandle)
 }
 
+func (p *MySQLProvider) apiKeyExists(keyID string) (APIKey, error) {
+	return sqlCommonGetAPIKeyByID(keyID, p.dbHandle)
+}
+
+func (p *MySQLProvider) addAPIKey(apiKey *APIKey) error {
+	return sqlCommonAddAPIKey(apiKey, p.dbHandle)
+}
+
+func (p *MySQLProvider) updateAPIKey(apiKey *APIKey) error {
+	return sqlCommonUpdateAPIKey(apiKey, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteAPIKey(apiKey *APIKey) error {
+	return sqlCommonDeleteAPIKey(apiKey, p.dbHandle)
+}
+
+func (p *MySQLProvider) getAPIKeys(limit int, offset int, order string) ([]APIKey, error) {
+	var res []APIKey
+	err := p.replicas.query(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		res, err = sqlCommonGetAPIKeys(limit, offset, order, dbHandle)
+		return err
+	})
+	return res, err
+}
+
+func (p *MySQLProvider) dumpAPIKeys() ([]APIKey, error) {
+	return sqlCommonDumpAPIKeys(p.dbHandle)
+}
+
+func (p *MySQLProvider) updateAPIKeyLastUse(keyID string) error {
+	return sqlCommonUpdateAPIKeyLastUse(keyID, p.dbHandle)
+}
+
+func (p *MySQLProvider) shareExists(shareID string) (Share, error) {
+	return sqlCommonGetShareByID(shareID, p.dbHandle)
+}
+
+func (p *MySQLProvider) addShare(share *Share) error {
+	return sqlCommonAddShare(share, p.dbHandle)
+}
+
+func (p *MySQLProvider) updateShare(share *Share) error {
+	return sqlCommonUpdateShare(share, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteShare(share *Share) error {
+	return sqlCommonDeleteShare(share, p.dbHandle)
+}
+
+func (p *MySQLProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
+	var res []Share
+	err := p.replicas.query(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		res, err = sqlCommonGetShares(limit, offset, order, username, dbHandle)
+		return err
+	})
+	return res, err
+}
+
+func (p *MySQLProvider) dumpShares() ([]Share, error) {
+	return sqlCommonDumpShares(p.dbHandle)
+}
+
+func (p *MySQLProvider) updateShareLastUse(shareID string, numTokens int) error {
+	return sqlCommonUpdateShareLastUse(shareID, numTokens, p.dbHandle)
+}
+
+func (p *MySQLProvider) addQueuedEvent(event *QueuedEvent) error {
+	return sqlCommonAddQueuedEvent(event, p.dbHandle)
+}
+
+func (p *MySQLProvider) getQueuedEvents(limit int, before int64) ([]QueuedEvent, error) {
+	return sqlCommonGetQueuedEvents(limit, before, p.dbHandle)
+}
+
+func (p *MySQLProvider) updateQueuedEvent(event *QueuedEvent) error {
+	return sqlCommonUpdateQueuedEvent(event, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteQueuedEvent(id int64) error {
+	return sqlCommonDeleteQueuedEvent(id, p.dbHandle)
+}
+
+func (p *MySQLProvider) addConnectionRecord(record *ConnectionRecord) error {
+	return sqlCommonAddConnectionRecord(record, p.dbHandle)
+}
+
+func (p *MySQLProvider) searchConnectionRecords(filters ConnectionHistoryFilters, limit, offset int, order string) ([]ConnectionRecord, error) {
+	var res []ConnectionRecord
+	err := p.replicas.query(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		res, err = sqlCommonSearchConnectionRecords(filters, limit, offset, order, dbHandle)
+		return err
+	})
+	return res, err
+}
+
+func (p *MySQLProvider) cleanupConnectionRecords(before int64) error {
+	return sqlCommonCleanupConnectionRecords(before, p.dbHandle)
+}
+
+func (p *MySQLProvider) addAuditEvent(event *AuditEvent) error {
+	return sqlCommonAddAuditEvent(event, p.dbHandle)
+}
+
+func (p *MySQLProvider) searchAuditEvents(filters AuditEventsFilters, limit, offset int, order string) ([]AuditEvent, error) {
+	var res []AuditEvent
+	err := p.replicas.query(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		res, err = sqlCommonSearchAuditEvents(filters, limit, offset, order, dbHandle)
+		return err
+	})
+	return res, err
+}
+
+func (p *MySQLProvider) cleanupAuditEvents(before int64) error {
+	return sqlCommonCleanupAuditEvents(before, p.dbHandle)
+}
+
 func (p *MySQLProvider) close() error {
+	p.replicas.close() //nolint:errcheck
 	return p.dbHandle.Close()
 }
 
@@ -234,6 +448,24 @@ func (p *MySQLProvider) migrateDatabase() error {
 		providerLog(logger.LevelError, "%v", err)
 		logger.ErrorToConsole("%v", err)
 		return err
+	case version == 8:
+		return updateMySQLDatabaseFromV8(p.dbHandle)
+	case version == 9:
+		return updateMySQLDatabaseFromV9(p.dbHandle)
+	case version == 10:
+		return updateMySQLDatabaseFromV10(p.dbHandle)
+	case version == 11:
+		return updateMySQLDatabaseFromV11(p.dbHandle)
+	case version == 12:
+		return updateMySQLDatabaseFromV12(p.dbHandle)
+	case version == 13:
+		return updateMySQLDatabaseFromV13(p.dbHandle)
+	case version == 14:
+		return updateMySQLDatabaseFromV14(p.dbHandle)
+	case version == 15:
+		return updateMySQLDatabaseFromV15(p.dbHandle)
+	case version == 16:
+		return updateMySQLDatabaseFromV16(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelWarn, "database version %v is newer than the supported one: %v", version,
@@ -246,6 +478,14 @@ func (p *MySQLProvider) migrateDatabase() error {
 	}
 }
 
+func (p *MySQLProvider) checkDatabaseVersion() error {
+	return sqlCommonCheckDatabaseVersion(p.dbHandle)
+}
+
+func (p *MySQLProvider) resetDatabase() error {
+	return sqlCommonResetDatabase(p.dbHandle, "DROP TABLE IF EXISTS `%v` CASCADE;")
+}
+
 func (p *MySQLProvider) revertDatabase(targetVersion int) error {
 	dbVersion, err := sqlCommonGetDatabaseVersion(p.dbHandle, true)
 	if err != nil {
@@ -254,5 +494,276 @@ func (p *MySQLProvider) revertDatabase(targetVersion int) error {
 	if dbVersion.Version == targetVersion {
 		return errors.New("current version match target version, nothing to do")
 	}
-	return errors.New("the current version cannot be reverted")
+
+	switch dbVersion.Version {
+	case 9:
+		return downgradeMySQLDatabaseFromV9(p.dbHandle)
+	case 10:
+		return downgradeMySQLDatabaseFromV10(p.dbHandle)
+	case 11:
+		return downgradeMySQLDatabaseFromV11(p.dbHandle)
+	case 12:
+		return downgradeMySQLDatabaseFromV12(p.dbHandle)
+	case 13:
+		return downgradeMySQLDatabaseFromV13(p.dbHandle)
+	case 14:
+		return downgradeMySQLDatabaseFromV14(p.dbHandle)
+	case 15:
+		return downgradeMySQLDatabaseFromV15(p.dbHandle)
+	case 16:
+		return downgradeMySQLDatabaseFromV16(p.dbHandle)
+	case 17:
+		return downgradeMySQLDatabaseFromV17(p.dbHandle)
+	default:
+		return fmt.Errorf("database version not handled: %v", dbVersion.Version)
+	}
+}
+
+func updateMySQLDatabaseFromV8(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom8To9(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV9(dbHandle)
+}
+
+func updateMySQLDatabaseFromV9(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom9To10(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV10(dbHandle)
+}
+
+func updateMySQLDatabaseFromV10(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom10To11(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV11(dbHandle)
+}
+
+func updateMySQLDatabaseFromV11(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom11To12(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV12(dbHandle)
+}
+
+func updateMySQLDatabaseFromV12(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom12To13(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV13(dbHandle)
+}
+
+func updateMySQLDatabaseFromV13(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom13To14(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV14(dbHandle)
+}
+
+func updateMySQLDatabaseFromV14(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom14To15(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV15(dbHandle)
+}
+
+func updateMySQLDatabaseFromV15(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom15To16(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV16(dbHandle)
+}
+
+func updateMySQLDatabaseFromV16(dbHandle *sql.DB) error {
+	return updateMySQLDatabaseFrom16To17(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV9(dbHandle *sql.DB) error {
+	return downgradeMySQLDatabaseFrom9To8(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV10(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom10To9(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV9(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV11(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom11To10(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV10(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV12(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom12To11(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV11(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV13(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom13To12(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV12(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV14(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom14To13(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV13(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV15(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom15To14(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV14(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV16(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom16To15(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV15(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV17(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom17To16(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV16(dbHandle)
+}
+
+func updateMySQLDatabaseFrom8To9(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 8 -> 9")
+	providerLog(logger.LevelInfo, "updating database version: 8 -> 9")
+	sql := strings.ReplaceAll(mysqlV9SQL, "{{api_keys}}", sqlTableAPIKeys)
+	sql = strings.ReplaceAll(sql, "{{admins}}", sqlTableAdmins)
+	sql = strings.ReplaceAll(sql, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 9)
+}
+
+func downgradeMySQLDatabaseFrom9To8(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 9 -> 8")
+	providerLog(logger.LevelInfo, "downgrading database version: 9 -> 8")
+	sql := strings.ReplaceAll(mysqlV9DownSQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 8)
+}
+
+func updateMySQLDatabaseFrom9To10(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 9 -> 10")
+	providerLog(logger.LevelInfo, "updating database version: 9 -> 10")
+	sql := strings.ReplaceAll(mysqlV10SQL, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 10)
+}
+
+func downgradeMySQLDatabaseFrom10To9(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 10 -> 9")
+	providerLog(logger.LevelInfo, "downgrading database version: 10 -> 9")
+	sql := strings.ReplaceAll(mysqlV10DownSQL, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 9)
+}
+
+func updateMySQLDatabaseFrom10To11(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 10 -> 11")
+	providerLog(logger.LevelInfo, "updating database version: 10 -> 11")
+	sql := strings.ReplaceAll(mysqlV11SQL, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 11)
+}
+
+func downgradeMySQLDatabaseFrom11To10(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 11 -> 10")
+	providerLog(logger.LevelInfo, "downgrading database version: 11 -> 10")
+	sql := strings.ReplaceAll(mysqlV11DownSQL, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 10)
+}
+
+func updateMySQLDatabaseFrom11To12(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 11 -> 12")
+	providerLog(logger.LevelInfo, "updating database version: 11 -> 12")
+	sql := strings.ReplaceAll(mysqlV12SQL, "{{events_queue}}", sqlTableEventsQueue)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 12)
+}
+
+func downgradeMySQLDatabaseFrom12To11(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 12 -> 11")
+	providerLog(logger.LevelInfo, "downgrading database version: 12 -> 11")
+	sql := strings.ReplaceAll(mysqlV12DownSQL, "{{events_queue}}", sqlTableEventsQueue)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 11)
+}
+
+func updateMySQLDatabaseFrom12To13(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 12 -> 13")
+	providerLog(logger.LevelInfo, "updating database version: 12 -> 13")
+	sql := strings.ReplaceAll(mysqlV13SQL, "{{connection_history}}", sqlTableConnHistory)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 13)
+}
+
+func downgradeMySQLDatabaseFrom13To12(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 13 -> 12")
+	providerLog(logger.LevelInfo, "downgrading database version: 13 -> 12")
+	sql := strings.ReplaceAll(mysqlV13DownSQL, "{{connection_history}}", sqlTableConnHistory)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 12)
+}
+
+func updateMySQLDatabaseFrom13To14(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 13 -> 14")
+	providerLog(logger.LevelInfo, "updating database version: 13 -> 14")
+	sql := strings.ReplaceAll(mysqlV14SQL, "{{shares}}", sqlTableShares)
+	sql = strings.ReplaceAll(sql, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 14)
+}
+
+func downgradeMySQLDatabaseFrom14To13(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 14 -> 13")
+	providerLog(logger.LevelInfo, "downgrading database version: 14 -> 13")
+	sql := strings.ReplaceAll(mysqlV14DownSQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 13)
+}
+
+func updateMySQLDatabaseFrom14To15(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 14 -> 15")
+	providerLog(logger.LevelInfo, "updating database version: 14 -> 15")
+	sql := strings.ReplaceAll(mysqlV15SQL, "{{audit_events}}", sqlTableAuditEvents)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 15)
+}
+
+func downgradeMySQLDatabaseFrom15To14(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 15 -> 14")
+	providerLog(logger.LevelInfo, "downgrading database version: 15 -> 14")
+	sql := strings.ReplaceAll(mysqlV15DownSQL, "{{audit_events}}", sqlTableAuditEvents)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 14)
+}
+
+func updateMySQLDatabaseFrom15To16(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 15 -> 16")
+	providerLog(logger.LevelInfo, "updating database version: 15 -> 16")
+	sql := strings.ReplaceAll(mysqlV16SQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 16)
+}
+
+func downgradeMySQLDatabaseFrom16To15(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 16 -> 15")
+	providerLog(logger.LevelInfo, "downgrading database version: 16 -> 15")
+	sql := strings.ReplaceAll(mysqlV16DownSQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 15)
+}
+
+func updateMySQLDatabaseFrom16To17(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 16 -> 17")
+	providerLog(logger.LevelInfo, "updating database version: 16 -> 17")
+	sql := strings.ReplaceAll(mysqlV17SQL, "{{users}}", sqlTableUsers)
+	return sqlCommonUpdateDatabaseFrom16To17(dbHandle, strings.Split(sql, ";"))
+}
+
+func downgradeMySQLDatabaseFrom17To16(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 17 -> 16")
+	providerLog(logger.LevelInfo, "downgrading database version: 17 -> 16")
+	sql := strings.ReplaceAll(mysqlV17DownSQL, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 16)
 }
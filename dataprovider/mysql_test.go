@@ -0,0 +1,17 @@
+// +build !nomysql
+
+package dataprovider
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMySQLTransientErrors(t *testing.T) {
+	assert.True(t, isTransientProviderError(mysql.ErrInvalidConn))
+	assert.True(t, isTransientProviderError(fmt.Errorf("wrapped: %w", mysql.ErrInvalidConn)))
+	assert.False(t, isTransientProviderError(mysql.ErrNoTLS))
+}
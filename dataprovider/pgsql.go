@@ -8,7 +8,6 @@ import (
 	"errors"
 	"fmt"
 	"strings"
-	"time"
 
 	// we import lib/pq here to be able to disable PostgreSQL support using a build tag
 	_ "github.com/lib/pq"
@@ -43,12 +42,76 @@ FOREIGN KEY ("user_id") REFERENCES "{{users}}" ("id") MATCH SIMPLE ON UPDATE NO
 CREATE INDEX "folders_mapping_folder_id_idx" ON "{{folders_mapping}}" ("folder_id");
 CREATE INDEX "folders_mapping_user_id_idx" ON "{{folders_mapping}}" ("user_id");
 INSERT INTO {{schema_version}} (version) VALUES (8);
+`
+	pgsqlV9SQL = `CREATE TABLE "{{api_keys}}" ("id" serial NOT NULL PRIMARY KEY, "name" varchar(255) NOT NULL,
+"key_id" varchar(50) NOT NULL UNIQUE, "api_key" varchar(255) NOT NULL UNIQUE, "scope" integer NOT NULL,
+"created_at" bigint NOT NULL, "updated_at" bigint NOT NULL, "last_use_at" bigint NOT NULL, "expires_at" bigint NOT NULL,
+"description" text NULL, "admin_id" integer NULL, "user_id" integer NULL);
+ALTER TABLE "{{api_keys}}" ADD CONSTRAINT "api_keys_admin_id_fk_admins_id" FOREIGN KEY ("admin_id")
+REFERENCES "{{admins}}" ("id") MATCH SIMPLE ON UPDATE NO ACTION ON DELETE CASCADE;
+ALTER TABLE "{{api_keys}}" ADD CONSTRAINT "api_keys_user_id_fk_users_id" FOREIGN KEY ("user_id")
+REFERENCES "{{users}}" ("id") MATCH SIMPLE ON UPDATE NO ACTION ON DELETE CASCADE;
+CREATE INDEX "api_keys_admin_id_idx" ON "{{api_keys}}" ("admin_id");
+CREATE INDEX "api_keys_user_id_idx" ON "{{api_keys}}" ("user_id");
+`
+	pgsqlV9DownSQL  = `DROP TABLE "{{api_keys}}" CASCADE;`
+	pgsqlV10SQL     = `ALTER TABLE "{{users}}" ADD COLUMN "last_login_protocol" varchar(20) NULL;`
+	pgsqlV10DownSQL = `ALTER TABLE "{{users}}" DROP COLUMN "last_login_protocol" CASCADE;`
+	pgsqlV11SQL     = `ALTER TABLE "{{users}}" ADD COLUMN "used_upload_data_transfer" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{users}}" ADD COLUMN "used_download_data_transfer" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{users}}" ADD COLUMN "data_transfer_period_start" bigint DEFAULT 0 NOT NULL;
+`
+	pgsqlV11DownSQL = `ALTER TABLE "{{users}}" DROP COLUMN "used_upload_data_transfer" CASCADE;
+ALTER TABLE "{{users}}" DROP COLUMN "used_download_data_transfer" CASCADE;
+ALTER TABLE "{{users}}" DROP COLUMN "data_transfer_period_start" CASCADE;
+`
+	pgsqlV12SQL = `CREATE TABLE "{{events_queue}}" ("id" bigserial NOT NULL PRIMARY KEY, "payload" text NOT NULL,
+"attempts" integer NOT NULL, "created_at" bigint NOT NULL, "next_attempt_at" bigint NOT NULL);
+CREATE INDEX "events_queue_next_attempt_at_idx" ON "{{events_queue}}" ("next_attempt_at");
+`
+	pgsqlV12DownSQL = `DROP TABLE "{{events_queue}}" CASCADE;`
+	pgsqlV13SQL     = `CREATE TABLE "{{connection_history}}" ("id" bigserial NOT NULL PRIMARY KEY,
+"connection_id" varchar(255) NOT NULL, "username" varchar(255) NOT NULL, "ip" varchar(50) NOT NULL,
+"protocol" varchar(20) NOT NULL, "start_time" bigint NOT NULL, "end_time" bigint NOT NULL,
+"bytes_uploaded" bigint NOT NULL, "bytes_downloaded" bigint NOT NULL);
+CREATE INDEX "connection_history_username_idx" ON "{{connection_history}}" ("username");
+CREATE INDEX "connection_history_start_time_idx" ON "{{connection_history}}" ("start_time");
+CREATE INDEX "connection_history_end_time_idx" ON "{{connection_history}}" ("end_time");
+`
+	pgsqlV13DownSQL = `DROP TABLE "{{connection_history}}" CASCADE;`
+	pgsqlV14SQL     = `CREATE TABLE "{{shares}}" ("id" serial NOT NULL PRIMARY KEY,
+"share_id" varchar(60) NOT NULL UNIQUE, "name" varchar(255) NOT NULL, "description" varchar(512) NULL,
+"scope" integer NOT NULL, "paths" text NOT NULL, "created_at" bigint NOT NULL, "updated_at" bigint NOT NULL,
+"last_use_at" bigint NOT NULL, "expires_at" bigint NOT NULL, "password" text NULL, "max_tokens" integer NOT NULL,
+"used_tokens" integer NOT NULL, "allow_from" text NULL, "user_id" integer NOT NULL);
+ALTER TABLE "{{shares}}" ADD CONSTRAINT "shares_user_id_fk_users_id" FOREIGN KEY ("user_id")
+REFERENCES "{{users}}" ("id") MATCH SIMPLE ON UPDATE NO ACTION ON DELETE CASCADE;
+CREATE INDEX "shares_user_id_idx" ON "{{shares}}" ("user_id");
+`
+	pgsqlV14DownSQL = `DROP TABLE "{{shares}}" CASCADE;`
+	pgsqlV15SQL     = `CREATE TABLE "{{audit_events}}" ("id" bigserial NOT NULL PRIMARY KEY,
+"event_time" bigint NOT NULL, "action" varchar(50) NOT NULL, "username" varchar(255) NOT NULL,
+"ip" varchar(50) NOT NULL, "protocol" varchar(20) NOT NULL, "object_type" varchar(50) NOT NULL,
+"object_name" varchar(255) NOT NULL, "details" text NOT NULL);
+CREATE INDEX "audit_events_event_time_idx" ON "{{audit_events}}" ("event_time");
+CREATE INDEX "audit_events_username_idx" ON "{{audit_events}}" ("username");
+CREATE INDEX "audit_events_action_idx" ON "{{audit_events}}" ("action");
+`
+	pgsqlV15DownSQL = `DROP TABLE "{{audit_events}}" CASCADE;`
+	pgsqlV16SQL     = `ALTER TABLE "{{api_keys}}" ADD COLUMN "restrictions" text NULL;`
+	pgsqlV16DownSQL = `ALTER TABLE "{{api_keys}}" DROP COLUMN "restrictions" CASCADE;`
+	pgsqlV17SQL     = `ALTER TABLE "{{users}}" ADD COLUMN "fs_provider" integer DEFAULT 0 NOT NULL;
+ALTER TABLE "{{users}}" ADD COLUMN "user_groups" text NULL;
+`
+	pgsqlV17DownSQL = `ALTER TABLE "{{users}}" DROP COLUMN "fs_provider" CASCADE;
+ALTER TABLE "{{users}}" DROP COLUMN "user_groups" CASCADE;
 `
 )
 
 // PGSQLProvider auth provider for PostgreSQL database
 type PGSQLProvider struct {
 	dbHandle *sql.DB
+	replicas *sqlReadReplicas
 }
 
 func init() {
@@ -62,14 +125,13 @@ func initializePGSQLProvider() error {
 	if err == nil {
 		providerLog(logger.LevelDebug, "postgres database handle created, connection string: %#v, pool size: %v",
 			getPGSQLConnectionString(true), config.PoolSize)
-		dbHandle.SetMaxOpenConns(config.PoolSize)
-		if config.PoolSize > 0 {
-			dbHandle.SetMaxIdleConns(config.PoolSize)
-		} else {
-			dbHandle.SetMaxIdleConns(2)
+		sqlCommonSetPoolOptions(dbHandle)
+		replicas, errReplicas := newSQLReadReplicas("postgres")
+		if errReplicas != nil {
+			dbHandle.Close() //nolint:errcheck
+			return errReplicas
 		}
-		dbHandle.SetConnMaxLifetime(240 * time.Second)
-		provider = &PGSQLProvider{dbHandle: dbHandle}
+		provider = &PGSQLProvider{dbHandle: dbHandle, replicas: replicas}
 	} else {
 		providerLog(logger.LevelWarn, "error creating postgres database handler, connection string: %#v, error: %v",
 			getPGSQLConnectionString(true), err)
@@ -93,7 +155,11 @@ func getPGSQLConnectionString(redactedPwd bool) string {
 }
 
 func (p *PGSQLProvider) checkAvailability() error {
-	return sqlCommonCheckAvailability(p.dbHandle)
+	if err := sqlCommonCheckAvailability(p.dbHandle); err != nil {
+		return err
+	}
+	p.replicas.checkAvailability()
+	return nil
 }
 
 func (p *PGSQLProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
@@ -108,12 +174,20 @@ func (p *PGSQLProvider) updateQuota(username string, filesAdd int, sizeAdd int64
 	return sqlCommonUpdateQuota(username, filesAdd, sizeAdd, reset, p.dbHandle)
 }
 
+func (p *PGSQLProvider) updateTransferQuota(username string, uploadSize, downloadSize, periodStart int64) error {
+	return sqlCommonUpdateTransferQuota(username, uploadSize, downloadSize, periodStart, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getUsedTransferQuota(username string) (int64, int64, int64, error) {
+	return sqlCommonGetUsedTransferQuota(username, p.dbHandle)
+}
+
 func (p *PGSQLProvider) getUsedQuota(username string) (int, int64, error) {
 	return sqlCommonGetUsedQuota(username, p.dbHandle)
 }
 
-func (p *PGSQLProvider) updateLastLogin(username string) error {
-	return sqlCommonUpdateLastLogin(username, p.dbHandle)
+func (p *PGSQLProvider) updateLastLogin(username, protocol string) error {
+	return sqlCommonUpdateLastLogin(username, protocol, p.dbHandle)
 }
 
 func (p *PGSQLProvider) userExists(username string) (User, error) {
@@ -137,7 +211,23 @@ func (p *PGSQLProvider) dumpUsers() ([]User, error) {
 }
 
 func (p *PGSQLProvider) getUsers(limit int, offset int, order string) ([]User, error) {
-	return sqlCommonGetUsers(limit, offset, order, p.dbHandle)
+	var res []User
+	err := p.replicas.query(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		res, err = sqlCommonGetUsers(limit, offset, order, dbHandle)
+		return err
+	})
+	return res, err
+}
+
+func (p *PGSQLProvider) searchUsers(filters UserSearchFilters, limit, offset int, order string) ([]User, error) {
+	var res []User
+	err := p.replicas.query(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		res, err = sqlCommonSearchUsers(filters, limit, offset, order, dbHandle)
+		return err
+	})
+	return res, err
 }
 
 func (p *PGSQLProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
@@ -145,7 +235,13 @@ func (p *PGSQLProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 }
 
 func (p *PGSQLProvider) getFolders(limit, offset int, order string) ([]vfs.BaseVirtualFolder, error) {
-	return sqlCommonGetFolders(limit, offset, order, p.dbHandle)
+	var res []vfs.BaseVirtualFolder
+	err := p.replicas.query(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		res, err = sqlCommonGetFolders(limit, offset, order, dbHandle)
+		return err
+	})
+	return res, err
 }
 
 func (p *PGSQLProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
@@ -191,18 +287,149 @@ func (p *PGSQLProvider) deleteAdmin(admin *Admin) error {
 }
 
 func (p *PGSQLProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
-	return sqlCommonGetAdmins(limit, offset, order, p.dbHandle)
+	var res []Admin
+	err := p.replicas.query(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		res, err = sqlCommonGetAdmins(limit, offset, order, dbHandle)
+		return err
+	})
+	return res, err
 }
 
 func (p *PGSQLProvider) dumpAdmins() ([]Admin, error) {
 	return sqlCommonDumpAdmins(p.dbHandle)
 }
 
+func (p *PGSQLProvider) updateSecrets(users []User, admins []Admin) error {
+	return sqlCommonUpdateSecrets(users, admins, p.dbHandle)
+}
+
 func (p *PGSQLProvider) validateAdminAndPass(username, password, ip string) (Admin, error) {
 	return sqlCommonValidateAdminAndPass(username, password, ip, p.dbHandle)
 }
 
+func (p *PGSQLProvider) apiKeyExists(keyID string) (APIKey, error) {
+	return sqlCommonGetAPIKeyByID(keyID, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addAPIKey(apiKey *APIKey) error {
+	return sqlCommonAddAPIKey(apiKey, p.dbHandle)
+}
+
+func (p *PGSQLProvider) updateAPIKey(apiKey *APIKey) error {
+	return sqlCommonUpdateAPIKey(apiKey, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteAPIKey(apiKey *APIKey) error {
+	return sqlCommonDeleteAPIKey(apiKey, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getAPIKeys(limit int, offset int, order string) ([]APIKey, error) {
+	var res []APIKey
+	err := p.replicas.query(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		res, err = sqlCommonGetAPIKeys(limit, offset, order, dbHandle)
+		return err
+	})
+	return res, err
+}
+
+func (p *PGSQLProvider) dumpAPIKeys() ([]APIKey, error) {
+	return sqlCommonDumpAPIKeys(p.dbHandle)
+}
+
+func (p *PGSQLProvider) updateAPIKeyLastUse(keyID string) error {
+	return sqlCommonUpdateAPIKeyLastUse(keyID, p.dbHandle)
+}
+
+func (p *PGSQLProvider) shareExists(shareID string) (Share, error) {
+	return sqlCommonGetShareByID(shareID, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addShare(share *Share) error {
+	return sqlCommonAddShare(share, p.dbHandle)
+}
+
+func (p *PGSQLProvider) updateShare(share *Share) error {
+	return sqlCommonUpdateShare(share, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteShare(share *Share) error {
+	return sqlCommonDeleteShare(share, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
+	var res []Share
+	err := p.replicas.query(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		res, err = sqlCommonGetShares(limit, offset, order, username, dbHandle)
+		return err
+	})
+	return res, err
+}
+
+func (p *PGSQLProvider) dumpShares() ([]Share, error) {
+	return sqlCommonDumpShares(p.dbHandle)
+}
+
+func (p *PGSQLProvider) updateShareLastUse(shareID string, numTokens int) error {
+	return sqlCommonUpdateShareLastUse(shareID, numTokens, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addQueuedEvent(event *QueuedEvent) error {
+	return sqlCommonAddQueuedEvent(event, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getQueuedEvents(limit int, before int64) ([]QueuedEvent, error) {
+	return sqlCommonGetQueuedEvents(limit, before, p.dbHandle)
+}
+
+func (p *PGSQLProvider) updateQueuedEvent(event *QueuedEvent) error {
+	return sqlCommonUpdateQueuedEvent(event, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteQueuedEvent(id int64) error {
+	return sqlCommonDeleteQueuedEvent(id, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addConnectionRecord(record *ConnectionRecord) error {
+	return sqlCommonAddConnectionRecord(record, p.dbHandle)
+}
+
+func (p *PGSQLProvider) searchConnectionRecords(filters ConnectionHistoryFilters, limit, offset int, order string) ([]ConnectionRecord, error) {
+	var res []ConnectionRecord
+	err := p.replicas.query(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		res, err = sqlCommonSearchConnectionRecords(filters, limit, offset, order, dbHandle)
+		return err
+	})
+	return res, err
+}
+
+func (p *PGSQLProvider) cleanupConnectionRecords(before int64) error {
+	return sqlCommonCleanupConnectionRecords(before, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addAuditEvent(event *AuditEvent) error {
+	return sqlCommonAddAuditEvent(event, p.dbHandle)
+}
+
+func (p *PGSQLProvider) searchAuditEvents(filters AuditEventsFilters, limit, offset int, order string) ([]AuditEvent, error) {
+	var res []AuditEvent
+	err := p.replicas.query(p.dbHandle, func(dbHandle *sql.DB) error {
+		var err error
+		res, err = sqlCommonSearchAuditEvents(filters, limit, offset, order, dbHandle)
+		return err
+	})
+	return res, err
+}
+
+func (p *PGSQLProvider) cleanupAuditEvents(before int64) error {
+	return sqlCommonCleanupAuditEvents(before, p.dbHandle)
+}
+
 func (p *PGSQLProvider) close() error {
+	p.replicas.close() //nolint:errcheck
 	return p.dbHandle.Close()
 }
 
@@ -240,6 +467,24 @@ func (p *PGSQLProvider) migrateDatabase() error {
 		providerLog(logger.LevelError, "%v", err)
 		logger.ErrorToConsole("%v", err)
 		return err
+	case version == 8:
+		return updatePGSQLDatabaseFromV8(p.dbHandle)
+	case version == 9:
+		return updatePGSQLDatabaseFromV9(p.dbHandle)
+	case version == 10:
+		return updatePGSQLDatabaseFromV10(p.dbHandle)
+	case version == 11:
+		return updatePGSQLDatabaseFromV11(p.dbHandle)
+	case version == 12:
+		return updatePGSQLDatabaseFromV12(p.dbHandle)
+	case version == 13:
+		return updatePGSQLDatabaseFromV13(p.dbHandle)
+	case version == 14:
+		return updatePGSQLDatabaseFromV14(p.dbHandle)
+	case version == 15:
+		return updatePGSQLDatabaseFromV15(p.dbHandle)
+	case version == 16:
+		return updatePGSQLDatabaseFromV16(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelWarn, "database version %v is newer than the supported one: %v", version,
@@ -252,6 +497,14 @@ func (p *PGSQLProvider) migrateDatabase() error {
 	}
 }
 
+func (p *PGSQLProvider) checkDatabaseVersion() error {
+	return sqlCommonCheckDatabaseVersion(p.dbHandle)
+}
+
+func (p *PGSQLProvider) resetDatabase() error {
+	return sqlCommonResetDatabase(p.dbHandle, `DROP TABLE IF EXISTS "%v" CASCADE;`)
+}
+
 func (p *PGSQLProvider) revertDatabase(targetVersion int) error {
 	dbVersion, err := sqlCommonGetDatabaseVersion(p.dbHandle, true)
 	if err != nil {
@@ -260,5 +513,276 @@ func (p *PGSQLProvider) revertDatabase(targetVersion int) error {
 	if dbVersion.Version == targetVersion {
 		return errors.New("current version match target version, nothing to do")
 	}
-	return errors.New("the current version cannot be reverted")
+
+	switch dbVersion.Version {
+	case 9:
+		return downgradePGSQLDatabaseFromV9(p.dbHandle)
+	case 10:
+		return downgradePGSQLDatabaseFromV10(p.dbHandle)
+	case 11:
+		return downgradePGSQLDatabaseFromV11(p.dbHandle)
+	case 12:
+		return downgradePGSQLDatabaseFromV12(p.dbHandle)
+	case 13:
+		return downgradePGSQLDatabaseFromV13(p.dbHandle)
+	case 14:
+		return downgradePGSQLDatabaseFromV14(p.dbHandle)
+	case 15:
+		return downgradePGSQLDatabaseFromV15(p.dbHandle)
+	case 16:
+		return downgradePGSQLDatabaseFromV16(p.dbHandle)
+	case 17:
+		return downgradePGSQLDatabaseFromV17(p.dbHandle)
+	default:
+		return fmt.Errorf("database version not handled: %v", dbVersion.Version)
+	}
+}
+
+func updatePGSQLDatabaseFromV8(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom8To9(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV9(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV9(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom9To10(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV10(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV10(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom10To11(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV11(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV11(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom11To12(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV12(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV12(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom12To13(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV13(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV13(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom13To14(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV14(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV14(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom14To15(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV15(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV15(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom15To16(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV16(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV16(dbHandle *sql.DB) error {
+	return updatePGSQLDatabaseFrom16To17(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV9(dbHandle *sql.DB) error {
+	return downgradePGSQLDatabaseFrom9To8(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV10(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom10To9(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV9(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV11(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom11To10(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV10(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV12(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom12To11(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV11(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV13(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom13To12(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV12(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV14(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom14To13(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV13(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV15(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom15To14(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV14(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV16(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom16To15(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV15(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV17(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom17To16(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV16(dbHandle)
+}
+
+func updatePGSQLDatabaseFrom8To9(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 8 -> 9")
+	providerLog(logger.LevelInfo, "updating database version: 8 -> 9")
+	sql := strings.ReplaceAll(pgsqlV9SQL, "{{api_keys}}", sqlTableAPIKeys)
+	sql = strings.ReplaceAll(sql, "{{admins}}", sqlTableAdmins)
+	sql = strings.ReplaceAll(sql, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 9)
+}
+
+func downgradePGSQLDatabaseFrom9To8(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 9 -> 8")
+	providerLog(logger.LevelInfo, "downgrading database version: 9 -> 8")
+	sql := strings.ReplaceAll(pgsqlV9DownSQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 8)
+}
+
+func updatePGSQLDatabaseFrom9To10(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 9 -> 10")
+	providerLog(logger.LevelInfo, "updating database version: 9 -> 10")
+	sql := strings.ReplaceAll(pgsqlV10SQL, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 10)
+}
+
+func downgradePGSQLDatabaseFrom10To9(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 10 -> 9")
+	providerLog(logger.LevelInfo, "downgrading database version: 10 -> 9")
+	sql := strings.ReplaceAll(pgsqlV10DownSQL, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 9)
+}
+
+func updatePGSQLDatabaseFrom10To11(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 10 -> 11")
+	providerLog(logger.LevelInfo, "updating database version: 10 -> 11")
+	sql := strings.ReplaceAll(pgsqlV11SQL, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 11)
+}
+
+func downgradePGSQLDatabaseFrom11To10(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 11 -> 10")
+	providerLog(logger.LevelInfo, "downgrading database version: 11 -> 10")
+	sql := strings.ReplaceAll(pgsqlV11DownSQL, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 10)
+}
+
+func updatePGSQLDatabaseFrom11To12(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 11 -> 12")
+	providerLog(logger.LevelInfo, "updating database version: 11 -> 12")
+	sql := strings.ReplaceAll(pgsqlV12SQL, "{{events_queue}}", sqlTableEventsQueue)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 12)
+}
+
+func downgradePGSQLDatabaseFrom12To11(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 12 -> 11")
+	providerLog(logger.LevelInfo, "downgrading database version: 12 -> 11")
+	sql := strings.ReplaceAll(pgsqlV12DownSQL, "{{events_queue}}", sqlTableEventsQueue)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 11)
+}
+
+func updatePGSQLDatabaseFrom12To13(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 12 -> 13")
+	providerLog(logger.LevelInfo, "updating database version: 12 -> 13")
+	sql := strings.ReplaceAll(pgsqlV13SQL, "{{connection_history}}", sqlTableConnHistory)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 13)
+}
+
+func downgradePGSQLDatabaseFrom13To12(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 13 -> 12")
+	providerLog(logger.LevelInfo, "downgrading database version: 13 -> 12")
+	sql := strings.ReplaceAll(pgsqlV13DownSQL, "{{connection_history}}", sqlTableConnHistory)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 12)
+}
+
+func updatePGSQLDatabaseFrom13To14(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 13 -> 14")
+	providerLog(logger.LevelInfo, "updating database version: 13 -> 14")
+	sql := strings.ReplaceAll(pgsqlV14SQL, "{{shares}}", sqlTableShares)
+	sql = strings.ReplaceAll(sql, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 14)
+}
+
+func downgradePGSQLDatabaseFrom14To13(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 14 -> 13")
+	providerLog(logger.LevelInfo, "downgrading database version: 14 -> 13")
+	sql := strings.ReplaceAll(pgsqlV14DownSQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 13)
+}
+
+func updatePGSQLDatabaseFrom14To15(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 14 -> 15")
+	providerLog(logger.LevelInfo, "updating database version: 14 -> 15")
+	sql := strings.ReplaceAll(pgsqlV15SQL, "{{audit_events}}", sqlTableAuditEvents)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 15)
+}
+
+func downgradePGSQLDatabaseFrom15To14(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 15 -> 14")
+	providerLog(logger.LevelInfo, "downgrading database version: 15 -> 14")
+	sql := strings.ReplaceAll(pgsqlV15DownSQL, "{{audit_events}}", sqlTableAuditEvents)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 14)
+}
+
+func updatePGSQLDatabaseFrom15To16(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 15 -> 16")
+	providerLog(logger.LevelInfo, "updating database version: 15 -> 16")
+	sql := strings.ReplaceAll(pgsqlV16SQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 16)
+}
+
+func downgradePGSQLDatabaseFrom16To15(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 16 -> 15")
+	providerLog(logger.LevelInfo, "downgrading database version: 16 -> 15")
+	sql := strings.ReplaceAll(pgsqlV16DownSQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 15)
+}
+
+func updatePGSQLDatabaseFrom16To17(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 16 -> 17")
+	providerLog(logger.LevelInfo, "updating database version: 16 -> 17")
+	sql := strings.ReplaceAll(pgsqlV17SQL, "{{users}}", sqlTableUsers)
+	return sqlCommonUpdateDatabaseFrom16To17(dbHandle, []string{sql})
+}
+
+func downgradePGSQLDatabaseFrom17To16(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 17 -> 16")
+	providerLog(logger.LevelInfo, "downgrading database version: 17 -> 16")
+	sql := strings.ReplaceAll(pgsqlV17DownSQL, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 16)
 }
@@ -0,0 +1,498 @@
+package dataprovider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+const restDumpPageSize = 100
+
+// errRESTConflict is returned if the remote service refuses to add an already existing record
+var errRESTConflict = errors.New("conflict")
+
+type restQuotaUpdate struct {
+	Files int   `json:"files"`
+	Size  int64 `json:"size"`
+	Reset bool  `json:"reset"`
+}
+
+type restTransferQuotaUpdate struct {
+	UploadSize   int64 `json:"upload_size"`
+	DownloadSize int64 `json:"download_size"`
+	PeriodStart  int64 `json:"period_start"`
+}
+
+type restLastLoginUpdate struct {
+	Protocol string `json:"protocol"`
+}
+
+// RESTProvider auth provider for users stored in a remote service reachable via HTTP.
+// Users are fetched and updated using the remote REST API, virtual folders,
+// admins and API keys are stored in memory as for the memory provider.
+// The virtual folders associated to the remote users are mapped to the
+// in memory folders, missing folders are automatically added
+type RESTProvider struct {
+	*MemoryProvider
+	baseURL string
+}
+
+func initializeRESTProvider(basePath string) error {
+	logSender = fmt.Sprintf("dataprovider_%v", RESTDataProviderName)
+	baseURL, err := url.Parse(config.ConnectionString)
+	if err != nil || !strings.HasPrefix(baseURL.Scheme, "http") || baseURL.Host == "" {
+		return fmt.Errorf("invalid connection string %#v for the REST provider, it must be an HTTP URL",
+			config.ConnectionString)
+	}
+	configFile := ""
+	if utils.IsFileInputValid(config.Name) {
+		configFile = config.Name
+		if !filepath.IsAbs(configFile) {
+			configFile = filepath.Join(basePath, configFile)
+		}
+	}
+	provider = &RESTProvider{
+		MemoryProvider: &MemoryProvider{
+			dbHandle: newMemoryProviderHandle(configFile),
+		},
+		baseURL: strings.TrimSuffix(baseURL.String(), "/"),
+	}
+	if err := provider.reloadConfig(); err != nil {
+		logger.Error(logSender, "", "unable to load initial data: %v", err)
+		logger.ErrorToConsole("unable to load initial data: %v", err)
+	}
+	return nil
+}
+
+func (p *RESTProvider) getUserURL(username string, parts ...string) string {
+	return strings.Join(append([]string{p.baseURL, "users", url.PathEscape(username)}, parts...), "/")
+}
+
+// doRequest sends a JSON request to the remote service. If result is not nil
+// the response body is decoded into it
+func (p *RESTProvider) doRequest(method, reqURL string, body, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		asJSON, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewBuffer(asJSON)
+	}
+	req, err := http.NewRequest(method, reqURL, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if config.Username != "" || config.Password != "" {
+		req.SetBasicAuth(config.Username, config.Password)
+	}
+	resp, err := httpclient.GetHTTPClient().Do(req)
+	if err != nil {
+		providerLog(logger.LevelWarn, "REST provider request %v %#v failed: %v", method, reqURL, err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return &RecordNotFoundError{err: fmt.Sprintf("%#v not found", reqURL)}
+	case resp.StatusCode == http.StatusConflict:
+		return fmt.Errorf("%w: %v %#v", errRESTConflict, method, reqURL)
+	case resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusNoContent:
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		err = fmt.Errorf("unexpected status code from REST provider: %v, response: %#v", resp.StatusCode,
+			string(respBody))
+		providerLog(logger.LevelWarn, "REST provider request %v %#v failed: %v", method, reqURL, err)
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (p *RESTProvider) checkAvailability() error {
+	if err := p.MemoryProvider.checkAvailability(); err != nil {
+		return err
+	}
+	var users []User
+	return p.doRequest(http.MethodGet, fmt.Sprintf("%v/users?limit=1", p.baseURL), nil, &users)
+}
+
+func (p *RESTProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
+	var user User
+	if password == "" {
+		return user, errors.New("Credentials cannot be null or empty")
+	}
+	user, err := p.userExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %#v: %v", username, err)
+		return user, err
+	}
+	return checkUserAndPass(&user, password, ip, protocol)
+}
+
+func (p *RESTProvider) validateUserAndPubKey(username string, pubKey []byte) (User, string, error) {
+	var user User
+	if len(pubKey) == 0 {
+		return user, "", errors.New("Credentials cannot be null or empty")
+	}
+	user, err := p.userExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %#v: %v", username, err)
+		return user, "", err
+	}
+	return checkUserAndPubKey(&user, pubKey)
+}
+
+func (p *RESTProvider) updateLastLogin(username, protocol string) error {
+	return p.doRequest(http.MethodPut, p.getUserURL(username, "lastlogin"), restLastLoginUpdate{
+		Protocol: protocol,
+	}, nil)
+}
+
+func (p *RESTProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
+	err := p.doRequest(http.MethodPut, p.getUserURL(username, "quota"), restQuotaUpdate{
+		Files: filesAdd,
+		Size:  sizeAdd,
+		Reset: reset,
+	}, nil)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to update quota for user %#v error: %v", username, err)
+		return err
+	}
+	providerLog(logger.LevelDebug, "quota updated for user %#v, files increment: %v size increment: %v is reset? %v",
+		username, filesAdd, sizeAdd, reset)
+	return nil
+}
+
+func (p *RESTProvider) getUsedQuota(username string) (int, int64, error) {
+	user, err := p.userExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get quota for user %#v error: %v", username, err)
+		return 0, 0, err
+	}
+	return user.UsedQuotaFiles, user.UsedQuotaSize, nil
+}
+
+func (p *RESTProvider) updateTransferQuota(username string, uploadSize, downloadSize, periodStart int64) error {
+	err := p.doRequest(http.MethodPut, p.getUserURL(username, "transferquota"), restTransferQuotaUpdate{
+		UploadSize:   uploadSize,
+		DownloadSize: downloadSize,
+		PeriodStart:  periodStart,
+	}, nil)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to update transfer quota for user %#v error: %v", username, err)
+	}
+	return err
+}
+
+func (p *RESTProvider) getUsedTransferQuota(username string) (int64, int64, int64, error) {
+	user, err := p.userExists(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get transfer quota for user %#v error: %v", username, err)
+		return 0, 0, 0, err
+	}
+	return user.UsedUploadDataTransfer, user.UsedDownloadDataTransfer, user.DataTransferPeriodStart, nil
+}
+
+func (p *RESTProvider) userExists(username string) (User, error) {
+	var user User
+	err := p.doRequest(http.MethodGet, p.getUserURL(username), nil, &user)
+	if err != nil {
+		if _, ok := err.(*RecordNotFoundError); ok {
+			err = &RecordNotFoundError{err: fmt.Sprintf("username %#v does not exist", username)}
+		}
+		return User{}, err
+	}
+	if user.Username != username {
+		return User{}, fmt.Errorf("the REST provider returned the unexpected username %#v, wanted %#v",
+			user.Username, username)
+	}
+	p.mapVirtualFolders(&user)
+	return user, nil
+}
+
+// mapVirtualFolders replaces the virtual folders of the given remote user with
+// the in memory ones, adding the missing folders, and updates the folders mapping
+func (p *RESTProvider) mapVirtualFolders(user *User) {
+	if len(user.VirtualFolders) == 0 {
+		return
+	}
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	user.VirtualFolders = p.joinVirtualFoldersFields(user)
+}
+
+// unmapVirtualFolders removes the given user from the mapping of the specified folders
+func (p *RESTProvider) unmapVirtualFolders(username string, folders []vfs.VirtualFolder) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	for _, folder := range folders {
+		p.removeUserFromFolderMapping(folder.Name, username)
+	}
+}
+
+func (p *RESTProvider) addUser(user *User) error {
+	err := ValidateUser(user)
+	if err != nil {
+		return err
+	}
+	user.LastQuotaUpdate = 0
+	user.UsedQuotaSize = 0
+	user.UsedQuotaFiles = 0
+	user.LastLogin = 0
+	user.LastLoginProtocol = ""
+	user.UsedUploadDataTransfer = 0
+	user.UsedDownloadDataTransfer = 0
+	user.DataTransferPeriodStart = 0
+	// the remote service is responsible to enforce the username uniqueness
+	err = p.doRequest(http.MethodPost, fmt.Sprintf("%v/users", p.baseURL), user, nil)
+	if err != nil {
+		if errors.Is(err, errRESTConflict) {
+			return fmt.Errorf("username %#v already exists", user.Username)
+		}
+		return err
+	}
+	p.mapVirtualFolders(user)
+	return nil
+}
+
+// updateSecrets updates the remote users one at a time, the remote service does
+// not allow to update them inside a single transaction
+func (p *RESTProvider) updateSecrets(users []User, admins []Admin) error {
+	for idx := range users {
+		u, err := p.userExists(users[idx].Username)
+		if err != nil {
+			return err
+		}
+		u.Filters = users[idx].Filters
+		u.FsConfig = users[idx].FsConfig
+		if err := p.doRequest(http.MethodPut, p.getUserURL(u.Username), &u, nil); err != nil {
+			return err
+		}
+	}
+	return p.MemoryProvider.updateSecrets(nil, admins)
+}
+
+func (p *RESTProvider) updateUser(user *User) error {
+	err := ValidateUser(user)
+	if err != nil {
+		return err
+	}
+	u, err := p.userExists(user.Username)
+	if err != nil {
+		return err
+	}
+	user.LastQuotaUpdate = u.LastQuotaUpdate
+	user.UsedQuotaSize = u.UsedQuotaSize
+	user.UsedQuotaFiles = u.UsedQuotaFiles
+	user.LastLogin = u.LastLogin
+	user.LastLoginProtocol = u.LastLoginProtocol
+	user.UsedUploadDataTransfer = u.UsedUploadDataTransfer
+	user.UsedDownloadDataTransfer = u.UsedDownloadDataTransfer
+	user.DataTransferPeriodStart = u.DataTransferPeriodStart
+	user.ID = u.ID
+	err = p.doRequest(http.MethodPut, p.getUserURL(user.Username), user, nil)
+	if err != nil {
+		return err
+	}
+	p.unmapVirtualFolders(u.Username, u.VirtualFolders)
+	p.mapVirtualFolders(user)
+	return nil
+}
+
+func (p *RESTProvider) deleteUser(user *User) error {
+	err := p.doRequest(http.MethodDelete, p.getUserURL(user.Username), nil, nil)
+	if err != nil {
+		return err
+	}
+	p.unmapVirtualFolders(user.Username, user.VirtualFolders)
+
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+
+	p.deleteAPIKeysWithUser(user.Username)
+	return nil
+}
+
+// deleteFolder removes the folder from the remote users it is associated to
+// and then deletes it
+func (p *RESTProvider) deleteFolder(folder *vfs.BaseVirtualFolder) error {
+	f, err := p.getFolderByName(folder.Name)
+	if err != nil {
+		return err
+	}
+	for _, username := range f.Users {
+		user, err := p.userExists(username)
+		if err != nil {
+			if _, ok := err.(*RecordNotFoundError); ok {
+				continue
+			}
+			return err
+		}
+		var folders []vfs.VirtualFolder
+		for _, userFolder := range user.VirtualFolders {
+			if userFolder.Name != folder.Name {
+				folders = append(folders, userFolder)
+			}
+		}
+		user.VirtualFolders = folders
+		err = p.doRequest(http.MethodPut, p.getUserURL(user.Username), &user, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return p.MemoryProvider.deleteFolder(folder)
+}
+
+func (p *RESTProvider) getUsers(limit int, offset int, order string) ([]User, error) {
+	users := make([]User, 0, limit)
+	if limit <= 0 {
+		return users, nil
+	}
+	q := url.Values{}
+	q.Set("limit", strconv.Itoa(limit))
+	q.Set("offset", strconv.Itoa(offset))
+	q.Set("order", order)
+	err := p.doRequest(http.MethodGet, fmt.Sprintf("%v/users?%v", p.baseURL, q.Encode()), nil, &users)
+	if err != nil {
+		return users, err
+	}
+	for idx := range users {
+		p.mapVirtualFolders(&users[idx])
+		users[idx].HideConfidentialData()
+	}
+	return users, nil
+}
+
+// searchUsers pages through the users returned by the remote service and filters them.
+// The remote service is only required to list the users sorted by username
+func (p *RESTProvider) searchUsers(filters UserSearchFilters, limit, offset int, order string) ([]User, error) {
+	users := make([]User, 0, limit)
+	if limit <= 0 {
+		return users, nil
+	}
+	sortedByUsername := filters.isSortedByUsername()
+	itNum := 0
+	remoteOffset := 0
+	for {
+		var page []User
+		q := url.Values{}
+		q.Set("limit", strconv.Itoa(restDumpPageSize))
+		q.Set("offset", strconv.Itoa(remoteOffset))
+		q.Set("order", order)
+		err := p.doRequest(http.MethodGet, fmt.Sprintf("%v/users?%v", p.baseURL, q.Encode()), nil, &page)
+		if err != nil {
+			return users, err
+		}
+		for idx := range page {
+			if !filters.match(&page[idx]) {
+				continue
+			}
+			if sortedByUsername {
+				itNum++
+				if itNum <= offset {
+					continue
+				}
+			}
+			p.mapVirtualFolders(&page[idx])
+			page[idx].HideConfidentialData()
+			users = append(users, page[idx])
+			if sortedByUsername && len(users) >= limit {
+				return users, nil
+			}
+		}
+		if len(page) < restDumpPageSize {
+			break
+		}
+		remoteOffset += len(page)
+	}
+	if !sortedByUsername {
+		users = sortAndPaginateUsers(users, &filters, limit, offset, order)
+	}
+	return users, nil
+}
+
+func (p *RESTProvider) dumpUsers() ([]User, error) {
+	var users []User
+	offset := 0
+	for {
+		var page []User
+		q := url.Values{}
+		q.Set("limit", strconv.Itoa(restDumpPageSize))
+		q.Set("offset", strconv.Itoa(offset))
+		q.Set("order", OrderASC)
+		err := p.doRequest(http.MethodGet, fmt.Sprintf("%v/users?%v", p.baseURL, q.Encode()), nil, &page)
+		if err != nil {
+			return users, err
+		}
+		for idx := range page {
+			p.mapVirtualFolders(&page[idx])
+			err = addCredentialsToUser(&page[idx])
+			if err != nil {
+				return users, err
+			}
+		}
+		users = append(users, page...)
+		if len(page) < restDumpPageSize {
+			break
+		}
+		offset += len(page)
+	}
+	return users, nil
+}
+
+func (p *RESTProvider) reloadConfig() error {
+	if p.dbHandle.configFile == "" {
+		providerLog(logger.LevelDebug, "no dump configuration file defined")
+		return nil
+	}
+	dump, err := loadDumpFromFile(p.dbHandle.configFile)
+	if err != nil {
+		return err
+	}
+	p.clear()
+
+	// users are stored in the remote service, we only restore the local data
+	if err := p.restoreFolders(&dump); err != nil {
+		return err
+	}
+
+	if err := p.restoreAdmins(&dump); err != nil {
+		return err
+	}
+
+	if err := p.restoreAPIKeys(&dump); err != nil {
+		return err
+	}
+
+	providerLog(logger.LevelDebug, "config loaded from file: %#v", p.dbHandle.configFile)
+	return nil
+}
+
+func (p *RESTProvider) revertDatabase(targetVersion int) error {
+	return errors.New("REST provider does not store data, revert not possible")
+}
+
+func (p *RESTProvider) resetDatabase() error {
+	return errors.New("REST provider does not store data, reset not possible")
+}
@@ -0,0 +1,355 @@
+package dataprovider
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+// testRESTService is a minimal remote users service
+type testRESTService struct {
+	sync.Mutex
+	users    map[string]User
+	requests []string
+}
+
+func (s *testRESTService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 0 || parts[0] != "users" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			s.listUsers(w, r)
+		case http.MethodPost:
+			var user User
+			if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if _, ok := s.users[user.Username]; ok {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			user.ID = int64(len(s.users) + 1)
+			s.users[user.Username] = user
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	user, ok := s.users[parts[1]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if len(parts) == 3 {
+		s.updateUserField(w, r, user, parts[2])
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(user) //nolint:errcheck
+	case http.MethodPut:
+		var updated User
+		if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.users[user.Username] = updated
+	case http.MethodDelete:
+		delete(s.users, user.Username)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *testRESTService) listUsers(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	usernames := make([]string, 0, len(s.users))
+	for username := range s.users {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	if r.URL.Query().Get("order") == OrderDESC {
+		sort.Sort(sort.Reverse(sort.StringSlice(usernames)))
+	}
+	users := make([]User, 0)
+	for idx := offset; idx < len(usernames) && len(users) < limit; idx++ {
+		users = append(users, s.users[usernames[idx]])
+	}
+	json.NewEncoder(w).Encode(users) //nolint:errcheck
+}
+
+func (s *testRESTService) updateUserField(w http.ResponseWriter, r *http.Request, user User, field string) {
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	switch field {
+	case "quota":
+		var update restQuotaUpdate
+		json.NewDecoder(r.Body).Decode(&update) //nolint:errcheck
+		if update.Reset {
+			user.UsedQuotaFiles = update.Files
+			user.UsedQuotaSize = update.Size
+		} else {
+			user.UsedQuotaFiles += update.Files
+			user.UsedQuotaSize += update.Size
+		}
+	case "transferquota":
+		var update restTransferQuotaUpdate
+		json.NewDecoder(r.Body).Decode(&update) //nolint:errcheck
+		if update.PeriodStart != user.DataTransferPeriodStart {
+			user.UsedUploadDataTransfer = 0
+			user.UsedDownloadDataTransfer = 0
+			user.DataTransferPeriodStart = update.PeriodStart
+		}
+		user.UsedUploadDataTransfer += update.UploadSize
+		user.UsedDownloadDataTransfer += update.DownloadSize
+	case "lastlogin":
+		var update restLastLoginUpdate
+		json.NewDecoder(r.Body).Decode(&update) //nolint:errcheck
+		user.LastLogin = utils.GetTimeAsMsSinceEpoch(time.Now())
+		user.LastLoginProtocol = update.Protocol
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	s.users[user.Username] = user
+}
+
+func (s *testRESTService) getRequests() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+func newTestRESTProvider(baseURL string) *RESTProvider {
+	return &RESTProvider{
+		MemoryProvider: &MemoryProvider{
+			dbHandle: newMemoryProviderHandle(""),
+		},
+		baseURL: baseURL,
+	}
+}
+
+func TestRESTProviderUsers(t *testing.T) {
+	service := &testRESTService{
+		users: make(map[string]User),
+	}
+	server := httptest.NewServer(service)
+	defer server.Close()
+
+	p := newTestRESTProvider(server.URL)
+	err := p.checkAvailability()
+	assert.NoError(t, err)
+
+	user := getTestUser("rest_user")
+	err = p.addUser(&user)
+	require.NoError(t, err)
+	// uniqueness is enforced by the remote service, no lookup is done before adding
+	assert.Equal(t, []string{"GET /users", "POST /users"}, service.getRequests())
+	user = getTestUser("rest_user")
+	err = p.addUser(&user)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "already exists")
+	}
+	assert.Equal(t, []string{"POST /users"}, service.getRequests())
+
+	_, err = p.userExists("missing_user")
+	assert.IsType(t, &RecordNotFoundError{}, err)
+
+	u, err := p.validateUserAndPass(user.Username, "password", "127.0.0.1", "SSH")
+	assert.NoError(t, err)
+	assert.Equal(t, user.Username, u.Username)
+	_, err = p.validateUserAndPass(user.Username, "wrong", "127.0.0.1", "SSH")
+	assert.Error(t, err)
+
+	err = p.updateQuota(user.Username, 2, 100, false)
+	assert.NoError(t, err)
+	files, size, err := p.getUsedQuota(user.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, files)
+	assert.Equal(t, int64(100), size)
+	err = p.updateTransferQuota(user.Username, 10, 20, 1)
+	assert.NoError(t, err)
+	ul, dl, periodStart, err := p.getUsedTransferQuota(user.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), ul)
+	assert.Equal(t, int64(20), dl)
+	assert.Equal(t, int64(1), periodStart)
+	err = p.updateLastLogin(user.Username, "SSH")
+	assert.NoError(t, err)
+
+	// quota and last login are preserved on update
+	u.MaxSessions = 2
+	err = p.updateUser(&u)
+	assert.NoError(t, err)
+	u, err = p.userExists(user.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, u.MaxSessions)
+	assert.Equal(t, 2, u.UsedQuotaFiles)
+	assert.Equal(t, int64(10), u.UsedUploadDataTransfer)
+	assert.Equal(t, "SSH", u.LastLoginProtocol)
+	assert.Greater(t, u.LastLogin, int64(0))
+
+	user1 := getTestUser("rest_user1")
+	err = p.addUser(&user1)
+	assert.NoError(t, err)
+	users, err := p.getUsers(10, 0, OrderDESC)
+	assert.NoError(t, err)
+	if assert.Len(t, users, 2) {
+		assert.Equal(t, user1.Username, users[0].Username)
+		assert.Empty(t, users[0].Password)
+	}
+	users, err = p.dumpUsers()
+	assert.NoError(t, err)
+	if assert.Len(t, users, 2) {
+		assert.Equal(t, user.Username, users[0].Username)
+		assert.NotEmpty(t, users[0].Password)
+	}
+
+	err = p.deleteUser(&u)
+	assert.NoError(t, err)
+	err = p.deleteUser(&user1)
+	assert.NoError(t, err)
+	assert.Len(t, service.users, 0)
+	err = p.deleteUser(&user1)
+	assert.IsType(t, &RecordNotFoundError{}, err)
+
+	server.Close()
+	err = p.checkAvailability()
+	assert.Error(t, err)
+}
+
+func TestRESTProviderVirtualFolders(t *testing.T) {
+	service := &testRESTService{
+		users: make(map[string]User),
+	}
+	server := httptest.NewServer(service)
+	defer server.Close()
+
+	p := newTestRESTProvider(server.URL)
+	folderName := "rest_folder"
+	mappedPath := filepath.Join(os.TempDir(), folderName)
+	user := getTestUser("rest_user_folders")
+	user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name:       folderName,
+			MappedPath: mappedPath,
+		},
+		VirtualPath: "/vdir",
+		QuotaFiles:  -1,
+		QuotaSize:   -1,
+	})
+	err := p.addUser(&user)
+	require.NoError(t, err)
+
+	folder, err := p.getFolderByName(folderName)
+	assert.NoError(t, err)
+	assert.Equal(t, mappedPath, folder.MappedPath)
+	assert.Equal(t, []string{user.Username}, folder.Users)
+	err = p.updateFolderQuota(folderName, 3, 300, true)
+	assert.NoError(t, err)
+	// the folder fields are read from the memory provider
+	u, err := p.userExists(user.Username)
+	assert.NoError(t, err)
+	if assert.Len(t, u.VirtualFolders, 1) {
+		assert.Equal(t, folder.ID, u.VirtualFolders[0].ID)
+		assert.Equal(t, 3, u.VirtualFolders[0].UsedQuotaFiles)
+		assert.Equal(t, int64(300), u.VirtualFolders[0].UsedQuotaSize)
+		assert.Equal(t, "/vdir", u.VirtualFolders[0].VirtualPath)
+	}
+	// folders referenced by remote users are added if missing
+	p.clear()
+	_, err = p.getFolderByName(folderName)
+	assert.IsType(t, &RecordNotFoundError{}, err)
+	users, err := p.getUsers(10, 0, OrderASC)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+	folder, err = p.getFolderByName(folderName)
+	assert.NoError(t, err)
+	assert.Equal(t, mappedPath, folder.MappedPath)
+	assert.Equal(t, []string{user.Username}, folder.Users)
+	// removing the folder from the user updates the mapping
+	u.VirtualFolders = nil
+	err = p.updateUser(&u)
+	assert.NoError(t, err)
+	folder, err = p.getFolderByName(folderName)
+	assert.NoError(t, err)
+	assert.Len(t, folder.Users, 0)
+	// deleting a folder removes it from the remote users
+	u.VirtualFolders = user.VirtualFolders
+	err = p.updateUser(&u)
+	assert.NoError(t, err)
+	folder, err = p.getFolderByName(folderName)
+	assert.NoError(t, err)
+	assert.Len(t, folder.Users, 1)
+	err = p.deleteFolder(&folder)
+	assert.NoError(t, err)
+	_, err = p.getFolderByName(folderName)
+	assert.IsType(t, &RecordNotFoundError{}, err)
+	assert.Len(t, service.users[user.Username].VirtualFolders, 0)
+	u, err = p.userExists(user.Username)
+	assert.NoError(t, err)
+	assert.Len(t, u.VirtualFolders, 0)
+
+	err = p.deleteUser(&u)
+	assert.NoError(t, err)
+}
+
+func TestRESTProviderErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/users/wrong") {
+			json.NewEncoder(w).Encode(User{Username: "another"}) //nolint:errcheck
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	p := newTestRESTProvider(server.URL)
+	user := getTestUser("rest_user_errors")
+	err := p.addUser(&user)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unexpected status code")
+	}
+	_, err = p.userExists("wrong_user")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unexpected username")
+	}
+	_, err = p.getUsers(10, 0, OrderASC)
+	assert.Error(t, err)
+	_, err = p.dumpUsers()
+	assert.Error(t, err)
+	err = p.updateQuota(user.Username, 1, 1, false)
+	assert.Error(t, err)
+	_, _, err = p.validateUserAndPubKey(user.Username, []byte("key"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,306 @@
+package dataprovider
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// ShareScope defines the supported share scopes
+type ShareScope int
+
+// Supported share scopes
+const (
+	// the shared paths can be downloaded
+	ShareScopeRead ShareScope = iota + 1
+	// files can be uploaded inside the shared path
+	ShareScopeWrite
+)
+
+// RedactedSharePassword is returned, instead of the hashed password, for the password
+// protected shares. Updating a share with this password preserves the current one
+const RedactedSharePassword = "[**redacted**]"
+
+// Share defines a public share for some files and directories of a user.
+// The shares are accessed without authentication using the share id,
+// they can be password protected, restricted to some networks, can expire
+// and can be used a limited number of times
+type Share struct {
+	// Database unique identifier
+	ID int64 `json:"-"`
+	// Unique share identifier, it is used to build the public share link
+	ShareID     string     `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Scope       ShareScope `json:"scope"`
+	// Paths to share, relative to the user home directory.
+	// A share with write scope must have exactly one path, a directory
+	Paths []string `json:"paths"`
+	// Username of the user that owns the shared files
+	Username  string `json:"username"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+	// 0 means never used
+	LastUseAt int64 `json:"last_use_at"`
+	// 0 means never expire
+	ExpiresAt int64 `json:"expires_at"`
+	// optional password, we store the hash
+	Password string `json:"password,omitempty"`
+	// maximum number of uses, 0 means unlimited
+	MaxTokens int `json:"max_tokens"`
+	// number of uses, it is updated when the share is used and it cannot be
+	// changed using the update method
+	UsedTokens int `json:"used_tokens"`
+	// networks, in CIDR notation, allowed to use the share, empty means all networks
+	AllowFrom []string `json:"allow_from,omitempty"`
+}
+
+func (s *Share) getACopy() Share {
+	paths := make([]string, len(s.Paths))
+	copy(paths, s.Paths)
+	allowFrom := make([]string, len(s.AllowFrom))
+	copy(allowFrom, s.AllowFrom)
+
+	return Share{
+		ID:          s.ID,
+		ShareID:     s.ShareID,
+		Name:        s.Name,
+		Description: s.Description,
+		Scope:       s.Scope,
+		Paths:       paths,
+		Username:    s.Username,
+		CreatedAt:   s.CreatedAt,
+		UpdatedAt:   s.UpdatedAt,
+		LastUseAt:   s.LastUseAt,
+		ExpiresAt:   s.ExpiresAt,
+		Password:    s.Password,
+		MaxTokens:   s.MaxTokens,
+		UsedTokens:  s.UsedTokens,
+		AllowFrom:   allowFrom,
+	}
+}
+
+// HideConfidentialData hides share confidential data
+func (s *Share) HideConfidentialData() {
+	if s.Password != "" {
+		s.Password = RedactedSharePassword
+	}
+}
+
+// IsExpired returns true if the share is expired
+func (s *Share) IsExpired() bool {
+	return s.ExpiresAt > 0 && s.ExpiresAt < utils.GetTimeAsMsSinceEpoch(time.Now())
+}
+
+// IsUsedUp returns true if the share reached the maximum number of uses
+func (s *Share) IsUsedUp() bool {
+	return s.MaxTokens > 0 && s.UsedTokens >= s.MaxTokens
+}
+
+// hasTokens returns true if the share can be used the given number of times
+func (s *Share) hasTokens(numTokens int) bool {
+	return s.MaxTokens == 0 || s.UsedTokens+numTokens <= s.MaxTokens
+}
+
+// IsRemoteAddrAllowed returns true if the share can be used from the given IP address
+func (s *Share) IsRemoteAddrAllowed(ip string) bool {
+	if len(s.AllowFrom) == 0 {
+		return true
+	}
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+	for _, network := range s.AllowFrom {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			continue
+		}
+		if ipNet.Contains(parsedIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPassword returns an error if the share is password protected and the given
+// password does not match
+func (s *Share) CheckPassword(password string) error {
+	if s.Password == "" {
+		return nil
+	}
+	match, err := compareSupportedAlgoHash(password, s.Password)
+	if err != nil {
+		return err
+	}
+	if !match {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// IsPathShared returns true if the given virtual path is one of the shared paths
+// or it is inside a shared directory
+func (s *Share) IsPathShared(virtualPath string) bool {
+	virtualPath = utils.CleanPath(virtualPath)
+	for _, p := range s.Paths {
+		if virtualPath == p || p == "/" || strings.HasPrefix(virtualPath, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Share) hashPassword() error {
+	if s.Password != "" && !isHashedWithSupportedAlgo(s.Password) {
+		hashed, err := hashPlainPassword(s.Password)
+		if err != nil {
+			return err
+		}
+		s.Password = hashed
+	}
+	return nil
+}
+
+func (s *Share) validatePaths() error {
+	var paths []string
+	for _, p := range s.Paths {
+		if p == "" {
+			continue
+		}
+		paths = append(paths, utils.CleanPath(p))
+	}
+	s.Paths = utils.RemoveDuplicates(paths)
+	if len(s.Paths) == 0 {
+		return &ValidationError{err: "at least a path to share is required"}
+	}
+	if s.Scope == ShareScopeWrite && len(s.Paths) > 1 {
+		return &ValidationError{err: "the write scope requires exactly one path"}
+	}
+	return nil
+}
+
+func (s *Share) validate() error {
+	if s.ShareID == "" {
+		return &ValidationError{err: "share id is mandatory"}
+	}
+	if s.Name == "" {
+		return &ValidationError{err: "name is mandatory"}
+	}
+	if s.Scope != ShareScopeRead && s.Scope != ShareScopeWrite {
+		return &ValidationError{err: fmt.Sprintf("invalid scope: %v", s.Scope)}
+	}
+	if err := s.validatePaths(); err != nil {
+		return err
+	}
+	if s.MaxTokens < 0 {
+		return &ValidationError{err: fmt.Sprintf("invalid max tokens: %v", s.MaxTokens)}
+	}
+	if s.UsedTokens < 0 {
+		s.UsedTokens = 0
+	}
+	s.AllowFrom = utils.RemoveDuplicates(s.AllowFrom)
+	for _, network := range s.AllowFrom {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not parse allow from entry %#v : %v", network, err)}
+		}
+	}
+	if s.Username == "" {
+		return &ValidationError{err: "username is mandatory"}
+	}
+	user, err := provider.userExists(s.Username)
+	if err != nil {
+		return &ValidationError{err: fmt.Sprintf("unable to check share user %v: %v", s.Username, err)}
+	}
+	for _, p := range s.Paths {
+		if s.Scope == ShareScopeRead && !user.HasPerms([]string{PermListItems, PermDownload}, p) {
+			return &ValidationError{err: fmt.Sprintf("the user %#v cannot list and download %#v", user.Username, p)}
+		}
+		if s.Scope == ShareScopeWrite && !user.HasPerm(PermUpload, p) {
+			return &ValidationError{err: fmt.Sprintf("the user %#v cannot upload to %#v", user.Username, p)}
+		}
+	}
+	return s.hashPassword()
+}
+
+// ShareExists returns the share with the given ID if it exists.
+// If username is not empty the share must belong to this user
+func ShareExists(shareID, username string) (Share, error) {
+	if shareID == "" {
+		return Share{}, &RecordNotFoundError{err: fmt.Sprintf("share %#v does not exist", shareID)}
+	}
+	share, err := provider.shareExists(shareID)
+	if err != nil {
+		return share, err
+	}
+	if username != "" && share.Username != username {
+		return Share{}, &RecordNotFoundError{err: fmt.Sprintf("share %#v does not exist", shareID)}
+	}
+	return share, nil
+}
+
+// AddShare adds a new share, a random share id is generated if not provided
+func AddShare(share *Share) error {
+	if share.ShareID == "" {
+		share.ShareID = xid.New().String()
+	}
+	err := provider.addShare(share)
+	if err == nil {
+		addProviderChangeEvent(AuditActionAdd, AuditObjectShare, share.ShareID, share.Username)
+	}
+	return err
+}
+
+// UpdateShare updates an existing share, the usage counters are preserved
+func UpdateShare(share *Share) error {
+	if share.Password == RedactedSharePassword {
+		current, err := provider.shareExists(share.ShareID)
+		if err != nil {
+			return err
+		}
+		share.Password = current.Password
+	}
+	err := provider.updateShare(share)
+	if err == nil {
+		addProviderChangeEvent(AuditActionUpdate, AuditObjectShare, share.ShareID, share.Username)
+	}
+	return err
+}
+
+// DeleteShare deletes the share with the given ID.
+// If username is not empty the share must belong to this user
+func DeleteShare(shareID, username string) error {
+	share, err := ShareExists(shareID, username)
+	if err != nil {
+		return err
+	}
+	err = provider.deleteShare(&share)
+	if err == nil {
+		addProviderChangeEvent(AuditActionDelete, AuditObjectShare, share.ShareID, share.Username)
+	}
+	return err
+}
+
+// GetShares returns an array of shares respecting limit and offset.
+// If username is not empty only the shares for this user are returned
+func GetShares(limit, offset int, order, username string) ([]Share, error) {
+	return provider.getShares(limit, offset, order, username)
+}
+
+// UpdateShareLastUse increments the used tokens for the given share and updates its last use time.
+// The tokens are checked and incremented atomically, ErrShareUsedUp is returned if the share
+// has not enough tokens left. It is called before each download or upload using the public share endpoint
+func UpdateShareLastUse(share *Share, numTokens int) error {
+	if err := provider.updateShareLastUse(share.ShareID, numTokens); err != nil {
+		providerLog(logger.LevelWarn, "unable to update last use for share %#v: %v", share.ShareID, err)
+		return err
+	}
+	providerLog(logger.LevelDebug, "last use updated for share %#v, tokens: %v", share.ShareID, numTokens)
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/drakkan/sftpgo/logger"
@@ -15,7 +16,7 @@ import (
 )
 
 const (
-	sqlDatabaseVersion     = 8
+	sqlDatabaseVersion     = 17
 	defaultSQLQueryTimeout = 10 * time.Second
 	longSQLQueryTimeout    = 60 * time.Second
 )
@@ -193,6 +194,617 @@ func sqlCommonDumpAdmins(dbHandle sqlQuerier) ([]Admin, error) {
 	return admins, rows.Err()
 }
 
+func sqlCommonGetAPIKeyByID(keyID string, dbHandle sqlQuerier) (APIKey, error) {
+	var apiKey APIKey
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getAPIKeyByIDQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return apiKey, err
+	}
+	defer stmt.Close()
+	row := stmt.QueryRowContext(ctx, keyID)
+
+	return getAPIKeyFromDbRow(row)
+}
+
+func sqlCommonAddAPIKey(apiKey *APIKey, dbHandle *sql.DB) error {
+	err := apiKey.validate()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getAddAPIKeyQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	apiKey.CreatedAt = now
+	apiKey.UpdatedAt = now
+	apiKey.LastUseAt = 0
+	restrictions, err := json.Marshal(apiKey.Restrictions)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx, apiKey.KeyID, apiKey.Name, apiKey.Key, apiKey.Scope, apiKey.CreatedAt,
+		apiKey.UpdatedAt, apiKey.LastUseAt, apiKey.ExpiresAt, apiKey.Description, apiKey.User, apiKey.Admin,
+		string(restrictions))
+	return err
+}
+
+func sqlCommonUpdateAPIKey(apiKey *APIKey, dbHandle *sql.DB) error {
+	err := apiKey.validate()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getUpdateAPIKeyQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+
+	restrictions, err := json.Marshal(apiKey.Restrictions)
+	if err != nil {
+		return err
+	}
+	apiKey.UpdatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	res, err := stmt.ExecContext(ctx, apiKey.Name, apiKey.Scope, apiKey.ExpiresAt, apiKey.Description,
+		apiKey.UpdatedAt, apiKey.User, apiKey.Admin, string(restrictions), apiKey.KeyID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return &RecordNotFoundError{err: fmt.Sprintf("API key %#v does not exist", apiKey.KeyID)}
+	}
+	return nil
+}
+
+func sqlCommonDeleteAPIKey(apiKey *APIKey, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getDeleteAPIKeyQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, apiKey.KeyID)
+	return err
+}
+
+func sqlCommonGetAPIKeys(limit, offset int, order string, dbHandle sqlQuerier) ([]APIKey, error) {
+	apiKeys := make([]APIKey, 0, limit)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getAPIKeysQuery(order)
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, limit, offset)
+	if err != nil {
+		return apiKeys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		k, err := getAPIKeyFromDbRow(rows)
+		if err != nil {
+			return apiKeys, err
+		}
+		k.HideConfidentialData()
+		apiKeys = append(apiKeys, k)
+	}
+
+	return apiKeys, rows.Err()
+}
+
+func sqlCommonDumpAPIKeys(dbHandle sqlQuerier) ([]APIKey, error) {
+	apiKeys := make([]APIKey, 0, 30)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getDumpAPIKeysQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return apiKeys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		k, err := getAPIKeyFromDbRow(rows)
+		if err != nil {
+			return apiKeys, err
+		}
+		apiKeys = append(apiKeys, k)
+	}
+
+	return apiKeys, rows.Err()
+}
+
+func sqlCommonUpdateAPIKeyLastUse(keyID string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getUpdateAPIKeyLastUseQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, utils.GetTimeAsMsSinceEpoch(time.Now()), keyID)
+	if err == nil {
+		providerLog(logger.LevelDebug, "last use updated for key %#v", keyID)
+	} else {
+		providerLog(logger.LevelWarn, "unable to update last use for key %#v: %v", keyID, err)
+	}
+	return err
+}
+
+func sqlCommonGetShareByID(shareID string, dbHandle sqlQuerier) (Share, error) {
+	var share Share
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getShareByIDQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return share, err
+	}
+	defer stmt.Close()
+	row := stmt.QueryRowContext(ctx, shareID)
+
+	return getShareFromDbRow(row)
+}
+
+func sqlCommonAddShare(share *Share, dbHandle *sql.DB) error {
+	err := share.validate()
+	if err != nil {
+		return err
+	}
+	paths, err := json.Marshal(share.Paths)
+	if err != nil {
+		return err
+	}
+	var allowFrom []byte
+	if len(share.AllowFrom) > 0 {
+		allowFrom, err = json.Marshal(share.AllowFrom)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getAddShareQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	share.CreatedAt = now
+	share.UpdatedAt = now
+	share.LastUseAt = 0
+	share.UsedTokens = 0
+	_, err = stmt.ExecContext(ctx, share.ShareID, share.Name, share.Description, share.Scope, string(paths),
+		share.CreatedAt, share.UpdatedAt, share.LastUseAt, share.ExpiresAt, share.Password, share.MaxTokens,
+		share.UsedTokens, string(allowFrom), share.Username)
+	return err
+}
+
+func sqlCommonUpdateShare(share *Share, dbHandle *sql.DB) error {
+	err := share.validate()
+	if err != nil {
+		return err
+	}
+	paths, err := json.Marshal(share.Paths)
+	if err != nil {
+		return err
+	}
+	var allowFrom []byte
+	if len(share.AllowFrom) > 0 {
+		allowFrom, err = json.Marshal(share.AllowFrom)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getUpdateShareQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+
+	share.UpdatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	res, err := stmt.ExecContext(ctx, share.Name, share.Description, share.Scope, string(paths), share.UpdatedAt,
+		share.ExpiresAt, share.Password, share.MaxTokens, string(allowFrom), share.Username, share.ShareID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return &RecordNotFoundError{err: fmt.Sprintf("share %#v does not exist", share.ShareID)}
+	}
+	return nil
+}
+
+func sqlCommonDeleteShare(share *Share, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getDeleteShareQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, share.ShareID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return &RecordNotFoundError{err: fmt.Sprintf("share %#v does not exist", share.ShareID)}
+	}
+	return nil
+}
+
+func sqlCommonGetShares(limit, offset int, order, username string, dbHandle sqlQuerier) ([]Share, error) {
+	shares := make([]Share, 0, limit)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getSharesQuery(order, username)
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var rows *sql.Rows
+	if username != "" {
+		rows, err = stmt.QueryContext(ctx, username, limit, offset)
+	} else {
+		rows, err = stmt.QueryContext(ctx, limit, offset)
+	}
+	if err != nil {
+		return shares, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := getShareFromDbRow(rows)
+		if err != nil {
+			return shares, err
+		}
+		s.HideConfidentialData()
+		shares = append(shares, s)
+	}
+
+	return shares, rows.Err()
+}
+
+func sqlCommonDumpShares(dbHandle sqlQuerier) ([]Share, error) {
+	shares := make([]Share, 0, 30)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getDumpSharesQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return shares, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := getShareFromDbRow(rows)
+		if err != nil {
+			return shares, err
+		}
+		shares = append(shares, s)
+	}
+
+	return shares, rows.Err()
+}
+
+func sqlCommonUpdateShareLastUse(shareID string, numTokens int, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getUpdateShareLastUseQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, utils.GetTimeAsMsSinceEpoch(time.Now()), numTokens, shareID, numTokens)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		// the share does not exist or it has not enough tokens left
+		if _, err := sqlCommonGetShareByID(shareID, dbHandle); err != nil {
+			return err
+		}
+		return ErrShareUsedUp
+	}
+	return nil
+}
+
+func sqlCommonAddQueuedEvent(event *QueuedEvent, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getAddQueuedEventQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	if config.Driver == PGSQLDataProviderName {
+		return stmt.QueryRowContext(ctx, event.Payload, event.Attempts, event.CreatedAt, event.NextAttemptAt).Scan(&event.ID)
+	}
+	res, err := stmt.ExecContext(ctx, event.Payload, event.Attempts, event.CreatedAt, event.NextAttemptAt)
+	if err != nil {
+		return err
+	}
+	event.ID, err = res.LastInsertId()
+	return err
+}
+
+func sqlCommonGetQueuedEvents(limit int, before int64, dbHandle *sql.DB) ([]QueuedEvent, error) {
+	events := make([]QueuedEvent, 0, limit)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getQueuedEventsQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, before, limit)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event QueuedEvent
+		err = rows.Scan(&event.ID, &event.Payload, &event.Attempts, &event.CreatedAt, &event.NextAttemptAt)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func sqlCommonUpdateQueuedEvent(event *QueuedEvent, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getUpdateQueuedEventQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, event.Attempts, event.NextAttemptAt, event.ID)
+	return err
+}
+
+func sqlCommonDeleteQueuedEvent(id int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getDeleteQueuedEventQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, id)
+	return err
+}
+
+func sqlCommonAddConnectionRecord(record *ConnectionRecord, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getAddConnectionRecordQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	args := []interface{}{record.ConnectionID, record.Username, record.IP, record.Protocol, record.StartTime,
+		record.EndTime, record.BytesUploaded, record.BytesDownloaded}
+	if config.Driver == PGSQLDataProviderName {
+		return stmt.QueryRowContext(ctx, args...).Scan(&record.ID)
+	}
+	res, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+	record.ID, err = res.LastInsertId()
+	return err
+}
+
+func sqlCommonSearchConnectionRecords(filters ConnectionHistoryFilters, limit, offset int, order string,
+	dbHandle *sql.DB) ([]ConnectionRecord, error) {
+	records := make([]ConnectionRecord, 0, limit)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q, args := getSearchConnectionRecordsQuery(&filters, limit, offset, order)
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return records, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record ConnectionRecord
+		err = rows.Scan(&record.ID, &record.ConnectionID, &record.Username, &record.IP, &record.Protocol,
+			&record.StartTime, &record.EndTime, &record.BytesUploaded, &record.BytesDownloaded)
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+func sqlCommonCleanupConnectionRecords(before int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getCleanupConnectionRecordsQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, before)
+	return err
+}
+
+func sqlCommonAddAuditEvent(event *AuditEvent, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getAddAuditEventQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	args := []interface{}{event.Timestamp, event.Action, event.Username, event.IP, event.Protocol,
+		event.ObjectType, event.ObjectName, event.Details}
+	if config.Driver == PGSQLDataProviderName {
+		return stmt.QueryRowContext(ctx, args...).Scan(&event.ID)
+	}
+	res, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+	event.ID, err = res.LastInsertId()
+	return err
+}
+
+func sqlCommonSearchAuditEvents(filters AuditEventsFilters, limit, offset int, order string,
+	dbHandle *sql.DB) ([]AuditEvent, error) {
+	events := make([]AuditEvent, 0, limit)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q, args := getSearchAuditEventsQuery(&filters, limit, offset, order)
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event AuditEvent
+		err = rows.Scan(&event.ID, &event.Timestamp, &event.Action, &event.Username, &event.IP, &event.Protocol,
+			&event.ObjectType, &event.ObjectName, &event.Details)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func sqlCommonCleanupAuditEvents(before int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getCleanupAuditEventsQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, before)
+	return err
+}
+
 func sqlCommonGetUserByUsername(username string, dbHandle sqlQuerier) (User, error) {
 	var user User
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
@@ -265,6 +877,48 @@ func sqlCommonUpdateQuota(username string, filesAdd int, sizeAdd int64, reset bo
 	return err
 }
 
+func sqlCommonUpdateTransferQuota(username string, uploadSize, downloadSize, periodStart int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getUpdateTransferQuotaQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	// the period start must be updated last, MySQL evaluates the assignments from left to right
+	_, err = stmt.ExecContext(ctx, periodStart, uploadSize, uploadSize, periodStart, downloadSize, downloadSize,
+		periodStart, username)
+	if err == nil {
+		providerLog(logger.LevelDebug, "transfer quota updated for user %#v, upload increment: %v download increment: %v",
+			username, uploadSize, downloadSize)
+	} else {
+		providerLog(logger.LevelWarn, "error updating transfer quota for user %#v: %v", username, err)
+	}
+	return err
+}
+
+func sqlCommonGetUsedTransferQuota(username string, dbHandle *sql.DB) (int64, int64, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getTransferQuotaQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return 0, 0, 0, err
+	}
+	defer stmt.Close()
+
+	var uploadSize, downloadSize, periodStart int64
+	err = stmt.QueryRowContext(ctx, username).Scan(&uploadSize, &downloadSize, &periodStart)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error getting transfer quota for user: %v, error: %v", username, err)
+		return 0, 0, 0, err
+	}
+	return uploadSize, downloadSize, periodStart, err
+}
+
 func sqlCommonGetUsedQuota(username string, dbHandle *sql.DB) (int, int64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -286,7 +940,7 @@ func sqlCommonGetUsedQuota(username string, dbHandle *sql.DB) (int, int64, error
 	return usedFiles, usedSize, err
 }
 
-func sqlCommonUpdateLastLogin(username string, dbHandle *sql.DB) error {
+func sqlCommonUpdateLastLogin(username, protocol string, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
 	q := getUpdateLastLoginQuery()
@@ -296,9 +950,9 @@ func sqlCommonUpdateLastLogin(username string, dbHandle *sql.DB) error {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.ExecContext(ctx, utils.GetTimeAsMsSinceEpoch(time.Now()), username)
+	_, err = stmt.ExecContext(ctx, utils.GetTimeAsMsSinceEpoch(time.Now()), protocol, username)
 	if err == nil {
-		providerLog(logger.LevelDebug, "last login updated for user %#v", username)
+		providerLog(logger.LevelDebug, "last login updated for user %#v, protocol: %v", username, protocol)
 	} else {
 		providerLog(logger.LevelWarn, "error updating last login for user %#v: %v", username, err)
 	}
@@ -342,7 +996,7 @@ func sqlCommonAddUser(user *User, dbHandle *sql.DB) error {
 	}
 	_, err = stmt.ExecContext(ctx, user.Username, user.Password, string(publicKeys), user.HomeDir, user.UID, user.GID, user.MaxSessions, user.QuotaSize,
 		user.QuotaFiles, string(permissions), user.UploadBandwidth, user.DownloadBandwidth, user.Status, user.ExpirationDate, string(filters),
-		string(fsConfig), user.AdditionalInfo)
+		string(fsConfig), user.AdditionalInfo, user.FsConfig.Provider, getUserGroupsSearchValue(user))
 	if err != nil {
 		return err
 	}
@@ -390,7 +1044,7 @@ func sqlCommonUpdateUser(user *User, dbHandle *sql.DB) error {
 	}
 	_, err = stmt.ExecContext(ctx, user.Password, string(publicKeys), user.HomeDir, user.UID, user.GID, user.MaxSessions, user.QuotaSize,
 		user.QuotaFiles, string(permissions), user.UploadBandwidth, user.DownloadBandwidth, user.Status, user.ExpirationDate,
-		string(filters), string(fsConfig), user.AdditionalInfo, user.ID)
+		string(filters), string(fsConfig), user.AdditionalInfo, user.FsConfig.Provider, getUserGroupsSearchValue(user), user.ID)
 	if err != nil {
 		return err
 	}
@@ -401,6 +1055,76 @@ func sqlCommonUpdateUser(user *User, dbHandle *sql.DB) error {
 	return tx.Commit()
 }
 
+// sqlCommonUpdateSecrets saves the filters and the filesystem config, containing the
+// secrets, for the given users and admins inside a single transaction.
+// The objects are not validated, they must be loaded from the data provider
+func sqlCommonUpdateSecrets(users []User, admins []Admin, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+
+	tx, err := dbHandle.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := sqlCommonUpdateUsersSecrets(ctx, users, tx); err != nil {
+		tx.Rollback() //nolint:errcheck
+		return err
+	}
+	if err := sqlCommonUpdateAdminsSecrets(ctx, admins, tx); err != nil {
+		tx.Rollback() //nolint:errcheck
+		return err
+	}
+	return tx.Commit()
+}
+
+func sqlCommonUpdateUsersSecrets(ctx context.Context, users []User, tx *sql.Tx) error {
+	q := getUpdateUserSecretsQuery()
+	stmt, err := tx.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+
+	for idx := range users {
+		filters, err := users[idx].GetFiltersAsJSON()
+		if err != nil {
+			return err
+		}
+		fsConfig, err := users[idx].GetFsConfigAsJSON()
+		if err != nil {
+			return err
+		}
+		_, err = stmt.ExecContext(ctx, string(filters), string(fsConfig), users[idx].ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sqlCommonUpdateAdminsSecrets(ctx context.Context, admins []Admin, tx *sql.Tx) error {
+	q := getUpdateAdminSecretsQuery()
+	stmt, err := tx.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+
+	for idx := range admins {
+		filters, err := json.Marshal(admins[idx].Filters)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.ExecContext(ctx, string(filters), admins[idx].Username)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func sqlCommonDeleteUser(user *User, dbHandle *sql.DB) error {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
 	defer cancel()
@@ -481,6 +1205,109 @@ func sqlCommonGetUsers(limit int, offset int, order string, dbHandle sqlQuerier)
 	return getUsersWithVirtualFolders(users, dbHandle)
 }
 
+func sqlCommonSearchUsers(filters UserSearchFilters, limit, offset int, order string, dbHandle sqlQuerier) ([]User, error) {
+	users := make([]User, 0, limit)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q, args := getSearchUsersQuery(&filters, limit, offset, order)
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := getUserFromDbRow(rows)
+		if err != nil {
+			return users, err
+		}
+		u.HideConfidentialData()
+		users = append(users, u)
+	}
+	err = rows.Err()
+	if err != nil {
+		return users, err
+	}
+	return getUsersWithVirtualFolders(users, dbHandle)
+}
+
+// sqlCommonUpdateUsersSearchFields fills the columns used to search the users for the existing users
+func sqlCommonUpdateUsersSearchFields(ctx context.Context, tx *sql.Tx) error {
+	q := getDumpUsersQuery()
+	rows, err := tx.QueryContext(ctx, q)
+	if err != nil {
+		return err
+	}
+	var users []User
+	for rows.Next() {
+		u, err := getUserFromDbRow(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		users = append(users, u)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	q = getUpdateUserSearchFieldsQuery()
+	stmt, err := tx.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+
+	for idx := range users {
+		_, err = stmt.ExecContext(ctx, users[idx].FsConfig.Provider, getUserGroupsSearchValue(&users[idx]), users[idx].ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqlCommonUpdateDatabaseFrom16To17 executes the given sql statements, adding the columns
+// used to search the users, and fills these columns for the existing users
+func sqlCommonUpdateDatabaseFrom16To17(dbHandle *sql.DB, sqls []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+	tx, err := dbHandle.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, q := range sqls {
+		if strings.TrimSpace(q) == "" {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, q)
+		if err != nil {
+			tx.Rollback() //nolint:errcheck
+			return err
+		}
+	}
+	if err = sqlCommonUpdateUsersSearchFields(ctx, tx); err != nil {
+		tx.Rollback() //nolint:errcheck
+		return err
+	}
+	err = sqlCommonUpdateDatabaseVersion(ctx, tx, 17)
+	if err != nil {
+		tx.Rollback() //nolint:errcheck
+		return err
+	}
+	return tx.Commit()
+}
+
 func getAdminFromDbRow(row sqlScanner) (Admin, error) {
 	var admin Admin
 	var email, filters, additionalInfo, permissions sql.NullString
@@ -521,6 +1348,75 @@ func getAdminFromDbRow(row sqlScanner) (Admin, error) {
 	return admin, err
 }
 
+func getAPIKeyFromDbRow(row sqlScanner) (APIKey, error) {
+	var apiKey APIKey
+	var description, username, adminUsername, restrictions sql.NullString
+
+	err := row.Scan(&apiKey.ID, &apiKey.KeyID, &apiKey.Name, &apiKey.Key, &apiKey.Scope, &apiKey.CreatedAt,
+		&apiKey.UpdatedAt, &apiKey.LastUseAt, &apiKey.ExpiresAt, &description, &username, &adminUsername,
+		&restrictions)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return apiKey, &RecordNotFoundError{err: err.Error()}
+		}
+		return apiKey, err
+	}
+
+	if description.Valid {
+		apiKey.Description = description.String
+	}
+	if username.Valid {
+		apiKey.User = username.String
+	}
+	if adminUsername.Valid {
+		apiKey.Admin = adminUsername.String
+	}
+	if restrictions.Valid {
+		var keyRestrictions APIKeyRestrictions
+		err = json.Unmarshal([]byte(restrictions.String), &keyRestrictions)
+		if err == nil {
+			apiKey.Restrictions = keyRestrictions
+		}
+	}
+
+	return apiKey, nil
+}
+
+func getShareFromDbRow(row sqlScanner) (Share, error) {
+	var share Share
+	var description, password, allowFrom sql.NullString
+	var paths string
+
+	err := row.Scan(&share.ID, &share.ShareID, &share.Name, &description, &share.Scope, &paths, &share.Username,
+		&share.CreatedAt, &share.UpdatedAt, &share.LastUseAt, &share.ExpiresAt, &password, &share.MaxTokens,
+		&share.UsedTokens, &allowFrom)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return share, &RecordNotFoundError{err: err.Error()}
+		}
+		return share, err
+	}
+
+	if err = json.Unmarshal([]byte(paths), &share.Paths); err != nil {
+		return share, err
+	}
+	if description.Valid {
+		share.Description = description.String
+	}
+	if password.Valid {
+		share.Password = password.String
+	}
+	if allowFrom.Valid && allowFrom.String != "" {
+		if err = json.Unmarshal([]byte(allowFrom.String), &share.AllowFrom); err != nil {
+			return share, err
+		}
+	}
+
+	return share, nil
+}
+
 func getUserFromDbRow(row sqlScanner) (User, error) {
 	var user User
 	var permissions sql.NullString
@@ -529,11 +1425,13 @@ func getUserFromDbRow(row sqlScanner) (User, error) {
 	var filters sql.NullString
 	var fsConfig sql.NullString
 	var additionalInfo sql.NullString
+	var lastLoginProtocol sql.NullString
 
 	err := row.Scan(&user.ID, &user.Username, &password, &publicKey, &user.HomeDir, &user.UID, &user.GID, &user.MaxSessions,
 		&user.QuotaSize, &user.QuotaFiles, &permissions, &user.UsedQuotaSize, &user.UsedQuotaFiles, &user.LastQuotaUpdate,
 		&user.UploadBandwidth, &user.DownloadBandwidth, &user.ExpirationDate, &user.LastLogin, &user.Status, &filters, &fsConfig,
-		&additionalInfo)
+		&additionalInfo, &lastLoginProtocol, &user.UsedUploadDataTransfer, &user.UsedDownloadDataTransfer,
+		&user.DataTransferPeriodStart)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return user, &RecordNotFoundError{err: err.Error()}
@@ -579,6 +1477,9 @@ func getUserFromDbRow(row sqlScanner) (User, error) {
 	if additionalInfo.Valid {
 		user.AdditionalInfo = additionalInfo.String
 	}
+	if lastLoginProtocol.Valid {
+		user.LastLoginProtocol = lastLoginProtocol.String
+	}
 	user.SetEmptySecretsIfNil()
 	return user, err
 }
@@ -969,6 +1870,101 @@ func sqlCommonGetDatabaseVersion(dbHandle *sql.DB, showInitWarn bool) (schemaVer
 	return result, err
 }
 
+// sqlCommonSetPoolOptions applies the configured connection pool options to the given handle
+func sqlCommonSetPoolOptions(dbHandle *sql.DB) {
+	dbHandle.SetMaxOpenConns(config.PoolSize)
+	if config.MaxIdleConnections > 0 {
+		dbHandle.SetMaxIdleConns(config.MaxIdleConnections)
+	} else if config.PoolSize > 0 {
+		dbHandle.SetMaxIdleConns(config.PoolSize)
+	} else {
+		dbHandle.SetMaxIdleConns(2)
+	}
+	dbHandle.SetConnMaxLifetime(time.Duration(config.ConnectionMaxLifetime) * time.Second)
+}
+
+// sqlReadReplicas handles the optional read-only replicas for the SQL providers
+type sqlReadReplicas struct {
+	handles []*sql.DB
+	counter uint32
+}
+
+func newSQLReadReplicas(driverName string) (*sqlReadReplicas, error) {
+	replicas := &sqlReadReplicas{}
+	for idx, connectionString := range config.ReadReplicas {
+		if strings.TrimSpace(connectionString) == "" {
+			continue
+		}
+		dbHandle, err := sql.Open(driverName, connectionString)
+		if err != nil {
+			providerLog(logger.LevelWarn, "error creating %v read replica database handler, replica index: %v, error: %v",
+				driverName, idx, err)
+			replicas.close() //nolint:errcheck
+			return nil, err
+		}
+		providerLog(logger.LevelDebug, "%v read replica database handle created, replica index: %v, pool size: %v",
+			driverName, idx, config.PoolSize)
+		sqlCommonSetPoolOptions(dbHandle)
+		replicas.handles = append(replicas.handles, dbHandle)
+	}
+	return replicas, nil
+}
+
+// getHandle returns the next replica handle in a round-robin fashion or nil
+// if no replica is configured
+func (r *sqlReadReplicas) getHandle() *sql.DB {
+	if len(r.handles) == 0 {
+		return nil
+	}
+	idx := atomic.AddUint32(&r.counter, 1)
+	return r.handles[int(idx%uint32(len(r.handles)))]
+}
+
+// query executes the given read-only function against a replica, if any.
+// If the replica returns an error the function is executed again using the
+// primary handle. Only reads that can tolerate replication lag must use this
+// method, authoritative reads and reads inside write flows must use the primary
+func (r *sqlReadReplicas) query(primary *sql.DB, fn func(dbHandle *sql.DB) error) error {
+	dbHandle := r.getHandle()
+	if dbHandle == nil {
+		return fn(primary)
+	}
+	err := fn(dbHandle)
+	if err == nil {
+		return nil
+	}
+	providerLog(logger.LevelWarn, "error executing query on read replica, retrying on primary: %v", err)
+	return fn(primary)
+}
+
+// checkAvailability logs a warning for each unreachable replica. An unavailable
+// replica does not make the provider unavailable: the queries are retried on the primary
+func (r *sqlReadReplicas) checkAvailability() {
+	for idx, dbHandle := range r.handles {
+		if err := sqlCommonCheckAvailability(dbHandle); err != nil {
+			providerLog(logger.LevelWarn, "read replica unavailable, replica index: %v, error: %v", idx, err)
+		}
+	}
+}
+
+func (r *sqlReadReplicas) close() error {
+	var result error
+	for _, dbHandle := range r.handles {
+		if err := dbHandle.Close(); err != nil {
+			result = err
+		}
+	}
+	return result
+}
+
+func sqlCommonCheckDatabaseVersion(dbHandle *sql.DB) error {
+	dbVersion, err := sqlCommonGetDatabaseVersion(dbHandle, true)
+	if err != nil {
+		return err
+	}
+	return checkSchemaVersion(dbVersion.Version, sqlDatabaseVersion)
+}
+
 func sqlCommonUpdateDatabaseVersion(ctx context.Context, dbHandle sqlQuerier, version int) error {
 	q := getUpdateDBVersionQuery()
 	stmt, err := dbHandle.PrepareContext(ctx, q)
@@ -981,6 +1977,31 @@ func sqlCommonUpdateDatabaseVersion(ctx context.Context, dbHandle sqlQuerier, ve
 	return err
 }
 
+// sqlCommonResetDatabase drops all the tables, dropTableSQL is the provider specific
+// DROP TABLE statement with a placeholder for the table name
+func sqlCommonResetDatabase(dbHandle *sql.DB, dropTableSQL string) error {
+	logger.InfoToConsole("resetting database")
+	providerLog(logger.LevelInfo, "resetting database")
+	// the referencing tables must be dropped first
+	tables := []string{sqlTableFoldersMapping, sqlTableAPIKeys, sqlTableShares, sqlTableUsers, sqlTableFolders,
+		sqlTableAdmins, sqlTableEventsQueue, sqlTableConnHistory, sqlTableAuditEvents, sqlTableSchemaVersion}
+
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+	tx, err := dbHandle.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(dropTableSQL, table))
+		if err != nil {
+			tx.Rollback() //nolint:errcheck
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func sqlCommonExecSQLAndUpdateDBVersion(dbHandle *sql.DB, sql []string, newVersion int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
 	defer cancel()
@@ -0,0 +1,100 @@
+package dataprovider
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLReadReplicas(t *testing.T) {
+	primary := &sql.DB{}
+	replicas := &sqlReadReplicas{}
+	assert.Nil(t, replicas.getHandle())
+
+	var used []*sql.DB
+	fn := func(dbHandle *sql.DB) error {
+		used = append(used, dbHandle)
+		return nil
+	}
+	err := replicas.query(primary, fn)
+	assert.NoError(t, err)
+	if assert.Len(t, used, 1) {
+		assert.True(t, primary == used[0])
+	}
+
+	replica1 := &sql.DB{}
+	replica2 := &sql.DB{}
+	replicas.handles = []*sql.DB{replica1, replica2}
+	used = nil
+	for i := 0; i < 4; i++ {
+		err = replicas.query(primary, fn)
+		assert.NoError(t, err)
+	}
+	if assert.Len(t, used, 4) {
+		assert.True(t, used[0] == used[2])
+		assert.True(t, used[1] == used[3])
+		assert.True(t, used[0] != used[1])
+		for _, dbHandle := range used {
+			assert.True(t, dbHandle != primary)
+		}
+	}
+	// a replica error must be retried on the primary
+	used = nil
+	errReplica := errors.New("replica error")
+	err = replicas.query(primary, func(dbHandle *sql.DB) error {
+		used = append(used, dbHandle)
+		if dbHandle != primary {
+			return errReplica
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, used, 2) {
+		assert.True(t, primary != used[0])
+		assert.True(t, primary == used[1])
+	}
+	// the primary error is returned
+	errPrimary := errors.New("primary error")
+	err = replicas.query(primary, func(dbHandle *sql.DB) error {
+		if dbHandle != primary {
+			return errReplica
+		}
+		return errPrimary
+	})
+	assert.ErrorIs(t, err, errPrimary)
+}
+
+type unreachableConnector struct{}
+
+func (c *unreachableConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (c *unreachableConnector) Driver() driver.Driver {
+	return nil
+}
+
+func TestSQLReadReplicasAvailability(t *testing.T) {
+	replica := sql.OpenDB(&unreachableConnector{})
+	replicas := &sqlReadReplicas{
+		handles: []*sql.DB{replica},
+	}
+	defer replicas.close() //nolint:errcheck
+
+	assert.Error(t, sqlCommonCheckAvailability(replica))
+	// an unreachable replica must not make the provider unavailable
+	assert.NotPanics(t, replicas.checkAvailability)
+	// queries are retried on the primary
+	primary := &sql.DB{}
+	err := replicas.query(primary, func(dbHandle *sql.DB) error {
+		if dbHandle != primary {
+			return sqlCommonCheckAvailability(dbHandle)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
@@ -41,6 +41,56 @@ CONSTRAINT "unique_mapping" UNIQUE ("user_id", "folder_id"));
 CREATE INDEX "folders_mapping_folder_id_idx" ON "{{folders_mapping}}" ("folder_id");
 CREATE INDEX "folders_mapping_user_id_idx" ON "{{folders_mapping}}" ("user_id");
 INSERT INTO {{schema_version}} (version) VALUES (8);
+`
+	sqliteV9SQL = `CREATE TABLE "{{api_keys}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "name" varchar(255) NOT NULL,
+"key_id" varchar(50) NOT NULL UNIQUE, "api_key" varchar(255) NOT NULL UNIQUE, "scope" integer NOT NULL,
+"created_at" bigint NOT NULL, "updated_at" bigint NOT NULL, "last_use_at" bigint NOT NULL, "expires_at" bigint NOT NULL,
+"description" text NULL, "admin_id" integer NULL REFERENCES "{{admins}}" ("id") ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED,
+"user_id" integer NULL REFERENCES "{{users}}" ("id") ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED);
+CREATE INDEX "api_keys_admin_id_idx" ON "{{api_keys}}" ("admin_id");
+CREATE INDEX "api_keys_user_id_idx" ON "{{api_keys}}" ("user_id");
+`
+	sqliteV9DownSQL = `DROP TABLE "{{api_keys}}";`
+	sqliteV10SQL    = `ALTER TABLE "{{users}}" ADD COLUMN "last_login_protocol" varchar(20) NULL;`
+	sqliteV11SQL    = `ALTER TABLE "{{users}}" ADD COLUMN "used_upload_data_transfer" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "{{users}}" ADD COLUMN "used_download_data_transfer" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "{{users}}" ADD COLUMN "data_transfer_period_start" bigint NOT NULL DEFAULT 0;
+`
+	sqliteV12SQL = `CREATE TABLE "{{events_queue}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "payload" text NOT NULL,
+"attempts" integer NOT NULL, "created_at" bigint NOT NULL, "next_attempt_at" bigint NOT NULL);
+CREATE INDEX "events_queue_next_attempt_at_idx" ON "{{events_queue}}" ("next_attempt_at");
+`
+	sqliteV12DownSQL = `DROP TABLE "{{events_queue}}";`
+	sqliteV13SQL     = `CREATE TABLE "{{connection_history}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"connection_id" varchar(255) NOT NULL, "username" varchar(255) NOT NULL, "ip" varchar(50) NOT NULL,
+"protocol" varchar(20) NOT NULL, "start_time" bigint NOT NULL, "end_time" bigint NOT NULL,
+"bytes_uploaded" bigint NOT NULL, "bytes_downloaded" bigint NOT NULL);
+CREATE INDEX "connection_history_username_idx" ON "{{connection_history}}" ("username");
+CREATE INDEX "connection_history_start_time_idx" ON "{{connection_history}}" ("start_time");
+CREATE INDEX "connection_history_end_time_idx" ON "{{connection_history}}" ("end_time");
+`
+	sqliteV13DownSQL = `DROP TABLE "{{connection_history}}";`
+	sqliteV14SQL     = `CREATE TABLE "{{shares}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"share_id" varchar(60) NOT NULL UNIQUE, "name" varchar(255) NOT NULL, "description" varchar(512) NULL,
+"scope" integer NOT NULL, "paths" text NOT NULL, "created_at" bigint NOT NULL, "updated_at" bigint NOT NULL,
+"last_use_at" bigint NOT NULL, "expires_at" bigint NOT NULL, "password" text NULL, "max_tokens" integer NOT NULL,
+"used_tokens" integer NOT NULL, "allow_from" text NULL,
+"user_id" integer NOT NULL REFERENCES "{{users}}" ("id") ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED);
+CREATE INDEX "shares_user_id_idx" ON "{{shares}}" ("user_id");
+`
+	sqliteV14DownSQL = `DROP TABLE "{{shares}}";`
+	sqliteV15SQL     = `CREATE TABLE "{{audit_events}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"event_time" bigint NOT NULL, "action" varchar(50) NOT NULL, "username" varchar(255) NOT NULL,
+"ip" varchar(50) NOT NULL, "protocol" varchar(20) NOT NULL, "object_type" varchar(50) NOT NULL,
+"object_name" varchar(255) NOT NULL, "details" text NOT NULL);
+CREATE INDEX "audit_events_event_time_idx" ON "{{audit_events}}" ("event_time");
+CREATE INDEX "audit_events_username_idx" ON "{{audit_events}}" ("username");
+CREATE INDEX "audit_events_action_idx" ON "{{audit_events}}" ("action");
+`
+	sqliteV15DownSQL = `DROP TABLE "{{audit_events}}";`
+	sqliteV16SQL     = `ALTER TABLE "{{api_keys}}" ADD COLUMN "restrictions" text NULL;`
+	sqliteV17SQL     = `ALTER TABLE "{{users}}" ADD COLUMN "fs_provider" integer NOT NULL DEFAULT 0;
+ALTER TABLE "{{users}}" ADD COLUMN "user_groups" text NULL;
 `
 )
 
@@ -97,12 +147,20 @@ func (p *SQLiteProvider) updateQuota(username string, filesAdd int, sizeAdd int6
 	return sqlCommonUpdateQuota(username, filesAdd, sizeAdd, reset, p.dbHandle)
 }
 
+func (p *SQLiteProvider) updateTransferQuota(username string, uploadSize, downloadSize, periodStart int64) error {
+	return sqlCommonUpdateTransferQuota(username, uploadSize, downloadSize, periodStart, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getUsedTransferQuota(username string) (int64, int64, int64, error) {
+	return sqlCommonGetUsedTransferQuota(username, p.dbHandle)
+}
+
 func (p *SQLiteProvider) getUsedQuota(username string) (int, int64, error) {
 	return sqlCommonGetUsedQuota(username, p.dbHandle)
 }
 
-func (p *SQLiteProvider) updateLastLogin(username string) error {
-	return sqlCommonUpdateLastLogin(username, p.dbHandle)
+func (p *SQLiteProvider) updateLastLogin(username, protocol string) error {
+	return sqlCommonUpdateLastLogin(username, protocol, p.dbHandle)
 }
 
 func (p *SQLiteProvider) userExists(username string) (User, error) {
@@ -129,6 +187,10 @@ func (p *SQLiteProvider) getUsers(limit int, offset int, order string) ([]User,
 	return sqlCommonGetUsers(limit, offset, order, p.dbHandle)
 }
 
+func (p *SQLiteProvider) searchUsers(filters UserSearchFilters, limit, offset int, order string) ([]User, error) {
+	return sqlCommonSearchUsers(filters, limit, offset, order, p.dbHandle)
+}
+
 func (p *SQLiteProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
 	return sqlCommonDumpFolders(p.dbHandle)
 }
@@ -187,10 +249,110 @@ func (p *SQLiteProvider) dumpAdmins() ([]Admin, error) {
 	return sqlCommonDumpAdmins(p.dbHandle)
 }
 
+func (p *SQLiteProvider) updateSecrets(users []User, admins []Admin) error {
+	return sqlCommonUpdateSecrets(users, admins, p.dbHandle)
+}
+
 func (p *SQLiteProvider) validateAdminAndPass(username, password, ip string) (Admin, error) {
 	return sqlCommonValidateAdminAndPass(username, password, ip, p.dbHandle)
 }
 
+func (p *SQLiteProvider) apiKeyExists(keyID string) (APIKey, error) {
+	return sqlCommonGetAPIKeyByID(keyID, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addAPIKey(apiKey *APIKey) error {
+	return sqlCommonAddAPIKey(apiKey, p.dbHandle)
+}
+
+func (p *SQLiteProvider) updateAPIKey(apiKey *APIKey) error {
+	return sqlCommonUpdateAPIKey(apiKey, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteAPIKey(apiKey *APIKey) error {
+	return sqlCommonDeleteAPIKey(apiKey, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getAPIKeys(limit int, offset int, order string) ([]APIKey, error) {
+	return sqlCommonGetAPIKeys(limit, offset, order, p.dbHandle)
+}
+
+func (p *SQLiteProvider) dumpAPIKeys() ([]APIKey, error) {
+	return sqlCommonDumpAPIKeys(p.dbHandle)
+}
+
+func (p *SQLiteProvider) updateAPIKeyLastUse(keyID string) error {
+	return sqlCommonUpdateAPIKeyLastUse(keyID, p.dbHandle)
+}
+
+func (p *SQLiteProvider) shareExists(shareID string) (Share, error) {
+	return sqlCommonGetShareByID(shareID, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addShare(share *Share) error {
+	return sqlCommonAddShare(share, p.dbHandle)
+}
+
+func (p *SQLiteProvider) updateShare(share *Share) error {
+	return sqlCommonUpdateShare(share, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteShare(share *Share) error {
+	return sqlCommonDeleteShare(share, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
+	return sqlCommonGetShares(limit, offset, order, username, p.dbHandle)
+}
+
+func (p *SQLiteProvider) dumpShares() ([]Share, error) {
+	return sqlCommonDumpShares(p.dbHandle)
+}
+
+func (p *SQLiteProvider) updateShareLastUse(shareID string, numTokens int) error {
+	return sqlCommonUpdateShareLastUse(shareID, numTokens, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addQueuedEvent(event *QueuedEvent) error {
+	return sqlCommonAddQueuedEvent(event, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getQueuedEvents(limit int, before int64) ([]QueuedEvent, error) {
+	return sqlCommonGetQueuedEvents(limit, before, p.dbHandle)
+}
+
+func (p *SQLiteProvider) updateQueuedEvent(event *QueuedEvent) error {
+	return sqlCommonUpdateQueuedEvent(event, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteQueuedEvent(id int64) error {
+	return sqlCommonDeleteQueuedEvent(id, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addConnectionRecord(record *ConnectionRecord) error {
+	return sqlCommonAddConnectionRecord(record, p.dbHandle)
+}
+
+func (p *SQLiteProvider) searchConnectionRecords(filters ConnectionHistoryFilters, limit, offset int, order string) ([]ConnectionRecord, error) {
+	return sqlCommonSearchConnectionRecords(filters, limit, offset, order, p.dbHandle)
+}
+
+func (p *SQLiteProvider) cleanupConnectionRecords(before int64) error {
+	return sqlCommonCleanupConnectionRecords(before, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addAuditEvent(event *AuditEvent) error {
+	return sqlCommonAddAuditEvent(event, p.dbHandle)
+}
+
+func (p *SQLiteProvider) searchAuditEvents(filters AuditEventsFilters, limit, offset int, order string) ([]AuditEvent, error) {
+	return sqlCommonSearchAuditEvents(filters, limit, offset, order, p.dbHandle)
+}
+
+func (p *SQLiteProvider) cleanupAuditEvents(before int64) error {
+	return sqlCommonCleanupAuditEvents(before, p.dbHandle)
+}
+
 func (p *SQLiteProvider) close() error {
 	return p.dbHandle.Close()
 }
@@ -229,6 +391,24 @@ func (p *SQLiteProvider) migrateDatabase() error {
 		providerLog(logger.LevelError, "%v", err)
 		logger.ErrorToConsole("%v", err)
 		return err
+	case version == 8:
+		return updateSQLiteDatabaseFromV8(p.dbHandle)
+	case version == 9:
+		return updateSQLiteDatabaseFromV9(p.dbHandle)
+	case version == 10:
+		return updateSQLiteDatabaseFromV10(p.dbHandle)
+	case version == 11:
+		return updateSQLiteDatabaseFromV11(p.dbHandle)
+	case version == 12:
+		return updateSQLiteDatabaseFromV12(p.dbHandle)
+	case version == 13:
+		return updateSQLiteDatabaseFromV13(p.dbHandle)
+	case version == 14:
+		return updateSQLiteDatabaseFromV14(p.dbHandle)
+	case version == 15:
+		return updateSQLiteDatabaseFromV15(p.dbHandle)
+	case version == 16:
+		return updateSQLiteDatabaseFromV16(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelWarn, "database version %v is newer than the supported one: %v", version,
@@ -241,6 +421,10 @@ func (p *SQLiteProvider) migrateDatabase() error {
 	}
 }
 
+func (p *SQLiteProvider) checkDatabaseVersion() error {
+	return sqlCommonCheckDatabaseVersion(p.dbHandle)
+}
+
 func (p *SQLiteProvider) revertDatabase(targetVersion int) error {
 	dbVersion, err := sqlCommonGetDatabaseVersion(p.dbHandle, true)
 	if err != nil {
@@ -249,5 +433,325 @@ func (p *SQLiteProvider) revertDatabase(targetVersion int) error {
 	if dbVersion.Version == targetVersion {
 		return errors.New("current version match target version, nothing to do")
 	}
-	return errors.New("the current version cannot be reverted")
+
+	switch dbVersion.Version {
+	case 9:
+		return downgradeSQLiteDatabaseFromV9(p.dbHandle)
+	case 10:
+		return downgradeSQLiteDatabaseFromV10(p.dbHandle)
+	case 11:
+		return downgradeSQLiteDatabaseFromV11(p.dbHandle)
+	case 12:
+		return downgradeSQLiteDatabaseFromV12(p.dbHandle)
+	case 13:
+		return downgradeSQLiteDatabaseFromV13(p.dbHandle)
+	case 14:
+		return downgradeSQLiteDatabaseFromV14(p.dbHandle)
+	case 15:
+		return downgradeSQLiteDatabaseFromV15(p.dbHandle)
+	case 16:
+		return downgradeSQLiteDatabaseFromV16(p.dbHandle)
+	case 17:
+		return downgradeSQLiteDatabaseFromV17(p.dbHandle)
+	default:
+		return fmt.Errorf("database version not handled: %v", dbVersion.Version)
+	}
+}
+
+func (p *SQLiteProvider) resetDatabase() error {
+	return sqlCommonResetDatabase(p.dbHandle, `DROP TABLE IF EXISTS "%v";`)
+}
+
+func updateSQLiteDatabaseFromV8(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom8To9(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV9(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV9(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom9To10(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV10(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV10(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom10To11(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV11(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV11(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom11To12(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV12(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV12(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom12To13(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV13(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV13(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom13To14(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV14(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV14(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom14To15(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV15(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV15(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom15To16(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV16(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV16(dbHandle *sql.DB) error {
+	return updateSQLiteDatabaseFrom16To17(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV9(dbHandle *sql.DB) error {
+	return downgradeSQLiteDatabaseFrom9To8(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV10(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom10To9(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV9(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV11(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom11To10(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV10(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV12(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom12To11(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV11(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV13(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom13To12(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV12(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV14(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom14To13(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV13(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV15(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom15To14(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV14(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV16(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom16To15(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV15(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV17(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom17To16(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV16(dbHandle)
+}
+
+func updateSQLiteDatabaseFrom8To9(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 8 -> 9")
+	providerLog(logger.LevelInfo, "updating database version: 8 -> 9")
+	sql := strings.ReplaceAll(sqliteV9SQL, "{{api_keys}}", sqlTableAPIKeys)
+	sql = strings.ReplaceAll(sql, "{{admins}}", sqlTableAdmins)
+	sql = strings.ReplaceAll(sql, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 9)
+}
+
+func downgradeSQLiteDatabaseFrom9To8(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 9 -> 8")
+	providerLog(logger.LevelInfo, "downgrading database version: 9 -> 8")
+	sql := strings.ReplaceAll(sqliteV9DownSQL, "{{api_keys}}", sqlTableAPIKeys)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 8)
+}
+
+func updateSQLiteDatabaseFrom9To10(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 9 -> 10")
+	providerLog(logger.LevelInfo, "updating database version: 9 -> 10")
+	exists, err := sqliteColumnExists(dbHandle, sqlTableUsers, "last_login_protocol")
+	if err != nil {
+		return err
+	}
+	var sqls []string
+	// the column could be already there if the database was previously downgraded
+	if !exists {
+		sqls = append(sqls, strings.ReplaceAll(sqliteV10SQL, "{{users}}", sqlTableUsers))
+	}
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, sqls, 10)
+}
+
+func downgradeSQLiteDatabaseFrom10To9(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 10 -> 9")
+	providerLog(logger.LevelInfo, "downgrading database version: 10 -> 9")
+	// the bundled SQLite version does not support DROP COLUMN, the last_login_protocol
+	// column is nullable and it will be simply ignored by previous versions
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, nil, 9)
+}
+
+func updateSQLiteDatabaseFrom10To11(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 10 -> 11")
+	providerLog(logger.LevelInfo, "updating database version: 10 -> 11")
+	exists, err := sqliteColumnExists(dbHandle, sqlTableUsers, "used_upload_data_transfer")
+	if err != nil {
+		return err
+	}
+	var sqls []string
+	// the columns could be already there if the database was previously downgraded
+	if !exists {
+		sqls = append(sqls, strings.ReplaceAll(sqliteV11SQL, "{{users}}", sqlTableUsers))
+	}
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, sqls, 11)
+}
+
+func downgradeSQLiteDatabaseFrom11To10(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 11 -> 10")
+	providerLog(logger.LevelInfo, "downgrading database version: 11 -> 10")
+	// the data transfer columns have a default value, we leave them in place as for
+	// the 10 -> 9 downgrade
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, nil, 10)
+}
+
+func sqliteColumnExists(dbHandle *sql.DB, table, column string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	var count int
+	err := dbHandle.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	return count > 0, err
+}
+
+func updateSQLiteDatabaseFrom11To12(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 11 -> 12")
+	providerLog(logger.LevelInfo, "updating database version: 11 -> 12")
+	sql := strings.ReplaceAll(sqliteV12SQL, "{{events_queue}}", sqlTableEventsQueue)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 12)
+}
+
+func downgradeSQLiteDatabaseFrom12To11(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 12 -> 11")
+	providerLog(logger.LevelInfo, "downgrading database version: 12 -> 11")
+	sql := strings.ReplaceAll(sqliteV12DownSQL, "{{events_queue}}", sqlTableEventsQueue)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 11)
+}
+
+func updateSQLiteDatabaseFrom12To13(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 12 -> 13")
+	providerLog(logger.LevelInfo, "updating database version: 12 -> 13")
+	sql := strings.ReplaceAll(sqliteV13SQL, "{{connection_history}}", sqlTableConnHistory)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 13)
+}
+
+func downgradeSQLiteDatabaseFrom13To12(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 13 -> 12")
+	providerLog(logger.LevelInfo, "downgrading database version: 13 -> 12")
+	sql := strings.ReplaceAll(sqliteV13DownSQL, "{{connection_history}}", sqlTableConnHistory)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 12)
+}
+
+func updateSQLiteDatabaseFrom13To14(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 13 -> 14")
+	providerLog(logger.LevelInfo, "updating database version: 13 -> 14")
+	sql := strings.ReplaceAll(sqliteV14SQL, "{{shares}}", sqlTableShares)
+	sql = strings.ReplaceAll(sql, "{{users}}", sqlTableUsers)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 14)
+}
+
+func downgradeSQLiteDatabaseFrom14To13(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 14 -> 13")
+	providerLog(logger.LevelInfo, "downgrading database version: 14 -> 13")
+	sql := strings.ReplaceAll(sqliteV14DownSQL, "{{shares}}", sqlTableShares)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 13)
+}
+
+func updateSQLiteDatabaseFrom14To15(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 14 -> 15")
+	providerLog(logger.LevelInfo, "updating database version: 14 -> 15")
+	sql := strings.ReplaceAll(sqliteV15SQL, "{{audit_events}}", sqlTableAuditEvents)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 15)
+}
+
+func downgradeSQLiteDatabaseFrom15To14(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 15 -> 14")
+	providerLog(logger.LevelInfo, "downgrading database version: 15 -> 14")
+	sql := strings.ReplaceAll(sqliteV15DownSQL, "{{audit_events}}", sqlTableAuditEvents)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 14)
+}
+
+func updateSQLiteDatabaseFrom15To16(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 15 -> 16")
+	providerLog(logger.LevelInfo, "updating database version: 15 -> 16")
+	exists, err := sqliteColumnExists(dbHandle, sqlTableAPIKeys, "restrictions")
+	if err != nil {
+		return err
+	}
+	var sqls []string
+	// the column could be already there if the database was previously downgraded
+	if !exists {
+		sqls = append(sqls, strings.ReplaceAll(sqliteV16SQL, "{{api_keys}}", sqlTableAPIKeys))
+	}
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, sqls, 16)
+}
+
+func downgradeSQLiteDatabaseFrom16To15(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 16 -> 15")
+	providerLog(logger.LevelInfo, "downgrading database version: 16 -> 15")
+	// the restrictions column is nullable and it will be simply ignored by previous versions
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, nil, 15)
+}
+
+func updateSQLiteDatabaseFrom16To17(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 16 -> 17")
+	providerLog(logger.LevelInfo, "updating database version: 16 -> 17")
+	exists, err := sqliteColumnExists(dbHandle, sqlTableUsers, "fs_provider")
+	if err != nil {
+		return err
+	}
+	var sqls []string
+	// the columns could be already there if the database was previously downgraded,
+	// their values are refreshed anyway since they are not updated by previous versions
+	if !exists {
+		sqls = append(sqls, strings.ReplaceAll(sqliteV17SQL, "{{users}}", sqlTableUsers))
+	}
+	return sqlCommonUpdateDatabaseFrom16To17(dbHandle, sqls)
+}
+
+func downgradeSQLiteDatabaseFrom17To16(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 17 -> 16")
+	providerLog(logger.LevelInfo, "downgrading database version: 17 -> 16")
+	// the fs_provider column has a default value and the user_groups one is nullable,
+	// they will be simply ignored by previous versions
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, nil, 16)
 }
@@ -10,9 +10,17 @@ import (
 
 const (
 	selectUserFields = "id,username,password,public_keys,home_dir,uid,gid,max_sessions,quota_size,quota_files,permissions,used_quota_size," +
-		"used_quota_files,last_quota_update,upload_bandwidth,download_bandwidth,expiration_date,last_login,status,filters,filesystem,additional_info"
+		"used_quota_files,last_quota_update,upload_bandwidth,download_bandwidth,expiration_date,last_login,status,filters,filesystem,additional_info,last_login_protocol," +
+		"used_upload_data_transfer,used_download_data_transfer,data_transfer_period_start"
 	selectFolderFields = "id,path,used_quota_size,used_quota_files,last_quota_update,name"
 	selectAdminFields  = "id,username,password,status,email,permissions,filters,additional_info"
+	selectAPIKeyFields = "k.id,k.key_id,k.name,k.api_key,k.scope,k.created_at,k.updated_at,k.last_use_at,k.expires_at," +
+		"k.description,u.username,a.username,k.restrictions"
+	selectQueuedEventFields = "id,payload,attempts,created_at,next_attempt_at"
+	selectConnRecordFields  = "id,connection_id,username,ip,protocol,start_time,end_time,bytes_uploaded,bytes_downloaded"
+	selectAuditEventFields  = "id,event_time,action,username,ip,protocol,object_type,object_name,details"
+	selectShareFields       = "s.id,s.share_id,s.name,s.description,s.scope,s.paths,u.username,s.created_at,s.updated_at," +
+		"s.last_use_at,s.expires_at,s.password,s.max_tokens,s.used_tokens,s.allow_from"
 )
 
 func getSQLPlaceholders() []string {
@@ -52,10 +60,133 @@ func getUpdateAdminQuery() string {
 		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6])
 }
 
+func getUpdateAdminSecretsQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET filters=%v WHERE username = %v`, sqlTableAdmins, sqlPlaceholders[0],
+		sqlPlaceholders[1])
+}
+
 func getDeleteAdminQuery() string {
 	return fmt.Sprintf(`DELETE FROM %v WHERE username = %v`, sqlTableAdmins, sqlPlaceholders[0])
 }
 
+func getAPIKeysJoinClause() string {
+	return fmt.Sprintf(`%v k LEFT JOIN %v u ON k.user_id = u.id LEFT JOIN %v a ON k.admin_id = a.id`,
+		sqlTableAPIKeys, sqlTableUsers, sqlTableAdmins)
+}
+
+func getAPIKeyByIDQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v WHERE k.key_id = %v`, selectAPIKeyFields, getAPIKeysJoinClause(),
+		sqlPlaceholders[0])
+}
+
+func getAPIKeysQuery(order string) string {
+	return fmt.Sprintf(`SELECT %v FROM %v ORDER BY k.key_id %v LIMIT %v OFFSET %v`, selectAPIKeyFields,
+		getAPIKeysJoinClause(), order, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getDumpAPIKeysQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v`, selectAPIKeyFields, getAPIKeysJoinClause())
+}
+
+func getAddAPIKeyQuery() string {
+	return fmt.Sprintf(`INSERT INTO %v (key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,
+		user_id,admin_id,restrictions) VALUES (%v,%v,%v,%v,%v,%v,%v,%v,%v,(SELECT id FROM %v WHERE username = %v),
+		(SELECT id FROM %v WHERE username = %v),%v)`, sqlTableAPIKeys, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6],
+		sqlPlaceholders[7], sqlPlaceholders[8], sqlTableUsers, sqlPlaceholders[9], sqlTableAdmins, sqlPlaceholders[10],
+		sqlPlaceholders[11])
+}
+
+func getUpdateAPIKeyQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET name=%v,scope=%v,expires_at=%v,description=%v,updated_at=%v,
+		user_id=(SELECT id FROM %v WHERE username = %v),admin_id=(SELECT id FROM %v WHERE username = %v),
+		restrictions=%v WHERE key_id = %v`, sqlTableAPIKeys, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
+		sqlPlaceholders[3], sqlPlaceholders[4], sqlTableUsers, sqlPlaceholders[5], sqlTableAdmins, sqlPlaceholders[6],
+		sqlPlaceholders[7], sqlPlaceholders[8])
+}
+
+func getDeleteAPIKeyQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE key_id = %v`, sqlTableAPIKeys, sqlPlaceholders[0])
+}
+
+func getUpdateAPIKeyLastUseQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET last_use_at = %v WHERE key_id = %v`, sqlTableAPIKeys, sqlPlaceholders[0],
+		sqlPlaceholders[1])
+}
+
+func getSharesJoinClause() string {
+	return fmt.Sprintf(`%v s INNER JOIN %v u ON s.user_id = u.id`, sqlTableShares, sqlTableUsers)
+}
+
+func getShareByIDQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v WHERE s.share_id = %v`, selectShareFields, getSharesJoinClause(),
+		sqlPlaceholders[0])
+}
+
+func getSharesQuery(order, username string) string {
+	if username != "" {
+		return fmt.Sprintf(`SELECT %v FROM %v WHERE u.username = %v ORDER BY s.share_id %v LIMIT %v OFFSET %v`,
+			selectShareFields, getSharesJoinClause(), sqlPlaceholders[0], order, sqlPlaceholders[1], sqlPlaceholders[2])
+	}
+	return fmt.Sprintf(`SELECT %v FROM %v ORDER BY s.share_id %v LIMIT %v OFFSET %v`, selectShareFields,
+		getSharesJoinClause(), order, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getDumpSharesQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v`, selectShareFields, getSharesJoinClause())
+}
+
+func getAddShareQuery() string {
+	return fmt.Sprintf(`INSERT INTO %v (share_id,name,description,scope,paths,created_at,updated_at,last_use_at,
+		expires_at,password,max_tokens,used_tokens,allow_from,user_id) VALUES (%v,%v,%v,%v,%v,%v,%v,%v,%v,%v,%v,%v,%v,
+		(SELECT id FROM %v WHERE username = %v))`, sqlTableShares, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6],
+		sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11],
+		sqlPlaceholders[12], sqlTableUsers, sqlPlaceholders[13])
+}
+
+func getUpdateShareQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET name=%v,description=%v,scope=%v,paths=%v,updated_at=%v,expires_at=%v,
+		password=%v,max_tokens=%v,allow_from=%v,user_id=(SELECT id FROM %v WHERE username = %v) WHERE share_id = %v`,
+		sqlTableShares, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8],
+		sqlTableUsers, sqlPlaceholders[9], sqlPlaceholders[10])
+}
+
+func getDeleteShareQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE share_id = %v`, sqlTableShares, sqlPlaceholders[0])
+}
+
+func getUpdateShareLastUseQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET last_use_at = %v, used_tokens = used_tokens + %v WHERE share_id = %v AND
+		(max_tokens = 0 OR used_tokens + %v <= max_tokens)`, sqlTableShares, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
+func getAddQueuedEventQuery() string {
+	q := fmt.Sprintf(`INSERT INTO %v (payload,attempts,created_at,next_attempt_at) VALUES (%v,%v,%v,%v)`,
+		sqlTableEventsQueue, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+	if config.Driver == PGSQLDataProviderName {
+		// PostgreSQL does not support LastInsertId
+		q += " RETURNING id"
+	}
+	return q
+}
+
+func getQueuedEventsQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v WHERE next_attempt_at <= %v ORDER BY id ASC LIMIT %v`,
+		selectQueuedEventFields, sqlTableEventsQueue, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getUpdateQueuedEventQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET attempts = %v,next_attempt_at = %v WHERE id = %v`, sqlTableEventsQueue,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
+func getDeleteQueuedEventQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE id = %v`, sqlTableEventsQueue, sqlPlaceholders[0])
+}
+
 func getUserByUsernameQuery() string {
 	return fmt.Sprintf(`SELECT %v FROM %v WHERE username = %v`, selectUserFields, sqlTableUsers, sqlPlaceholders[0])
 }
@@ -65,6 +196,67 @@ func getUsersQuery(order string) string {
 		order, sqlPlaceholders[0], sqlPlaceholders[1])
 }
 
+// getSearchUsersQuery returns the search query and its arguments,
+// only the non empty filters are added to the where clause
+func getSearchUsersQuery(filters *UserSearchFilters, limit, offset int, order string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	getPlaceholder := func(arg interface{}) string {
+		args = append(args, arg)
+		if config.Driver == PGSQLDataProviderName {
+			return fmt.Sprintf("$%v", len(args))
+		}
+		return "?"
+	}
+	getGroupCondition := func(group string) string {
+		return fmt.Sprintf("user_groups LIKE %v ESCAPE '!'", getPlaceholder("%,"+escapeSQLLikePattern(group)+",%"))
+	}
+	if len(filters.Usernames) > 0 {
+		placeholders := make([]string, 0, len(filters.Usernames))
+		for _, username := range filters.Usernames {
+			placeholders = append(placeholders, getPlaceholder(username))
+		}
+		conditions = append(conditions, fmt.Sprintf("username IN (%v)", strings.Join(placeholders, ",")))
+	}
+	if len(filters.Groups) > 0 {
+		groupConditions := make([]string, 0, len(filters.Groups))
+		for _, group := range filters.Groups {
+			groupConditions = append(groupConditions, getGroupCondition(group))
+		}
+		conditions = append(conditions, fmt.Sprintf("(%v)", strings.Join(groupConditions, " OR ")))
+	}
+	if filters.Group != "" {
+		conditions = append(conditions, getGroupCondition(filters.Group))
+	}
+	if filters.Status >= 0 {
+		conditions = append(conditions, fmt.Sprintf("status = %v", getPlaceholder(filters.Status)))
+	}
+	if filters.FsProvider >= 0 {
+		conditions = append(conditions, fmt.Sprintf("fs_provider = %v", getPlaceholder(filters.FsProvider)))
+	}
+	if filters.InactiveDays > 0 {
+		conditions = append(conditions, fmt.Sprintf("last_login > 0 AND last_login < %v",
+			getPlaceholder(filters.getInactiveBefore())))
+	}
+	if filters.Search != "" {
+		conditions = append(conditions, fmt.Sprintf("LOWER(username) LIKE %v ESCAPE '!'",
+			getPlaceholder("%"+escapeSQLLikePattern(filters.Search)+"%")))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	orderBy := fmt.Sprintf("username %v", order)
+	if !filters.isSortedByUsername() {
+		orderBy = fmt.Sprintf("%v %v,%v", filters.SortField, order, orderBy)
+	}
+	limitPlaceholder := getPlaceholder(limit)
+	offsetPlaceholder := getPlaceholder(offset)
+	q := fmt.Sprintf(`SELECT %v FROM %v%v ORDER BY %v LIMIT %v OFFSET %v`, selectUserFields, sqlTableUsers, where,
+		orderBy, limitPlaceholder, offsetPlaceholder)
+	return q, args
+}
+
 func getDumpUsersQuery() string {
 	return fmt.Sprintf(`SELECT %v FROM %v`, selectUserFields, sqlTableUsers)
 }
@@ -83,7 +275,21 @@ func getUpdateQuotaQuery(reset bool) string {
 }
 
 func getUpdateLastLoginQuery() string {
-	return fmt.Sprintf(`UPDATE %v SET last_login = %v WHERE username = %v`, sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1])
+	return fmt.Sprintf(`UPDATE %v SET last_login = %v,last_login_protocol = %v WHERE username = %v`, sqlTableUsers,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
+func getUpdateTransferQuotaQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET used_upload_data_transfer = CASE WHEN data_transfer_period_start = %v
+		THEN used_upload_data_transfer + %v ELSE %v END,used_download_data_transfer = CASE WHEN data_transfer_period_start = %v
+		THEN used_download_data_transfer + %v ELSE %v END,data_transfer_period_start = %v WHERE username = %v`, sqlTableUsers,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5],
+		sqlPlaceholders[6], sqlPlaceholders[7])
+}
+
+func getTransferQuotaQuery() string {
+	return fmt.Sprintf(`SELECT used_upload_data_transfer,used_download_data_transfer,data_transfer_period_start FROM %v
+		WHERE username = %v`, sqlTableUsers, sqlPlaceholders[0])
 }
 
 func getQuotaQuery() string {
@@ -94,20 +300,32 @@ func getQuotaQuery() string {
 func getAddUserQuery() string {
 	return fmt.Sprintf(`INSERT INTO %v (username,password,public_keys,home_dir,uid,gid,max_sessions,quota_size,quota_files,permissions,
 		used_quota_size,used_quota_files,last_quota_update,upload_bandwidth,download_bandwidth,status,last_login,expiration_date,filters,
-		filesystem,additional_info)
-		VALUES (%v,%v,%v,%v,%v,%v,%v,%v,%v,%v,0,0,0,%v,%v,%v,0,%v,%v,%v,%v)`, sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1],
+		filesystem,additional_info,fs_provider,user_groups)
+		VALUES (%v,%v,%v,%v,%v,%v,%v,%v,%v,%v,0,0,0,%v,%v,%v,0,%v,%v,%v,%v,%v,%v)`, sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1],
 		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7],
 		sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12], sqlPlaceholders[13],
-		sqlPlaceholders[14], sqlPlaceholders[15], sqlPlaceholders[16])
+		sqlPlaceholders[14], sqlPlaceholders[15], sqlPlaceholders[16], sqlPlaceholders[17], sqlPlaceholders[18])
+}
+
+// getUpdateUserSearchFieldsQuery returns the query to update the columns, derived from the
+// user filters and filesystem, used to search the users
+func getUpdateUserSearchFieldsQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET fs_provider=%v,user_groups=%v WHERE id = %v`, sqlTableUsers, sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
+func getUpdateUserSecretsQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET filters=%v,filesystem=%v WHERE id = %v`, sqlTableUsers, sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2])
 }
 
 func getUpdateUserQuery() string {
 	return fmt.Sprintf(`UPDATE %v SET password=%v,public_keys=%v,home_dir=%v,uid=%v,gid=%v,max_sessions=%v,quota_size=%v,
 		quota_files=%v,permissions=%v,upload_bandwidth=%v,download_bandwidth=%v,status=%v,expiration_date=%v,filters=%v,filesystem=%v,
-		additional_info=%v WHERE id = %v`, sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
-		sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9],
-		sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12], sqlPlaceholders[13], sqlPlaceholders[14], sqlPlaceholders[15],
-		sqlPlaceholders[16])
+		additional_info=%v,fs_provider=%v,user_groups=%v WHERE id = %v`, sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7],
+		sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12], sqlPlaceholders[13],
+		sqlPlaceholders[14], sqlPlaceholders[15], sqlPlaceholders[16], sqlPlaceholders[17], sqlPlaceholders[18])
 }
 
 func getDeleteUserQuery() string {
@@ -203,3 +421,101 @@ func getDatabaseVersionQuery() string {
 func getUpdateDBVersionQuery() string {
 	return fmt.Sprintf(`UPDATE %v SET version=%v`, sqlTableSchemaVersion, sqlPlaceholders[0])
 }
+
+func getAddConnectionRecordQuery() string {
+	q := fmt.Sprintf(`INSERT INTO %v (connection_id,username,ip,protocol,start_time,end_time,bytes_uploaded,bytes_downloaded)
+		VALUES (%v,%v,%v,%v,%v,%v,%v,%v)`, sqlTableConnHistory, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
+		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7])
+	if config.Driver == PGSQLDataProviderName {
+		// PostgreSQL does not support LastInsertId
+		q += " RETURNING id"
+	}
+	return q
+}
+
+// getSearchConnectionRecordsQuery returns the search query and its arguments,
+// only the non empty filters are added to the where clause
+func getSearchConnectionRecordsQuery(filters *ConnectionHistoryFilters, limit, offset int, order string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		conditions = append(conditions, fmt.Sprintf(condition, sqlPlaceholders[len(args)]))
+		args = append(args, arg)
+	}
+	if filters.Username != "" {
+		addCondition("username = %v", filters.Username)
+	}
+	if filters.IP != "" {
+		addCondition("ip = %v", filters.IP)
+	}
+	if filters.Protocol != "" {
+		addCondition("protocol = %v", filters.Protocol)
+	}
+	if filters.StartTimeFrom > 0 {
+		addCondition("start_time >= %v", filters.StartTimeFrom)
+	}
+	if filters.StartTimeTo > 0 {
+		addCondition("start_time <= %v", filters.StartTimeTo)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	q := fmt.Sprintf(`SELECT %v FROM %v%v ORDER BY id %v LIMIT %v OFFSET %v`, selectConnRecordFields,
+		sqlTableConnHistory, where, order, sqlPlaceholders[len(args)], sqlPlaceholders[len(args)+1])
+	args = append(args, limit, offset)
+	return q, args
+}
+
+func getCleanupConnectionRecordsQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE end_time < %v`, sqlTableConnHistory, sqlPlaceholders[0])
+}
+
+func getAddAuditEventQuery() string {
+	q := fmt.Sprintf(`INSERT INTO %v (event_time,action,username,ip,protocol,object_type,object_name,details)
+		VALUES (%v,%v,%v,%v,%v,%v,%v,%v)`, sqlTableAuditEvents, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
+		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7])
+	if config.Driver == PGSQLDataProviderName {
+		// PostgreSQL does not support LastInsertId
+		q += " RETURNING id"
+	}
+	return q
+}
+
+// getSearchAuditEventsQuery returns the search query and its arguments,
+// only the non empty filters are added to the where clause
+func getSearchAuditEventsQuery(filters *AuditEventsFilters, limit, offset int, order string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		conditions = append(conditions, fmt.Sprintf(condition, sqlPlaceholders[len(args)]))
+		args = append(args, arg)
+	}
+	if filters.Username != "" {
+		addCondition("username = %v", filters.Username)
+	}
+	if filters.Action != "" {
+		addCondition("action = %v", filters.Action)
+	}
+	if filters.ObjectType != "" {
+		addCondition("object_type = %v", filters.ObjectType)
+	}
+	if filters.TimestampFrom > 0 {
+		addCondition("event_time >= %v", filters.TimestampFrom)
+	}
+	if filters.TimestampTo > 0 {
+		addCondition("event_time <= %v", filters.TimestampTo)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	q := fmt.Sprintf(`SELECT %v FROM %v%v ORDER BY id %v LIMIT %v OFFSET %v`, selectAuditEventFields,
+		sqlTableAuditEvents, where, order, sqlPlaceholders[len(args)], sqlPlaceholders[len(args)+1])
+	args = append(args, limit, offset)
+	return q, args
+}
+
+func getCleanupAuditEventsQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE event_time < %v`, sqlTableAuditEvents, sqlPlaceholders[0])
+}
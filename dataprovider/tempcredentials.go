@@ -0,0 +1,238 @@
+package dataprovider
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const tempCredentialsUsernameSeparator = "_tmp_"
+
+var (
+	tempCredentialsTicker     *time.Ticker
+	tempCredentialsTickerDone chan bool
+	// serializes the login counters updates, so a credential cannot be used
+	// more than the allowed times by concurrent logins
+	tempCredentialsMu sync.Mutex
+)
+
+// TempCredentials defines the restrictions for temporary credentials.
+// Temporary credentials are users that inherit the filesystem and the
+// restrictions of an existing user, they expire after a configurable
+// time to live and they can be used for a limited number of logins
+type TempCredentials struct {
+	// the user that minted the temporary credentials
+	ParentUsername string `json:"parent_username,omitempty"`
+	// maximum number of logins allowed, 0 means unlimited
+	MaxUses int `json:"max_uses,omitempty"`
+	// number of successful logins
+	Uses int `json:"uses,omitempty"`
+}
+
+// IsTemporary returns true if the user is a temporary credential
+func (c *TempCredentials) IsTemporary() bool {
+	return c.ParentUsername != ""
+}
+
+// IsUsedUp returns true if no more logins are allowed
+func (c *TempCredentials) IsUsedUp() bool {
+	return c.MaxUses > 0 && c.Uses >= c.MaxUses
+}
+
+func (c *TempCredentials) validate() error {
+	if !c.IsTemporary() {
+		c.MaxUses = 0
+		c.Uses = 0
+		return nil
+	}
+	if c.MaxUses < 0 {
+		return &ValidationError{err: fmt.Sprintf("invalid temporary credentials max uses: %v", c.MaxUses)}
+	}
+	if c.Uses < 0 {
+		return &ValidationError{err: fmt.Sprintf("invalid temporary credentials uses: %v", c.Uses)}
+	}
+	return nil
+}
+
+// AddTempCredentials creates a temporary user bound to the filesystem of the given parent user.
+// The temporary user expires after ttl and it can login at most maxUses times, 0 means unlimited.
+// If publicKey is empty a random password is generated, the password is returned in plain text
+// and it is not possible to get it again. The parent user changes are not propagated to the
+// temporary user, except for the quota limits: the disk and data transfer usage is charged
+// to the parent user
+func AddTempCredentials(parentUsername string, ttl time.Duration, maxUses int, publicKey string) (User, string, error) {
+	if ttl <= 0 {
+		return User{}, "", &ValidationError{err: fmt.Sprintf("invalid time to live: %v", ttl)}
+	}
+	if maxUses < 0 {
+		return User{}, "", &ValidationError{err: fmt.Sprintf("invalid max uses: %v", maxUses)}
+	}
+	parent, err := provider.userExists(convertUsername(parentUsername))
+	if err != nil {
+		return User{}, "", err
+	}
+	if parent.Filters.TempCredentials.IsTemporary() {
+		return User{}, "", &ValidationError{err: "temporary credentials cannot be created for a temporary user"}
+	}
+	if err := checkLoginConditions(&parent); err != nil {
+		return User{}, "", &ValidationError{err: err.Error()}
+	}
+	user := parent.getACopy()
+	user.ID = 0
+	user.Username = fmt.Sprintf("%v%v%v", parent.Username, tempCredentialsUsernameSeparator,
+		hex.EncodeToString(utils.GenerateRandomBytes(6)))
+	expirationDate := utils.GetTimeAsMsSinceEpoch(time.Now().Add(ttl))
+	if parent.ExpirationDate == 0 || expirationDate < parent.ExpirationDate {
+		user.ExpirationDate = expirationDate
+	}
+	user.LastLogin = 0
+	user.LastLoginProtocol = ""
+	user.UsedQuotaSize = 0
+	user.UsedQuotaFiles = 0
+	user.LastQuotaUpdate = 0
+	user.UsedUploadDataTransfer = 0
+	user.UsedDownloadDataTransfer = 0
+	user.DataTransferPeriodStart = 0
+	user.Filters.TOTPConfig = TOTPConfig{}
+	user.Filters.RecoveryCodes = nil
+	user.Filters.TempCredentials = TempCredentials{
+		ParentUsername: parent.Username,
+		MaxUses:        maxUses,
+	}
+	user.AdditionalInfo = fmt.Sprintf("temporary credentials for user %#v", parent.Username)
+	var password string
+	if publicKey != "" {
+		user.Password = ""
+		user.PublicKeys = []string{publicKey}
+	} else {
+		password = hex.EncodeToString(utils.GenerateRandomBytes(16))
+		user.Password = password
+		user.PublicKeys = nil
+	}
+	if err := AddUser(&user); err != nil {
+		return user, "", err
+	}
+	providerLog(logger.LevelInfo, "temporary credentials %#v created for user %#v, expiration: %v, max uses: %v",
+		user.Username, parent.Username, utils.GetTimeFromMsecSinceEpoch(user.ExpirationDate).UTC().Format(time.RFC3339),
+		maxUses)
+	user, err = provider.userExists(user.Username)
+	return user, password, err
+}
+
+// AddTempCredentialsUse records a successful login for the given user if it is a temporary
+// credential. An error is returned if the temporary credential cannot be used anymore.
+// This method must be called once for each login
+func AddTempCredentialsUse(user *User) error {
+	if !user.Filters.TempCredentials.IsTemporary() {
+		return nil
+	}
+	tempCredentialsMu.Lock()
+	defer tempCredentialsMu.Unlock()
+
+	u, err := provider.userExists(user.Username)
+	if err != nil {
+		return err
+	}
+	if u.Filters.TempCredentials.IsUsedUp() {
+		return fmt.Errorf("temporary credentials %#v reached the maximum allowed uses: %v", u.Username,
+			u.Filters.TempCredentials.MaxUses)
+	}
+	u.Filters.TempCredentials.Uses++
+	if err := provider.updateUser(&u); err != nil {
+		providerLog(logger.LevelWarn, "unable to update uses for temporary credentials %#v: %v", user.Username, err)
+		return err
+	}
+	user.Filters.TempCredentials.Uses = u.Filters.TempCredentials.Uses
+	RemoveCachedUser(user.Username)
+	return nil
+}
+
+// checkTempCredentials checks if the given temporary credentials can login
+func checkTempCredentials(user *User) error {
+	if user.Filters.TempCredentials.IsUsedUp() {
+		return fmt.Errorf("temporary credentials %#v reached the maximum allowed uses: %v", user.Username,
+			user.Filters.TempCredentials.MaxUses)
+	}
+	parent, err := provider.userExists(user.Filters.TempCredentials.ParentUsername)
+	if err != nil {
+		return fmt.Errorf("unable to get the parent user for temporary credentials %#v: %v", user.Username, err)
+	}
+	if parent.Status < 1 || parent.IsExpired() {
+		return fmt.Errorf("the parent user %#v for temporary credentials %#v is disabled or expired",
+			parent.Username, user.Username)
+	}
+	// the usage is charged to the parent user so its current limits apply
+	user.QuotaSize = parent.QuotaSize
+	user.QuotaFiles = parent.QuotaFiles
+	user.Filters.TransferQuota = parent.Filters.TransferQuota
+	return nil
+}
+
+func startTempCredentialsCleanupTimer() {
+	if config.TempCredentialsCleanupInterval <= 0 {
+		return
+	}
+	tempCredentialsTicker = time.NewTicker(time.Duration(config.TempCredentialsCleanupInterval) * time.Minute)
+	tempCredentialsTickerDone = make(chan bool)
+	providerLog(logger.LevelDebug, "start temporary credentials cleanup, interval: %v minutes",
+		config.TempCredentialsCleanupInterval)
+	go func() {
+		for {
+			select {
+			case <-tempCredentialsTickerDone:
+				return
+			case <-tempCredentialsTicker.C:
+				removeExpiredTempCredentials()
+			}
+		}
+	}()
+}
+
+// removeExpiredTempCredentials deletes the temporary credentials that are expired,
+// have no remaining uses or whose parent user does not exist anymore.
+// The home directory is not removed, it is shared with the parent user
+func removeExpiredTempCredentials() {
+	var toRemove []string
+	offset := 0
+	for {
+		users, err := provider.getUsers(expirationCheckLimit, offset, OrderASC)
+		if err != nil {
+			providerLog(logger.LevelWarn, "unable to get users to check for expired temporary credentials: %v", err)
+			return
+		}
+		for idx := range users {
+			if isTempCredentialToRemove(&users[idx]) {
+				toRemove = append(toRemove, users[idx].Username)
+			}
+		}
+		if len(users) < expirationCheckLimit {
+			break
+		}
+		offset += len(users)
+	}
+
+	for _, username := range toRemove {
+		if err := DeleteUser(username); err != nil {
+			providerLog(logger.LevelWarn, "unable to remove temporary credentials %#v: %v", username, err)
+			continue
+		}
+		providerLog(logger.LevelInfo, "temporary credentials %#v removed", username)
+	}
+}
+
+func isTempCredentialToRemove(user *User) bool {
+	if !user.Filters.TempCredentials.IsTemporary() {
+		return false
+	}
+	if user.IsExpired() || user.Filters.TempCredentials.IsUsedUp() {
+		return true
+	}
+	_, err := provider.userExists(user.Filters.TempCredentials.ParentUsername)
+	var errNotFound *RecordNotFoundError
+	return errors.As(err, &errNotFound)
+}
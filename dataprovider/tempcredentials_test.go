@@ -0,0 +1,69 @@
+package dataprovider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTempCredentialsQuota(t *testing.T) {
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+
+	config.TrackQuota = 1
+	parent := getTestUser("temp_quota_parent")
+	parent.QuotaSize = 1000
+	parent.QuotaFiles = 10
+	parent.Filters.TransferQuota = TransferQuota{
+		Period:     "day",
+		UploadSize: 2000,
+	}
+	err := AddUser(&parent)
+	require.NoError(t, err)
+	tempUser, password, err := AddTempCredentials(parent.Username, time.Hour, 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, parent.Username, tempUser.GetQuotaUsername())
+	assert.Equal(t, parent.Username, parent.GetQuotaUsername())
+	// the usage is charged to the parent user
+	err = UpdateUserQuota(&tempUser, 2, 500, false)
+	assert.NoError(t, err)
+	err = UpdateUserTransferQuota(&tempUser, 300, 0)
+	assert.NoError(t, err)
+	files, size, err := GetUsedQuota(parent.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, files)
+	assert.Equal(t, int64(500), size)
+	files, size, err = GetUsedQuota(tempUser.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, files)
+	assert.Equal(t, int64(0), size)
+	ul, dl, err := GetUsedTransferQuota(&parent)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(300), ul)
+	assert.Equal(t, int64(0), dl)
+	ul, _, err = GetUsedTransferQuota(&tempUser)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(300), ul)
+	// the current limits of the parent user apply
+	parent, err = UserExists(parent.Username)
+	require.NoError(t, err)
+	parent.QuotaSize = 600
+	parent.QuotaFiles = 0
+	parent.Filters.TransferQuota.UploadSize = 400
+	err = UpdateUser(&parent)
+	require.NoError(t, err)
+	user, err := CheckUserAndPass(tempUser.Username, password, "127.0.0.1", "SSH")
+	require.NoError(t, err)
+	assert.Equal(t, int64(600), user.QuotaSize)
+	assert.Equal(t, 0, user.QuotaFiles)
+	assert.Equal(t, int64(400), user.Filters.TransferQuota.UploadSize)
+
+	err = DeleteUser(tempUser.Username)
+	assert.NoError(t, err)
+	err = DeleteUser(parent.Username)
+	assert.NoError(t, err)
+}
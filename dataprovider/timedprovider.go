@@ -0,0 +1,420 @@
+package dataprovider
+
+import (
+	"errors"
+	"time"
+
+	"github.com/drakkan/sftpgo/diagnostics"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+// timedProvider wraps the configured provider and records the execution time
+// and the failures for each data provider operation
+type timedProvider struct {
+	Provider
+}
+
+// isProviderOperationError returns false for the errors caused by the request
+// and not by a data provider failure, for example a missing record
+func isProviderOperationError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var errNotFound *RecordNotFoundError
+	var errValidation *ValidationError
+	var errMethodDisabled *MethodDisabledError
+	return !errors.As(err, &errNotFound) && !errors.As(err, &errValidation) &&
+		!errors.As(err, &errMethodDisabled) && !errors.Is(err, ErrInvalidCredentials)
+}
+
+func providerOperationCompleted(operation string, startTime time.Time, err error) {
+	if !isProviderOperationError(err) {
+		err = nil
+	}
+	diagnostics.ProviderOperationCompleted(operation, time.Since(startTime), err)
+}
+
+func (p *timedProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
+	startTime := time.Now()
+	user, err := p.Provider.validateUserAndPass(username, password, ip, protocol)
+	providerOperationCompleted("validate_user_and_pass", startTime, err)
+	return user, err
+}
+
+func (p *timedProvider) validateUserAndPubKey(username string, pubKey []byte) (User, string, error) {
+	startTime := time.Now()
+	user, keyInfo, err := p.Provider.validateUserAndPubKey(username, pubKey)
+	providerOperationCompleted("validate_user_and_pub_key", startTime, err)
+	return user, keyInfo, err
+}
+
+func (p *timedProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
+	startTime := time.Now()
+	err := p.Provider.updateQuota(username, filesAdd, sizeAdd, reset)
+	providerOperationCompleted("update_quota", startTime, err)
+	return err
+}
+
+func (p *timedProvider) getUsedQuota(username string) (int, int64, error) {
+	startTime := time.Now()
+	files, size, err := p.Provider.getUsedQuota(username)
+	providerOperationCompleted("get_used_quota", startTime, err)
+	return files, size, err
+}
+
+func (p *timedProvider) updateTransferQuota(username string, uploadSize, downloadSize, periodStart int64) error {
+	startTime := time.Now()
+	err := p.Provider.updateTransferQuota(username, uploadSize, downloadSize, periodStart)
+	providerOperationCompleted("update_transfer_quota", startTime, err)
+	return err
+}
+
+func (p *timedProvider) getUsedTransferQuota(username string) (int64, int64, int64, error) {
+	startTime := time.Now()
+	ulSize, dlSize, periodStart, err := p.Provider.getUsedTransferQuota(username)
+	providerOperationCompleted("get_used_transfer_quota", startTime, err)
+	return ulSize, dlSize, periodStart, err
+}
+
+func (p *timedProvider) userExists(username string) (User, error) {
+	startTime := time.Now()
+	user, err := p.Provider.userExists(username)
+	providerOperationCompleted("user_exists", startTime, err)
+	return user, err
+}
+
+func (p *timedProvider) addUser(user *User) error {
+	startTime := time.Now()
+	err := p.Provider.addUser(user)
+	providerOperationCompleted("add_user", startTime, err)
+	return err
+}
+
+func (p *timedProvider) updateUser(user *User) error {
+	startTime := time.Now()
+	err := p.Provider.updateUser(user)
+	providerOperationCompleted("update_user", startTime, err)
+	return err
+}
+
+func (p *timedProvider) deleteUser(user *User) error {
+	startTime := time.Now()
+	err := p.Provider.deleteUser(user)
+	providerOperationCompleted("delete_user", startTime, err)
+	return err
+}
+
+func (p *timedProvider) getUsers(limit int, offset int, order string) ([]User, error) {
+	startTime := time.Now()
+	users, err := p.Provider.getUsers(limit, offset, order)
+	providerOperationCompleted("get_users", startTime, err)
+	return users, err
+}
+
+func (p *timedProvider) searchUsers(filters UserSearchFilters, limit, offset int, order string) ([]User, error) {
+	startTime := time.Now()
+	users, err := p.Provider.searchUsers(filters, limit, offset, order)
+	providerOperationCompleted("search_users", startTime, err)
+	return users, err
+}
+
+func (p *timedProvider) dumpUsers() ([]User, error) {
+	startTime := time.Now()
+	users, err := p.Provider.dumpUsers()
+	providerOperationCompleted("dump_users", startTime, err)
+	return users, err
+}
+
+func (p *timedProvider) updateLastLogin(username, protocol string) error {
+	startTime := time.Now()
+	err := p.Provider.updateLastLogin(username, protocol)
+	providerOperationCompleted("update_last_login", startTime, err)
+	return err
+}
+
+func (p *timedProvider) getFolders(limit, offset int, order string) ([]vfs.BaseVirtualFolder, error) {
+	startTime := time.Now()
+	folders, err := p.Provider.getFolders(limit, offset, order)
+	providerOperationCompleted("get_folders", startTime, err)
+	return folders, err
+}
+
+func (p *timedProvider) getFolderByName(name string) (vfs.BaseVirtualFolder, error) {
+	startTime := time.Now()
+	folder, err := p.Provider.getFolderByName(name)
+	providerOperationCompleted("get_folder_by_name", startTime, err)
+	return folder, err
+}
+
+func (p *timedProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
+	startTime := time.Now()
+	err := p.Provider.addFolder(folder)
+	providerOperationCompleted("add_folder", startTime, err)
+	return err
+}
+
+func (p *timedProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
+	startTime := time.Now()
+	err := p.Provider.updateFolder(folder)
+	providerOperationCompleted("update_folder", startTime, err)
+	return err
+}
+
+func (p *timedProvider) deleteFolder(folder *vfs.BaseVirtualFolder) error {
+	startTime := time.Now()
+	err := p.Provider.deleteFolder(folder)
+	providerOperationCompleted("delete_folder", startTime, err)
+	return err
+}
+
+func (p *timedProvider) updateFolderQuota(name string, filesAdd int, sizeAdd int64, reset bool) error {
+	startTime := time.Now()
+	err := p.Provider.updateFolderQuota(name, filesAdd, sizeAdd, reset)
+	providerOperationCompleted("update_folder_quota", startTime, err)
+	return err
+}
+
+func (p *timedProvider) getUsedFolderQuota(name string) (int, int64, error) {
+	startTime := time.Now()
+	files, size, err := p.Provider.getUsedFolderQuota(name)
+	providerOperationCompleted("get_used_folder_quota", startTime, err)
+	return files, size, err
+}
+
+func (p *timedProvider) dumpFolders() ([]vfs.BaseVirtualFolder, error) {
+	startTime := time.Now()
+	folders, err := p.Provider.dumpFolders()
+	providerOperationCompleted("dump_folders", startTime, err)
+	return folders, err
+}
+
+func (p *timedProvider) adminExists(username string) (Admin, error) {
+	startTime := time.Now()
+	admin, err := p.Provider.adminExists(username)
+	providerOperationCompleted("admin_exists", startTime, err)
+	return admin, err
+}
+
+func (p *timedProvider) addAdmin(admin *Admin) error {
+	startTime := time.Now()
+	err := p.Provider.addAdmin(admin)
+	providerOperationCompleted("add_admin", startTime, err)
+	return err
+}
+
+func (p *timedProvider) updateAdmin(admin *Admin) error {
+	startTime := time.Now()
+	err := p.Provider.updateAdmin(admin)
+	providerOperationCompleted("update_admin", startTime, err)
+	return err
+}
+
+func (p *timedProvider) deleteAdmin(admin *Admin) error {
+	startTime := time.Now()
+	err := p.Provider.deleteAdmin(admin)
+	providerOperationCompleted("delete_admin", startTime, err)
+	return err
+}
+
+func (p *timedProvider) getAdmins(limit int, offset int, order string) ([]Admin, error) {
+	startTime := time.Now()
+	admins, err := p.Provider.getAdmins(limit, offset, order)
+	providerOperationCompleted("get_admins", startTime, err)
+	return admins, err
+}
+
+func (p *timedProvider) dumpAdmins() ([]Admin, error) {
+	startTime := time.Now()
+	admins, err := p.Provider.dumpAdmins()
+	providerOperationCompleted("dump_admins", startTime, err)
+	return admins, err
+}
+
+func (p *timedProvider) updateSecrets(users []User, admins []Admin) error {
+	startTime := time.Now()
+	err := p.Provider.updateSecrets(users, admins)
+	providerOperationCompleted("update_secrets", startTime, err)
+	return err
+}
+
+func (p *timedProvider) validateAdminAndPass(username, password, ip string) (Admin, error) {
+	startTime := time.Now()
+	admin, err := p.Provider.validateAdminAndPass(username, password, ip)
+	providerOperationCompleted("validate_admin_and_pass", startTime, err)
+	return admin, err
+}
+
+func (p *timedProvider) apiKeyExists(keyID string) (APIKey, error) {
+	startTime := time.Now()
+	apiKey, err := p.Provider.apiKeyExists(keyID)
+	providerOperationCompleted("api_key_exists", startTime, err)
+	return apiKey, err
+}
+
+func (p *timedProvider) addAPIKey(apiKey *APIKey) error {
+	startTime := time.Now()
+	err := p.Provider.addAPIKey(apiKey)
+	providerOperationCompleted("add_api_key", startTime, err)
+	return err
+}
+
+func (p *timedProvider) updateAPIKey(apiKey *APIKey) error {
+	startTime := time.Now()
+	err := p.Provider.updateAPIKey(apiKey)
+	providerOperationCompleted("update_api_key", startTime, err)
+	return err
+}
+
+func (p *timedProvider) deleteAPIKey(apiKey *APIKey) error {
+	startTime := time.Now()
+	err := p.Provider.deleteAPIKey(apiKey)
+	providerOperationCompleted("delete_api_key", startTime, err)
+	return err
+}
+
+func (p *timedProvider) getAPIKeys(limit int, offset int, order string) ([]APIKey, error) {
+	startTime := time.Now()
+	apiKeys, err := p.Provider.getAPIKeys(limit, offset, order)
+	providerOperationCompleted("get_api_keys", startTime, err)
+	return apiKeys, err
+}
+
+func (p *timedProvider) dumpAPIKeys() ([]APIKey, error) {
+	startTime := time.Now()
+	apiKeys, err := p.Provider.dumpAPIKeys()
+	providerOperationCompleted("dump_api_keys", startTime, err)
+	return apiKeys, err
+}
+
+func (p *timedProvider) updateAPIKeyLastUse(keyID string) error {
+	startTime := time.Now()
+	err := p.Provider.updateAPIKeyLastUse(keyID)
+	providerOperationCompleted("update_api_key_last_use", startTime, err)
+	return err
+}
+
+func (p *timedProvider) shareExists(shareID string) (Share, error) {
+	startTime := time.Now()
+	share, err := p.Provider.shareExists(shareID)
+	providerOperationCompleted("share_exists", startTime, err)
+	return share, err
+}
+
+func (p *timedProvider) addShare(share *Share) error {
+	startTime := time.Now()
+	err := p.Provider.addShare(share)
+	providerOperationCompleted("add_share", startTime, err)
+	return err
+}
+
+func (p *timedProvider) updateShare(share *Share) error {
+	startTime := time.Now()
+	err := p.Provider.updateShare(share)
+	providerOperationCompleted("update_share", startTime, err)
+	return err
+}
+
+func (p *timedProvider) deleteShare(share *Share) error {
+	startTime := time.Now()
+	err := p.Provider.deleteShare(share)
+	providerOperationCompleted("delete_share", startTime, err)
+	return err
+}
+
+func (p *timedProvider) getShares(limit int, offset int, order, username string) ([]Share, error) {
+	startTime := time.Now()
+	shares, err := p.Provider.getShares(limit, offset, order, username)
+	providerOperationCompleted("get_shares", startTime, err)
+	return shares, err
+}
+
+func (p *timedProvider) dumpShares() ([]Share, error) {
+	startTime := time.Now()
+	shares, err := p.Provider.dumpShares()
+	providerOperationCompleted("dump_shares", startTime, err)
+	return shares, err
+}
+
+func (p *timedProvider) updateShareLastUse(shareID string, numTokens int) error {
+	startTime := time.Now()
+	err := p.Provider.updateShareLastUse(shareID, numTokens)
+	providerOperationCompleted("update_share_last_use", startTime, err)
+	return err
+}
+
+func (p *timedProvider) addQueuedEvent(event *QueuedEvent) error {
+	startTime := time.Now()
+	err := p.Provider.addQueuedEvent(event)
+	providerOperationCompleted("add_queued_event", startTime, err)
+	return err
+}
+
+func (p *timedProvider) getQueuedEvents(limit int, before int64) ([]QueuedEvent, error) {
+	startTime := time.Now()
+	events, err := p.Provider.getQueuedEvents(limit, before)
+	providerOperationCompleted("get_queued_events", startTime, err)
+	return events, err
+}
+
+func (p *timedProvider) updateQueuedEvent(event *QueuedEvent) error {
+	startTime := time.Now()
+	err := p.Provider.updateQueuedEvent(event)
+	providerOperationCompleted("update_queued_event", startTime, err)
+	return err
+}
+
+func (p *timedProvider) deleteQueuedEvent(id int64) error {
+	startTime := time.Now()
+	err := p.Provider.deleteQueuedEvent(id)
+	providerOperationCompleted("delete_queued_event", startTime, err)
+	return err
+}
+
+func (p *timedProvider) addConnectionRecord(record *ConnectionRecord) error {
+	startTime := time.Now()
+	err := p.Provider.addConnectionRecord(record)
+	providerOperationCompleted("add_connection_record", startTime, err)
+	return err
+}
+
+func (p *timedProvider) searchConnectionRecords(filters ConnectionHistoryFilters, limit, offset int, order string) ([]ConnectionRecord, error) {
+	startTime := time.Now()
+	records, err := p.Provider.searchConnectionRecords(filters, limit, offset, order)
+	providerOperationCompleted("search_connection_records", startTime, err)
+	return records, err
+}
+
+func (p *timedProvider) cleanupConnectionRecords(before int64) error {
+	startTime := time.Now()
+	err := p.Provider.cleanupConnectionRecords(before)
+	providerOperationCompleted("cleanup_connection_records", startTime, err)
+	return err
+}
+
+func (p *timedProvider) addAuditEvent(event *AuditEvent) error {
+	startTime := time.Now()
+	err := p.Provider.addAuditEvent(event)
+	providerOperationCompleted("add_audit_event", startTime, err)
+	return err
+}
+
+func (p *timedProvider) searchAuditEvents(filters AuditEventsFilters, limit, offset int, order string) ([]AuditEvent, error) {
+	startTime := time.Now()
+	events, err := p.Provider.searchAuditEvents(filters, limit, offset, order)
+	providerOperationCompleted("search_audit_events", startTime, err)
+	return events, err
+}
+
+func (p *timedProvider) cleanupAuditEvents(before int64) error {
+	startTime := time.Now()
+	err := p.Provider.cleanupAuditEvents(before)
+	providerOperationCompleted("cleanup_audit_events", startTime, err)
+	return err
+}
+
+func (p *timedProvider) checkAvailability() error {
+	startTime := time.Now()
+	err := p.Provider.checkAvailability()
+	providerOperationCompleted("check_availability", startTime, err)
+	return err
+}
@@ -0,0 +1,332 @@
+package dataprovider
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/png"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	totpIssuer          = "SFTPGo"
+	totpPasscodeLength  = 6
+	recoveryCodesNumber = 12
+	recoveryCodeLength  = 10
+	totpQRCodeSize      = 200
+	// a passcode is accepted for the current period and for the previous and
+	// the next one, so it must be remembered for three periods
+	totpPasscodeValidity = 90 * time.Second
+)
+
+var (
+	// ValidTOTPProtocols defines the protocols where TOTP can be enforced for users
+	ValidTOTPProtocols = []string{"SSH", "FTP", "DAV", "HTTP"}
+	errInvalidPasscode = errors.New("invalid passcode")
+	usedPasscodes      = usedPasscodesCache{
+		passcodes: make(map[string]time.Time),
+	}
+)
+
+// usedPasscodesCache keeps track of the TOTP passcodes already used for each account,
+// so a passcode cannot be replayed while it is still valid
+type usedPasscodesCache struct {
+	sync.Mutex
+	passcodes map[string]time.Time
+}
+
+// add marks the given passcode as used for the specified account.
+// It returns false if the passcode was already used
+func (c *usedPasscodesCache) add(account, passcode string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	for k, expiration := range c.passcodes {
+		if expiration.Before(now) {
+			delete(c.passcodes, k)
+		}
+	}
+	key := account + "\x00" + passcode
+	if _, ok := c.passcodes[key]; ok {
+		return false
+	}
+	c.passcodes[key] = now.Add(totpPasscodeValidity)
+	return true
+}
+
+// TOTPConfig defines the time-based one time password configuration
+type TOTPConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Base32 encoded secret, it is stored encrypted
+	Secret *kms.Secret `json:"secret,omitempty"`
+	// Protocols where TOTP is required, users only.
+	// If empty TOTP is required for all the supported protocols
+	Protocols []string `json:"protocols,omitempty"`
+}
+
+// RecoveryCode defines a one time recovery code to use if the TOTP device is lost.
+// Only the code hash is stored
+type RecoveryCode struct {
+	Hash string `json:"hash"`
+	Used bool   `json:"used,omitempty"`
+}
+
+func (c *TOTPConfig) validate(additionalData string, withProtocols bool) error {
+	if c.Secret == nil {
+		c.Secret = kms.NewEmptySecret()
+	}
+	if !c.Enabled {
+		c.Secret = kms.NewEmptySecret()
+		c.Protocols = nil
+		return nil
+	}
+	if c.Secret.IsEmpty() || c.Secret.IsRedacted() {
+		return &ValidationError{err: "invalid TOTP secret"}
+	}
+	if c.Secret.IsPlain() {
+		secret := strings.ToUpper(strings.ReplaceAll(c.Secret.GetPayload(), " ", ""))
+		if _, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "=")); err != nil {
+			return &ValidationError{err: fmt.Sprintf("invalid TOTP secret, it must be base32 encoded: %v", err)}
+		}
+		c.Secret = kms.NewPlainSecret(secret)
+		c.Secret.SetAdditionalData(additionalData)
+		if err := c.Secret.Encrypt(); err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not encrypt TOTP secret: %v", err)}
+		}
+	}
+	if !withProtocols {
+		c.Protocols = nil
+		return nil
+	}
+	c.Protocols = utils.RemoveDuplicates(c.Protocols)
+	for _, p := range c.Protocols {
+		if !utils.IsStringInSlice(p, ValidTOTPProtocols) {
+			return &ValidationError{err: fmt.Sprintf("invalid TOTP protocol: %#v", p)}
+		}
+	}
+	return nil
+}
+
+// isRequiredForProtocol returns true if TOTP is enabled for the given protocol
+func (c *TOTPConfig) isRequiredForProtocol(protocol string) bool {
+	if !c.Enabled {
+		return false
+	}
+	if len(c.Protocols) == 0 {
+		return utils.IsStringInSlice(protocol, ValidTOTPProtocols)
+	}
+	return utils.IsStringInSlice(protocol, c.Protocols)
+}
+
+// validatePasscode returns true if the given passcode is valid for the current time
+// and it was not already used for the specified account
+func (c *TOTPConfig) validatePasscode(passcode, account string) (bool, error) {
+	if c.Secret == nil || c.Secret.IsEmpty() {
+		return false, errors.New("no TOTP secret configured")
+	}
+	secret := c.Secret.Clone()
+	if secret.IsEncrypted() {
+		if err := secret.Decrypt(); err != nil {
+			return false, fmt.Errorf("unable to decrypt TOTP secret: %v", err)
+		}
+	}
+	if !totp.Validate(passcode, secret.GetPayload()) {
+		return false, nil
+	}
+	if !usedPasscodes.add(account, passcode) {
+		providerLog(logger.LevelWarn, "passcode already used for account %#v", account)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (c *TOTPConfig) getACopy() TOTPConfig {
+	if c.Secret == nil {
+		c.Secret = kms.NewEmptySecret()
+	}
+	protocols := make([]string, len(c.Protocols))
+	copy(protocols, c.Protocols)
+	return TOTPConfig{
+		Enabled:   c.Enabled,
+		Secret:    c.Secret.Clone(),
+		Protocols: protocols,
+	}
+}
+
+// GenerateTOTPSecret generates a new TOTP secret for the given account.
+// It returns the base32 encoded secret, the key URL and its QR code as PNG image
+func GenerateTOTPSecret(accountName string) (string, string, []byte, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: accountName,
+	})
+	if err != nil {
+		return "", "", nil, err
+	}
+	img, err := key.Image(totpQRCodeSize, totpQRCodeSize)
+	if err != nil {
+		return "", "", nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", "", nil, err
+	}
+	return key.Secret(), key.URL(), buf.Bytes(), nil
+}
+
+// ValidateTOTPPasscode returns true if the passcode is valid for the given base32 encoded secret
+func ValidateTOTPPasscode(secret, passcode string) bool {
+	return totp.Validate(strings.TrimSpace(passcode), secret)
+}
+
+// GenerateRecoveryCodes returns new recovery codes in plain text and
+// the recovery codes to store. The codes are hashed using the configured
+// password hashing algorithm
+func GenerateRecoveryCodes() ([]string, []RecoveryCode, error) {
+	codes := make([]string, 0, recoveryCodesNumber)
+	recoveryCodes := make([]RecoveryCode, 0, recoveryCodesNumber)
+	for len(codes) < recoveryCodesNumber {
+		code := hex.EncodeToString(utils.GenerateRandomBytes(recoveryCodeLength))[:recoveryCodeLength]
+		hash, err := hashPlainPassword(code)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to hash recovery code: %w", err)
+		}
+		codes = append(codes, code)
+		recoveryCodes = append(recoveryCodes, RecoveryCode{
+			Hash: hash,
+		})
+	}
+	return codes, recoveryCodes, nil
+}
+
+// getRecoveryCodeIndex returns the index of the given code if it is a valid, unused, recovery code or -1
+func getRecoveryCodeIndex(codes []RecoveryCode, code string) int {
+	if len(strings.TrimSpace(code)) != recoveryCodeLength {
+		return -1
+	}
+	code = strings.ToLower(strings.TrimSpace(code))
+	for idx := range codes {
+		if codes[idx].Used || !isHashedWithSupportedAlgo(codes[idx].Hash) {
+			continue
+		}
+		match, err := compareSupportedAlgoHash(code, codes[idx].Hash)
+		if err != nil {
+			providerLog(logger.LevelWarn, "unable to compare recovery code hash: %v", err)
+			continue
+		}
+		if match {
+			return idx
+		}
+	}
+	return -1
+}
+
+func getUnusedRecoveryCodes(codes []RecoveryCode) int {
+	unused := 0
+	for _, code := range codes {
+		if !code.Used {
+			unused++
+		}
+	}
+	return unused
+}
+
+// checkUserPasscode validates a TOTP passcode for the given user. If the passcode
+// is a valid recovery code it is returned, it must be marked as used after
+// the password validation
+func checkUserPasscode(user *User, passcode string) (string, error) {
+	match, err := user.Filters.TOTPConfig.validatePasscode(passcode, "user_"+user.Username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to validate passcode for user %#v: %v", user.Username, err)
+		return "", err
+	}
+	if match {
+		return "", nil
+	}
+	if getRecoveryCodeIndex(user.Filters.RecoveryCodes, passcode) >= 0 {
+		return passcode, nil
+	}
+	return "", errInvalidPasscode
+}
+
+// splitPasswordAndPasscode checks the TOTP passcode, or the recovery code, appended to the given password.
+// It returns the password without the passcode and the recovery code, if any
+func splitPasswordAndPasscode(user *User, password string) (string, string, error) {
+	if len(password) > totpPasscodeLength {
+		match, err := user.Filters.TOTPConfig.validatePasscode(password[len(password)-totpPasscodeLength:],
+			"user_"+user.Username)
+		if err != nil {
+			providerLog(logger.LevelWarn, "unable to validate passcode for user %#v: %v", user.Username, err)
+			return "", "", err
+		}
+		if match {
+			return password[:len(password)-totpPasscodeLength], "", nil
+		}
+	}
+	if len(password) > recoveryCodeLength {
+		code := password[len(password)-recoveryCodeLength:]
+		if getRecoveryCodeIndex(user.Filters.RecoveryCodes, code) >= 0 {
+			return password[:len(password)-recoveryCodeLength], code, nil
+		}
+	}
+	return "", "", errInvalidPasscode
+}
+
+// useUserRecoveryCode marks the given recovery code as used and saves the user
+func useUserRecoveryCode(user *User, code string) error {
+	u, err := provider.userExists(user.Username)
+	if err != nil {
+		return err
+	}
+	idx := getRecoveryCodeIndex(u.Filters.RecoveryCodes, code)
+	if idx < 0 {
+		return errInvalidPasscode
+	}
+	u.Filters.RecoveryCodes[idx].Used = true
+	if err := provider.updateUser(&u); err != nil {
+		providerLog(logger.LevelWarn, "unable to save used recovery code for user %#v: %v", user.Username, err)
+		return err
+	}
+	providerLog(logger.LevelInfo, "recovery code used for user %#v, unused recovery codes: %v", user.Username,
+		getUnusedRecoveryCodes(u.Filters.RecoveryCodes))
+	RemoveCachedUser(user.Username)
+	return nil
+}
+
+// CheckAdminPasscode validates a TOTP passcode or a recovery code for the given admin.
+// Used recovery codes are persisted in the data provider
+func CheckAdminPasscode(admin *Admin, passcode string) error {
+	if !admin.Filters.TOTPConfig.Enabled {
+		return nil
+	}
+	if passcode == "" {
+		return errors.New("a passcode is required")
+	}
+	match, err := admin.Filters.TOTPConfig.validatePasscode(passcode, "admin_"+admin.Username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to validate passcode for admin %#v: %v", admin.Username, err)
+		return err
+	}
+	if match {
+		return nil
+	}
+	idx := getRecoveryCodeIndex(admin.Filters.RecoveryCodes, passcode)
+	if idx < 0 {
+		return errInvalidPasscode
+	}
+	admin.Filters.RecoveryCodes[idx].Used = true
+	providerLog(logger.LevelInfo, "recovery code used for admin %#v, unused recovery codes: %v", admin.Username,
+		getUnusedRecoveryCodes(admin.Filters.RecoveryCodes))
+	return provider.updateAdmin(admin)
+}
@@ -1,6 +1,8 @@
 package dataprovider
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,6 +16,7 @@ import (
 
 	"golang.org/x/net/webdav"
 
+	"github.com/drakkan/sftpgo/geoip"
 	"github.com/drakkan/sftpgo/kms"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/utils"
@@ -59,6 +62,19 @@ const (
 	SSHLoginMethodKeyAndKeyboardInt   = "publickey+keyboard-interactive"
 )
 
+// Supported periods for data transfer quotas
+const (
+	TransferQuotaPeriodDay   = "day"
+	TransferQuotaPeriodMonth = "month"
+)
+
+// Supported policies for interrupted atomic uploads
+const (
+	PartialUploadsDelete     = "delete"
+	PartialUploadsKeep       = "keep"
+	PartialUploadsQuarantine = "quarantine"
+)
+
 var (
 	errNoMatchingVirtualFolder = errors.New("no matching virtual folder found")
 )
@@ -71,8 +87,12 @@ type CachedUser struct {
 	LockSystem webdav.LockSystem
 }
 
-// IsExpired returns true if the cached user is expired
+// IsExpired returns true if the cached user is expired.
+// A cached user is expired if the cache entry or the user account is expired
 func (c *CachedUser) IsExpired() bool {
+	if c.User.IsExpired() {
+		return true
+	}
 	if c.Expiration.IsZero() {
 		return false
 	}
@@ -122,6 +142,95 @@ type PatternsFilter struct {
 	DeniedPatterns []string `json:"denied_patterns,omitempty"`
 }
 
+// TransferQuota defines the data transfer limits for a user.
+// Uploaded and downloaded bytes are tracked for the configured period
+// and the transfers exceeding the limits are aborted
+type TransferQuota struct {
+	// period for the limits, "day" or "month". Periods start at midnight UTC
+	Period string `json:"period,omitempty"`
+	// maximum bytes that can be uploaded within a period, 0 means unlimited
+	UploadSize int64 `json:"upload_size,omitempty"`
+	// maximum bytes that can be downloaded within a period, 0 means unlimited
+	DownloadSize int64 `json:"download_size,omitempty"`
+}
+
+// PartialUploads defines how to handle the interrupted atomic uploads.
+// It has no effect if atomic uploads are disabled or not supported
+// by the user's filesystem
+type PartialUploads struct {
+	// "delete" removes the temporary file, "keep" renames it adding a ".partial" suffix
+	// to the requested path, so a resumed upload continues from it, and "quarantine"
+	// moves it to QuarantinePath.
+	// Empty means the global upload mode applies
+	Policy string `json:"policy,omitempty"`
+	// virtual directory where the partial files are moved for the quarantine policy
+	QuarantinePath string `json:"quarantine_path,omitempty"`
+}
+
+// DirectoryLimit defines the maximum number of entries, files and directories,
+// allowed directly inside the matching directories
+type DirectoryLimit struct {
+	// Virtual path, it can contain shell patterns, for example "/uploads/*"
+	// matches any direct sub directory of "/uploads"
+	Path string `json:"path"`
+	// maximum number of entries allowed inside a matching directory
+	MaxFiles int `json:"max_files"`
+}
+
+// FolderRetention defines the retention policy for the specified directory path
+type FolderRetention struct {
+	// Path is the exposed virtual directory path, if no other specific retention is defined,
+	// the retention applies for sub directories too. For example if retention is defined
+	// for the paths "/" and "/sub" then the retention for "/" is applied for any file outside
+	// the "/sub" directory
+	Path string `json:"path"`
+	// Retention time in hours. 0 means exclude this path
+	Retention int `json:"retention"`
+	// DeleteEmptyDirs defines if empty directories will be deleted.
+	// The user needs the delete permission
+	DeleteEmptyDirs bool `json:"delete_empty_dirs,omitempty"`
+	// IgnoreUserPermissions defines if delete files even if the user does not have the delete permission.
+	// The default is false which means that files are skipped if the user does not have the permission
+	// to delete them. This applies to sub directories too.
+	IgnoreUserPermissions bool `json:"ignore_user_permissions,omitempty"`
+}
+
+// Validate returns an error if the configuration is not valid
+func (f *FolderRetention) Validate() error {
+	f.Path = utils.CleanPath(f.Path)
+	if f.Retention < 0 {
+		return &ValidationError{err: fmt.Sprintf("invalid folder retention %v, it must be greater or equal to zero", f.Retention)}
+	}
+	return nil
+}
+
+// HasLimits returns true if an upload or download limit is defined
+func (q *TransferQuota) HasLimits() bool {
+	return q.UploadSize > 0 || q.DownloadSize > 0
+}
+
+// GetPeriodStart returns the start of the period including the given time
+// as unix timestamp in milliseconds
+func (q *TransferQuota) GetPeriodStart(t time.Time) int64 {
+	t = t.UTC()
+	if q.Period == TransferQuotaPeriodMonth {
+		return utils.GetTimeAsMsSinceEpoch(time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC))
+	}
+	return utils.GetTimeAsMsSinceEpoch(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC))
+}
+
+// BandwidthLimit defines per-source bandwidth limits that override the
+// user's upload and download bandwidth
+type BandwidthLimit struct {
+	// source networks in CIDR notation as defined in RFC 4632 and RFC 4291
+	// for example "192.0.2.0/24" or "2001:db8::/32"
+	Sources []string `json:"sources"`
+	// maximum upload bandwidth as KB/s, 0 means unlimited
+	UploadBandwidth int64 `json:"upload_bandwidth,omitempty"`
+	// maximum download bandwidth as KB/s, 0 means unlimited
+	DownloadBandwidth int64 `json:"download_bandwidth,omitempty"`
+}
+
 // UserFilters defines additional restrictions for a user
 type UserFilters struct {
 	// only clients connecting from these IP/Mask are allowed.
@@ -131,6 +240,13 @@ type UserFilters struct {
 	// clients connecting from these IP/Mask are not allowed.
 	// Denied rules will be evaluated before allowed ones
 	DeniedIP []string `json:"denied_ip,omitempty"`
+	// only clients connecting from these countries are allowed.
+	// The countries are ISO 3166-1 alpha-2 codes, for example "IT" or "US",
+	// and they require a GeoIP database
+	AllowedCountries []string `json:"allowed_countries,omitempty"`
+	// clients connecting from these countries are not allowed.
+	// Denied countries will be evaluated before allowed ones
+	DeniedCountries []string `json:"denied_countries,omitempty"`
 	// these login methods are not allowed.
 	// If null or empty any available login method is allowed
 	DeniedLoginMethods []string `json:"denied_login_methods,omitempty"`
@@ -144,6 +260,39 @@ type UserFilters struct {
 	FilePatterns []PatternsFilter `json:"file_patterns,omitempty"`
 	// max size allowed for a single upload, 0 means unlimited
 	MaxUploadFileSize int64 `json:"max_upload_file_size,omitempty"`
+	// max concurrent uploads and downloads for all the user sessions, 0 means unlimited
+	MaxConcurrentTransfers int `json:"max_concurrent_transfers,omitempty"`
+	// max concurrent sessions for the specified protocols, the key is the protocol:
+	// SSH, FTP or DAV. They apply in addition to the global max sessions
+	MaxSessionsPerProtocol map[string]int `json:"max_sessions_per_protocol,omitempty"`
+	// data transfer limits
+	TransferQuota TransferQuota `json:"transfer_quota"`
+	// groups this user belongs to. Groups can be used to restrict the users
+	// an admin is allowed to manage
+	Groups []string `json:"groups,omitempty"`
+	// time-based one time password configuration, if enabled a passcode is
+	// required in addition to the password
+	TOTPConfig TOTPConfig `json:"totp_config,omitempty"`
+	// recovery codes to use if the TOTP device is lost
+	RecoveryCodes []RecoveryCode `json:"recovery_codes,omitempty"`
+	// restrictions for temporary credentials, they are set for users created
+	// using the temporary credentials API
+	TempCredentials TempCredentials `json:"temp_credentials,omitempty"`
+	// bandwidth limits overrides based on the client IP address.
+	// The first limit with a matching source is applied
+	BandwidthLimits []BandwidthLimit `json:"bandwidth_limits,omitempty"`
+	// policy for the interrupted atomic uploads
+	PartialUploads PartialUploads `json:"partial_uploads"`
+	// limits for the number of entries inside directories.
+	// The first limit with a matching path applies
+	DirectoryLimits []DirectoryLimit `json:"directory_limits,omitempty"`
+	// data retention policies applied by the scheduled retention checks
+	DataRetention []FolderRetention `json:"data_retention,omitempty"`
+	// LDAP domain for the users added by the built-in LDAP authentication, "*" for
+	// the default domain. The LDAP authentication never updates the other users
+	LDAPDomain string `json:"ldap_domain,omitempty"`
+	// API key authentication allows to impersonate this user with an API key not bound to any user
+	AllowAPIKeyAuth bool `json:"allow_api_key_auth,omitempty"`
 }
 
 // FilesystemProvider defines the supported storages
@@ -182,7 +331,7 @@ type User struct {
 	ExpirationDate int64 `json:"expiration_date"`
 	// Password used for password authentication.
 	// For users created using SFTPGo REST API the password is be stored using argon2id hashing algo.
-	// Checking passwords stored with bcrypt, pbkdf2, md5crypt and sha512crypt is supported too.
+	// Checking passwords stored with bcrypt, pbkdf2, md5crypt, sha512crypt, LDAP salted SHA and Django pbkdf2 is supported too.
 	Password string `json:"password,omitempty"`
 	// PublicKeys used for public key authentication. At least one between password and a public key is mandatory
 	PublicKeys []string `json:"public_keys,omitempty"`
@@ -209,12 +358,21 @@ type User struct {
 	UsedQuotaFiles int `json:"used_quota_files"`
 	// Last quota update as unix timestamp in milliseconds
 	LastQuotaUpdate int64 `json:"last_quota_update"`
+	// Uploaded bytes in the current data transfer quota period
+	UsedUploadDataTransfer int64 `json:"used_upload_data_transfer"`
+	// Downloaded bytes in the current data transfer quota period
+	UsedDownloadDataTransfer int64 `json:"used_download_data_transfer"`
+	// Start of the data transfer quota period the used data transfer refers to,
+	// as unix timestamp in milliseconds
+	DataTransferPeriodStart int64 `json:"data_transfer_period_start"`
 	// Maximum upload bandwidth as KB/s, 0 means unlimited
 	UploadBandwidth int64 `json:"upload_bandwidth"`
 	// Maximum download bandwidth as KB/s, 0 means unlimited
 	DownloadBandwidth int64 `json:"download_bandwidth"`
 	// Last login as unix timestamp in milliseconds
 	LastLogin int64 `json:"last_login"`
+	// Protocol used for the last login, for example SSH, FTP, DAV
+	LastLoginProtocol string `json:"last_login_protocol,omitempty"`
 	// Additional restrictions
 	Filters UserFilters `json:"filters"`
 	// Filesystem configuration details
@@ -259,6 +417,27 @@ func (u *User) HideConfidentialData() {
 		u.FsConfig.SFTPConfig.Password.Hide()
 		u.FsConfig.SFTPConfig.PrivateKey.Hide()
 	}
+	if u.Filters.TOTPConfig.Secret != nil {
+		u.Filters.TOTPConfig.Secret.Hide()
+	}
+	u.Filters.RecoveryCodes = nil
+}
+
+// GetSignature returns a signature for this user.
+// It will change after a password change
+func (u *User) GetSignature() string {
+	data := []byte(u.Username)
+	data = append(data, []byte(u.Password)...)
+	signature := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(signature[:])
+}
+
+// CheckPassword verifies the user password, a user without a password never matches
+func (u *User) CheckPassword(password string) (bool, error) {
+	if u.Password == "" || password == "" {
+		return false, nil
+	}
+	return isPasswordOK(u, password)
 }
 
 // IsPasswordHashed returns true if the password is hashed
@@ -627,6 +806,9 @@ func (u *User) isFilePatternAllowed(virtualPath string) bool {
 // If DeniedIP is defined the specified IP/Mask cannot login.
 // If an IP is both allowed and denied then login will be denied
 func (u *User) IsLoginFromAddrAllowed(remoteAddr string) bool {
+	if !u.isLoginFromCountryAllowed(remoteAddr) {
+		return false
+	}
 	if len(u.Filters.AllowedIP) == 0 && len(u.Filters.DeniedIP) == 0 {
 		return true
 	}
@@ -657,6 +839,70 @@ func (u *User) IsLoginFromAddrAllowed(remoteAddr string) bool {
 	return len(u.Filters.AllowedIP) == 0
 }
 
+// isLoginFromCountryAllowed returns true if the login is allowed from the
+// country of the specified remoteAddr. If the country cannot be determined
+// the login is allowed only if no allowed countries are defined
+func (u *User) isLoginFromCountryAllowed(remoteAddr string) bool {
+	if len(u.Filters.AllowedCountries) == 0 && len(u.Filters.DeniedCountries) == 0 {
+		return true
+	}
+	country := geoip.GetCountry(utils.GetIPFromRemoteAddress(remoteAddr))
+	if !geoip.IsCountryAllowed(country, u.Filters.AllowedCountries, u.Filters.DeniedCountries) {
+		logger.Debug(logSender, "", "login from %#v, country %#v, not allowed for user %#v", remoteAddr, country,
+			u.Username)
+		return false
+	}
+	return true
+}
+
+// GetMaxSessionsForProtocol returns the max concurrent sessions allowed for
+// the given protocol, 0 means unlimited
+func (u *User) GetMaxSessionsForProtocol(protocol string) int {
+	return u.Filters.MaxSessionsPerProtocol[protocol]
+}
+
+// GetBandwidthForIP returns the upload and download bandwidth, as KB/s, for
+// the given client IP address. The first bandwidth limit with a matching
+// source overrides the user's bandwidth
+func (u *User) GetBandwidthForIP(clientIP, connectionID string) (int64, int64) {
+	if len(u.Filters.BandwidthLimits) == 0 {
+		return u.UploadBandwidth, u.DownloadBandwidth
+	}
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return u.UploadBandwidth, u.DownloadBandwidth
+	}
+	for _, limit := range u.Filters.BandwidthLimits {
+		for _, source := range limit.Sources {
+			_, ipNet, err := net.ParseCIDR(source)
+			if err != nil {
+				continue
+			}
+			if ipNet.Contains(ip) {
+				logger.Debug(logSender, connectionID, "override bandwidth limit for ip %#v, upload limit: %v KB/s, download limit: %v KB/s",
+					clientIP, limit.UploadBandwidth, limit.DownloadBandwidth)
+				return limit.UploadBandwidth, limit.DownloadBandwidth
+			}
+		}
+	}
+	return u.UploadBandwidth, u.DownloadBandwidth
+}
+
+// GetDirectoryLimit returns the maximum number of entries allowed inside
+// the given virtual directory. The first limit with a matching path applies.
+// 0 means unlimited
+func (u *User) GetDirectoryLimit(virtualDir string) int {
+	for _, limit := range u.Filters.DirectoryLimits {
+		if limit.Path == virtualDir {
+			return limit.MaxFiles
+		}
+		if matched, err := path.Match(limit.Path, virtualDir); err == nil && matched {
+			return limit.MaxFiles
+		}
+	}
+	return 0
+}
+
 // GetPermissionsAsJSON returns the permissions as json byte array
 func (u *User) GetPermissionsAsJSON() ([]byte, error) {
 	return json.Marshal(u.Permissions)
@@ -698,6 +944,16 @@ func (u *User) GetHomeDir() string {
 	return filepath.Clean(u.HomeDir)
 }
 
+// GetQuotaUsername returns the username to charge the disk and data transfer usage to.
+// Temporary credentials share the filesystem of their parent user, so the usage is
+// charged to the parent user
+func (u *User) GetQuotaUsername() string {
+	if u.Filters.TempCredentials.IsTemporary() {
+		return u.Filters.TempCredentials.ParentUsername
+	}
+	return u.Username
+}
+
 // HasQuotaRestrictions returns true if there is a quota restriction on number of files or size or both
 func (u *User) HasQuotaRestrictions() bool {
 	return u.QuotaFiles > 0 || u.QuotaSize > 0
@@ -716,6 +972,17 @@ func (u *User) GetQuotaSummary() string {
 			result += "/" + utils.ByteCountIEC(u.QuotaSize)
 		}
 	}
+	if u.Filters.TransferQuota.HasLimits() {
+		usedUL, usedDL := u.GetUsedDataTransfer()
+		result += fmt.Sprintf(". Data transfer per %v: UL %v", u.Filters.TransferQuota.Period, utils.ByteCountIEC(usedUL))
+		if u.Filters.TransferQuota.UploadSize > 0 {
+			result += "/" + utils.ByteCountIEC(u.Filters.TransferQuota.UploadSize)
+		}
+		result += " DL " + utils.ByteCountIEC(usedDL)
+		if u.Filters.TransferQuota.DownloadSize > 0 {
+			result += "/" + utils.ByteCountIEC(u.Filters.TransferQuota.DownloadSize)
+		}
+	}
 	return result
 }
 
@@ -759,13 +1026,16 @@ func (u *User) GetBandwidthAsString() string {
 }
 
 // GetInfoString returns user's info as string.
-// Storage provider, number of public keys, max sessions, uid,
-// gid, denied and allowed IP/Mask are returned
+// Storage provider, number of public keys, max sessions, max upload file size,
+// uid, gid, denied and allowed IP/Mask and additional info are returned
 func (u *User) GetInfoString() string {
 	var result string
 	if u.LastLogin > 0 {
 		t := utils.GetTimeFromMsecSinceEpoch(u.LastLogin)
 		result += fmt.Sprintf("Last login: %v ", t.Format("2006-01-02 15:04:05")) // YYYY-MM-DD HH:MM:SS
+		if u.LastLoginProtocol != "" {
+			result += fmt.Sprintf("(%v) ", u.LastLoginProtocol)
+		}
 	}
 	switch u.FsConfig.Provider {
 	case S3FilesystemProvider:
@@ -785,6 +1055,9 @@ func (u *User) GetInfoString() string {
 	if u.MaxSessions > 0 {
 		result += fmt.Sprintf("Max sessions: %v ", u.MaxSessions)
 	}
+	if u.Filters.MaxUploadFileSize > 0 {
+		result += fmt.Sprintf("Max upload size: %v ", utils.ByteCountIEC(u.Filters.MaxUploadFileSize))
+	}
 	if u.UID > 0 {
 		result += fmt.Sprintf("UID: %v ", u.UID)
 	}
@@ -797,9 +1070,36 @@ func (u *User) GetInfoString() string {
 	if len(u.Filters.AllowedIP) > 0 {
 		result += fmt.Sprintf("Allowed IP/Mask: %v ", len(u.Filters.AllowedIP))
 	}
+	if len(u.Filters.DeniedCountries) > 0 {
+		result += fmt.Sprintf("Denied countries: %v ", strings.Join(u.Filters.DeniedCountries, ","))
+	}
+	if len(u.Filters.AllowedCountries) > 0 {
+		result += fmt.Sprintf("Allowed countries: %v ", strings.Join(u.Filters.AllowedCountries, ","))
+	}
+	if len(u.Filters.Groups) > 0 {
+		result += fmt.Sprintf("Groups: %v ", strings.Join(u.Filters.Groups, ","))
+	}
+	if u.AdditionalInfo != "" {
+		result += fmt.Sprintf("Info: %v ", u.AdditionalInfo)
+	}
 	return result
 }
 
+// IsInactive returns true if the user logged in at least once and the last login
+// is older than the specified number of days. Users that never logged in are not
+// considered inactive since we don't know when they were created
+func (u *User) IsInactive(days int) bool {
+	if days <= 0 || u.LastLogin <= 0 {
+		return false
+	}
+	return u.LastLogin < utils.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Duration(days)*24*time.Hour))
+}
+
+// IsExpired returns true if the user account has an expiration date in the past
+func (u *User) IsExpired() bool {
+	return u.ExpirationDate > 0 && u.ExpirationDate < utils.GetTimeAsMsSinceEpoch(time.Now())
+}
+
 // GetExpirationDateAsString returns expiration date formatted as YYYY-MM-DD
 func (u *User) GetExpirationDateAsString() string {
 	if u.ExpirationDate > 0 {
@@ -814,11 +1114,45 @@ func (u *User) GetAllowedIPAsString() string {
 	return strings.Join(u.Filters.AllowedIP, ",")
 }
 
+// GetGroupsAsString returns the user groups as comma separated string
+func (u *User) GetGroupsAsString() string {
+	return strings.Join(u.Filters.Groups, ",")
+}
+
+// GetUsedDataTransfer returns the uploaded and downloaded bytes in the current
+// data transfer quota period
+func (u *User) GetUsedDataTransfer() (int64, int64) {
+	if u.DataTransferPeriodStart != u.Filters.TransferQuota.GetPeriodStart(time.Now()) {
+		return 0, 0
+	}
+	return u.UsedUploadDataTransfer, u.UsedDownloadDataTransfer
+}
+
+// IsInGroups returns true if the user belongs to at least one of the given groups
+func (u *User) IsInGroups(groups []string) bool {
+	for _, group := range u.Filters.Groups {
+		if utils.IsStringInSlice(group, groups) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetDeniedIPAsString returns the denied IP as comma separated string
 func (u *User) GetDeniedIPAsString() string {
 	return strings.Join(u.Filters.DeniedIP, ",")
 }
 
+// GetAllowedCountriesAsString returns the allowed countries as comma separated string
+func (u *User) GetAllowedCountriesAsString() string {
+	return strings.Join(u.Filters.AllowedCountries, ",")
+}
+
+// GetDeniedCountriesAsString returns the denied countries as comma separated string
+func (u *User) GetDeniedCountriesAsString() string {
+	return strings.Join(u.Filters.DeniedCountries, ",")
+}
+
 // SetEmptySecretsIfNil sets the secrets to empty if nil
 func (u *User) SetEmptySecretsIfNil() {
 	if u.FsConfig.S3Config.AccessSecret == nil {
@@ -855,10 +1189,24 @@ func (u *User) getACopy() User {
 	}
 	filters := UserFilters{}
 	filters.MaxUploadFileSize = u.Filters.MaxUploadFileSize
+	filters.MaxConcurrentTransfers = u.Filters.MaxConcurrentTransfers
+	filters.AllowAPIKeyAuth = u.Filters.AllowAPIKeyAuth
+	if u.Filters.MaxSessionsPerProtocol != nil {
+		filters.MaxSessionsPerProtocol = make(map[string]int)
+		for k, v := range u.Filters.MaxSessionsPerProtocol {
+			filters.MaxSessionsPerProtocol[k] = v
+		}
+	}
+	filters.TransferQuota = u.Filters.TransferQuota
+	filters.PartialUploads = u.Filters.PartialUploads
 	filters.AllowedIP = make([]string, len(u.Filters.AllowedIP))
 	copy(filters.AllowedIP, u.Filters.AllowedIP)
 	filters.DeniedIP = make([]string, len(u.Filters.DeniedIP))
 	copy(filters.DeniedIP, u.Filters.DeniedIP)
+	filters.AllowedCountries = make([]string, len(u.Filters.AllowedCountries))
+	copy(filters.AllowedCountries, u.Filters.AllowedCountries)
+	filters.DeniedCountries = make([]string, len(u.Filters.DeniedCountries))
+	copy(filters.DeniedCountries, u.Filters.DeniedCountries)
 	filters.DeniedLoginMethods = make([]string, len(u.Filters.DeniedLoginMethods))
 	copy(filters.DeniedLoginMethods, u.Filters.DeniedLoginMethods)
 	filters.FileExtensions = make([]ExtensionsFilter, len(u.Filters.FileExtensions))
@@ -867,31 +1215,60 @@ func (u *User) getACopy() User {
 	copy(filters.FilePatterns, u.Filters.FilePatterns)
 	filters.DeniedProtocols = make([]string, len(u.Filters.DeniedProtocols))
 	copy(filters.DeniedProtocols, u.Filters.DeniedProtocols)
+	filters.Groups = make([]string, len(u.Filters.Groups))
+	copy(filters.Groups, u.Filters.Groups)
+	filters.TOTPConfig = u.Filters.TOTPConfig.getACopy()
+	filters.RecoveryCodes = make([]RecoveryCode, len(u.Filters.RecoveryCodes))
+	copy(filters.RecoveryCodes, u.Filters.RecoveryCodes)
+	filters.TempCredentials = u.Filters.TempCredentials
+	filters.BandwidthLimits = make([]BandwidthLimit, 0, len(u.Filters.BandwidthLimits))
+	for _, limit := range u.Filters.BandwidthLimits {
+		sources := make([]string, len(limit.Sources))
+		copy(sources, limit.Sources)
+		filters.BandwidthLimits = append(filters.BandwidthLimits, BandwidthLimit{
+			Sources:           sources,
+			UploadBandwidth:   limit.UploadBandwidth,
+			DownloadBandwidth: limit.DownloadBandwidth,
+		})
+	}
+	filters.DirectoryLimits = make([]DirectoryLimit, len(u.Filters.DirectoryLimits))
+	copy(filters.DirectoryLimits, u.Filters.DirectoryLimits)
+	filters.DataRetention = make([]FolderRetention, len(u.Filters.DataRetention))
+	copy(filters.DataRetention, u.Filters.DataRetention)
+	filters.LDAPDomain = u.Filters.LDAPDomain
 	fsConfig := Filesystem{
 		Provider: u.FsConfig.Provider,
 		S3Config: vfs.S3FsConfig{
-			Bucket:            u.FsConfig.S3Config.Bucket,
-			Region:            u.FsConfig.S3Config.Region,
-			AccessKey:         u.FsConfig.S3Config.AccessKey,
-			AccessSecret:      u.FsConfig.S3Config.AccessSecret.Clone(),
-			Endpoint:          u.FsConfig.S3Config.Endpoint,
-			StorageClass:      u.FsConfig.S3Config.StorageClass,
-			KeyPrefix:         u.FsConfig.S3Config.KeyPrefix,
-			UploadPartSize:    u.FsConfig.S3Config.UploadPartSize,
-			UploadConcurrency: u.FsConfig.S3Config.UploadConcurrency,
+			Bucket:             u.FsConfig.S3Config.Bucket,
+			Region:             u.FsConfig.S3Config.Region,
+			AccessKey:          u.FsConfig.S3Config.AccessKey,
+			AccessSecret:       u.FsConfig.S3Config.AccessSecret.Clone(),
+			CredentialsFile:    u.FsConfig.S3Config.CredentialsFile,
+			CredentialsProfile: u.FsConfig.S3Config.CredentialsProfile,
+			AccessKeyEnv:       u.FsConfig.S3Config.AccessKeyEnv,
+			AccessSecretEnv:    u.FsConfig.S3Config.AccessSecretEnv,
+			Endpoint:           u.FsConfig.S3Config.Endpoint,
+			StorageClass:       u.FsConfig.S3Config.StorageClass,
+			KeyPrefix:          u.FsConfig.S3Config.KeyPrefix,
+			UploadPartSize:     u.FsConfig.S3Config.UploadPartSize,
+			UploadConcurrency:  u.FsConfig.S3Config.UploadConcurrency,
 		},
 		GCSConfig: vfs.GCSFsConfig{
-			Bucket:               u.FsConfig.GCSConfig.Bucket,
-			CredentialFile:       u.FsConfig.GCSConfig.CredentialFile,
-			Credentials:          u.FsConfig.GCSConfig.Credentials.Clone(),
-			AutomaticCredentials: u.FsConfig.GCSConfig.AutomaticCredentials,
-			StorageClass:         u.FsConfig.GCSConfig.StorageClass,
-			KeyPrefix:            u.FsConfig.GCSConfig.KeyPrefix,
+			Bucket:                u.FsConfig.GCSConfig.Bucket,
+			CredentialFile:        u.FsConfig.GCSConfig.CredentialFile,
+			Credentials:           u.FsConfig.GCSConfig.Credentials.Clone(),
+			SharedCredentialsFile: u.FsConfig.GCSConfig.SharedCredentialsFile,
+			CredentialsEnv:        u.FsConfig.GCSConfig.CredentialsEnv,
+			AutomaticCredentials:  u.FsConfig.GCSConfig.AutomaticCredentials,
+			StorageClass:          u.FsConfig.GCSConfig.StorageClass,
+			KeyPrefix:             u.FsConfig.GCSConfig.KeyPrefix,
 		},
 		AzBlobConfig: vfs.AzBlobFsConfig{
 			Container:         u.FsConfig.AzBlobConfig.Container,
 			AccountName:       u.FsConfig.AzBlobConfig.AccountName,
 			AccountKey:        u.FsConfig.AzBlobConfig.AccountKey.Clone(),
+			AccountKeyFile:    u.FsConfig.AzBlobConfig.AccountKeyFile,
+			AccountKeyEnv:     u.FsConfig.AzBlobConfig.AccountKeyEnv,
 			Endpoint:          u.FsConfig.AzBlobConfig.Endpoint,
 			SASURL:            u.FsConfig.AzBlobConfig.SASURL,
 			KeyPrefix:         u.FsConfig.AzBlobConfig.KeyPrefix,
@@ -917,29 +1294,33 @@ func (u *User) getACopy() User {
 	}
 
 	return User{
-		ID:                u.ID,
-		Username:          u.Username,
-		Password:          u.Password,
-		PublicKeys:        pubKeys,
-		HomeDir:           u.HomeDir,
-		VirtualFolders:    virtualFolders,
-		UID:               u.UID,
-		GID:               u.GID,
-		MaxSessions:       u.MaxSessions,
-		QuotaSize:         u.QuotaSize,
-		QuotaFiles:        u.QuotaFiles,
-		Permissions:       permissions,
-		UsedQuotaSize:     u.UsedQuotaSize,
-		UsedQuotaFiles:    u.UsedQuotaFiles,
-		LastQuotaUpdate:   u.LastQuotaUpdate,
-		UploadBandwidth:   u.UploadBandwidth,
-		DownloadBandwidth: u.DownloadBandwidth,
-		Status:            u.Status,
-		ExpirationDate:    u.ExpirationDate,
-		LastLogin:         u.LastLogin,
-		Filters:           filters,
-		FsConfig:          fsConfig,
-		AdditionalInfo:    u.AdditionalInfo,
+		ID:                       u.ID,
+		Username:                 u.Username,
+		Password:                 u.Password,
+		PublicKeys:               pubKeys,
+		HomeDir:                  u.HomeDir,
+		VirtualFolders:           virtualFolders,
+		UID:                      u.UID,
+		GID:                      u.GID,
+		MaxSessions:              u.MaxSessions,
+		QuotaSize:                u.QuotaSize,
+		QuotaFiles:               u.QuotaFiles,
+		Permissions:              permissions,
+		UsedQuotaSize:            u.UsedQuotaSize,
+		UsedQuotaFiles:           u.UsedQuotaFiles,
+		LastQuotaUpdate:          u.LastQuotaUpdate,
+		UsedUploadDataTransfer:   u.UsedUploadDataTransfer,
+		UsedDownloadDataTransfer: u.UsedDownloadDataTransfer,
+		DataTransferPeriodStart:  u.DataTransferPeriodStart,
+		UploadBandwidth:          u.UploadBandwidth,
+		DownloadBandwidth:        u.DownloadBandwidth,
+		Status:                   u.Status,
+		ExpirationDate:           u.ExpirationDate,
+		LastLogin:                u.LastLogin,
+		LastLoginProtocol:        u.LastLoginProtocol,
+		Filters:                  filters,
+		FsConfig:                 fsConfig,
+		AdditionalInfo:           u.AdditionalInfo,
 	}
 }
 
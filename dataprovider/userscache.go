@@ -0,0 +1,173 @@
+package dataprovider
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+var cachedUsers = &usersCache{
+	users: make(map[string]cachedUser),
+}
+
+// UsersCacheConfig defines the cache configuration for the users used to authenticate
+type UsersCacheConfig struct {
+	// Cache entries expiration time, in seconds. 0 means the cache is disabled
+	ExpirationTime int `json:"expiration_time" mapstructure:"expiration_time"`
+	// Maximum number of users to cache. 0 means unlimited
+	MaxSize int `json:"max_size" mapstructure:"max_size"`
+}
+
+func (c *UsersCacheConfig) isEnabled() bool {
+	return c.ExpirationTime > 0
+}
+
+type cachedUser struct {
+	user       User
+	expiration time.Time
+}
+
+func (c *cachedUser) isExpired() bool {
+	return c.expiration.Before(time.Now())
+}
+
+// usersCache caches the users used to authenticate so we can avoid to query
+// the data provider for each login
+type usersCache struct {
+	sync.RWMutex
+	users map[string]cachedUser
+}
+
+func (c *usersCache) get(username string) (User, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	cached, ok := c.users[username]
+	if !ok || cached.isExpired() {
+		return User{}, false
+	}
+	return cached.user.getACopy(), true
+}
+
+func (c *usersCache) add(user *User) {
+	if user.Username == "" {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.users[user.Username]; !ok && config.UsersCache.MaxSize > 0 && len(c.users) >= config.UsersCache.MaxSize {
+		c.removeExpiringFirst()
+	}
+	c.users[user.Username] = cachedUser{
+		user:       user.getACopy(),
+		expiration: time.Now().Add(time.Duration(config.UsersCache.ExpirationTime) * time.Second),
+	}
+}
+
+// removeExpiringFirst removes the cached user expiring first, it must be called
+// with the lock held
+func (c *usersCache) removeExpiringFirst() {
+	var userToRemove string
+	var expirationTime time.Time
+
+	for username, cached := range c.users {
+		if userToRemove == "" || cached.expiration.Before(expirationTime) {
+			userToRemove = username
+			expirationTime = cached.expiration
+		}
+	}
+	if userToRemove != "" {
+		delete(c.users, userToRemove)
+	}
+}
+
+func (c *usersCache) updateLastLogin(username, protocol string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if cached, ok := c.users[username]; ok {
+		cached.user.LastLogin = utils.GetTimeAsMsSinceEpoch(time.Now())
+		cached.user.LastLoginProtocol = protocol
+		c.users[username] = cached
+	}
+}
+
+func (c *usersCache) remove(username string) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.users, username)
+}
+
+func (c *usersCache) clear() int {
+	c.Lock()
+	defer c.Unlock()
+
+	count := len(c.users)
+	c.users = make(map[string]cachedUser)
+	return count
+}
+
+// getUserForAuth returns the user with the given username from the cache, if enabled,
+// or from the data provider
+func getUserForAuth(username string) (User, error) {
+	if !config.UsersCache.isEnabled() {
+		return provider.userExists(username)
+	}
+	if user, ok := cachedUsers.get(username); ok {
+		providerLog(logger.LevelDebug, "user %#v found in cache", username)
+		return user, nil
+	}
+	user, err := provider.userExists(username)
+	if err != nil {
+		return user, err
+	}
+	cachedUsers.add(&user)
+	return user, nil
+}
+
+func validateCachedUserAndPass(username, password, ip, protocol string) (User, error) {
+	var user User
+	if password == "" {
+		return user, errors.New("Credentials cannot be null or empty")
+	}
+	user, err := getUserForAuth(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %#v: %v", username, err)
+		return user, err
+	}
+	return checkUserAndPass(&user, password, ip, protocol)
+}
+
+func validateCachedUserAndPubKey(username string, pubKey []byte) (User, string, error) {
+	var user User
+	if len(pubKey) == 0 {
+		return user, "", errors.New("Credentials cannot be null or empty")
+	}
+	user, err := getUserForAuth(username)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error authenticating user %#v: %v", username, err)
+		return user, "", err
+	}
+	return checkUserAndPubKey(&user, pubKey)
+}
+
+// RemoveCachedUser removes the user with the given username from the users cache
+func RemoveCachedUser(username string) {
+	cachedUsers.remove(convertUsername(username))
+	RemoveCachedWebDAVUser(username)
+}
+
+// ClearUsersCache removes all the cached users and returns the number of removed entries
+func ClearUsersCache() int {
+	webDAVUsersCache.Range(func(k, v interface{}) bool {
+		webDAVUsersCache.Delete(k)
+		return true
+	})
+	return cachedUsers.clear()
+}
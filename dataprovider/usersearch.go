@@ -0,0 +1,171 @@
+package dataprovider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// Supported fields to sort the users search results
+const (
+	UserSortFieldUsername       = "username"
+	UserSortFieldLastLogin      = "last_login"
+	UserSortFieldExpirationDate = "expiration_date"
+	UserSortFieldUsedQuotaSize  = "used_quota_size"
+)
+
+// ValidUserSortFields defines the supported fields to sort the users search results
+var ValidUserSortFields = []string{UserSortFieldUsername, UserSortFieldLastLogin, UserSortFieldExpirationDate,
+	UserSortFieldUsedQuotaSize}
+
+// UserSearchFilters defines the filters to search the users.
+// Empty values are ignored, use NewUserSearchFilters to get filters matching any user
+type UserSearchFilters struct {
+	// only the users with these usernames
+	Usernames []string
+	// only the users belonging to at least one of these groups
+	Groups []string
+	// only the users belonging to this group
+	Group string
+	// -1 means any status
+	Status int
+	// -1 means any filesystem provider
+	FsProvider int
+	// only the users that have not logged in for the specified number of days
+	InactiveDays int
+	// case insensitive substring to search inside the username
+	Search string
+	// field to sort the users by, users with the same value are sorted by username.
+	// Default: username
+	SortField string
+}
+
+// NewUserSearchFilters returns filters matching any user
+func NewUserSearchFilters() UserSearchFilters {
+	return UserSearchFilters{
+		Status:     -1,
+		FsProvider: -1,
+	}
+}
+
+// IsEmpty returns true if the filters match any user
+func (f *UserSearchFilters) IsEmpty() bool {
+	return len(f.Usernames) == 0 && len(f.Groups) == 0 && f.Group == "" && f.Status < 0 && f.FsProvider < 0 &&
+		f.InactiveDays <= 0 && f.Search == ""
+}
+
+// Validate returns an error if the filters are not valid
+func (f *UserSearchFilters) Validate() error {
+	if f.SortField == "" {
+		f.SortField = UserSortFieldUsername
+	}
+	if !utils.IsStringInSlice(f.SortField, ValidUserSortFields) {
+		return &ValidationError{err: fmt.Sprintf("invalid sort field %#v", f.SortField)}
+	}
+	if f.InactiveDays < 0 {
+		return &ValidationError{err: fmt.Sprintf("invalid inactive days: %v", f.InactiveDays)}
+	}
+	f.Search = strings.ToLower(strings.TrimSpace(f.Search))
+	return nil
+}
+
+// getInactiveBefore returns the last login time, as unix timestamp in milliseconds,
+// before which a user is considered inactive
+func (f *UserSearchFilters) getInactiveBefore() int64 {
+	return utils.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Duration(f.InactiveDays) * 24 * time.Hour))
+}
+
+func (f *UserSearchFilters) isSortedByUsername() bool {
+	return f.SortField == "" || f.SortField == UserSortFieldUsername
+}
+
+func (f *UserSearchFilters) match(user *User) bool {
+	if len(f.Usernames) > 0 && !utils.IsStringInSlice(user.Username, f.Usernames) {
+		return false
+	}
+	if len(f.Groups) > 0 && !user.IsInGroups(f.Groups) {
+		return false
+	}
+	if f.Group != "" && !user.IsInGroups([]string{f.Group}) {
+		return false
+	}
+	if f.Status >= 0 && user.Status != f.Status {
+		return false
+	}
+	if f.FsProvider >= 0 && int(user.FsConfig.Provider) != f.FsProvider {
+		return false
+	}
+	if f.InactiveDays > 0 && !user.IsInactive(f.InactiveDays) {
+		return false
+	}
+	if f.Search != "" && !strings.Contains(strings.ToLower(user.Username), f.Search) {
+		return false
+	}
+	return true
+}
+
+// less returns true if the first user must be sorted before the second one
+// in ascending order
+func (f *UserSearchFilters) less(u1, u2 *User) bool {
+	var v1, v2 int64
+	switch f.SortField {
+	case UserSortFieldLastLogin:
+		v1, v2 = u1.LastLogin, u2.LastLogin
+	case UserSortFieldExpirationDate:
+		v1, v2 = u1.ExpirationDate, u2.ExpirationDate
+	case UserSortFieldUsedQuotaSize:
+		v1, v2 = u1.UsedQuotaSize, u2.UsedQuotaSize
+	}
+	if v1 != v2 {
+		return v1 < v2
+	}
+	return u1.Username < u2.Username
+}
+
+// sortAndPaginateUsers sorts the given users, matching the filters, and returns
+// the requested page. It is used by the providers that cannot sort by the
+// requested field while iterating the users
+func sortAndPaginateUsers(users []User, filters *UserSearchFilters, limit, offset int, order string) []User {
+	sort.Slice(users, func(i, j int) bool {
+		if order == OrderDESC {
+			return filters.less(&users[j], &users[i])
+		}
+		return filters.less(&users[i], &users[j])
+	})
+	if offset >= len(users) {
+		return []User{}
+	}
+	users = users[offset:]
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users
+}
+
+// getUserGroupsSearchValue returns the value to store in the user_groups column.
+// The groups are enclosed in commas so a group can be searched matching ",group,"
+func getUserGroupsSearchValue(user *User) string {
+	if len(user.Filters.Groups) == 0 {
+		return ""
+	}
+	return "," + strings.Join(user.Filters.Groups, ",") + ","
+}
+
+// escapeSQLLikePattern escapes the LIKE wildcards in the given value using "!" as escape character
+func escapeSQLLikePattern(value string) string {
+	value = strings.ReplaceAll(value, "!", "!!")
+	value = strings.ReplaceAll(value, "%", "!%")
+	return strings.ReplaceAll(value, "_", "!_")
+}
+
+// SearchUsers returns the users matching the given filters respecting limit and offset.
+// The users are sorted by the field defined in the filters
+func SearchUsers(filters UserSearchFilters, limit, offset int, order string) ([]User, error) {
+	if err := filters.Validate(); err != nil {
+		return nil, err
+	}
+	return provider.searchUsers(filters, limit, offset, order)
+}
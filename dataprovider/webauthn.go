@@ -0,0 +1,100 @@
+package dataprovider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// WebAuthnCredential defines a FIDO2/WebAuthn credential, for example a security key,
+// registered for an admin
+type WebAuthnCredential struct {
+	// user defined name to identify the credential
+	Name string `json:"name"`
+	// credential ID as returned by the authenticator
+	ID []byte `json:"id"`
+	// COSE encoded public key
+	PublicKey []byte `json:"public_key"`
+	// attestation format
+	AttestationType string `json:"attestation_type,omitempty"`
+	// authenticator identifier
+	AAGUID []byte `json:"aaguid,omitempty"`
+	// signature counter, used to detect cloned authenticators
+	SignCount uint32 `json:"sign_count"`
+	// creation time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+	// last use time as unix timestamp in milliseconds
+	LastUseAt int64 `json:"last_use_at,omitempty"`
+}
+
+func (c *WebAuthnCredential) getACopy() WebAuthnCredential {
+	return WebAuthnCredential{
+		Name:            c.Name,
+		ID:              append([]byte(nil), c.ID...),
+		PublicKey:       append([]byte(nil), c.PublicKey...),
+		AttestationType: c.AttestationType,
+		AAGUID:          append([]byte(nil), c.AAGUID...),
+		SignCount:       c.SignCount,
+		CreatedAt:       c.CreatedAt,
+		LastUseAt:       c.LastUseAt,
+	}
+}
+
+// GetEncodedID returns the credential ID base64 URL encoded
+func (c *WebAuthnCredential) GetEncodedID() string {
+	return base64.RawURLEncoding.EncodeToString(c.ID)
+}
+
+// GetInfoString returns the creation and last use dates as string
+func (c *WebAuthnCredential) GetInfoString() string {
+	var result string
+	if c.CreatedAt > 0 {
+		t := utils.GetTimeFromMsecSinceEpoch(c.CreatedAt)
+		result += fmt.Sprintf("Registered: %v ", t.Format("2006-01-02 15:04:05")) // YYYY-MM-DD HH:MM:SS
+	}
+	if c.LastUseAt > 0 {
+		t := utils.GetTimeFromMsecSinceEpoch(c.LastUseAt)
+		result += fmt.Sprintf("Last use: %v ", t.Format("2006-01-02 15:04:05"))
+	}
+	return strings.TrimSpace(result)
+}
+
+func validateWebAuthnCredentials(credentials []WebAuthnCredential) error {
+	for idx := range credentials {
+		c := &credentials[idx]
+		c.Name = strings.TrimSpace(c.Name)
+		if c.Name == "" {
+			return &ValidationError{err: "WebAuthn credential name is mandatory"}
+		}
+		if len(c.ID) == 0 || len(c.PublicKey) == 0 {
+			return &ValidationError{err: fmt.Sprintf("invalid WebAuthn credential %#v", c.Name)}
+		}
+		for _, other := range credentials[:idx] {
+			if other.Name == c.Name {
+				return &ValidationError{err: fmt.Sprintf("duplicated WebAuthn credential name %#v", c.Name)}
+			}
+			if bytes.Equal(other.ID, c.ID) {
+				return &ValidationError{err: fmt.Sprintf("WebAuthn credential %#v is already registered", c.Name)}
+			}
+		}
+	}
+	return nil
+}
+
+// GetWebAuthnCredentialIndex returns the index of the credential with the given ID or -1
+func (a *Admin) GetWebAuthnCredentialIndex(id []byte) int {
+	for idx := range a.Filters.WebAuthnCredentials {
+		if bytes.Equal(a.Filters.WebAuthnCredentials[idx].ID, id) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// IsWebAuthnRequired returns true if the admin must login using a WebAuthn credential
+func (a *Admin) IsWebAuthnRequired() bool {
+	return len(a.Filters.WebAuthnCredentials) > 0
+}
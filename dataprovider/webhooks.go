@@ -0,0 +1,251 @@
+package dataprovider
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+
+	"github.com/drakkan/sftpgo/diagnostics"
+	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// WebhookSignatureHeader is the HTTP header containing the hex encoded HMAC-SHA256
+// of the webhook payload, computed using the configured secret
+const WebhookSignatureHeader = "X-SFTPGo-Signature"
+
+var (
+	webhookActions     = []string{AuditActionAdd, AuditActionUpdate, AuditActionDelete}
+	webhookObjectTypes = []string{AuditObjectUser, AuditObjectFolder, AuditObjectAdmin}
+)
+
+// WebhookConfig defines an HTTP endpoint notified about the users, folders and
+// admins changes
+type WebhookConfig struct {
+	// HTTP URL to notify
+	URL string `json:"url" mapstructure:"url"`
+	// Actions to notify, valid values are add, update, delete. Empty means all
+	ExecuteOn []string `json:"execute_on" mapstructure:"execute_on"`
+	// Object types to notify, valid values are user, folder, admin. Empty means all
+	ObjectTypes []string `json:"object_types" mapstructure:"object_types"`
+	// Optional secret used to sign the payload. The signature is sent
+	// in the WebhookSignatureHeader
+	Secret string `json:"secret" mapstructure:"secret"`
+}
+
+func (c *WebhookConfig) validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %#v", c.URL)
+	}
+	if len(c.ExecuteOn) == 0 {
+		c.ExecuteOn = append(c.ExecuteOn, webhookActions...)
+	}
+	for _, action := range c.ExecuteOn {
+		if !utils.IsStringInSlice(action, webhookActions) {
+			return fmt.Errorf("invalid webhook action %#v", action)
+		}
+	}
+	if len(c.ObjectTypes) == 0 {
+		c.ObjectTypes = append(c.ObjectTypes, webhookObjectTypes...)
+	}
+	for _, objectType := range c.ObjectTypes {
+		if !utils.IsStringInSlice(objectType, webhookObjectTypes) {
+			return fmt.Errorf("invalid webhook object type %#v", objectType)
+		}
+	}
+	return nil
+}
+
+func (c *WebhookConfig) isApplicable(action, objectType string) bool {
+	return utils.IsStringInSlice(action, c.ExecuteOn) && utils.IsStringInSlice(objectType, c.ObjectTypes)
+}
+
+func (c *WebhookConfig) send(payload []byte) error {
+	req, err := retryablehttp.NewRequest(http.MethodPost, c.URL, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(c.Secret))
+		mac.Write(payload) //nolint:errcheck // writing to a hash never returns an error
+		req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := httpclient.GetRetraybleHTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code: %v", resp.StatusCode)
+	}
+	return nil
+}
+
+// WebhookFieldChange defines the old and the new value for a changed field
+type WebhookFieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// WebhookEvent defines the payload sent to the webhooks
+type WebhookEvent struct {
+	Action     string `json:"action"`
+	ObjectType string `json:"object_type"`
+	ObjectName string `json:"object_name"`
+	// event time as unix timestamp in milliseconds
+	Timestamp int64 `json:"timestamp"`
+	// the added or updated object or, for deletes, the deleted one.
+	// The confidential data are hidden
+	Object map[string]interface{} `json:"object,omitempty"`
+	// changed fields, available for updates only
+	Diff map[string]WebhookFieldChange `json:"diff,omitempty"`
+}
+
+func validateWebhooks(c *Config) error {
+	for idx := range c.Webhooks {
+		if err := c.Webhooks[idx].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getWebhooks(action, objectType string) []WebhookConfig {
+	var webhooks []WebhookConfig
+	for _, webhook := range config.Webhooks {
+		if webhook.isApplicable(action, objectType) {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks
+}
+
+// getWebhookObject returns the given object, with the confidential data hidden,
+// as a generic map, so it can be compared with another version of the same object
+func getWebhookObject(objectType, objectName string) (map[string]interface{}, error) {
+	var object interface{}
+	switch objectType {
+	case AuditObjectUser:
+		user, err := provider.userExists(objectName)
+		if err != nil {
+			return nil, err
+		}
+		user.HideConfidentialData()
+		object = user
+	case AuditObjectFolder:
+		folder, err := provider.getFolderByName(objectName)
+		if err != nil {
+			return nil, err
+		}
+		object = folder
+	case AuditObjectAdmin:
+		admin, err := provider.adminExists(objectName)
+		if err != nil {
+			return nil, err
+		}
+		admin.HideConfidentialData()
+		object = admin
+	default:
+		return nil, fmt.Errorf("unsupported webhook object type %#v", objectType)
+	}
+	asJSON, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	err = json.Unmarshal(asJSON, &result)
+	return result, err
+}
+
+// getWebhookPreviousObject returns the current version of the given object if
+// there are webhooks to notify for the given action, it must be called before
+// updating or deleting the object
+func getWebhookPreviousObject(action, objectType, objectName string) map[string]interface{} {
+	if len(getWebhooks(action, objectType)) == 0 {
+		return nil
+	}
+	object, err := getWebhookObject(objectType, objectName)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get the %v %#v to notify for action %#v: %v", objectType,
+			objectName, action, err)
+		return nil
+	}
+	return object
+}
+
+func getObjectDiff(previous, current map[string]interface{}) map[string]WebhookFieldChange {
+	diff := make(map[string]WebhookFieldChange)
+	for k, v := range current {
+		if old, ok := previous[k]; !ok || !reflect.DeepEqual(old, v) {
+			diff[k] = WebhookFieldChange{Old: previous[k], New: v}
+		}
+	}
+	for k, v := range previous {
+		if _, ok := current[k]; !ok {
+			diff[k] = WebhookFieldChange{Old: v, New: nil}
+		}
+	}
+	return diff
+}
+
+// executeWebhooks notifies the configured webhooks about an object change.
+// For updates and deletes previous is the object before the change, as returned
+// by getWebhookPreviousObject. Updates without changes are not notified.
+// The changed object is read synchronously, so the notification matches the change,
+// while the webhooks are notified in the background
+func executeWebhooks(action, objectType, objectName string, previous map[string]interface{}) {
+	webhooks := getWebhooks(action, objectType)
+	if len(webhooks) == 0 {
+		return
+	}
+	event := WebhookEvent{
+		Action:     action,
+		ObjectType: objectType,
+		ObjectName: objectName,
+		Timestamp:  utils.GetTimeAsMsSinceEpoch(time.Now()),
+		Object:     previous,
+	}
+	if action != AuditActionDelete {
+		object, err := getWebhookObject(objectType, objectName)
+		if err != nil {
+			providerLog(logger.LevelWarn, "unable to get the %v %#v to notify for action %#v: %v", objectType,
+				objectName, action, err)
+			return
+		}
+		event.Object = object
+		if action == AuditActionUpdate && previous != nil {
+			event.Diff = getObjectDiff(previous, object)
+			if len(event.Diff) == 0 {
+				providerLog(logger.LevelDebug, "no changes for %v %#v, webhooks not notified", objectType, objectName)
+				return
+			}
+		}
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to serialize webhook event for %v %#v: %v", objectType, objectName, err)
+		return
+	}
+
+	go func() {
+		for idx := range webhooks {
+			startTime := time.Now()
+			err := webhooks[idx].send(payload)
+			diagnostics.HookExecuted(diagnostics.HookProviderWebhook, time.Since(startTime), err)
+			providerLog(logger.LevelDebug, "webhook %#v notified, action %#v, %v %#v, elapsed: %v, err: %v",
+				webhooks[idx].URL, action, objectType, objectName, time.Since(startTime), err)
+		}
+	}()
+}
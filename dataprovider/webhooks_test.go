@@ -0,0 +1,168 @@
+package dataprovider
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+func TestWebhookConfigValidation(t *testing.T) {
+	c := WebhookConfig{}
+	assert.Error(t, c.validate())
+	c.URL = "ftp://127.0.0.1"
+	assert.Error(t, c.validate())
+	c.URL = "http://127.0.0.1:8080/webhook"
+	assert.NoError(t, c.validate())
+	assert.Equal(t, webhookActions, c.ExecuteOn)
+	assert.Equal(t, webhookObjectTypes, c.ObjectTypes)
+	c.ExecuteOn = []string{"pre-delete"}
+	assert.Error(t, c.validate())
+	c.ExecuteOn = []string{AuditActionUpdate}
+	c.ObjectTypes = []string{AuditObjectShare}
+	assert.Error(t, c.validate())
+	c.ObjectTypes = []string{AuditObjectUser}
+	assert.NoError(t, c.validate())
+	assert.True(t, c.isApplicable(AuditActionUpdate, AuditObjectUser))
+	assert.False(t, c.isApplicable(AuditActionAdd, AuditObjectUser))
+	assert.False(t, c.isApplicable(AuditActionUpdate, AuditObjectAdmin))
+
+	cfg := Config{
+		Webhooks: []WebhookConfig{c, {}},
+	}
+	assert.Error(t, validateWebhooks(&cfg))
+}
+
+func TestObjectDiff(t *testing.T) {
+	previous := map[string]interface{}{
+		"status":      float64(1),
+		"permissions": map[string]interface{}{"/": []interface{}{"*"}},
+		"description": "desc",
+	}
+	current := map[string]interface{}{
+		"status":      float64(0),
+		"permissions": map[string]interface{}{"/": []interface{}{"*"}},
+		"email":       "user@example.com",
+	}
+	diff := getObjectDiff(previous, current)
+	require.Len(t, diff, 3)
+	assert.Equal(t, WebhookFieldChange{Old: float64(1), New: float64(0)}, diff["status"])
+	assert.Equal(t, WebhookFieldChange{Old: nil, New: "user@example.com"}, diff["email"])
+	assert.Equal(t, WebhookFieldChange{Old: "desc", New: nil}, diff["description"])
+	assert.Len(t, getObjectDiff(current, current), 0)
+}
+
+func TestWebhooks(t *testing.T) {
+	secret := "webhook secret"
+	events := make(chan WebhookEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body) //nolint:errcheck
+		assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), r.Header.Get(WebhookSignatureHeader))
+		var event WebhookEvent
+		assert.NoError(t, json.Unmarshal(body, &event))
+		events <- event
+	}))
+	defer server.Close()
+
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+	config.Webhooks = []WebhookConfig{
+		{
+			URL:         server.URL,
+			ObjectTypes: []string{AuditObjectUser, AuditObjectFolder},
+			Secret:      secret,
+		},
+	}
+	require.NoError(t, validateWebhooks(&config))
+
+	getEvent := func() WebhookEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("webhook not received")
+		}
+		return WebhookEvent{}
+	}
+
+	user := getTestUser("webhook_user")
+	err := AddUser(&user)
+	require.NoError(t, err)
+	event := getEvent()
+	assert.Equal(t, AuditActionAdd, event.Action)
+	assert.Equal(t, AuditObjectUser, event.ObjectType)
+	assert.Equal(t, user.Username, event.ObjectName)
+	assert.Greater(t, event.Timestamp, int64(0))
+	assert.Equal(t, user.Username, event.Object["username"])
+	assert.Empty(t, event.Object["password"])
+	assert.Len(t, event.Diff, 0)
+
+	user, err = UserExists(user.Username)
+	require.NoError(t, err)
+	// no changes, no notification
+	err = UpdateUser(&user)
+	require.NoError(t, err)
+	user.AdditionalInfo = "webhook info"
+	user.QuotaFiles = 100
+	err = UpdateUser(&user)
+	require.NoError(t, err)
+	event = getEvent()
+	assert.Equal(t, AuditActionUpdate, event.Action)
+	require.Len(t, event.Diff, 2)
+	assert.Equal(t, "webhook info", event.Diff["additional_info"].New)
+	assert.Equal(t, float64(100), event.Diff["quota_files"].New)
+	assert.Equal(t, float64(0), event.Diff["quota_files"].Old)
+
+	err = DeleteUser(user.Username)
+	require.NoError(t, err)
+	event = getEvent()
+	assert.Equal(t, AuditActionDelete, event.Action)
+	assert.Equal(t, "webhook info", event.Object["additional_info"])
+
+	folder := vfs.BaseVirtualFolder{
+		Name:       "webhook_folder",
+		MappedPath: "/tmp/webhook_folder",
+	}
+	err = AddFolder(&folder)
+	require.NoError(t, err)
+	event = getEvent()
+	assert.Equal(t, AuditActionAdd, event.Action)
+	assert.Equal(t, AuditObjectFolder, event.ObjectType)
+	assert.Equal(t, folder.Name, event.ObjectName)
+	err = DeleteFolder(folder.Name)
+	require.NoError(t, err)
+	event = getEvent()
+	assert.Equal(t, AuditActionDelete, event.Action)
+	assert.Equal(t, folder.MappedPath, event.Object["mapped_path"])
+	// admins are not notified
+	admin := Admin{
+		Username:    "webhook_admin",
+		Password:    "password",
+		Status:      1,
+		Permissions: []string{PermAdminAny},
+	}
+	err = AddAdmin(&admin)
+	require.NoError(t, err)
+	err = DeleteAdmin(admin.Username)
+	require.NoError(t, err)
+	select {
+	case event = <-events:
+		t.Errorf("unexpected webhook event: %+v", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,134 @@
+// Package diagnostics tracks the execution time and the failures for the external
+// hooks and the data provider operations. The same measurements are exposed as
+// Prometheus metrics, if enabled
+package diagnostics
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/metrics"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// Supported hooks
+const (
+	HookExternalAuth    = "external_auth"
+	HookPreLogin        = "pre_login"
+	HookPostLogin       = "post_login"
+	HookCheckPassword   = "check_password"
+	HookKeyboardAuth    = "keyboard_interactive"
+	HookProviderActions = "provider_actions"
+	HookProviderWebhook = "provider_webhook"
+	HookActions         = "actions"
+	HookPostConnect     = "post_connect"
+	HookSessionEnd      = "session_end"
+)
+
+var (
+	hooks              = newRegistry()
+	providerOperations = newRegistry()
+)
+
+// Stats defines the execution statistics for a hook or a data provider operation
+type Stats struct {
+	Name string `json:"name"`
+	// Number of executions
+	Count int64 `json:"count"`
+	// Number of failed executions
+	Errors int64 `json:"errors"`
+	// Execution times as milliseconds
+	TotalTime float64 `json:"total_ms"`
+	AvgTime   float64 `json:"avg_ms"`
+	MaxTime   float64 `json:"max_ms"`
+	LastTime  float64 `json:"last_ms"`
+	// Last error and its time as unix timestamp in milliseconds
+	LastError     string `json:"last_error,omitempty"`
+	LastErrorTime int64  `json:"last_error_time,omitempty"`
+}
+
+type registry struct {
+	sync.Mutex
+	stats map[string]*Stats
+}
+
+func newRegistry() *registry {
+	return &registry{
+		stats: make(map[string]*Stats),
+	}
+}
+
+func (r *registry) add(name string, elapsed time.Duration, err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	stats, ok := r.stats[name]
+	if !ok {
+		stats = &Stats{Name: name}
+		r.stats[name] = stats
+	}
+	ms := float64(elapsed) / float64(time.Millisecond)
+	stats.Count++
+	stats.TotalTime += ms
+	stats.AvgTime = stats.TotalTime / float64(stats.Count)
+	stats.LastTime = ms
+	if ms > stats.MaxTime {
+		stats.MaxTime = ms
+	}
+	if err != nil {
+		stats.Errors++
+		stats.LastError = err.Error()
+		stats.LastErrorTime = utils.GetTimeAsMsSinceEpoch(time.Now())
+	}
+}
+
+func (r *registry) get() []Stats {
+	r.Lock()
+	defer r.Unlock()
+
+	result := make([]Stats, 0, len(r.stats))
+	for _, stats := range r.stats {
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func (r *registry) reset() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.stats = make(map[string]*Stats)
+}
+
+// HookExecuted records the execution time and the result for the specified hook
+func HookExecuted(hook string, elapsed time.Duration, err error) {
+	hooks.add(hook, elapsed, err)
+	metrics.HookExecuted(hook, elapsed, err)
+}
+
+// ProviderOperationCompleted records the execution time and the result for the
+// specified data provider operation
+func ProviderOperationCompleted(operation string, elapsed time.Duration, err error) {
+	providerOperations.add(operation, elapsed, err)
+	metrics.ProviderOperationCompleted(operation, elapsed, err)
+}
+
+// GetHooksStats returns the execution statistics for the external hooks
+func GetHooksStats() []Stats {
+	return hooks.get()
+}
+
+// GetProviderStats returns the execution statistics for the data provider operations
+func GetProviderStats() []Stats {
+	return providerOperations.get()
+}
+
+// Reset clears the collected statistics. The Prometheus metrics are not affected
+func Reset() {
+	hooks.reset()
+	providerOperations.reset()
+}
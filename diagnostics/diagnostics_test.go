@@ -0,0 +1,46 @@
+package diagnostics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	Reset()
+	assert.Len(t, GetHooksStats(), 0)
+	assert.Len(t, GetProviderStats(), 0)
+
+	HookExecuted(HookPreLogin, 30*time.Millisecond, nil)
+	HookExecuted(HookPreLogin, 10*time.Millisecond, errors.New("hook error"))
+	HookExecuted(HookExternalAuth, 5*time.Millisecond, nil)
+	ProviderOperationCompleted("user_exists", time.Millisecond, nil)
+
+	stats := GetHooksStats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, HookExternalAuth, stats[0].Name)
+	assert.Equal(t, int64(1), stats[0].Count)
+	assert.Equal(t, int64(0), stats[0].Errors)
+	assert.Empty(t, stats[0].LastError)
+	assert.Equal(t, HookPreLogin, stats[1].Name)
+	assert.Equal(t, int64(2), stats[1].Count)
+	assert.Equal(t, int64(1), stats[1].Errors)
+	assert.InDelta(t, 40, stats[1].TotalTime, 0.001)
+	assert.InDelta(t, 20, stats[1].AvgTime, 0.001)
+	assert.InDelta(t, 30, stats[1].MaxTime, 0.001)
+	assert.InDelta(t, 10, stats[1].LastTime, 0.001)
+	assert.Equal(t, "hook error", stats[1].LastError)
+	assert.Greater(t, stats[1].LastErrorTime, int64(0))
+
+	stats = GetProviderStats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, "user_exists", stats[0].Name)
+	assert.InDelta(t, 1, stats[0].MaxTime, 0.001)
+
+	Reset()
+	assert.Len(t, GetHooksStats(), 0)
+	assert.Len(t, GetProviderStats(), 0)
+}
@@ -645,6 +645,172 @@ func TestDefender(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestLoginTempCredentials(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	tempUser, password, err := dataprovider.AddTempCredentials(user.Username, time.Hour, 1, "")
+	assert.NoError(t, err)
+	tempUser.Password = password
+	client, err := getFTPClient(tempUser, false)
+	if assert.NoError(t, err) {
+		err = checkBasicFTP(client)
+		assert.NoError(t, err)
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+	// the maximum number of uses is reached
+	_, err = getFTPClient(tempUser, false)
+	assert.Error(t, err)
+
+	_, err = httpdtest.RemoveUser(tempUser, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestLoginThrottling(t *testing.T) {
+	oldConfig := config.GetCommonConfig()
+
+	cfg := config.GetCommonConfig()
+	cfg.LoginThrottling.Delay = 200
+	cfg.LoginThrottling.MaxDelay = 400
+	cfg.LoginThrottling.MaxAttempts = 3
+	cfg.LoginThrottling.Period = 60
+
+	err := common.Initialize(cfg)
+	assert.NoError(t, err)
+
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	client, err := getFTPClient(user, false)
+	if assert.NoError(t, err) {
+		err = checkBasicFTP(client)
+		assert.NoError(t, err)
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+
+	user.Password = "wrong_pwd"
+	startTime := time.Now()
+	_, err = getFTPClient(user, false)
+	assert.Error(t, err)
+	assert.GreaterOrEqual(t, time.Since(startTime), 200*time.Millisecond)
+	startTime = time.Now()
+	_, err = getFTPClient(user, false)
+	assert.Error(t, err)
+	assert.GreaterOrEqual(t, time.Since(startTime), 400*time.Millisecond)
+	// the rate limit is reached, valid credentials are refused too
+	user.Password = defaultPassword
+	_, err = getFTPClient(user, false)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), common.ErrLoginRateLimited.Error())
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	err = common.Initialize(oldConfig)
+	assert.NoError(t, err)
+}
+
+func TestRateLimiter(t *testing.T) {
+	oldConfig := config.GetCommonConfig()
+
+	cfg := config.GetCommonConfig()
+	cfg.RateLimiters = []common.RateLimiterConfig{
+		{
+			Average:   1,
+			Period:    60000,
+			Burst:     2,
+			Type:      int(common.RateLimiterTypeGlobal),
+			Target:    int(common.RateLimiterTargetConnections),
+			Protocols: []string{common.ProtocolFTP},
+		},
+	}
+
+	err := common.Initialize(cfg)
+	assert.NoError(t, err)
+
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		client, err := getFTPClient(user, false)
+		if assert.NoError(t, err) {
+			err = checkBasicFTP(client)
+			assert.NoError(t, err)
+			err = client.Quit()
+			assert.NoError(t, err)
+		}
+	}
+	// the connection is refused before the authentication
+	_, err = getFTPClient(user, false)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "rate limit exceeded")
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	err = common.Initialize(oldConfig)
+	assert.NoError(t, err)
+}
+
+func TestIPLists(t *testing.T) {
+	oldConfig := config.GetCommonConfig()
+
+	denyListFile := filepath.Join(os.TempDir(), "ftpd_denylist.json")
+	hl := common.HostListFile{}
+	asJSON, err := json.Marshal(hl)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(denyListFile, asJSON, os.ModePerm)
+	assert.NoError(t, err)
+
+	cfg := config.GetCommonConfig()
+	cfg.IPLists.DenyListFile = denyListFile
+	err = common.Initialize(cfg)
+	assert.NoError(t, err)
+
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	client, err := getFTPClient(user, false)
+	if assert.NoError(t, err) {
+		err = checkBasicFTP(client)
+		assert.NoError(t, err)
+		// the existing sessions are not affected by a reload
+		hl.IPAddresses = []string{"127.0.0.1"}
+		asJSON, err = json.Marshal(hl)
+		assert.NoError(t, err)
+		err = ioutil.WriteFile(denyListFile, asJSON, os.ModePerm)
+		assert.NoError(t, err)
+		err = common.ReloadIPLists()
+		assert.NoError(t, err)
+		err = checkBasicFTP(client)
+		assert.NoError(t, err)
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+	_, err = getFTPClient(user, false)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "client IP not allowed")
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.Remove(denyListFile)
+	assert.NoError(t, err)
+
+	err = common.Initialize(oldConfig)
+	assert.NoError(t, err)
+}
+
 func TestMaxSessions(t *testing.T) {
 	u := getTestUser()
 	u.MaxSessions = 1
@@ -665,6 +831,39 @@ func TestMaxSessions(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestMaxSessionsPerProtocol(t *testing.T) {
+	u := getTestUser()
+	u.Filters.MaxSessionsPerProtocol = map[string]int{
+		common.ProtocolSSH: 1,
+		common.ProtocolFTP: 2,
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	client, err := getFTPClient(user, true)
+	if assert.NoError(t, err) {
+		err = checkBasicFTP(client)
+		assert.NoError(t, err)
+		// the SSH limit does not apply to FTP sessions
+		client1, err := getFTPClient(user, false)
+		if assert.NoError(t, err) {
+			err = checkBasicFTP(client1)
+			assert.NoError(t, err)
+			_, err = getFTPClient(user, false)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), "too many open FTP sessions")
+			}
+			err = client1.Quit()
+			assert.NoError(t, err)
+		}
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestZeroBytesTransfers(t *testing.T) {
 	u := getTestUser()
 	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
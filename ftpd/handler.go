@@ -26,6 +26,7 @@ var (
 type Connection struct {
 	*common.BaseConnection
 	clientContext ftpserver.ClientContext
+	listener      *controlListener
 }
 
 // GetClientVersion returns the connected client's version.
@@ -44,8 +45,15 @@ func (c *Connection) GetRemoteAddress() string {
 	return c.clientContext.RemoteAddr().String()
 }
 
-// Disconnect disconnects the client
+// Disconnect disconnects the client.
+// If the server is shutting down a 421 reply is sent to the client before closing
+// the connection. The reply cannot be sent on TLS connections, they are just closed
 func (c *Connection) Disconnect() error {
+	if common.IsShuttingDown() && c.listener != nil && !c.clientContext.HasTLSForControl() {
+		err := c.listener.sendReply(c.clientContext.RemoteAddr().String(), ftpserver.StatusServiceNotAvailable,
+			"Service not available, the server is shutting down")
+		c.Log(logger.LevelDebug, "shutdown reply sent, err: %v", err)
+	}
 	return c.clientContext.Close()
 }
 
@@ -345,14 +353,24 @@ func (c *Connection) downloadFile(fsPath, ftpPath string, offset int64) (ftpserv
 		return nil, c.GetPermissionDeniedError()
 	}
 
+	if err := c.CheckTransfersLimit(); err != nil {
+		return nil, err
+	}
+
 	file, r, cancelFn, err := c.Fs.Open(fsPath, offset)
 	if err != nil {
 		c.Log(logger.LevelWarn, "could not open file %#v for reading: %+v", fsPath, err)
 		return nil, c.GetFsError(err)
 	}
 
-	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, fsPath, ftpPath, common.TransferDownload,
+	baseTransfer, err := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, fsPath, ftpPath, common.TransferDownload,
 		0, 0, 0, false, c.Fs)
+	if err != nil {
+		return nil, err
+	}
+	if expectedSize := baseTransfer.GetExpectedSize(); offset > 0 && expectedSize > offset {
+		baseTransfer.SetExpectedSize(expectedSize - offset)
+	}
 	t := newTransfer(baseTransfer, nil, r, offset)
 
 	return t, nil
@@ -364,6 +382,10 @@ func (c *Connection) uploadFile(fsPath, ftpPath string, flags int) (ftpserver.Fi
 		return nil, c.GetPermissionDeniedError()
 	}
 
+	if err := c.CheckTransfersLimit(); err != nil {
+		return nil, err
+	}
+
 	filePath := fsPath
 	if common.Config.IsAtomicUploadEnabled() && c.Fs.IsAtomicUploadSupported() {
 		filePath = c.Fs.GetAtomicUploadPath(fsPath)
@@ -374,6 +396,12 @@ func (c *Connection) uploadFile(fsPath, ftpPath string, flags int) (ftpserver.Fi
 		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(ftpPath)) {
 			return nil, c.GetPermissionDeniedError()
 		}
+		if statErr != nil && flags&os.O_TRUNC == 0 {
+			// resume from the partial file kept for an interrupted upload, if any
+			if info, err := c.RestorePartialUpload(fsPath, ftpPath); err == nil {
+				return c.handleFTPUploadToExistingFile(flags, fsPath, filePath, info.Size(), ftpPath)
+			}
+		}
 		return c.handleFTPUploadToNewFile(fsPath, filePath, ftpPath)
 	}
 
@@ -401,6 +429,9 @@ func (c *Connection) handleFTPUploadToNewFile(resolvedPath, filePath, requestPat
 		c.Log(logger.LevelInfo, "denying file write due to quota limits")
 		return nil, common.ErrQuotaExceeded
 	}
+	if err := c.CheckDirectoryLimit(requestPath); err != nil {
+		return nil, err
+	}
 	file, w, cancelFn, err := c.Fs.Create(filePath, 0)
 	if err != nil {
 		c.Log(logger.LevelWarn, "error creating file %#v: %+v", resolvedPath, err)
@@ -412,8 +443,11 @@ func (c *Connection) handleFTPUploadToNewFile(resolvedPath, filePath, requestPat
 	// we can get an error only for resume
 	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, 0)
 
-	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
+	baseTransfer, err := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
 		common.TransferUpload, 0, 0, maxWriteSize, true, c.Fs)
+	if err != nil {
+		return nil, err
+	}
 	t := newTransfer(baseTransfer, w, nil, 0)
 
 	return t, nil
@@ -484,8 +518,11 @@ func (c *Connection) handleFTPUploadToExistingFile(flags int, resolvedPath, file
 
 	vfs.SetPathPermissions(c.Fs, filePath, c.User.GetUID(), c.User.GetGID())
 
-	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
+	baseTransfer, err := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
 		common.TransferUpload, minWriteOffset, initialSize, maxWriteSize, false, c.Fs)
+	if err != nil {
+		return nil, err
+	}
 	t := newTransfer(baseTransfer, w, nil, 0)
 
 	return t, nil
@@ -13,7 +13,9 @@ import (
 	"time"
 
 	"github.com/eikenb/pipeat"
+	ftpserver "github.com/fclairamb/ftpserverlib"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/dataprovider"
@@ -406,7 +408,7 @@ func TestServerGetSettings(t *testing.T) {
 	oldConfig := common.Config
 
 	binding := Binding{
-		Port:             2121,
+		Port:             2127,
 		ApplyProxyConfig: true,
 	}
 	c := &Configuration{
@@ -422,6 +424,13 @@ func TestServerGetSettings(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 10000, settings.PassiveTransferPortRange.Start)
 	assert.Equal(t, 11000, settings.PassiveTransferPortRange.End)
+	err = settings.Listener.Close()
+	assert.NoError(t, err)
+	// the port is already in use
+	server.binding.Port = 2121
+	_, err = server.GetSettings()
+	assert.Error(t, err)
+	server.binding.Port = 2127
 
 	common.Config.ProxyProtocol = 1
 	common.Config.ProxyAllowed = []string{"invalid"}
@@ -705,8 +714,9 @@ func TestTransferErrors(t *testing.T) {
 		BaseConnection: common.NewBaseConnection(connID, common.ProtocolFTP, user, fs),
 		clientContext:  mockCC,
 	}
-	baseTransfer := common.NewBaseTransfer(file, connection.BaseConnection, nil, file.Name(), testfile, common.TransferDownload,
+	baseTransfer, err := common.NewBaseTransfer(file, connection.BaseConnection, nil, file.Name(), testfile, common.TransferDownload,
 		0, 0, 0, false, fs)
+	require.NoError(t, err)
 	tr := newTransfer(baseTransfer, nil, nil, 0)
 	err = tr.Close()
 	assert.NoError(t, err)
@@ -723,8 +733,9 @@ func TestTransferErrors(t *testing.T) {
 
 	r, _, err := pipeat.Pipe()
 	assert.NoError(t, err)
-	baseTransfer = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testfile, testfile,
+	baseTransfer, err = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testfile, testfile,
 		common.TransferUpload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	tr = newTransfer(baseTransfer, nil, r, 10)
 	pos, err := tr.Seek(10, 0)
 	assert.NoError(t, err)
@@ -735,8 +746,9 @@ func TestTransferErrors(t *testing.T) {
 	r, w, err := pipeat.Pipe()
 	assert.NoError(t, err)
 	pipeWriter := vfs.NewPipeWriter(w)
-	baseTransfer = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testfile, testfile,
+	baseTransfer, err = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testfile, testfile,
 		common.TransferUpload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	tr = newTransfer(baseTransfer, pipeWriter, nil, 0)
 
 	err = r.Close()
@@ -818,3 +830,30 @@ func TestVerifyTLSConnection(t *testing.T) {
 
 	certMgr = oldCertMgr
 }
+
+func TestControlListenerReply(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := newControlListener(l)
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	assert.Len(t, listener.conns, 1)
+
+	err = listener.sendReply(client.LocalAddr().String(), ftpserver.StatusServiceNotAvailable, "shutting down")
+	assert.NoError(t, err)
+	buf := make([]byte, 64)
+	n, err := client.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "421 shutting down\r\n", string(buf[:n]))
+
+	err = conn.Close()
+	assert.NoError(t, err)
+	assert.Len(t, listener.conns, 0)
+	err = listener.sendReply(client.LocalAddr().String(), ftpserver.StatusServiceNotAvailable, "shutting down")
+	assert.ErrorIs(t, err, errControlConnNotFound)
+}
@@ -0,0 +1,96 @@
+package ftpd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const replyWriteTimeout = 2 * time.Second
+
+var errControlConnNotFound = errors.New("control connection not found")
+
+// controlListener wraps the FTP listener and keeps track of the accepted control
+// connections, this way we can send a reply to the clients before closing them,
+// ftpserverlib does not allow to write to the control connection
+type controlListener struct {
+	net.Listener
+	sync.Mutex
+	conns map[*controlConn]bool
+}
+
+func newControlListener(listener net.Listener) *controlListener {
+	return &controlListener{
+		Listener: listener,
+		conns:    make(map[*controlConn]bool),
+	}
+}
+
+// Accept implements net.Listener
+func (l *controlListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	c := &controlConn{
+		Conn:     conn,
+		listener: l,
+	}
+	l.Lock()
+	l.conns[c] = true
+	l.Unlock()
+	return c, nil
+}
+
+// getConn returns the control connection for the given remote address. The remote
+// address is not used as key when the connection is accepted, for proxy protocol
+// connections it requires to read the proxy header
+func (l *controlListener) getConn(remoteAddr string) (*controlConn, error) {
+	l.Lock()
+	conns := make([]*controlConn, 0, len(l.conns))
+	for c := range l.conns {
+		conns = append(conns, c)
+	}
+	l.Unlock()
+
+	for _, c := range conns {
+		if c.RemoteAddr().String() == remoteAddr {
+			return c, nil
+		}
+	}
+	return nil, errControlConnNotFound
+}
+
+// sendReply writes the specified reply to the control connection for the given
+// remote address
+func (l *controlListener) sendReply(remoteAddr string, code int, message string) error {
+	conn, err := l.getConn(remoteAddr)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetWriteDeadline(time.Now().Add(replyWriteTimeout)); err != nil {
+		return err
+	}
+	_, err = conn.Write([]byte(fmt.Sprintf("%d %s\r\n", code, message)))
+	return err
+}
+
+func (l *controlListener) remove(c *controlConn) {
+	l.Lock()
+	defer l.Unlock()
+
+	delete(l.conns, c)
+}
+
+type controlConn struct {
+	net.Conn
+	listener *controlListener
+}
+
+// Close implements net.Conn
+func (c *controlConn) Close() error {
+	c.listener.remove(c)
+	return c.Conn.Close()
+}
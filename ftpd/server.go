@@ -13,6 +13,7 @@ import (
 
 	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/geoip"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/metrics"
 	"github.com/drakkan/sftpgo/utils"
@@ -26,6 +27,7 @@ type Server struct {
 	initialMsg   string
 	statusBanner string
 	binding      Binding
+	listener     *controlListener
 }
 
 // NewServer returns a new FTP server driver
@@ -62,30 +64,42 @@ func (s *Server) GetSettings() (*ftpserver.Settings, error) {
 			End:   s.config.PassivePortRange.End,
 		}
 	}
-	var ftpListener net.Listener
+	if s.binding.TLSMode < 0 || s.binding.TLSMode > 2 {
+		return nil, errors.New("unsupported TLS mode")
+	}
+
+	if s.binding.TLSMode > 0 && certMgr == nil {
+		return nil, errors.New("to enable TLS you need to provide a certificate")
+	}
+
+	ftpListener, err := net.Listen("tcp", s.binding.GetAddress())
+	if err != nil {
+		logger.Warn(logSender, "", "error starting listener on address %v: %v", s.binding.GetAddress(), err)
+		return nil, err
+	}
 	if common.Config.ProxyProtocol > 0 && s.binding.ApplyProxyConfig {
-		listener, err := net.Listen("tcp", s.binding.GetAddress())
+		proxyListener, err := common.Config.GetProxyListener(ftpListener)
 		if err != nil {
-			logger.Warn(logSender, "", "error starting listener on address %v: %v", s.binding.GetAddress(), err)
+			ftpListener.Close()
+			logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
 			return nil, err
 		}
-		ftpListener, err = common.Config.GetProxyListener(listener)
+		ftpListener = proxyListener
+	}
+	// ftpserverlib enables implicit TLS only for the listeners it creates itself
+	if s.binding.TLSMode == 2 {
+		tlsConfig, err := s.GetTLSConfig()
 		if err != nil {
-			logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
+			ftpListener.Close()
+			logger.Warn(logSender, "", "unable to get TLS config for implicit TLS: %v", err)
 			return nil, err
 		}
+		ftpListener = tls.NewListener(ftpListener, tlsConfig)
 	}
-
-	if s.binding.TLSMode < 0 || s.binding.TLSMode > 2 {
-		return nil, errors.New("unsupported TLS mode")
-	}
-
-	if s.binding.TLSMode > 0 && certMgr == nil {
-		return nil, errors.New("to enable TLS you need to provide a certificate")
-	}
+	s.listener = newControlListener(ftpListener)
 
 	return &ftpserver.Settings{
-		Listener:                 ftpListener,
+		Listener:                 s.listener,
 		ListenAddr:               s.binding.GetAddress(),
 		PublicHost:               s.binding.ForcePassiveIP,
 		PassiveTransferPortRange: portRange,
@@ -105,10 +119,23 @@ func (s *Server) GetSettings() (*ftpserver.Settings, error) {
 // ClientConnected is called to send the very first welcome message
 func (s *Server) ClientConnected(cc ftpserver.ClientContext) (string, error) {
 	ipAddr := utils.GetIPFromRemoteAddress(cc.RemoteAddr().String())
+	if !common.IsIPAllowed(ipAddr) {
+		logger.Log(logger.LevelDebug, common.ProtocolFTP, "", "connection refused, ip %#v is not allowed", ipAddr)
+		return "Access denied, client IP not allowed", common.ErrConnectionDenied
+	}
 	if common.IsBanned(ipAddr) {
 		logger.Log(logger.LevelDebug, common.ProtocolFTP, "", "connection refused, ip %#v is banned", ipAddr)
+		s.tarpit(cc)
 		return "Access denied, banned client IP", common.ErrConnectionDenied
 	}
+	if err := common.LimitRate(common.RateLimiterTargetConnections, common.ProtocolFTP, ipAddr); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolFTP, "", "connection refused, ip %#v: %v", ipAddr, err)
+		return "Access denied, rate limit exceeded", err
+	}
+	if common.IsShuttingDown() {
+		logger.Log(logger.LevelDebug, common.ProtocolFTP, "", "connection refused, the server is shutting down")
+		return "Service not available, the server is shutting down", common.ErrConnectionDenied
+	}
 	if !common.Connections.IsNewConnectionAllowed() {
 		logger.Log(logger.LevelDebug, common.ProtocolFTP, "", "connection refused, configured limit reached")
 		return "", common.ErrConnectionDenied
@@ -121,11 +148,25 @@ func (s *Server) ClientConnected(cc ftpserver.ClientContext) (string, error) {
 	connection := &Connection{
 		BaseConnection: common.NewBaseConnection(connID, common.ProtocolFTP, user, nil),
 		clientContext:  cc,
+		listener:       s.listener,
 	}
 	common.Connections.Add(connection)
 	return s.initialMsg, nil
 }
 
+// tarpit keeps the control connection from a banned host open, if configured,
+// before ftpserverlib sends the refusal reply and closes it
+func (s *Server) tarpit(cc ftpserver.ClientContext) {
+	if s.listener == nil {
+		return
+	}
+	conn, err := s.listener.getConn(cc.RemoteAddr().String())
+	if err != nil {
+		return
+	}
+	common.Tarpit(conn, common.ProtocolFTP)
+}
+
 // ClientDisconnected is called when the user disconnects, even if he never authenticated
 func (s *Server) ClientDisconnected(cc ftpserver.ClientContext) {
 	connID := fmt.Sprintf("%v_%v_%v", common.ProtocolFTP, s.ID, cc.ID())
@@ -135,12 +176,21 @@ func (s *Server) ClientDisconnected(cc ftpserver.ClientContext) {
 // AuthUser authenticates the user and selects an handling driver
 func (s *Server) AuthUser(cc ftpserver.ClientContext, username, password string) (ftpserver.ClientDriver, error) {
 	ipAddr := utils.GetIPFromRemoteAddress(cc.RemoteAddr().String())
+	if err := common.CheckLoginAttempt(ipAddr, common.ProtocolFTP); err != nil {
+		user := dataprovider.User{Username: username}
+		updateLoginMetrics(&user, ipAddr, err)
+		return nil, err
+	}
 	user, err := dataprovider.CheckUserAndPass(username, password, ipAddr, common.ProtocolFTP)
 	if err != nil {
 		user.Username = username
 		updateLoginMetrics(&user, ipAddr, err)
 		return nil, err
 	}
+	if err = dataprovider.AddTempCredentialsUse(&user); err != nil {
+		updateLoginMetrics(&user, ipAddr, err)
+		return nil, err
+	}
 
 	connection, err := s.validateUser(user, cc)
 
@@ -150,9 +200,9 @@ func (s *Server) AuthUser(cc ftpserver.ClientContext, username, password string)
 		return nil, err
 	}
 	connection.Fs.CheckRootPath(connection.GetUsername(), user.GetUID(), user.GetGID())
-	connection.Log(logger.LevelInfo, "User id: %d, logged in with FTP, username: %#v, home_dir: %#v remote addr: %#v",
-		user.ID, user.Username, user.HomeDir, ipAddr)
-	dataprovider.UpdateLastLogin(&user) //nolint:errcheck
+	connection.Log(logger.LevelInfo, "User id: %d, logged in with FTP, username: %#v, home_dir: %#v remote addr: %#v "+
+		"country: %#v", user.ID, user.Username, user.HomeDir, ipAddr, geoip.GetCountry(ipAddr))
+	dataprovider.UpdateLastLogin(&user, common.ProtocolFTP) //nolint:errcheck
 	return connection, nil
 }
 
@@ -224,6 +274,14 @@ func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext
 			return nil, fmt.Errorf("too many open sessions: %v", activeSessions)
 		}
 	}
+	if maxSessions := user.GetMaxSessionsForProtocol(common.ProtocolFTP); maxSessions > 0 {
+		activeSessions := common.Connections.GetActiveProtocolSessions(user.Username, common.ProtocolFTP)
+		if activeSessions >= maxSessions {
+			logger.Debug(logSender, connectionID, "authentication refused for user: %#v, too many open FTP sessions: %v/%v", user.Username,
+				activeSessions, maxSessions)
+			return nil, fmt.Errorf("too many open FTP sessions: %v", activeSessions)
+		}
+	}
 	if dataprovider.GetQuotaTracking() > 0 && user.HasOverlappedMappedPaths() {
 		logger.Debug(logSender, connectionID, "cannot login user %#v, overlapping mapped folders are allowed only with quota tracking disabled",
 			user.Username)
@@ -241,7 +299,9 @@ func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext
 	connection := &Connection{
 		BaseConnection: common.NewBaseConnection(fmt.Sprintf("%v_%v", s.ID, cc.ID()), common.ProtocolFTP, user, fs),
 		clientContext:  cc,
+		listener:       s.listener,
 	}
+	connection.SetRemoteAddress(remoteAddr)
 	err = common.Connections.Swap(connection)
 	if err != nil {
 		return nil, errors.New("Internal authentication error")
@@ -252,13 +312,16 @@ func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext
 func updateLoginMetrics(user *dataprovider.User, ip string, err error) {
 	metrics.AddLoginAttempt(dataprovider.LoginMethodPassword)
 	if err != nil {
-		logger.ConnectionFailedLog(user.Username, ip, dataprovider.LoginMethodPassword,
+		logger.ConnectionFailedLog(user.Username, ip, geoip.GetCountry(ip), dataprovider.LoginMethodPassword,
 			common.ProtocolFTP, err.Error())
 		event := common.HostEventLoginFailed
 		if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
 			event = common.HostEventUserNotFound
 		}
 		common.AddDefenderEvent(ip, event)
+		common.DelayFailedLogin(ip, user.Username)
+	} else {
+		common.ResetFailedLogins(ip, user.Username)
 	}
 	metrics.AddLoginResult(dataprovider.LoginMethodPassword, err)
 	dataprovider.ExecutePostLoginHook(user, dataprovider.LoginMethodPassword, ip, common.ProtocolFTP, err)
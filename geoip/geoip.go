@@ -0,0 +1,224 @@
+// Package geoip provides country lookups for the client IP addresses using
+// MaxMind GeoIP2 or GeoLite2 databases.
+// The connections can be allowed or denied based on the client's country
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/geoip2-golang"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	logSender = "geoip"
+)
+
+var (
+	db = &database{}
+)
+
+// Config defines the GeoIP configuration
+type Config struct {
+	// Path to a MaxMind GeoIP2 or GeoLite2 Country or City database.
+	// The path can be absolute or relative to the configuration directory.
+	// Leave empty to disable the country lookups
+	DatabaseFile string `json:"database_file" mapstructure:"database_file"`
+	// ISO 3166-1 alpha-2 country codes allowed to connect.
+	// If set, the clients from other countries, or whose country cannot be
+	// determined, are refused
+	AllowedCountries []string `json:"allowed_countries" mapstructure:"allowed_countries"`
+	// ISO 3166-1 alpha-2 country codes not allowed to connect.
+	// The denied countries are evaluated before the allowed ones
+	DeniedCountries []string `json:"denied_countries" mapstructure:"denied_countries"`
+}
+
+// Initialize loads the configured database. The allowed and denied countries
+// require a database
+func (c *Config) Initialize(configDir string) error {
+	allowed, err := ValidateCountries(c.AllowedCountries)
+	if err != nil {
+		return fmt.Errorf("geoip: invalid allowed countries: %w", err)
+	}
+	denied, err := ValidateCountries(c.DeniedCountries)
+	if err != nil {
+		return fmt.Errorf("geoip: invalid denied countries: %w", err)
+	}
+	if c.DatabaseFile == "" {
+		if len(allowed) > 0 || len(denied) > 0 {
+			return errors.New("geoip: a database is required to allow or deny countries")
+		}
+		db.close()
+		return nil
+	}
+	if !utils.IsFileInputValid(c.DatabaseFile) {
+		return fmt.Errorf("geoip: invalid database file %#v", c.DatabaseFile)
+	}
+	dbPath := c.DatabaseFile
+	if !filepath.IsAbs(dbPath) {
+		dbPath = filepath.Join(configDir, dbPath)
+	}
+	return db.load(dbPath, allowed, denied)
+}
+
+type database struct {
+	sync.RWMutex
+	path    string
+	reader  *geoip2.Reader
+	allowed []string
+	denied  []string
+}
+
+func (d *database) load(dbPath string, allowed, denied []string) error {
+	reader, err := geoip2.Open(dbPath)
+	if err != nil {
+		return fmt.Errorf("geoip: unable to open database %#v: %w", dbPath, err)
+	}
+	if _, err := reader.Country(net.IPv4(127, 0, 0, 1)); err != nil && isUnsupportedDatabase(err) {
+		reader.Close()
+		return fmt.Errorf("geoip: unsupported database %#v: %w", dbPath, err)
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	if d.reader != nil {
+		d.reader.Close()
+	}
+	d.path = dbPath
+	d.reader = reader
+	d.allowed = allowed
+	d.denied = denied
+	logger.Info(logSender, "", "database %#v loaded, type: %#v, build epoch: %v", dbPath,
+		reader.Metadata().DatabaseType, reader.Metadata().BuildEpoch)
+	return nil
+}
+
+func (d *database) reload() error {
+	d.RLock()
+	dbPath := d.path
+	allowed := d.allowed
+	denied := d.denied
+	d.RUnlock()
+
+	if dbPath == "" {
+		return nil
+	}
+	return d.load(dbPath, allowed, denied)
+}
+
+func (d *database) close() {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.reader != nil {
+		d.reader.Close()
+	}
+	d.path = ""
+	d.reader = nil
+	d.allowed = nil
+	d.denied = nil
+}
+
+func (d *database) isEnabled() bool {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.reader != nil
+}
+
+func (d *database) getCountry(ip string) string {
+	d.RLock()
+	defer d.RUnlock()
+
+	if d.reader == nil {
+		return ""
+	}
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return ""
+	}
+	country, err := d.reader.Country(parsedIP)
+	if err != nil {
+		logger.Debug(logSender, "", "unable to get the country for ip %#v: %v", ip, err)
+		return ""
+	}
+	return country.Country.IsoCode
+}
+
+func (d *database) isAllowed(ip string) (bool, string) {
+	country := d.getCountry(ip)
+
+	d.RLock()
+	defer d.RUnlock()
+
+	return IsCountryAllowed(country, d.allowed, d.denied), country
+}
+
+func isUnsupportedDatabase(err error) bool {
+	var invalidMethodErr geoip2.InvalidMethodError
+	return errors.As(err, &invalidMethodErr)
+}
+
+// ValidateCountries checks the given ISO 3166-1 alpha-2 country codes and
+// returns them uppercase without duplicates
+func ValidateCountries(countries []string) ([]string, error) {
+	var result []string
+	for _, country := range countries {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if len(country) != 2 || country[0] < 'A' || country[0] > 'Z' || country[1] < 'A' || country[1] > 'Z' {
+			return nil, fmt.Errorf("invalid country code %#v", country)
+		}
+		if !utils.IsStringInSlice(country, result) {
+			result = append(result, country)
+		}
+	}
+	return result, nil
+}
+
+// IsCountryAllowed returns true if the specified country is allowed by the given
+// lists. An empty country, it cannot be determined, is allowed only if no allowed
+// countries are defined
+func IsCountryAllowed(country string, allowed, denied []string) bool {
+	if country != "" && utils.IsStringInSlice(country, denied) {
+		return false
+	}
+	if len(allowed) > 0 {
+		return country != "" && utils.IsStringInSlice(country, allowed)
+	}
+	return true
+}
+
+// IsEnabled returns true if a database is loaded
+func IsEnabled() bool {
+	return db.isEnabled()
+}
+
+// GetCountry returns the ISO 3166-1 alpha-2 country code for the given IP
+// address or an empty string if the country cannot be determined
+func GetCountry(ip string) string {
+	return db.getCountry(ip)
+}
+
+// IsIPAllowed returns false if the country for the given IP address is not
+// allowed by the global allowed and denied countries
+func IsIPAllowed(ip string) bool {
+	allowed, country := db.isAllowed(ip)
+	if !allowed {
+		logger.Debug(logSender, "", "ip %#v, country %#v, is not allowed", ip, country)
+	}
+	return allowed
+}
+
+// Reload loads the configured database again, this allows to update it without
+// restarting the service. The existing sessions are not affected
+func Reload() error {
+	return db.reload()
+}
@@ -0,0 +1,141 @@
+package geoip
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// GeoLite2-Country test database with these networks:
+	// 127.0.0.0/8 -> IT, 10.0.0.0/8 -> US, 192.168.1.0/24 -> FR
+	testDatabase = "AAABAAAOAAACAAAIAAADAAAlAAAEAAAlAAAlAAAFAAAGAAAlAAAlAAAHAAA1AAAlAAAlAAAJAAAlAAAKAAAlAAALAAAlAAAMAAAlAAANAAAlAABLAAAlAAAPAAAQAAAlAAARAAAlAAASAAAlAAATAAAlAAAUAAAlAAAVAAAlAAAlAAAWAAAXAAAlAAAlAAAYAAAZAAAlAAAlAAAaAAAbAAAlAAAcAAAlAAAdAAAlAAAeAAAlAAAfAAAlAAAgAAAlAAAhAAAlAAAiAAAlAAAjAAAlAAAkAAAlAAAlAABhAAAAAAAAAAAAAAAAAAAAAOFHY291bnRyeeFIaXNvX2NvZGVCVVPhR2NvdW50cnnhSGlzb19jb2RlQklU4Udjb3VudHJ54Uhpc29fY29kZUJGUqvN701heE1pbmQuY29t6VtiaW5hcnlfZm9ybWF0X21ham9yX3ZlcnNpb26hAltiaW5hcnlfZm9ybWF0X21pbm9yX3ZlcnNpb26gS2J1aWxkX2Vwb2NoBAJf7mYATWRhdGFiYXNlX3R5cGVQR2VvTGl0ZTItQ291bnRyeUtkZXNjcmlwdGlvbuFCZW5UU0ZUUEdvIHRlc3QgZGF0YWJhc2VKaXBfdmVyc2lvbqEESWxhbmd1YWdlcwEEQmVuSm5vZGVfY291bnTBJUtyZWNvcmRfc2l6ZaEY"
+)
+
+func writeTestDatabase(t *testing.T, dir string) string {
+	data, err := base64.StdEncoding.DecodeString(testDatabase)
+	require.NoError(t, err)
+	dbPath := filepath.Join(dir, "GeoLite2-Country.mmdb")
+	err = ioutil.WriteFile(dbPath, data, os.ModePerm)
+	require.NoError(t, err)
+	return dbPath
+}
+
+func TestInitialize(t *testing.T) {
+	configDir := t.TempDir()
+	defer db.close()
+
+	c := Config{}
+	err := c.Initialize(configDir)
+	assert.NoError(t, err)
+	assert.False(t, IsEnabled())
+	assert.Empty(t, GetCountry("127.0.0.1"))
+	assert.True(t, IsIPAllowed("127.0.0.1"))
+
+	c.AllowedCountries = []string{"IT"}
+	err = c.Initialize(configDir)
+	assert.Error(t, err)
+	c.AllowedCountries = []string{"ITA"}
+	err = c.Initialize(configDir)
+	assert.Error(t, err)
+	c.AllowedCountries = nil
+	c.DeniedCountries = []string{"1T"}
+	err = c.Initialize(configDir)
+	assert.Error(t, err)
+	c.DeniedCountries = nil
+	c.DatabaseFile = "."
+	err = c.Initialize(configDir)
+	assert.Error(t, err)
+	c.DatabaseFile = "missing.mmdb"
+	err = c.Initialize(configDir)
+	assert.Error(t, err)
+	assert.False(t, IsEnabled())
+
+	writeTestDatabase(t, configDir)
+	c.DatabaseFile = "GeoLite2-Country.mmdb"
+	err = c.Initialize(configDir)
+	require.NoError(t, err)
+	assert.True(t, IsEnabled())
+	assert.Equal(t, "IT", GetCountry("127.0.0.1"))
+	assert.Equal(t, "US", GetCountry("10.1.2.3"))
+	assert.Equal(t, "FR", GetCountry("192.168.1.5"))
+	assert.Empty(t, GetCountry("192.168.2.5"))
+	assert.Empty(t, GetCountry("invalid ip"))
+	assert.Empty(t, GetCountry("::1"))
+
+	c.DatabaseFile = ""
+	err = c.Initialize(configDir)
+	assert.NoError(t, err)
+	assert.False(t, IsEnabled())
+}
+
+func TestIPAllowed(t *testing.T) {
+	configDir := t.TempDir()
+	defer db.close()
+
+	c := Config{
+		DatabaseFile:     writeTestDatabase(t, configDir),
+		AllowedCountries: []string{"it", " us "},
+		DeniedCountries:  []string{"US"},
+	}
+	err := c.Initialize(configDir)
+	require.NoError(t, err)
+	assert.True(t, IsIPAllowed("127.0.0.1"))
+	assert.False(t, IsIPAllowed("10.0.0.1"))
+	assert.False(t, IsIPAllowed("192.168.1.1"))
+	// the country cannot be determined
+	assert.False(t, IsIPAllowed("172.16.0.1"))
+
+	c.AllowedCountries = nil
+	err = c.Initialize(configDir)
+	require.NoError(t, err)
+	assert.True(t, IsIPAllowed("127.0.0.1"))
+	assert.False(t, IsIPAllowed("10.0.0.1"))
+	assert.True(t, IsIPAllowed("192.168.1.1"))
+	assert.True(t, IsIPAllowed("172.16.0.1"))
+	// the database can be replaced and reloaded, the database is memory mapped
+	// so it must be replaced atomically as the MaxMind updater does
+	invalidPath := filepath.Join(configDir, "invalid.mmdb")
+	err = ioutil.WriteFile(invalidPath, []byte("invalid database"), os.ModePerm)
+	require.NoError(t, err)
+	err = os.Rename(invalidPath, c.DatabaseFile)
+	require.NoError(t, err)
+	err = Reload()
+	assert.Error(t, err)
+	// the previous database is still used
+	assert.Equal(t, "IT", GetCountry("127.0.0.1"))
+	err = os.Remove(c.DatabaseFile)
+	require.NoError(t, err)
+	writeTestDatabase(t, configDir)
+	err = Reload()
+	assert.NoError(t, err)
+	assert.False(t, IsIPAllowed("10.0.0.1"))
+
+	db.close()
+	assert.NoError(t, Reload())
+	assert.True(t, IsIPAllowed("10.0.0.1"))
+}
+
+func TestCountries(t *testing.T) {
+	countries, err := ValidateCountries([]string{"it", "IT", " Us"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"IT", "US"}, countries)
+	countries, err = ValidateCountries(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, countries)
+	_, err = ValidateCountries([]string{"IT", ""})
+	assert.Error(t, err)
+
+	assert.True(t, IsCountryAllowed("", nil, nil))
+	assert.True(t, IsCountryAllowed("", nil, []string{"IT"}))
+	assert.False(t, IsCountryAllowed("", []string{"IT"}, nil))
+	assert.True(t, IsCountryAllowed("IT", []string{"IT"}, nil))
+	assert.False(t, IsCountryAllowed("US", []string{"IT"}, nil))
+	assert.False(t, IsCountryAllowed("IT", []string{"IT"}, []string{"IT"}))
+	assert.True(t, IsCountryAllowed("US", nil, []string{"IT"}))
+}
@@ -58,6 +58,10 @@ func addAdmin(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
+	admin.Filters.TOTPConfig = dataprovider.TOTPConfig{}
+	admin.Filters.RecoveryCodes = nil
+	admin.Filters.WebAuthnCredentials = nil
+	admin.Filters.WebAuthnPasswordless = false
 	err = dataprovider.AddAdmin(&admin)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -76,11 +80,21 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	adminID := admin.ID
+	// two-factor authentication can only be configured by the admin itself
+	totpConfig := admin.Filters.TOTPConfig
+	recoveryCodes := admin.Filters.RecoveryCodes
+	webAuthnCredentials := admin.Filters.WebAuthnCredentials
+	webAuthnPasswordless := admin.Filters.WebAuthnPasswordless
+	admin.Filters.TOTPConfig = dataprovider.TOTPConfig{}
 	err = render.DecodeJSON(r.Body, &admin)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
+	admin.Filters.TOTPConfig = totpConfig
+	admin.Filters.RecoveryCodes = recoveryCodes
+	admin.Filters.WebAuthnCredentials = webAuthnCredentials
+	admin.Filters.WebAuthnPasswordless = webAuthnPasswordless
 
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
@@ -106,6 +120,24 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 	sendAPIResponse(w, r, nil, "Admin updated", http.StatusOK)
 }
 
+func disableAdmin2FA(w http.ResponseWriter, r *http.Request) {
+	username := getURLParam(r, "username")
+	admin, err := dataprovider.AdminExists(username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	admin.Filters.TOTPConfig = dataprovider.TOTPConfig{}
+	admin.Filters.RecoveryCodes = nil
+	admin.Filters.WebAuthnCredentials = nil
+	admin.Filters.WebAuthnPasswordless = false
+	if err := dataprovider.UpdateAdmin(&admin); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "2FA disabled", http.StatusOK)
+}
+
 func deleteAdmin(w http.ResponseWriter, r *http.Request) {
 	username := getURLParam(r, "username")
 	claims, err := getTokenClaims(r)
@@ -0,0 +1,311 @@
+package httpd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	bulkActionEnable  = "enable"
+	bulkActionDisable = "disable"
+	bulkActionDelete  = "delete"
+	bulkActionUpdate  = "update"
+)
+
+var (
+	bulkActions = []string{bulkActionEnable, bulkActionDisable, bulkActionDelete, bulkActionUpdate}
+	// user fields that cannot be changed using a bulk update
+	bulkUpdateDeniedFields = []string{"id", "username", "password", "used_quota_size", "used_quota_files",
+		"last_quota_update", "last_login", "last_login_protocol"}
+)
+
+// usersBulkFilters defines the users to apply a bulk action to, if no username is specified.
+// They are the same filters supported for the users list
+type usersBulkFilters struct {
+	InactiveDays int    `json:"inactive_days,omitempty"`
+	Status       *int   `json:"status,omitempty"`
+	Group        string `json:"group,omitempty"`
+	FsProvider   *int   `json:"fs_provider,omitempty"`
+	Search       string `json:"search,omitempty"`
+}
+
+func (f *usersBulkFilters) getSearchFilters() (dataprovider.UserSearchFilters, error) {
+	filters := dataprovider.NewUserSearchFilters()
+	if f.InactiveDays < 0 {
+		return filters, errors.New("invalid inactive_days")
+	}
+	filters.InactiveDays = f.InactiveDays
+	if f.Status != nil {
+		if *f.Status != 0 && *f.Status != 1 {
+			return filters, errors.New("invalid status")
+		}
+		filters.Status = *f.Status
+	}
+	if f.FsProvider != nil {
+		if *f.FsProvider < int(dataprovider.LocalFilesystemProvider) || *f.FsProvider > int(dataprovider.SFTPFilesystemProvider) {
+			return filters, errors.New("invalid fs_provider")
+		}
+		filters.FsProvider = *f.FsProvider
+	}
+	filters.Group = strings.TrimSpace(f.Group)
+	filters.Search = strings.TrimSpace(f.Search)
+	if filters.IsEmpty() {
+		return filters, errors.New("at least a filter is required")
+	}
+	return filters, filters.Validate()
+}
+
+// usersBulkRequest defines an action to apply to multiple users
+type usersBulkRequest struct {
+	Action string `json:"action"`
+	// the users to apply the action to, if empty the filters are used
+	Usernames []string          `json:"usernames,omitempty"`
+	Filters   *usersBulkFilters `json:"filters,omitempty"`
+	// partial user, the fields to change for the update action
+	Update json.RawMessage `json:"update,omitempty"`
+	// disconnect the updated users. Deleted users are always disconnected
+	Disconnect bool `json:"disconnect,omitempty"`
+}
+
+func (b *usersBulkRequest) validate(claims *jwtTokenClaims) (int, error) {
+	if !utils.IsStringInSlice(b.Action, bulkActions) {
+		return http.StatusBadRequest, fmt.Errorf("invalid action %#v", b.Action)
+	}
+	if len(b.Usernames) == 0 && b.Filters == nil {
+		return http.StatusBadRequest, errors.New("usernames or filters are required")
+	}
+	if len(b.Usernames) > 0 && b.Filters != nil {
+		return http.StatusBadRequest, errors.New("usernames and filters are mutually exclusive")
+	}
+	if b.Action == bulkActionUpdate {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(b.Update, &fields); err != nil || len(fields) == 0 {
+			return http.StatusBadRequest, errors.New("the update action requires the fields to change")
+		}
+		for field := range fields {
+			if utils.IsStringInSlice(field, bulkUpdateDeniedFields) {
+				return http.StatusBadRequest, fmt.Errorf("the field %#v cannot be changed using a bulk update", field)
+			}
+		}
+	}
+	if b.Action == bulkActionDelete {
+		if !claims.hasPerm(dataprovider.PermAdminDeleteUsers) {
+			return http.StatusForbidden, errors.New("the delete users permission is required")
+		}
+	} else if !claims.hasPerm(dataprovider.PermAdminChangeUsers) {
+		return http.StatusForbidden, errors.New("the edit users permission is required")
+	}
+	return http.StatusOK, nil
+}
+
+// usersBulkItemResult defines the result of a bulk action for a single user
+type usersBulkItemResult struct {
+	Username string `json:"username"`
+	// HTTP status code for this user
+	Status  int    `json:"status"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type usersBulkResult struct {
+	Action    string                `json:"action"`
+	Succeeded int                   `json:"succeeded"`
+	Failed    int                   `json:"failed"`
+	Results   []usersBulkItemResult `json:"results"`
+}
+
+func (r *usersBulkResult) add(username, message string, err error) {
+	item := usersBulkItemResult{
+		Username: username,
+		Status:   http.StatusOK,
+		Message:  message,
+	}
+	if err != nil {
+		item.Status = getRespStatus(err)
+		item.Message = ""
+		item.Error = err.Error()
+		if _, ok := err.(*bulkForbiddenError); ok {
+			item.Status = http.StatusForbidden
+		}
+		r.Failed++
+	} else {
+		r.Succeeded++
+	}
+	r.Results = append(r.Results, item)
+}
+
+type bulkForbiddenError struct {
+	err string
+}
+
+func (e *bulkForbiddenError) Error() string {
+	return e.err
+}
+
+func bulkUpdateUsers(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	var req usersBulkRequest
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if status, err := req.validate(&claims); err != nil {
+		sendAPIResponse(w, r, err, "", status)
+		return
+	}
+	result := usersBulkResult{
+		Action:  req.Action,
+		Results: []usersBulkItemResult{},
+	}
+	users, err := getBulkUsers(r, &req, &result)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	for idx := range users {
+		message, err := applyBulkAction(r, &req, &users[idx])
+		result.add(users[idx].Username, message, err)
+		if err == nil {
+			logger.Info(logSender, "", "bulk action %#v applied to user %#v by admin %#v", req.Action,
+				users[idx].Username, claims.Username)
+			if req.Action == bulkActionDelete || req.Disconnect {
+				disconnectUser(users[idx].Username)
+			}
+		}
+	}
+	render.JSON(w, r, result)
+}
+
+// getBulkUsers returns the users to apply the bulk action to. The users are all
+// loaded before applying the action so the changes cannot affect the filters matching.
+// The requested users that are not found or not within the admin scope are added to
+// the result as failed
+func getBulkUsers(r *http.Request, req *usersBulkRequest, result *usersBulkResult) ([]dataprovider.User, error) {
+	var users []dataprovider.User
+	if req.Filters != nil {
+		filters, err := req.Filters.getSearchFilters()
+		if err != nil {
+			return nil, dataprovider.NewValidationError(err.Error())
+		}
+		offset := 0
+		for {
+			batch, err := getUsersInAdminScope(r, defaultQueryLimit, offset, dataprovider.OrderASC, &filters)
+			if err != nil {
+				return nil, err
+			}
+			for idx := range batch {
+				// the users list has the confidential data hidden, we need the full users to update them
+				user, err := dataprovider.UserExists(batch[idx].Username)
+				if err != nil {
+					result.add(batch[idx].Username, "", err)
+					continue
+				}
+				users = append(users, user)
+			}
+			if len(batch) < defaultQueryLimit {
+				break
+			}
+			offset += len(batch)
+		}
+		return users, nil
+	}
+	for _, username := range utils.RemoveDuplicates(req.Usernames) {
+		user, err := dataprovider.UserExists(username)
+		if err != nil {
+			result.add(username, "", err)
+			continue
+		}
+		if !isUserInAdminScope(r, &user) {
+			result.add(username, "", &bulkForbiddenError{err: "the user must belong to at least one of your groups"})
+			continue
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func applyBulkAction(r *http.Request, req *usersBulkRequest, user *dataprovider.User) (string, error) {
+	switch req.Action {
+	case bulkActionEnable, bulkActionDisable:
+		status := 1
+		if req.Action == bulkActionDisable {
+			status = 0
+		}
+		if user.Status == status {
+			return "No changes", nil
+		}
+		user.Status = status
+		if err := dataprovider.UpdateUser(user); err != nil {
+			return "", err
+		}
+		return "User updated", nil
+	case bulkActionDelete:
+		if err := dataprovider.DeleteUser(user.Username); err != nil {
+			return "", err
+		}
+		return "User deleted", nil
+	default:
+		if err := applyBulkUserUpdate(user, req.Update); err != nil {
+			return "", err
+		}
+		// the update could move the user outside the admin scope
+		if !isUserInAdminScope(r, user) {
+			return "", &bulkForbiddenError{err: "the user must belong to at least one of your groups"}
+		}
+		if err := dataprovider.UpdateUser(user); err != nil {
+			return "", err
+		}
+		return "User updated", nil
+	}
+}
+
+// applyBulkUserUpdate sets the fields included in the given partial user to the specified user.
+// The fields not included are preserved, the permissions are replaced if included
+func applyBulkUserUpdate(user *dataprovider.User, update json.RawMessage) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(update, &fields); err != nil {
+		return dataprovider.NewValidationError(fmt.Sprintf("invalid update: %v", err))
+	}
+	userID := user.ID
+	username := user.Username
+	currentS3AccessSecret := user.FsConfig.S3Config.AccessSecret
+	currentAzAccountKey := user.FsConfig.AzBlobConfig.AccountKey
+	currentGCSCredentials := user.FsConfig.GCSConfig.Credentials
+	currentCryptoPassphrase := user.FsConfig.CryptConfig.Passphrase
+	currentSFTPPassword := user.FsConfig.SFTPConfig.Password
+	currentSFTPKey := user.FsConfig.SFTPConfig.PrivateKey
+	currentTOTPSecret := user.Filters.TOTPConfig.Secret
+	currentRecoveryCodes := user.Filters.RecoveryCodes
+
+	if _, ok := fields["permissions"]; ok {
+		user.Permissions = make(map[string][]string)
+	}
+	if err := json.Unmarshal(update, user); err != nil {
+		return dataprovider.NewValidationError(fmt.Sprintf("invalid update: %v", err))
+	}
+	user.ID = userID
+	user.Username = username
+	// recovery codes can only be generated using the dedicated API
+	user.Filters.RecoveryCodes = currentRecoveryCodes
+	if user.Filters.TOTPConfig.Secret != nil && user.Filters.TOTPConfig.Secret.IsNotPlainAndNotEmpty() {
+		user.Filters.TOTPConfig.Secret = currentTOTPSecret
+	}
+	user.SetEmptySecretsIfNil()
+	updateEncryptedSecrets(user, currentS3AccessSecret, currentAzAccountKey, currentGCSCredentials, currentCryptoPassphrase,
+		currentSFTPPassword, currentSFTPKey)
+	return nil
+}
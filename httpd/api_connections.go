@@ -0,0 +1,209 @@
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+type closeUserConnectionsResult struct {
+	ClosedConnections int      `json:"closed_connections"`
+	BannedIPs         []string `json:"banned_ips"`
+	Disabled          bool     `json:"disabled"`
+}
+
+// getConnectionsInAdminScope returns the active connections for the users in the scope of
+// the logged in admin. The connections not yet authenticated are only returned to the admins
+// without restrictions
+func getConnectionsInAdminScope(r *http.Request) ([]*common.ConnectionStatus, error) {
+	claims, err := getTokenClaims(r)
+	if err != nil {
+		return nil, err
+	}
+	stats := common.Connections.GetStats()
+	if !claims.isRestricted() {
+		return stats, nil
+	}
+	usersInScope := make(map[string]bool)
+	result := make([]*common.ConnectionStatus, 0, len(stats))
+	for _, stat := range stats {
+		if stat.Username == "" {
+			continue
+		}
+		inScope, ok := usersInScope[stat.Username]
+		if !ok {
+			user, err := dataprovider.UserExists(stat.Username)
+			inScope = err == nil && isUserInAdminScope(r, &user)
+			usersInScope[stat.Username] = inScope
+		}
+		if inScope {
+			result = append(result, stat)
+		}
+	}
+	return result, nil
+}
+
+func getActiveConnections(w http.ResponseWriter, r *http.Request) {
+	stats, err := getConnectionsInAdminScope(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	render.JSON(w, r, stats)
+}
+
+func getConnectionHistory(w http.ResponseWriter, r *http.Request) {
+	limit, offset, order, err := getSearchFilters(w, r)
+	if err != nil {
+		return
+	}
+	filters := dataprovider.ConnectionHistoryFilters{
+		Username: r.URL.Query().Get("username"),
+		IP:       r.URL.Query().Get("ip"),
+		Protocol: r.URL.Query().Get("protocol"),
+	}
+	if _, ok := r.URL.Query()["start_from"]; ok {
+		filters.StartTimeFrom, err = strconv.ParseInt(r.URL.Query().Get("start_from"), 10, 64)
+		if err != nil || filters.StartTimeFrom < 0 {
+			sendAPIResponse(w, r, errors.New("Invalid start_from"), "", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, ok := r.URL.Query()["start_to"]; ok {
+		filters.StartTimeTo, err = strconv.ParseInt(r.URL.Query().Get("start_to"), 10, 64)
+		if err != nil || filters.StartTimeTo < 0 {
+			sendAPIResponse(w, r, errors.New("Invalid start_to"), "", http.StatusBadRequest)
+			return
+		}
+	}
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	if claims.isRestricted() {
+		// restricted admins can only search the connections of the users in their scope
+		if filters.Username == "" {
+			sendAPIResponse(w, r, errors.New("the username filter is required for restricted admins"),
+				"", http.StatusBadRequest)
+			return
+		}
+		user, err := dataprovider.UserExists(filters.Username)
+		if err != nil || !isUserInAdminScope(r, &user) {
+			sendAPIResponse(w, r, fmt.Errorf("you are not allowed to view the connections of the user %#v", filters.Username),
+				"", http.StatusForbidden)
+			return
+		}
+	}
+
+	records, err := dataprovider.SearchConnectionRecords(filters, limit, offset, order)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, records)
+}
+
+// closeUserConnections terminates all the active connections for the given user.
+// The user can be optionally disabled and its source IP addresses banned, before
+// closing the connections, so the client cannot immediately reconnect
+func closeUserConnections(w http.ResponseWriter, r *http.Request) {
+	username := getURLParam(r, "username")
+	disable, err := getCloseUserConnectionsParam(r, "disable")
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	ban, err := getCloseUserConnectionsParam(r, "ban")
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	// all the checks are done before changing anything
+	if disable && !claims.hasPerm(dataprovider.PermAdminChangeUsers) {
+		sendAPIResponse(w, r, errors.New("the edit users permission is required to disable the user"), "",
+			http.StatusForbidden)
+		return
+	}
+	if ban {
+		if !claims.hasPerm(dataprovider.PermAdminManageDefender) {
+			sendAPIResponse(w, r, errors.New("the manage defender permission is required to ban the IP addresses"), "",
+				http.StatusForbidden)
+			return
+		}
+		if !common.IsDefenderEnabled() {
+			sendAPIResponse(w, r, errors.New("the defender is disabled, the IP addresses cannot be banned"), "",
+				http.StatusBadRequest)
+			return
+		}
+	}
+	var user dataprovider.User
+	if disable {
+		user, err = dataprovider.UserExists(username)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+	}
+
+	result := closeUserConnectionsResult{
+		BannedIPs: []string{},
+	}
+	if disable && user.Status != 0 {
+		user.Status = 0
+		if err = dataprovider.UpdateUser(&user); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		logger.Info(logSender, "", "user %#v disabled by admin %#v", username, claims.Username)
+	}
+	result.Disabled = disable
+	var connectionIDs []string
+	for _, stat := range common.Connections.GetStats() {
+		if stat.Username != username {
+			continue
+		}
+		connectionIDs = append(connectionIDs, stat.ConnectionID)
+		if !ban {
+			continue
+		}
+		ip := utils.GetIPFromRemoteAddress(stat.RemoteAddress)
+		if utils.IsStringInSlice(ip, result.BannedIPs) {
+			continue
+		}
+		if common.Ban(ip) {
+			result.BannedIPs = append(result.BannedIPs, ip)
+			logger.Info(logSender, "", "ip %#v, used by user %#v, banned by admin %#v", ip, username, claims.Username)
+		}
+	}
+	for _, connectionID := range connectionIDs {
+		if common.Connections.Close(connectionID) {
+			result.ClosedConnections++
+		}
+	}
+	render.JSON(w, r, result)
+}
+
+func getCloseUserConnectionsParam(r *http.Request, name string) (bool, error) {
+	if _, ok := r.URL.Query()[name]; !ok {
+		return false, nil
+	}
+	value, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil || (value != 0 && value != 1) {
+		return false, fmt.Errorf("invalid %v parameter: %#v", name, r.URL.Query().Get(name))
+	}
+	return value == 1, nil
+}
@@ -0,0 +1,412 @@
+package httpd
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	csvColumnUsername          = "username"
+	csvColumnStatus            = "status"
+	csvColumnExpirationDate    = "expiration_date"
+	csvColumnHomeDir           = "home_dir"
+	csvColumnUID               = "uid"
+	csvColumnGID               = "gid"
+	csvColumnMaxSessions       = "max_sessions"
+	csvColumnQuotaSize         = "quota_size"
+	csvColumnQuotaFiles        = "quota_files"
+	csvColumnUploadBandwidth   = "upload_bandwidth"
+	csvColumnDownloadBandwidth = "download_bandwidth"
+	csvColumnPermissions       = "permissions"
+	csvColumnGroups            = "groups"
+	csvColumnAdditionalInfo    = "additional_info"
+	csvColumnPassword          = "password"
+	csvImportActionAdd         = "add"
+	csvImportActionUpdate      = "update"
+	csvDateFormat              = "2006-01-02"
+)
+
+// the columns included in the exported CSV, the password is never exported
+var usersCSVExportColumns = []string{csvColumnUsername, csvColumnStatus, csvColumnExpirationDate, csvColumnHomeDir,
+	csvColumnUID, csvColumnGID, csvColumnMaxSessions, csvColumnQuotaSize, csvColumnQuotaFiles, csvColumnUploadBandwidth,
+	csvColumnDownloadBandwidth, csvColumnPermissions, csvColumnGroups, csvColumnAdditionalInfo}
+
+// the columns accepted when importing users
+var usersCSVImportColumns = append(usersCSVExportColumns, csvColumnPassword)
+
+// usersCSVImportRow defines the import result for a CSV record
+type usersCSVImportRow struct {
+	// line number inside the CSV file, the header is the line 1
+	Line     int    `json:"line"`
+	Username string `json:"username"`
+	Action   string `json:"action"`
+	Error    string `json:"error,omitempty"`
+}
+
+// usersCSVImportReport defines the result of a CSV import
+type usersCSVImportReport struct {
+	DryRun  bool                `json:"dry_run"`
+	Added   int                 `json:"added"`
+	Updated int                 `json:"updated"`
+	Errors  int                 `json:"errors"`
+	Rows    []usersCSVImportRow `json:"rows"`
+}
+
+func (r *usersCSVImportReport) addRowError(idx int, err error) {
+	if validationErr, ok := err.(*dataprovider.ValidationError); ok {
+		r.Rows[idx].Error = validationErr.GetErrorString()
+	} else {
+		r.Rows[idx].Error = err.Error()
+	}
+	r.Errors++
+}
+
+func exportUsersAsCSV(w http.ResponseWriter, r *http.Request) {
+	filters, err := getUserSearchFilters(w, r)
+	if err != nil {
+		return
+	}
+	content, err := getUsersAsCSV(r, &filters)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"sftpgo-users.csv\"")
+	w.Write(content) //nolint:errcheck
+}
+
+func importUsersFromCSV(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	err := r.ParseMultipartForm(maxRequestSize)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll() //nolint:errcheck
+
+	dryRun := false
+	if _, ok := r.URL.Query()["dry_run"]; ok {
+		dryRun, err = strconv.ParseBool(r.URL.Query().Get("dry_run"))
+		if err != nil {
+			sendAPIResponse(w, r, err, "Invalid dry_run parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	usersContent, err := getMultipartFormValue(r, "users")
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to read the users to import", http.StatusBadRequest)
+		return
+	}
+	report, err := importUsersCSVContent(r, usersContent, dryRun)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	status := http.StatusOK
+	if !dryRun && report.Errors > 0 {
+		status = http.StatusBadRequest
+	}
+	ctx := context.WithValue(r.Context(), render.StatusCtxKey, status)
+	render.JSON(w, r.WithContext(ctx), report)
+}
+
+// getUsersAsCSV returns the users in the scope of the logged in admin, matching the given filters, as CSV
+func getUsersAsCSV(r *http.Request, filters *dataprovider.UserSearchFilters) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(usersCSVExportColumns); err != nil {
+		return nil, err
+	}
+	offset := 0
+	for {
+		users, err := getUsersInAdminScope(r, defaultQueryLimit, offset, dataprovider.OrderASC, filters)
+		if err != nil {
+			return nil, err
+		}
+		for idx := range users {
+			if err := writer.Write(getUserCSVRecord(&users[idx])); err != nil {
+				return nil, err
+			}
+		}
+		if len(users) < defaultQueryLimit {
+			break
+		}
+		offset += len(users)
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+func getUserCSVRecord(user *dataprovider.User) []string {
+	var expirationDate string
+	if user.ExpirationDate > 0 {
+		expirationDate = utils.GetTimeFromMsecSinceEpoch(user.ExpirationDate).UTC().Format(webDateTimeFormat)
+	}
+	return []string{
+		user.Username,
+		strconv.Itoa(user.Status),
+		expirationDate,
+		user.HomeDir,
+		strconv.Itoa(user.UID),
+		strconv.Itoa(user.GID),
+		strconv.Itoa(user.MaxSessions),
+		strconv.FormatInt(user.QuotaSize, 10),
+		strconv.Itoa(user.QuotaFiles),
+		strconv.FormatInt(user.UploadBandwidth, 10),
+		strconv.FormatInt(user.DownloadBandwidth, 10),
+		strings.Join(user.Permissions["/"], ","),
+		strings.Join(user.Filters.Groups, ","),
+		user.AdditionalInfo,
+	}
+}
+
+// importUsersCSVContent adds the CSV users that don't exist and updates the existing ones.
+// The first CSV record must be a header with the column names, only the columns
+// included in the CSV are modified for the existing users and an empty value resets
+// the field, except for the password that is changed only if not empty.
+// All the users are validated before applying any change: if a user is invalid
+// or this is a dry run, nothing is changed and the returned report contains the
+// validation result for each user. An error is returned if the CSV cannot be parsed
+func importUsersCSVContent(r *http.Request, content []byte, dryRun bool) (usersCSVImportReport, error) {
+	report := usersCSVImportReport{
+		DryRun: dryRun,
+		Rows:   []usersCSVImportRow{},
+	}
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			err = errors.New("no users to import")
+		}
+		return report, dataprovider.NewValidationError(fmt.Sprintf("unable to parse the users to import: %v", err))
+	}
+	columns, err := getUsersCSVColumns(header)
+	if err != nil {
+		return report, err
+	}
+	records, err := reader.ReadAll()
+	if err != nil {
+		return report, dataprovider.NewValidationError(fmt.Sprintf("unable to parse the users to import: %v", err))
+	}
+	admin := getAdminFromToken(r)
+	usernames := make(map[string]bool)
+	users := make([]dataprovider.User, 0, len(records))
+	// report row index for each user to add or update
+	rowIndexes := make([]int, 0, len(records))
+
+	for idx, record := range records {
+		username := strings.TrimSpace(record[columns[csvColumnUsername]])
+		row := usersCSVImportRow{
+			Line:     idx + 2,
+			Username: username,
+			Action:   csvImportActionAdd,
+		}
+		report.Rows = append(report.Rows, row)
+		if username == "" {
+			report.addRowError(idx, errors.New("username is mandatory"))
+			continue
+		}
+		if usernames[username] {
+			report.addRowError(idx, errors.New("duplicated username"))
+			continue
+		}
+		usernames[username] = true
+
+		user, err := dataprovider.UserExists(username)
+		if err == nil {
+			report.Rows[idx].Action = csvImportActionUpdate
+			if !admin.HasPermission(dataprovider.PermAdminChangeUsers) {
+				report.addRowError(idx, errors.New("you are not allowed to update users"))
+				continue
+			}
+			if !isUserInAdminScope(r, &user) {
+				report.addRowError(idx, errors.New("the user is not in your groups"))
+				continue
+			}
+		} else {
+			if _, ok := err.(*dataprovider.RecordNotFoundError); !ok {
+				report.addRowError(idx, err)
+				continue
+			}
+			user = dataprovider.User{
+				Username:    username,
+				Status:      1,
+				Permissions: make(map[string][]string),
+			}
+		}
+		if err := setUserFieldsFromCSV(&user, columns, record); err != nil {
+			report.addRowError(idx, err)
+			continue
+		}
+		if !isUserInAdminScope(r, &user) {
+			report.addRowError(idx, errors.New("the user must belong to at least one of your groups"))
+			continue
+		}
+		if err := dataprovider.ValidateUser(&user); err != nil {
+			report.addRowError(idx, err)
+			continue
+		}
+		if report.Rows[idx].Action == csvImportActionAdd {
+			report.Added++
+		} else {
+			report.Updated++
+		}
+		users = append(users, user)
+		rowIndexes = append(rowIndexes, idx)
+	}
+
+	if dryRun || report.Errors > 0 {
+		return report, nil
+	}
+	report.Added = 0
+	report.Updated = 0
+	for idx := range users {
+		rowIdx := rowIndexes[idx]
+		if report.Rows[rowIdx].Action == csvImportActionAdd {
+			err = dataprovider.AddUser(&users[idx])
+			if err == nil {
+				report.Added++
+			}
+		} else {
+			err = dataprovider.UpdateUser(&users[idx])
+			if err == nil {
+				report.Updated++
+			}
+		}
+		if err != nil {
+			report.addRowError(rowIdx, err)
+		}
+	}
+	return report, nil
+}
+
+// getUsersCSVColumns returns the index for each column in the given CSV header
+func getUsersCSVColumns(header []string) (map[string]int, error) {
+	columns := make(map[string]int)
+	for idx, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !utils.IsStringInSlice(name, usersCSVImportColumns) {
+			return columns, dataprovider.NewValidationError(fmt.Sprintf("unsupported CSV column %#v", name))
+		}
+		if _, ok := columns[name]; ok {
+			return columns, dataprovider.NewValidationError(fmt.Sprintf("duplicated CSV column %#v", name))
+		}
+		columns[name] = idx
+	}
+	if _, ok := columns[csvColumnUsername]; !ok {
+		return columns, dataprovider.NewValidationError("the CSV header must include the username column")
+	}
+	return columns, nil
+}
+
+// setUserFieldsFromCSV updates the given user using the values of the CSV columns.
+// The permissions column sets the permissions for the root directory,
+// the permissions for the sub directories are preserved
+func setUserFieldsFromCSV(user *dataprovider.User, columns map[string]int, record []string) error {
+	var err error
+	for name, idx := range columns {
+		value := strings.TrimSpace(record[idx])
+		switch name {
+		case csvColumnStatus:
+			user.Status, err = getCSVIntValue(name, value)
+		case csvColumnExpirationDate:
+			user.ExpirationDate, err = getCSVDateValue(value)
+		case csvColumnHomeDir:
+			user.HomeDir = value
+		case csvColumnUID:
+			user.UID, err = getCSVIntValue(name, value)
+		case csvColumnGID:
+			user.GID, err = getCSVIntValue(name, value)
+		case csvColumnMaxSessions:
+			user.MaxSessions, err = getCSVIntValue(name, value)
+		case csvColumnQuotaSize:
+			user.QuotaSize, err = getCSVInt64Value(name, value)
+		case csvColumnQuotaFiles:
+			user.QuotaFiles, err = getCSVIntValue(name, value)
+		case csvColumnUploadBandwidth:
+			user.UploadBandwidth, err = getCSVInt64Value(name, value)
+		case csvColumnDownloadBandwidth:
+			user.DownloadBandwidth, err = getCSVInt64Value(name, value)
+		case csvColumnPermissions:
+			if user.Permissions == nil {
+				user.Permissions = make(map[string][]string)
+			}
+			user.Permissions["/"] = getCSVListValue(value)
+		case csvColumnGroups:
+			user.Filters.Groups = getCSVListValue(value)
+		case csvColumnAdditionalInfo:
+			user.AdditionalInfo = value
+		case csvColumnPassword:
+			// an empty password means no change
+			if value != "" {
+				user.Password = value
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getCSVIntValue(name, value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, dataprovider.NewValidationError(fmt.Sprintf("invalid %v: %#v", name, value))
+	}
+	return result, nil
+}
+
+func getCSVInt64Value(name, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	result, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, dataprovider.NewValidationError(fmt.Sprintf("invalid %v: %#v", name, value))
+	}
+	return result, nil
+}
+
+// getCSVDateValue parses an UTC expiration date, both the date only and the
+// date with time formats are accepted
+func getCSVDateValue(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	for _, format := range []string{webDateTimeFormat, csvDateFormat} {
+		if t, err := time.Parse(format, value); err == nil {
+			return utils.GetTimeAsMsSinceEpoch(t), nil
+		}
+	}
+	return 0, dataprovider.NewValidationError(fmt.Sprintf("invalid expiration_date: %#v", value))
+}
+
+func getCSVListValue(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" && !utils.IsStringInSlice(item, result) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
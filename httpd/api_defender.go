@@ -80,3 +80,11 @@ func validateIPAddress(ip string) error {
 	}
 	return nil
 }
+
+func reloadIPLists(w http.ResponseWriter, r *http.Request) {
+	if err := common.ReloadIPLists(); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	sendAPIResponse(w, r, nil, "IP lists reloaded", http.StatusOK)
+}
@@ -0,0 +1,26 @@
+package httpd
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/diagnostics"
+)
+
+type diagnosticsStats struct {
+	Hooks        []diagnostics.Stats `json:"hooks"`
+	DataProvider []diagnostics.Stats `json:"data_provider"`
+}
+
+func getDiagnostics(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, diagnosticsStats{
+		Hooks:        diagnostics.GetHooksStats(),
+		DataProvider: diagnostics.GetProviderStats(),
+	})
+}
+
+func resetDiagnostics(w http.ResponseWriter, r *http.Request) {
+	diagnostics.Reset()
+	sendAPIResponse(w, r, nil, "Diagnostics reset", http.StatusOK)
+}
@@ -0,0 +1,44 @@
+package httpd
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+)
+
+func getAuditEvents(w http.ResponseWriter, r *http.Request) {
+	limit, offset, order, err := getSearchFilters(w, r)
+	if err != nil {
+		return
+	}
+	filters := dataprovider.AuditEventsFilters{
+		Username:   r.URL.Query().Get("username"),
+		Action:     r.URL.Query().Get("action"),
+		ObjectType: r.URL.Query().Get("object_type"),
+	}
+	if _, ok := r.URL.Query()["from"]; ok {
+		filters.TimestampFrom, err = strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+		if err != nil || filters.TimestampFrom < 0 {
+			sendAPIResponse(w, r, errors.New("Invalid from"), "", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, ok := r.URL.Query()["to"]; ok {
+		filters.TimestampTo, err = strconv.ParseInt(r.URL.Query().Get("to"), 10, 64)
+		if err != nil || filters.TimestampTo < 0 {
+			sendAPIResponse(w, r, errors.New("Invalid to"), "", http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := dataprovider.SearchAuditEvents(filters, limit, offset, order)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, events)
+}
@@ -0,0 +1,262 @@
+package httpd
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/geoip"
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/metrics"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// userProfile defines the user details visible to the user itself using the user API
+type userProfile struct {
+	Username          string              `json:"username"`
+	ExpirationDate    int64               `json:"expiration_date"`
+	Permissions       map[string][]string `json:"permissions"`
+	QuotaSize         int64               `json:"quota_size"`
+	QuotaFiles        int                 `json:"quota_files"`
+	UsedQuotaSize     int64               `json:"used_quota_size"`
+	UsedQuotaFiles    int                 `json:"used_quota_files"`
+	LastLogin         int64               `json:"last_login"`
+	LastLoginProtocol string              `json:"last_login_protocol,omitempty"`
+}
+
+func getUserProfile(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(w, r)
+	if !ok {
+		return
+	}
+	render.JSON(w, r, userProfile{
+		Username:          user.Username,
+		ExpirationDate:    user.ExpirationDate,
+		Permissions:       user.Permissions,
+		QuotaSize:         user.QuotaSize,
+		QuotaFiles:        user.QuotaFiles,
+		UsedQuotaSize:     user.UsedQuotaSize,
+		UsedQuotaFiles:    user.UsedQuotaFiles,
+		LastLogin:         user.LastLogin,
+		LastLoginProtocol: user.LastLoginProtocol,
+	})
+}
+
+// checkHTTPUserLogin checks if the given user, already authenticated, can use the user API
+func checkHTTPUserLogin(user *dataprovider.User, r *http.Request) error {
+	if utils.IsStringInSlice(common.ProtocolHTTP, user.Filters.DeniedProtocols) {
+		logger.Debug(logSender, "", "cannot login user %#v, protocol HTTP is not allowed", user.Username)
+		return fmt.Errorf("Protocol HTTP is not allowed for user %#v", user.Username)
+	}
+	if !user.IsLoginMethodAllowed(dataprovider.LoginMethodPassword, nil) {
+		logger.Debug(logSender, "", "cannot login user %#v, password login method is not allowed", user.Username)
+		return fmt.Errorf("Password login method is not allowed for user %#v", user.Username)
+	}
+	if !user.IsLoginFromAddrAllowed(r.RemoteAddr) {
+		logger.Debug(logSender, "", "cannot login user %#v, remote address is not allowed: %v", user.Username, r.RemoteAddr)
+		return fmt.Errorf("Login for user %#v is not allowed from this address: %v", user.Username, r.RemoteAddr)
+	}
+	if connAddr, ok := r.Context().Value(connAddrKey).(string); ok {
+		if connAddr != r.RemoteAddr && !user.IsLoginFromAddrAllowed(connAddr) {
+			logger.Debug(logSender, "", "cannot login user %#v, remote address is not allowed: %v", user.Username, connAddr)
+			return fmt.Errorf("Login for user %#v is not allowed from this address: %v", user.Username, connAddr)
+		}
+	}
+	return nil
+}
+
+func updateLoginMetrics(user *dataprovider.User, ip string, err error) {
+	metrics.AddLoginAttempt(dataprovider.LoginMethodPassword)
+	if err != nil {
+		logger.ConnectionFailedLog(user.Username, ip, geoip.GetCountry(ip), dataprovider.LoginMethodPassword,
+			common.ProtocolHTTP, err.Error())
+		event := common.HostEventLoginFailed
+		if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+			event = common.HostEventUserNotFound
+		}
+		common.AddDefenderEvent(ip, event)
+		common.DelayFailedLogin(ip, user.Username)
+	} else {
+		common.ResetFailedLogins(ip, user.Username)
+	}
+	metrics.AddLoginResult(dataprovider.LoginMethodPassword, err)
+	dataprovider.ExecutePostLoginHook(user, dataprovider.LoginMethodPassword, ip, common.ProtocolHTTP, err)
+}
+
+// userTOTPSecret defines a new TOTP secret, not yet saved
+type userTOTPSecret struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url"`
+	// base64 encoded PNG image
+	QRCode []byte `json:"qr_code"`
+}
+
+// userTOTPConfig defines the TOTP configuration to save, the passcode must be
+// generated using the given secret
+type userTOTPConfig struct {
+	Secret    string   `json:"secret"`
+	Passcode  string   `json:"passcode"`
+	Protocols []string `json:"protocols"`
+}
+
+// getLoggedUser returns the user authenticated by the token in the request
+func getLoggedUser(w http.ResponseWriter, r *http.Request) (dataprovider.User, bool) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return dataprovider.User{}, false
+	}
+	user, err := dataprovider.UserExists(claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return user, false
+	}
+	return user, true
+}
+
+func changeUserPassword(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	var pwd adminPwd
+	err := render.DecodeJSON(r.Body, &pwd)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if pwd.CurrentPassword == "" || pwd.NewPassword == "" {
+		sendAPIResponse(w, r, nil, "Please provide the current password and the new one", http.StatusBadRequest)
+		return
+	}
+	if pwd.CurrentPassword == pwd.NewPassword {
+		sendAPIResponse(w, r, nil, "The new password must be different from the current one", http.StatusBadRequest)
+		return
+	}
+	user, ok := getLoggedUser(w, r)
+	if !ok {
+		return
+	}
+	match, err := user.CheckPassword(pwd.CurrentPassword)
+	if !match || err != nil {
+		sendAPIResponse(w, r, err, "Current password does not match", http.StatusBadRequest)
+		return
+	}
+	user.Password = pwd.NewPassword
+	if err := dataprovider.UpdateUser(&user); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Password updated", http.StatusOK)
+}
+
+func getUserPublicKeys(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(w, r)
+	if !ok {
+		return
+	}
+	publicKeys := user.PublicKeys
+	if publicKeys == nil {
+		publicKeys = []string{}
+	}
+	render.JSON(w, r, publicKeys)
+}
+
+func setUserPublicKeys(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	var publicKeys []string
+	err := render.DecodeJSON(r.Body, &publicKeys)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	user, ok := getLoggedUser(w, r)
+	if !ok {
+		return
+	}
+	user.PublicKeys = publicKeys
+	if err := dataprovider.UpdateUser(&user); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Public keys updated", http.StatusOK)
+}
+
+func generateUserTOTPSecret(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(w, r)
+	if !ok {
+		return
+	}
+	secret, keyURL, qrCode, err := dataprovider.GenerateTOTPSecret(user.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to generate TOTP secret", http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, userTOTPSecret{
+		Secret: secret,
+		URL:    keyURL,
+		QRCode: qrCode,
+	})
+}
+
+// saveUserTOTPConfig enables TOTP, or replaces the current secret, and
+// generates new recovery codes
+func saveUserTOTPConfig(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	var config userTOTPConfig
+	err := render.DecodeJSON(r.Body, &config)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if config.Secret == "" || !dataprovider.ValidateTOTPPasscode(config.Secret, config.Passcode) {
+		sendAPIResponse(w, r, nil, "Invalid passcode for the given secret", http.StatusBadRequest)
+		return
+	}
+	user, ok := getLoggedUser(w, r)
+	if !ok {
+		return
+	}
+	user.Filters.TOTPConfig = dataprovider.TOTPConfig{
+		Enabled:   true,
+		Secret:    kms.NewPlainSecret(config.Secret),
+		Protocols: config.Protocols,
+	}
+	codes, recoveryCodes, err := dataprovider.GenerateRecoveryCodes()
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	user.Filters.RecoveryCodes = recoveryCodes
+	if err := dataprovider.UpdateUser(&user); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, codes)
+}
+
+func generateLoggedUserRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(w, r)
+	if !ok {
+		return
+	}
+	if !user.Filters.TOTPConfig.Enabled {
+		sendAPIResponse(w, r, nil, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	codes, recoveryCodes, err := dataprovider.GenerateRecoveryCodes()
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	user.Filters.RecoveryCodes = recoveryCodes
+	if err := dataprovider.UpdateUser(&user); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, codes)
+}
@@ -0,0 +1,199 @@
+package httpd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+func getAPIKeys(w http.ResponseWriter, r *http.Request) {
+	limit, offset, order, err := getSearchFilters(w, r)
+	if err != nil {
+		return
+	}
+
+	apiKeys, err := dataprovider.GetAPIKeys(limit, offset, order)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, apiKeys)
+}
+
+func getAPIKeyByID(w http.ResponseWriter, r *http.Request) {
+	keyID := getURLParam(r, "id")
+	apiKey, err := dataprovider.APIKeyExists(keyID)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	apiKey.HideConfidentialData()
+
+	render.JSON(w, r, apiKey)
+}
+
+func addAPIKey(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	var apiKey dataprovider.APIKey
+	err := render.DecodeJSON(r.Body, &apiKey)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	apiKey.ID = 0
+	apiKey.KeyID = ""
+	apiKey.Key = ""
+	if err = checkAPIKeyAdmin(r, &apiKey); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusForbidden)
+		return
+	}
+	err = dataprovider.AddAPIKey(&apiKey)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	response := make(map[string]string)
+	response["message"] = "API key created. This is the only time the API key is visible, please save it."
+	response["key"] = apiKey.DisplayKey()
+	w.Header().Add("Location", apiKeysPath+"/"+apiKey.KeyID)
+	w.Header().Add("X-Object-ID", apiKey.KeyID)
+	ctx := context.WithValue(r.Context(), render.StatusCtxKey, http.StatusCreated)
+	render.JSON(w, r.WithContext(ctx), response)
+}
+
+func updateAPIKey(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	keyID := getURLParam(r, "id")
+	apiKey, err := dataprovider.APIKeyExists(keyID)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+
+	scope := apiKey.Scope
+	admin := apiKey.Admin
+	restrictions := apiKey.Restrictions
+	// the restrictions are replaced, omitting them removes the existing ones
+	apiKey.Restrictions = dataprovider.APIKeyRestrictions{}
+	err = render.DecodeJSON(r.Body, &apiKey)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	// removing the restrictions could give the key more permissions
+	if apiKey.Scope != scope || apiKey.Admin != admin || !reflect.DeepEqual(apiKey.Restrictions, restrictions) {
+		if err = checkAPIKeyAdmin(r, &apiKey); err != nil {
+			sendAPIResponse(w, r, err, "", http.StatusForbidden)
+			return
+		}
+	}
+
+	apiKey.KeyID = keyID
+	if err := dataprovider.UpdateAPIKey(&apiKey); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "API key updated", http.StatusOK)
+}
+
+func deleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID := getURLParam(r, "id")
+
+	err := dataprovider.DeleteAPIKey(keyID)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, err, "API key deleted", http.StatusOK)
+}
+
+// checkAPIKeyAdmin returns an error if the logged in admin cannot create an API key
+// for the admin associated with the given key. The token generated for an admin
+// API key has the permissions of the associated admin, so an admin can only create
+// keys for its own account or for admins with a subset of its permissions and scope.
+// A key not associated to any admin can be used to impersonate any admin allowing
+// API key authentication, it requires an admin without restrictions.
+// A token restricted by an API key cannot manage API keys
+func checkAPIKeyAdmin(r *http.Request, apiKey *dataprovider.APIKey) error {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		return errors.New("invalid token claims")
+	}
+	if claims.Restrictions != nil {
+		return errors.New("a token restricted by an API key cannot manage API keys")
+	}
+	if apiKey.Scope != dataprovider.APIKeyScopeAdmin {
+		return checkAPIKeyUser(&claims, apiKey)
+	}
+	if apiKey.Admin == "" {
+		if !claims.hasPerm(dataprovider.PermAdminAny) || claims.isRestricted() {
+			return errors.New("only an admin without restrictions can create API keys not associated to an admin")
+		}
+		return nil
+	}
+	if apiKey.Admin == claims.Username {
+		return nil
+	}
+	admin, err := dataprovider.AdminExists(apiKey.Admin)
+	if err != nil {
+		// the key validation will fail
+		return nil
+	}
+	for _, perm := range admin.Permissions {
+		if !claims.hasPerm(perm) {
+			return fmt.Errorf("you cannot create API keys for the admin %#v, you don't have the permission %#v",
+				admin.Username, perm)
+		}
+	}
+	if len(claims.Users) > 0 {
+		// admins cannot be restricted to some users
+		return fmt.Errorf("you cannot create API keys for the admin %#v, it is not restricted to your users",
+			admin.Username)
+	}
+	if len(claims.Groups) > 0 {
+		if len(admin.Filters.Groups) == 0 {
+			return fmt.Errorf("you cannot create API keys for the admin %#v, it is not restricted to your groups",
+				admin.Username)
+		}
+		for _, group := range admin.Filters.Groups {
+			if !utils.IsStringInSlice(group, claims.Groups) {
+				return fmt.Errorf("you cannot create API keys for the admin %#v, it is not restricted to your groups",
+					admin.Username)
+			}
+		}
+	}
+	return nil
+}
+
+// checkAPIKeyUser returns an error if the logged in admin cannot create an API key
+// for the user associated with the given key. A key not associated to any user can be
+// used to impersonate any user allowing API key authentication, it requires an admin
+// without restrictions
+func checkAPIKeyUser(claims *jwtTokenClaims, apiKey *dataprovider.APIKey) error {
+	if apiKey.User == "" {
+		if !claims.hasPerm(dataprovider.PermAdminAny) || claims.isRestricted() {
+			return errors.New("only an admin without restrictions can create user API keys not associated to a user")
+		}
+		return nil
+	}
+	if !claims.isRestricted() {
+		return nil
+	}
+	user, err := dataprovider.UserExists(apiKey.User)
+	if err != nil {
+		// the key validation will fail
+		return nil
+	}
+	if !claims.isUserInScope(&user) {
+		return fmt.Errorf("you cannot create API keys for the user %#v", user.Username)
+	}
+	return nil
+}
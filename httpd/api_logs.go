@@ -0,0 +1,126 @@
+package httpd
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/rs/zerolog"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+var validLogLevels = map[string]zerolog.Level{
+	"debug": zerolog.DebugLevel,
+	"info":  zerolog.InfoLevel,
+	"warn":  zerolog.WarnLevel,
+	"error": zerolog.ErrorLevel,
+}
+
+type logLevel struct {
+	Level string `json:"level"`
+	// per-module log levels, the modules not included use the global log level
+	Modules map[string]string `json:"modules,omitempty"`
+}
+
+type debugLogs struct {
+	ConnectionIDs []string `json:"connection_ids"`
+	Usernames     []string `json:"usernames"`
+}
+
+func getLogLevel(w http.ResponseWriter, r *http.Request) {
+	resp := logLevel{
+		Level:   logger.GetLevel().String(),
+		Modules: make(map[string]string),
+	}
+	for module, level := range logger.GetModuleLevels() {
+		resp.Modules[module] = level.String()
+	}
+	render.JSON(w, r, resp)
+}
+
+func updateLogLevel(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	var req logLevel
+	err := render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	level, ok := validLogLevels[req.Level]
+	if !ok {
+		sendAPIResponse(w, r, fmt.Errorf("invalid log level %#v", req.Level), "", http.StatusBadRequest)
+		return
+	}
+	var moduleLevels map[string]zerolog.Level
+	if req.Modules != nil {
+		moduleLevels = make(map[string]zerolog.Level)
+		for module, name := range req.Modules {
+			if !utils.IsStringInSlice(module, logger.ValidModules) {
+				sendAPIResponse(w, r, fmt.Errorf("invalid module %#v", module), "", http.StatusBadRequest)
+				return
+			}
+			moduleLevel, ok := validLogLevels[name]
+			if !ok {
+				sendAPIResponse(w, r, fmt.Errorf("invalid log level %#v for module %#v", name, module), "",
+					http.StatusBadRequest)
+				return
+			}
+			moduleLevels[module] = moduleLevel
+		}
+	}
+	logger.SetLevel(level)
+	if moduleLevels != nil {
+		logger.SetModuleLevels(moduleLevels)
+	}
+	logger.Info(logSender, "", "log level changed to %#v, module log levels: %#v", req.Level,
+		logger.GetModuleLevelsAsString())
+	sendAPIResponse(w, r, nil, "Log level updated", http.StatusOK)
+}
+
+func getDebugLogs(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, debugLogs{
+		ConnectionIDs: logger.GetDebugConnections(),
+		Usernames:     common.Connections.GetDebugUsers(),
+	})
+}
+
+func enableConnectionDebugLogs(w http.ResponseWriter, r *http.Request) {
+	connectionID := getURLParam(r, "connectionID")
+	stats, err := getConnectionsInAdminScope(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	for _, stat := range stats {
+		if stat.ConnectionID == connectionID {
+			logger.EnableConnectionDebug(connectionID)
+			sendAPIResponse(w, r, nil, "Debug logs enabled", http.StatusOK)
+			return
+		}
+	}
+	sendAPIResponse(w, r, nil, "Connection not found", http.StatusNotFound)
+}
+
+func disableConnectionDebugLogs(w http.ResponseWriter, r *http.Request) {
+	if logger.DisableConnectionDebug(getURLParam(r, "connectionID")) {
+		sendAPIResponse(w, r, nil, "Debug logs disabled", http.StatusOK)
+		return
+	}
+	sendAPIResponse(w, r, nil, "Debug logs are not enabled for this connection", http.StatusNotFound)
+}
+
+func enableUserDebugLogs(w http.ResponseWriter, r *http.Request) {
+	common.Connections.EnableUserDebug(getURLParam(r, "username"))
+	sendAPIResponse(w, r, nil, "Debug logs enabled", http.StatusOK)
+}
+
+func disableUserDebugLogs(w http.ResponseWriter, r *http.Request) {
+	if common.Connections.DisableUserDebug(getURLParam(r, "username")) {
+		sendAPIResponse(w, r, nil, "Debug logs disabled", http.StatusOK)
+		return
+	}
+	sendAPIResponse(w, r, nil, "Debug logs are not enabled for this user", http.StatusNotFound)
+}
@@ -109,7 +109,12 @@ func loadDataFromRequest(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
-	if err := restoreBackup(content, "", scanQuota, mode); err != nil {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	if err := restoreBackup(content, "", scanQuota, mode, claims.Username); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 	}
 	sendAPIResponse(w, r, err, "Data restored", http.StatusOK)
@@ -141,13 +146,25 @@ func loadData(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
-	if err := restoreBackup(content, inputFile, scanQuota, mode); err != nil {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	if err := restoreBackup(content, inputFile, scanQuota, mode, claims.Username); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 	}
 	sendAPIResponse(w, r, err, "Data restored", http.StatusOK)
 }
 
-func restoreBackup(content []byte, inputFile string, scanQuota, mode int) error {
+func restoreBackup(content []byte, inputFile string, scanQuota, mode int, executor string) error {
+	if common.IsEncryptedBackup(content) {
+		var err error
+		content, err = common.DecryptBackup(content, common.Config.BackupSchedule.Passphrase)
+		if err != nil {
+			return dataprovider.NewValidationError(fmt.Sprintf("Unable to decrypt backup content: %v", err))
+		}
+	}
 	dump, err := dataprovider.ParseDumpData(content)
 	if err != nil {
 		return dataprovider.NewValidationError(fmt.Sprintf("Unable to parse backup content: %v", err))
@@ -165,8 +182,22 @@ func restoreBackup(content []byte, inputFile string, scanQuota, mode int) error
 		return err
 	}
 
-	logger.Debug(logSender, "", "backup restored, users: %v, folders: %v, admins: %vs",
-		len(dump.Users), len(dump.Folders), len(dump.Admins))
+	if err = RestoreAPIKeys(dump.APIKeys, inputFile, mode); err != nil {
+		return err
+	}
+
+	if err = RestoreShares(dump.Shares, inputFile, mode); err != nil {
+		return err
+	}
+
+	if mode == 3 {
+		if err = DeleteMissingObjects(&dump, inputFile, executor); err != nil {
+			return err
+		}
+	}
+
+	logger.Debug(logSender, "", "backup restored, users: %v, folders: %v, admins: %v, API keys: %v, shares: %v",
+		len(dump.Users), len(dump.Folders), len(dump.Admins), len(dump.APIKeys), len(dump.Shares))
 
 	return nil
 }
@@ -220,7 +251,7 @@ func RestoreFolders(folders []vfs.BaseVirtualFolder, inputFile string, mode, sca
 		if scanQuota >= 1 {
 			if common.QuotaScans.AddVFolderQuotaScan(folder.Name) {
 				logger.Debug(logSender, "", "starting quota scan for restored folder: %#v", folder.Name)
-				go doFolderQuotaScan(folder) //nolint:errcheck
+				go common.DoFolderQuotaScan(folder) //nolint:errcheck
 			}
 		}
 	}
@@ -282,8 +313,139 @@ func RestoreUsers(users []dataprovider.User, inputFile string, mode, scanQuota i
 		if scanQuota == 1 || (scanQuota == 2 && user.HasQuotaRestrictions()) {
 			if common.QuotaScans.AddUserQuotaScan(user.Username) {
 				logger.Debug(logSender, "", "starting quota scan for restored user: %#v", user.Username)
-				go doQuotaScan(user) //nolint:errcheck
+				go common.DoUserQuotaScan(user) //nolint:errcheck
+			}
+		}
+	}
+	return nil
+}
+
+// RestoreAPIKeys restores the specified API keys
+func RestoreAPIKeys(apiKeys []dataprovider.APIKey, inputFile string, mode int) error {
+	for _, apiKey := range apiKeys {
+		apiKey := apiKey // pin
+		if apiKey.KeyID == "" || apiKey.Key == "" {
+			return dataprovider.NewValidationError(fmt.Sprintf("cannot restore API key %#v: id and key are mandatory",
+				apiKey.Name))
+		}
+		k, err := dataprovider.APIKeyExists(apiKey.KeyID)
+		if err == nil {
+			if mode == 1 {
+				logger.Debug(logSender, "", "loaddata mode 1, existing API key %#v not updated", apiKey.KeyID)
+				continue
 			}
+			apiKey.ID = k.ID
+			err = dataprovider.UpdateAPIKey(&apiKey)
+			apiKey.Key = redactedSecret
+			logger.Debug(logSender, "", "restoring existing API key: %+v, dump file: %#v, error: %v", apiKey, inputFile, err)
+		} else {
+			err = dataprovider.AddAPIKey(&apiKey)
+			apiKey.Key = redactedSecret
+			logger.Debug(logSender, "", "adding new API key: %+v, dump file: %#v, error: %v", apiKey, inputFile, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreShares restores the specified shares
+func RestoreShares(shares []dataprovider.Share, inputFile string, mode int) error {
+	for _, share := range shares {
+		share := share // pin
+		if share.ShareID == "" {
+			return dataprovider.NewValidationError(fmt.Sprintf("cannot restore share %#v: id is mandatory",
+				share.Name))
+		}
+		_, err := dataprovider.ShareExists(share.ShareID, "")
+		if err == nil {
+			if mode == 1 {
+				logger.Debug(logSender, "", "loaddata mode 1, existing share %#v not updated", share.ShareID)
+				continue
+			}
+			err = dataprovider.UpdateShare(&share)
+			share.Password = redactedSecret
+			logger.Debug(logSender, "", "restoring existing share: %+v, dump file: %#v, error: %v", share, inputFile, err)
+		} else {
+			err = dataprovider.AddShare(&share)
+			share.Password = redactedSecret
+			logger.Debug(logSender, "", "adding new share: %+v, dump file: %#v, error: %v", share, inputFile, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteMissingObjects removes the users, folders, admins, API keys and shares not
+// included in the specified dump. Folders referenced by restored users are
+// preserved. Admins are removed only if the dump includes at least one admin
+// and the executor, if any, is never removed
+func DeleteMissingObjects(dump *dataprovider.BackupData, inputFile, executor string) error {
+	current, err := dataprovider.DumpData()
+	if err != nil {
+		return err
+	}
+	for _, apiKey := range current.APIKeys {
+		if dump.HasAPIKey(apiKey.KeyID) {
+			continue
+		}
+		err = dataprovider.DeleteAPIKey(apiKey.KeyID)
+		logger.Debug(logSender, "", "deleting API key %#v not included in dump file: %#v, error: %v",
+			apiKey.KeyID, inputFile, err)
+		if _, ok := err.(*dataprovider.RecordNotFoundError); err != nil && !ok {
+			return err
+		}
+	}
+	for _, share := range current.Shares {
+		if dump.HasShare(share.ShareID) {
+			continue
+		}
+		err = dataprovider.DeleteShare(share.ShareID, "")
+		logger.Debug(logSender, "", "deleting share %#v not included in dump file: %#v, error: %v",
+			share.ShareID, inputFile, err)
+		if _, ok := err.(*dataprovider.RecordNotFoundError); err != nil && !ok {
+			return err
+		}
+	}
+	for _, user := range current.Users {
+		if dump.HasUser(user.Username) {
+			continue
+		}
+		err = dataprovider.DeleteUser(user.Username)
+		logger.Debug(logSender, "", "deleting user %#v not included in dump file: %#v, error: %v",
+			user.Username, inputFile, err)
+		if err != nil {
+			return err
+		}
+		disconnectUser(user.Username)
+	}
+	for _, folder := range current.Folders {
+		if dump.IsFolderReferenced(folder.Name) {
+			continue
+		}
+		err = dataprovider.DeleteFolder(folder.Name)
+		logger.Debug(logSender, "", "deleting folder %#v not included in dump file: %#v, error: %v",
+			folder.Name, inputFile, err)
+		if err != nil {
+			return err
+		}
+	}
+	if len(dump.Admins) == 0 {
+		logger.Debug(logSender, "", "no admin included in dump file: %#v, existing admins not removed", inputFile)
+		return nil
+	}
+	for _, admin := range current.Admins {
+		if dump.HasAdmin(admin.Username) || admin.Username == executor {
+			continue
+		}
+		err = dataprovider.DeleteAdmin(admin.Username)
+		logger.Debug(logSender, "", "deleting admin %#v not included in dump file: %#v, error: %v",
+			admin.Username, inputFile, err)
+		if _, ok := err.(*dataprovider.RecordNotFoundError); err != nil && !ok {
+			return err
 		}
 	}
 	return nil
@@ -0,0 +1,213 @@
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// publicShareDirEntry defines a directory entry listed using a public share
+type publicShareDirEntry struct {
+	Name         string `json:"name"`
+	Size         int64  `json:"size"`
+	IsDir        bool   `json:"is_dir"`
+	LastModified int64  `json:"last_modified"`
+}
+
+// getPublicShareConnection checks the share identified by the id URL param and returns
+// a connection for its user. The share must have the given scope, it must not be expired
+// or used up and, if it is password protected, the password must be provided using basic
+// auth. The returned connection must be removed from the active ones when done
+func getPublicShareConnection(w http.ResponseWriter, r *http.Request, scope dataprovider.ShareScope,
+) (dataprovider.Share, *Connection, error) {
+	ipAddr := utils.GetIPFromRemoteAddress(r.RemoteAddr)
+	if common.IsBanned(ipAddr) {
+		sendAPIResponse(w, r, common.ErrConnectionDenied, "", http.StatusForbidden)
+		return dataprovider.Share{}, nil, common.ErrConnectionDenied
+	}
+	share, err := dataprovider.ShareExists(getURLParam(r, "id"), "")
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return share, nil, err
+	}
+	if share.Scope != scope {
+		err = errors.New("invalid share scope")
+		sendAPIResponse(w, r, err, "", http.StatusForbidden)
+		return share, nil, err
+	}
+	if share.IsExpired() || share.IsUsedUp() {
+		err = errors.New("the share is expired or it reached the maximum number of uses")
+		sendAPIResponse(w, r, err, "", http.StatusForbidden)
+		return share, nil, err
+	}
+	if !share.IsRemoteAddrAllowed(ipAddr) {
+		err = fmt.Errorf("the share cannot be used from this address: %v", ipAddr)
+		sendAPIResponse(w, r, err, "", http.StatusForbidden)
+		return share, nil, err
+	}
+	if share.Password != "" {
+		_, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
+			sendAPIResponse(w, r, nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return share, nil, dataprovider.ErrInvalidCredentials
+		}
+		if err := share.CheckPassword(password); err != nil {
+			logger.Debug(logSender, "", "invalid password for share %#v, ip %#v: %v", share.ShareID, ipAddr, err)
+			common.AddDefenderEvent(ipAddr, common.HostEventLoginFailed)
+			w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
+			sendAPIResponse(w, r, dataprovider.ErrInvalidCredentials, http.StatusText(http.StatusUnauthorized),
+				http.StatusUnauthorized)
+			return share, nil, dataprovider.ErrInvalidCredentials
+		}
+	}
+	user, err := dataprovider.UserExists(share.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return share, nil, err
+	}
+	if user.Status != 1 || user.IsExpired() || utils.IsStringInSlice(common.ProtocolHTTP, user.Filters.DeniedProtocols) {
+		err = fmt.Errorf("the user %#v cannot use the share", user.Username)
+		sendAPIResponse(w, r, err, "", http.StatusForbidden)
+		return share, nil, err
+	}
+	connection, err := newUserConnection(r, user)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return share, nil, err
+	}
+	common.Connections.Add(connection)
+	return share, connection, nil
+}
+
+// getPublicShare lists a directory or downloads a file shared with the read scope.
+// The path query parameter is required if more than one path is shared
+func getPublicShare(w http.ResponseWriter, r *http.Request) {
+	share, connection, err := getPublicShareConnection(w, r, dataprovider.ShareScopeRead)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := r.URL.Query().Get("path")
+	if name == "" && len(share.Paths) == 1 {
+		name = share.Paths[0]
+	}
+	name = utils.CleanPath(name)
+	if !share.IsPathShared(name) ||
+		!connection.User.HasPerms([]string{dataprovider.PermListItems, dataprovider.PermDownload}, name) {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	info, err := connection.stat(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getBrowseFilesStatus(err))
+		return
+	}
+	if info.IsDir() {
+		connection.Log(logger.LevelInfo, "listing the directory %#v using share %#v", name, share.ShareID)
+		contents, err := connection.ReadDir(name)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getBrowseFilesStatus(err))
+			return
+		}
+		entries := make([]publicShareDirEntry, 0, len(contents))
+		for _, info := range contents {
+			entries = append(entries, publicShareDirEntry{
+				Name:         info.Name(),
+				Size:         info.Size(),
+				IsDir:        info.IsDir(),
+				LastModified: utils.GetTimeAsMsSinceEpoch(info.ModTime()),
+			})
+		}
+		render.JSON(w, r, entries)
+		return
+	}
+	connection.Log(logger.LevelInfo, "downloading the file %#v using share %#v", name, share.ShareID)
+	file, info, err := connection.getFileReader(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getBrowseFilesStatus(err))
+		return
+	}
+	defer file.Close()
+
+	if err := useShare(w, r, &share); err != nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprintf("%v", info.Size()))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%#v", path.Base(name)))
+	// the response writer wrapper, added by the logger middleware, can advertise io.ReaderFrom even
+	// if the wrapped writer does not implement it, so we copy using the Write method only
+	if _, err := io.Copy(struct{ io.Writer }{w}, file); err != nil {
+		connection.Log(logger.LevelWarn, "error downloading file %#v using share %#v: %v", name, share.ShareID, err)
+	}
+}
+
+// uploadToPublicShare uploads the request body, as a file with the name defined by the
+// name query parameter, inside the directory shared with the write scope
+func uploadToPublicShare(w http.ResponseWriter, r *http.Request) {
+	share, connection, err := getPublicShareConnection(w, r, dataprovider.ShareScopeWrite)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	fileName := r.URL.Query().Get("name")
+	if fileName == "" || fileName == "." || fileName == ".." || strings.ContainsAny(fileName, "/\\") {
+		sendAPIResponse(w, r, fmt.Errorf("invalid file name %#v", fileName), "", http.StatusBadRequest)
+		return
+	}
+	name := path.Join(share.Paths[0], fileName)
+	if err := useShare(w, r, &share); err != nil {
+		return
+	}
+	connection.Log(logger.LevelInfo, "uploading the file %#v using share %#v", name, share.ShareID)
+	file, err := connection.getFileWriter(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getUploadStatus(err))
+		return
+	}
+	if r.ContentLength > 0 {
+		file.SetExpectedSize(r.ContentLength)
+	}
+	_, err = io.Copy(file, r.Body)
+	if err != nil {
+		file.TransferError(err)
+	}
+	errClose := file.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		connection.Log(logger.LevelWarn, "unable to upload the file %#v using share %#v: %v", name, share.ShareID, err)
+		sendAPIResponse(w, r, err, "", getUploadStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Upload completed", http.StatusCreated)
+}
+
+// useShare atomically checks and increments the used tokens for the given share,
+// it must be called before serving a download or an upload
+func useShare(w http.ResponseWriter, r *http.Request, share *dataprovider.Share) error {
+	err := dataprovider.UpdateShareLastUse(share, 1)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, dataprovider.ErrShareUsedUp) {
+		sendAPIResponse(w, r, err, "", http.StatusForbidden)
+	} else {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	}
+	return err
+}
@@ -8,7 +8,6 @@ import (
 
 	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/dataprovider"
-	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/vfs"
 )
 
@@ -17,6 +16,18 @@ const (
 	quotaUpdateModeReset = "reset"
 )
 
+// folderQuotaScanStatus defines the quota scan status for a virtual folder.
+// The used quota is updated when the scan completes
+type folderQuotaScanStatus struct {
+	Name       string `json:"name"`
+	InProgress bool   `json:"in_progress"`
+	// scan start time as unix timestamp in milliseconds, 0 if no scan is in progress
+	StartTime       int64 `json:"start_time"`
+	UsedQuotaSize   int64 `json:"used_quota_size"`
+	UsedQuotaFiles  int   `json:"used_quota_files"`
+	LastQuotaUpdate int64 `json:"last_quota_update"`
+}
+
 func getQuotaScans(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, common.QuotaScans.GetUsersQuotaScans())
 }
@@ -48,6 +59,10 @@ func updateUserQuotaUsage(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	if !isUserInAdminScope(r, &user) {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
 	if mode == quotaUpdateModeAdd && !user.HasQuotaRestrictions() && dataprovider.GetQuotaTracking() == 2 {
 		sendAPIResponse(w, r, errors.New("this user has no quota restrictions, only reset mode is supported"),
 			"", http.StatusBadRequest)
@@ -119,8 +134,12 @@ func startQuotaScan(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	if !isUserInAdminScope(r, &user) {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
 	if common.QuotaScans.AddUserQuotaScan(user.Username) {
-		go doQuotaScan(user) //nolint:errcheck
+		go common.DoUserQuotaScan(user) //nolint:errcheck
 		sendAPIResponse(w, r, err, "Scan started", http.StatusAccepted)
 	} else {
 		sendAPIResponse(w, r, err, "Another scan is already in progress", http.StatusConflict)
@@ -139,48 +158,48 @@ func startVFolderQuotaScan(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
-	folder, err := dataprovider.GetFolderByName(f.Name)
-	if err != nil {
-		sendAPIResponse(w, r, err, "", getRespStatus(err))
+	doStartVFolderQuotaScan(w, r, f.Name)
+}
+
+func startFolderQuotaScanByName(w http.ResponseWriter, r *http.Request) {
+	if dataprovider.GetQuotaTracking() == 0 {
+		sendAPIResponse(w, r, nil, "Quota tracking is disabled!", http.StatusForbidden)
 		return
 	}
-	if common.QuotaScans.AddVFolderQuotaScan(folder.Name) {
-		go doFolderQuotaScan(folder) //nolint:errcheck
-		sendAPIResponse(w, r, err, "Scan started", http.StatusAccepted)
-	} else {
-		sendAPIResponse(w, r, err, "Another scan is already in progress", http.StatusConflict)
-	}
+	doStartVFolderQuotaScan(w, r, getURLParam(r, "name"))
 }
 
-func doQuotaScan(user dataprovider.User) error {
-	defer common.QuotaScans.RemoveUserQuotaScan(user.Username)
-	fs, err := user.GetFilesystem("")
+func getFolderQuotaScanByName(w http.ResponseWriter, r *http.Request) {
+	folder, err := dataprovider.GetFolderByName(getURLParam(r, "name"))
 	if err != nil {
-		logger.Warn(logSender, "", "unable scan quota for user %#v error creating filesystem: %v", user.Username, err)
-		return err
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
 	}
-	defer fs.Close()
-	numFiles, size, err := fs.ScanRootDirContents()
-	if err != nil {
-		logger.Warn(logSender, "", "error scanning user home dir %#v: %v", user.Username, err)
-		return err
+	status := folderQuotaScanStatus{
+		Name:            folder.Name,
+		UsedQuotaSize:   folder.UsedQuotaSize,
+		UsedQuotaFiles:  folder.UsedQuotaFiles,
+		LastQuotaUpdate: folder.LastQuotaUpdate,
 	}
-	err = dataprovider.UpdateUserQuota(&user, numFiles, size, true)
-	logger.Debug(logSender, "", "user home dir scanned, user: %#v, error: %v", user.Username, err)
-	return err
+	if scan, ok := common.QuotaScans.GetVFolderQuotaScan(folder.Name); ok {
+		status.InProgress = true
+		status.StartTime = scan.StartTime
+	}
+	render.JSON(w, r, status)
 }
 
-func doFolderQuotaScan(folder vfs.BaseVirtualFolder) error {
-	defer common.QuotaScans.RemoveVFolderQuotaScan(folder.Name)
-	fs := vfs.NewOsFs("", "", nil).(*vfs.OsFs)
-	numFiles, size, err := fs.GetDirSize(folder.MappedPath)
+func doStartVFolderQuotaScan(w http.ResponseWriter, r *http.Request, name string) {
+	folder, err := dataprovider.GetFolderByName(name)
 	if err != nil {
-		logger.Warn(logSender, "", "error scanning folder %#v: %v", folder.MappedPath, err)
-		return err
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if common.QuotaScans.AddVFolderQuotaScan(folder.Name) {
+		go common.DoFolderQuotaScan(folder) //nolint:errcheck
+		sendAPIResponse(w, r, err, "Scan started", http.StatusAccepted)
+	} else {
+		sendAPIResponse(w, r, err, "Another scan is already in progress", http.StatusConflict)
 	}
-	err = dataprovider.UpdateVirtualFolderQuota(&folder, numFiles, size, true)
-	logger.Debug(logSender, "", "virtual folder %#v scanned, error: %v", folder.Name, err)
-	return err
 }
 
 func getQuotaUpdateMode(r *http.Request) (string, error) {
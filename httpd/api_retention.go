@@ -0,0 +1,144 @@
+package httpd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+)
+
+func getRetentionChecks(w http.ResponseWriter, r *http.Request) {
+	claims, err := getTokenClaims(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	checks := common.RetentionChecks.Get()
+	if !claims.isRestricted() {
+		render.JSON(w, r, checks)
+		return
+	}
+	// restricted admins can only see the checks for the users in their scope
+	result := make([]common.RetentionCheck, 0, len(checks))
+	for _, check := range checks {
+		user, err := dataprovider.UserExists(check.Username)
+		if err == nil && claims.isUserInScope(&user) {
+			result = append(result, check)
+		}
+	}
+	render.JSON(w, r, result)
+}
+
+func getRetentionReport(w http.ResponseWriter, r *http.Request) {
+	username := getURLParam(r, "username")
+	report, ok := common.RetentionChecks.GetReport(username)
+	if !ok {
+		sendAPIResponse(w, r, nil, "No retention check report found for this user", http.StatusNotFound)
+		return
+	}
+	render.JSON(w, r, report)
+}
+
+func getFolderRetentionChecks(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, common.RetentionChecks.GetFolderChecks())
+}
+
+func getFolderRetentionReport(w http.ResponseWriter, r *http.Request) {
+	name := getURLParam(r, "name")
+	report, ok := common.RetentionChecks.GetFolderReport(name)
+	if !ok {
+		sendAPIResponse(w, r, nil, "No retention check report found for this folder", http.StatusNotFound)
+		return
+	}
+	render.JSON(w, r, report)
+}
+
+func startRetentionCheck(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	username := getURLParam(r, "username")
+	user, err := dataprovider.UserExists(username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	check, err := getRetentionCheckFromRequest(w, r)
+	if err != nil {
+		return
+	}
+	c := common.RetentionChecks.Add(check, &user)
+	if c == nil {
+		sendAPIResponse(w, r, err, "Another check is already in progress", http.StatusConflict)
+		return
+	}
+	go c.Start()
+	sendAPIResponse(w, r, err, "Check started", http.StatusAccepted)
+}
+
+func startFolderRetentionCheck(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	name := getURLParam(r, "name")
+	folder, err := dataprovider.GetFolderByName(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	check, err := getRetentionCheckFromRequest(w, r)
+	if err != nil {
+		return
+	}
+	c := common.RetentionChecks.AddFolder(check, &folder)
+	if c == nil {
+		sendAPIResponse(w, r, err, "Another check is already in progress", http.StatusConflict)
+		return
+	}
+	go c.Start()
+	sendAPIResponse(w, r, err, "Check started", http.StatusAccepted)
+}
+
+// getRetentionCheckFromRequest returns a validated retention check with the inline
+// policy from the request body. An error response is sent if the check is not valid
+func getRetentionCheckFromRequest(w http.ResponseWriter, r *http.Request) (common.RetentionCheck, error) {
+	var check common.RetentionCheck
+	err := render.DecodeJSON(r.Body, &check.Folders)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return check, err
+	}
+	if dryRun := r.URL.Query().Get("dry_run"); dryRun != "" {
+		check.DryRun, err = strconv.ParseBool(dryRun)
+		if err != nil {
+			err = fmt.Errorf("invalid dry_run parameter: %v", err)
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return check, err
+		}
+	}
+	for _, notification := range strings.Split(r.URL.Query().Get("notifications"), ",") {
+		notification = strings.TrimSpace(notification)
+		if notification != "" {
+			check.Notifications = append(check.Notifications, notification)
+		}
+	}
+	if len(check.Notifications) > 0 {
+		claims, err := getTokenClaims(r)
+		if err != nil {
+			sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+			return check, err
+		}
+		admin, err := dataprovider.AdminExists(claims.Username)
+		if err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return check, err
+		}
+		check.Email = admin.Email
+	}
+	if err := check.Validate(); err != nil {
+		sendAPIResponse(w, r, err, "Invalid retention check", http.StatusBadRequest)
+		return check, err
+	}
+	return check, nil
+}
@@ -0,0 +1,239 @@
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+)
+
+func getShares(w http.ResponseWriter, r *http.Request) {
+	limit, offset, order, err := getSearchFilters(w, r)
+	if err != nil {
+		return
+	}
+	username := r.URL.Query().Get("username")
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	if claims.isRestricted() {
+		// restricted admins can only list the shares of the users in their scope
+		if username == "" {
+			sendAPIResponse(w, r, errors.New("the username filter is required for restricted admins"),
+				"", http.StatusBadRequest)
+			return
+		}
+		if err := checkShareUserInAdminScope(w, r, username); err != nil {
+			return
+		}
+	}
+
+	shares, err := dataprovider.GetShares(limit, offset, order, username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, shares)
+}
+
+func getShareByID(w http.ResponseWriter, r *http.Request) {
+	share, err := getShareInAdminScope(w, r)
+	if err != nil {
+		return
+	}
+	share.HideConfidentialData()
+
+	render.JSON(w, r, share)
+}
+
+func addShare(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	var share dataprovider.Share
+	err := render.DecodeJSON(r.Body, &share)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if err := checkShareUserInAdminScope(w, r, share.Username); err != nil {
+		return
+	}
+	saveNewShare(w, r, &share, sharesPath)
+}
+
+func updateShare(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	share, err := getShareInAdminScope(w, r)
+	if err != nil {
+		return
+	}
+	shareID := share.ShareID
+	share.HideConfidentialData()
+	err = render.DecodeJSON(r.Body, &share)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if err := checkShareUserInAdminScope(w, r, share.Username); err != nil {
+		return
+	}
+	share.ShareID = shareID
+	if err := dataprovider.UpdateShare(&share); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Share updated", http.StatusOK)
+}
+
+func deleteShare(w http.ResponseWriter, r *http.Request) {
+	share, err := getShareInAdminScope(w, r)
+	if err != nil {
+		return
+	}
+	if err := dataprovider.DeleteShare(share.ShareID, share.Username); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Share deleted", http.StatusOK)
+}
+
+func getUserShares(w http.ResponseWriter, r *http.Request) {
+	limit, offset, order, err := getSearchFilters(w, r)
+	if err != nil {
+		return
+	}
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+
+	shares, err := dataprovider.GetShares(limit, offset, order, claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, shares)
+}
+
+func getUserShareByID(w http.ResponseWriter, r *http.Request) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	share, err := dataprovider.ShareExists(getURLParam(r, "id"), claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	share.HideConfidentialData()
+
+	render.JSON(w, r, share)
+}
+
+func addUserShare(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+
+	var share dataprovider.Share
+	err = render.DecodeJSON(r.Body, &share)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	share.Username = claims.Username
+	saveNewShare(w, r, &share, userSharesPath)
+}
+
+func updateUserShare(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	share, err := dataprovider.ShareExists(getURLParam(r, "id"), claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	shareID := share.ShareID
+	share.HideConfidentialData()
+	err = render.DecodeJSON(r.Body, &share)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	share.ShareID = shareID
+	share.Username = claims.Username
+	if err := dataprovider.UpdateShare(&share); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Share updated", http.StatusOK)
+}
+
+func deleteUserShare(w http.ResponseWriter, r *http.Request) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	if err := dataprovider.DeleteShare(getURLParam(r, "id"), claims.Username); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Share deleted", http.StatusOK)
+}
+
+func saveNewShare(w http.ResponseWriter, r *http.Request, share *dataprovider.Share, basePath string) {
+	share.ID = 0
+	share.ShareID = ""
+	if err := dataprovider.AddShare(share); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	w.Header().Add("Location", basePath+"/"+share.ShareID)
+	w.Header().Add("X-Object-ID", share.ShareID)
+	sendAPIResponse(w, r, nil, "Share created", http.StatusCreated)
+}
+
+// getShareInAdminScope returns the share identified by the id URL param if it
+// belongs to a user that the logged in admin can manage.
+// An error response is sent if the share cannot be returned
+func getShareInAdminScope(w http.ResponseWriter, r *http.Request) (dataprovider.Share, error) {
+	share, err := dataprovider.ShareExists(getURLParam(r, "id"), "")
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return share, err
+	}
+	err = checkShareUserInAdminScope(w, r, share.Username)
+	return share, err
+}
+
+// checkShareUserInAdminScope sends a forbidden response and returns an error if the
+// logged in admin cannot manage the shares of the given user.
+// Not existing users are checked in the share validation
+func checkShareUserInAdminScope(w http.ResponseWriter, r *http.Request, username string) error {
+	user, err := dataprovider.UserExists(username)
+	if err != nil {
+		return nil
+	}
+	if !isUserInAdminScope(r, &user) {
+		err = fmt.Errorf("you are not allowed to manage the shares of the user %#v", username)
+		sendAPIResponse(w, r, err, "", http.StatusForbidden)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,592 @@
+package httpd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// headers and values defined in the tus resumable upload protocol
+const (
+	tusVersion             = "1.0.0"
+	tusExtensions          = "creation,termination"
+	headerTusResumable     = "Tus-Resumable"
+	headerTusVersion       = "Tus-Version"
+	headerTusExtension     = "Tus-Extension"
+	headerTusMaxSize       = "Tus-Max-Size"
+	headerUploadLength     = "Upload-Length"
+	headerUploadOffset     = "Upload-Offset"
+	headerUploadMetadata   = "Upload-Metadata"
+	tusOffsetContentType   = "application/offset+octet-stream"
+	uploadInfoFileSuffix   = ".info"
+	defaultUploadsMaxAge   = 24
+	defaultUploadsPerUser  = 10
+	uploadMetadataPath     = "path"
+	uploadMetadataFilename = "filename"
+)
+
+var (
+	errUploadNotFound = errors.New("upload not found")
+	errUploadLocked   = errors.New("the upload is locked by another request")
+	errTooManyUploads = errors.New("too many pending uploads")
+	uploadsMgr        *resumableUploadsManager
+)
+
+// ResumableUploads defines the configuration for the resumable uploads in the user API.
+// The tus protocol, https://tus.io/, is used so the uploads can be resumed after a
+// network interruption
+type ResumableUploads struct {
+	// Path to the directory to store the partial uploads. This can be an absolute path
+	// or a path relative to the config dir. Empty means resumable uploads disabled
+	Path string `json:"path" mapstructure:"path"`
+	// Partial uploads not completed within this number of hours are removed.
+	// 0 means the default, 24 hours
+	MaxAge int `json:"max_age" mapstructure:"max_age"`
+	// Maximum allowed size, as bytes, for a single upload. 0 means no limit
+	MaxSize int64 `json:"max_size" mapstructure:"max_size"`
+	// Maximum number of pending uploads for each user. 0 means the default, 10
+	MaxPerUser int `json:"max_per_user" mapstructure:"max_per_user"`
+}
+
+func (c *ResumableUploads) initialize(configDir string) error {
+	uploadsMgr = nil
+	dir := getConfigPath(c.Path, configDir)
+	if dir == "" {
+		logger.Debug(logSender, "", "resumable uploads disabled")
+		return nil
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("resumable uploads: invalid max age %v", c.MaxAge)
+	}
+	if c.MaxSize < 0 {
+		return fmt.Errorf("resumable uploads: invalid max size %v", c.MaxSize)
+	}
+	if c.MaxPerUser < 0 {
+		return fmt.Errorf("resumable uploads: invalid max uploads per user %v", c.MaxPerUser)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("resumable uploads: unable to create the directory %#v: %w", dir, err)
+	}
+	maxAge := c.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultUploadsMaxAge
+	}
+	maxPerUser := c.MaxPerUser
+	if maxPerUser == 0 {
+		maxPerUser = defaultUploadsPerUser
+	}
+	uploadsMgr = &resumableUploadsManager{
+		dir:        dir,
+		maxAge:     time.Duration(maxAge) * time.Hour,
+		maxSize:    c.MaxSize,
+		maxPerUser: maxPerUser,
+		inProgress: make(map[string]bool),
+	}
+	logger.Debug(logSender, "", "resumable uploads enabled, directory %#v", dir)
+	return nil
+}
+
+// resumableUpload defines a partial upload, the received data are stored in a file
+// named as the upload ID inside the uploads directory
+type resumableUpload struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type resumableUploadsManager struct {
+	sync.Mutex
+	dir        string
+	maxAge     time.Duration
+	maxSize    int64
+	maxPerUser int
+	// uploads locked by a request
+	inProgress map[string]bool
+	// serializes the uploads creation, so the pending uploads checks cannot be bypassed
+	// using concurrent requests
+	createMu sync.Mutex
+}
+
+func (m *resumableUploadsManager) getDataPath(id string) string {
+	return filepath.Join(m.dir, id)
+}
+
+func (m *resumableUploadsManager) getInfoPath(id string) string {
+	return filepath.Join(m.dir, id+uploadInfoFileSuffix)
+}
+
+// getPending returns the number and the total declared size of the pending uploads
+// for the given user
+func (m *resumableUploadsManager) getPending(username string) (int, int64, error) {
+	files, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var count int
+	var size int64
+	for _, info := range files {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), uploadInfoFileSuffix) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(m.dir, info.Name()))
+		if err != nil {
+			continue
+		}
+		var upload resumableUpload
+		if err := json.Unmarshal(data, &upload); err != nil || upload.Username != username {
+			continue
+		}
+		count++
+		size += upload.Size
+	}
+	return count, size, nil
+}
+
+func (m *resumableUploadsManager) add(upload *resumableUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(m.getDataPath(upload.ID), nil, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(m.getInfoPath(upload.ID), data, 0600); err != nil {
+		os.Remove(m.getDataPath(upload.ID))
+		return err
+	}
+	return nil
+}
+
+// get returns the upload with the given ID and the current offset.
+// Uploads owned by other users are not returned
+func (m *resumableUploadsManager) get(id, username string) (resumableUpload, int64, error) {
+	var upload resumableUpload
+	if _, err := xid.FromString(id); err != nil {
+		return upload, 0, errUploadNotFound
+	}
+	data, err := ioutil.ReadFile(m.getInfoPath(id))
+	if err != nil {
+		return upload, 0, errUploadNotFound
+	}
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return upload, 0, err
+	}
+	if upload.ID != id || upload.Username != username {
+		return upload, 0, errUploadNotFound
+	}
+	info, err := os.Stat(m.getDataPath(id))
+	if err != nil {
+		return upload, 0, errUploadNotFound
+	}
+	return upload, info.Size(), nil
+}
+
+func (m *resumableUploadsManager) lock(id string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.inProgress[id] {
+		return errUploadLocked
+	}
+	m.inProgress[id] = true
+	return nil
+}
+
+func (m *resumableUploadsManager) unlock(id string) {
+	m.Lock()
+	defer m.Unlock()
+
+	delete(m.inProgress, id)
+}
+
+func (m *resumableUploadsManager) remove(id string) {
+	os.Remove(m.getInfoPath(id))
+	os.Remove(m.getDataPath(id))
+}
+
+// removeExpired removes the partial uploads not completed within the configured max age
+func (m *resumableUploadsManager) removeExpired() {
+	files, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to list the resumable uploads directory %#v: %v", m.dir, err)
+		return
+	}
+	expiration := utils.GetTimeAsMsSinceEpoch(time.Now().Add(-m.maxAge))
+	for _, info := range files {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), uploadInfoFileSuffix) {
+			continue
+		}
+		id := strings.TrimSuffix(info.Name(), uploadInfoFileSuffix)
+		data, err := ioutil.ReadFile(filepath.Join(m.dir, info.Name()))
+		if err != nil {
+			continue
+		}
+		var upload resumableUpload
+		if err := json.Unmarshal(data, &upload); err == nil && upload.CreatedAt > expiration {
+			continue
+		}
+		if err := m.lock(id); err != nil {
+			continue
+		}
+		logger.Debug(logSender, "", "removing expired resumable upload %#v, user %#v, path %#v", id,
+			upload.Username, upload.Path)
+		m.remove(id)
+		m.unlock(id)
+	}
+}
+
+func cleanupExpiredResumableUploads() {
+	if uploadsMgr != nil {
+		uploadsMgr.removeExpired()
+	}
+}
+
+// parseUploadMetadata parses the Upload-Metadata header, it consists of comma separated
+// key-value pairs. The key and the value are separated by a space and the value is base64 encoded
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, " ", 2)
+		value := ""
+		if len(parts) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for the metadata key %#v: %v", parts[0], err)
+			}
+			value = string(decoded)
+		}
+		metadata[parts[0]] = value
+	}
+	return metadata, nil
+}
+
+// getUploadVirtualPath returns the target path from the upload metadata.
+// A file name is uploaded inside the root directory
+func getUploadVirtualPath(metadata map[string]string) (string, error) {
+	name := metadata[uploadMetadataPath]
+	if name == "" {
+		name = metadata[uploadMetadataFilename]
+		if name == "" {
+			return "", errors.New("the upload metadata must include the path or the filename")
+		}
+		name = path.Base(name)
+	}
+	name = utils.CleanPath(name)
+	if name == "/" {
+		return "", errors.New("invalid upload path")
+	}
+	return name, nil
+}
+
+func getUploadStatus(err error) int {
+	switch err {
+	case errUploadNotFound:
+		return http.StatusNotFound
+	case errUploadLocked:
+		return http.StatusLocked
+	case errTooManyUploads:
+		return http.StatusTooManyRequests
+	case common.ErrQuotaExceeded:
+		return http.StatusRequestEntityTooLarge
+	default:
+		return getBrowseFilesStatus(err)
+	}
+}
+
+func checkResumableUploads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if uploadsMgr == nil {
+			sendAPIResponse(w, r, nil, "Resumable uploads are disabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set(headerTusResumable, tusVersion)
+		if r.Header.Get(headerTusResumable) != tusVersion {
+			w.Header().Set(headerTusVersion, tusVersion)
+			sendAPIResponse(w, r, nil, fmt.Sprintf("Unsupported %v version", headerTusResumable),
+				http.StatusPreconditionFailed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleUploadsOptions(w http.ResponseWriter, r *http.Request) {
+	if uploadsMgr == nil {
+		sendAPIResponse(w, r, nil, "Resumable uploads are disabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set(headerTusResumable, tusVersion)
+	w.Header().Set(headerTusVersion, tusVersion)
+	w.Header().Set(headerTusExtension, tusExtensions)
+	if uploadsMgr.maxSize > 0 {
+		w.Header().Set(headerTusMaxSize, strconv.FormatInt(uploadsMgr.maxSize, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func createUpload(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(w, r)
+	if !ok {
+		return
+	}
+	size, err := strconv.ParseInt(r.Header.Get(headerUploadLength), 10, 64)
+	if err != nil || size < 0 {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Invalid or missing %v header", headerUploadLength),
+			http.StatusBadRequest)
+		return
+	}
+	if uploadsMgr.maxSize > 0 && size > uploadsMgr.maxSize {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("The upload size exceeds the maximum allowed size: %v",
+			uploadsMgr.maxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := parseUploadMetadata(r.Header.Get(headerUploadMetadata))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	name, err := getUploadVirtualPath(metadata)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	connection, err := newUserConnection(r, user)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to create connection", http.StatusInternalServerError)
+		return
+	}
+	upload := resumableUpload{
+		ID:        xid.New().String(),
+		Username:  user.Username,
+		Path:      name,
+		Size:      size,
+		CreatedAt: utils.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+	if err := addUpload(connection, &upload); err != nil {
+		sendAPIResponse(w, r, err, "", getUploadStatus(err))
+		return
+	}
+	logger.Debug(logSender, "", "resumable upload %#v created for user %#v, path %#v, size %v", upload.ID,
+		user.Username, name, size)
+	if size == 0 {
+		if err := finalizeUpload(r, user, &upload); err != nil {
+			sendAPIResponse(w, r, err, "", getUploadStatus(err))
+			return
+		}
+	}
+	w.Header().Set("Location", fmt.Sprintf("%v/%v", userUploadsPath, upload.ID))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// addUpload checks the pending uploads limit and the quota and adds the upload.
+// The declared sizes of the pending uploads are charged to the user quota since
+// they are not yet included in the used quota
+func addUpload(connection *Connection, upload *resumableUpload) error {
+	uploadsMgr.createMu.Lock()
+	defer uploadsMgr.createMu.Unlock()
+
+	count, pendingSize, err := uploadsMgr.getPending(upload.Username)
+	if err != nil {
+		return err
+	}
+	if count >= uploadsMgr.maxPerUser {
+		connection.Log(logger.LevelInfo, "unable to create a new resumable upload, pending uploads: %v, max allowed: %v",
+			count, uploadsMgr.maxPerUser)
+		return errTooManyUploads
+	}
+	if err := connection.checkUpload(upload.Path, upload.Size+pendingSize); err != nil {
+		return err
+	}
+	return uploadsMgr.add(upload)
+}
+
+func getUploadOffset(w http.ResponseWriter, r *http.Request) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	upload, offset, err := uploadsMgr.get(getURLParam(r, "id"), claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getUploadStatus(err))
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set(headerUploadOffset, strconv.FormatInt(offset, 10))
+	w.Header().Set(headerUploadLength, strconv.FormatInt(upload.Size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func writeUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != tusOffsetContentType {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Content-Type must be %#v", tusOffsetContentType),
+			http.StatusUnsupportedMediaType)
+		return
+	}
+	user, ok := getLoggedUser(w, r)
+	if !ok {
+		return
+	}
+	clientOffset, err := strconv.ParseInt(r.Header.Get(headerUploadOffset), 10, 64)
+	if err != nil || clientOffset < 0 {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Invalid or missing %v header", headerUploadOffset),
+			http.StatusBadRequest)
+		return
+	}
+	id := getURLParam(r, "id")
+	if _, _, err := uploadsMgr.get(id, user.Username); err != nil {
+		sendAPIResponse(w, r, err, "", getUploadStatus(err))
+		return
+	}
+	if err := uploadsMgr.lock(id); err != nil {
+		sendAPIResponse(w, r, err, "", getUploadStatus(err))
+		return
+	}
+	defer uploadsMgr.unlock(id)
+
+	// the upload could be removed before acquiring the lock
+	upload, offset, err := uploadsMgr.get(id, user.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getUploadStatus(err))
+		return
+	}
+	if clientOffset != offset {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Upload offset mismatch, expected: %v", offset), http.StatusConflict)
+		return
+	}
+	remaining := upload.Size - offset
+	if r.ContentLength > remaining {
+		sendAPIResponse(w, r, nil, "The request body exceeds the upload size", http.StatusBadRequest)
+		return
+	}
+	offset, err = appendUploadData(r.Body, uploadsMgr.getDataPath(id), offset, remaining)
+	if err != nil {
+		// the received data are kept, the client can resume from the saved offset
+		w.Header().Set(headerUploadOffset, strconv.FormatInt(offset, 10))
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if offset == upload.Size {
+		if err := finalizeUpload(r, user, &upload); err != nil {
+			sendAPIResponse(w, r, err, "", getUploadStatus(err))
+			return
+		}
+	}
+	w.Header().Set(headerUploadOffset, strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// appendUploadData appends at most remaining bytes, read from the given reader,
+// to the file with the specified path and returns the new offset
+func appendUploadData(reader io.Reader, name string, offset, remaining int64) (int64, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return offset, err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, io.LimitReader(reader, remaining))
+	if err != nil {
+		return offset + n, err
+	}
+	// the body is not limited for chunked requests
+	if n == remaining {
+		buf := make([]byte, 1)
+		if read, _ := reader.Read(buf); read > 0 {
+			if err := f.Truncate(offset); err != nil {
+				return offset + n, err
+			}
+			return offset, errors.New("the request body exceeds the upload size")
+		}
+	}
+	return offset + n, nil
+}
+
+// finalizeUpload copies the received data to the user's filesystem and removes the upload.
+// The upload is removed only if the copy succeeds, otherwise the client can retry sending
+// an empty request with the final offset
+func finalizeUpload(r *http.Request, user dataprovider.User, upload *resumableUpload) (err error) {
+	defer func() {
+		if err == nil {
+			uploadsMgr.remove(upload.ID)
+		}
+	}()
+
+	connection, err := newUserConnection(r, user)
+	if err != nil {
+		return err
+	}
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	src, err := os.Open(uploadsMgr.getDataPath(upload.ID))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	file, err := connection.getFileWriter(upload.Path)
+	if err != nil {
+		return err
+	}
+	file.SetExpectedSize(upload.Size)
+	_, err = io.Copy(file, src)
+	if err != nil {
+		file.TransferError(err)
+	}
+	errClose := file.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		connection.Log(logger.LevelWarn, "unable to complete the resumable upload %#v: %v", upload.ID, err)
+		return err
+	}
+	connection.Log(logger.LevelInfo, "resumable upload %#v completed, path %#v", upload.ID, upload.Path)
+	return nil
+}
+
+func deleteUpload(w http.ResponseWriter, r *http.Request) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	id := getURLParam(r, "id")
+	if _, _, err := uploadsMgr.get(id, claims.Username); err != nil {
+		sendAPIResponse(w, r, err, "", getUploadStatus(err))
+		return
+	}
+	if err := uploadsMgr.lock(id); err != nil {
+		sendAPIResponse(w, r, err, "", getUploadStatus(err))
+		return
+	}
+	defer uploadsMgr.unlock(id)
+
+	uploadsMgr.remove(id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,27 +1,76 @@
 package httpd
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/render"
 
 	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/vfs"
 )
 
+func getUserSearchFilters(w http.ResponseWriter, r *http.Request) (dataprovider.UserSearchFilters, error) {
+	var err error
+	filters := dataprovider.NewUserSearchFilters()
+	if _, ok := r.URL.Query()["inactive_days"]; ok {
+		filters.InactiveDays, err = strconv.Atoi(r.URL.Query().Get("inactive_days"))
+		if err != nil || filters.InactiveDays < 0 {
+			err = errors.New("Invalid inactive_days")
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return filters, err
+		}
+	}
+	if _, ok := r.URL.Query()["status"]; ok {
+		filters.Status, err = strconv.Atoi(r.URL.Query().Get("status"))
+		if err != nil || (filters.Status != 0 && filters.Status != 1) {
+			err = errors.New("Invalid status")
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return filters, err
+		}
+	}
+	if _, ok := r.URL.Query()["fs_provider"]; ok {
+		filters.FsProvider, err = strconv.Atoi(r.URL.Query().Get("fs_provider"))
+		if err != nil || filters.FsProvider < int(dataprovider.LocalFilesystemProvider) ||
+			filters.FsProvider > int(dataprovider.SFTPFilesystemProvider) {
+			err = errors.New("Invalid fs_provider")
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return filters, err
+		}
+	}
+	filters.Group = strings.TrimSpace(r.URL.Query().Get("group"))
+	filters.Search = strings.TrimSpace(r.URL.Query().Get("search"))
+	filters.SortField = strings.TrimSpace(r.URL.Query().Get("sort"))
+	if err = filters.Validate(); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return filters, err
+	}
+	return filters, nil
+}
+
 func getUsers(w http.ResponseWriter, r *http.Request) {
 	limit, offset, order, err := getSearchFilters(w, r)
 	if err != nil {
 		return
 	}
+	filters, err := getUserSearchFilters(w, r)
+	if err != nil {
+		return
+	}
 
-	users, err := dataprovider.GetUsers(limit, offset, order)
+	users, err := getUsersInAdminScope(r, limit, offset, order, &filters)
 	if err == nil {
 		render.JSON(w, r, users)
 	} else {
@@ -58,36 +107,14 @@ func addUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user.SetEmptySecretsIfNil()
-	switch user.FsConfig.Provider {
-	case dataprovider.S3FilesystemProvider:
-		if user.FsConfig.S3Config.AccessSecret.IsRedacted() {
-			sendAPIResponse(w, r, errors.New("invalid access_secret"), "", http.StatusBadRequest)
-			return
-		}
-	case dataprovider.GCSFilesystemProvider:
-		if user.FsConfig.GCSConfig.Credentials.IsRedacted() {
-			sendAPIResponse(w, r, errors.New("invalid credentials"), "", http.StatusBadRequest)
-			return
-		}
-	case dataprovider.AzureBlobFilesystemProvider:
-		if user.FsConfig.AzBlobConfig.AccountKey.IsRedacted() {
-			sendAPIResponse(w, r, errors.New("invalid account_key"), "", http.StatusBadRequest)
-			return
-		}
-	case dataprovider.CryptedFilesystemProvider:
-		if user.FsConfig.CryptConfig.Passphrase.IsRedacted() {
-			sendAPIResponse(w, r, errors.New("invalid passphrase"), "", http.StatusBadRequest)
-			return
-		}
-	case dataprovider.SFTPFilesystemProvider:
-		if user.FsConfig.SFTPConfig.Password.IsRedacted() {
-			sendAPIResponse(w, r, errors.New("invalid SFTP password"), "", http.StatusBadRequest)
-			return
-		}
-		if user.FsConfig.SFTPConfig.PrivateKey.IsRedacted() {
-			sendAPIResponse(w, r, errors.New("invalid SFTP private key"), "", http.StatusBadRequest)
-			return
-		}
+	if err := checkRedactedSecrets(&user); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	user.Filters.RecoveryCodes = nil
+	if !isUserInAdminScope(r, &user) {
+		sendAPIResponse(w, r, errors.New("the user must belong to at least one of your groups"), "", http.StatusForbidden)
+		return
 	}
 	err = dataprovider.AddUser(&user)
 	if err != nil {
@@ -124,6 +151,8 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	currentCryptoPassphrase := user.FsConfig.CryptConfig.Passphrase
 	currentSFTPPassword := user.FsConfig.SFTPConfig.Password
 	currentSFTPKey := user.FsConfig.SFTPConfig.PrivateKey
+	currentTOTPSecret := user.Filters.TOTPConfig.Secret
+	currentRecoveryCodes := user.Filters.RecoveryCodes
 
 	user.Permissions = make(map[string][]string)
 	user.FsConfig.S3Config = vfs.S3FsConfig{}
@@ -131,11 +160,18 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	user.FsConfig.GCSConfig = vfs.GCSFsConfig{}
 	user.FsConfig.CryptConfig = vfs.CryptFsConfig{}
 	user.FsConfig.SFTPConfig = vfs.SFTPFsConfig{}
+	user.Filters.TransferQuota = dataprovider.TransferQuota{}
+	user.Filters.TOTPConfig = dataprovider.TOTPConfig{}
 	err = render.DecodeJSON(r.Body, &user)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
 		return
 	}
+	// recovery codes can only be generated using the dedicated API
+	user.Filters.RecoveryCodes = currentRecoveryCodes
+	if user.Filters.TOTPConfig.Secret != nil && user.Filters.TOTPConfig.Secret.IsNotPlainAndNotEmpty() {
+		user.Filters.TOTPConfig.Secret = currentTOTPSecret
+	}
 	user.ID = userID
 	user.Username = username
 	user.SetEmptySecretsIfNil()
@@ -145,6 +181,10 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	}
 	updateEncryptedSecrets(&user, currentS3AccessSecret, currentAzAccountKey, currentGCSCredentials, currentCryptoPassphrase,
 		currentSFTPPassword, currentSFTPKey)
+	if !isUserInAdminScope(r, &user) {
+		sendAPIResponse(w, r, errors.New("the user must belong to at least one of your groups"), "", http.StatusForbidden)
+		return
+	}
 	err = dataprovider.UpdateUser(&user)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -156,6 +196,125 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func generateUserRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	username := getURLParam(r, "username")
+	user, err := dataprovider.UserExists(username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if !user.Filters.TOTPConfig.Enabled {
+		sendAPIResponse(w, r, nil, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	codes, recoveryCodes, err := dataprovider.GenerateRecoveryCodes()
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	user.Filters.RecoveryCodes = recoveryCodes
+	if err := dataprovider.UpdateUser(&user); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, codes)
+}
+
+type tempCredentialsRequest struct {
+	// time to live as seconds
+	TTL       int64  `json:"ttl"`
+	MaxUses   int    `json:"max_uses"`
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+type tempCredentialsResponse struct {
+	Username       string `json:"username"`
+	Password       string `json:"password,omitempty"`
+	ExpirationDate int64  `json:"expiration_date"`
+	MaxUses        int    `json:"max_uses"`
+}
+
+func addTempCredentials(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	var req tempCredentialsRequest
+	err := render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	user, password, err := dataprovider.AddTempCredentials(getURLParam(r, "username"),
+		time.Duration(req.TTL)*time.Second, req.MaxUses, req.PublicKey)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, tempCredentialsResponse{
+		Username:       user.Username,
+		Password:       password,
+		ExpirationDate: user.ExpirationDate,
+		MaxUses:        user.Filters.TempCredentials.MaxUses,
+	})
+}
+
+type generatedPasswordResponse struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func generateUserPassword(w http.ResponseWriter, r *http.Request) {
+	username := getURLParam(r, "username")
+	password, err := dataprovider.GenerateUserPassword(username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	render.JSON(w, r, generatedPasswordResponse{
+		Username: username,
+		Password: password,
+	})
+}
+
+type generatedKeyPairRequest struct {
+	// key type, empty means ecdsa
+	Type string `json:"type,omitempty"`
+	// replace the existing public keys instead of adding the generated one
+	Replace bool `json:"replace,omitempty"`
+}
+
+type generatedKeyPairResponse struct {
+	Username   string `json:"username"`
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+}
+
+func generateUserKeyPair(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	var req generatedKeyPairRequest
+	if r.ContentLength != 0 {
+		if err := render.DecodeJSON(r.Body, &req); err != nil {
+			sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Type == "" {
+		req.Type = utils.SSHKeyTypeECDSA
+	}
+	username := getURLParam(r, "username")
+	privateKey, publicKey, err := dataprovider.GenerateUserKeyPair(username, req.Type, req.Replace)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	render.JSON(w, r, generatedKeyPairResponse{
+		Username:   username,
+		PrivateKey: privateKey,
+		PublicKey:  publicKey,
+	})
+}
+
 func deleteUser(w http.ResponseWriter, r *http.Request) {
 	username := getURLParam(r, "username")
 	err := dataprovider.DeleteUser(username)
@@ -167,6 +326,17 @@ func deleteUser(w http.ResponseWriter, r *http.Request) {
 	disconnectUser(username)
 }
 
+func removeCachedUser(w http.ResponseWriter, r *http.Request) {
+	username := getURLParam(r, "username")
+	dataprovider.RemoveCachedUser(username)
+	sendAPIResponse(w, r, nil, "User removed from cache", http.StatusOK)
+}
+
+func clearUsersCache(w http.ResponseWriter, r *http.Request) {
+	removed := dataprovider.ClearUsersCache()
+	sendAPIResponse(w, r, nil, fmt.Sprintf("%v users removed from cache", removed), http.StatusOK)
+}
+
 func disconnectUser(username string) {
 	for _, stat := range common.Connections.GetStats() {
 		if stat.Username == username {
@@ -204,3 +374,164 @@ func updateEncryptedSecrets(user *dataprovider.User, currentS3AccessSecret, curr
 		}
 	}
 }
+
+// checkRedactedSecrets returns an error if the user has redacted secrets,
+// redacted secrets are not allowed for new users
+func checkRedactedSecrets(user *dataprovider.User) error {
+	switch user.FsConfig.Provider {
+	case dataprovider.S3FilesystemProvider:
+		if user.FsConfig.S3Config.AccessSecret.IsRedacted() {
+			return errors.New("invalid access_secret")
+		}
+	case dataprovider.GCSFilesystemProvider:
+		if user.FsConfig.GCSConfig.Credentials.IsRedacted() {
+			return errors.New("invalid credentials")
+		}
+	case dataprovider.AzureBlobFilesystemProvider:
+		if user.FsConfig.AzBlobConfig.AccountKey.IsRedacted() {
+			return errors.New("invalid account_key")
+		}
+	case dataprovider.CryptedFilesystemProvider:
+		if user.FsConfig.CryptConfig.Passphrase.IsRedacted() {
+			return errors.New("invalid passphrase")
+		}
+	case dataprovider.SFTPFilesystemProvider:
+		if user.FsConfig.SFTPConfig.Password.IsRedacted() {
+			return errors.New("invalid SFTP password")
+		}
+		if user.FsConfig.SFTPConfig.PrivateKey.IsRedacted() {
+			return errors.New("invalid SFTP private key")
+		}
+	}
+	return nil
+}
+
+func addUsersFromTemplate(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	err := r.ParseMultipartForm(maxRequestSize)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll() //nolint:errcheck
+
+	templateContent, err := getMultipartFormValue(r, "template")
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to read the user template", http.StatusBadRequest)
+		return
+	}
+	usersContent, err := getMultipartFormValue(r, "users")
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to read the users to add", http.StatusBadRequest)
+		return
+	}
+	users, err := GetUsersFromTemplate(templateContent, usersContent)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	for idx := range users {
+		if !isUserInAdminScope(r, &users[idx]) {
+			sendAPIResponse(w, r, errors.New("the users must belong to at least one of your groups"), "",
+				http.StatusForbidden)
+			return
+		}
+	}
+
+	for idx := range users {
+		if err := dataprovider.AddUser(&users[idx]); err != nil {
+			sendAPIResponse(w, r, err, fmt.Sprintf("Unable to add user %#v, users added before this error: %v",
+				users[idx].Username, idx), getRespStatus(err))
+			return
+		}
+	}
+	sendAPIResponse(w, r, nil, fmt.Sprintf("%v users added", len(users)), http.StatusCreated)
+}
+
+// GetUsersFromTemplate returns the users to add using the given JSON user template
+// and CSV users list. All the users are validated before returning, this way we don't
+// add only some of them for simple validation errors. The users are not added to the
+// data provider
+func GetUsersFromTemplate(templateContent, usersContent []byte) ([]dataprovider.User, error) {
+	var templateUser dataprovider.User
+	err := json.Unmarshal(templateContent, &templateUser)
+	if err != nil {
+		return nil, dataprovider.NewValidationError(fmt.Sprintf("unable to parse the user template: %v", err))
+	}
+	templateUser.SetEmptySecretsIfNil()
+	if err := checkRedactedSecrets(&templateUser); err != nil {
+		return nil, dataprovider.NewValidationError(err.Error())
+	}
+	userTmplFields, err := getUsersForTemplateFromCSV(usersContent)
+	if err != nil {
+		return nil, dataprovider.NewValidationError(fmt.Sprintf("unable to parse the users to add: %v", err))
+	}
+	if len(userTmplFields) == 0 {
+		return nil, dataprovider.NewValidationError("no users to add")
+	}
+	users := make([]dataprovider.User, 0, len(userTmplFields))
+	for _, tmpl := range userTmplFields {
+		u := getUserFromTemplate(templateUser, tmpl)
+		if err := dataprovider.ValidateUser(&u); err != nil {
+			if validationErr, ok := err.(*dataprovider.ValidationError); ok {
+				return nil, dataprovider.NewValidationError(fmt.Sprintf("user %#v: %v", u.Username,
+					validationErr.GetErrorString()))
+			}
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// getMultipartFormValue returns the content for the specified multipart form field,
+// the field can be an uploaded file or a simple value
+func getMultipartFormValue(r *http.Request, name string) ([]byte, error) {
+	file, _, err := r.FormFile(name)
+	if err == nil {
+		defer file.Close()
+		return ioutil.ReadAll(file)
+	}
+	if value := r.FormValue(name); value != "" {
+		return []byte(value), nil
+	}
+	return nil, fmt.Errorf("missing form field %#v", name)
+}
+
+// getUsersForTemplateFromCSV parses the given CSV content. Each record must contain
+// the username and optionally the password and the public key.
+// A header, if present, is ignored, duplicated usernames are ignored too
+func getUsersForTemplateFromCSV(content []byte) ([]userTemplateFields, error) {
+	var res []userTemplateFields
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return res, err
+	}
+	users := make(map[string]bool)
+	for idx, record := range records {
+		username := strings.TrimSpace(record[0])
+		if username == "" || (idx == 0 && username == "username") {
+			continue
+		}
+		if _, ok := users[username]; ok {
+			continue
+		}
+		var password, publicKey string
+		if len(record) > 1 {
+			password = strings.TrimSpace(record[1])
+		}
+		if len(record) > 2 {
+			publicKey = strings.TrimSpace(record[2])
+		}
+		users[username] = true
+		res = append(res, userTemplateFields{
+			Username:  username,
+			Password:  password,
+			PublicKey: publicKey,
+		})
+	}
+	return res, nil
+}
@@ -48,11 +48,19 @@ func handleCloseConnection(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, nil, "connectionID is mandatory", http.StatusBadRequest)
 		return
 	}
-	if common.Connections.Close(connectionID) {
-		sendAPIResponse(w, r, nil, "Connection closed", http.StatusOK)
-	} else {
-		sendAPIResponse(w, r, nil, "Not Found", http.StatusNotFound)
+	// restricted admins can only close the connections of the users in their scope
+	stats, err := getConnectionsInAdminScope(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	for _, stat := range stats {
+		if stat.ConnectionID == connectionID && common.Connections.Close(connectionID) {
+			sendAPIResponse(w, r, nil, "Connection closed", http.StatusOK)
+			return
+		}
 	}
+	sendAPIResponse(w, r, nil, "Not Found", http.StatusNotFound)
 }
 
 func getSearchFilters(w http.ResponseWriter, r *http.Request) (int, int, string, error) {
@@ -1,6 +1,7 @@
 package httpd
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -18,33 +19,56 @@ import (
 type tokenAudience = string
 
 const (
-	tokenAudienceWeb  tokenAudience = "Web"
-	tokenAudienceAPI  tokenAudience = "API"
-	tokenAudienceCSRF tokenAudience = "CSRF"
+	tokenAudienceWeb     tokenAudience = "Web"
+	tokenAudienceAPI     tokenAudience = "API"
+	tokenAudienceAPIUser tokenAudience = "APIUser"
+	tokenAudienceCSRF    tokenAudience = "CSRF"
 )
 
 const (
 	claimUsernameKey    = "username"
 	claimPermissionsKey = "permissions"
+	claimGroupsKey      = "groups"
+	claimUsersKey       = "users"
+	claimRestrictionKey = "restrictions"
 	basicRealm          = "Basic realm=\"SFTPGo\""
+	apiKeyHeader        = "X-SFTPGO-API-KEY"
+	otpHeader           = "X-SFTPGO-OTP"
 )
 
 var (
-	tokenDuration   = 10 * time.Minute
-	tokenRefreshMin = 5 * time.Minute
+	tokenDuration              = 10 * time.Minute
+	tokenRefreshMin            = 5 * time.Minute
+	restrictedTokenDeniedPerms = []string{dataprovider.PermAdminAny, dataprovider.PermAdminManageAdmins,
+		dataprovider.PermAdminManageAPIKeys}
 )
 
 type jwtTokenClaims struct {
 	Username    string
 	Permissions []string
+	Groups      []string
+	Users       []string
 	Signature   string
+	// restrictions applied to the token, they are preserved if the token is refreshed
+	Restrictions *dataprovider.APIKeyRestrictions
 }
 
 func (c *jwtTokenClaims) asMap() map[string]interface{} {
 	claims := make(map[string]interface{})
 
 	claims[claimUsernameKey] = c.Username
-	claims[claimPermissionsKey] = c.Permissions
+	if len(c.Permissions) > 0 {
+		claims[claimPermissionsKey] = c.Permissions
+	}
+	if len(c.Groups) > 0 {
+		claims[claimGroupsKey] = c.Groups
+	}
+	if len(c.Users) > 0 {
+		claims[claimUsersKey] = c.Users
+	}
+	if c.Restrictions != nil {
+		claims[claimRestrictionKey] = c.Restrictions
+	}
 	claims[jwt.SubjectKey] = c.Signature
 
 	return claims
@@ -75,6 +99,74 @@ func (c *jwtTokenClaims) Decode(token map[string]interface{}) {
 			}
 		}
 	}
+
+	groups := token[claimGroupsKey]
+	switch v := groups.(type) {
+	case []interface{}:
+		for _, elem := range v {
+			switch elemValue := elem.(type) {
+			case string:
+				c.Groups = append(c.Groups, elemValue)
+			}
+		}
+	}
+
+	users := token[claimUsersKey]
+	switch v := users.(type) {
+	case []interface{}:
+		for _, elem := range v {
+			switch elemValue := elem.(type) {
+			case string:
+				c.Users = append(c.Users, elemValue)
+			}
+		}
+	}
+
+	if restrictions, ok := token[claimRestrictionKey]; ok {
+		// the restrictions are decoded as a generic map, we convert them back
+		data, err := json.Marshal(restrictions)
+		if err == nil {
+			var r dataprovider.APIKeyRestrictions
+			if err := json.Unmarshal(data, &r); err == nil {
+				c.Restrictions = &r
+			}
+		}
+	}
+}
+
+// applyRestrictions limits the claims permissions and scope to the given restrictions
+func (c *jwtTokenClaims) applyRestrictions(restrictions *dataprovider.APIKeyRestrictions) error {
+	if restrictions == nil || restrictions.IsEmpty() {
+		return nil
+	}
+	c.Permissions = restrictions.GetPermissions(c.Permissions)
+	if len(c.Permissions) == 0 {
+		return errors.New("the admin has none of the permissions allowed by the restrictions")
+	}
+	groups, err := restrictions.GetGroups(c.Groups)
+	if err != nil {
+		return err
+	}
+	c.Groups = groups
+	c.Users = restrictions.Users
+	c.Restrictions = restrictions
+	return nil
+}
+
+// isRestricted returns true if the claims are restricted to some users and/or groups
+func (c *jwtTokenClaims) isRestricted() bool {
+	return len(c.Groups) > 0 || len(c.Users) > 0
+}
+
+// isUserInScope returns true if the given user can be managed using these claims
+func (c *jwtTokenClaims) isUserInScope(user *dataprovider.User) bool {
+	if len(c.Users) > 0 && !utils.IsStringInSlice(user.Username, c.Users) {
+		return false
+	}
+	if len(c.Groups) > 0 && !user.IsInGroups(c.Groups) {
+		return false
+	}
+	return true
 }
 
 func (c *jwtTokenClaims) isCriticalPermRemoved(permissions []string) bool {
@@ -90,7 +182,36 @@ func (c *jwtTokenClaims) isCriticalPermRemoved(permissions []string) bool {
 	return false
 }
 
+// isGroupsScopeChanged returns true if the groups in the claims do not match the
+// given admin groups, restricted as when the token was issued. The token scope is
+// no longer valid and so the token must be invalidated, as for the removal of a
+// critical permission
+func (c *jwtTokenClaims) isGroupsScopeChanged(adminGroups []string) bool {
+	groups := adminGroups
+	if c.Restrictions != nil && !c.Restrictions.IsEmpty() {
+		restrictedGroups, err := c.Restrictions.GetGroups(adminGroups)
+		if err != nil {
+			return true
+		}
+		groups = restrictedGroups
+	}
+	if len(groups) != len(c.Groups) {
+		return true
+	}
+	for _, group := range groups {
+		if !utils.IsStringInSlice(group, c.Groups) {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *jwtTokenClaims) hasPerm(perm string) bool {
+	// a token restricted by an API key cannot manage admins and API keys,
+	// the created objects could have more permissions than the token itself
+	if c.Restrictions != nil && utils.IsStringInSlice(perm, restrictedTokenDeniedPerms) {
+		return false
+	}
 	if utils.IsStringInSlice(dataprovider.PermAdminAny, c.Permissions) {
 		return true
 	}
@@ -178,6 +299,30 @@ func invalidateToken(r *http.Request) {
 	}
 }
 
+// isUserInAdminScope returns true if the logged in admin can manage the given user
+func isUserInAdminScope(r *http.Request, user *dataprovider.User) bool {
+	claims, err := getTokenClaims(r)
+	if err != nil {
+		return false
+	}
+	return claims.isUserInScope(user)
+}
+
+// getUsersInAdminScope returns the users matching the specified filters
+// restricted to the users the logged in admin can manage
+func getUsersInAdminScope(r *http.Request, limit, offset int, order string,
+	filters *dataprovider.UserSearchFilters,
+) ([]dataprovider.User, error) {
+	claims, err := getTokenClaims(r)
+	if err != nil {
+		return nil, err
+	}
+	searchFilters := *filters
+	searchFilters.Usernames = claims.Users
+	searchFilters.Groups = claims.Groups
+	return dataprovider.SearchUsers(searchFilters, limit, offset, order)
+}
+
 func getAdminFromToken(r *http.Request) *dataprovider.Admin {
 	admin := &dataprovider.Admin{}
 	_, claims, err := jwtauth.FromContext(r.Context())
@@ -188,6 +333,7 @@ func getAdminFromToken(r *http.Request) *dataprovider.Admin {
 	tokenClaims.Decode(claims)
 	admin.Username = tokenClaims.Username
 	admin.Permissions = tokenClaims.Permissions
+	admin.Filters.Groups = tokenClaims.Groups
 	return admin
 }
 
@@ -0,0 +1,114 @@
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultBrandingName      = "SFTPGo"
+	defaultBrandingShortName = "SFTPGo Web"
+)
+
+// Branding defines the customizations to white-label the web admin
+type Branding struct {
+	// Path to a directory containing the branding files, for example the logo,
+	// the favicon and the CSS files. This can be an absolute path or a path relative
+	// to the config dir. The files inside this directory are served under "/branding"
+	Path string `json:"path" mapstructure:"path"`
+	// Name replaces "SFTPGo" in the page titles and in the login page
+	Name string `json:"name" mapstructure:"name"`
+	// ShortName replaces "SFTPGo Web" in the sidebar
+	ShortName string `json:"short_name" mapstructure:"short_name"`
+	// Path to the favicon, relative to the branding directory
+	FaviconPath string `json:"favicon_path" mapstructure:"favicon_path"`
+	// Path to the logo, relative to the branding directory.
+	// The logo is displayed in the sidebar and in the login page
+	LogoPath string `json:"logo_path" mapstructure:"logo_path"`
+	// Paths to additional CSS files, relative to the branding directory.
+	// They are loaded after the default CSS files
+	ExtraCSS []string `json:"extra_css" mapstructure:"extra_css"`
+}
+
+// brandingData defines the branding to use to render the web pages
+type brandingData struct {
+	Name       string
+	ShortName  string
+	FaviconURL string
+	LogoURL    string
+	ExtraCSS   []string
+	// directory to serve the branding files from, empty if not configured
+	dir string
+}
+
+var webBranding = getDefaultBrandingData()
+
+func getDefaultBrandingData() brandingData {
+	return brandingData{
+		Name:       defaultBrandingName,
+		ShortName:  defaultBrandingShortName,
+		FaviconURL: path.Join(webStaticFilesPath, "favicon.ico"),
+	}
+}
+
+func (c *Branding) initialize(configDir string) error {
+	data := getDefaultBrandingData()
+	if c.Name != "" {
+		data.Name = c.Name
+	}
+	if c.ShortName != "" {
+		data.ShortName = c.ShortName
+	}
+	data.dir = getConfigPath(c.Path, configDir)
+	if data.dir == "" {
+		if c.FaviconPath != "" || c.LogoPath != "" || len(c.ExtraCSS) > 0 {
+			return errors.New("branding: a directory is required to load the branding files")
+		}
+		webBranding = data
+		return nil
+	}
+	info, err := os.Stat(data.dir)
+	if err != nil {
+		return fmt.Errorf("branding: unable to access the directory %#v: %w", data.dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("branding: %#v is not a directory", data.dir)
+	}
+	if c.FaviconPath != "" {
+		if data.FaviconURL, err = getBrandingFileURL(data.dir, c.FaviconPath); err != nil {
+			return err
+		}
+	}
+	if c.LogoPath != "" {
+		if data.LogoURL, err = getBrandingFileURL(data.dir, c.LogoPath); err != nil {
+			return err
+		}
+	}
+	for _, css := range c.ExtraCSS {
+		cssURL, err := getBrandingFileURL(data.dir, css)
+		if err != nil {
+			return err
+		}
+		data.ExtraCSS = append(data.ExtraCSS, cssURL)
+	}
+	webBranding = data
+	return nil
+}
+
+// getBrandingFileURL returns the URL for the given file, relative to the branding
+// directory. An error is returned if the file does not exist
+func getBrandingFileURL(dir, name string) (string, error) {
+	name = path.Clean("/" + strings.TrimSpace(filepath.ToSlash(name)))
+	info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return "", fmt.Errorf("branding: unable to access the file %#v: %w", name, err)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("branding: %#v is not a file", name)
+	}
+	return webBrandingPath + name, nil
+}
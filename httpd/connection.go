@@ -0,0 +1,449 @@
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+var errTransferAborted = errors.New("transfer aborted")
+
+// Connection details for a server side connection used by the web admin
+// to access a user's filesystem on behalf of an admin and by the user API
+type Connection struct {
+	*common.BaseConnection
+	request *http.Request
+	// the admin that is browsing the user's files, empty for the user API
+	admin string
+}
+
+// GetClientVersion returns the connected client's version.
+func (c *Connection) GetClientVersion() string {
+	if c.request != nil {
+		return c.request.UserAgent()
+	}
+	return ""
+}
+
+// GetRemoteAddress return the connected client's address
+func (c *Connection) GetRemoteAddress() string {
+	if c.request != nil {
+		return c.request.RemoteAddr
+	}
+	return ""
+}
+
+// Disconnect closes the active transfer
+func (c *Connection) Disconnect() error {
+	return c.SignalTransfersAbort()
+}
+
+// GetCommand returns the request method
+func (c *Connection) GetCommand() string {
+	if c.request != nil {
+		return strings.ToUpper(c.request.Method)
+	}
+	return ""
+}
+
+// ReadDir returns the content of the directory with the given virtual path
+func (c *Connection) ReadDir(name string) ([]os.FileInfo, error) {
+	c.UpdateLastActivity()
+
+	name = utils.CleanPath(name)
+	p, err := c.Fs.ResolvePath(name)
+	if err != nil {
+		return nil, c.GetFsError(err)
+	}
+	return c.ListDir(p, name)
+}
+
+// stat returns the info for the file or directory with the given virtual path
+func (c *Connection) stat(name string) (os.FileInfo, error) {
+	c.UpdateLastActivity()
+
+	p, err := c.Fs.ResolvePath(utils.CleanPath(name))
+	if err != nil {
+		return nil, c.GetFsError(err)
+	}
+	info, err := c.DoStat(p, 0)
+	if err != nil {
+		return nil, c.GetFsError(err)
+	}
+	return info, nil
+}
+
+// getFileReader returns a reader for the file with the given virtual path.
+// The returned reader is a download transfer, it must be closed
+func (c *Connection) getFileReader(name string) (*httpdFile, os.FileInfo, error) {
+	c.UpdateLastActivity()
+
+	name = utils.CleanPath(name)
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(name)) {
+		return nil, nil, c.GetPermissionDeniedError()
+	}
+	if !c.User.IsFileAllowed(name) {
+		c.Log(logger.LevelWarn, "reading file %#v is not allowed", name)
+		return nil, nil, c.GetPermissionDeniedError()
+	}
+	if err := c.CheckTransfersLimit(); err != nil {
+		return nil, nil, err
+	}
+	p, err := c.Fs.ResolvePath(name)
+	if err != nil {
+		return nil, nil, c.GetFsError(err)
+	}
+	info, err := c.DoStat(p, 0)
+	if err != nil {
+		return nil, nil, c.GetFsError(err)
+	}
+	if info.IsDir() {
+		return nil, nil, common.ErrOpUnsupported
+	}
+	file, r, cancelFn, err := c.Fs.Open(p, 0)
+	if err != nil {
+		c.Log(logger.LevelWarn, "could not open file %#v for reading: %+v", p, err)
+		return nil, nil, c.GetFsError(err)
+	}
+	baseTransfer, err := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, p, name, common.TransferDownload,
+		0, 0, 0, false, c.Fs)
+	if err != nil {
+		if r != nil {
+			r.Close() //nolint:errcheck
+		}
+		return nil, nil, err
+	}
+	baseTransfer.SetExpectedSize(info.Size())
+	var reader io.ReadCloser = file
+	if file == nil {
+		reader = r
+	}
+	return &httpdFile{
+		BaseTransfer: baseTransfer,
+		reader:       reader,
+	}, info, nil
+}
+
+// removeFile removes the file with the given virtual path
+func (c *Connection) removeFile(name string) error {
+	c.UpdateLastActivity()
+
+	name = utils.CleanPath(name)
+	p, err := c.Fs.ResolvePath(name)
+	if err != nil {
+		return c.GetFsError(err)
+	}
+	info, err := c.Fs.Lstat(p)
+	if err != nil {
+		c.Log(logger.LevelWarn, "failed to remove file %#v: stat error: %+v", p, err)
+		return c.GetFsError(err)
+	}
+	if info.IsDir() {
+		c.Log(logger.LevelDebug, "cannot remove %#v is not a file/symlink", p)
+		return common.ErrOpUnsupported
+	}
+	return c.RemoveFile(p, name, info)
+}
+
+// checkUpload returns an error if a file with the given virtual path and size
+// cannot be uploaded. The same checks are done again when the upload starts
+func (c *Connection) checkUpload(name string, size int64) error {
+	name = utils.CleanPath(name)
+	if !c.User.IsFileAllowed(name) {
+		c.Log(logger.LevelWarn, "writing file %#v is not allowed", name)
+		return c.GetPermissionDeniedError()
+	}
+	p, err := c.Fs.ResolvePath(name)
+	if err != nil {
+		return c.GetFsError(err)
+	}
+	var fileSize int64
+	stat, statErr := c.Fs.Lstat(p)
+	if (statErr == nil && stat.Mode()&os.ModeSymlink != 0) || c.Fs.IsNotExist(statErr) {
+		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(name)) {
+			return c.GetPermissionDeniedError()
+		}
+	} else {
+		if statErr != nil {
+			return c.GetFsError(statErr)
+		}
+		if stat.IsDir() {
+			return c.GetOpUnsupportedError()
+		}
+		if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(name)) {
+			return c.GetPermissionDeniedError()
+		}
+		fileSize = stat.Size()
+	}
+	quotaResult := c.HasSpace(fileSize == 0, false, name)
+	if !quotaResult.HasSpace {
+		return common.ErrQuotaExceeded
+	}
+	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, fileSize)
+	if maxWriteSize > 0 && size > maxWriteSize {
+		return common.ErrQuotaExceeded
+	}
+	return nil
+}
+
+// getFileWriter returns a writer for the file with the given virtual path.
+// The returned writer is an upload transfer, it must be closed
+func (c *Connection) getFileWriter(name string) (*httpdFile, error) {
+	c.UpdateLastActivity()
+
+	name = utils.CleanPath(name)
+	if !c.User.IsFileAllowed(name) {
+		c.Log(logger.LevelWarn, "writing file %#v is not allowed", name)
+		return nil, c.GetPermissionDeniedError()
+	}
+	if err := c.CheckTransfersLimit(); err != nil {
+		return nil, err
+	}
+	p, err := c.Fs.ResolvePath(name)
+	if err != nil {
+		return nil, c.GetFsError(err)
+	}
+	filePath := p
+	if common.Config.IsAtomicUploadEnabled() && c.Fs.IsAtomicUploadSupported() {
+		filePath = c.Fs.GetAtomicUploadPath(p)
+	}
+
+	stat, statErr := c.Fs.Lstat(p)
+	if (statErr == nil && stat.Mode()&os.ModeSymlink != 0) || c.Fs.IsNotExist(statErr) {
+		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(name)) {
+			return nil, c.GetPermissionDeniedError()
+		}
+		return c.handleUploadToNewFile(p, filePath, name)
+	}
+
+	if statErr != nil {
+		c.Log(logger.LevelError, "error performing file stat %#v: %+v", p, statErr)
+		return nil, c.GetFsError(statErr)
+	}
+
+	// This happen if we upload a file that has the same name of an existing directory
+	if stat.IsDir() {
+		c.Log(logger.LevelWarn, "attempted to open a directory for writing to: %#v", p)
+		return nil, c.GetOpUnsupportedError()
+	}
+
+	if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(name)) {
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	return c.handleUploadToExistingFile(p, filePath, stat.Size(), name)
+}
+
+func (c *Connection) handleUploadToNewFile(resolvedPath, filePath, requestPath string) (*httpdFile, error) {
+	quotaResult := c.HasSpace(true, false, requestPath)
+	if !quotaResult.HasSpace {
+		c.Log(logger.LevelInfo, "denying file write due to quota limits")
+		return nil, common.ErrQuotaExceeded
+	}
+	if err := c.CheckDirectoryLimit(requestPath); err != nil {
+		return nil, err
+	}
+	file, w, cancelFn, err := c.Fs.Create(filePath, 0)
+	if err != nil {
+		c.Log(logger.LevelWarn, "error creating file %#v: %+v", resolvedPath, err)
+		return nil, c.GetFsError(err)
+	}
+
+	vfs.SetPathPermissions(c.Fs, filePath, c.User.GetUID(), c.User.GetGID())
+
+	// we can get an error only for resume
+	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, 0)
+
+	baseTransfer, err := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
+		common.TransferUpload, 0, 0, maxWriteSize, true, c.Fs)
+	if err != nil {
+		return nil, err
+	}
+
+	return newHTTPDUploadFile(baseTransfer, w), nil
+}
+
+func (c *Connection) handleUploadToExistingFile(resolvedPath, filePath string, fileSize int64,
+	requestPath string) (*httpdFile, error) {
+	var err error
+	quotaResult := c.HasSpace(false, false, requestPath)
+	if !quotaResult.HasSpace {
+		c.Log(logger.LevelInfo, "denying file write due to quota limits")
+		return nil, common.ErrQuotaExceeded
+	}
+
+	// if there is a size limit remaining size cannot be 0 here, since quotaResult.HasSpace
+	// will return false in this case and we deny the upload before
+	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, fileSize)
+
+	if common.Config.IsAtomicUploadEnabled() && c.Fs.IsAtomicUploadSupported() {
+		err = c.Fs.Rename(resolvedPath, filePath)
+		if err != nil {
+			c.Log(logger.LevelWarn, "error renaming existing file for atomic upload, source: %#v, dest: %#v, err: %+v",
+				resolvedPath, filePath, err)
+			return nil, c.GetFsError(err)
+		}
+	}
+
+	file, w, cancelFn, err := c.Fs.Create(filePath, 0)
+	if err != nil {
+		c.Log(logger.LevelWarn, "error creating file %#v: %+v", resolvedPath, err)
+		return nil, c.GetFsError(err)
+	}
+	initialSize := int64(0)
+	if vfs.IsLocalOrSFTPFs(c.Fs) {
+		vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
+		if err == nil {
+			dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
+			if vfolder.IsIncludedInUserQuota() {
+				dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
+			}
+		} else {
+			dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
+		}
+	} else {
+		initialSize = fileSize
+	}
+
+	vfs.SetPathPermissions(c.Fs, filePath, c.User.GetUID(), c.User.GetGID())
+
+	baseTransfer, err := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
+		common.TransferUpload, 0, initialSize, maxWriteSize, false, c.Fs)
+	if err != nil {
+		return nil, err
+	}
+
+	return newHTTPDUploadFile(baseTransfer, w), nil
+}
+
+// httpdFile is a download transfer started from the web admin or an
+// upload transfer started from the user API
+type httpdFile struct {
+	*common.BaseTransfer
+	reader     io.ReadCloser
+	writer     io.WriteCloser
+	isFinished bool
+}
+
+func newHTTPDUploadFile(baseTransfer *common.BaseTransfer, pipeWriter *vfs.PipeWriter) *httpdFile {
+	var writer io.WriteCloser = baseTransfer.File
+	if baseTransfer.File == nil {
+		writer = pipeWriter
+	}
+	return &httpdFile{
+		BaseTransfer: baseTransfer,
+		writer:       writer,
+	}
+}
+
+// Read reads the contents to downloads.
+func (f *httpdFile) Read(p []byte) (n int, err error) {
+	if atomic.LoadInt32(&f.AbortTransfer) == 1 {
+		return 0, errTransferAborted
+	}
+
+	f.Connection.UpdateLastActivity()
+
+	n, err = f.reader.Read(p)
+	atomic.AddInt64(&f.BytesSent, int64(n))
+
+	if err == nil {
+		err = f.CheckRead()
+	}
+	if err != nil && err != io.EOF {
+		f.TransferError(err)
+		return
+	}
+	f.HandleThrottle()
+	return
+}
+
+// Write writes the uploaded contents.
+func (f *httpdFile) Write(p []byte) (n int, err error) {
+	if atomic.LoadInt32(&f.AbortTransfer) == 1 {
+		return 0, errTransferAborted
+	}
+
+	f.Connection.UpdateLastActivity()
+
+	n, err = f.writer.Write(p)
+	atomic.AddInt64(&f.BytesReceived, int64(n))
+
+	if err == nil {
+		err = f.CheckWrite()
+	}
+	if err != nil {
+		f.TransferError(err)
+		return
+	}
+	f.HandleThrottle()
+	return
+}
+
+// Close closes the current transfer
+func (f *httpdFile) Close() error {
+	f.Lock()
+	if f.isFinished {
+		f.Unlock()
+		return common.ErrTransferClosed
+	}
+	f.isFinished = true
+	f.Unlock()
+
+	var err error
+	if f.writer != nil {
+		err = f.writer.Close()
+		f.Lock()
+		// we set ErrTransfer here so quota is not updated, in this case the uploads are atomic
+		if err != nil && f.ErrTransfer == nil {
+			f.ErrTransfer = err
+		}
+		f.Unlock()
+	} else {
+		err = f.reader.Close()
+	}
+	errBaseClose := f.BaseTransfer.Close()
+	if errBaseClose != nil {
+		err = errBaseClose
+	}
+	return f.Connection.GetFsError(err)
+}
+
+// newUserConnection returns a connection for the user API, the user's root
+// directory is created if missing
+func newUserConnection(r *http.Request, user dataprovider.User) (*Connection, error) {
+	connection, err := newAdminConnection(r, user, "")
+	if err != nil {
+		return nil, err
+	}
+	connection.Fs.CheckRootPath(user.Username, user.GetUID(), user.GetGID())
+	return connection, nil
+}
+
+func newAdminConnection(r *http.Request, user dataprovider.User, admin string) (*Connection, error) {
+	connectionID := fmt.Sprintf("%v_%v", common.ProtocolHTTP, xid.New().String())
+	fs, err := user.GetFilesystem(connectionID)
+	if err != nil {
+		return nil, err
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connectionID, common.ProtocolHTTP, user, fs),
+		request:        r,
+		admin:          admin,
+	}
+	connection.SetRemoteAddress(r.RemoteAddr)
+	return connection, nil
+}
@@ -1,6 +1,6 @@
 // Package httpd implements REST API and Web interface for SFTPGo.
 // The OpenAPI 3 schema for the exposed API can be found inside the source tree:
-// https://github.com/drakkan/sftpgo/blob/main/httpd/schema/openapi.yaml
+// https://github.com/drakkan/sftpgo/blob/main/openapi/openapi.yaml
 // A basic Web interface to manage users and connections is provided too
 package httpd
 
@@ -29,8 +29,11 @@ import (
 const (
 	logSender                 = "httpd"
 	tokenPath                 = "/api/v2/token"
+	tokenRefreshPath          = "/api/v2/token/refresh"
 	logoutPath                = "/api/v2/logout"
 	activeConnectionsPath     = "/api/v2/connections"
+	connectionHistoryPath     = "/api/v2/connections/history"
+	auditEventsPath           = "/api/v2/events"
 	quotaScanPath             = "/api/v2/quota-scans"
 	quotaScanVFolderPath      = "/api/v2/folder-quota-scans"
 	userPath                  = "/api/v2/users"
@@ -44,8 +47,34 @@ const (
 	defenderBanTime           = "/api/v2/defender/bantime"
 	defenderUnban             = "/api/v2/defender/unban"
 	defenderScore             = "/api/v2/defender/score"
+	ipListsReloadPath         = "/api/v2/iplists/reload"
 	adminPath                 = "/api/v2/admins"
 	adminPwdPath              = "/api/v2/changepwd/admin"
+	apiKeysPath               = "/api/v2/apikeys"
+	sharesPath                = "/api/v2/shares"
+	publicSharesPath          = "/api/v2/publicshares"
+	userTemplatePath          = "/api/v2/template/users"
+	usersCSVPath              = "/api/v2/csv/users"
+	usersBulkPath             = "/api/v2/bulk/users"
+	usersCachePath            = "/api/v2/cache/users"
+	retentionBasePath         = "/api/v2/retention/users"
+	retentionChecksPath       = "/api/v2/retention/users/checks"
+	retentionFoldersBasePath  = "/api/v2/retention/folders"
+	retentionFolderChecksPath = "/api/v2/retention/folders/checks"
+	logLevelPath              = "/api/v2/logs/level"
+	debugLogsPath             = "/api/v2/logs/debug"
+	diagnosticsPath           = "/api/v2/diagnostics"
+	userTokenPath             = "/api/v2/user/token"
+	userTokenRefreshPath      = "/api/v2/user/token/refresh"
+	userLogoutPath            = "/api/v2/user/logout"
+	userProfilePath           = "/api/v2/user/profile"
+	userSharesPath            = "/api/v2/user/shares"
+	userUploadsPath           = "/api/v2/user/uploads"
+	userPwdPath               = "/api/v2/user/changepwd"
+	userPublicKeysPath        = "/api/v2/user/publickeys"
+	userTOTPPath              = "/api/v2/user/2fa/totp"
+	userTOTPGeneratePath      = "/api/v2/user/2fa/totp/generate"
+	userRecoveryCodesPath     = "/api/v2/user/2fa/recoverycodes"
 	healthzPath               = "/healthz"
 	webBasePath               = "/web"
 	webLoginPath              = "/web/login"
@@ -64,9 +93,20 @@ const (
 	webScanVFolderPath        = "/web/folder-quota-scans"
 	webQuotaScanPath          = "/web/quota-scans"
 	webChangeAdminPwdPath     = "/web/changepwd/admin"
+	webMFAPath                = "/web/mfa"
 	webTemplateUser           = "/web/template/user"
 	webTemplateFolder         = "/web/template/folder"
+	webUsersCSVPath           = "/web/csv/users"
+	webUsersCSVImportPath     = "/web/csv/users/import"
+	webOIDCBasePath           = "/web/oidc"
+	webOIDCLoginPath          = "/web/oidc/login"
+	webOIDCRedirectPath       = "/web/oidc/redirect"
+	webOIDCPasscodePath       = "/web/oidc/passcode"
+	webWebAuthnLoginPath      = "/web/webauthn/login"
+	webWebAuthnVerifyPath     = "/web/webauthn/verify"
 	webStaticFilesPath        = "/static"
+	webBrandingPath           = "/branding"
+	webOpenAPIPath            = "/openapi"
 	// MaxRestoreSize defines the max size for the loaddata input file
 	MaxRestoreSize = 10485760 // 10 MB
 	maxRequestSize = 1048576  // 1MB
@@ -91,9 +131,14 @@ type Binding struct {
 	// Enable the built-in admin interface.
 	// You have to define TemplatesPath and StaticFilesPath for this to work
 	EnableWebAdmin bool `json:"enable_web_admin" mapstructure:"enable_web_admin"`
+	// Enable to serve the OpenAPI schema and the Swagger UI.
+	// You have to define OpenAPIPath for this to work
+	RenderOpenAPI bool `json:"render_openapi" mapstructure:"render_openapi"`
 	// you also need to provide a certificate for enabling HTTPS
 	EnableHTTPS bool `json:"enable_https" mapstructure:"enable_https"`
 	// set to 1 to require client certificate authentication in addition to basic auth.
+	// Set to 2 to verify the client certificate only if provided, this way the admins
+	// can be bound to their certificates while the other clients can connect without one.
 	// You need to define at least a certificate authority for this to work
 	ClientAuthType int `json:"client_auth_type" mapstructure:"client_auth_type"`
 	// TLSCipherSuites is a list of supported cipher suites for TLS version 1.2.
@@ -107,6 +152,28 @@ type Binding struct {
 	// any invalid name will be silently ignored.
 	// The order matters, the ciphers listed first will be the preferred ones.
 	TLSCipherSuites []string `json:"tls_cipher_suites" mapstructure:"tls_cipher_suites"`
+	// List of IP addresses and IP ranges, in CIDR notation, of the trusted reverse proxies.
+	// The client IP address is read from the ClientIPProxyHeader only for the requests
+	// coming from these proxies, for the other requests the proxy headers are ignored
+	ProxyAllowed []string `json:"proxy_allowed" mapstructure:"proxy_allowed"`
+	// The header to read the client IP address from, for the requests coming from a
+	// trusted proxy. Supported headers: X-Forwarded-For, X-Real-IP, CF-Connecting-IP.
+	// Empty means X-Forwarded-For
+	ClientIPProxyHeader string `json:"client_ip_proxy_header" mapstructure:"client_ip_proxy_header"`
+	// For X-Forwarded-For, the number of addresses to skip starting from the right.
+	// 0 means the address added by the trusted proxy, increase it if the request
+	// goes through multiple trusted proxies
+	ClientIPHeaderDepth int `json:"client_ip_header_depth" mapstructure:"client_ip_header_depth"`
+	trustedProxies      *common.TrustedProxies
+}
+
+func (b *Binding) parseTrustedProxies() error {
+	trustedProxies, err := common.NewTrustedProxies(b.ProxyAllowed, b.ClientIPProxyHeader, b.ClientIPHeaderDepth)
+	if err != nil {
+		return fmt.Errorf("invalid proxy configuration for binding %#v: %w", b.GetAddress(), err)
+	}
+	b.trustedProxies = trustedProxies
+	return nil
 }
 
 // GetAddress returns the binding address
@@ -151,6 +218,9 @@ type Conf struct {
 	// Path to the static files for the web interface. This can be an absolute path or a path relative to the config dir.
 	// If both TemplatesPath and StaticFilesPath are empty the built-in web interface will be disabled
 	StaticFilesPath string `json:"static_files_path" mapstructure:"static_files_path"`
+	// Path to the directory containing the OpenAPI schema and the Swagger UI. This can be an absolute path
+	// or a path relative to the config dir. If empty the OpenAPI schema and the Swagger UI will not be served
+	OpenAPIPath string `json:"openapi_path" mapstructure:"openapi_path"`
 	// Path to the backup directory. This can be an absolute path or a path relative to the config dir
 	BackupsPath string `json:"backups_path" mapstructure:"backups_path"`
 	// If files containing a certificate and matching private key for the server are provided the server will expect
@@ -164,6 +234,15 @@ type Conf struct {
 	// CARevocationLists defines a set a revocation lists, one for each root CA, to be used to check
 	// if a client certificate has been revoked
 	CARevocationLists []string `json:"ca_revocation_lists" mapstructure:"ca_revocation_lists"`
+	// OIDC defines the OpenID Connect configuration to login to the web admin
+	OIDC OIDC `json:"oidc" mapstructure:"oidc"`
+	// WebAuthn defines the configuration to use FIDO2/WebAuthn credentials, such as
+	// security keys, to login to the web admin
+	WebAuthn WebAuthn `json:"webauthn" mapstructure:"webauthn"`
+	// Branding defines the customizations to white-label the web admin
+	Branding Branding `json:"branding" mapstructure:"branding"`
+	// ResumableUploads defines the configuration for the resumable uploads in the user API
+	ResumableUploads ResumableUploads `json:"resumable_uploads" mapstructure:"resumable_uploads"`
 }
 
 type apiResponse struct {
@@ -188,6 +267,7 @@ func (c *Conf) Initialize(configDir string) error {
 	backupsPath = getConfigPath(c.BackupsPath, configDir)
 	staticFilesPath := getConfigPath(c.StaticFilesPath, configDir)
 	templatesPath := getConfigPath(c.TemplatesPath, configDir)
+	openAPIPath := getConfigPath(c.OpenAPIPath, configDir)
 	enableWebAdmin := staticFilesPath != "" || templatesPath != ""
 	if backupsPath == "" {
 		return fmt.Errorf("Required directory is invalid, backup path %#v", backupsPath)
@@ -203,6 +283,9 @@ func (c *Conf) Initialize(configDir string) error {
 	} else {
 		logger.Info(logSender, "", "built-in web interface disabled, please set templates_path and static_files_path to enable it")
 	}
+	if openAPIPath == "" {
+		logger.Info(logSender, "", "OpenAPI schema and Swagger UI disabled, please set openapi_path to enable them")
+	}
 	if certificateFile != "" && certificateKeyFile != "" {
 		mgr, err := common.NewCertManager(certificateFile, certificateKeyFile, configDir, logSender)
 		if err != nil {
@@ -221,6 +304,28 @@ func (c *Conf) Initialize(configDir string) error {
 
 	csrfTokenAuth = jwtauth.New("HS256", utils.GenerateRandomBytes(32), nil)
 
+	if enableWebAdmin {
+		if err := c.OIDC.initialize(); err != nil {
+			return err
+		}
+		if err := c.WebAuthn.initialize(); err != nil {
+			return err
+		}
+		if err := c.Branding.initialize(configDir); err != nil {
+			return err
+		}
+	}
+
+	if err := c.ResumableUploads.initialize(configDir); err != nil {
+		return err
+	}
+
+	for idx := range c.Bindings {
+		if err := c.Bindings[idx].parseTrustedProxies(); err != nil {
+			return err
+		}
+	}
+
 	exitChannel := make(chan error, 1)
 
 	for _, binding := range c.Bindings {
@@ -229,7 +334,7 @@ func (c *Conf) Initialize(configDir string) error {
 		}
 
 		go func(b Binding) {
-			server := newHttpdServer(b, staticFilesPath, enableWebAdmin)
+			server := newHttpdServer(b, staticFilesPath, openAPIPath, enableWebAdmin)
 
 			exitChannel <- server.listenAndServe()
 		}(binding)
@@ -298,14 +403,12 @@ func fileServer(r chi.Router, path string, root http.FileSystem) {
 	})
 }
 
-// GetHTTPRouter returns an HTTP handler suitable to use for test cases
-func GetHTTPRouter() http.Handler {
-	b := Binding{
-		Address:        "",
-		Port:           8080,
-		EnableWebAdmin: true,
+// GetHTTPRouter returns an HTTP handler for the given binding suitable to use for test cases
+func GetHTTPRouter(b Binding) http.Handler {
+	if err := b.parseTrustedProxies(); err != nil {
+		logger.Warn(logSender, "", "unable to get HTTP router: %v", err)
 	}
-	server := newHttpdServer(b, "../static", true)
+	server := newHttpdServer(b, "../static", "../openapi", true)
 	server.initializeRouter()
 	return server.router
 }
@@ -323,6 +426,9 @@ func startJWTTokensCleanupTicker(duration time.Duration) {
 				return
 			case <-jwtTokensCleanupTicker.C:
 				cleanupExpiredJWTTokens()
+				cleanupExpiredOIDCPendingLogins()
+				cleanupExpiredWebAuthnSessions()
+				cleanupExpiredResumableUploads()
 			}
 		}
 	}()
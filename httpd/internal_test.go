@@ -3,13 +3,21 @@ package httpd
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -21,9 +29,16 @@ import (
 	"testing"
 	"time"
 
+	"github.com/duo-labs/webauthn/protocol"
+	"github.com/duo-labs/webauthn/protocol/webauthncose"
+	"github.com/fxamacker/cbor/v2"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/jwtauth"
+	"github.com/go-chi/render"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jws"
 	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/pquerna/otp/totp"
 	"github.com/rs/xid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -392,7 +407,12 @@ func TestCSRFToken(t *testing.T) {
 		assert.Contains(t, err.Error(), "form token is not valid")
 	}
 
-	r := GetHTTPRouter()
+	r := GetHTTPRouter(Binding{
+		Address:        "",
+		Port:           8080,
+		EnableWebAdmin: true,
+		RenderOpenAPI:  true,
+	})
 	fn := verifyCSRFHeader(r)
 	rr := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodDelete, path.Join(userPath, "username"), nil)
@@ -468,7 +488,12 @@ func TestJWTTokenValidation(t *testing.T) {
 	token, _, err := tokenAuth.Encode(claims)
 	assert.NoError(t, err)
 
-	r := GetHTTPRouter()
+	r := GetHTTPRouter(Binding{
+		Address:        "",
+		Port:           8080,
+		EnableWebAdmin: true,
+		RenderOpenAPI:  true,
+	})
 	fn := jwtAuthenticator(r)
 	rr := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodGet, userPath, nil)
@@ -519,6 +544,58 @@ func TestAdminAllowListConnAddr(t *testing.T) {
 	assert.Equal(t, "context value connection address", connAddrKey.String())
 }
 
+func TestAdminTLSCertificate(t *testing.T) {
+	crt, err := tls.X509KeyPair([]byte(client1Crt), []byte(client1Key))
+	assert.NoError(t, err)
+	x509crt, err := x509.ParseCertificate(crt.Certificate[0])
+	assert.NoError(t, err)
+
+	server := httpdServer{
+		tokenAuth: jwtauth.New("HS256", utils.GenerateRandomBytes(32), nil),
+	}
+	admin := dataprovider.Admin{
+		Username: "admin_tls",
+		Filters: dataprovider.AdminFilters{
+			TLSCertCommonNames: []string{x509crt.Subject.CommonName},
+		},
+	}
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, tokenPath, nil)
+	assert.Nil(t, getVerifiedClientCertificate(req))
+	server.checkAddrAndSendToken(rr, req, admin)
+	assert.Equal(t, http.StatusForbidden, rr.Code, rr.Body.String())
+	// the peer certificate is not enough, it must be verified
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{x509crt},
+	}
+	assert.Nil(t, getVerifiedClientCertificate(req))
+	rr = httptest.NewRecorder()
+	server.checkAddrAndSendToken(rr, req, admin)
+	assert.Equal(t, http.StatusForbidden, rr.Code, rr.Body.String())
+
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{x509crt}}
+	assert.Equal(t, x509crt, getVerifiedClientCertificate(req))
+	rr = httptest.NewRecorder()
+	server.checkAddrAndSendToken(rr, req, admin)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	admin.Filters.TLSCertCommonNames = []string{"another common name"}
+	rr = httptest.NewRecorder()
+	server.checkAddrAndSendToken(rr, req, admin)
+	assert.Equal(t, http.StatusForbidden, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), "TLS client certificate")
+
+	rr = httptest.NewRecorder()
+	server.loginWebAdmin(rr, req, &admin)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), "A valid TLS client certificate is required for this admin")
+
+	// a binding with an optional client certificate accepts connections without a certificate
+	server.binding.ClientAuthType = 2
+	err = server.verifyTLSConnection(tls.ConnectionState{})
+	assert.NoError(t, err)
+}
+
 func TestUpdateContextFromCookie(t *testing.T) {
 	server := httpdServer{
 		tokenAuth: jwtauth.New("HS256", utils.GenerateRandomBytes(32), nil),
@@ -679,19 +756,6 @@ func TestRenderInvalidTemplate(t *testing.T) {
 	}
 }
 
-func TestQuotaScanInvalidFs(t *testing.T) {
-	user := dataprovider.User{
-		Username: "test",
-		HomeDir:  os.TempDir(),
-		FsConfig: dataprovider.Filesystem{
-			Provider: dataprovider.S3FilesystemProvider,
-		},
-	}
-	common.QuotaScans.AddUserQuotaScan(user.Username)
-	err := doQuotaScan(user)
-	assert.Error(t, err)
-}
-
 func TestVerifyTLSConnection(t *testing.T) {
 	oldCertMgr := certMgr
 
@@ -831,3 +895,802 @@ func TestJWTTokenCleanup(t *testing.T) {
 	assert.Eventually(t, func() bool { return !isTokenInvalidated(req) }, 1*time.Second, 200*time.Millisecond)
 	stopJWTTokensCleanupTicker()
 }
+
+func TestOIDCLogin(t *testing.T) {
+	const clientID = "sftpgo-client"
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	var nonce, username string
+	idpMux := http.NewServeMux()
+	idpServer := httptest.NewServer(idpMux)
+	defer idpServer.Close()
+
+	idpMux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, r, map[string]interface{}{
+			"issuer":                 idpServer.URL,
+			"authorization_endpoint": idpServer.URL + "/auth",
+			"token_endpoint":         idpServer.URL + "/token",
+			"jwks_uri":               idpServer.URL + "/jwks",
+		})
+	})
+	idpMux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, r, map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"alg": "RS256",
+					"use": "sig",
+					"n":   base64.RawURLEncoding.EncodeToString(privateKey.PublicKey.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(privateKey.PublicKey.E)).Bytes()),
+				},
+			},
+		})
+	})
+	idpMux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		claims, err := json.Marshal(map[string]interface{}{
+			"iss":                idpServer.URL,
+			"aud":                clientID,
+			"sub":                "subject",
+			"iat":                time.Now().Unix(),
+			"exp":                time.Now().Add(5 * time.Minute).Unix(),
+			"nonce":              nonce,
+			"preferred_username": username,
+		})
+		require.NoError(t, err)
+		idToken, err := jws.Sign(claims, jwa.RS256, privateKey)
+		require.NoError(t, err)
+		render.JSON(w, r, map[string]interface{}{
+			"access_token": "access_token",
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     string(idToken),
+		})
+	})
+
+	config := OIDC{
+		ConfigURL: idpServer.URL,
+	}
+	err = config.initialize()
+	assert.Error(t, err)
+	config.ClientID = clientID
+	err = config.initialize()
+	assert.Error(t, err)
+	config.ClientSecret = "secret"
+	err = config.initialize()
+	assert.Error(t, err)
+	config.RedirectBaseURL = "http://127.0.0.1:8081/"
+	config.ConfigURL = idpServer.URL + "/missing"
+	err = config.initialize()
+	assert.Error(t, err)
+	config.ConfigURL = idpServer.URL
+	config.Scopes = []string{"openid", "profile", " "}
+	err = config.initialize()
+	require.NoError(t, err)
+	defer func() {
+		oidcMgr = nil
+	}()
+	assert.Equal(t, "http://127.0.0.1:8081"+webOIDCRedirectPath, oidcMgr.oauth2Config.RedirectURL)
+	assert.Equal(t, []string{"openid", "profile"}, oidcMgr.oauth2Config.Scopes)
+	assert.Equal(t, oidcDefaultUsernameField, oidcMgr.config.UsernameField)
+
+	admin := dataprovider.Admin{
+		Username:    "oidcadmin",
+		Password:    "password",
+		Status:      1,
+		Permissions: []string{dataprovider.PermAdminAny},
+	}
+	err = dataprovider.AddAdmin(&admin)
+	require.NoError(t, err)
+
+	server := httpdServer{
+		tokenAuth: jwtauth.New("HS256", utils.GenerateRandomBytes(32), nil),
+	}
+	getSetCookies := func(rr *httptest.ResponseRecorder) string {
+		return strings.Join(rr.Header().Values("Set-Cookie"), "\n")
+	}
+	getStateCookie := func(rr *httptest.ResponseRecorder) *http.Cookie {
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name == oidcStateCookieName {
+				return cookie
+			}
+		}
+		return nil
+	}
+	startLogin := func() (string, *http.Cookie) {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, webOIDCLoginPath, nil)
+		handleWebOIDCLogin(rr, req)
+		require.Equal(t, http.StatusFound, rr.Code)
+		location, err := url.Parse(rr.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, "/auth", location.Path)
+		assert.Equal(t, clientID, location.Query().Get("client_id"))
+		assert.Equal(t, oidcMgr.oauth2Config.RedirectURL, location.Query().Get("redirect_uri"))
+		nonce = location.Query().Get("nonce")
+		cookie := getStateCookie(rr)
+		require.NotNil(t, cookie)
+		assert.True(t, cookie.HttpOnly)
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+		return location.Query().Get("state"), cookie
+	}
+	finishLogin := func(state string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%v?state=%v&code=code", webOIDCRedirectPath, state), nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		server.handleWebOIDCRedirect(rr, req)
+		return rr
+	}
+	sendPasscode := func(loginID, passcode string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		form := make(url.Values)
+		form.Set(csrfFormToken, createCSRFToken())
+		form.Set("oidc_login", loginID)
+		form.Set("passcode", passcode)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, webOIDCPasscodePath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "127.0.0.1:1234"
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		server.handleWebOIDCPasscodePost(rr, req)
+		return rr
+	}
+	getPendingLoginID := func() string {
+		var loginID string
+		oidcPendingLogins.Range(func(key, value interface{}) bool {
+			loginID = key.(string)
+			return false
+		})
+		return loginID
+	}
+
+	username = admin.Username
+	state, cookie := startLogin()
+	rr := finishLogin(state, cookie)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webUsersPath, rr.Header().Get("Location"))
+	assert.Contains(t, getSetCookies(rr), "jwt=")
+	// the state cookie is removed after the login
+	removedCookie := getStateCookie(rr)
+	if assert.NotNil(t, removedCookie) {
+		assert.Less(t, removedCookie.MaxAge, 0)
+	}
+	rr = finishLogin(state, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "unable to find the OpenID Connect state")
+	// a state started by another browser is rejected, this prevents login CSRF
+	state, _ = startLogin()
+	_, cookie = startLogin()
+	rr = finishLogin(state, cookie)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "the OpenID Connect state does not match")
+	assert.NotContains(t, getSetCookies(rr), "jwt=")
+	rr = finishLogin(state, &http.Cookie{Name: oidcStateCookieName, Value: state})
+	assert.Contains(t, rr.Body.String(), "invalid OpenID Connect state")
+	// wrong nonce
+	state, cookie = startLogin()
+	nonce = "invalid"
+	rr = finishLogin(state, cookie)
+	assert.Contains(t, rr.Body.String(), "OpenID Connect authentication failed")
+	// missing username claim
+	username = ""
+	state, cookie = startLogin()
+	rr = finishLogin(state, cookie)
+	assert.Contains(t, rr.Body.String(), "OpenID Connect authentication failed")
+	// unknown admin
+	username = "unknownadmin"
+	state, cookie = startLogin()
+	rr = finishLogin(state, cookie)
+	assert.Contains(t, rr.Body.String(), dataprovider.ErrInvalidCredentials.Error())
+	// the second factor is required if TOTP is enabled
+	secret, _, _, err := dataprovider.GenerateTOTPSecret(admin.Username)
+	require.NoError(t, err)
+	admin.Filters.TOTPConfig = dataprovider.TOTPConfig{
+		Enabled: true,
+		Secret:  kms.NewPlainSecret(secret),
+	}
+	err = dataprovider.UpdateAdmin(&admin)
+	require.NoError(t, err)
+	username = admin.Username
+	state, cookie = startLogin()
+	rr = finishLogin(state, cookie)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "oidc_passcode_form")
+	assert.NotContains(t, getSetCookies(rr), "jwt=")
+	loginID := getPendingLoginID()
+	require.NotEmpty(t, loginID)
+	rr = sendPasscode(loginID, "123456", cookie)
+	assert.Contains(t, rr.Body.String(), "invalid passcode")
+	assert.NotContains(t, getSetCookies(rr), "jwt=")
+	// the pending login can be used only once
+	passcode, err := totp.GenerateCode(secret, time.Now())
+	require.NoError(t, err)
+	rr = sendPasscode(loginID, passcode, cookie)
+	assert.Contains(t, rr.Body.String(), "unable to find the OpenID Connect login")
+	// the pending login is bound to the state cookie
+	state, cookie = startLogin()
+	rr = finishLogin(state, cookie)
+	assert.Contains(t, rr.Body.String(), "oidc_passcode_form")
+	loginID = getPendingLoginID()
+	_, otherCookie := startLogin()
+	rr = sendPasscode(loginID, passcode, otherCookie)
+	assert.Contains(t, rr.Body.String(), "the OpenID Connect state does not match")
+	// expired pending login
+	state, cookie = startLogin()
+	rr = finishLogin(state, cookie)
+	assert.Contains(t, rr.Body.String(), "oidc_passcode_form")
+	loginID = getPendingLoginID()
+	oidcPendingLogins.Store(loginID, oidcPendingLogin{
+		Username: admin.Username,
+		State:    state,
+		IssuedAt: time.Now().Add(-2 * oidcPendingAuthTimeout),
+	})
+	rr = sendPasscode(loginID, passcode, cookie)
+	assert.Contains(t, rr.Body.String(), "the OpenID Connect login is expired")
+	// missing CSRF token
+	rr = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, webOIDCPasscodePath, strings.NewReader("passcode=123456"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	server.handleWebOIDCPasscodePost(rr, req)
+	assert.Contains(t, rr.Body.String(), "Unable to verify form token")
+
+	state, cookie = startLogin()
+	rr = finishLogin(state, cookie)
+	assert.Contains(t, rr.Body.String(), "oidc_passcode_form")
+	rr = sendPasscode(getPendingLoginID(), passcode, cookie)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webUsersPath, rr.Header().Get("Location"))
+	assert.Contains(t, getSetCookies(rr), "jwt=")
+	// the security key is required after the OpenID Connect login if WebAuthn is required
+	admin.Filters.TOTPConfig = dataprovider.TOTPConfig{}
+	admin.Filters.WebAuthnCredentials = []dataprovider.WebAuthnCredential{
+		{
+			Name:      "key1",
+			ID:        utils.GenerateRandomBytes(16),
+			PublicKey: utils.GenerateRandomBytes(32),
+		},
+	}
+	err = dataprovider.UpdateAdmin(&admin)
+	require.NoError(t, err)
+	webAuthnConfig := WebAuthn{
+		RPID: "localhost",
+	}
+	err = webAuthnConfig.initialize()
+	require.NoError(t, err)
+	state, cookie = startLogin()
+	rr = finishLogin(state, cookie)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "webauthn_form")
+	assert.NotContains(t, getSetCookies(rr), "jwt=")
+	webAuthnMgr = nil
+	admin.Filters.WebAuthnCredentials = nil
+	// login from a not allowed IP
+	admin.Filters.AllowList = []string{"192.168.1.0/24"}
+	err = dataprovider.UpdateAdmin(&admin)
+	assert.NoError(t, err)
+	state, cookie = startLogin()
+	rr = finishLogin(state, cookie)
+	assert.Contains(t, rr.Body.String(), "login from IP 127.0.0.1 not allowed")
+	// disabled admin
+	admin.Status = 0
+	err = dataprovider.UpdateAdmin(&admin)
+	assert.NoError(t, err)
+	state, cookie = startLogin()
+	rr = finishLogin(state, cookie)
+	assert.Contains(t, rr.Body.String(), "is disabled")
+	// error from the identity provider
+	rr = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, webOIDCRedirectPath+"?error=access_denied", nil)
+	server.handleWebOIDCRedirect(rr, req)
+	assert.Contains(t, rr.Body.String(), "OpenID Connect authentication error: access_denied")
+
+	oidcPendingLogins.Store("expired", oidcPendingLogin{IssuedAt: time.Now().Add(-2 * oidcPendingAuthTimeout)})
+	oidcPendingLogins.Store("invalid", "invalid")
+	cleanupExpiredOIDCPendingLogins()
+	_, ok := oidcPendingLogins.Load("expired")
+	assert.False(t, ok)
+	_, ok = oidcPendingLogins.Load("invalid")
+	assert.False(t, ok)
+
+	oidcMgr = nil
+	rr = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, webOIDCLoginPath, nil)
+	handleWebOIDCLogin(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr = httptest.NewRecorder()
+	server.handleWebOIDCRedirect(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	err = dataprovider.DeleteAdmin(admin.Username)
+	assert.NoError(t, err)
+}
+
+// testAuthenticator is a software WebAuthn authenticator
+type testAuthenticator struct {
+	t            *testing.T
+	rpID         string
+	origin       string
+	credentialID []byte
+	privateKey   *ecdsa.PrivateKey
+	signCount    uint32
+}
+
+func (a *testAuthenticator) getClientData(ceremony, challenge string) []byte {
+	clientData, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    a.origin,
+	})
+	require.NoError(a.t, err)
+	return clientData
+}
+
+func (a *testAuthenticator) getAuthData(flags byte, attestedData []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	authData := append([]byte(nil), rpIDHash[:]...)
+	authData = append(authData, flags)
+	counter := make([]byte, 4)
+	binary.BigEndian.PutUint32(counter, a.signCount)
+	authData = append(authData, counter...)
+	return append(authData, attestedData...)
+}
+
+func (a *testAuthenticator) register(challenge string) string {
+	publicKey, err := cbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1,
+		XCoord: a.privateKey.X.FillBytes(make([]byte, 32)),
+		YCoord: a.privateKey.Y.FillBytes(make([]byte, 32)),
+	})
+	require.NoError(a.t, err)
+	attestedData := make([]byte, 16) // AAGUID
+	credIDLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(credIDLen, uint16(len(a.credentialID)))
+	attestedData = append(attestedData, credIDLen...)
+	attestedData = append(attestedData, a.credentialID...)
+	attestedData = append(attestedData, publicKey...)
+	// user present and attested credential data included
+	authData := a.getAuthData(0x41, attestedData)
+	attestationObject, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	require.NoError(a.t, err)
+	response, err := json.Marshal(map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestationObject),
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(a.getClientData("webauthn.create", challenge)),
+		},
+	})
+	require.NoError(a.t, err)
+	return string(response)
+}
+
+func (a *testAuthenticator) login(challenge string, userVerified bool) string {
+	a.signCount++
+	flags := byte(0x01)
+	if userVerified {
+		flags |= 0x04
+	}
+	authData := a.getAuthData(flags, nil)
+	clientData := a.getClientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.privateKey, digest[:])
+	require.NoError(a.t, err)
+	response, err := json.Marshal(map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+		},
+	})
+	require.NoError(a.t, err)
+	return string(response)
+}
+
+func getPendingWebAuthnSession(t *testing.T, username string, registration bool) (string, webAuthnSession) {
+	var sessionID string
+	var session webAuthnSession
+	webAuthnSessions.Range(func(key, value interface{}) bool {
+		s := value.(webAuthnSession)
+		if s.Username == username && s.Registration == registration {
+			sessionID = key.(string)
+			session = s
+			return false
+		}
+		return true
+	})
+	require.NotEmpty(t, sessionID)
+	return sessionID, session
+}
+
+func TestWebAuthnLogin(t *testing.T) {
+	config := WebAuthn{
+		RPID:     "localhost",
+		RPOrigin: "invalid origin",
+	}
+	err := config.initialize()
+	assert.Error(t, err)
+	config.RPOrigin = ""
+	err = config.initialize()
+	require.NoError(t, err)
+	defer func() {
+		webAuthnMgr = nil
+	}()
+	assert.Equal(t, "https://localhost", config.RPOrigin)
+	assert.Equal(t, webAuthnDefaultRPDisplayName, config.RPDisplayName)
+
+	admin := dataprovider.Admin{
+		Username:    "webauthnadmin",
+		Password:    "password",
+		Status:      1,
+		Permissions: []string{dataprovider.PermAdminAny},
+	}
+	err = dataprovider.AddAdmin(&admin)
+	require.NoError(t, err)
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	authenticator := &testAuthenticator{
+		t:            t,
+		rpID:         config.RPID,
+		origin:       config.RPOrigin,
+		credentialID: utils.GenerateRandomBytes(16),
+		privateKey:   privateKey,
+	}
+	server := httpdServer{
+		tokenAuth: jwtauth.New("HS256", utils.GenerateRandomBytes(32), nil),
+	}
+	newRequest := func(form url.Values) *http.Request {
+		req, _ := http.NewRequest(http.MethodPost, webMFAPath, bytes.NewBuffer([]byte(form.Encode())))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "127.0.0.1:1234"
+		require.NoError(t, req.ParseForm())
+		return req
+	}
+	// register a security key
+	rr := httptest.NewRecorder()
+	handleWebAuthnRegistrationBegin(rr, newRequest(url.Values{}), &admin)
+	assert.Contains(t, rr.Body.String(), "Please enter a name for the security key")
+	rr = httptest.NewRecorder()
+	handleWebAuthnRegistrationBegin(rr, newRequest(url.Values{"webauthn_name": []string{"key1"}}), &admin)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "webAuthnRegister(webAuthnOptions")
+	sessionID, session := getPendingWebAuthnSession(t, admin.Username, true)
+	form := url.Values{}
+	form.Set("webauthn_session", sessionID)
+	form.Set("webauthn_response", authenticator.register(session.Data.Challenge))
+	rr = httptest.NewRecorder()
+	handleWebAuthnRegistrationFinish(rr, newRequest(form), &admin)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "key1")
+	// the session can be used only once
+	rr = httptest.NewRecorder()
+	handleWebAuthnRegistrationFinish(rr, newRequest(form), &admin)
+	assert.Contains(t, rr.Body.String(), "unable to find the WebAuthn session")
+
+	admin, err = dataprovider.AdminExists(admin.Username)
+	assert.NoError(t, err)
+	require.Len(t, admin.Filters.WebAuthnCredentials, 1)
+	assert.Equal(t, authenticator.credentialID, admin.Filters.WebAuthnCredentials[0].ID)
+	assert.True(t, admin.IsWebAuthnRequired())
+	// JWT tokens cannot be obtained using only the password
+	rr = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, tokenPath, nil)
+	req.SetBasicAuth(admin.Username, "password")
+	req.RemoteAddr = "127.0.0.1:1234"
+	server.getToken(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "a security key is required")
+	// the same name cannot be used twice
+	rr = httptest.NewRecorder()
+	handleWebAuthnRegistrationBegin(rr, newRequest(url.Values{"webauthn_name": []string{"key1"}}), &admin)
+	assert.Contains(t, rr.Body.String(), "already exists")
+
+	verifyLogin := func(response func(challenge string) string) *httptest.ResponseRecorder {
+		sessionID, session := getPendingWebAuthnSession(t, admin.Username, false)
+		form := url.Values{}
+		form.Set(csrfFormToken, createCSRFToken())
+		form.Set("webauthn_session", sessionID)
+		form.Set("webauthn_response", response(session.Data.Challenge))
+		rr := httptest.NewRecorder()
+		server.handleWebAuthnVerifyPost(rr, newRequest(form))
+		return rr
+	}
+	// second factor login
+	rr = httptest.NewRecorder()
+	renderWebAuthnLoginPage(rr, &admin, protocol.VerificationDiscouraged)
+	assert.Contains(t, rr.Body.String(), "webAuthnLogin(webAuthnOptions")
+	rr = verifyLogin(func(challenge string) string {
+		return authenticator.login(challenge, false)
+	})
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, webUsersPath, rr.Header().Get("Location"))
+	assert.Contains(t, rr.Header().Get("Set-Cookie"), "jwt=")
+	admin, err = dataprovider.AdminExists(admin.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), admin.Filters.WebAuthnCredentials[0].SignCount)
+	assert.Greater(t, admin.Filters.WebAuthnCredentials[0].LastUseAt, int64(0))
+	// a wrong challenge must fail
+	renderWebAuthnLoginPage(httptest.NewRecorder(), &admin, protocol.VerificationDiscouraged)
+	rr = verifyLogin(func(challenge string) string {
+		return authenticator.login("wrong challenge", false)
+	})
+	assert.Contains(t, rr.Body.String(), "Security key authentication failed")
+	// a signature counter not incremented denotes a cloned authenticator
+	authenticator.signCount = 0
+	renderWebAuthnLoginPage(httptest.NewRecorder(), &admin, protocol.VerificationDiscouraged)
+	rr = verifyLogin(func(challenge string) string {
+		return authenticator.login(challenge, false)
+	})
+	assert.Contains(t, rr.Body.String(), "the authenticator could be cloned")
+	authenticator.signCount = 10
+	// passwordless login must be enabled
+	loginForm := url.Values{}
+	loginForm.Set(csrfFormToken, createCSRFToken())
+	loginForm.Set("username", admin.Username)
+	rr = httptest.NewRecorder()
+	handleWebAuthnLoginPost(rr, newRequest(loginForm))
+	assert.Contains(t, rr.Body.String(), "Passwordless login is not enabled")
+	rr = httptest.NewRecorder()
+	handleWebAuthnPasswordless(rr, newRequest(url.Values{"webauthn_passwordless": []string{"on"}}), &admin)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = httptest.NewRecorder()
+	handleWebAuthnLoginPost(rr, newRequest(loginForm))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "webAuthnLogin(webAuthnOptions")
+	// passwordless login requires user verification
+	rr = verifyLogin(func(challenge string) string {
+		return authenticator.login(challenge, false)
+	})
+	assert.Contains(t, rr.Body.String(), "Security key authentication failed")
+	rr = httptest.NewRecorder()
+	handleWebAuthnLoginPost(rr, newRequest(loginForm))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = verifyLogin(func(challenge string) string {
+		return authenticator.login(challenge, true)
+	})
+	assert.Equal(t, http.StatusFound, rr.Code)
+	// the REST API cannot change the WebAuthn credentials
+	admin, err = dataprovider.AdminExists(admin.Username)
+	assert.NoError(t, err)
+	assert.True(t, admin.Filters.WebAuthnPasswordless)
+	// remove the security key
+	rr = httptest.NewRecorder()
+	handleWebAuthnCredentialDelete(rr, newRequest(url.Values{"webauthn_id": []string{"invalid"}}), &admin)
+	assert.Contains(t, rr.Body.String(), "Security key not found")
+	rr = httptest.NewRecorder()
+	handleWebAuthnCredentialDelete(rr, newRequest(url.Values{
+		"webauthn_id": []string{admin.Filters.WebAuthnCredentials[0].GetEncodedID()},
+	}), &admin)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	admin, err = dataprovider.AdminExists(admin.Username)
+	assert.NoError(t, err)
+	assert.Len(t, admin.Filters.WebAuthnCredentials, 0)
+	assert.False(t, admin.Filters.WebAuthnPasswordless)
+	rr = httptest.NewRecorder()
+	handleWebAuthnPasswordless(rr, newRequest(url.Values{"webauthn_passwordless": []string{"on"}}), &admin)
+	assert.Contains(t, rr.Body.String(), "Please register a security key")
+
+	webAuthnSessions.Store("expired", webAuthnSession{IssuedAt: time.Now().Add(-2 * webAuthnSessionTimeout)})
+	webAuthnSessions.Store("invalid", "invalid")
+	cleanupExpiredWebAuthnSessions()
+	_, ok := webAuthnSessions.Load("expired")
+	assert.False(t, ok)
+	_, ok = webAuthnSessions.Load("invalid")
+	assert.False(t, ok)
+
+	webAuthnMgr = nil
+	rr = httptest.NewRecorder()
+	handleWebAuthnLoginPost(rr, newRequest(loginForm))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr = httptest.NewRecorder()
+	server.handleWebAuthnVerifyPost(rr, newRequest(loginForm))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	err = dataprovider.DeleteAdmin(admin.Username)
+	assert.NoError(t, err)
+}
+
+func TestOpenAPIDisabled(t *testing.T) {
+	b := Binding{
+		Port:          8080,
+		RenderOpenAPI: true,
+	}
+	server := newHttpdServer(b, "", "", false)
+	assert.False(t, server.renderOpenAPI)
+	server = newHttpdServer(b, "", "../openapi", false)
+	assert.True(t, server.renderOpenAPI)
+	b.RenderOpenAPI = false
+	server = newHttpdServer(b, "", "../openapi", false)
+	assert.False(t, server.renderOpenAPI)
+	server.initializeRouter()
+	req, _ := http.NewRequest(http.MethodGet, "/openapi/openapi.yaml", nil)
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestBranding(t *testing.T) {
+	oldBranding := webBranding
+	defer func() {
+		webBranding = oldBranding
+	}()
+
+	brandingDir := filepath.Join(os.TempDir(), "branding")
+	err := os.MkdirAll(filepath.Join(brandingDir, "css"), os.ModePerm)
+	require.NoError(t, err)
+	defer os.RemoveAll(brandingDir)
+	err = ioutil.WriteFile(filepath.Join(brandingDir, "logo.png"), []byte("logo"), os.ModePerm)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(brandingDir, "css", "custom.css"), []byte("body {}"), os.ModePerm)
+	assert.NoError(t, err)
+
+	c := Branding{}
+	err = c.initialize("..")
+	assert.NoError(t, err)
+	assert.Equal(t, getDefaultBrandingData(), webBranding)
+
+	c.LogoPath = "logo.png"
+	err = c.initialize("..")
+	assert.Error(t, err)
+	c.Path = filepath.Join(brandingDir, "logo.png")
+	err = c.initialize("..")
+	assert.Error(t, err)
+	c.Path = filepath.Join(brandingDir, "missing")
+	err = c.initialize("..")
+	assert.Error(t, err)
+	c.Path = brandingDir
+	c.FaviconPath = "favicon.ico"
+	err = c.initialize("..")
+	assert.Error(t, err)
+	c.FaviconPath = "css"
+	err = c.initialize("..")
+	assert.Error(t, err)
+	c.FaviconPath = ""
+	c.ExtraCSS = []string{"../branding/css/custom.css", "missing.css"}
+	err = c.initialize("..")
+	assert.Error(t, err)
+	c.ExtraCSS = []string{"css/custom.css"}
+	c.Name = "Example Corp"
+	c.ShortName = "Example"
+	err = c.initialize("..")
+	assert.NoError(t, err)
+	assert.Equal(t, "Example Corp", webBranding.Name)
+	assert.Equal(t, "Example", webBranding.ShortName)
+	assert.Equal(t, "/static/favicon.ico", webBranding.FaviconURL)
+	assert.Equal(t, "/branding/logo.png", webBranding.LogoURL)
+	assert.Equal(t, []string{"/branding/css/custom.css"}, webBranding.ExtraCSS)
+
+	rr := httptest.NewRecorder()
+	renderLoginPage(rr, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "<title>Example Corp - Login</title>")
+	assert.Contains(t, rr.Body.String(), "/branding/logo.png")
+	assert.Contains(t, rr.Body.String(), "/branding/css/custom.css")
+
+	server := newHttpdServer(Binding{Port: 8080, EnableWebAdmin: true}, "../static", "", true)
+	server.initializeRouter()
+	req, _ := http.NewRequest(http.MethodGet, "/branding/css/custom.css", nil)
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "body {}", rr.Body.String())
+}
+
+func TestResumableUploads(t *testing.T) {
+	metadata, err := parseUploadMetadata("path L2Rpci9maWxlLnR4dA==, empty ,filename ZmlsZS50eHQ=")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"path": "/dir/file.txt", "empty": "", "filename": "file.txt"}, metadata)
+	_, err = parseUploadMetadata("path invalid")
+	assert.Error(t, err)
+	name, err := getUploadVirtualPath(map[string]string{"filename": "../dir/file.txt"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/file.txt", name)
+	_, err = getUploadVirtualPath(map[string]string{"path": "/dir/.."})
+	assert.Error(t, err)
+	_, err = getUploadVirtualPath(nil)
+	assert.Error(t, err)
+
+	savedMgr := uploadsMgr
+	defer func() {
+		uploadsMgr = savedMgr
+	}()
+
+	c := ResumableUploads{
+		Path:   "",
+		MaxAge: -1,
+	}
+	err = c.initialize("..")
+	assert.NoError(t, err)
+	assert.Nil(t, uploadsMgr)
+	c.Path = filepath.Join(os.TempDir(), "test_resumable_uploads")
+	err = c.initialize("..")
+	assert.Error(t, err)
+	c.MaxAge = 0
+	c.MaxSize = -1
+	err = c.initialize("..")
+	assert.Error(t, err)
+	c.MaxSize = 0
+	c.MaxPerUser = -1
+	err = c.initialize("..")
+	assert.Error(t, err)
+	c.MaxPerUser = 0
+	err = c.initialize("..")
+	assert.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, uploadsMgr.maxAge)
+	assert.Equal(t, 10, uploadsMgr.maxPerUser)
+	defer os.RemoveAll(c.Path)
+
+	expired := resumableUpload{
+		ID:        xid.New().String(),
+		Username:  "user",
+		Path:      "/file1",
+		CreatedAt: utils.GetTimeAsMsSinceEpoch(time.Now().Add(-25 * time.Hour)),
+	}
+	valid := resumableUpload{
+		ID:        xid.New().String(),
+		Username:  "user",
+		Path:      "/file2",
+		CreatedAt: utils.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+	err = uploadsMgr.add(&expired)
+	assert.NoError(t, err)
+	err = uploadsMgr.add(&valid)
+	assert.NoError(t, err)
+	_, _, err = uploadsMgr.get(valid.ID, "other user")
+	assert.ErrorIs(t, err, errUploadNotFound)
+	_, _, err = uploadsMgr.get("../"+valid.ID, "user")
+	assert.ErrorIs(t, err, errUploadNotFound)
+	// locked uploads are not removed
+	err = uploadsMgr.lock(expired.ID)
+	assert.NoError(t, err)
+	err = uploadsMgr.lock(expired.ID)
+	assert.ErrorIs(t, err, errUploadLocked)
+	cleanupExpiredResumableUploads()
+	_, _, err = uploadsMgr.get(expired.ID, "user")
+	assert.NoError(t, err)
+	uploadsMgr.unlock(expired.ID)
+	cleanupExpiredResumableUploads()
+	_, _, err = uploadsMgr.get(expired.ID, "user")
+	assert.ErrorIs(t, err, errUploadNotFound)
+	assert.NoFileExists(t, uploadsMgr.getDataPath(expired.ID))
+	_, offset, err := uploadsMgr.get(valid.ID, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), offset)
+	valid.Size = 10
+	err = uploadsMgr.add(&valid)
+	assert.NoError(t, err)
+	count, size, err := uploadsMgr.getPending("user")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, int64(10), size)
+	count, size, err = uploadsMgr.getPending("other user")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.Equal(t, int64(0), size)
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodOptions, userUploadsPath, nil)
+	uploadsMgr.maxSize = 100
+	handleUploadsOptions(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "100", rr.Header().Get(headerTusMaxSize))
+	uploadsMgr = nil
+	rr = httptest.NewRecorder()
+	handleUploadsOptions(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
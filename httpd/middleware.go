@@ -2,17 +2,29 @@ package httpd
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/jwtauth"
 	"github.com/lestrrat-go/jwx/jwt"
 
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/utils"
 )
 
-var connAddrKey = &contextKey{"connection address"}
+var (
+	connAddrKey                 = &contextKey{"connection address"}
+	errAdminGroupsChanged       = errors.New("the admin groups have been changed")
+	errAdminCertificateRequired = errors.New("a valid TLS client certificate is required for this admin")
+)
 
 type contextKey struct {
 	name string
@@ -29,30 +41,31 @@ func saveConnectionAddress(next http.Handler) http.Handler {
 	})
 }
 
-func jwtAuthenticator(next http.Handler) http.Handler {
+// checkProxyHeaders sets the client IP address from the proxy header,
+// if the request comes from a trusted proxy
+func (s *httpdServer) checkProxyHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token, _, err := jwtauth.FromContext(r.Context())
-
-		if err != nil || token == nil {
-			logger.Debug(logSender, "", "error getting jwt token: %v", err)
-			sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-			return
+		if ip := s.binding.trustedProxies.GetClientIP(r); ip != "" {
+			r.RemoteAddr = ip
 		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		err = jwt.Validate(token)
-		if err != nil {
-			logger.Debug(logSender, "", "error validating jwt token: %v", err)
-			sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-			return
-		}
-		if !utils.IsStringInSlice(tokenAudienceAPI, token.Audience()) {
-			logger.Debug(logSender, "", "the token audience is not valid for API usage")
-			sendAPIResponse(w, r, nil, "Your token audience is not valid", http.StatusUnauthorized)
+func jwtAuthenticator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !validateJWTToken(w, r, tokenAudienceAPI) {
 			return
 		}
-		if isTokenInvalidated(r) {
-			logger.Debug(logSender, "", "the token has been invalidated")
-			sendAPIResponse(w, r, nil, "Your token is no longer valid", http.StatusUnauthorized)
+
+		// Token is authenticated, pass it through
+		next.ServeHTTP(w, r)
+	})
+}
+
+func jwtAuthenticatorAPIUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !validateJWTToken(w, r, tokenAudienceAPIUser) {
 			return
 		}
 
@@ -61,6 +74,80 @@ func jwtAuthenticator(next http.Handler) http.Handler {
 	})
 }
 
+// validateJWTToken checks the token in the request context and sends the
+// appropriate error response if it is not valid for the given audience
+func validateJWTToken(w http.ResponseWriter, r *http.Request, audience tokenAudience) bool {
+	token, _, err := jwtauth.FromContext(r.Context())
+
+	if err != nil || token == nil {
+		logger.Debug(logSender, "", "error getting jwt token: %v", err)
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return false
+	}
+
+	err = jwt.Validate(token)
+	if err != nil {
+		logger.Debug(logSender, "", "error validating jwt token: %v", err)
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return false
+	}
+	if !utils.IsStringInSlice(audience, token.Audience()) {
+		logger.Debug(logSender, "", "the token audience is not valid for %v usage", audience)
+		sendAPIResponse(w, r, nil, "Your token audience is not valid", http.StatusUnauthorized)
+		return false
+	}
+	if isTokenInvalidated(r) {
+		logger.Debug(logSender, "", "the token has been invalidated")
+		sendAPIResponse(w, r, nil, "Your token is no longer valid", http.StatusUnauthorized)
+		return false
+	}
+	if audience == tokenAudienceAPI {
+		switch err := checkAdminToken(r); err {
+		case nil:
+		case errAdminCertificateRequired:
+			sendAPIResponse(w, r, err, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return false
+		default:
+			sendAPIResponse(w, r, nil, "Your groups have been changed, please login again", http.StatusUnauthorized)
+			return false
+		}
+	}
+	return true
+}
+
+// checkAdminToken checks the token of the logged in admin against the stored admin.
+// The token is invalidated if the admin no longer exists or if their groups changed
+// after it was issued. The TLS client certificate binding is checked for each request
+// and not only when the token is issued, otherwise the token could be replayed on a
+// connection without a client certificate. Other provider errors are left to the
+// request handlers
+func checkAdminToken(r *http.Request) error {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		return nil
+	}
+	admin, err := dataprovider.AdminExists(claims.Username)
+	if err != nil {
+		logger.Debug(logSender, "", "unable to get admin %#v to check the token: %v", claims.Username, err)
+		if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+			invalidateToken(r)
+			return errAdminGroupsChanged
+		}
+		return nil
+	}
+	if claims.isGroupsScopeChanged(admin.Filters.Groups) {
+		logger.Debug(logSender, "", "the groups for admin %#v have been changed, the token is no longer valid",
+			admin.Username)
+		invalidateToken(r)
+		return errAdminGroupsChanged
+	}
+	if !admin.CanLoginWithCertificate(getVerifiedClientCertificate(r)) {
+		logger.Debug(logSender, "", "admin %#v requires a valid TLS client certificate, token refused", admin.Username)
+		return errAdminCertificateRequired
+	}
+	return nil
+}
+
 func jwtAuthenticatorWeb(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token, _, err := jwtauth.FromContext(r.Context())
@@ -87,6 +174,10 @@ func jwtAuthenticatorWeb(next http.Handler) http.Handler {
 			http.Redirect(w, r, webLoginPath, http.StatusFound)
 			return
 		}
+		if err := checkAdminToken(r); err != nil {
+			http.Redirect(w, r, webLoginPath, http.StatusFound)
+			return
+		}
 
 		// Token is authenticated, pass it through
 		next.ServeHTTP(w, r)
@@ -122,6 +213,267 @@ func checkPerm(perm string) func(next http.Handler) http.Handler {
 	}
 }
 
+// auditAdminRequest records an audit event for the requests that could modify
+// something. The event is recorded after the request is served so that the
+// response status is available
+func auditAdminRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !dataprovider.IsAuditEnabled() || r.Method == http.MethodGet || r.Method == http.MethodHead ||
+			r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		claims, _ := getTokenClaims(r)
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		dataprovider.AddAuditEvent(dataprovider.AuditEvent{
+			Action:     dataprovider.AuditActionAdminRequest,
+			Username:   claims.Username,
+			IP:         utils.GetIPFromRemoteAddress(r.RemoteAddr),
+			Protocol:   "HTTP",
+			ObjectType: dataprovider.AuditObjectAdmin,
+			ObjectName: claims.Username,
+			Details:    fmt.Sprintf("%v %v, status %v", r.Method, r.URL.Path, status),
+		})
+	})
+}
+
+// rateLimitAPIRequests rejects the REST API requests exceeding the configured rate
+// limiters. The limits are applied before authenticating the requests, so only the
+// JWT tokens with a valid signature get their own bucket. The other requests, for
+// example the ones using basic auth or API keys, are not authenticated yet and so
+// they use the source IP bucket
+func rateLimitAPIRequests(tokenAuth *jwtauth.JWTAuth) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			delay, err := common.LimitAPIRate(utils.GetIPFromRemoteAddress(r.RemoteAddr), getVerifiedTokenID(tokenAuth, r))
+			if err != nil {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				sendAPIResponse(w, r, err, "", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// getVerifiedTokenID returns an hash of the ID of the bearer token for the given
+// request, if the token is valid, or an empty string. The API keys are verified
+// later and so the requests using them are not associated with a token
+func getVerifiedTokenID(tokenAuth *jwtauth.JWTAuth, r *http.Request) string {
+	if r.Header.Get(apiKeyHeader) != "" {
+		return ""
+	}
+	tokenString := jwtauth.TokenFromHeader(r)
+	if tokenString == "" {
+		return ""
+	}
+	token, err := jwtauth.VerifyToken(tokenAuth, tokenString)
+	if err != nil || token == nil || token.JwtID() == "" {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token.JwtID())))
+}
+
+// checkUserScope denies access to the user identified by the username URL param
+// if the logged in admin is restricted to some groups and the user is not part of them.
+// Tokens generated from restricted API keys can also be limited to some users
+func checkUserScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := getTokenClaims(r)
+		if err != nil {
+			if isWebAdminRequest(r) {
+				renderBadRequestPage(w, r, err)
+			} else {
+				sendAPIResponse(w, r, err, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			}
+			return
+		}
+		if claims.isRestricted() {
+			user, err := dataprovider.UserExists(getURLParam(r, "username"))
+			// if the user does not exist the handler will return the appropriate error
+			if err == nil && !claims.isUserInScope(&user) {
+				if isWebAdminRequest(r) {
+					renderForbiddenPage(w, r, "You are not allowed to manage this user")
+				} else {
+					sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				}
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkAPIKeyAuth authenticates the request using the API key in the apiKeyHeader, if any.
+// Only API keys with the given scope are accepted. A valid API key is converted to a short
+// lived token so the following JWT based middlewares can be used unchanged
+func checkAPIKeyAuth(tokenAuth *jwtauth.JWTAuth, scope dataprovider.APIKeyScope) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiKey := r.Header.Get(apiKeyHeader)
+			if apiKey == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			keyParams := strings.SplitN(apiKey, ".", 3)
+			if len(keyParams) < 2 {
+				logger.Debug(logSender, "", "invalid api key %#v", apiKey)
+				sendAPIResponse(w, r, errors.New("the provided api key is not valid"), "", http.StatusBadRequest)
+				return
+			}
+			keyValue := keyParams[0]
+			keyID := keyParams[1]
+			username := ""
+			if len(keyParams) > 2 {
+				username = keyParams[2]
+			}
+
+			k, err := dataprovider.APIKeyExists(keyID)
+			if err != nil {
+				logger.Debug(logSender, "", "invalid api key %#v: %v", apiKey, err)
+				sendAPIResponse(w, r, errors.New("the provided api key is not valid"), "", http.StatusBadRequest)
+				return
+			}
+			if err := k.Authenticate(keyValue); err != nil {
+				logger.Debug(logSender, "", "unable to authenticate api key %#v: %v", apiKey, err)
+				sendAPIResponse(w, r, fmt.Errorf("the provided api key cannot be authenticated"), "", http.StatusUnauthorized)
+				return
+			}
+			if k.Scope != scope {
+				logger.Debug(logSender, "", "unable to authenticate api key %#v: invalid scope", apiKey)
+				sendAPIResponse(w, r, fmt.Errorf("the provided api key is invalid for this request"), "", http.StatusForbidden)
+				return
+			}
+			if scope == dataprovider.APIKeyScopeAdmin {
+				if !authenticateAdminWithAPIKey(w, r, tokenAuth, &k, username) {
+					return
+				}
+			} else {
+				if !authenticateUserWithAPIKey(w, r, tokenAuth, &k, username) {
+					return
+				}
+			}
+			dataprovider.UpdateAPIKeyLastUse(&k) //nolint:errcheck
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authenticateAdminWithAPIKey checks the admin impersonated using the given API key
+// and sets the Authorization header for the request. It returns false, after sending
+// the error response, if the admin cannot be authenticated
+func authenticateAdminWithAPIKey(w http.ResponseWriter, r *http.Request, tokenAuth *jwtauth.JWTAuth,
+	k *dataprovider.APIKey, username string,
+) bool {
+	if k.Admin != "" {
+		username = k.Admin
+	}
+	admin, err := dataprovider.AdminExists(username)
+	if err != nil {
+		logger.Debug(logSender, "", "invalid admin %#v for api key %#v: %v", username, k.KeyID, err)
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return false
+	}
+	if k.Admin == "" && !admin.Filters.AllowAPIKeyAuth {
+		logger.Debug(logSender, "", "admin %#v does not allow api key authentication", username)
+		sendAPIResponse(w, r, errors.New("API key authentication disabled for this admin"), "",
+			http.StatusUnauthorized)
+		return false
+	}
+	if admin.Status != 1 {
+		logger.Debug(logSender, "", "admin %#v is disabled", username)
+		sendAPIResponse(w, r, errors.New("admin account is disabled"), "", http.StatusUnauthorized)
+		return false
+	}
+	if !admin.CanLoginFromIP(utils.GetIPFromRemoteAddress(r.RemoteAddr)) {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return false
+	}
+	if connAddr, ok := r.Context().Value(connAddrKey).(string); ok {
+		if connAddr != r.RemoteAddr && !admin.CanLoginFromIP(utils.GetIPFromRemoteAddress(connAddr)) {
+			sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return false
+		}
+	}
+	if !admin.CanLoginWithCertificate(getVerifiedClientCertificate(r)) {
+		sendAPIResponse(w, r, errAdminCertificateRequired,
+			http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return false
+	}
+
+	c := jwtTokenClaims{
+		Username:    admin.Username,
+		Permissions: admin.Permissions,
+		Groups:      admin.Filters.Groups,
+		Signature:   admin.GetSignature(),
+	}
+	if err := c.applyRestrictions(&k.Restrictions); err != nil {
+		logger.Debug(logSender, "", "unable to apply the restrictions for api key %#v: %v", k.KeyID, err)
+		sendAPIResponse(w, r, err, "", http.StatusForbidden)
+		return false
+	}
+	return setAPIKeyToken(w, r, tokenAuth, &c, tokenAudienceAPI)
+}
+
+// authenticateUserWithAPIKey checks the user impersonated using the given API key
+// and sets the Authorization header for the request. It returns false, after sending
+// the error response, if the user cannot be authenticated
+func authenticateUserWithAPIKey(w http.ResponseWriter, r *http.Request, tokenAuth *jwtauth.JWTAuth,
+	k *dataprovider.APIKey, username string,
+) bool {
+	if k.User != "" {
+		username = k.User
+	}
+	user, err := dataprovider.UserExists(username)
+	if err != nil {
+		logger.Debug(logSender, "", "invalid user %#v for api key %#v: %v", username, k.KeyID, err)
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return false
+	}
+	if k.User == "" && !user.Filters.AllowAPIKeyAuth {
+		logger.Debug(logSender, "", "user %#v does not allow api key authentication", username)
+		sendAPIResponse(w, r, errors.New("API key authentication disabled for this user"), "",
+			http.StatusUnauthorized)
+		return false
+	}
+	if user.Status != 1 || user.IsExpired() {
+		logger.Debug(logSender, "", "user %#v is disabled or expired", username)
+		sendAPIResponse(w, r, errors.New("user account is disabled or expired"), "", http.StatusUnauthorized)
+		return false
+	}
+	if err := checkHTTPUserLogin(&user, r); err != nil {
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return false
+	}
+
+	c := jwtTokenClaims{
+		Username:  user.Username,
+		Signature: user.GetSignature(),
+	}
+	return setAPIKeyToken(w, r, tokenAuth, &c, tokenAudienceAPIUser)
+}
+
+func setAPIKeyToken(w http.ResponseWriter, r *http.Request, tokenAuth *jwtauth.JWTAuth, c *jwtTokenClaims,
+	audience tokenAudience,
+) bool {
+	resp, err := c.createTokenResponse(tokenAuth, audience)
+	if err != nil {
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return false
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("Bearer %v", resp["access_token"]))
+	return true
+}
+
 func verifyCSRFHeader(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenString := r.Header.Get(csrfHeaderToken)
@@ -0,0 +1,393 @@
+package httpd
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/duo-labs/webauthn/protocol"
+	"golang.org/x/oauth2"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	oidcDefaultUsernameField = "preferred_username"
+	oidcPendingAuthTimeout   = 5 * time.Minute
+	oidcRequestTimeout       = 30 * time.Second
+)
+
+const oidcStateCookieName = "oidc_state"
+
+var (
+	oidcMgr *oidcManager
+	// OpenID Connect logins waiting for the second factor
+	oidcPendingLogins sync.Map
+)
+
+// OIDC defines the OpenID Connect configuration to login to the web admin
+// using an external identity provider
+type OIDC struct {
+	// ClientID is the application's ID
+	ClientID string `json:"client_id" mapstructure:"client_id"`
+	// ClientSecret is the application's secret
+	ClientSecret string `json:"client_secret" mapstructure:"client_secret"`
+	// ConfigURL is the identifier for the service.
+	// SFTPGo will try to retrieve the provider configuration on startup and then
+	// will refuse to start if it fails to connect to the specified URL
+	ConfigURL string `json:"config_url" mapstructure:"config_url"`
+	// RedirectBaseURL is the base URL to redirect to after OpenID authentication.
+	// The suffix "/web/oidc/redirect" will be added to this base URL
+	RedirectBaseURL string `json:"redirect_base_url" mapstructure:"redirect_base_url"`
+	// UsernameField is the ID token claim field to map to the SFTPGo admin username.
+	// Default "preferred_username"
+	UsernameField string `json:"username_field" mapstructure:"username_field"`
+	// Scopes to request, the "openid" scope is always added
+	Scopes []string `json:"scopes" mapstructure:"scopes"`
+}
+
+func (o *OIDC) isEnabled() bool {
+	return o.ConfigURL != ""
+}
+
+func (o *OIDC) getRedirectURL() string {
+	return strings.TrimSuffix(o.RedirectBaseURL, "/") + webOIDCRedirectPath
+}
+
+func (o *OIDC) getScopes() []string {
+	scopes := []string{oidc.ScopeOpenID}
+	for _, scope := range o.Scopes {
+		scope = strings.TrimSpace(scope)
+		if scope != "" && !utils.IsStringInSlice(scope, scopes) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+func (o *OIDC) validate() error {
+	if o.ClientID == "" {
+		return errors.New("oidc: client ID is mandatory")
+	}
+	if o.ClientSecret == "" {
+		return errors.New("oidc: client secret is mandatory")
+	}
+	if !strings.HasPrefix(o.RedirectBaseURL, "http") {
+		return fmt.Errorf("oidc: invalid redirect base URL %#v", o.RedirectBaseURL)
+	}
+	if o.UsernameField == "" {
+		o.UsernameField = oidcDefaultUsernameField
+	}
+	return nil
+}
+
+func (o *OIDC) initialize() error {
+	if !o.isEnabled() {
+		oidcMgr = nil
+		return nil
+	}
+	if err := o.validate(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(getOIDCContext(), oidcRequestTimeout)
+	defer cancel()
+
+	provider, err := oidc.NewProvider(ctx, o.ConfigURL)
+	if err != nil {
+		return fmt.Errorf("oidc: unable to initialize provider for URL %#v: %w", o.ConfigURL, err)
+	}
+	oidcMgr = &oidcManager{
+		config:   *o,
+		verifier: provider.Verifier(&oidc.Config{ClientID: o.ClientID}),
+		oauth2Config: &oauth2.Config{
+			ClientID:     o.ClientID,
+			ClientSecret: o.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  o.getRedirectURL(),
+			Scopes:       o.getScopes(),
+		},
+	}
+	logger.Debug(logSender, "", "OpenID Connect initialized, config URL %#v, redirect URL %#v", o.ConfigURL,
+		oidcMgr.oauth2Config.RedirectURL)
+	return nil
+}
+
+type oidcManager struct {
+	config       OIDC
+	verifier     *oidc.IDTokenVerifier
+	oauth2Config *oauth2.Config
+}
+
+// getUsername returns the admin username from the given ID token claims
+func (m *oidcManager) getUsername(claims map[string]interface{}) (string, error) {
+	username, ok := claims[m.config.UsernameField].(string)
+	if !ok || username == "" {
+		return "", fmt.Errorf("the ID token has no string claim %#v", m.config.UsernameField)
+	}
+	return username, nil
+}
+
+// exchangeCode exchanges the given authorization code and returns the
+// claims from the verified ID token
+func (m *oidcManager) exchangeCode(code, nonce string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(getOIDCContext(), oidcRequestTimeout)
+	defer cancel()
+
+	oauth2Token, err := m.oauth2Config.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("unable to exchange the authorization code: %w", err)
+	}
+	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, errors.New("the token response has no ID token")
+	}
+	idToken, err := m.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("unable to verify the ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("the ID token nonce does not match")
+	}
+	claims := make(map[string]interface{})
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("unable to decode the ID token claims: %w", err)
+	}
+	return claims, nil
+}
+
+// oidcPendingLogin is an OpenID Connect login waiting for the second factor
+type oidcPendingLogin struct {
+	Username string
+	// State is the OpenID Connect state, it must match the one stored inside
+	// the state cookie of the browser that started the login
+	State    string
+	IssuedAt time.Time
+}
+
+func (l *oidcPendingLogin) isExpired() bool {
+	return time.Since(l.IssuedAt) > oidcPendingAuthTimeout
+}
+
+// getOIDCPendingLogin returns and removes the pending login with the given ID
+func getOIDCPendingLogin(loginID string) (oidcPendingLogin, error) {
+	val, ok := oidcPendingLogins.Load(loginID)
+	if !ok {
+		return oidcPendingLogin{}, errors.New("unable to find the OpenID Connect login")
+	}
+	oidcPendingLogins.Delete(loginID)
+	pendingLogin := val.(oidcPendingLogin)
+	if pendingLogin.isExpired() {
+		return pendingLogin, errors.New("the OpenID Connect login is expired")
+	}
+	return pendingLogin, nil
+}
+
+func cleanupExpiredOIDCPendingLogins() {
+	oidcPendingLogins.Range(func(key, value interface{}) bool {
+		pendingLogin, ok := value.(oidcPendingLogin)
+		if !ok || pendingLogin.isExpired() {
+			oidcPendingLogins.Delete(key)
+		}
+		return true
+	})
+}
+
+// setOIDCStateCookie stores the state and the nonce for a new authentication inside a
+// short-lived cookie, so the authentication can only be completed by the same browser
+func setOIDCStateCookie(w http.ResponseWriter, r *http.Request, state, nonce string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    state + ":" + nonce,
+		Path:     webOIDCBasePath,
+		Expires:  time.Now().Add(oidcPendingAuthTimeout),
+		MaxAge:   int(oidcPendingAuthTimeout / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// the identity provider redirects the browser back to us with a top level
+		// cross-site navigation, so we cannot use the strict mode here
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func removeOIDCStateCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    "",
+		Path:     webOIDCBasePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// getOIDCStateFromCookie returns the state and the nonce stored inside the state cookie
+func getOIDCStateFromCookie(r *http.Request) (string, string, error) {
+	cookie, err := r.Cookie(oidcStateCookieName)
+	if err != nil {
+		return "", "", errors.New("unable to find the OpenID Connect state")
+	}
+	values := strings.Split(cookie.Value, ":")
+	if len(values) != 2 || values[0] == "" || values[1] == "" {
+		return "", "", errors.New("invalid OpenID Connect state")
+	}
+	return values[0], values[1], nil
+}
+
+// checkOIDCState returns an error if the given state does not match the one
+// stored inside the state cookie. The nonce is returned if the state matches
+func checkOIDCState(r *http.Request, state string) (string, error) {
+	cookieState, nonce, err := getOIDCStateFromCookie(r)
+	if err != nil {
+		return "", err
+	}
+	if subtle.ConstantTimeCompare([]byte(cookieState), []byte(state)) != 1 {
+		return "", errors.New("the OpenID Connect state does not match")
+	}
+	return nonce, nil
+}
+
+func getOIDCContext() context.Context {
+	return oidc.ClientContext(context.Background(), httpclient.GetHTTPClient())
+}
+
+func handleWebOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if oidcMgr == nil {
+		renderNotFoundPage(w, r, errors.New("OpenID Connect is not configured"))
+		return
+	}
+	state := hex.EncodeToString(utils.GenerateRandomBytes(32))
+	nonce := hex.EncodeToString(utils.GenerateRandomBytes(32))
+	setOIDCStateCookie(w, r, state, nonce)
+	http.Redirect(w, r, oidcMgr.oauth2Config.AuthCodeURL(state, oidc.Nonce(nonce)), http.StatusFound)
+}
+
+func (s *httpdServer) handleWebOIDCRedirect(w http.ResponseWriter, r *http.Request) {
+	if oidcMgr == nil {
+		renderNotFoundPage(w, r, errors.New("OpenID Connect is not configured"))
+		return
+	}
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		logger.Debug(logSender, "", "OpenID Connect authentication error: %v, description: %v", errCode,
+			r.URL.Query().Get("error_description"))
+		removeOIDCStateCookie(w, r)
+		renderLoginPage(w, fmt.Sprintf("OpenID Connect authentication error: %v", errCode))
+		return
+	}
+	state := r.URL.Query().Get("state")
+	nonce, err := checkOIDCState(r, state)
+	if err != nil {
+		renderLoginPage(w, err.Error())
+		return
+	}
+	claims, err := oidcMgr.exchangeCode(r.URL.Query().Get("code"), nonce)
+	if err != nil {
+		logger.Debug(logSender, "", "OpenID Connect authentication failed: %v", err)
+		removeOIDCStateCookie(w, r)
+		renderLoginPage(w, "OpenID Connect authentication failed")
+		return
+	}
+	username, err := oidcMgr.getUsername(claims)
+	if err != nil {
+		logger.Debug(logSender, "", "OpenID Connect authentication failed: %v", err)
+		removeOIDCStateCookie(w, r)
+		renderLoginPage(w, "OpenID Connect authentication failed")
+		return
+	}
+	admin, err := getOIDCAdmin(r, username)
+	if err != nil {
+		removeOIDCStateCookie(w, r)
+		renderLoginPage(w, err.Error())
+		return
+	}
+	if admin.Filters.TOTPConfig.Enabled {
+		// the state cookie is preserved, it is required to complete the login
+		loginID := hex.EncodeToString(utils.GenerateRandomBytes(32))
+		oidcPendingLogins.Store(loginID, oidcPendingLogin{
+			Username: admin.Username,
+			State:    state,
+			IssuedAt: time.Now(),
+		})
+		data := getLoginPageData("")
+		data.CurrentURL = webOIDCPasscodePath
+		data.OIDCLoginID = loginID
+		renderTemplate(w, templateLogin, data)
+		return
+	}
+	s.completeOIDCLogin(w, r, &admin)
+}
+
+// handleWebOIDCPasscodePost verifies the second factor for an OpenID Connect login
+func (s *httpdServer) handleWebOIDCPasscodePost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if oidcMgr == nil {
+		renderNotFoundPage(w, r, errors.New("OpenID Connect is not configured"))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderLoginPage(w, err.Error())
+		return
+	}
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken)); err != nil {
+		renderLoginPage(w, err.Error())
+		return
+	}
+	pendingLogin, err := getOIDCPendingLogin(r.Form.Get("oidc_login"))
+	if err != nil {
+		renderLoginPage(w, err.Error())
+		return
+	}
+	if _, err := checkOIDCState(r, pendingLogin.State); err != nil {
+		renderLoginPage(w, err.Error())
+		return
+	}
+	removeOIDCStateCookie(w, r)
+	admin, err := getOIDCAdmin(r, pendingLogin.Username)
+	if err != nil {
+		renderLoginPage(w, err.Error())
+		return
+	}
+	if err := dataprovider.CheckAdminPasscode(&admin, strings.TrimSpace(r.Form.Get("passcode"))); err != nil {
+		renderLoginPage(w, err.Error())
+		return
+	}
+	s.completeOIDCLogin(w, r, &admin)
+}
+
+// completeOIDCLogin logs in the given admin after the OpenID Connect authentication
+// and the passcode verification, if any. A WebAuthn login is started if required
+func (s *httpdServer) completeOIDCLogin(w http.ResponseWriter, r *http.Request, admin *dataprovider.Admin) {
+	removeOIDCStateCookie(w, r)
+	if webAuthnMgr != nil && admin.IsWebAuthnRequired() {
+		renderWebAuthnLoginPage(w, admin, protocol.VerificationDiscouraged)
+		return
+	}
+	s.loginWebAdmin(w, r, admin)
+}
+
+// getOIDCAdmin returns the admin with the given username if it can login
+func getOIDCAdmin(r *http.Request, username string) (dataprovider.Admin, error) {
+	admin, err := dataprovider.AdminExists(username)
+	if err != nil {
+		logger.Debug(logSender, "", "unable to get the admin %#v for OpenID Connect login: %v", username, err)
+		return admin, dataprovider.ErrInvalidCredentials
+	}
+	if admin.Status != 1 {
+		return admin, fmt.Errorf("admin %#v is disabled", admin.Username)
+	}
+	ipAddr := utils.GetIPFromRemoteAddress(r.RemoteAddr)
+	if !admin.CanLoginFromIP(ipAddr) {
+		return admin, fmt.Errorf("login from IP %v not allowed", ipAddr)
+	}
+	return admin, nil
+}
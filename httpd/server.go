@@ -7,8 +7,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/duo-labs/webauthn/protocol"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/jwtauth"
@@ -26,16 +28,20 @@ var compressor = middleware.NewCompressor(5)
 type httpdServer struct {
 	binding         Binding
 	staticFilesPath string
+	openAPIPath     string
 	enableWebAdmin  bool
+	renderOpenAPI   bool
 	router          *chi.Mux
 	tokenAuth       *jwtauth.JWTAuth
 }
 
-func newHttpdServer(b Binding, staticFilesPath string, enableWebAdmin bool) *httpdServer {
+func newHttpdServer(b Binding, staticFilesPath, openAPIPath string, enableWebAdmin bool) *httpdServer {
 	return &httpdServer{
 		binding:         b,
 		staticFilesPath: staticFilesPath,
+		openAPIPath:     openAPIPath,
 		enableWebAdmin:  enableWebAdmin && b.EnableWebAdmin,
+		renderOpenAPI:   openAPIPath != "" && b.RenderOpenAPI,
 	}
 }
 
@@ -59,10 +65,15 @@ func (s *httpdServer) listenAndServe() error {
 		logger.Debug(logSender, "", "configured TLS cipher suites for binding %#v: %v", s.binding.GetAddress(),
 			config.CipherSuites)
 		httpServer.TLSConfig = config
-		if s.binding.ClientAuthType == 1 {
+		switch s.binding.ClientAuthType {
+		case 1:
 			httpServer.TLSConfig.ClientCAs = certMgr.GetRootCAs()
 			httpServer.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
 			httpServer.TLSConfig.VerifyConnection = s.verifyTLSConnection
+		case 2:
+			httpServer.TLSConfig.ClientCAs = certMgr.GetRootCAs()
+			httpServer.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			httpServer.TLSConfig.VerifyConnection = s.verifyTLSConnection
 		}
 		return utils.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, true, logSender)
 	}
@@ -76,6 +87,9 @@ func (s *httpdServer) verifyTLSConnection(state tls.ConnectionState) error {
 		if len(state.PeerCertificates) > 0 {
 			clientCrt = state.PeerCertificates[0]
 			clientCrtName = clientCrt.Subject.String()
+		} else if s.binding.ClientAuthType == 2 {
+			// the client certificate is optional
+			return nil
 		}
 		if len(state.VerifiedChains) == 0 {
 			logger.Warn(logSender, "", "TLS connection cannot be verified: unable to get verification chain")
@@ -96,6 +110,15 @@ func (s *httpdServer) verifyTLSConnection(state tls.ConnectionState) error {
 	return nil
 }
 
+// getVerifiedClientCertificate returns the client certificate for the given request
+// if the request was received over a TLS connection and the certificate was verified
+func getVerifiedClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
 func (s *httpdServer) refreshCookie(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		s.checkCookieExpiration(w, r)
@@ -124,6 +147,19 @@ func (s *httpdServer) handleWebLoginPost(w http.ResponseWriter, r *http.Request)
 		renderLoginPage(w, err.Error())
 		return
 	}
+	if err := dataprovider.CheckAdminPasscode(&admin, strings.TrimSpace(r.Form.Get("passcode"))); err != nil {
+		renderLoginPage(w, err.Error())
+		return
+	}
+	if webAuthnMgr != nil && admin.IsWebAuthnRequired() {
+		renderWebAuthnLoginPage(w, &admin, protocol.VerificationDiscouraged)
+		return
+	}
+	s.loginWebAdmin(w, r, &admin)
+}
+
+// loginWebAdmin sets the web admin cookie for an already authenticated admin
+func (s *httpdServer) loginWebAdmin(w http.ResponseWriter, r *http.Request, admin *dataprovider.Admin) {
 	if connAddr, ok := r.Context().Value(connAddrKey).(string); ok {
 		if connAddr != r.RemoteAddr {
 			if !admin.CanLoginFromIP(utils.GetIPFromRemoteAddress(connAddr)) {
@@ -132,13 +168,18 @@ func (s *httpdServer) handleWebLoginPost(w http.ResponseWriter, r *http.Request)
 			}
 		}
 	}
+	if !admin.CanLoginWithCertificate(getVerifiedClientCertificate(r)) {
+		renderLoginPage(w, "A valid TLS client certificate is required for this admin")
+		return
+	}
 	c := jwtTokenClaims{
 		Username:    admin.Username,
 		Permissions: admin.Permissions,
+		Groups:      admin.Filters.Groups,
 		Signature:   admin.GetSignature(),
 	}
 
-	err = c.createAndSetCookie(w, r, s.tokenAuth)
+	err := c.createAndSetCookie(w, r, s.tokenAuth)
 	if err != nil {
 		renderLoginPage(w, err.Error())
 		return
@@ -165,6 +206,17 @@ func (s *httpdServer) getToken(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
+	if err := dataprovider.CheckAdminPasscode(&admin, strings.TrimSpace(r.Header.Get(otpHeader))); err != nil {
+		w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	// security keys cannot be used for REST API authentication
+	if webAuthnMgr != nil && admin.IsWebAuthnRequired() {
+		sendAPIResponse(w, r, errors.New("a security key is required for this admin, please use an API key"),
+			http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
 
 	s.checkAddrAndSendToken(w, r, admin)
 }
@@ -178,12 +230,28 @@ func (s *httpdServer) checkAddrAndSendToken(w http.ResponseWriter, r *http.Reque
 			}
 		}
 	}
+	if !admin.CanLoginWithCertificate(getVerifiedClientCertificate(r)) {
+		sendAPIResponse(w, r, errAdminCertificateRequired,
+			http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	restrictions, err := getTokenRestrictions(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
 
 	c := jwtTokenClaims{
 		Username:    admin.Username,
 		Permissions: admin.Permissions,
+		Groups:      admin.Filters.Groups,
 		Signature:   admin.GetSignature(),
 	}
+	if err := c.applyRestrictions(restrictions); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusForbidden)
+		return
+	}
 
 	resp, err := c.createTokenResponse(s.tokenAuth, tokenAudienceAPI)
 
@@ -195,6 +263,167 @@ func (s *httpdServer) checkAddrAndSendToken(w http.ResponseWriter, r *http.Reque
 	render.JSON(w, r, resp)
 }
 
+// refreshToken issues a new API token for the admin authenticated by the current,
+// still valid, token. The current token is invalidated
+func (s *httpdServer) refreshToken(w http.ResponseWriter, r *http.Request) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" || claims.Signature == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	admin, err := dataprovider.AdminExists(claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if admin.Status != 1 {
+		logger.Debug(logSender, "", "admin %#v is disabled, unable to refresh token", admin.Username)
+		sendAPIResponse(w, r, nil, "Admin account is disabled", http.StatusUnauthorized)
+		return
+	}
+	if admin.GetSignature() != claims.Signature {
+		logger.Debug(logSender, "", "signature mismatch for admin %#v, unable to refresh token", admin.Username)
+		sendAPIResponse(w, r, nil, "Your credentials have been changed, please login again", http.StatusUnauthorized)
+		return
+	}
+	if !admin.CanLoginFromIP(utils.GetIPFromRemoteAddress(r.RemoteAddr)) {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if connAddr, ok := r.Context().Value(connAddrKey).(string); ok {
+		if connAddr != r.RemoteAddr && !admin.CanLoginFromIP(utils.GetIPFromRemoteAddress(connAddr)) {
+			sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	}
+	if !admin.CanLoginWithCertificate(getVerifiedClientCertificate(r)) {
+		sendAPIResponse(w, r, errAdminCertificateRequired,
+			http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	c := jwtTokenClaims{
+		Username:    admin.Username,
+		Permissions: admin.Permissions,
+		Groups:      admin.Filters.Groups,
+		Signature:   admin.GetSignature(),
+	}
+	if err := c.applyRestrictions(claims.Restrictions); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusForbidden)
+		return
+	}
+	s.sendRefreshedToken(w, r, &c, tokenAudienceAPI)
+}
+
+// getTokenRestrictions returns the restrictions requested for a new API token
+// using the optional query parameters, nil means no restrictions
+func getTokenRestrictions(r *http.Request) (*dataprovider.APIKeyRestrictions, error) {
+	restrictions := dataprovider.APIKeyRestrictions{
+		Permissions: getSliceFromDelimitedValues(r.URL.Query().Get("permissions"), ","),
+		Users:       getSliceFromDelimitedValues(r.URL.Query().Get("users"), ","),
+		Groups:      getSliceFromDelimitedValues(r.URL.Query().Get("groups"), ","),
+	}
+	if err := restrictions.Validate(); err != nil {
+		return nil, err
+	}
+	if restrictions.IsEmpty() {
+		return nil, nil
+	}
+	return &restrictions, nil
+}
+
+func (s *httpdServer) getUserToken(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	ipAddr := utils.GetIPFromRemoteAddress(r.RemoteAddr)
+	if common.IsBanned(ipAddr) {
+		sendAPIResponse(w, r, common.ErrConnectionDenied, "", http.StatusForbidden)
+		return
+	}
+	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolHTTP); err != nil {
+		sendAPIResponse(w, r, common.ErrConnectionDenied, "", http.StatusForbidden)
+		return
+	}
+	user, err := dataprovider.CheckUserAndPass(username, password, ipAddr, common.ProtocolHTTP)
+	if err != nil {
+		updateLoginMetrics(&user, ipAddr, err)
+		w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
+		sendAPIResponse(w, r, dataprovider.ErrInvalidCredentials, http.StatusText(http.StatusUnauthorized),
+			http.StatusUnauthorized)
+		return
+	}
+	if err := checkHTTPUserLogin(&user, r); err != nil {
+		updateLoginMetrics(&user, ipAddr, err)
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	updateLoginMetrics(&user, ipAddr, nil)
+	dataprovider.UpdateLastLogin(&user, common.ProtocolHTTP) //nolint:errcheck
+
+	c := jwtTokenClaims{
+		Username:  user.Username,
+		Signature: user.GetSignature(),
+	}
+	resp, err := c.createTokenResponse(s.tokenAuth, tokenAudienceAPIUser)
+	if err != nil {
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(w, r, resp)
+}
+
+// refreshUserToken issues a new user API token for the user authenticated by the
+// current, still valid, token. The current token is invalidated
+func (s *httpdServer) refreshUserToken(w http.ResponseWriter, r *http.Request) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" || claims.Signature == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.UserExists(claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if user.Status != 1 || user.IsExpired() {
+		logger.Debug(logSender, "", "user %#v is disabled or expired, unable to refresh token", user.Username)
+		sendAPIResponse(w, r, nil, "User account is disabled or expired", http.StatusUnauthorized)
+		return
+	}
+	if user.GetSignature() != claims.Signature {
+		logger.Debug(logSender, "", "signature mismatch for user %#v, unable to refresh token", user.Username)
+		sendAPIResponse(w, r, nil, "Your credentials have been changed, please login again", http.StatusUnauthorized)
+		return
+	}
+	if err := checkHTTPUserLogin(&user, r); err != nil {
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	c := jwtTokenClaims{
+		Username:  user.Username,
+		Signature: user.GetSignature(),
+	}
+	s.sendRefreshedToken(w, r, &c, tokenAudienceAPIUser)
+}
+
+func (s *httpdServer) sendRefreshedToken(w http.ResponseWriter, r *http.Request, c *jwtTokenClaims,
+	audience tokenAudience,
+) {
+	resp, err := c.createTokenResponse(s.tokenAuth, audience)
+	if err != nil {
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	invalidateToken(r)
+	logger.Debug(logSender, "", "token refreshed for %#v, audience: %v", c.Username, audience)
+
+	render.JSON(w, r, resp)
+}
+
 func (s *httpdServer) checkCookieExpiration(w http.ResponseWriter, r *http.Request) {
 	token, claims, err := jwtauth.FromContext(r.Context())
 	if err != nil {
@@ -233,6 +462,11 @@ func (s *httpdServer) checkCookieExpiration(w http.ResponseWriter, r *http.Reque
 			}
 		}
 	}
+	if !admin.CanLoginWithCertificate(getVerifiedClientCertificate(r)) {
+		logger.Debug(logSender, "", "admin %#v requires a valid TLS client certificate, unable to refresh cookie",
+			admin.Username)
+		return
+	}
 	logger.Debug(logSender, "", "cookie refreshed for admin %#v", admin.Username)
 	tokenClaims.createAndSetCookie(w, r, s.tokenAuth) //nolint:errcheck
 }
@@ -266,7 +500,7 @@ func (s *httpdServer) initializeRouter() {
 
 	s.router.Group(func(router chi.Router) {
 		router.Use(middleware.RequestID)
-		router.Use(middleware.RealIP)
+		router.Use(s.checkProxyHeaders)
 		router.Use(logger.NewStructuredLogger(logger.GetLogger()))
 		router.Use(middleware.Recoverer)
 
@@ -279,17 +513,32 @@ func (s *httpdServer) initializeRouter() {
 			sendAPIResponse(w, r, nil, "Not Found", http.StatusNotFound)
 		}))
 
-		router.Get(tokenPath, s.getToken)
+		router.With(rateLimitAPIRequests(s.tokenAuth)).Get(tokenPath, s.getToken)
+		router.With(rateLimitAPIRequests(s.tokenAuth)).Get(userTokenPath, s.getUserToken)
+		router.Options(userUploadsPath, handleUploadsOptions)
+		router.With(rateLimitAPIRequests(s.tokenAuth)).Get(publicSharesPath+"/{id}", getPublicShare)
+		router.With(rateLimitAPIRequests(s.tokenAuth)).Post(publicSharesPath+"/{id}", uploadToPublicShare)
+
+		if s.renderOpenAPI {
+			router.Group(func(router chi.Router) {
+				router.Use(compressor.Handler)
+				fileServer(router, webOpenAPIPath, http.Dir(s.openAPIPath))
+			})
+		}
 
 		router.Group(func(router chi.Router) {
+			router.Use(rateLimitAPIRequests(s.tokenAuth))
+			router.Use(checkAPIKeyAuth(s.tokenAuth, dataprovider.APIKeyScopeAdmin))
 			router.Use(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromHeader))
 			router.Use(jwtAuthenticator)
+			router.Use(auditAdminRequest)
 
 			router.Get(versionPath, func(w http.ResponseWriter, r *http.Request) {
 				render.JSON(w, r, version.Get())
 			})
 
 			router.Get(logoutPath, s.logout)
+			router.Get(tokenRefreshPath, s.refreshToken)
 			router.Put(adminPwdPath, changeAdminPassword)
 
 			router.With(checkPerm(dataprovider.PermAdminViewServerStatus)).
@@ -297,27 +546,57 @@ func (s *httpdServer) initializeRouter() {
 					render.JSON(w, r, getServicesStatus())
 				})
 
-			router.With(checkPerm(dataprovider.PermAdminViewConnections)).
-				Get(activeConnectionsPath, func(w http.ResponseWriter, r *http.Request) {
-					render.JSON(w, r, common.Connections.GetStats())
-				})
+			router.With(checkPerm(dataprovider.PermAdminViewConnections)).Get(activeConnectionsPath, getActiveConnections)
 
+			router.With(checkPerm(dataprovider.PermAdminViewConnections)).Get(connectionHistoryPath, getConnectionHistory)
+			router.With(checkPerm(dataprovider.PermAdminViewServerStatus)).Get(diagnosticsPath, getDiagnostics)
+			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Delete(diagnosticsPath, resetDiagnostics)
+			router.With(checkPerm(dataprovider.PermAdminViewEvents)).Get(auditEventsPath, getAuditEvents)
 			router.With(checkPerm(dataprovider.PermAdminCloseConnections)).
 				Delete(activeConnectionsPath+"/{connectionID}", handleCloseConnection)
+			router.With(checkPerm(dataprovider.PermAdminCloseConnections), checkUserScope).
+				Delete(activeConnectionsPath+"/users/{username}", closeUserConnections)
 			router.With(checkPerm(dataprovider.PermAdminQuotaScans)).Get(quotaScanPath, getQuotaScans)
 			router.With(checkPerm(dataprovider.PermAdminQuotaScans)).Post(quotaScanPath, startQuotaScan)
 			router.With(checkPerm(dataprovider.PermAdminQuotaScans)).Get(quotaScanVFolderPath, getVFolderQuotaScans)
 			router.With(checkPerm(dataprovider.PermAdminQuotaScans)).Post(quotaScanVFolderPath, startVFolderQuotaScan)
+			router.With(checkPerm(dataprovider.PermAdminRetentionChecks)).Get(retentionChecksPath, getRetentionChecks)
+			router.With(checkPerm(dataprovider.PermAdminRetentionChecks), checkUserScope).
+				Post(retentionBasePath+"/{username}/check", startRetentionCheck)
+			router.With(checkPerm(dataprovider.PermAdminRetentionChecks), checkUserScope).
+				Get(retentionBasePath+"/{username}/report", getRetentionReport)
+			router.With(checkPerm(dataprovider.PermAdminRetentionChecks)).
+				Get(retentionFolderChecksPath, getFolderRetentionChecks)
+			router.With(checkPerm(dataprovider.PermAdminRetentionChecks)).
+				Post(retentionFoldersBasePath+"/{name}/check", startFolderRetentionCheck)
+			router.With(checkPerm(dataprovider.PermAdminRetentionChecks)).
+				Get(retentionFoldersBasePath+"/{name}/report", getFolderRetentionReport)
 			router.With(checkPerm(dataprovider.PermAdminViewUsers)).Get(userPath, getUsers)
 			router.With(checkPerm(dataprovider.PermAdminAddUsers)).Post(userPath, addUser)
-			router.With(checkPerm(dataprovider.PermAdminViewUsers)).Get(userPath+"/{username}", getUserByUsername)
-			router.With(checkPerm(dataprovider.PermAdminChangeUsers)).Put(userPath+"/{username}", updateUser)
-			router.With(checkPerm(dataprovider.PermAdminDeleteUsers)).Delete(userPath+"/{username}", deleteUser)
+			router.With(checkPerm(dataprovider.PermAdminAddUsers)).Post(userTemplatePath, addUsersFromTemplate)
+			router.With(checkPerm(dataprovider.PermAdminViewUsers)).Get(usersCSVPath, exportUsersAsCSV)
+			router.With(checkPerm(dataprovider.PermAdminAddUsers)).Post(usersCSVPath, importUsersFromCSV)
+			router.Post(usersBulkPath, bulkUpdateUsers)
+			router.With(checkPerm(dataprovider.PermAdminViewUsers), checkUserScope).Get(userPath+"/{username}", getUserByUsername)
+			router.With(checkPerm(dataprovider.PermAdminChangeUsers), checkUserScope).Put(userPath+"/{username}", updateUser)
+			router.With(checkPerm(dataprovider.PermAdminDeleteUsers), checkUserScope).Delete(userPath+"/{username}", deleteUser)
+			router.With(checkPerm(dataprovider.PermAdminChangeUsers), checkUserScope).
+				Post(userPath+"/{username}/2fa/recoverycodes", generateUserRecoveryCodes)
+			router.With(checkPerm(dataprovider.PermAdminAddUsers), checkUserScope).
+				Post(userPath+"/{username}/tempcredentials", addTempCredentials)
+			router.With(checkPerm(dataprovider.PermAdminChangeUsers), checkUserScope).
+				Post(userPath+"/{username}/credentials/password", generateUserPassword)
+			router.With(checkPerm(dataprovider.PermAdminChangeUsers), checkUserScope).
+				Post(userPath+"/{username}/credentials/keypair", generateUserKeyPair)
+			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Delete(usersCachePath, clearUsersCache)
+			router.With(checkPerm(dataprovider.PermAdminChangeUsers), checkUserScope).Delete(usersCachePath+"/{username}", removeCachedUser)
 			router.With(checkPerm(dataprovider.PermAdminViewUsers)).Get(folderPath, getFolders)
 			router.With(checkPerm(dataprovider.PermAdminViewUsers)).Get(folderPath+"/{name}", getFolderByName)
 			router.With(checkPerm(dataprovider.PermAdminAddUsers)).Post(folderPath, addFolder)
 			router.With(checkPerm(dataprovider.PermAdminChangeUsers)).Put(folderPath+"/{name}", updateFolder)
 			router.With(checkPerm(dataprovider.PermAdminDeleteUsers)).Delete(folderPath+"/{name}", deleteFolder)
+			router.With(checkPerm(dataprovider.PermAdminQuotaScans)).Get(folderPath+"/{name}/quota-scan", getFolderQuotaScanByName)
+			router.With(checkPerm(dataprovider.PermAdminQuotaScans)).Post(folderPath+"/{name}/quota-scan", startFolderQuotaScanByName)
 			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Get(dumpDataPath, dumpData)
 			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Get(loadDataPath, loadData)
 			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Post(loadDataPath, loadDataFromRequest)
@@ -326,11 +605,60 @@ func (s *httpdServer) initializeRouter() {
 			router.With(checkPerm(dataprovider.PermAdminViewDefender)).Get(defenderBanTime, getBanTime)
 			router.With(checkPerm(dataprovider.PermAdminViewDefender)).Get(defenderScore, getScore)
 			router.With(checkPerm(dataprovider.PermAdminManageDefender)).Post(defenderUnban, unban)
+			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Post(ipListsReloadPath, reloadIPLists)
+			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Get(logLevelPath, getLogLevel)
+			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Put(logLevelPath, updateLogLevel)
+			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Get(debugLogsPath, getDebugLogs)
+			router.With(checkPerm(dataprovider.PermAdminManageSystem)).
+				Put(debugLogsPath+"/connections/{connectionID}", enableConnectionDebugLogs)
+			router.With(checkPerm(dataprovider.PermAdminManageSystem)).
+				Delete(debugLogsPath+"/connections/{connectionID}", disableConnectionDebugLogs)
+			router.With(checkPerm(dataprovider.PermAdminManageSystem), checkUserScope).
+				Put(debugLogsPath+"/users/{username}", enableUserDebugLogs)
+			router.With(checkPerm(dataprovider.PermAdminManageSystem), checkUserScope).
+				Delete(debugLogsPath+"/users/{username}", disableUserDebugLogs)
 			router.With(checkPerm(dataprovider.PermAdminManageAdmins)).Get(adminPath, getAdmins)
 			router.With(checkPerm(dataprovider.PermAdminManageAdmins)).Post(adminPath, addAdmin)
 			router.With(checkPerm(dataprovider.PermAdminManageAdmins)).Get(adminPath+"/{username}", getAdminByUsername)
 			router.With(checkPerm(dataprovider.PermAdminManageAdmins)).Put(adminPath+"/{username}", updateAdmin)
 			router.With(checkPerm(dataprovider.PermAdminManageAdmins)).Delete(adminPath+"/{username}", deleteAdmin)
+			router.With(checkPerm(dataprovider.PermAdminManageAdmins)).Put(adminPath+"/{username}/2fa/disable", disableAdmin2FA)
+			router.With(checkPerm(dataprovider.PermAdminManageAPIKeys)).Get(apiKeysPath, getAPIKeys)
+			router.With(checkPerm(dataprovider.PermAdminManageAPIKeys)).Post(apiKeysPath, addAPIKey)
+			router.With(checkPerm(dataprovider.PermAdminManageAPIKeys)).Get(apiKeysPath+"/{id}", getAPIKeyByID)
+			router.With(checkPerm(dataprovider.PermAdminManageAPIKeys)).Put(apiKeysPath+"/{id}", updateAPIKey)
+			router.With(checkPerm(dataprovider.PermAdminManageAPIKeys)).Delete(apiKeysPath+"/{id}", deleteAPIKey)
+			router.With(checkPerm(dataprovider.PermAdminViewUsers)).Get(sharesPath, getShares)
+			router.With(checkPerm(dataprovider.PermAdminChangeUsers)).Post(sharesPath, addShare)
+			router.With(checkPerm(dataprovider.PermAdminViewUsers)).Get(sharesPath+"/{id}", getShareByID)
+			router.With(checkPerm(dataprovider.PermAdminChangeUsers)).Put(sharesPath+"/{id}", updateShare)
+			router.With(checkPerm(dataprovider.PermAdminChangeUsers)).Delete(sharesPath+"/{id}", deleteShare)
+		})
+
+		router.Group(func(router chi.Router) {
+			router.Use(rateLimitAPIRequests(s.tokenAuth))
+			router.Use(checkAPIKeyAuth(s.tokenAuth, dataprovider.APIKeyScopeUser))
+			router.Use(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromHeader))
+			router.Use(jwtAuthenticatorAPIUser)
+
+			router.Get(userLogoutPath, s.logout)
+			router.Get(userTokenRefreshPath, s.refreshUserToken)
+			router.Get(userProfilePath, getUserProfile)
+			router.Get(userSharesPath, getUserShares)
+			router.Post(userSharesPath, addUserShare)
+			router.Get(userSharesPath+"/{id}", getUserShareByID)
+			router.Put(userSharesPath+"/{id}", updateUserShare)
+			router.Delete(userSharesPath+"/{id}", deleteUserShare)
+			router.With(checkResumableUploads).Post(userUploadsPath, createUpload)
+			router.With(checkResumableUploads).Head(userUploadsPath+"/{id}", getUploadOffset)
+			router.With(checkResumableUploads).Patch(userUploadsPath+"/{id}", writeUpload)
+			router.With(checkResumableUploads).Delete(userUploadsPath+"/{id}", deleteUpload)
+			router.Put(userPwdPath, changeUserPassword)
+			router.Get(userPublicKeysPath, getUserPublicKeys)
+			router.Put(userPublicKeysPath, setUserPublicKeys)
+			router.Post(userTOTPGeneratePath, generateUserTOTPSecret)
+			router.Post(userTOTPPath, saveUserTOTPConfig)
+			router.Post(userRecoveryCodesPath, generateLoggedUserRecoveryCodes)
 		})
 
 		if s.enableWebAdmin {
@@ -344,22 +672,40 @@ func (s *httpdServer) initializeRouter() {
 
 			router.Get(webLoginPath, handleWebLogin)
 			router.Post(webLoginPath, s.handleWebLoginPost)
+			router.Get(webOIDCLoginPath, handleWebOIDCLogin)
+			router.Get(webOIDCRedirectPath, s.handleWebOIDCRedirect)
+			router.Post(webOIDCPasscodePath, s.handleWebOIDCPasscodePost)
+			router.Post(webWebAuthnLoginPath, handleWebAuthnLoginPost)
+			router.Post(webWebAuthnVerifyPath, s.handleWebAuthnVerifyPost)
 
 			router.Group(func(router chi.Router) {
 				router.Use(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie))
 				router.Use(jwtAuthenticatorWeb)
+				router.Use(auditAdminRequest)
 
 				router.Get(webLogoutPath, handleWebLogout)
 				router.With(s.refreshCookie).Get(webChangeAdminPwdPath, handleWebAdminChangePwd)
 				router.Post(webChangeAdminPwdPath, handleWebAdminChangePwdPost)
+				router.With(s.refreshCookie).Get(webMFAPath, handleWebMFA)
+				router.Post(webMFAPath, handleWebMFAPost)
 				router.With(checkPerm(dataprovider.PermAdminViewUsers), s.refreshCookie).
 					Get(webUsersPath, handleGetWebUsers)
 				router.With(checkPerm(dataprovider.PermAdminAddUsers), s.refreshCookie).
 					Get(webUserPath, handleWebAddUserGet)
-				router.With(checkPerm(dataprovider.PermAdminChangeUsers), s.refreshCookie).
+				router.With(checkPerm(dataprovider.PermAdminChangeUsers), checkUserScope, s.refreshCookie).
 					Get(webUserPath+"/{username}", handleWebUpdateUserGet)
 				router.With(checkPerm(dataprovider.PermAdminAddUsers)).Post(webUserPath, handleWebAddUserPost)
-				router.With(checkPerm(dataprovider.PermAdminChangeUsers)).Post(webUserPath+"/{username}", handleWebUpdateUserPost)
+				router.With(checkPerm(dataprovider.PermAdminChangeUsers), checkUserScope).Post(webUserPath+"/{username}", handleWebUpdateUserPost)
+				router.With(checkPerm(dataprovider.PermAdminChangeUsers), checkUserScope, s.refreshCookie).
+					Get(webUserPath+"/{username}/permissions", handleWebUserPermissionsGet)
+				router.With(checkPerm(dataprovider.PermAdminChangeUsers), checkUserScope).
+					Post(webUserPath+"/{username}/permissions", handleWebUserPermissionsPost)
+				router.With(checkPerm(dataprovider.PermAdminBrowseFiles), checkUserScope, s.refreshCookie).
+					Get(webUserPath+"/{username}/files", handleWebUserFilesGet)
+				router.With(checkPerm(dataprovider.PermAdminBrowseFiles), checkUserScope).
+					Get(webUserPath+"/{username}/files/download", handleWebUserFileDownload)
+				router.With(checkPerm(dataprovider.PermAdminBrowseFiles), checkUserScope, verifyCSRFHeader).
+					Delete(webUserPath+"/{username}/files", handleWebUserFileDelete)
 				router.With(checkPerm(dataprovider.PermAdminViewConnections), s.refreshCookie).
 					Get(webConnectionsPath, handleWebGetConnections)
 				router.With(checkPerm(dataprovider.PermAdminViewUsers), s.refreshCookie).
@@ -388,7 +734,7 @@ func (s *httpdServer) initializeRouter() {
 					Delete(webFolderPath+"/{name}", deleteFolder)
 				router.With(checkPerm(dataprovider.PermAdminQuotaScans), verifyCSRFHeader).
 					Post(webScanVFolderPath, startVFolderQuotaScan)
-				router.With(checkPerm(dataprovider.PermAdminDeleteUsers), verifyCSRFHeader).
+				router.With(checkPerm(dataprovider.PermAdminDeleteUsers), checkUserScope, verifyCSRFHeader).
 					Delete(webUserPath+"/{username}", deleteUser)
 				router.With(checkPerm(dataprovider.PermAdminQuotaScans), verifyCSRFHeader).
 					Post(webQuotaScanPath, startQuotaScan)
@@ -401,11 +747,18 @@ func (s *httpdServer) initializeRouter() {
 				router.With(checkPerm(dataprovider.PermAdminManageSystem), s.refreshCookie).
 					Get(webTemplateFolder, handleWebTemplateFolderGet)
 				router.With(checkPerm(dataprovider.PermAdminManageSystem)).Post(webTemplateFolder, handleWebTemplateFolderPost)
+				router.With(checkPerm(dataprovider.PermAdminViewUsers)).Get(webUsersCSVPath, exportUsersAsCSV)
+				router.With(checkPerm(dataprovider.PermAdminAddUsers), s.refreshCookie).
+					Get(webUsersCSVImportPath, handleWebUsersCSVImportGet)
+				router.With(checkPerm(dataprovider.PermAdminAddUsers)).Post(webUsersCSVImportPath, handleWebUsersCSVImportPost)
 			})
 
 			router.Group(func(router chi.Router) {
 				router.Use(compressor.Handler)
 				fileServer(router, webStaticFilesPath, http.Dir(s.staticFilesPath))
+				if webBranding.dir != "" {
+					fileServer(router, webBrandingPath, http.Dir(webBranding.dir))
+				}
 			})
 		}
 	})
@@ -1,6 +1,7 @@
 package httpd
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"html/template"
@@ -53,6 +54,10 @@ const (
 	templateLogin        = "login.html"
 	templateChangePwd    = "changepwd.html"
 	templateMaintenance  = "maintenance.html"
+	templateMFA          = "mfa.html"
+	templatePermissions  = "permissions.html"
+	templateUsersImport  = "usersimport.html"
+	templateUserFiles    = "userfiles.html"
 	pageUsersTitle       = "Users"
 	pageAdminsTitle      = "Admins"
 	pageConnectionsTitle = "Connections"
@@ -60,6 +65,10 @@ const (
 	pageFoldersTitle     = "Folders"
 	pageChangePwdTitle   = "Change password"
 	pageMaintenanceTitle = "Maintenance"
+	pageMFATitle         = "Two-factor authentication"
+	pagePermissionsTitle = "Permissions"
+	pageUsersImportTitle = "Import users"
+	pageUserFilesTitle   = "Files"
 	page400Title         = "Bad request"
 	page403Title         = "Forbidden"
 	page404Title         = "Not found"
@@ -83,6 +92,8 @@ type basePage struct {
 	UsersURL           string
 	UserURL            string
 	UserTemplateURL    string
+	UsersCSVURL        string
+	UsersImportURL     string
 	AdminsURL          string
 	AdminURL           string
 	QuotaScanURL       string
@@ -92,6 +103,7 @@ type basePage struct {
 	FolderTemplateURL  string
 	LogoutURL          string
 	ChangeAdminPwdURL  string
+	MFAURL             string
 	FolderQuotaScanURL string
 	StatusURL          string
 	MaintenanceURL     string
@@ -104,6 +116,7 @@ type basePage struct {
 	Version            string
 	CSRFToken          string
 	LoggedAdmin        *dataprovider.Admin
+	Branding           brandingData
 }
 
 type usersPage struct {
@@ -139,6 +152,7 @@ type userPage struct {
 	ValidPerms           []string
 	ValidSSHLoginMethods []string
 	ValidProtocols       []string
+	ValidTOTPProtocols   []string
 	RootDirPerms         []string
 	RedactedSecret       string
 	Mode                 userPageMode
@@ -156,6 +170,21 @@ type changePwdPage struct {
 	Error string
 }
 
+type mfaPage struct {
+	basePage
+	TOTPEnabled          bool
+	UnusedRecoveryCodes  int
+	Secret               string
+	QRCode               template.URL
+	RecoveryCodes        []string
+	Error                string
+	WebAuthnEnabled      bool
+	WebAuthnCredentials  []dataprovider.WebAuthnCredential
+	WebAuthnPasswordless bool
+	WebAuthnOptions      template.JS
+	WebAuthnSessionID    string
+}
+
 type maintenancePage struct {
 	basePage
 	BackupPath  string
@@ -177,10 +206,17 @@ type messagePage struct {
 }
 
 type loginPage struct {
-	CurrentURL string
-	Version    string
-	Error      string
-	CSRFToken  string
+	CurrentURL        string
+	Version           string
+	Error             string
+	CSRFToken         string
+	OIDCLoginURL      string
+	WebAuthnLoginURL  string
+	WebAuthnOptions   template.JS
+	WebAuthnSessionID string
+	// OIDCLoginID is set for OpenID Connect logins waiting for the passcode
+	OIDCLoginID string
+	Branding    brandingData
 }
 
 type userTemplateFields struct {
@@ -237,6 +273,22 @@ func loadTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateBase),
 		filepath.Join(templatesPath, templateMaintenance),
 	}
+	mfaPath := []string{
+		filepath.Join(templatesPath, templateBase),
+		filepath.Join(templatesPath, templateMFA),
+	}
+	permissionsPath := []string{
+		filepath.Join(templatesPath, templateBase),
+		filepath.Join(templatesPath, templatePermissions),
+	}
+	usersImportPath := []string{
+		filepath.Join(templatesPath, templateBase),
+		filepath.Join(templatesPath, templateUsersImport),
+	}
+	userFilesPath := []string{
+		filepath.Join(templatesPath, templateBase),
+		filepath.Join(templatesPath, templateUserFiles),
+	}
 	usersTmpl := utils.LoadTemplate(template.ParseFiles(usersPaths...))
 	userTmpl := utils.LoadTemplate(template.ParseFiles(userPaths...))
 	adminsTmpl := utils.LoadTemplate(template.ParseFiles(adminsPaths...))
@@ -249,6 +301,10 @@ func loadTemplates(templatesPath string) {
 	loginTmpl := utils.LoadTemplate(template.ParseFiles(loginPath...))
 	changePwdTmpl := utils.LoadTemplate(template.ParseFiles(changePwdPaths...))
 	maintenanceTmpl := utils.LoadTemplate(template.ParseFiles(maintenancePath...))
+	mfaTmpl := utils.LoadTemplate(template.ParseFiles(mfaPath...))
+	permissionsTmpl := utils.LoadTemplate(template.ParseFiles(permissionsPath...))
+	usersImportTmpl := utils.LoadTemplate(template.ParseFiles(usersImportPath...))
+	userFilesTmpl := utils.LoadTemplate(template.ParseFiles(userFilesPath...))
 
 	templates[templateUsers] = usersTmpl
 	templates[templateUser] = userTmpl
@@ -262,6 +318,10 @@ func loadTemplates(templatesPath string) {
 	templates[templateLogin] = loginTmpl
 	templates[templateChangePwd] = changePwdTmpl
 	templates[templateMaintenance] = maintenanceTmpl
+	templates[templateMFA] = mfaTmpl
+	templates[templatePermissions] = permissionsTmpl
+	templates[templateUsersImport] = usersImportTmpl
+	templates[templateUserFiles] = userFilesTmpl
 }
 
 func getBasePageData(title, currentURL string, r *http.Request) basePage {
@@ -275,6 +335,8 @@ func getBasePageData(title, currentURL string, r *http.Request) basePage {
 		UsersURL:           webUsersPath,
 		UserURL:            webUserPath,
 		UserTemplateURL:    webTemplateUser,
+		UsersCSVURL:        webUsersCSVPath,
+		UsersImportURL:     webUsersCSVImportPath,
 		AdminsURL:          webAdminsPath,
 		AdminURL:           webAdminPath,
 		FoldersURL:         webFoldersPath,
@@ -282,6 +344,7 @@ func getBasePageData(title, currentURL string, r *http.Request) basePage {
 		FolderTemplateURL:  webTemplateFolder,
 		LogoutURL:          webLogoutPath,
 		ChangeAdminPwdURL:  webChangeAdminPwdPath,
+		MFAURL:             webMFAPath,
 		QuotaScanURL:       webQuotaScanPath,
 		ConnectionsURL:     webConnectionsPath,
 		StatusURL:          webStatusPath,
@@ -296,6 +359,7 @@ func getBasePageData(title, currentURL string, r *http.Request) basePage {
 		Version:            version.GetAsString(),
 		LoggedAdmin:        getAdminFromToken(r),
 		CSRFToken:          csrfToken,
+		Branding:           webBranding,
 	}
 }
 
@@ -400,6 +464,7 @@ func renderUserPage(w http.ResponseWriter, r *http.Request, user *dataprovider.U
 		ValidPerms:           dataprovider.ValidPerms,
 		ValidSSHLoginMethods: dataprovider.ValidSSHLoginMethods,
 		ValidProtocols:       dataprovider.ValidProtocols,
+		ValidTOTPProtocols:   dataprovider.ValidTOTPProtocols,
 		RootDirPerms:         user.GetPermissionsForPath("/"),
 		RedactedSecret:       redactedSecret,
 	}
@@ -651,10 +716,17 @@ func getFiltersFromUserPostFields(r *http.Request) dataprovider.UserFilters {
 	var filters dataprovider.UserFilters
 	filters.AllowedIP = getSliceFromDelimitedValues(r.Form.Get("allowed_ip"), ",")
 	filters.DeniedIP = getSliceFromDelimitedValues(r.Form.Get("denied_ip"), ",")
+	filters.AllowedCountries = getSliceFromDelimitedValues(r.Form.Get("allowed_countries"), ",")
+	filters.DeniedCountries = getSliceFromDelimitedValues(r.Form.Get("denied_countries"), ",")
 	filters.DeniedLoginMethods = r.Form["ssh_login_methods"]
 	filters.DeniedProtocols = r.Form["denied_protocols"]
 	filters.FileExtensions = getFileExtensionsFromPostField(r.Form.Get("allowed_extensions"), r.Form.Get("denied_extensions"))
 	filters.FilePatterns = getFilePatternsFromPostField(r.Form.Get("allowed_patterns"), r.Form.Get("denied_patterns"))
+	filters.Groups = getSliceFromDelimitedValues(r.Form.Get("groups"), ",")
+	filters.TOTPConfig.Enabled = len(r.Form.Get("totp_enabled")) > 0
+	filters.TOTPConfig.Secret = getSecretFromFormField(r, "totp_secret")
+	filters.TOTPConfig.Protocols = r.Form["totp_protocols"]
+	filters.AllowAPIKeyAuth = len(r.Form.Get("allow_api_key_auth")) > 0
 	return filters
 }
 
@@ -676,6 +748,10 @@ func getS3Config(r *http.Request) (vfs.S3FsConfig, error) {
 	config.Region = r.Form.Get("s3_region")
 	config.AccessKey = r.Form.Get("s3_access_key")
 	config.AccessSecret = getSecretFromFormField(r, "s3_access_secret")
+	config.CredentialsFile = r.Form.Get("s3_credentials_file")
+	config.CredentialsProfile = r.Form.Get("s3_credentials_profile")
+	config.AccessKeyEnv = r.Form.Get("s3_access_key_env")
+	config.AccessSecretEnv = r.Form.Get("s3_access_secret_env")
 	config.Endpoint = r.Form.Get("s3_endpoint")
 	config.StorageClass = r.Form.Get("s3_storage_class")
 	config.KeyPrefix = r.Form.Get("s3_key_prefix")
@@ -694,6 +770,8 @@ func getGCSConfig(r *http.Request) (vfs.GCSFsConfig, error) {
 	config.Bucket = r.Form.Get("gcs_bucket")
 	config.StorageClass = r.Form.Get("gcs_storage_class")
 	config.KeyPrefix = r.Form.Get("gcs_key_prefix")
+	config.SharedCredentialsFile = r.Form.Get("gcs_shared_credentials_file")
+	config.CredentialsEnv = r.Form.Get("gcs_credentials_env")
 	autoCredentials := r.Form.Get("gcs_auto_credentials")
 	if autoCredentials != "" {
 		config.AutomaticCredentials = 1
@@ -738,6 +816,8 @@ func getAzureConfig(r *http.Request) (vfs.AzBlobFsConfig, error) {
 	config.Container = r.Form.Get("az_container")
 	config.AccountName = r.Form.Get("az_account_name")
 	config.AccountKey = getSecretFromFormField(r, "az_account_key")
+	config.AccountKeyFile = r.Form.Get("az_account_key_file")
+	config.AccountKeyEnv = r.Form.Get("az_account_key_env")
 	config.SASURL = r.Form.Get("az_sas_url")
 	config.Endpoint = r.Form.Get("az_endpoint")
 	config.KeyPrefix = r.Form.Get("az_key_prefix")
@@ -801,6 +881,9 @@ func getAdminFromPostFields(r *http.Request) (dataprovider.Admin, error) {
 	admin.Email = r.Form.Get("email")
 	admin.Status = status
 	admin.Filters.AllowList = getSliceFromDelimitedValues(r.Form.Get("allowed_ip"), ",")
+	admin.Filters.Groups = getSliceFromDelimitedValues(r.Form.Get("groups"), ",")
+	admin.Filters.AllowAPIKeyAuth = len(r.Form.Get("allow_api_key_auth")) > 0
+	admin.Filters.TLSCertCommonNames = getSliceFromDelimitedValues(r.Form.Get("tls_cert_common_names"), ",")
 	admin.AdditionalInfo = r.Form.Get("additional_info")
 	return admin, nil
 }
@@ -978,19 +1061,194 @@ func getUserFromPostFields(r *http.Request) (dataprovider.User, error) {
 		FsConfig:          fsConfig,
 		AdditionalInfo:    r.Form.Get("additional_info"),
 	}
+	transferQuota, err := getTransferQuotaFromPostFields(r)
+	if err != nil {
+		return user, err
+	}
+	user.Filters.TransferQuota = transferQuota
+	user.Filters.PartialUploads = dataprovider.PartialUploads{
+		Policy:         r.Form.Get("partial_uploads_policy"),
+		QuarantinePath: strings.TrimSpace(r.Form.Get("partial_uploads_quarantine_path")),
+	}
+	bandwidthLimits, err := getBandwidthLimitsFromPostField(r.Form.Get("bandwidth_limits"))
+	if err != nil {
+		return user, err
+	}
+	user.Filters.BandwidthLimits = bandwidthLimits
+	directoryLimits, err := getDirectoryLimitsFromPostField(r.Form.Get("directory_limits"))
+	if err != nil {
+		return user, err
+	}
+	user.Filters.DirectoryLimits = directoryLimits
+	dataRetention, err := getDataRetentionFromPostField(r.Form.Get("data_retention"))
+	if err != nil {
+		return user, err
+	}
+	user.Filters.DataRetention = dataRetention
+	maxSessionsPerProtocol, err := getMaxSessionsPerProtocolFromPostFields(r)
+	if err != nil {
+		return user, err
+	}
+	user.Filters.MaxSessionsPerProtocol = maxSessionsPerProtocol
+	if val := strings.TrimSpace(r.Form.Get("max_concurrent_transfers")); val != "" {
+		user.Filters.MaxConcurrentTransfers, err = strconv.Atoi(val)
+		if err != nil {
+			return user, err
+		}
+	}
 	maxFileSize, err := strconv.ParseInt(r.Form.Get("max_upload_file_size"), 10, 64)
 	user.Filters.MaxUploadFileSize = maxFileSize
 	return user, err
 }
 
-func renderLoginPage(w http.ResponseWriter, error string) {
+func getMaxSessionsPerProtocolFromPostFields(r *http.Request) (map[string]int, error) {
+	result := make(map[string]int)
+	for _, protocol := range dataprovider.ValidProtocols {
+		val := strings.TrimSpace(r.Form.Get(fmt.Sprintf("max_sessions_%v", strings.ToLower(protocol))))
+		if val == "" {
+			continue
+		}
+		maxSessions, err := strconv.Atoi(val)
+		if err != nil {
+			return result, fmt.Errorf("invalid max sessions for protocol %v: %v", protocol, err)
+		}
+		if maxSessions > 0 {
+			result[protocol] = maxSessions
+		}
+	}
+	return result, nil
+}
+
+func getBandwidthLimitsFromPostField(value string) ([]dataprovider.BandwidthLimit, error) {
+	var result []dataprovider.BandwidthLimit
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.Split(line, "::")
+		if len(parts) != 3 {
+			return result, fmt.Errorf("invalid bandwidth limit %#v", line)
+		}
+		limit := dataprovider.BandwidthLimit{}
+		for _, source := range strings.Split(parts[0], ",") {
+			if cleaned := strings.TrimSpace(source); cleaned != "" {
+				limit.Sources = append(limit.Sources, cleaned)
+			}
+		}
+		var err error
+		limit.UploadBandwidth, err = strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil {
+			return result, fmt.Errorf("invalid upload bandwidth for limit %#v: %v", line, err)
+		}
+		limit.DownloadBandwidth, err = strconv.ParseInt(strings.TrimSpace(parts[2]), 10, 64)
+		if err != nil {
+			return result, fmt.Errorf("invalid download bandwidth for limit %#v: %v", line, err)
+		}
+		result = append(result, limit)
+	}
+	return result, nil
+}
+
+func getDirectoryLimitsFromPostField(value string) ([]dataprovider.DirectoryLimit, error) {
+	var result []dataprovider.DirectoryLimit
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.Split(line, "::")
+		if len(parts) != 2 {
+			return result, fmt.Errorf("invalid directory limit %#v", line)
+		}
+		maxFiles, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return result, fmt.Errorf("invalid max files for directory limit %#v: %v", line, err)
+		}
+		result = append(result, dataprovider.DirectoryLimit{
+			Path:     strings.TrimSpace(parts[0]),
+			MaxFiles: maxFiles,
+		})
+	}
+	return result, nil
+}
+
+func getDataRetentionFromPostField(value string) ([]dataprovider.FolderRetention, error) {
+	var result []dataprovider.FolderRetention
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.Split(line, "::")
+		if len(parts) < 2 || len(parts) > 3 {
+			return result, fmt.Errorf("invalid data retention %#v", line)
+		}
+		retention, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return result, fmt.Errorf("invalid retention for data retention %#v: %v", line, err)
+		}
+		folder := dataprovider.FolderRetention{
+			Path:      strings.TrimSpace(parts[0]),
+			Retention: retention,
+		}
+		if len(parts) == 3 {
+			for _, option := range strings.Split(parts[2], ",") {
+				switch strings.TrimSpace(option) {
+				case "delete_empty_dirs":
+					folder.DeleteEmptyDirs = true
+				case "ignore_user_permissions":
+					folder.IgnoreUserPermissions = true
+				case "":
+				default:
+					return result, fmt.Errorf("invalid option %#v for data retention %#v", option, line)
+				}
+			}
+		}
+		result = append(result, folder)
+	}
+	return result, nil
+}
+
+func getTransferQuotaFromPostFields(r *http.Request) (dataprovider.TransferQuota, error) {
+	var err error
+	quota := dataprovider.TransferQuota{
+		Period: r.Form.Get("transfer_quota_period"),
+	}
+	if val := strings.TrimSpace(r.Form.Get("transfer_quota_upload_size")); val != "" {
+		quota.UploadSize, err = strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return quota, err
+		}
+	}
+	if val := strings.TrimSpace(r.Form.Get("transfer_quota_download_size")); val != "" {
+		quota.DownloadSize, err = strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return quota, err
+		}
+	}
+	return quota, nil
+}
+
+func getLoginPageData(error string) loginPage {
 	data := loginPage{
 		CurrentURL: webLoginPath,
 		Version:    version.Get().Version,
 		Error:      error,
 		CSRFToken:  createCSRFToken(),
+		Branding:   webBranding,
+	}
+	if oidcMgr != nil {
+		data.OIDCLoginURL = webOIDCLoginPath
 	}
-	renderTemplate(w, templateLogin, data)
+	if webAuthnMgr != nil {
+		data.WebAuthnLoginURL = webWebAuthnLoginPath
+	}
+	return data
+}
+
+func renderLoginPage(w http.ResponseWriter, error string) {
+	renderTemplate(w, templateLogin, getLoginPageData(error))
 }
 
 func handleWebAdminChangePwd(w http.ResponseWriter, r *http.Request) {
@@ -1017,6 +1275,154 @@ func handleWebAdminChangePwdPost(w http.ResponseWriter, r *http.Request) {
 	handleWebLogout(w, r)
 }
 
+func getMFAPageData(r *http.Request, admin *dataprovider.Admin, error string) mfaPage {
+	data := mfaPage{
+		basePage:             getBasePageData(pageMFATitle, webMFAPath, r),
+		TOTPEnabled:          admin.Filters.TOTPConfig.Enabled,
+		Error:                error,
+		WebAuthnEnabled:      webAuthnMgr != nil,
+		WebAuthnCredentials:  admin.Filters.WebAuthnCredentials,
+		WebAuthnPasswordless: admin.Filters.WebAuthnPasswordless,
+	}
+	for _, code := range admin.Filters.RecoveryCodes {
+		if !code.Used {
+			data.UnusedRecoveryCodes++
+		}
+	}
+	return data
+}
+
+func renderMFAPage(w http.ResponseWriter, r *http.Request, admin *dataprovider.Admin, error string) {
+	renderTemplate(w, templateMFA, getMFAPageData(r, admin, error))
+}
+
+func renderMFASetupPage(w http.ResponseWriter, r *http.Request, admin *dataprovider.Admin, error string) {
+	secret, _, qrCode, err := dataprovider.GenerateTOTPSecret(admin.Username)
+	if err != nil {
+		renderInternalServerErrorPage(w, r, err)
+		return
+	}
+	data := mfaPage{
+		basePage: getBasePageData(pageMFATitle, webMFAPath, r),
+		Secret:   secret,
+		QRCode:   template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(qrCode)), //nolint:gosec
+		Error:    error,
+	}
+	renderTemplate(w, templateMFA, data)
+}
+
+func getLoggedAdmin(w http.ResponseWriter, r *http.Request) (dataprovider.Admin, bool) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		renderBadRequestPage(w, r, errors.New("invalid token claims"))
+		return dataprovider.Admin{}, false
+	}
+	admin, err := dataprovider.AdminExists(claims.Username)
+	if err != nil {
+		renderInternalServerErrorPage(w, r, err)
+		return admin, false
+	}
+	return admin, true
+}
+
+func handleWebMFA(w http.ResponseWriter, r *http.Request) {
+	admin, ok := getLoggedAdmin(w, r)
+	if !ok {
+		return
+	}
+	renderMFAPage(w, r, &admin, "")
+}
+
+func handleWebMFAPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	admin, ok := getLoggedAdmin(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderMFAPage(w, r, &admin, err.Error())
+		return
+	}
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken)); err != nil {
+		renderForbiddenPage(w, r, err.Error())
+		return
+	}
+	switch r.Form.Get("action") {
+	case "generate":
+		renderMFASetupPage(w, r, &admin, "")
+	case "enable":
+		secret := r.Form.Get("secret")
+		if !dataprovider.ValidateTOTPPasscode(secret, r.Form.Get("passcode")) {
+			renderMFASetupPage(w, r, &admin, "Invalid authentication code, please scan the new QR code and try again")
+			return
+		}
+		admin.Filters.TOTPConfig = dataprovider.TOTPConfig{
+			Enabled: true,
+			Secret:  kms.NewPlainSecret(secret),
+		}
+		saveMFARecoveryCodes(w, r, &admin)
+	case "recoverycodes":
+		if !admin.Filters.TOTPConfig.Enabled {
+			renderMFAPage(w, r, &admin, "Two-factor authentication is not enabled")
+			return
+		}
+		saveMFARecoveryCodes(w, r, &admin)
+	case "disable":
+		if err := dataprovider.CheckAdminPasscode(&admin, strings.TrimSpace(r.Form.Get("passcode"))); err != nil {
+			renderMFAPage(w, r, &admin, err.Error())
+			return
+		}
+		admin.Filters.TOTPConfig = dataprovider.TOTPConfig{}
+		admin.Filters.RecoveryCodes = nil
+		if err := dataprovider.UpdateAdmin(&admin); err != nil {
+			renderMFAPage(w, r, &admin, err.Error())
+			return
+		}
+		renderMFAPage(w, r, &admin, "")
+	case "webauthn_register", "webauthn_register_finish", "webauthn_delete", "webauthn_passwordless":
+		handleWebAuthnMFAAction(w, r, &admin)
+	default:
+		renderBadRequestPage(w, r, errors.New("invalid action"))
+	}
+}
+
+func handleWebAuthnMFAAction(w http.ResponseWriter, r *http.Request, admin *dataprovider.Admin) {
+	if webAuthnMgr == nil {
+		renderMFAPage(w, r, admin, "WebAuthn is not configured")
+		return
+	}
+	switch r.Form.Get("action") {
+	case "webauthn_register":
+		handleWebAuthnRegistrationBegin(w, r, admin)
+	case "webauthn_register_finish":
+		handleWebAuthnRegistrationFinish(w, r, admin)
+	case "webauthn_delete":
+		handleWebAuthnCredentialDelete(w, r, admin)
+	default:
+		handleWebAuthnPasswordless(w, r, admin)
+	}
+}
+
+func saveMFARecoveryCodes(w http.ResponseWriter, r *http.Request, admin *dataprovider.Admin) {
+	codes, recoveryCodes, err := dataprovider.GenerateRecoveryCodes()
+	if err != nil {
+		renderMFAPage(w, r, admin, err.Error())
+		return
+	}
+	admin.Filters.RecoveryCodes = recoveryCodes
+	if err := dataprovider.UpdateAdmin(admin); err != nil {
+		renderMFAPage(w, r, admin, err.Error())
+		return
+	}
+	data := mfaPage{
+		basePage:            getBasePageData(pageMFATitle, webMFAPath, r),
+		TOTPEnabled:         true,
+		UnusedRecoveryCodes: len(codes),
+		RecoveryCodes:       codes,
+	}
+	renderTemplate(w, templateMFA, data)
+}
+
 func handleWebLogout(w http.ResponseWriter, r *http.Request) {
 	c := jwtTokenClaims{}
 	c.removeCookie(w, r)
@@ -1068,7 +1474,12 @@ func handleWebRestore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := restoreBackup(backupContent, "", scanQuota, restoreMode); err != nil {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		renderMaintenancePage(w, r, "Invalid token claims")
+		return
+	}
+	if err := restoreBackup(backupContent, "", scanQuota, restoreMode, claims.Username); err != nil {
 		renderMaintenancePage(w, r, err.Error())
 		return
 	}
@@ -1167,6 +1578,12 @@ func handleWebUpdateAdminPost(w http.ResponseWriter, r *http.Request) {
 	if updatedAdmin.Password == "" {
 		updatedAdmin.Password = admin.Password
 	}
+	if len(r.Form.Get("disable_totp")) == 0 {
+		updatedAdmin.Filters.TOTPConfig = admin.Filters.TOTPConfig
+		updatedAdmin.Filters.RecoveryCodes = admin.Filters.RecoveryCodes
+		updatedAdmin.Filters.WebAuthnCredentials = admin.Filters.WebAuthnCredentials
+		updatedAdmin.Filters.WebAuthnPasswordless = admin.Filters.WebAuthnPasswordless
+	}
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
 		renderAddUpdateAdminPage(w, r, &updatedAdmin, fmt.Sprintf("Invalid token claims: %v", err), false)
@@ -1200,8 +1617,9 @@ func handleGetWebUsers(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	users := make([]dataprovider.User, 0, limit)
+	filters := dataprovider.NewUserSearchFilters()
 	for {
-		u, err := dataprovider.GetUsers(limit, len(users), dataprovider.OrderASC)
+		u, err := getUsersInAdminScope(r, limit, len(users), dataprovider.OrderASC, &filters)
 		if err != nil {
 			renderInternalServerErrorPage(w, r, err)
 			return
@@ -1335,6 +1753,10 @@ func handleWebAddUserGet(w http.ResponseWriter, r *http.Request) {
 		username := r.URL.Query().Get("clone-from")
 		user, err := dataprovider.UserExists(username)
 		if err == nil {
+			if !isUserInAdminScope(r, &user) {
+				renderForbiddenPage(w, r, "You are not allowed to manage this user")
+				return
+			}
 			user.ID = 0
 			user.Username = ""
 			user.Password = ""
@@ -1374,6 +1796,10 @@ func handleWebAddUserPost(w http.ResponseWriter, r *http.Request) {
 		renderForbiddenPage(w, r, err.Error())
 		return
 	}
+	if !isUserInAdminScope(r, &user) {
+		renderUserPage(w, r, &user, userPageModeAdd, "The user must belong to at least one of your groups")
+		return
+	}
 	err = dataprovider.AddUser(&user)
 	if err == nil {
 		http.Redirect(w, r, webUsersPath, http.StatusSeeOther)
@@ -1411,6 +1837,16 @@ func handleWebUpdateUserPost(w http.ResponseWriter, r *http.Request) {
 	updateEncryptedSecrets(&updatedUser, user.FsConfig.S3Config.AccessSecret, user.FsConfig.AzBlobConfig.AccountKey,
 		user.FsConfig.GCSConfig.Credentials, user.FsConfig.CryptConfig.Passphrase, user.FsConfig.SFTPConfig.Password,
 		user.FsConfig.SFTPConfig.PrivateKey)
+	if updatedUser.Filters.TOTPConfig.Secret.IsRedacted() {
+		updatedUser.Filters.TOTPConfig.Secret = user.Filters.TOTPConfig.Secret
+	}
+	updatedUser.Filters.RecoveryCodes = user.Filters.RecoveryCodes
+	updatedUser.Filters.TempCredentials = user.Filters.TempCredentials
+	updatedUser.Filters.LDAPDomain = user.Filters.LDAPDomain
+	if !isUserInAdminScope(r, &updatedUser) {
+		renderUserPage(w, r, &user, userPageModeUpdate, "The user must belong to at least one of your groups")
+		return
+	}
 
 	err = dataprovider.UpdateUser(&updatedUser)
 	if err == nil {
@@ -1432,7 +1868,11 @@ func handleWebGetStatus(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleWebGetConnections(w http.ResponseWriter, r *http.Request) {
-	connectionStats := common.Connections.GetStats()
+	connectionStats, err := getConnectionsInAdminScope(r)
+	if err != nil {
+		renderBadRequestPage(w, r, err)
+		return
+	}
 	data := connectionsPage{
 		basePage:    getBasePageData(pageConnectionsTitle, webConnectionsPath, r),
 		Connections: connectionStats,
@@ -0,0 +1,409 @@
+package httpd
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/duo-labs/webauthn/protocol"
+	"github.com/duo-labs/webauthn/webauthn"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	webAuthnDefaultRPDisplayName = "SFTPGo"
+	webAuthnSessionTimeout       = 5 * time.Minute
+)
+
+var (
+	webAuthnMgr      *webauthn.WebAuthn
+	webAuthnSessions sync.Map
+)
+
+// WebAuthn defines the configuration to register FIDO2/WebAuthn credentials, such as
+// security keys, for admins and to use them to login to the web admin
+type WebAuthn struct {
+	// RPID is the relying party identifier, it must be the domain name, without scheme
+	// and port, used to access the web admin, for example "sftpgo.example.com".
+	// Leave empty to disable WebAuthn
+	RPID string `json:"rp_id" mapstructure:"rp_id"`
+	// RPOrigin is the origin used to access the web admin, for example
+	// "https://sftpgo.example.com:8080". If empty "https://<rp_id>" is assumed
+	RPOrigin string `json:"rp_origin" mapstructure:"rp_origin"`
+	// RPDisplayName is the relying party name shown by the browsers.
+	// Default "SFTPGo"
+	RPDisplayName string `json:"rp_display_name" mapstructure:"rp_display_name"`
+}
+
+func (c *WebAuthn) isEnabled() bool {
+	return c.RPID != ""
+}
+
+func (c *WebAuthn) initialize() error {
+	if !c.isEnabled() {
+		webAuthnMgr = nil
+		return nil
+	}
+	if c.RPDisplayName == "" {
+		c.RPDisplayName = webAuthnDefaultRPDisplayName
+	}
+	if c.RPOrigin == "" {
+		c.RPOrigin = "https://" + c.RPID
+	}
+	if !strings.HasPrefix(c.RPOrigin, "http") {
+		return fmt.Errorf("webauthn: invalid relying party origin %#v", c.RPOrigin)
+	}
+	mgr, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: c.RPDisplayName,
+		RPID:          c.RPID,
+		RPOrigin:      c.RPOrigin,
+	})
+	if err != nil {
+		return fmt.Errorf("webauthn: %w", err)
+	}
+	webAuthnMgr = mgr
+	logger.Debug(logSender, "", "WebAuthn initialized, relying party ID %#v, origin %#v", c.RPID, c.RPOrigin)
+	return nil
+}
+
+// webAuthnAdmin adapts an admin to the webauthn.User interface
+type webAuthnAdmin struct {
+	admin *dataprovider.Admin
+}
+
+func (u *webAuthnAdmin) WebAuthnID() []byte {
+	return []byte(u.admin.Username)
+}
+
+func (u *webAuthnAdmin) WebAuthnName() string {
+	return u.admin.Username
+}
+
+func (u *webAuthnAdmin) WebAuthnDisplayName() string {
+	return u.admin.Username
+}
+
+func (u *webAuthnAdmin) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *webAuthnAdmin) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.admin.Filters.WebAuthnCredentials))
+	for _, c := range u.admin.Filters.WebAuthnCredentials {
+		credentials = append(credentials, webauthn.Credential{
+			ID:              c.ID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		})
+	}
+	return credentials
+}
+
+// webAuthnSession is a pending WebAuthn registration or login
+type webAuthnSession struct {
+	Username string
+	// Name is the name for the credential to register
+	Name         string
+	Registration bool
+	Data         webauthn.SessionData
+	IssuedAt     time.Time
+}
+
+func (s *webAuthnSession) isExpired() bool {
+	return time.Since(s.IssuedAt) > webAuthnSessionTimeout
+}
+
+func storeWebAuthnSession(session webAuthnSession) string {
+	sessionID := hex.EncodeToString(utils.GenerateRandomBytes(32))
+	session.IssuedAt = time.Now()
+	webAuthnSessions.Store(sessionID, session)
+	return sessionID
+}
+
+// getWebAuthnSession returns and removes the pending session with the given ID
+func getWebAuthnSession(sessionID string, registration bool) (webAuthnSession, error) {
+	val, ok := webAuthnSessions.Load(sessionID)
+	if !ok {
+		return webAuthnSession{}, errors.New("unable to find the WebAuthn session")
+	}
+	webAuthnSessions.Delete(sessionID)
+	session := val.(webAuthnSession)
+	if session.isExpired() {
+		return session, errors.New("the WebAuthn session is expired")
+	}
+	if session.Registration != registration {
+		return session, errors.New("invalid WebAuthn session")
+	}
+	return session, nil
+}
+
+func cleanupExpiredWebAuthnSessions() {
+	webAuthnSessions.Range(func(key, value interface{}) bool {
+		session, ok := value.(webAuthnSession)
+		if !ok || session.isExpired() {
+			webAuthnSessions.Delete(key)
+		}
+		return true
+	})
+}
+
+func marshalWebAuthnOptions(options interface{}) (template.JS, error) {
+	data, err := json.Marshal(options)
+	if err != nil {
+		return "", err
+	}
+	return template.JS(data), nil //nolint:gosec
+}
+
+// renderWebAuthnLoginPage starts a WebAuthn login for the given admin and renders the login
+// page, the browser will ask to use a registered credential
+func renderWebAuthnLoginPage(w http.ResponseWriter, admin *dataprovider.Admin, userVerification protocol.UserVerificationRequirement) {
+	assertion, sessionData, err := webAuthnMgr.BeginLogin(&webAuthnAdmin{admin: admin},
+		webauthn.WithUserVerification(userVerification))
+	if err != nil {
+		logger.Warn(logSender, "", "unable to start WebAuthn login for admin %#v: %v", admin.Username, err)
+		renderLoginPage(w, "Unable to start the security key authentication")
+		return
+	}
+	options, err := marshalWebAuthnOptions(assertion)
+	if err != nil {
+		renderLoginPage(w, err.Error())
+		return
+	}
+	sessionID := storeWebAuthnSession(webAuthnSession{
+		Username: admin.Username,
+		Data:     *sessionData,
+	})
+	data := getLoginPageData("")
+	data.CurrentURL = webWebAuthnVerifyPath
+	data.WebAuthnOptions = options
+	data.WebAuthnSessionID = sessionID
+	renderTemplate(w, templateLogin, data)
+}
+
+// handleWebAuthnLoginPost starts a passwordless login
+func handleWebAuthnLoginPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if webAuthnMgr == nil {
+		renderNotFoundPage(w, r, errors.New("WebAuthn is not configured"))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderLoginPage(w, err.Error())
+		return
+	}
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken)); err != nil {
+		renderLoginPage(w, err.Error())
+		return
+	}
+	username := r.Form.Get("username")
+	if username == "" {
+		renderLoginPage(w, "Please enter your username")
+		return
+	}
+	admin, err := dataprovider.AdminExists(username)
+	if err != nil || admin.Status != 1 || !admin.Filters.WebAuthnPasswordless || !admin.IsWebAuthnRequired() {
+		logger.Debug(logSender, "", "passwordless login not allowed for admin %#v, err: %v", username, err)
+		renderLoginPage(w, "Passwordless login is not enabled for this account")
+		return
+	}
+	ipAddr := utils.GetIPFromRemoteAddress(r.RemoteAddr)
+	if !admin.CanLoginFromIP(ipAddr) {
+		renderLoginPage(w, fmt.Sprintf("login from IP %v not allowed", ipAddr))
+		return
+	}
+	renderWebAuthnLoginPage(w, &admin, protocol.VerificationRequired)
+}
+
+// handleWebAuthnVerifyPost completes a WebAuthn login
+func (s *httpdServer) handleWebAuthnVerifyPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if webAuthnMgr == nil {
+		renderNotFoundPage(w, r, errors.New("WebAuthn is not configured"))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderLoginPage(w, err.Error())
+		return
+	}
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken)); err != nil {
+		renderLoginPage(w, err.Error())
+		return
+	}
+	session, err := getWebAuthnSession(r.Form.Get("webauthn_session"), false)
+	if err != nil {
+		renderLoginPage(w, err.Error())
+		return
+	}
+	admin, err := dataprovider.AdminExists(session.Username)
+	if err != nil {
+		renderLoginPage(w, dataprovider.ErrInvalidCredentials.Error())
+		return
+	}
+	if admin.Status != 1 {
+		renderLoginPage(w, fmt.Sprintf("admin %#v is disabled", admin.Username))
+		return
+	}
+	ipAddr := utils.GetIPFromRemoteAddress(r.RemoteAddr)
+	if !admin.CanLoginFromIP(ipAddr) {
+		renderLoginPage(w, fmt.Sprintf("login from IP %v not allowed", ipAddr))
+		return
+	}
+	parsedResponse, err := protocol.ParseCredentialRequestResponseBody(strings.NewReader(r.Form.Get("webauthn_response")))
+	if err != nil {
+		logger.Debug(logSender, "", "unable to parse WebAuthn response for admin %#v: %v", admin.Username, err)
+		renderLoginPage(w, "Security key authentication failed")
+		return
+	}
+	credential, err := webAuthnMgr.ValidateLogin(&webAuthnAdmin{admin: &admin}, session.Data, parsedResponse)
+	if err != nil {
+		logger.Debug(logSender, "", "WebAuthn login failed for admin %#v: %v", admin.Username, err)
+		renderLoginPage(w, "Security key authentication failed")
+		return
+	}
+	if credential.Authenticator.CloneWarning {
+		logger.Warn(logSender, "", "WebAuthn login denied for admin %#v, the authenticator could be cloned",
+			admin.Username)
+		renderLoginPage(w, "Security key authentication failed, the authenticator could be cloned")
+		return
+	}
+	idx := admin.GetWebAuthnCredentialIndex(credential.ID)
+	if idx < 0 {
+		renderLoginPage(w, "Security key authentication failed")
+		return
+	}
+	admin.Filters.WebAuthnCredentials[idx].SignCount = credential.Authenticator.SignCount
+	admin.Filters.WebAuthnCredentials[idx].LastUseAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	if err := dataprovider.UpdateAdmin(&admin); err != nil {
+		logger.Warn(logSender, "", "unable to update WebAuthn credential for admin %#v: %v", admin.Username, err)
+		renderLoginPage(w, err.Error())
+		return
+	}
+	s.loginWebAdmin(w, r, &admin)
+}
+
+// handleWebAuthnRegistrationBegin starts the registration of a new credential for the given admin
+func handleWebAuthnRegistrationBegin(w http.ResponseWriter, r *http.Request, admin *dataprovider.Admin) {
+	name := strings.TrimSpace(r.Form.Get("webauthn_name"))
+	if name == "" {
+		renderMFAPage(w, r, admin, "Please enter a name for the security key")
+		return
+	}
+	user := &webAuthnAdmin{admin: admin}
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(admin.Filters.WebAuthnCredentials))
+	for _, c := range admin.Filters.WebAuthnCredentials {
+		if c.Name == name {
+			renderMFAPage(w, r, admin, fmt.Sprintf("A security key named %#v already exists", name))
+			return
+		}
+		exclusions = append(exclusions, protocol.CredentialDescriptor{
+			Type:         protocol.PublicKeyCredentialType,
+			CredentialID: c.ID,
+		})
+	}
+	creation, sessionData, err := webAuthnMgr.BeginRegistration(user, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		renderMFAPage(w, r, admin, err.Error())
+		return
+	}
+	options, err := marshalWebAuthnOptions(creation)
+	if err != nil {
+		renderMFAPage(w, r, admin, err.Error())
+		return
+	}
+	sessionID := storeWebAuthnSession(webAuthnSession{
+		Username:     admin.Username,
+		Name:         name,
+		Registration: true,
+		Data:         *sessionData,
+	})
+	data := getMFAPageData(r, admin, "")
+	data.WebAuthnOptions = options
+	data.WebAuthnSessionID = sessionID
+	renderTemplate(w, templateMFA, data)
+}
+
+// handleWebAuthnRegistrationFinish validates the authenticator response and stores the new credential
+func handleWebAuthnRegistrationFinish(w http.ResponseWriter, r *http.Request, admin *dataprovider.Admin) {
+	session, err := getWebAuthnSession(r.Form.Get("webauthn_session"), true)
+	if err != nil {
+		renderMFAPage(w, r, admin, err.Error())
+		return
+	}
+	if session.Username != admin.Username {
+		renderMFAPage(w, r, admin, "invalid WebAuthn session")
+		return
+	}
+	parsedResponse, err := protocol.ParseCredentialCreationResponseBody(strings.NewReader(r.Form.Get("webauthn_response")))
+	if err != nil {
+		renderMFAPage(w, r, admin, fmt.Sprintf("Unable to parse the security key response: %v", err))
+		return
+	}
+	credential, err := webAuthnMgr.CreateCredential(&webAuthnAdmin{admin: admin}, session.Data, parsedResponse)
+	if err != nil {
+		renderMFAPage(w, r, admin, fmt.Sprintf("Unable to register the security key: %v", err))
+		return
+	}
+	admin.Filters.WebAuthnCredentials = append(admin.Filters.WebAuthnCredentials, dataprovider.WebAuthnCredential{
+		Name:            session.Name,
+		ID:              credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		CreatedAt:       utils.GetTimeAsMsSinceEpoch(time.Now()),
+	})
+	if err := dataprovider.UpdateAdmin(admin); err != nil {
+		renderMFAPage(w, r, admin, err.Error())
+		return
+	}
+	renderMFAPage(w, r, admin, "")
+}
+
+// handleWebAuthnCredentialDelete removes the credential with the ID, base64 URL encoded, specified in the form
+func handleWebAuthnCredentialDelete(w http.ResponseWriter, r *http.Request, admin *dataprovider.Admin) {
+	id, err := base64.RawURLEncoding.DecodeString(r.Form.Get("webauthn_id"))
+	if err != nil {
+		renderMFAPage(w, r, admin, "Invalid security key")
+		return
+	}
+	idx := admin.GetWebAuthnCredentialIndex(id)
+	if idx < 0 {
+		renderMFAPage(w, r, admin, "Security key not found")
+		return
+	}
+	admin.Filters.WebAuthnCredentials = append(admin.Filters.WebAuthnCredentials[:idx],
+		admin.Filters.WebAuthnCredentials[idx+1:]...)
+	if err := dataprovider.UpdateAdmin(admin); err != nil {
+		renderMFAPage(w, r, admin, err.Error())
+		return
+	}
+	renderMFAPage(w, r, admin, "")
+}
+
+func handleWebAuthnPasswordless(w http.ResponseWriter, r *http.Request, admin *dataprovider.Admin) {
+	admin.Filters.WebAuthnPasswordless = len(r.Form.Get("webauthn_passwordless")) > 0
+	if admin.Filters.WebAuthnPasswordless && !admin.IsWebAuthnRequired() {
+		renderMFAPage(w, r, admin, "Please register a security key before enabling passwordless login")
+		return
+	}
+	if err := dataprovider.UpdateAdmin(admin); err != nil {
+		renderMFAPage(w, r, admin, err.Error())
+		return
+	}
+	renderMFAPage(w, r, admin, "")
+}
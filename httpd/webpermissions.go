@@ -0,0 +1,349 @@
+package httpd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	permissionsActionPreview = "preview"
+	permissionsActionSave    = "save"
+)
+
+// permissionsRow defines a path, and its permissions, inside the permissions editor
+type permissionsRow struct {
+	Index       int
+	Path        string
+	Permissions []string
+	// virtual folder name, if the path is a virtual folder or it is inside a virtual folder
+	Folder          string
+	IsVirtualFolder bool
+	Error           string
+}
+
+// HasPerm returns true if the given permission is granted for this row
+func (r *permissionsRow) HasPerm(perm string) bool {
+	return utils.IsStringInSlice(perm, r.Permissions)
+}
+
+// permissionsDiff defines the changes for a path
+type permissionsDiff struct {
+	Path    string
+	IsNew   bool
+	Deleted bool
+	Added   []string
+	Removed []string
+}
+
+type permissionsPage struct {
+	basePage
+	Username    string
+	EditUserURL string
+	ValidPerms  []string
+	Rows        []permissionsRow
+	NextIndex   int
+	// virtual folders without explicit permissions, they inherit the permissions of the parent directory
+	Warnings    []string
+	Diff        []permissionsDiff
+	Preview     bool
+	PreviewHash string
+	Error       string
+}
+
+func renderPermissionsPage(w http.ResponseWriter, r *http.Request, data *permissionsPage) {
+	data.basePage = getBasePageData(pagePermissionsTitle,
+		fmt.Sprintf("%v/%v/permissions", webUserPath, url.PathEscape(data.Username)), r)
+	data.EditUserURL = fmt.Sprintf("%v/%v", webUserPath, url.PathEscape(data.Username))
+	data.ValidPerms = dataprovider.ValidPerms
+	data.NextIndex = len(data.Rows)
+	renderTemplate(w, templatePermissions, data)
+}
+
+func handleWebUserPermissionsGet(w http.ResponseWriter, r *http.Request) {
+	user, err := dataprovider.UserExists(getURLParam(r, "username"))
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		renderNotFoundPage(w, r, err)
+		return
+	} else if err != nil {
+		renderInternalServerErrorPage(w, r, err)
+		return
+	}
+	data := permissionsPage{
+		Username: user.Username,
+		Rows:     getPermissionsRows(&user, user.Permissions),
+	}
+	data.Warnings = getPermissionsWarnings(&user, data.Rows)
+	renderPermissionsPage(w, r, &data)
+}
+
+func handleWebUserPermissionsPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	user, err := dataprovider.UserExists(getURLParam(r, "username"))
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		renderNotFoundPage(w, r, err)
+		return
+	} else if err != nil {
+		renderInternalServerErrorPage(w, r, err)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderBadRequestPage(w, r, err)
+		return
+	}
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken)); err != nil {
+		renderForbiddenPage(w, r, err.Error())
+		return
+	}
+	rows := getPermissionsRowsFromPostFields(r, &user)
+	data := permissionsPage{
+		Username: user.Username,
+		Rows:     rows,
+		Warnings: getPermissionsWarnings(&user, rows),
+	}
+	permissions, err := validatePermissionsRows(data.Rows)
+	if err != nil {
+		data.Error = err.Error()
+		renderPermissionsPage(w, r, &data)
+		return
+	}
+	data.Diff = getPermissionsDiff(user.Permissions, permissions)
+	data.PreviewHash = getPermissionsPreviewHash(user.Permissions, permissions)
+
+	switch r.Form.Get("action") {
+	case permissionsActionPreview:
+		data.Preview = true
+		renderPermissionsPage(w, r, &data)
+	case permissionsActionSave:
+		if r.Form.Get("preview_hash") != data.PreviewHash {
+			data.Preview = true
+			data.Error = "The permissions changed after the preview, please review the changes and save again"
+			renderPermissionsPage(w, r, &data)
+			return
+		}
+		if len(data.Diff) == 0 {
+			http.Redirect(w, r, webUsersPath, http.StatusSeeOther)
+			return
+		}
+		user.Permissions = permissions
+		if err := dataprovider.UpdateUser(&user); err != nil {
+			data.Preview = true
+			data.Error = err.Error()
+			renderPermissionsPage(w, r, &data)
+			return
+		}
+		http.Redirect(w, r, webUsersPath, http.StatusSeeOther)
+	default:
+		renderBadRequestPage(w, r, fmt.Errorf("invalid action %#v", r.Form.Get("action")))
+	}
+}
+
+// getPermissionsRows returns the rows for the given permissions, the root directory is always the first row
+func getPermissionsRows(user *dataprovider.User, permissions map[string][]string) []permissionsRow {
+	paths := make([]string, 0, len(permissions))
+	for p := range permissions {
+		if p != "/" {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	paths = append([]string{"/"}, paths...)
+
+	rows := make([]permissionsRow, 0, len(paths))
+	for idx, p := range paths {
+		row := permissionsRow{
+			Index:       idx,
+			Path:        p,
+			Permissions: permissions[p],
+		}
+		setPermissionsRowFolder(user, &row)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func getPermissionsRowsFromPostFields(r *http.Request, user *dataprovider.User) []permissionsRow {
+	rows := []permissionsRow{
+		{
+			Index:       0,
+			Path:        "/",
+			Permissions: r.Form["perm_0"],
+		},
+	}
+	var indexes []int
+	for key := range r.Form {
+		if !strings.HasPrefix(key, "path_") {
+			continue
+		}
+		idx, err := strconv.Atoi(strings.TrimPrefix(key, "path_"))
+		if err != nil || idx <= 0 {
+			continue
+		}
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+	for _, idx := range indexes {
+		if r.Form.Get(fmt.Sprintf("remove_%v", idx)) != "" {
+			continue
+		}
+		p := strings.TrimSpace(r.Form.Get(fmt.Sprintf("path_%v", idx)))
+		if p == "" {
+			continue
+		}
+		rows = append(rows, permissionsRow{
+			Index:       len(rows),
+			Path:        p,
+			Permissions: r.Form[fmt.Sprintf("perm_%v", idx)],
+		})
+	}
+	for idx := range rows {
+		setPermissionsRowFolder(user, &rows[idx])
+	}
+	return rows
+}
+
+func setPermissionsRowFolder(user *dataprovider.User, row *permissionsRow) {
+	cleanedPath := utils.CleanPath(row.Path)
+	folder, err := user.GetVirtualFolderForPath(cleanedPath)
+	if err == nil {
+		row.Folder = folder.Name
+		row.IsVirtualFolder = folder.VirtualPath == cleanedPath
+	}
+}
+
+// validatePermissionsRows validates the given rows and returns the resulting permissions.
+// The errors are also set inside the invalid rows
+func validatePermissionsRows(rows []permissionsRow) (map[string][]string, error) {
+	permissions := make(map[string][]string)
+	hasErrors := false
+	for idx := range rows {
+		row := &rows[idx]
+		for _, p := range row.Permissions {
+			if !utils.IsStringInSlice(p, dataprovider.ValidPerms) {
+				row.Error = fmt.Sprintf("invalid permission %#v", p)
+				break
+			}
+		}
+		if row.Error == "" {
+			row.Error = validatePermissionsPath(row.Path, idx == 0)
+		}
+		cleanedPath := utils.CleanPath(row.Path)
+		if row.Error == "" {
+			if _, ok := permissions[cleanedPath]; ok {
+				row.Error = fmt.Sprintf("duplicated path %#v", cleanedPath)
+			}
+		}
+		if row.Error == "" && idx == 0 && len(row.Permissions) == 0 {
+			row.Error = "at least a permission is required for the root directory"
+		}
+		if row.Error != "" {
+			hasErrors = true
+			continue
+		}
+		if utils.IsStringInSlice(dataprovider.PermAny, row.Permissions) {
+			permissions[cleanedPath] = []string{dataprovider.PermAny}
+		} else {
+			permissions[cleanedPath] = utils.RemoveDuplicates(append([]string{}, row.Permissions...))
+		}
+	}
+	if hasErrors {
+		return nil, fmt.Errorf("please fix the errors for the highlighted paths")
+	}
+	return permissions, nil
+}
+
+func validatePermissionsPath(p string, isRoot bool) string {
+	if isRoot {
+		if p != "/" {
+			return "the first path must be the root directory"
+		}
+		return ""
+	}
+	if !path.IsAbs(p) {
+		return fmt.Sprintf("%#v is not an absolute path", p)
+	}
+	if utils.CleanPath(p) == "/" {
+		return fmt.Sprintf("%#v is an alias for the root directory", p)
+	}
+	return ""
+}
+
+// getPermissionsWarnings returns a warning for each virtual folder without explicit permissions
+func getPermissionsWarnings(user *dataprovider.User, rows []permissionsRow) []string {
+	var warnings []string
+	for _, folder := range user.VirtualFolders {
+		found := false
+		for _, row := range rows {
+			if utils.CleanPath(row.Path) == folder.VirtualPath {
+				found = true
+				break
+			}
+		}
+		if !found {
+			warnings = append(warnings, fmt.Sprintf("The virtual folder %#v, mapped to %#v, has no explicit permissions and inherits the permissions of the parent directory",
+				folder.Name, folder.VirtualPath))
+		}
+	}
+	return warnings
+}
+
+// getPermissionsDiff returns the changes between the current and the new permissions ordered by path
+func getPermissionsDiff(current, updated map[string][]string) []permissionsDiff {
+	var paths []string
+	for p := range current {
+		paths = append(paths, p)
+	}
+	for p := range updated {
+		if _, ok := current[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	var diff []permissionsDiff
+	for _, p := range paths {
+		currentPerms, inCurrent := current[p]
+		updatedPerms, inUpdated := updated[p]
+		d := permissionsDiff{
+			Path:    p,
+			IsNew:   !inCurrent,
+			Deleted: !inUpdated,
+		}
+		for _, perm := range updatedPerms {
+			if !utils.IsStringInSlice(perm, currentPerms) {
+				d.Added = append(d.Added, perm)
+			}
+		}
+		for _, perm := range currentPerms {
+			if !utils.IsStringInSlice(perm, updatedPerms) {
+				d.Removed = append(d.Removed, perm)
+			}
+		}
+		if d.IsNew || d.Deleted || len(d.Added) > 0 || len(d.Removed) > 0 {
+			diff = append(diff, d)
+		}
+	}
+	return diff
+}
+
+// getPermissionsPreviewHash returns a hash for the current and the new permissions.
+// It allows to save only the previewed changes and to detect concurrent updates
+func getPermissionsPreviewHash(current, updated map[string][]string) string {
+	// maps are marshaled with sorted keys
+	currentJSON, _ := json.Marshal(current)
+	updatedJSON, _ := json.Marshal(updated)
+	data := append(currentJSON, '\n')
+	data = append(data, updatedJSON...)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
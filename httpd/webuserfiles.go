@@ -0,0 +1,192 @@
+package httpd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// userFileRow defines a file or directory inside the user's files browser
+type userFileRow struct {
+	Name    string
+	Path    string
+	IsDir   bool
+	Size    int64
+	ModTime string
+}
+
+// userFilesPathItem defines a breadcrumb element for the current directory
+type userFilesPathItem struct {
+	Name string
+	Path string
+}
+
+type userFilesPage struct {
+	basePage
+	Username    string
+	EditUserURL string
+	FilesURL    string
+	DownloadURL string
+	CurrentDir  string
+	Paths       []userFilesPathItem
+	Files       []userFileRow
+	Error       string
+}
+
+func getUserFilesPathItems(dirPath string) []userFilesPathItem {
+	items := []userFilesPathItem{{Name: "/", Path: "/"}}
+	if dirPath == "/" {
+		return items
+	}
+	currentPath := "/"
+	for _, name := range strings.Split(strings.Trim(dirPath, "/"), "/") {
+		currentPath = path.Join(currentPath, name)
+		items = append(items, userFilesPathItem{Name: name, Path: currentPath})
+	}
+	return items
+}
+
+// getBrowseFilesStatus returns the HTTP status code for an error returned by
+// the connection used to browse the user's files
+func getBrowseFilesStatus(err error) int {
+	switch err {
+	case common.ErrPermissionDenied:
+		return http.StatusForbidden
+	case common.ErrNotExist:
+		return http.StatusNotFound
+	case common.ErrOpUnsupported, common.ErrReadQuotaExceeded, common.ErrTooManyTransfers:
+		return http.StatusBadRequest
+	default:
+		return getRespStatus(err)
+	}
+}
+
+func renderUserFilesPage(w http.ResponseWriter, r *http.Request, data *userFilesPage) {
+	userURL := fmt.Sprintf("%v/%v", webUserPath, url.PathEscape(data.Username))
+	data.basePage = getBasePageData(pageUserFilesTitle, userURL+"/files", r)
+	data.EditUserURL = userURL
+	data.FilesURL = userURL + "/files"
+	data.DownloadURL = userURL + "/files/download"
+	data.Paths = getUserFilesPathItems(data.CurrentDir)
+	renderTemplate(w, templateUserFiles, data)
+}
+
+// getUserBrowseConnection returns a connection for the user in the URL, the connection
+// is added to the active ones and must be removed by the caller
+func getUserBrowseConnection(r *http.Request) (*Connection, error) {
+	user, err := dataprovider.UserExists(getURLParam(r, "username"))
+	if err != nil {
+		return nil, err
+	}
+	connection, err := newAdminConnection(r, user, getAdminFromToken(r).Username)
+	if err != nil {
+		return nil, err
+	}
+	common.Connections.Add(connection)
+	return connection, nil
+}
+
+func handleWebUserFilesGet(w http.ResponseWriter, r *http.Request) {
+	connection, err := getUserBrowseConnection(r)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		renderNotFoundPage(w, r, err)
+		return
+	} else if err != nil {
+		renderInternalServerErrorPage(w, r, err)
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	data := userFilesPage{
+		Username:   connection.User.Username,
+		CurrentDir: utils.CleanPath(r.URL.Query().Get("path")),
+	}
+	connection.Log(logger.LevelInfo, "admin %#v is listing the directory %#v", connection.admin, data.CurrentDir)
+	contents, err := connection.ReadDir(data.CurrentDir)
+	if err != nil {
+		data.Error = fmt.Sprintf("Unable to read the directory %#v: %v", data.CurrentDir, err)
+		renderUserFilesPage(w, r, &data)
+		return
+	}
+	for _, info := range contents {
+		data.Files = append(data.Files, userFileRow{
+			Name:    info.Name(),
+			Path:    path.Join(data.CurrentDir, info.Name()),
+			IsDir:   info.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC().Format(webDateTimeFormat),
+		})
+	}
+	sort.Slice(data.Files, func(i, j int) bool {
+		if data.Files[i].IsDir != data.Files[j].IsDir {
+			return data.Files[i].IsDir
+		}
+		return data.Files[i].Name < data.Files[j].Name
+	})
+	renderUserFilesPage(w, r, &data)
+}
+
+func handleWebUserFileDownload(w http.ResponseWriter, r *http.Request) {
+	connection, err := getUserBrowseConnection(r)
+	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+		renderNotFoundPage(w, r, err)
+		return
+	} else if err != nil {
+		renderInternalServerErrorPage(w, r, err)
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := utils.CleanPath(r.URL.Query().Get("path"))
+	connection.Log(logger.LevelInfo, "admin %#v is downloading the file %#v", connection.admin, name)
+	file, info, err := connection.getFileReader(name)
+	if err != nil {
+		switch getBrowseFilesStatus(err) {
+		case http.StatusForbidden:
+			renderForbiddenPage(w, r, err.Error())
+		case http.StatusNotFound:
+			renderNotFoundPage(w, r, err)
+		case http.StatusBadRequest:
+			renderBadRequestPage(w, r, err)
+		default:
+			renderInternalServerErrorPage(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprintf("%v", info.Size()))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%#v", path.Base(name)))
+	// the response writer wrapper, added by the logger middleware, can advertise io.ReaderFrom even
+	// if the wrapped writer does not implement it, so we copy using the Write method only
+	if _, err := io.Copy(struct{ io.Writer }{w}, file); err != nil {
+		connection.Log(logger.LevelWarn, "error downloading file %#v: %v", name, err)
+	}
+}
+
+func handleWebUserFileDelete(w http.ResponseWriter, r *http.Request) {
+	connection, err := getUserBrowseConnection(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	name := utils.CleanPath(r.URL.Query().Get("path"))
+	connection.Log(logger.LevelInfo, "admin %#v is removing the file %#v", connection.admin, name)
+	if err := connection.removeFile(name); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to remove the file %#v", name), getBrowseFilesStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "File deleted", http.StatusOK)
+}
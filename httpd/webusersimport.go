@@ -0,0 +1,70 @@
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+type usersImportPage struct {
+	basePage
+	ExportURL string
+	Report    *usersCSVImportReport
+	Error     string
+}
+
+func renderUsersImportPage(w http.ResponseWriter, r *http.Request, report *usersCSVImportReport, error string) {
+	data := usersImportPage{
+		basePage:  getBasePageData(pageUsersImportTitle, webUsersCSVImportPath, r),
+		ExportURL: webUsersCSVPath,
+		Report:    report,
+		Error:     error,
+	}
+	renderTemplate(w, templateUsersImport, data)
+}
+
+func handleWebUsersCSVImportGet(w http.ResponseWriter, r *http.Request) {
+	renderUsersImportPage(w, r, nil, "")
+}
+
+func handleWebUsersCSVImportPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	err := r.ParseMultipartForm(maxRequestSize)
+	if err != nil {
+		renderUsersImportPage(w, r, nil, err.Error())
+		return
+	}
+	defer r.MultipartForm.RemoveAll() //nolint:errcheck
+
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken)); err != nil {
+		renderForbiddenPage(w, r, err.Error())
+		return
+	}
+	usersFile, _, err := r.FormFile("users_file")
+	if err != nil {
+		renderUsersImportPage(w, r, nil, err.Error())
+		return
+	}
+	defer usersFile.Close()
+
+	usersContent, err := ioutil.ReadAll(usersFile)
+	if err != nil || len(usersContent) == 0 {
+		if len(usersContent) == 0 {
+			err = errors.New("CSV file size must be greater than 0")
+		}
+		renderUsersImportPage(w, r, nil, err.Error())
+		return
+	}
+	dryRun := r.Form.Get("dry_run") != ""
+	report, err := importUsersCSVContent(r, usersContent, dryRun)
+	if err != nil {
+		renderUsersImportPage(w, r, nil, err.Error())
+		return
+	}
+	if report.Errors > 0 {
+		renderUsersImportPage(w, r, &report, fmt.Sprintf("%v users with errors, no changes applied", report.Errors))
+		return
+	}
+	renderUsersImportPage(w, r, &report, "")
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"path"
@@ -30,6 +31,7 @@ import (
 const (
 	tokenPath                 = "/api/v2/token"
 	activeConnectionsPath     = "/api/v2/connections"
+	connectionHistoryPath     = "/api/v2/connections/history"
 	quotaScanPath             = "/api/v2/quota-scans"
 	quotaScanVFolderPath      = "/api/v2/folder-quota-scans"
 	userPath                  = "/api/v2/users"
@@ -42,9 +44,15 @@ const (
 	updateFolderUsedQuotaPath = "/api/v2/folder-quota-update"
 	defenderBanTime           = "/api/v2/defender/bantime"
 	defenderUnban             = "/api/v2/defender/unban"
+	ipListsReloadPath         = "/api/v2/iplists/reload"
 	defenderScore             = "/api/v2/defender/score"
 	adminPath                 = "/api/v2/admins"
 	adminPwdPath              = "/api/v2/changepwd/admin"
+	apiKeysPath               = "/api/v2/apikeys"
+	userTemplatePath          = "/api/v2/template/users"
+	usersCSVPath              = "/api/v2/csv/users"
+	usersBulkPath             = "/api/v2/bulk/users"
+	usersCachePath            = "/api/v2/cache/users"
 )
 
 const (
@@ -74,7 +82,7 @@ func sendHTTPRequest(method, url string, body io.Reader, contentType, token stri
 		return nil, err
 	}
 	if contentType != "" {
-		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Type", contentType)
 	}
 	if token != "" {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
@@ -152,6 +160,99 @@ func AddUser(user dataprovider.User, expectedStatusCode int) (dataprovider.User,
 	return newUser, body, err
 }
 
+// AddUsersFromTemplate adds the users defined in the given CSV content using the provided template
+// and checks the received HTTP Status code against expectedStatusCode.
+func AddUsersFromTemplate(template dataprovider.User, usersCSV []byte, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	templateAsJSON, _ := json.Marshal(template)
+	var buf bytes.Buffer
+	mpw := multipart.NewWriter(&buf)
+	if err := mpw.WriteField("template", string(templateAsJSON)); err != nil {
+		return body, err
+	}
+	part, err := mpw.CreateFormFile("users", "users.csv")
+	if err != nil {
+		return body, err
+	}
+	if _, err := part.Write(usersCSV); err != nil {
+		return body, err
+	}
+	if err := mpw.Close(); err != nil {
+		return body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(userTemplatePath), &buf,
+		mpw.FormDataContentType(), getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// ExportUsersCSV returns the users as CSV and checks the received HTTP Status code against expectedStatusCode.
+func ExportUsersCSV(expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(usersCSVPath), nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// ImportUsersCSV adds or updates the users defined in the given CSV content and checks the
+// received HTTP Status code against expectedStatusCode. The import report is returned
+func ImportUsersCSV(usersCSV []byte, dryRun bool, expectedStatusCode int) (map[string]interface{}, []byte, error) {
+	var report map[string]interface{}
+	var body []byte
+	var buf bytes.Buffer
+	mpw := multipart.NewWriter(&buf)
+	part, err := mpw.CreateFormFile("users", "users.csv")
+	if err != nil {
+		return report, body, err
+	}
+	if _, err := part.Write(usersCSV); err != nil {
+		return report, body, err
+	}
+	if err := mpw.Close(); err != nil {
+		return report, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(usersCSVPath)+"?dry_run="+strconv.FormatBool(dryRun),
+		&buf, mpw.FormDataContentType(), getDefaultToken())
+	if err != nil {
+		return report, body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil {
+		err = json.Unmarshal(body, &report)
+	}
+	return report, body, err
+}
+
+// BulkUpdateUsers applies the given bulk action to multiple users and checks the received
+// HTTP Status code against expectedStatusCode. The per-user results are returned
+func BulkUpdateUsers(request map[string]interface{}, expectedStatusCode int) (map[string]interface{}, []byte, error) {
+	var result map[string]interface{}
+	var body []byte
+	asJSON, _ := json.Marshal(request)
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(usersBulkPath), bytes.NewBuffer(asJSON),
+		"application/json", getDefaultToken())
+	if err != nil {
+		return result, body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = json.Unmarshal(body, &result)
+	}
+	return result, body, err
+}
+
 // UpdateUserWithJSON update a user using the provided JSON as POST body
 func UpdateUserWithJSON(user dataprovider.User, expectedStatusCode int, disconnect string, userAsJSON []byte) (dataprovider.User, []byte, error) {
 	var newUser dataprovider.User
@@ -199,6 +300,32 @@ func RemoveUser(user dataprovider.User, expectedStatusCode int) ([]byte, error)
 	return body, checkResponse(resp.StatusCode, expectedStatusCode)
 }
 
+// RemoveCachedUser removes the given user from the users cache and checks the received HTTP Status code
+// against expectedStatusCode.
+func RemoveCachedUser(user dataprovider.User, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(usersCachePath, url.PathEscape(user.Username)),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// ClearUsersCache removes all the cached users and checks the received HTTP Status code against expectedStatusCode.
+func ClearUsersCache(expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(usersCachePath), nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
 // GetUserByUsername gets a user by username and checks the received HTTP Status code against expectedStatusCode.
 func GetUserByUsername(username string, expectedStatusCode int) (dataprovider.User, []byte, error) {
 	var user dataprovider.User
@@ -351,6 +478,123 @@ func GetAdmins(limit, offset int64, expectedStatusCode int) ([]dataprovider.Admi
 	return admins, body, err
 }
 
+// AddAPIKey adds a new API key and checks the received HTTP Status code against expectedStatusCode.
+// The plain key, to use for authentication, is returned too
+func AddAPIKey(apiKey dataprovider.APIKey, expectedStatusCode int) (dataprovider.APIKey, string, []byte, error) {
+	var newAPIKey dataprovider.APIKey
+	var plainKey string
+	var body []byte
+	asJSON, _ := json.Marshal(apiKey)
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(apiKeysPath), bytes.NewBuffer(asJSON),
+		"application/json", getDefaultToken())
+	if err != nil {
+		return newAPIKey, plainKey, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusCreated {
+		body, _ = getResponseBody(resp)
+		return newAPIKey, plainKey, body, err
+	}
+	if err != nil {
+		body, _ = getResponseBody(resp)
+		return newAPIKey, plainKey, body, err
+	}
+	response := make(map[string]string)
+	err = render.DecodeJSON(resp.Body, &response)
+	if err != nil {
+		return newAPIKey, plainKey, body, err
+	}
+	plainKey = response["key"]
+	newAPIKey, body, err = GetAPIKeyByID(resp.Header.Get("X-Object-ID"), http.StatusOK)
+	if err == nil {
+		err = checkAPIKey(&apiKey, &newAPIKey)
+	}
+	return newAPIKey, plainKey, body, err
+}
+
+// UpdateAPIKey updates an existing API key and checks the received HTTP Status code against expectedStatusCode
+func UpdateAPIKey(apiKey dataprovider.APIKey, expectedStatusCode int) (dataprovider.APIKey, []byte, error) {
+	var newAPIKey dataprovider.APIKey
+	var body []byte
+
+	asJSON, _ := json.Marshal(apiKey)
+	resp, err := sendHTTPRequest(http.MethodPut, buildURLRelativeToBase(apiKeysPath, url.PathEscape(apiKey.KeyID)),
+		bytes.NewBuffer(asJSON), "application/json", getDefaultToken())
+	if err != nil {
+		return newAPIKey, body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if expectedStatusCode != http.StatusOK {
+		return newAPIKey, body, err
+	}
+	if err == nil {
+		newAPIKey, body, err = GetAPIKeyByID(apiKey.KeyID, expectedStatusCode)
+	}
+	if err == nil {
+		err = checkAPIKey(&apiKey, &newAPIKey)
+	}
+	return newAPIKey, body, err
+}
+
+// RemoveAPIKey removes an existing API key and checks the received HTTP Status code against expectedStatusCode.
+func RemoveAPIKey(apiKey dataprovider.APIKey, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(apiKeysPath, url.PathEscape(apiKey.KeyID)),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// GetAPIKeyByID gets a API key by ID and checks the received HTTP Status code against expectedStatusCode.
+func GetAPIKeyByID(keyID string, expectedStatusCode int) (dataprovider.APIKey, []byte, error) {
+	var apiKey dataprovider.APIKey
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(apiKeysPath, url.PathEscape(keyID)),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return apiKey, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &apiKey)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return apiKey, body, err
+}
+
+// GetAPIKeys returns a list of API keys and checks the received HTTP Status code against expectedStatusCode.
+// The number of results can be limited specifying a limit.
+// Some results can be skipped specifying an offset.
+func GetAPIKeys(limit, offset int64, expectedStatusCode int) ([]dataprovider.APIKey, []byte, error) {
+	var apiKeys []dataprovider.APIKey
+	var body []byte
+	url, err := addLimitAndOffsetQueryParams(buildURLRelativeToBase(apiKeysPath), limit, offset)
+	if err != nil {
+		return apiKeys, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodGet, url.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return apiKeys, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &apiKeys)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return apiKeys, body, err
+}
+
 // ChangeAdminPassword changes the password for an existing admin
 func ChangeAdminPassword(currentPassword, newPassword string, expectedStatusCode int) ([]byte, error) {
 	var body []byte
@@ -440,6 +684,34 @@ func GetConnections(expectedStatusCode int) ([]common.ConnectionStatus, []byte,
 	return connections, body, err
 }
 
+// GetConnectionHistory returns the completed connections matching the given query parameters,
+// for example username, ip, protocol, start_from, start_to, limit, offset and order
+func GetConnectionHistory(params map[string]string, expectedStatusCode int) ([]dataprovider.ConnectionRecord, []byte, error) {
+	var records []dataprovider.ConnectionRecord
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(connectionHistoryPath))
+	if err != nil {
+		return records, body, err
+	}
+	q := url.Query()
+	for k, v := range params {
+		q.Add(k, v)
+	}
+	url.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(http.MethodGet, url.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return records, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &records)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return records, body, err
+}
+
 // CloseConnection closes an active  connection identified by connectionID
 func CloseConnection(connectionID string, expectedStatusCode int) ([]byte, error) {
 	var body []byte
@@ -454,6 +726,37 @@ func CloseConnection(connectionID string, expectedStatusCode int) ([]byte, error
 	return body, err
 }
 
+// CloseUserConnections closes all the active connections for the given username,
+// optionally disabling the user and banning its source IP addresses
+func CloseUserConnections(username string, disable, ban bool, expectedStatusCode int) (map[string]interface{}, []byte, error) {
+	var response map[string]interface{}
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(activeConnectionsPath, "users", username))
+	if err != nil {
+		return response, body, err
+	}
+	q := url.Query()
+	if disable {
+		q.Add("disable", "1")
+	}
+	if ban {
+		q.Add("ban", "1")
+	}
+	url.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(http.MethodDelete, url.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return response, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &response)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return response, body, err
+}
+
 // AddFolder adds a new folder and checks the received HTTP Status code against expectedStatusCode
 func AddFolder(folder vfs.BaseVirtualFolder, expectedStatusCode int) (vfs.BaseVirtualFolder, []byte, error) {
 	var newFolder vfs.BaseVirtualFolder
@@ -714,6 +1017,17 @@ func UnbanIP(ip string, expectedStatusCode int) error {
 	return checkResponse(resp.StatusCode, expectedStatusCode)
 }
 
+// ReloadIPLists reloads the global IP allow and deny lists
+func ReloadIPLists(expectedStatusCode int) error {
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(ipListsReloadPath), nil, "",
+		getDefaultToken())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
 // Dumpdata requests a backup to outputFile.
 // outputFile is relative to the configured backups_path
 func Dumpdata(outputFile, outputData, indent string, expectedStatusCode int) (map[string]interface{}, []byte, error) {
@@ -897,10 +1211,95 @@ func checkAdmin(expected *dataprovider.Admin, actual *dataprovider.Admin) error
 			return errors.New("AllowList content mismatch")
 		}
 	}
+	if len(expected.Filters.Groups) != len(actual.Filters.Groups) {
+		return errors.New("Groups mismatch")
+	}
+	for _, v := range expected.Filters.Groups {
+		if !utils.IsStringInSlice(v, actual.Filters.Groups) {
+			return errors.New("Groups content mismatch")
+		}
+	}
+	if expected.Filters.AllowAPIKeyAuth != actual.Filters.AllowAPIKeyAuth {
+		return errors.New("AllowAPIKeyAuth mismatch")
+	}
+	if len(expected.Filters.TLSCertCommonNames) != len(actual.Filters.TLSCertCommonNames) {
+		return errors.New("TLSCertCommonNames mismatch")
+	}
+	for _, v := range expected.Filters.TLSCertCommonNames {
+		if !utils.IsStringInSlice(v, actual.Filters.TLSCertCommonNames) {
+			return errors.New("TLSCertCommonNames content mismatch")
+		}
+	}
 
 	return nil
 }
 
+func checkAPIKey(expected *dataprovider.APIKey, actual *dataprovider.APIKey) error {
+	if actual.Key != "" {
+		return errors.New("key must not be visible")
+	}
+	if actual.KeyID == "" {
+		return errors.New("actual key_id cannot be empty")
+	}
+	if expected.Name != actual.Name {
+		return errors.New("name mismatch")
+	}
+	if expected.Scope != actual.Scope {
+		return errors.New("scope mismatch")
+	}
+	if actual.CreatedAt == 0 {
+		return errors.New("created_at cannot be 0")
+	}
+	if actual.UpdatedAt == 0 {
+		return errors.New("updated_at cannot be 0")
+	}
+	if expected.ExpiresAt != actual.ExpiresAt {
+		return errors.New("expires_at mismatch")
+	}
+	if expected.Description != actual.Description {
+		return errors.New("description mismatch")
+	}
+	if expected.User != actual.User {
+		return errors.New("user mismatch")
+	}
+	if expected.Admin != actual.Admin {
+		return errors.New("admin mismatch")
+	}
+	if err := checkAPIKeyRestrictions(&expected.Restrictions, &actual.Restrictions); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func checkAPIKeyRestrictions(expected *dataprovider.APIKeyRestrictions, actual *dataprovider.APIKeyRestrictions) error {
+	if len(expected.Permissions) != len(actual.Permissions) {
+		return errors.New("restricted permissions mismatch")
+	}
+	for _, perm := range expected.Permissions {
+		if !utils.IsStringInSlice(perm, actual.Permissions) {
+			return errors.New("restricted permissions content mismatch")
+		}
+	}
+	if len(expected.Users) != len(actual.Users) {
+		return errors.New("restricted users mismatch")
+	}
+	for _, username := range expected.Users {
+		if !utils.IsStringInSlice(username, actual.Users) {
+			return errors.New("restricted users content mismatch")
+		}
+	}
+	if len(expected.Groups) != len(actual.Groups) {
+		return errors.New("restricted groups mismatch")
+	}
+	for _, group := range expected.Groups {
+		if !utils.IsStringInSlice(group, actual.Groups) {
+			return errors.New("restricted groups content mismatch")
+		}
+	}
+	return nil
+}
+
 func checkUser(expected *dataprovider.User, actual *dataprovider.User) error {
 	if actual.Password != "" {
 		return errors.New("User password must not be visible")
@@ -995,6 +1394,18 @@ func compareS3Config(expected *dataprovider.User, actual *dataprovider.User) err
 	if err := checkEncryptedSecret(expected.FsConfig.S3Config.AccessSecret, actual.FsConfig.S3Config.AccessSecret); err != nil {
 		return fmt.Errorf("S3 access secret mismatch: %v", err)
 	}
+	if expected.FsConfig.S3Config.CredentialsFile != actual.FsConfig.S3Config.CredentialsFile {
+		return errors.New("S3 credentials file mismatch")
+	}
+	if expected.FsConfig.S3Config.CredentialsProfile != actual.FsConfig.S3Config.CredentialsProfile {
+		return errors.New("S3 credentials profile mismatch")
+	}
+	if expected.FsConfig.S3Config.AccessKeyEnv != actual.FsConfig.S3Config.AccessKeyEnv {
+		return errors.New("S3 access key env mismatch")
+	}
+	if expected.FsConfig.S3Config.AccessSecretEnv != actual.FsConfig.S3Config.AccessSecretEnv {
+		return errors.New("S3 access secret env mismatch")
+	}
 	if expected.FsConfig.S3Config.Endpoint != actual.FsConfig.S3Config.Endpoint {
 		return errors.New("S3 endpoint mismatch")
 	}
@@ -1028,6 +1439,12 @@ func compareGCSConfig(expected *dataprovider.User, actual *dataprovider.User) er
 	if expected.FsConfig.GCSConfig.AutomaticCredentials != actual.FsConfig.GCSConfig.AutomaticCredentials {
 		return errors.New("GCS automatic credentials mismatch")
 	}
+	if expected.FsConfig.GCSConfig.SharedCredentialsFile != actual.FsConfig.GCSConfig.SharedCredentialsFile {
+		return errors.New("GCS credentials file mismatch")
+	}
+	if expected.FsConfig.GCSConfig.CredentialsEnv != actual.FsConfig.GCSConfig.CredentialsEnv {
+		return errors.New("GCS credentials env mismatch")
+	}
 	return nil
 }
 
@@ -1070,6 +1487,12 @@ func compareAzBlobConfig(expected *dataprovider.User, actual *dataprovider.User)
 	if err := checkEncryptedSecret(expected.FsConfig.AzBlobConfig.AccountKey, actual.FsConfig.AzBlobConfig.AccountKey); err != nil {
 		return fmt.Errorf("Azure Blob account key mismatch: %v", err)
 	}
+	if expected.FsConfig.AzBlobConfig.AccountKeyFile != actual.FsConfig.AzBlobConfig.AccountKeyFile {
+		return errors.New("Azure Blob account key file mismatch")
+	}
+	if expected.FsConfig.AzBlobConfig.AccountKeyEnv != actual.FsConfig.AzBlobConfig.AccountKeyEnv {
+		return errors.New("Azure Blob account key env mismatch")
+	}
 	if expected.FsConfig.AzBlobConfig.Endpoint != actual.FsConfig.AzBlobConfig.Endpoint {
 		return errors.New("Azure Blob endpoint mismatch")
 	}
@@ -1143,6 +1566,12 @@ func compareUserFilters(expected *dataprovider.User, actual *dataprovider.User)
 	if len(expected.Filters.DeniedIP) != len(actual.Filters.DeniedIP) {
 		return errors.New("DeniedIP mismatch")
 	}
+	if len(expected.Filters.AllowedCountries) != len(actual.Filters.AllowedCountries) {
+		return errors.New("Allowed countries mismatch")
+	}
+	if len(expected.Filters.DeniedCountries) != len(actual.Filters.DeniedCountries) {
+		return errors.New("Denied countries mismatch")
+	}
 	if len(expected.Filters.DeniedLoginMethods) != len(actual.Filters.DeniedLoginMethods) {
 		return errors.New("Denied login methods mismatch")
 	}
@@ -1152,6 +1581,23 @@ func compareUserFilters(expected *dataprovider.User, actual *dataprovider.User)
 	if expected.Filters.MaxUploadFileSize != actual.Filters.MaxUploadFileSize {
 		return errors.New("Max upload file size mismatch")
 	}
+	if expected.Filters.MaxConcurrentTransfers != actual.Filters.MaxConcurrentTransfers {
+		return errors.New("Max concurrent transfers mismatch")
+	}
+	if expected.Filters.AllowAPIKeyAuth != actual.Filters.AllowAPIKeyAuth {
+		return errors.New("AllowAPIKeyAuth mismatch")
+	}
+	for _, protocol := range dataprovider.ValidProtocols {
+		if expected.Filters.MaxSessionsPerProtocol[protocol] != actual.Filters.MaxSessionsPerProtocol[protocol] {
+			return errors.New("Max sessions per protocol mismatch")
+		}
+	}
+	if expected.Filters.TransferQuota != actual.Filters.TransferQuota {
+		return errors.New("Transfer quota mismatch")
+	}
+	if expected.Filters.PartialUploads != actual.Filters.PartialUploads {
+		return errors.New("Partial uploads mismatch")
+	}
 	for _, IPMask := range expected.Filters.AllowedIP {
 		if !utils.IsStringInSlice(IPMask, actual.Filters.AllowedIP) {
 			return errors.New("AllowedIP contents mismatch")
@@ -1162,6 +1608,16 @@ func compareUserFilters(expected *dataprovider.User, actual *dataprovider.User)
 			return errors.New("DeniedIP contents mismatch")
 		}
 	}
+	for _, country := range expected.Filters.AllowedCountries {
+		if !utils.IsStringInSlice(country, actual.Filters.AllowedCountries) {
+			return errors.New("Allowed countries contents mismatch")
+		}
+	}
+	for _, country := range expected.Filters.DeniedCountries {
+		if !utils.IsStringInSlice(country, actual.Filters.DeniedCountries) {
+			return errors.New("Denied countries contents mismatch")
+		}
+	}
 	for _, method := range expected.Filters.DeniedLoginMethods {
 		if !utils.IsStringInSlice(method, actual.Filters.DeniedLoginMethods) {
 			return errors.New("Denied login methods contents mismatch")
@@ -1172,12 +1628,71 @@ func compareUserFilters(expected *dataprovider.User, actual *dataprovider.User)
 			return errors.New("Denied protocols contents mismatch")
 		}
 	}
+	if len(expected.Filters.Groups) != len(actual.Filters.Groups) {
+		return errors.New("Groups mismatch")
+	}
+	for _, group := range expected.Filters.Groups {
+		if !utils.IsStringInSlice(group, actual.Filters.Groups) {
+			return errors.New("Groups contents mismatch")
+		}
+	}
+	if err := compareUserBandwidthLimits(expected, actual); err != nil {
+		return err
+	}
+	if err := compareUserDirectoryLimits(expected, actual); err != nil {
+		return err
+	}
+	if err := compareUserDataRetention(expected, actual); err != nil {
+		return err
+	}
 	if err := compareUserFileExtensionsFilters(expected, actual); err != nil {
 		return err
 	}
 	return compareUserFilePatternsFilters(expected, actual)
 }
 
+func compareUserBandwidthLimits(expected *dataprovider.User, actual *dataprovider.User) error {
+	if len(expected.Filters.BandwidthLimits) != len(actual.Filters.BandwidthLimits) {
+		return errors.New("bandwidth limits mismatch")
+	}
+	for idx, l := range expected.Filters.BandwidthLimits {
+		if l.UploadBandwidth != actual.Filters.BandwidthLimits[idx].UploadBandwidth {
+			return errors.New("bandwidth limit upload mismatch")
+		}
+		if l.DownloadBandwidth != actual.Filters.BandwidthLimits[idx].DownloadBandwidth {
+			return errors.New("bandwidth limit download mismatch")
+		}
+		if !checkFilterMatch(l.Sources, actual.Filters.BandwidthLimits[idx].Sources) {
+			return errors.New("bandwidth limit sources mismatch")
+		}
+	}
+	return nil
+}
+
+func compareUserDirectoryLimits(expected *dataprovider.User, actual *dataprovider.User) error {
+	if len(expected.Filters.DirectoryLimits) != len(actual.Filters.DirectoryLimits) {
+		return errors.New("directory limits mismatch")
+	}
+	for idx, l := range expected.Filters.DirectoryLimits {
+		if l != actual.Filters.DirectoryLimits[idx] {
+			return errors.New("directory limit mismatch")
+		}
+	}
+	return nil
+}
+
+func compareUserDataRetention(expected *dataprovider.User, actual *dataprovider.User) error {
+	if len(expected.Filters.DataRetention) != len(actual.Filters.DataRetention) {
+		return errors.New("data retention mismatch")
+	}
+	for idx, folder := range expected.Filters.DataRetention {
+		if folder != actual.Filters.DataRetention[idx] {
+			return errors.New("data retention policy mismatch")
+		}
+	}
+	return nil
+}
+
 func checkFilterMatch(expected []string, actual []string) bool {
 	if len(expected) != len(actual) {
 		return false
@@ -0,0 +1,51 @@
+// +build !noazurekms
+
+package kms
+
+import (
+	// we import azurekeyvault here to be able to disable Azure Key Vault support using a build tag
+	_ "gocloud.dev/secrets/azurekeyvault"
+
+	"github.com/drakkan/sftpgo/version"
+)
+
+type azureSecret struct {
+	baseGCloudSecret
+}
+
+func init() {
+	version.AddFeature("+azurekms")
+}
+
+func newAzureSecret(base baseSecret, url, masterKey string) SecretProvider {
+	return &azureSecret{
+		baseGCloudSecret{
+			baseSecret: base,
+			url:        url,
+			masterKey:  masterKey,
+		},
+	}
+}
+
+func (s *azureSecret) Name() string {
+	return azureProviderName
+}
+
+func (s *azureSecret) IsEncrypted() bool {
+	return s.Status == SecretStatusAzureKeyVault
+}
+
+func (s *azureSecret) Encrypt() error {
+	if err := s.baseGCloudSecret.Encrypt(); err != nil {
+		return err
+	}
+	s.Status = SecretStatusAzureKeyVault
+	return nil
+}
+
+func (s *azureSecret) Decrypt() error {
+	if !s.IsEncrypted() {
+		return errWrongSecretStatus
+	}
+	return s.baseGCloudSecret.Decrypt()
+}
@@ -0,0 +1,17 @@
+// +build noazurekms
+
+package kms
+
+import (
+	"errors"
+
+	"github.com/drakkan/sftpgo/version"
+)
+
+func init() {
+	version.AddFeature("-azurekms")
+}
+
+func newAzureSecret(base baseSecret, url, masterKey string) SecretProvider {
+	return newDisabledSecret(errors.New("Azure Key Vault KMS disabled at build time"))
+}
@@ -0,0 +1,25 @@
+// +build noazurekms
+
+package kms
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAzureSecretDisabled(t *testing.T) {
+	secret := newAzureSecret(baseSecret{Status: SecretStatusPlain, Payload: "payload"},
+		"azurekeyvault://mykeyvaultname.vault.azure.net/keys/mykeyname", "")
+	assert.Equal(t, disabledProviderName, secret.Name())
+	assert.False(t, secret.IsEncrypted())
+	err := secret.Encrypt()
+	assert.EqualError(t, err, "Azure Key Vault KMS disabled at build time")
+	err = secret.Decrypt()
+	assert.EqualError(t, err, "Azure Key Vault KMS disabled at build time")
+	// secrets encrypted using Azure Key Vault cannot be loaded
+	secret = newAzureSecret(baseSecret{Status: SecretStatusAzureKeyVault, Payload: "cGF5bG9hZA=="}, "", "")
+	assert.Equal(t, disabledProviderName, secret.Name())
+	err = secret.Decrypt()
+	assert.Error(t, err)
+}
@@ -0,0 +1,128 @@
+// +build !noazurekms
+
+package kms
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/secrets"
+	"gocloud.dev/secrets/localsecrets"
+)
+
+const testAzureURL = "azurekeyvault://mykeyvaultname.vault.azure.net/keys/mykeyname"
+
+func getTestAzureSecret(t *testing.T, base baseSecret, masterKey string) *azureSecret {
+	key, err := localsecrets.NewRandomKey()
+	require.NoError(t, err)
+	secret := newAzureSecret(base, testAzureURL, masterKey).(*azureSecret)
+	// the Azure Key Vault is replaced with a local keeper
+	secret.keeperOpener = func(ctx context.Context) (*secrets.Keeper, error) {
+		return localsecrets.NewKeeper(key), nil
+	}
+	return secret
+}
+
+func TestAzureSecretStatus(t *testing.T) {
+	secret := newAzureSecret(baseSecret{Status: SecretStatusPlain, Payload: "payload"}, testAzureURL, "")
+	assert.Equal(t, azureProviderName, secret.Name())
+	assert.False(t, secret.IsEncrypted())
+	err := secret.Decrypt()
+	assert.ErrorIs(t, err, errWrongSecretStatus)
+
+	secret = newAzureSecret(baseSecret{Status: SecretStatusAzureKeyVault, Payload: "payload"}, testAzureURL, "")
+	assert.True(t, secret.IsEncrypted())
+	err = secret.Encrypt()
+	assert.ErrorIs(t, err, errWrongSecretStatus)
+	// the payload is not base64 encoded
+	secret = newAzureSecret(baseSecret{Status: SecretStatusAzureKeyVault, Payload: "$"}, testAzureURL, "")
+	err = secret.Decrypt()
+	assert.Error(t, err)
+}
+
+func TestAzureSecretEncryptDecrypt(t *testing.T) {
+	for _, masterKey := range []string{"", "test master key"} {
+		secret := getTestAzureSecret(t, baseSecret{
+			Status:         SecretStatusPlain,
+			Payload:        "test payload",
+			AdditionalData: "add data",
+		}, masterKey)
+		err := secret.Encrypt()
+		require.NoError(t, err)
+		assert.Equal(t, SecretStatusAzureKeyVault, secret.GetStatus())
+		assert.True(t, secret.IsEncrypted())
+		assert.NotEqual(t, "test payload", secret.GetPayload())
+		assert.Equal(t, "add data", secret.GetAdditionalData())
+		if masterKey == "" {
+			assert.Empty(t, secret.GetKey())
+			assert.Equal(t, 0, secret.GetMode())
+		} else {
+			assert.Len(t, secret.GetKey(), 64)
+			assert.Equal(t, 1, secret.GetMode())
+		}
+		err = secret.Decrypt()
+		require.NoError(t, err)
+		assert.Equal(t, SecretStatusPlain, secret.GetStatus())
+		assert.Equal(t, "test payload", secret.GetPayload())
+		assert.Empty(t, secret.GetKey())
+		assert.Empty(t, secret.GetAdditionalData())
+		assert.Equal(t, 0, secret.GetMode())
+	}
+	secret := getTestAzureSecret(t, baseSecret{Status: SecretStatusPlain}, "")
+	err := secret.Encrypt()
+	assert.ErrorIs(t, err, errInvalidSecret)
+}
+
+func TestAzureSecretObject(t *testing.T) {
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+
+	c := Configuration{
+		Secrets: Secrets{
+			URL: testAzureURL,
+		},
+	}
+	err := c.Initialize()
+	require.NoError(t, err)
+	secret := NewPlainSecret("test payload")
+	assert.Equal(t, azureProviderName, secret.provider.Name())
+	assert.True(t, secret.IsValid())
+
+	secret = NewSecret(SecretStatusAzureKeyVault, "cGF5bG9hZA==", "", "add data")
+	assert.Equal(t, azureProviderName, secret.provider.Name())
+	assert.True(t, secret.IsEncrypted())
+	assert.True(t, secret.IsValid())
+	secret.SetKey("invalid key")
+	assert.False(t, secret.IsValid())
+	secret.SetKey("")
+
+	asJSON, err := json.Marshal(secret)
+	require.NoError(t, err)
+	loaded := NewEmptySecret()
+	err = json.Unmarshal(asJSON, loaded)
+	require.NoError(t, err)
+	assert.Equal(t, azureProviderName, loaded.provider.Name())
+	assert.Equal(t, SecretStatusAzureKeyVault, loaded.GetStatus())
+	assert.Equal(t, "cGF5bG9hZA==", loaded.GetPayload())
+	assert.Equal(t, "add data", loaded.GetAdditionalData())
+
+	clone := loaded.Clone()
+	assert.Equal(t, azureProviderName, clone.provider.Name())
+	assert.Equal(t, SecretStatusAzureKeyVault, clone.GetStatus())
+	assert.Equal(t, loaded.GetPayload(), clone.GetPayload())
+	clone.SetAdditionalData("other data")
+	assert.Equal(t, "add data", loaded.GetAdditionalData())
+	// the Azure secrets are loaded even if the configured KMS is different
+	c.Secrets.URL = ""
+	err = c.Initialize()
+	require.NoError(t, err)
+	loaded = NewEmptySecret()
+	err = json.Unmarshal(asJSON, loaded)
+	require.NoError(t, err)
+	assert.Equal(t, azureProviderName, loaded.provider.Name())
+}
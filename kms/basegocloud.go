@@ -12,6 +12,16 @@ type baseGCloudSecret struct {
 	baseSecret
 	masterKey string
 	url       string
+	// optional function to open the keeper, if nil the keeper is opened from the url
+	// using the Go CDK default URL openers
+	keeperOpener func(ctx context.Context) (*secrets.Keeper, error)
+}
+
+func (s *baseGCloudSecret) openKeeper(ctx context.Context) (*secrets.Keeper, error) {
+	if s.keeperOpener != nil {
+		return s.keeperOpener(ctx)
+	}
+	return secrets.OpenKeeper(ctx, s.url)
 }
 
 func (s *baseGCloudSecret) Encrypt() error {
@@ -39,7 +49,7 @@ func (s *baseGCloudSecret) Encrypt() error {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(defaultTimeout))
 	defer cancelFn()
 
-	keeper, err := secrets.OpenKeeper(ctx, s.url)
+	keeper, err := s.openKeeper(ctx)
 	if err != nil {
 		return err
 	}
@@ -63,7 +73,7 @@ func (s *baseGCloudSecret) Decrypt() error {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(defaultTimeout))
 	defer cancelFn()
 
-	keeper, err := secrets.OpenKeeper(ctx, s.url)
+	keeper, err := s.openKeeper(ctx)
 	if err != nil {
 		return err
 	}
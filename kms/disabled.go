@@ -1,4 +1,4 @@
-// +build noawskms nogcpkms novaultkms
+// +build noawskms nogcpkms novaultkms noazurekms
 
 package kms
 
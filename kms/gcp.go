@@ -3,12 +3,30 @@
 package kms
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"sync"
+
+	cloudkms "cloud.google.com/go/kms/apiv1"
+	"gocloud.dev/secrets"
 	// we import gcpkms here to be able to disable GCP KMS support using a build tag
-	_ "gocloud.dev/secrets/gcpkms"
+	"gocloud.dev/secrets/gcpkms"
+	"golang.org/x/oauth2/google"
 
 	"github.com/drakkan/sftpgo/version"
 )
 
+// gcpCredentialsFileParam is the optional URL query parameter to define the path to
+// a service account credentials file. If not set Application Default Credentials are used
+const gcpCredentialsFileParam = "credentials_file"
+
+var gcpClients = gcpKMSClients{
+	clients: make(map[string]*cloudkms.KeyManagementClient),
+}
+
 type gcpSecret struct {
 	baseGCloudSecret
 }
@@ -18,13 +36,37 @@ func init() {
 }
 
 func newGCPSecret(base baseSecret, url, masterKey string) SecretProvider {
-	return &gcpSecret{
+	secret := &gcpSecret{
 		baseGCloudSecret{
 			baseSecret: base,
 			url:        url,
 			masterKey:  masterKey,
 		},
 	}
+	if keyResourceID, credentialsFile, ok := getGCPCredentialsFileFromURL(url); ok {
+		secret.keeperOpener = func(ctx context.Context) (*secrets.Keeper, error) {
+			client, err := gcpClients.get(ctx, credentialsFile)
+			if err != nil {
+				return nil, err
+			}
+			return gcpkms.OpenKeeper(client, keyResourceID, nil), nil
+		}
+	}
+	return secret
+}
+
+// getGCPCredentialsFileFromURL returns the key resource ID and the credentials file,
+// if the credentials file is defined in the given URL
+func getGCPCredentialsFileFromURL(kmsURL string) (string, string, bool) {
+	u, err := url.Parse(kmsURL)
+	if err != nil {
+		return "", "", false
+	}
+	credentialsFile := u.Query().Get(gcpCredentialsFileParam)
+	if credentialsFile == "" {
+		return "", "", false
+	}
+	return path.Join(u.Host, u.Path), credentialsFile, true
 }
 
 func (s *gcpSecret) Name() string {
@@ -49,3 +91,33 @@ func (s *gcpSecret) Decrypt() error {
 	}
 	return s.baseGCloudSecret.Decrypt()
 }
+
+// gcpKMSClients caches the Cloud KMS clients for the configured credentials files
+type gcpKMSClients struct {
+	sync.Mutex
+	clients map[string]*cloudkms.KeyManagementClient
+}
+
+func (c *gcpKMSClients) get(ctx context.Context, credentialsFile string) (*cloudkms.KeyManagementClient, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if client, ok := c.clients[credentialsFile]; ok {
+		return client, nil
+	}
+	content, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read GCP credentials file: %w", err)
+	}
+	creds, err := google.CredentialsFromJSON(ctx, content, cloudkms.DefaultAuthScopes()...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse GCP credentials file %#v: %w", credentialsFile, err)
+	}
+	// the client must outlive the given context, it is used for all the future requests
+	client, _, err := gcpkms.Dial(context.Background(), creds.TokenSource)
+	if err != nil {
+		return nil, err
+	}
+	c.clients[credentialsFile] = client
+	return client, nil
+}
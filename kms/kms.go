@@ -46,6 +46,9 @@ const (
 	// SecretStatusVaultTransit means we use the transit secrets engine in Vault
 	// to keep information secret
 	SecretStatusVaultTransit SecretStatus = "VaultTransit"
+	// SecretStatusAzureKeyVault means we use keys from Azure Key Vault to keep
+	// information secret
+	SecretStatusAzureKeyVault SecretStatus = "AzureKeyVault"
 	// SecretStatusRedacted means the secret is redacted
 	SecretStatusRedacted SecretStatus = "Redacted"
 )
@@ -56,6 +59,7 @@ const (
 	awsProviderName     = "AWS"
 	gcpProviderName     = "GCP"
 	vaultProviderName   = "VaultTransit"
+	azureProviderName   = "AzureKeyVault"
 )
 
 // Configuration defines the KMS configuration
@@ -65,9 +69,13 @@ type Configuration struct {
 
 // Secrets define the KMS configuration for encryption/decryption
 type Secrets struct {
-	URL           string `json:"url" mapstructure:"url"`
-	MasterKeyPath string `json:"master_key_path" mapstructure:"master_key_path"`
-	masterKey     string
+	URL string `json:"url" mapstructure:"url"`
+	// MasterKeyString defines the master key as string, it is ignored if
+	// MasterKeyPath is set. It is useful to set the master key using an
+	// environment variable
+	MasterKeyString string `json:"master_key" mapstructure:"master_key"`
+	MasterKeyPath   string `json:"master_key_path" mapstructure:"master_key_path"`
+	masterKey       string
 }
 
 var (
@@ -75,7 +83,7 @@ var (
 	errMalformedCiphertext = errors.New("malformed ciphertext")
 	errInvalidSecret       = errors.New("invalid secret")
 	validSecretStatuses    = []string{SecretStatusPlain, SecretStatusAES256GCM, SecretStatusSecretBox,
-		SecretStatusVaultTransit, SecretStatusAWS, SecretStatusGCP, SecretStatusRedacted}
+		SecretStatusVaultTransit, SecretStatusAWS, SecretStatusGCP, SecretStatusAzureKeyVault, SecretStatusRedacted}
 	config         Configuration
 	defaultTimeout = 10 * time.Second
 )
@@ -112,6 +120,8 @@ func (c *Configuration) Initialize() error {
 			return err
 		}
 		c.Secrets.masterKey = strings.TrimSpace(string(mKey))
+	} else {
+		c.Secrets.masterKey = strings.TrimSpace(c.Secrets.MasterKeyString)
 	}
 	config = *c
 	return nil
@@ -139,6 +149,9 @@ func (c *Configuration) getSecretProvider(base baseSecret) SecretProvider {
 	if strings.HasPrefix(c.Secrets.URL, "gcpkms://") {
 		return newGCPSecret(base, c.Secrets.URL, c.Secrets.masterKey)
 	}
+	if strings.HasPrefix(c.Secrets.URL, "azurekeyvault://") {
+		return newAzureSecret(base, c.Secrets.URL, c.Secrets.masterKey)
+	}
 	return newLocalSecret(base, c.Secrets.masterKey)
 }
 
@@ -181,6 +194,8 @@ func (s *Secret) UnmarshalJSON(data []byte) error {
 		s.provider = newAWSSecret(baseSecret, config.Secrets.URL, config.Secrets.masterKey)
 	case SecretStatusGCP:
 		s.provider = newGCPSecret(baseSecret, config.Secrets.URL, config.Secrets.masterKey)
+	case SecretStatusAzureKeyVault:
+		s.provider = newAzureSecret(baseSecret, config.Secrets.URL, config.Secrets.masterKey)
 	case SecretStatusPlain, SecretStatusRedacted:
 		s.provider = config.getSecretProvider(baseSecret)
 	default:
@@ -219,6 +234,10 @@ func (s *Secret) Clone() *Secret {
 		return &Secret{
 			provider: newVaultSecret(baseSecret, config.Secrets.URL, config.Secrets.masterKey),
 		}
+	case azureProviderName:
+		return &Secret{
+			provider: newAzureSecret(baseSecret, config.Secrets.URL, config.Secrets.masterKey),
+		}
 	}
 	return NewSecret(s.GetStatus(), s.GetPayload(), s.GetKey(), s.GetAdditionalData())
 }
@@ -314,7 +333,7 @@ func (s *Secret) IsValid() bool {
 		if len(s.provider.GetKey()) != 64 {
 			return false
 		}
-	case SecretStatusAWS, SecretStatusGCP, SecretStatusVaultTransit:
+	case SecretStatusAWS, SecretStatusGCP, SecretStatusVaultTransit, SecretStatusAzureKeyVault:
 		key := s.provider.GetKey()
 		if key != "" && len(key) != 64 {
 			return false
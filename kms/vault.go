@@ -3,12 +3,34 @@
 package kms
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"gocloud.dev/secrets"
 	// we import hashivault here to be able to disable Vault support using a build tag
-	_ "gocloud.dev/secrets/hashivault"
+	"gocloud.dev/secrets/hashivault"
 
 	"github.com/drakkan/sftpgo/version"
 )
 
+// Environment variables to configure the Vault AppRole authentication.
+// If the role ID is not set the default Go CDK token authentication is used
+const (
+	vaultServerURLEnv   = "VAULT_SERVER_URL"
+	vaultRoleIDEnv      = "VAULT_ROLE_ID"
+	vaultSecretIDEnv    = "VAULT_SECRET_ID"
+	vaultAppRolePathEnv = "VAULT_APPROLE_PATH"
+)
+
+var vaultAppRole vaultAppRoleAuth
+
 type vaultSecret struct {
 	baseGCloudSecret
 }
@@ -18,13 +40,29 @@ func init() {
 }
 
 func newVaultSecret(base baseSecret, url, masterKey string) SecretProvider {
-	return &vaultSecret{
+	secret := &vaultSecret{
 		baseGCloudSecret{
 			baseSecret: base,
 			url:        url,
 			masterKey:  masterKey,
 		},
 	}
+	if os.Getenv(vaultRoleIDEnv) != "" {
+		secret.keeperOpener = secret.openAppRoleKeeper
+	}
+	return secret
+}
+
+func (s *vaultSecret) openAppRoleKeeper(ctx context.Context) (*secrets.Keeper, error) {
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return nil, err
+	}
+	client, err := vaultAppRole.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return hashivault.OpenKeeper(client, path.Join(u.Host, u.Path), nil), nil
 }
 
 func (s *vaultSecret) Name() string {
@@ -49,3 +87,52 @@ func (s *vaultSecret) Decrypt() error {
 	}
 	return s.baseGCloudSecret.Decrypt()
 }
+
+// vaultAppRoleAuth caches the Vault client authenticated using AppRole,
+// a new login is done when the token is about to expire
+type vaultAppRoleAuth struct {
+	sync.Mutex
+	client     *api.Client
+	expiration time.Time
+}
+
+func (a *vaultAppRoleAuth) getClient(ctx context.Context) (*api.Client, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.client != nil && (a.expiration.IsZero() || time.Now().Add(30*time.Second).Before(a.expiration)) {
+		return a.client, nil
+	}
+	serverURL := os.Getenv(vaultServerURLEnv)
+	if serverURL == "" {
+		return nil, fmt.Errorf("%v environment variable is not set", vaultServerURLEnv)
+	}
+	client, err := hashivault.Dial(ctx, &hashivault.Config{APIConfig: api.Config{Address: serverURL}})
+	if err != nil {
+		return nil, fmt.Errorf("unable to dial Vault server at %#v: %w", serverURL, err)
+	}
+	mountPath := os.Getenv(vaultAppRolePathEnv)
+	if mountPath == "" {
+		mountPath = "approle"
+	}
+	data := map[string]interface{}{
+		"role_id": os.Getenv(vaultRoleIDEnv),
+	}
+	if secretID := os.Getenv(vaultSecretIDEnv); secretID != "" {
+		data["secret_id"] = secretID
+	}
+	secret, err := client.Logical().Write(path.Join("auth", mountPath, "login"), data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to login to Vault using AppRole: %w", err)
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return nil, errors.New("unable to login to Vault using AppRole: no token returned")
+	}
+	client.SetToken(secret.Auth.ClientToken)
+	a.client = client
+	a.expiration = time.Time{}
+	if secret.Auth.LeaseDuration > 0 {
+		a.expiration = time.Now().Add(time.Duration(secret.Auth.LeaseDuration) * time.Second)
+	}
+	return client, nil
+}
@@ -9,6 +9,7 @@ import (
 
 // InitJournalDLogger configures the logger to write to journald
 func InitJournalDLogger(level zerolog.Level) {
-	logger = zerolog.New(journald.NewJournalDWriter()).Level(level)
+	setLoggers(journald.NewJournalDWriter(), level)
 	consoleLogger = zerolog.Nop()
+	rollingLogger = nil
 }
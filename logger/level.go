@@ -0,0 +1,145 @@
+package logger
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+var (
+	// the minimum level for the logged events, it can be changed at runtime
+	logLevel int32 = int32(zerolog.DebugLevel)
+	// 1 if the debug logs are enabled for at least one connection, it allows to
+	// skip the lookup in debugConnections for the events below the log level
+	hasDebugConnections int32
+	debugConnections    = struct {
+		sync.RWMutex
+		ids map[string]bool
+	}{
+		ids: make(map[string]bool),
+	}
+)
+
+// levelFilterWriter discards the events below the current log level.
+// The events built inside this package are checked against the log level
+// before encoding them, this filter is required for the events built using
+// the logger returned by GetLogger
+type levelFilterWriter struct {
+	output io.Writer
+	// the level is already checked against the module level, if any
+	skipLevelCheck bool
+}
+
+func (w *levelFilterWriter) Write(p []byte) (int, error) {
+	return w.output.Write(p)
+}
+
+// WriteLevel implements zerolog.LevelWriter
+func (w *levelFilterWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	if !w.skipLevelCheck && level < GetLevel() {
+		return len(p), nil
+	}
+	shipEvent(level, p)
+	if lw, ok := w.output.(zerolog.LevelWriter); ok {
+		return lw.WriteLevel(level, p)
+	}
+	return w.output.Write(p)
+}
+
+// setLoggers configures the main logger and the logger used for the connections
+// with debug logs enabled. The loggers are not bound to a level, this way the
+// level can be safely changed while they are in use
+func setLoggers(output io.Writer, level zerolog.Level) {
+	SetLevel(level)
+	logger = zerolog.New(&levelFilterWriter{output: output}).Level(zerolog.DebugLevel)
+	moduleLogger = zerolog.New(&levelFilterWriter{output: output, skipLevelCheck: true}).Level(zerolog.DebugLevel)
+	debugLogger = zerolog.New(output).Level(zerolog.DebugLevel)
+}
+
+// SetLevel changes the log level at runtime
+func SetLevel(level zerolog.Level) {
+	atomic.StoreInt32(&logLevel, int32(level))
+}
+
+// GetLevel returns the current log level
+func GetLevel() zerolog.Level {
+	return zerolog.Level(atomic.LoadInt32(&logLevel))
+}
+
+func isLevelEnabled(level zerolog.Level) bool {
+	return level >= GetLevel()
+}
+
+// newEvent returns a new event for the main logger or nil if the specified level
+// is not enabled. zerolog ignores nil events
+func newEvent(level zerolog.Level) *zerolog.Event {
+	if !isLevelEnabled(level) {
+		return nil
+	}
+	return logger.WithLevel(level)
+}
+
+// newSenderEvent is like newEvent but it honors the log level configured
+// for the module the sender belongs to, if any
+func newSenderEvent(level zerolog.Level, sender string) *zerolog.Event {
+	l := getSenderLogger(level, sender)
+	if l == nil {
+		return nil
+	}
+	return l.WithLevel(level)
+}
+
+// EnableConnectionDebug enables the debug logs for the specified connection ID
+// regardless of the current log level
+func EnableConnectionDebug(connectionID string) {
+	debugConnections.Lock()
+	defer debugConnections.Unlock()
+
+	debugConnections.ids[connectionID] = true
+	atomic.StoreInt32(&hasDebugConnections, 1)
+}
+
+// DisableConnectionDebug disables the debug logs for the specified connection ID.
+// It returns false if the debug logs were not enabled for this connection
+func DisableConnectionDebug(connectionID string) bool {
+	debugConnections.Lock()
+	defer debugConnections.Unlock()
+
+	if _, ok := debugConnections.ids[connectionID]; ok {
+		delete(debugConnections.ids, connectionID)
+		if len(debugConnections.ids) == 0 {
+			atomic.StoreInt32(&hasDebugConnections, 0)
+		}
+		return true
+	}
+	return false
+}
+
+// IsConnectionDebugEnabled returns true if the debug logs are enabled for
+// the specified connection ID
+func IsConnectionDebugEnabled(connectionID string) bool {
+	if atomic.LoadInt32(&hasDebugConnections) == 0 {
+		return false
+	}
+
+	debugConnections.RLock()
+	defer debugConnections.RUnlock()
+
+	return debugConnections.ids[connectionID]
+}
+
+// GetDebugConnections returns the connection IDs with debug logs enabled
+func GetDebugConnections() []string {
+	debugConnections.RLock()
+	defer debugConnections.RUnlock()
+
+	result := make([]string, 0, len(debugConnections.ids))
+	for id := range debugConnections.ids {
+		result = append(result, id)
+	}
+	sort.Strings(result)
+	return result
+}
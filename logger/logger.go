@@ -38,6 +38,10 @@ var (
 	logger        zerolog.Logger
 	consoleLogger zerolog.Logger
 	rollingLogger *lumberjack.Logger
+	// logs everything, it is used for the connections with debug logs enabled
+	debugLogger zerolog.Logger
+	// used for the modules with a specific log level
+	moduleLogger zerolog.Logger
 )
 
 // StdLoggerWrapper is a wrapper for standard logger compatibility
@@ -79,7 +83,10 @@ func (l *LeveledLogger) addKeysAndValues(ev *zerolog.Event, keysAndValues ...int
 
 // Error logs at error level for the specified sender
 func (l *LeveledLogger) Error(msg string, keysAndValues ...interface{}) {
-	ev := logger.Error()
+	ev := newSenderEvent(zerolog.ErrorLevel, l.Sender)
+	if ev == nil {
+		return
+	}
 	ev.Timestamp().Str("sender", l.Sender)
 	l.addKeysAndValues(ev, keysAndValues...)
 	ev.Msg(msg)
@@ -87,7 +94,10 @@ func (l *LeveledLogger) Error(msg string, keysAndValues ...interface{}) {
 
 // Info logs at info level for the specified sender
 func (l *LeveledLogger) Info(msg string, keysAndValues ...interface{}) {
-	ev := logger.Info()
+	ev := newSenderEvent(zerolog.InfoLevel, l.Sender)
+	if ev == nil {
+		return
+	}
 	ev.Timestamp().Str("sender", l.Sender)
 	l.addKeysAndValues(ev, keysAndValues...)
 	ev.Msg(msg)
@@ -95,7 +105,10 @@ func (l *LeveledLogger) Info(msg string, keysAndValues ...interface{}) {
 
 // Debug logs at debug level for the specified sender
 func (l *LeveledLogger) Debug(msg string, keysAndValues ...interface{}) {
-	ev := logger.Debug()
+	ev := newSenderEvent(zerolog.DebugLevel, l.Sender)
+	if ev == nil {
+		return
+	}
 	ev.Timestamp().Str("sender", l.Sender)
 	l.addKeysAndValues(ev, keysAndValues...)
 	ev.Msg(msg)
@@ -103,7 +116,10 @@ func (l *LeveledLogger) Debug(msg string, keysAndValues ...interface{}) {
 
 // Warn logs at warn level for the specified sender
 func (l *LeveledLogger) Warn(msg string, keysAndValues ...interface{}) {
-	ev := logger.Warn()
+	ev := newSenderEvent(zerolog.WarnLevel, l.Sender)
+	if ev == nil {
+		return
+	}
 	ev.Timestamp().Str("sender", l.Sender)
 	l.addKeysAndValues(ev, keysAndValues...)
 	ev.Msg(msg)
@@ -125,22 +141,21 @@ func InitLogger(logFilePath string, logMaxSize int, logMaxBackups int, logMaxAge
 			MaxAge:     logMaxAge,
 			Compress:   logCompress,
 		}
-		logger = zerolog.New(rollingLogger)
+		setLoggers(rollingLogger, level)
 		EnableConsoleLogger(level)
 	} else {
-		logger = zerolog.New(&logSyncWrapper{
+		setLoggers(&logSyncWrapper{
 			output: os.Stdout,
-		})
+		}, level)
 		consoleLogger = zerolog.Nop()
 	}
-	logger = logger.Level(level)
 }
 
 // InitStdErrLogger configures the logger to write to stderr
 func InitStdErrLogger(level zerolog.Level) {
-	logger = zerolog.New(&logSyncWrapper{
+	setLoggers(&logSyncWrapper{
 		output: os.Stderr,
-	}).Level(level)
+	}, level)
 	consoleLogger = zerolog.Nop()
 }
 
@@ -148,6 +163,8 @@ func InitStdErrLogger(level zerolog.Level) {
 // ConsoleLogger will not be affected
 func DisableLogger() {
 	logger = zerolog.Nop()
+	debugLogger = zerolog.Nop()
+	moduleLogger = zerolog.Nop()
 	rollingLogger = nil
 }
 
@@ -171,21 +188,38 @@ func RotateLogFile() error {
 
 // Log logs at the specified level for the specified sender
 func Log(level LogLevel, sender string, connectionID string, format string, v ...interface{}) {
-	var ev *zerolog.Event
+	ConnectionLog(level, sender, connectionID, "", format, v...)
+}
+
+// ConnectionLog logs at the specified level for the specified sender.
+// The username is added to the log fields if not empty
+func ConnectionLog(level LogLevel, sender, connectionID, username, format string, v ...interface{}) {
+	var zLevel zerolog.Level
 	switch level {
 	case LevelDebug:
-		ev = logger.Debug()
+		zLevel = zerolog.DebugLevel
 	case LevelInfo:
-		ev = logger.Info()
+		zLevel = zerolog.InfoLevel
 	case LevelWarn:
-		ev = logger.Warn()
+		zLevel = zerolog.WarnLevel
 	default:
-		ev = logger.Error()
+		zLevel = zerolog.ErrorLevel
 	}
+	l := getSenderLogger(zLevel, sender)
+	if l == nil {
+		if connectionID == "" || !IsConnectionDebugEnabled(connectionID) {
+			return
+		}
+		l = &debugLogger
+	}
+	ev := l.WithLevel(zLevel)
 	ev.Timestamp().Str("sender", sender)
 	if connectionID != "" {
 		ev.Str("connection_id", connectionID)
 	}
+	if username != "" {
+		ev.Str("username", username)
+	}
 	ev.Msg(fmt.Sprintf(format, v...))
 }
 
@@ -229,9 +263,10 @@ func ErrorToConsole(format string, v ...interface{}) {
 	consoleLogger.Error().Msg(fmt.Sprintf(format, v...))
 }
 
-// TransferLog logs uploads or downloads
-func TransferLog(operation string, path string, elapsed int64, size int64, user string, connectionID string, protocol string) {
-	logger.Info().
+// TransferLog logs uploads or downloads, the checksum is logged if not empty
+func TransferLog(operation string, path string, elapsed int64, size int64, user string, connectionID string, protocol string,
+	checksum string) {
+	ev := newEvent(zerolog.InfoLevel).
 		Timestamp().
 		Str("sender", operation).
 		Int64("elapsed_ms", elapsed).
@@ -239,14 +274,17 @@ func TransferLog(operation string, path string, elapsed int64, size int64, user
 		Str("username", user).
 		Str("file_path", path).
 		Str("connection_id", connectionID).
-		Str("protocol", protocol).
-		Send()
+		Str("protocol", protocol)
+	if checksum != "" {
+		ev.Str("sha256", checksum)
+	}
+	ev.Send()
 }
 
 // CommandLog logs an SFTP/SCP/SSH command
 func CommandLog(command, path, target, user, fileMode, connectionID, protocol string, uid, gid int, atime, mtime,
 	sshCommand string, size int64) {
-	logger.Info().
+	newEvent(zerolog.InfoLevel).
 		Timestamp().
 		Str("sender", command).
 		Str("username", user).
@@ -268,11 +306,12 @@ func CommandLog(command, path, target, user, fileMode, connectionID, protocol st
 // A connection can fail for an authentication error or other errors such as
 // a client abort or a time out if the login does not happen in two minutes.
 // These logs are useful for better integration with Fail2ban and similar tools.
-func ConnectionFailedLog(user, ip, loginType, protocol, errorString string) {
-	logger.Debug().
+func ConnectionFailedLog(user, ip, country, loginType, protocol, errorString string) {
+	newEvent(zerolog.DebugLevel).
 		Timestamp().
 		Str("sender", "connection_failed").
 		Str("client_ip", ip).
+		Str("country", country).
 		Str("username", user).
 		Str("login_type", loginType).
 		Str("protocol", protocol).
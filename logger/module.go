@@ -0,0 +1,176 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+// modules that support a specific log level
+const (
+	ModuleSFTPD        = "sftpd"
+	ModuleFTPD         = "ftpd"
+	ModuleWebDAVD      = "webdavd"
+	ModuleHTTPD        = "httpd"
+	ModuleDataProvider = "dataprovider"
+)
+
+var (
+	// ValidModules defines the modules that support a specific log level
+	ValidModules = []string{ModuleSFTPD, ModuleFTPD, ModuleWebDAVD, ModuleHTTPD, ModuleDataProvider}
+	// maps the senders, including the protocols used as sender for the connection
+	// logs, to the module they belong to
+	senderModules = map[string]string{
+		"sftpd":        ModuleSFTPD,
+		"SFTP":         ModuleSFTPD,
+		"SCP":          ModuleSFTPD,
+		"SSH":          ModuleSFTPD,
+		"SSHCommand":   ModuleSFTPD,
+		"ftpd":         ModuleFTPD,
+		"FTP":          ModuleFTPD,
+		"webdavd":      ModuleWebDAVD,
+		"DAV":          ModuleWebDAVD,
+		"httpd":        ModuleHTTPD,
+		"HTTP":         ModuleHTTPD,
+		"dataProvider": ModuleDataProvider,
+	}
+	// map[string]zerolog.Level, the modules without a specific level use the global one
+	moduleLevels atomic.Value
+	// 1 if at least one module has a specific log level
+	hasModuleLevels int32
+	validLevels     = map[string]zerolog.Level{
+		"debug": zerolog.DebugLevel,
+		"info":  zerolog.InfoLevel,
+		"warn":  zerolog.WarnLevel,
+		"error": zerolog.ErrorLevel,
+	}
+)
+
+// ParseLevel returns the log level for the specified name: debug, info, warn or error
+func ParseLevel(name string) (zerolog.Level, error) {
+	level, ok := validLevels[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return zerolog.NoLevel, fmt.Errorf("invalid log level %#v", name)
+	}
+	return level, nil
+}
+
+// ParseModuleLevels parses a comma separated list of module=level pairs,
+// for example "sftpd=debug,dataprovider=warn"
+func ParseModuleLevels(s string) (map[string]zerolog.Level, error) {
+	result := make(map[string]zerolog.Level)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid module log level %#v, the format is module=level", item)
+		}
+		module := strings.ToLower(strings.TrimSpace(parts[0]))
+		if !isValidModule(module) {
+			return nil, fmt.Errorf("invalid module %#v, valid modules: %v", module, strings.Join(ValidModules, ", "))
+		}
+		level, err := ParseLevel(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		result[module] = level
+	}
+	return result, nil
+}
+
+// SetModuleLevels sets the log level for the specified modules at runtime,
+// the modules not included use the global log level
+func SetModuleLevels(levels map[string]zerolog.Level) {
+	m := make(map[string]zerolog.Level, len(levels))
+	for module, level := range levels {
+		m[module] = level
+	}
+	moduleLevels.Store(m)
+	if len(m) > 0 {
+		atomic.StoreInt32(&hasModuleLevels, 1)
+	} else {
+		atomic.StoreInt32(&hasModuleLevels, 0)
+	}
+}
+
+// GetModuleLevels returns the modules with a specific log level
+func GetModuleLevels() map[string]zerolog.Level {
+	result := make(map[string]zerolog.Level)
+	if m, ok := moduleLevels.Load().(map[string]zerolog.Level); ok {
+		for module, level := range m {
+			result[module] = level
+		}
+	}
+	return result
+}
+
+// GetModuleLevelsAsString returns the modules with a specific log level in
+// the same format accepted by ParseModuleLevels
+func GetModuleLevelsAsString() string {
+	levels := GetModuleLevels()
+	result := make([]string, 0, len(levels))
+	for module, level := range levels {
+		result = append(result, fmt.Sprintf("%v=%v", module, level))
+	}
+	sort.Strings(result)
+	return strings.Join(result, ",")
+}
+
+func isValidModule(module string) bool {
+	for _, m := range ValidModules {
+		if m == module {
+			return true
+		}
+	}
+	return false
+}
+
+func getSenderModule(sender string) string {
+	if module, ok := senderModules[sender]; ok {
+		return module
+	}
+	if strings.HasPrefix(sender, "dataprovider_") {
+		return ModuleDataProvider
+	}
+	return ""
+}
+
+// getSenderLevel returns the log level for the module the sender belongs to,
+// if it has a specific one
+func getSenderLevel(sender string) (zerolog.Level, bool) {
+	if atomic.LoadInt32(&hasModuleLevels) == 0 {
+		return zerolog.NoLevel, false
+	}
+	module := getSenderModule(sender)
+	if module == "" {
+		return zerolog.NoLevel, false
+	}
+	m, ok := moduleLevels.Load().(map[string]zerolog.Level)
+	if !ok {
+		return zerolog.NoLevel, false
+	}
+	level, ok := m[module]
+	return level, ok
+}
+
+// getSenderLogger returns the logger to use for an event at the specified level
+// or nil if the level is not enabled for the sender
+func getSenderLogger(level zerolog.Level, sender string) *zerolog.Logger {
+	if moduleLevel, ok := getSenderLevel(sender); ok {
+		if level < moduleLevel {
+			return nil
+		}
+		// the module level could be lower than the global one
+		return &moduleLogger
+	}
+	if !isLevelEnabled(level) {
+		return nil
+	}
+	return &logger
+}
@@ -0,0 +1,32 @@
+package logger
+
+import (
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+var shipper atomic.Value
+
+// LogShipper defines the interface to send the log events to an external system
+// in addition to the configured log output
+type LogShipper interface {
+	// Ship receives a JSON encoded log event. It must not block and it must copy
+	// the event if it is retained after returning
+	Ship(level zerolog.Level, event []byte)
+}
+
+type shipperHolder struct {
+	shipper LogShipper
+}
+
+// SetLogShipper sets the log shipper, nil disables log shipping
+func SetLogShipper(s LogShipper) {
+	shipper.Store(shipperHolder{shipper: s})
+}
+
+func shipEvent(level zerolog.Level, p []byte) {
+	if h, ok := shipper.Load().(shipperHolder); ok && h.shipper != nil {
+		h.shipper.Ship(level, p)
+	}
+}
@@ -0,0 +1,232 @@
+package logger
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	syslogTimestampFormat = "2006-01-02T15:04:05.000000Z07:00"
+	syslogDialTimeout     = 10 * time.Second
+	syslogWriteTimeout    = 10 * time.Second
+	// DefaultSyslogFacility defines the syslog facility to use if none is configured
+	DefaultSyslogFacility = "daemon"
+	// DefaultSyslogTag defines the syslog tag to use if none is configured
+	DefaultSyslogTag = "sftpgo"
+)
+
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// SyslogConfig defines the configuration to send the logs to a syslog server
+type SyslogConfig struct {
+	// URL for the syslog server. The scheme defines the transport, the supported
+	// schemes are "udp", "tcp" and "tls", for example "udp://127.0.0.1:514"
+	URL string
+	// Facility name, for example "daemon" or "local0". Empty means "daemon"
+	Facility string
+	// Tag is used as APP-NAME in the syslog messages. Empty means "sftpgo"
+	Tag string
+}
+
+// syslogWriter sends the log events to a syslog server using the RFC 5424 format.
+// For TCP and TLS the messages are framed using the octet counting method
+// defined in RFC 6587, for UDP each message is sent within a single datagram
+type syslogWriter struct {
+	sync.Mutex
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	facility  int
+	tag       string
+	hostname  string
+	pid       int
+	conn      net.Conn
+}
+
+func newSyslogWriter(config SyslogConfig) (*syslogWriter, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog URL %#v: %w", config.URL, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid syslog URL %#v: the host is required", config.URL)
+	}
+	w := &syslogWriter{
+		address: u.Host,
+		pid:     os.Getpid(),
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		w.network = u.Scheme
+	case "tls":
+		w.network = "tcp"
+		w.tlsConfig = &tls.Config{
+			ServerName: u.Hostname(),
+			MinVersion: tls.VersionTLS12,
+		}
+	default:
+		return nil, fmt.Errorf("unsupported syslog URL scheme %#v, supported schemes: udp, tcp, tls", u.Scheme)
+	}
+	facility := strings.ToLower(strings.TrimSpace(config.Facility))
+	if facility == "" {
+		facility = DefaultSyslogFacility
+	}
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("invalid syslog facility %#v", config.Facility)
+	}
+	w.facility = code
+	w.tag = getSyslogField(config.Tag, DefaultSyslogTag, 48)
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = ""
+	}
+	w.hostname = getSyslogField(hostname, "-", 255)
+	return w, nil
+}
+
+// getSyslogField returns a valid RFC 5424 header field, only printable
+// US-ASCII characters, except space, are allowed
+func getSyslogField(value, defaultValue string, maxLength int) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, value)
+	if len(value) > maxLength {
+		value = value[:maxLength]
+	}
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func getSyslogSeverity(level zerolog.Level) int {
+	switch level {
+	case zerolog.TraceLevel, zerolog.DebugLevel:
+		return 7
+	case zerolog.WarnLevel:
+		return 4
+	case zerolog.ErrorLevel:
+		return 3
+	case zerolog.FatalLevel:
+		return 2
+	case zerolog.PanicLevel:
+		return 0
+	default:
+		return 6
+	}
+}
+
+func (w *syslogWriter) connect() error {
+	dialer := &net.Dialer{Timeout: syslogDialTimeout}
+	var conn net.Conn
+	var err error
+	if w.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, w.network, w.address, w.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(w.network, w.address)
+	}
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	return nil
+}
+
+func (w *syslogWriter) format(level zerolog.Level, p []byte) []byte {
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d - - %s", w.facility*8+getSyslogSeverity(level),
+		time.Now().Format(syslogTimestampFormat), w.hostname, w.tag, w.pid, bytes.TrimRight(p, "\n"))
+	if w.network == "udp" {
+		return []byte(msg)
+	}
+	return []byte(fmt.Sprintf("%d %s", len(msg), msg))
+}
+
+func (w *syslogWriter) send(msg []byte) error {
+	if w.conn == nil {
+		if err := w.connect(); err != nil {
+			return err
+		}
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout)) //nolint:errcheck
+	_, err := w.conn.Write(msg)
+	if err != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+	return err
+}
+
+// Write implements the io.Writer interface
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+// WriteLevel implements zerolog.LevelWriter. The connection is
+// established again, and the message resent, if the write fails
+func (w *syslogWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	msg := w.format(level, p)
+
+	w.Lock()
+	defer w.Unlock()
+
+	if err := w.send(msg); err != nil {
+		if err := w.send(msg); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// InitSyslogLogger configures the logger to send the logs to the specified syslog server.
+// An error is returned if the configuration is not valid or the server is not reachable
+func InitSyslogLogger(config SyslogConfig, level zerolog.Level) error {
+	w, err := newSyslogWriter(config)
+	if err != nil {
+		return err
+	}
+	w.Lock()
+	err = w.connect()
+	w.Unlock()
+	if err != nil {
+		return fmt.Errorf("unable to connect to the syslog server %#v: %w", config.URL, err)
+	}
+	zerolog.TimeFieldFormat = dateFormat
+	setLoggers(w, level)
+	consoleLogger = zerolog.Nop()
+	rollingLogger = nil
+	return nil
+}
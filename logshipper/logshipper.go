@@ -0,0 +1,352 @@
+// Package logshipper batches the structured log events and ships them to
+// Elasticsearch or Loki over HTTP, so small deployments don't need a separate
+// log shipper
+package logshipper
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/rs/zerolog"
+
+	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	logSender = "logshipper"
+	// TypeElasticsearch ships the log events using the Elasticsearch bulk API
+	TypeElasticsearch = "elasticsearch"
+	// TypeLoki ships the log events using the Loki push API
+	TypeLoki = "loki"
+
+	defaultIndex         = "sftpgo"
+	defaultBatchSize     = 500
+	defaultFlushInterval = 5
+	defaultQueueSize     = 10000
+	stopTimeout          = 10 * time.Second
+)
+
+var (
+	validTypes = []string{TypeElasticsearch, TypeLoki}
+	current    *shipper
+	mu         sync.Mutex
+)
+
+// Config defines the configuration to ship the log events to Elasticsearch or Loki
+type Config struct {
+	// Destination type, "elasticsearch" or "loki". Empty means disabled
+	Type string `json:"type" mapstructure:"type"`
+	// Base URL for the destination, for example "http://127.0.0.1:9200" for
+	// Elasticsearch or "http://127.0.0.1:3100" for Loki
+	URL string `json:"url" mapstructure:"url"`
+	// Elasticsearch index. Empty means "sftpgo"
+	Index string `json:"index" mapstructure:"index"`
+	// Static labels added to the Loki streams, the "level" label is always added.
+	// Empty means {"job": "sftpgo"}
+	Labels map[string]string `json:"labels" mapstructure:"labels"`
+	// Credentials for basic authentication, leave empty to disable
+	Username string `json:"username" mapstructure:"username"`
+	Password string `json:"password" mapstructure:"password"`
+	// Maximum number of log events to send within a single request. 0 means 500
+	BatchSize int `json:"batch_size" mapstructure:"batch_size"`
+	// Maximum time, as seconds, to wait before sending the pending log events. 0 means 5
+	FlushInterval int `json:"flush_interval" mapstructure:"flush_interval"`
+	// Maximum number of log events waiting to be sent. The new log events are
+	// discarded if the queue is full. 0 means 10000
+	QueueSize int `json:"queue_size" mapstructure:"queue_size"`
+}
+
+func (c *Config) isEnabled() bool {
+	return c.Type != ""
+}
+
+func (c *Config) validate() error {
+	if !c.isEnabled() {
+		return nil
+	}
+	if !utils.IsStringInSlice(c.Type, validTypes) {
+		return fmt.Errorf("invalid type %#v, valid types: %v", c.Type, strings.Join(validTypes, ", "))
+	}
+	if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+		return fmt.Errorf("invalid URL %#v: an http or https URL is required", c.URL)
+	}
+	if c.BatchSize < 0 || c.FlushInterval < 0 || c.QueueSize < 0 {
+		return errors.New("batch size, flush interval and queue size cannot be negative")
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = defaultBatchSize
+	}
+	if c.FlushInterval == 0 {
+		c.FlushInterval = defaultFlushInterval
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = defaultQueueSize
+	}
+	if c.Index == "" {
+		c.Index = defaultIndex
+	}
+	if len(c.Labels) == 0 {
+		c.Labels = map[string]string{"job": "sftpgo"}
+	}
+	return nil
+}
+
+// Initialize validates the configuration and starts shipping the log events.
+// A previously started log shipper is stopped
+func (c *Config) Initialize() error {
+	config := *c
+	if err := config.validate(); err != nil {
+		return fmt.Errorf("logshipper: %w", err)
+	}
+	Stop()
+	if !config.isEnabled() {
+		return nil
+	}
+	s := newShipper(config)
+	mu.Lock()
+	current = s
+	mu.Unlock()
+	logger.SetLogShipper(s)
+	go s.run()
+	logger.Info(logSender, "", "log shipping to %v enabled, URL: %#v", config.Type, config.URL)
+	return nil
+}
+
+// Stop stops shipping the log events, the pending ones are sent before returning
+func Stop() {
+	mu.Lock()
+	s := current
+	current = nil
+	mu.Unlock()
+
+	if s == nil {
+		return
+	}
+	logger.SetLogShipper(nil)
+	s.stop()
+}
+
+type logEvent struct {
+	level zerolog.Level
+	time  time.Time
+	data  []byte
+}
+
+type shipper struct {
+	config  Config
+	client  *retryablehttp.Client
+	events  chan logEvent
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newShipper(config Config) *shipper {
+	client := httpclient.GetRetraybleHTTPClient()
+	// the retryable client logs each request, these logs must not be shipped again
+	client.Logger = nil
+	return &shipper{
+		config:  config,
+		client:  client,
+		events:  make(chan logEvent, config.QueueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Ship implements logger.LogShipper. The event is discarded if the queue is full
+func (s *shipper) Ship(level zerolog.Level, event []byte) {
+	data := bytes.TrimRight(event, "\n")
+	ev := logEvent{
+		level: level,
+		time:  time.Now(),
+		data:  make([]byte, len(data)),
+	}
+	copy(ev.data, data)
+	select {
+	case s.events <- ev:
+	default:
+	}
+}
+
+func (s *shipper) run() {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(time.Duration(s.config.FlushInterval) * time.Second)
+	defer ticker.Stop()
+
+	batch := make([]logEvent, 0, s.config.BatchSize)
+	for {
+		select {
+		case ev := <-s.events:
+			batch = append(batch, ev)
+			if len(batch) >= s.config.BatchSize {
+				s.send(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				s.send(batch)
+				batch = batch[:0]
+			}
+		case <-s.done:
+			for {
+				select {
+				case ev := <-s.events:
+					batch = append(batch, ev)
+					if len(batch) >= s.config.BatchSize {
+						s.send(batch)
+						batch = batch[:0]
+					}
+				default:
+					if len(batch) > 0 {
+						s.send(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *shipper) stop() {
+	close(s.done)
+	select {
+	case <-s.stopped:
+	case <-time.After(stopTimeout):
+	}
+}
+
+func (s *shipper) send(batch []logEvent) {
+	var err error
+	switch s.config.Type {
+	case TypeElasticsearch:
+		err = s.sendToElasticsearch(batch)
+	default:
+		err = s.sendToLoki(batch)
+	}
+	if err != nil {
+		logger.Warn(logSender, "", "unable to ship %v log events to %v: %v", len(batch), s.config.Type, err)
+	}
+}
+
+func (s *shipper) sendToElasticsearch(batch []logEvent) error {
+	var buf bytes.Buffer
+	action, err := json.Marshal(map[string]interface{}{
+		"index": map[string]string{
+			"_index": s.config.Index,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, ev := range batch {
+		buf.Write(action)
+		buf.WriteByte('\n')
+		buf.Write(getElasticsearchDocument(ev))
+		buf.WriteByte('\n')
+	}
+	body, err := s.post(strings.TrimRight(s.config.URL, "/")+"/_bulk", "application/x-ndjson", &buf)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Errors bool `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("unable to decode the bulk response: %w", err)
+	}
+	if resp.Errors {
+		return errors.New("some log events were rejected")
+	}
+	return nil
+}
+
+// getElasticsearchDocument adds an "@timestamp" field, with time zone information,
+// to the log event
+func getElasticsearchDocument(ev logEvent) []byte {
+	if len(ev.data) < 2 || ev.data[0] != '{' {
+		return ev.data
+	}
+	timestamp := ev.time.UTC().Format(time.RFC3339Nano)
+	doc := make([]byte, 0, len(ev.data)+len(timestamp)+16)
+	doc = append(doc, `{"@timestamp":"`...)
+	doc = append(doc, timestamp...)
+	doc = append(doc, '"')
+	if ev.data[1] != '}' {
+		doc = append(doc, ',')
+	}
+	return append(doc, ev.data[1:]...)
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (s *shipper) sendToLoki(batch []logEvent) error {
+	streams := make(map[zerolog.Level]*lokiStream)
+	var levels []zerolog.Level
+	for _, ev := range batch {
+		stream, ok := streams[ev.level]
+		if !ok {
+			labels := make(map[string]string, len(s.config.Labels)+1)
+			for k, v := range s.config.Labels {
+				labels[k] = v
+			}
+			labels["level"] = ev.level.String()
+			stream = &lokiStream{Stream: labels}
+			streams[ev.level] = stream
+			levels = append(levels, ev.level)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(ev.time.UnixNano(), 10), string(ev.data)})
+	}
+	req := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, level := range levels {
+		req.Streams = append(req.Streams, streams[level])
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = s.post(strings.TrimRight(s.config.URL, "/")+"/loki/api/v1/push", "application/json", bytes.NewReader(data))
+	return err
+}
+
+func (s *shipper) post(url, contentType string, body io.Reader) ([]byte, error) {
+	req, err := retryablehttp.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.config.Username != "" || s.config.Password != "" {
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1048576))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("unexpected status code: %v", resp.StatusCode)
+	}
+	return respBody, nil
+}
@@ -0,0 +1,200 @@
+package logshipper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+func TestMain(m *testing.M) {
+	logFilePath := filepath.Join(os.TempDir(), "sftpgo_logshipper_test.log")
+	logger.InitLogger(logFilePath, 5, 1, 28, false, zerolog.DebugLevel)
+	exitCode := m.Run()
+	os.Remove(logFilePath)
+	os.Exit(exitCode)
+}
+
+type receivedRequests struct {
+	sync.Mutex
+	paths  []string
+	bodies [][]byte
+	users  []string
+}
+
+func (r *receivedRequests) handler(respBody string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		user, _, _ := req.BasicAuth()
+		r.Lock()
+		r.paths = append(r.paths, req.URL.Path)
+		r.bodies = append(r.bodies, body)
+		r.users = append(r.users, user)
+		r.Unlock()
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(respBody)) //nolint:errcheck
+	}
+}
+
+func (r *receivedRequests) count() int {
+	r.Lock()
+	defer r.Unlock()
+
+	return len(r.bodies)
+}
+
+func TestConfigValidation(t *testing.T) {
+	c := Config{}
+	assert.NoError(t, c.validate())
+	assert.NoError(t, c.Initialize())
+	c.Type = "splunk"
+	assert.Error(t, c.validate())
+	c.Type = TypeLoki
+	c.URL = "ftp://127.0.0.1"
+	assert.Error(t, c.validate())
+	c.URL = "http://127.0.0.1:3100"
+	c.QueueSize = -1
+	assert.Error(t, c.Initialize())
+	c.QueueSize = 0
+	assert.NoError(t, c.validate())
+	assert.Equal(t, defaultBatchSize, c.BatchSize)
+	assert.Equal(t, defaultFlushInterval, c.FlushInterval)
+	assert.Equal(t, defaultQueueSize, c.QueueSize)
+	assert.Equal(t, defaultIndex, c.Index)
+	assert.Equal(t, "sftpgo", c.Labels["job"])
+}
+
+func TestElasticsearchDocument(t *testing.T) {
+	now := time.Now()
+	doc := getElasticsearchDocument(logEvent{time: now, data: []byte(`{"level":"info","message":"test"}`)})
+	var m map[string]interface{}
+	err := json.Unmarshal(doc, &m)
+	require.NoError(t, err)
+	assert.Equal(t, "info", m["level"])
+	assert.Equal(t, now.UTC().Format(time.RFC3339Nano), m["@timestamp"])
+
+	doc = getElasticsearchDocument(logEvent{time: now, data: []byte(`{}`)})
+	err = json.Unmarshal(doc, &m)
+	assert.NoError(t, err)
+
+	doc = getElasticsearchDocument(logEvent{time: now, data: []byte(`invalid`)})
+	assert.Equal(t, []byte(`invalid`), doc)
+}
+
+func TestShipToElasticsearch(t *testing.T) {
+	requests := &receivedRequests{}
+	server := httptest.NewServer(requests.handler(`{"errors":false}`))
+	defer server.Close()
+
+	c := Config{
+		Type:      TypeElasticsearch,
+		URL:       server.URL + "/",
+		Index:     "logs",
+		Username:  "user",
+		Password:  "pwd",
+		BatchSize: 2,
+	}
+	err := c.Initialize()
+	require.NoError(t, err)
+	// the log shipper logs its initialization
+	logger.Info("test", "", "first event")
+	assert.Eventually(t, func() bool {
+		return requests.count() > 0
+	}, 2*time.Second, 50*time.Millisecond)
+	Stop()
+
+	requests.Lock()
+	defer requests.Unlock()
+
+	assert.Equal(t, "/_bulk", requests.paths[0])
+	assert.Equal(t, "user", requests.users[0])
+	scanner := bufio.NewScanner(bytes.NewReader(requests.bodies[0]))
+	var lines []map[string]interface{}
+	for scanner.Scan() {
+		var m map[string]interface{}
+		err = json.Unmarshal(scanner.Bytes(), &m)
+		require.NoError(t, err)
+		lines = append(lines, m)
+	}
+	require.Len(t, lines, 4)
+	assert.Contains(t, lines[0], "index")
+	assert.Equal(t, logSender, lines[1]["sender"])
+	assert.Contains(t, lines[1], "@timestamp")
+	assert.Equal(t, "first event", lines[3]["message"])
+}
+
+func TestShipToLoki(t *testing.T) {
+	requests := &receivedRequests{}
+	server := httptest.NewServer(requests.handler(""))
+	defer server.Close()
+
+	s := newShipper(Config{
+		Type:          TypeLoki,
+		URL:           server.URL,
+		Labels:        map[string]string{"job": "test"},
+		BatchSize:     10,
+		FlushInterval: 60,
+		QueueSize:     2,
+	})
+	go s.run()
+	s.Ship(zerolog.InfoLevel, []byte(`{"message":"info"}`+"\n"))
+	s.Ship(zerolog.ErrorLevel, []byte(`{"message":"error"}`))
+	// the queue is full, this event could be discarded
+	s.Ship(zerolog.InfoLevel, []byte(`{"message":"info1"}`))
+	// the pending events are sent on stop
+	s.stop()
+
+	requests.Lock()
+	defer requests.Unlock()
+
+	require.GreaterOrEqual(t, len(requests.bodies), 1)
+	assert.Equal(t, "/loki/api/v1/push", requests.paths[0])
+	var req struct {
+		Streams []lokiStream `json:"streams"`
+	}
+	err := json.Unmarshal(requests.bodies[0], &req)
+	require.NoError(t, err)
+	require.Len(t, req.Streams, 2)
+	assert.Equal(t, "test", req.Streams[0].Stream["job"])
+	assert.Equal(t, "info", req.Streams[0].Stream["level"])
+	assert.Equal(t, `{"message":"info"}`, req.Streams[0].Values[0][1])
+	assert.Equal(t, "error", req.Streams[1].Stream["level"])
+	assert.Len(t, req.Streams[1].Values, 1)
+	assert.Empty(t, requests.users[0])
+}
+
+func TestSendErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_bulk" {
+			w.Write([]byte(`{"errors":true}`)) //nolint:errcheck
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	s := newShipper(Config{
+		Type:  TypeElasticsearch,
+		URL:   server.URL,
+		Index: defaultIndex,
+	})
+	s.client.RetryMax = 0
+	batch := []logEvent{{level: zerolog.InfoLevel, time: time.Now(), data: []byte(`{}`)}}
+	assert.Error(t, s.sendToElasticsearch(batch))
+	assert.Error(t, s.sendToLoki(batch))
+	s.config.URL = "http://127.0.0.1:1"
+	assert.Error(t, s.sendToElasticsearch(batch))
+}
@@ -4,6 +4,8 @@
 package metrics
 
 import (
+	"time"
+
 	"github.com/go-chi/chi"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -521,6 +523,30 @@ var (
 		Name: "sftpgo_az_head_container_errors",
 		Help: "The total number of Azure head container errors",
 	})
+
+	// hookDuration is the metric that reports the execution time for the external hooks
+	hookDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "sftpgo_hook_duration_seconds",
+		Help: "The execution time for the external hooks",
+	}, []string{"hook"})
+
+	// totalHookErrors is the metric that reports the total external hook errors
+	totalHookErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_hook_errors",
+		Help: "The total number of external hook errors",
+	}, []string{"hook"})
+
+	// providerOperationDuration is the metric that reports the execution time for the data provider operations
+	providerOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "sftpgo_dataprovider_operation_duration_seconds",
+		Help: "The execution time for the data provider operations",
+	}, []string{"operation"})
+
+	// totalProviderOperationErrors is the metric that reports the total data provider operation errors
+	totalProviderOperationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_dataprovider_operation_errors",
+		Help: "The total number of data provider operation errors",
+	}, []string{"operation"})
 )
 
 // AddMetricsEndpoint exposes metrics to the specified endpoint
@@ -765,6 +791,22 @@ func UpdateDataProviderAvailability(err error) {
 	}
 }
 
+// HookExecuted updates the metrics after an external hook terminates
+func HookExecuted(hook string, elapsed time.Duration, err error) {
+	hookDuration.WithLabelValues(hook).Observe(elapsed.Seconds())
+	if err != nil {
+		totalHookErrors.WithLabelValues(hook).Inc()
+	}
+}
+
+// ProviderOperationCompleted updates the metrics after a data provider operation terminates
+func ProviderOperationCompleted(operation string, elapsed time.Duration, err error) {
+	providerOperationDuration.WithLabelValues(operation).Observe(elapsed.Seconds())
+	if err != nil {
+		totalProviderOperationErrors.WithLabelValues(operation).Inc()
+	}
+}
+
 // AddLoginAttempt increments the metrics for login attempts
 func AddLoginAttempt(authMethod string) {
 	totalLoginAttempts.Inc()
@@ -3,6 +3,8 @@
 package metrics
 
 import (
+	"time"
+
 	"github.com/go-chi/chi"
 
 	"github.com/drakkan/sftpgo/version"
@@ -54,6 +56,12 @@ func SSHCommandCompleted(err error) {}
 // UpdateDataProviderAvailability updates the metric for the data provider availability
 func UpdateDataProviderAvailability(err error) {}
 
+// HookExecuted updates the metrics after an external hook terminates
+func HookExecuted(hook string, elapsed time.Duration, err error) {}
+
+// ProviderOperationCompleted updates the metrics after a data provider operation terminates
+func ProviderOperationCompleted(operation string, elapsed time.Duration, err error) {}
+
 // AddLoginAttempt increments the metrics for login attempts
 func AddLoginAttempt(authMethod string) {}
 
@@ -0,0 +1,175 @@
+// Package notifier sends the service notifications, such as the service start,
+// the configuration errors and the fatal errors, to an HTTP webhook and/or by email
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/smtp"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/version"
+)
+
+const (
+	logSender = "notifier"
+)
+
+// Supported notification events
+const (
+	// EventStart is notified when the service starts
+	EventStart = "start"
+	// EventConfigError is notified if the configuration cannot be validated
+	EventConfigError = "config_error"
+	// EventFatalError is notified for fatal errors, for example a service that
+	// cannot be started or a data provider that becomes unreachable
+	EventFatalError = "fatal_error"
+)
+
+var (
+	validEvents = []string{EventStart, EventConfigError, EventFatalError}
+	current     Config
+	mu          sync.RWMutex
+)
+
+// Config defines the configuration for the service notifications
+type Config struct {
+	// HTTP URL, the notifications are sent as JSON using a POST request.
+	// Leave empty to disable
+	Hook string `json:"hook" mapstructure:"hook"`
+	// Email recipients, an SMTP server must be configured. Leave empty to disable
+	EmailRecipients []string `json:"email_recipients" mapstructure:"email_recipients"`
+	// Events to notify, empty means all the supported events
+	Events []string `json:"events" mapstructure:"events"`
+}
+
+// Notification defines the notification sent to the webhook
+type Notification struct {
+	Event     string `json:"event"`
+	Timestamp string `json:"timestamp"`
+	Hostname  string `json:"hostname"`
+	Version   string `json:"version"`
+	Message   string `json:"message"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (c *Config) isEnabled() bool {
+	return c.Hook != "" || len(c.EmailRecipients) > 0
+}
+
+func (c *Config) validate() error {
+	if c.Hook != "" {
+		u, err := url.Parse(c.Hook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid hook %#v: an http or https URL is required", c.Hook)
+		}
+	}
+	if len(c.EmailRecipients) > 0 && !smtp.IsEnabled() {
+		return errors.New("in order to send notifications via email you must configure an SMTP server")
+	}
+	for _, event := range c.Events {
+		if !utils.IsStringInSlice(event, validEvents) {
+			return fmt.Errorf("invalid event %#v, valid events: %v", event, strings.Join(validEvents, ", "))
+		}
+	}
+	return nil
+}
+
+func (c *Config) isEventEnabled(event string) bool {
+	if !c.isEnabled() {
+		return false
+	}
+	return len(c.Events) == 0 || utils.IsStringInSlice(event, c.Events)
+}
+
+// Initialize validates the configuration and enables the notifications.
+// The SMTP configuration must be initialized before calling this method
+func (c *Config) Initialize() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	current = Config{}
+	if err := c.validate(); err != nil {
+		return fmt.Errorf("notifier: %w", err)
+	}
+	current = *c
+	if current.isEnabled() {
+		logger.Debug(logSender, "", "service notifications enabled, hook: %#v, email recipients: %v, events: %v",
+			current.Hook, current.EmailRecipients, current.Events)
+	}
+	return nil
+}
+
+// Notify sends a notification for the specified event, if enabled.
+// It blocks until the notification is sent
+func Notify(event, message string, err error) {
+	mu.RLock()
+	config := current
+	mu.RUnlock()
+
+	if !config.isEventEnabled(event) {
+		return
+	}
+	hostname, _ := os.Hostname()
+	notification := Notification{
+		Event:     event,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Hostname:  hostname,
+		Version:   version.GetAsString(),
+		Message:   message,
+	}
+	if err != nil {
+		notification.Error = err.Error()
+	}
+	if config.Hook != "" {
+		if errHook := sendToHook(config.Hook, &notification); errHook != nil {
+			logger.Warn(logSender, "", "unable to notify event %#v to hook %#v: %v", event, config.Hook, errHook)
+		}
+	}
+	if len(config.EmailRecipients) > 0 {
+		if errEmail := sendEmail(config.EmailRecipients, &notification); errEmail != nil {
+			logger.Warn(logSender, "", "unable to notify event %#v via email: %v", event, errEmail)
+		}
+	}
+}
+
+func sendToHook(hook string, notification *Notification) error {
+	startTime := time.Now()
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(notification); err != nil {
+		return err
+	}
+	resp, err := httpclient.GetRetraybleHTTPClient().Post(hook, "application/json", &b)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	logger.Debug(logSender, "", "event %#v notified to hook %#v, status code: %v, elapsed: %v", notification.Event,
+		hook, resp.StatusCode, time.Since(startTime))
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status code: %v", resp.StatusCode)
+	}
+	return nil
+}
+
+func sendEmail(recipients []string, notification *Notification) error {
+	subject := fmt.Sprintf("SFTPGo %v notification from %#v", notification.Event, notification.Hostname)
+	var body strings.Builder
+	fmt.Fprintf(&body, "Event: %v\nTime: %v\nHostname: %v\nVersion: %v\nMessage: %v\n", notification.Event,
+		notification.Timestamp, notification.Hostname, notification.Version, notification.Message)
+	if notification.Error != "" {
+		fmt.Fprintf(&body, "Error: %v\n", notification.Error)
+	}
+	return smtp.SendEmail(recipients, subject, body.String(), smtp.EmailContentTypeTextPlain)
+}
@@ -0,0 +1,106 @@
+package notifier
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/smtp"
+)
+
+func TestMain(m *testing.M) {
+	logFilePath := filepath.Join(os.TempDir(), "sftpgo_notifier_test.log")
+	logger.InitLogger(logFilePath, 5, 1, 28, false, zerolog.DebugLevel)
+	exitCode := m.Run()
+	os.Remove(logFilePath)
+	os.Exit(exitCode)
+}
+
+func TestConfigValidation(t *testing.T) {
+	c := Config{}
+	assert.NoError(t, c.Initialize())
+	assert.False(t, current.isEventEnabled(EventStart))
+
+	c.Hook = "ftp://127.0.0.1/hook"
+	assert.Error(t, c.Initialize())
+	c.Hook = "http://127.0.0.1:8080/hook"
+	c.Events = []string{EventStart, "unknown"}
+	assert.Error(t, c.Initialize())
+	c.Events = []string{EventFatalError}
+	assert.NoError(t, c.Initialize())
+	assert.False(t, current.isEventEnabled(EventStart))
+	assert.True(t, current.isEventEnabled(EventFatalError))
+	c.Events = nil
+	assert.NoError(t, c.Initialize())
+	assert.True(t, current.isEventEnabled(EventStart))
+	assert.True(t, current.isEventEnabled(EventConfigError))
+
+	smtpCfg := smtp.Config{}
+	require.NoError(t, smtpCfg.Initialize())
+	c.EmailRecipients = []string{"admin@example.com"}
+	err := c.Initialize()
+	assert.Error(t, err)
+	// an invalid configuration disables the notifications
+	assert.False(t, current.isEventEnabled(EventFatalError))
+}
+
+func TestNotifyHook(t *testing.T) {
+	var mu sync.Mutex
+	var received []Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n Notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, n)
+		mu.Unlock()
+		if n.Event == EventConfigError {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := Config{
+		Hook:   server.URL,
+		Events: []string{EventStart, EventConfigError},
+	}
+	require.NoError(t, c.Initialize())
+	Notify(EventStart, "started", nil)
+	Notify(EventFatalError, "fatal", errors.New("fatal error"))
+	Notify(EventConfigError, "config", errors.New("config error"))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, received, 2)
+	assert.Equal(t, EventStart, received[0].Event)
+	assert.Equal(t, "started", received[0].Message)
+	assert.Empty(t, received[0].Error)
+	assert.NotEmpty(t, received[0].Timestamp)
+	assert.NotEmpty(t, received[0].Version)
+	assert.Equal(t, EventConfigError, received[1].Event)
+	assert.Equal(t, "config error", received[1].Error)
+
+	c = Config{}
+	require.NoError(t, c.Initialize())
+}
+
+func TestSendErrors(t *testing.T) {
+	n := &Notification{Event: EventStart}
+	assert.Error(t, sendToHook("http://127.0.0.1:1/hook", n))
+	assert.Error(t, sendEmail([]string{"admin@example.com"}, n))
+}
@@ -22,6 +22,7 @@ sed -i "s|sftpgo.db|/var/lib/sftpgo/sftpgo.db|" sftpgo.json
 sed -i "s|\"users_base_dir\": \"\",|\"users_base_dir\": \"/srv/sftpgo/data\",|" sftpgo.json
 sed -i "s|\"templates\"|\"/usr/share/sftpgo/templates\"|" sftpgo.json
 sed -i "s|\"static\"|\"/usr/share/sftpgo/static\"|" sftpgo.json
+sed -i "s|\"openapi\"|\"/usr/share/sftpgo/openapi\"|" sftpgo.json
 sed -i "s|\"backups\"|\"/srv/sftpgo/backups\"|" sftpgo.json
 sed -i "s|\"credentials\"|\"/var/lib/sftpgo/credentials\"|" sftpgo.json
 
@@ -66,6 +67,9 @@ contents:
   - src: "${BASE_DIR}/static/**/*"
     dst: "/usr/share/sftpgo/static/"
 
+  - src: "${BASE_DIR}/openapi/**/*"
+    dst: "/usr/share/sftpgo/openapi/"
+
   - src: "./sftpgo.json"
     dst: "/etc/sftpgo/sftpgo.json"
     type: "config|noreplace"
@@ -13,10 +13,17 @@ import (
 	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/config"
 	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/ftpd"
+	"github.com/drakkan/sftpgo/geoip"
 	"github.com/drakkan/sftpgo/httpd"
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/logshipper"
+	"github.com/drakkan/sftpgo/notifier"
+	"github.com/drakkan/sftpgo/sftpd"
+	"github.com/drakkan/sftpgo/telemetry"
 	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/version"
+	"github.com/drakkan/sftpgo/webdavd"
 )
 
 const (
@@ -39,6 +46,11 @@ type Service struct {
 	PortableUser      dataprovider.User
 	LogCompress       bool
 	LogVerbose        bool
+	LogSyslogURL      string
+	LogSyslogFacility string
+	LogSyslogTag      string
+	LogToJournalD     bool
+	LogModuleLevels   string
 	LoadDataClean     bool
 	LoadDataFrom      string
 	LoadDataMode      int
@@ -47,11 +59,27 @@ type Service struct {
 	Error             error
 }
 
-func (s *Service) initLogger() {
+func (s *Service) initLogger() error {
 	logLevel := zerolog.DebugLevel
 	if !s.LogVerbose {
 		logLevel = zerolog.InfoLevel
 	}
+	moduleLevels, err := logger.ParseModuleLevels(s.LogModuleLevels)
+	if err != nil {
+		return err
+	}
+	logger.SetModuleLevels(moduleLevels)
+	if s.LogToJournalD && s.PortableMode != 1 {
+		logger.InitJournalDLogger(logLevel)
+		return nil
+	}
+	if s.LogSyslogURL != "" && s.PortableMode != 1 {
+		return logger.InitSyslogLogger(logger.SyslogConfig{
+			URL:      s.LogSyslogURL,
+			Facility: s.LogSyslogFacility,
+			Tag:      s.LogSyslogTag,
+		}, logLevel)
+	}
 	if !filepath.IsAbs(s.LogFilePath) && utils.IsFileInputValid(s.LogFilePath) {
 		s.LogFilePath = filepath.Join(s.ConfigDir, s.LogFilePath)
 	}
@@ -62,14 +90,19 @@ func (s *Service) initLogger() {
 			logger.DisableLogger()
 		}
 	}
+	return nil
 }
 
 // Start initializes the service
 func (s *Service) Start() error {
-	s.initLogger()
+	if err := s.initLogger(); err != nil {
+		logger.ErrorToConsole("unable to initialize the logger: %v", err)
+		return err
+	}
 	logger.Info(logSender, "", "starting SFTPGo %v, config dir: %v, config file: %v, log max size: %v log max backups: %v "+
-		"log max age: %v log verbose: %v, log compress: %v, load data from: %#v", version.GetAsString(), s.ConfigDir, s.ConfigFile,
-		s.LogMaxSize, s.LogMaxBackups, s.LogMaxAge, s.LogVerbose, s.LogCompress, s.LoadDataFrom)
+		"log max age: %v log verbose: %v, log compress: %v, log syslog URL: %#v, log to journald: %v, log levels: %#v, load data from: %#v",
+		version.GetAsString(), s.ConfigDir, s.ConfigFile, s.LogMaxSize, s.LogMaxBackups, s.LogMaxAge, s.LogVerbose,
+		s.LogCompress, s.LogSyslogURL, s.LogToJournalD, logger.GetModuleLevelsAsString(), s.LoadDataFrom)
 	// in portable mode we don't read configuration from file
 	if s.PortableMode != 1 {
 		err := config.LoadConfig(s.ConfigDir, s.ConfigFile)
@@ -85,10 +118,46 @@ func (s *Service) Start() error {
 		return errors.New(infoString)
 	}
 
-	err := common.Initialize(config.GetCommonConfig())
+	// email actions require SMTP, so it must be initialized before the common configuration
+	smtpConfig := config.GetSMTPConfig()
+	err := smtpConfig.Initialize()
+	if err != nil {
+		logger.Error(logSender, "", "unable to initialize SMTP configuration: %v", err)
+		logger.ErrorToConsole("unable to initialize SMTP configuration: %v", err)
+		return err
+	}
+
+	// the HTTP client is used to send the notifications, so it must be initialized before them
+	httpConfig := config.GetHTTPConfig()
+	err = httpConfig.Initialize(s.ConfigDir)
+	if err != nil {
+		logger.Error(logSender, "", "error initializing http client: %v", err)
+		logger.ErrorToConsole("error initializing http client: %v", err)
+		return err
+	}
+
+	notifierConfig := config.GetNotifierConfig()
+	err = notifierConfig.Initialize()
+	if err != nil {
+		logger.Error(logSender, "", "unable to initialize notifications: %v", err)
+		logger.ErrorToConsole("unable to initialize notifications: %v", err)
+		return err
+	}
+
+	geoIPConfig := config.GetGeoIPConfig()
+	err = geoIPConfig.Initialize(s.ConfigDir)
+	if err != nil {
+		logger.Error(logSender, "", "unable to initialize GeoIP: %v", err)
+		logger.ErrorToConsole("unable to initialize GeoIP: %v", err)
+		notifier.Notify(notifier.EventConfigError, "unable to initialize GeoIP", err)
+		return err
+	}
+
+	err = common.Initialize(config.GetCommonConfig())
 	if err != nil {
 		logger.Error(logSender, "", "%v", err)
 		logger.ErrorToConsole("%v", err)
+		notifier.Notify(notifier.EventConfigError, "unable to initialize the common configuration", err)
 		os.Exit(1)
 	}
 	kmsConfig := config.GetKMSConfig()
@@ -96,18 +165,33 @@ func (s *Service) Start() error {
 	if err != nil {
 		logger.Error(logSender, "", "unable to initialize KMS: %v", err)
 		logger.ErrorToConsole("unable to initialize KMS: %v", err)
+		notifier.Notify(notifier.EventConfigError, "unable to initialize KMS", err)
 		os.Exit(1)
 	}
 
+	logShipperConfig := config.GetLogShipperConfig()
+	err = logShipperConfig.Initialize()
+	if err != nil {
+		logger.Error(logSender, "", "unable to initialize log shipping: %v", err)
+		logger.ErrorToConsole("unable to initialize log shipping: %v", err)
+		notifier.Notify(notifier.EventConfigError, "unable to initialize log shipping", err)
+		return err
+	}
+
 	providerConf := config.GetProviderConf()
 
 	err = dataprovider.Initialize(providerConf, s.ConfigDir, s.PortableMode == 0)
 	if err != nil {
 		logger.Error(logSender, "", "error initializing data provider: %v", err)
 		logger.ErrorToConsole("error initializing data provider: %v", err)
+		notifier.Notify(notifier.EventConfigError, "unable to initialize the data provider", err)
 		return err
 	}
 
+	if config.GetCommonConfig().Actions.Queue.Enabled {
+		common.StartActionsQueue()
+	}
+
 	if s.PortableMode == 1 {
 		// create the user for portable mode
 		err = dataprovider.AddUser(&s.PortableUser)
@@ -123,15 +207,10 @@ func (s *Service) Start() error {
 		logger.ErrorToConsole("unable to load initial data: %v", err)
 	}
 
-	httpConfig := config.GetHTTPConfig()
-	err = httpConfig.Initialize(s.ConfigDir)
-	if err != nil {
-		logger.Error(logSender, "", "error initializing http client: %v", err)
-		logger.ErrorToConsole("error initializing http client: %v", err)
-		return err
-	}
-
 	s.startServices()
+	if s.PortableMode != 1 {
+		go notifier.Notify(notifier.EventStart, fmt.Sprintf("SFTPGo started, config dir: %#v", s.ConfigDir), nil)
+	}
 
 	return nil
 }
@@ -149,6 +228,7 @@ func (s *Service) startServices() {
 			if err := sftpdConf.Initialize(s.ConfigDir); err != nil {
 				logger.Error(logSender, "", "could not start SFTP server: %v", err)
 				logger.ErrorToConsole("could not start SFTP server: %v", err)
+				notifier.Notify(notifier.EventFatalError, "could not start SFTP server", err)
 				s.Error = err
 			}
 			s.Shutdown <- true
@@ -162,6 +242,7 @@ func (s *Service) startServices() {
 			if err := httpdConf.Initialize(s.ConfigDir); err != nil {
 				logger.Error(logSender, "", "could not start HTTP server: %v", err)
 				logger.ErrorToConsole("could not start HTTP server: %v", err)
+				notifier.Notify(notifier.EventFatalError, "could not start HTTP server", err)
 				s.Error = err
 			}
 			s.Shutdown <- true
@@ -177,6 +258,7 @@ func (s *Service) startServices() {
 			if err := ftpdConf.Initialize(s.ConfigDir); err != nil {
 				logger.Error(logSender, "", "could not start FTP server: %v", err)
 				logger.ErrorToConsole("could not start FTP server: %v", err)
+				notifier.Notify(notifier.EventFatalError, "could not start FTP server", err)
 				s.Error = err
 			}
 			s.Shutdown <- true
@@ -189,6 +271,7 @@ func (s *Service) startServices() {
 			if err := webDavDConf.Initialize(s.ConfigDir); err != nil {
 				logger.Error(logSender, "", "could not start WebDAV server: %v", err)
 				logger.ErrorToConsole("could not start WebDAV server: %v", err)
+				notifier.Notify(notifier.EventFatalError, "could not start WebDAV server", err)
 				s.Error = err
 			}
 			s.Shutdown <- true
@@ -201,6 +284,7 @@ func (s *Service) startServices() {
 			if err := telemetryConf.Initialize(s.ConfigDir); err != nil {
 				logger.Error(logSender, "", "could not start telemetry server: %v", err)
 				logger.ErrorToConsole("could not start telemetry server: %v", err)
+				notifier.Notify(notifier.EventFatalError, "could not start telemetry server", err)
 				s.Error = err
 			}
 			s.Shutdown <- true
@@ -216,10 +300,63 @@ func (s *Service) startServices() {
 // Wait blocks until the service exits
 func (s *Service) Wait() {
 	if s.PortableMode != 1 {
-		registerSigHup()
+		registerSigHup(s)
 		registerSigUSR1()
 	}
+	registerSigTerm(s.Shutdown)
 	<-s.Shutdown
+	logshipper.Stop()
+}
+
+// reload applies the configuration settings that can be changed at runtime,
+// the existing sessions are not affected
+func (s *Service) reload() {
+	err := config.ReloadConfig(s.ConfigDir, s.ConfigFile)
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading configuration file, the hooks will not be updated: %v", err)
+	} else {
+		common.ReloadHooks(config.GetCommonConfig())
+		err = dataprovider.ReloadHooks(config.GetProviderConf())
+		if err != nil {
+			logger.Warn(logSender, "", "error reloading dataprovider hooks: %v", err)
+		}
+	}
+	err = dataprovider.ReloadConfig()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading dataprovider configuration: %v", err)
+	}
+	err = httpd.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading cert manager: %v", err)
+	}
+	err = ftpd.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading FTPD cert manager: %v", err)
+	}
+	err = webdavd.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading WebDAV cert manager: %v", err)
+	}
+	err = telemetry.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading telemetry cert manager: %v", err)
+	}
+	err = sftpd.ReloadHostKeys()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading SFTPD host keys: %v", err)
+	}
+	err = geoip.Reload()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading GeoIP database: %v", err)
+	}
+	err = common.ReloadDefender()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading defender's lists: %v", err)
+	}
+	err = common.ReloadIPLists()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading ip lists: %v", err)
+	}
 }
 
 // Stop terminates the service unblocking the Wait method
@@ -235,7 +372,7 @@ func (s *Service) loadInitialData() error {
 	if !filepath.IsAbs(s.LoadDataFrom) {
 		return fmt.Errorf("invalid input_file %#v, it must be an absolute path", s.LoadDataFrom)
 	}
-	if s.LoadDataMode < 0 || s.LoadDataMode > 1 {
+	if s.LoadDataMode < 0 || s.LoadDataMode > 3 || s.LoadDataMode == 2 {
 		return fmt.Errorf("Invalid loaddata-mode %v", s.LoadDataMode)
 	}
 	if s.LoadDataQuotaScan < 0 || s.LoadDataQuotaScan > 2 {
@@ -253,6 +390,12 @@ func (s *Service) loadInitialData() error {
 	if err != nil {
 		return fmt.Errorf("unable to read input file %#v: %v", s.LoadDataFrom, err)
 	}
+	if common.IsEncryptedBackup(content) {
+		content, err = common.DecryptBackup(content, common.Config.BackupSchedule.Passphrase)
+		if err != nil {
+			return fmt.Errorf("unable to decrypt file to restore %#v: %v", s.LoadDataFrom, err)
+		}
+	}
 	dump, err := dataprovider.ParseDumpData(content)
 	if err != nil {
 		return fmt.Errorf("unable to parse file to restore %#v: %v", s.LoadDataFrom, err)
@@ -291,5 +434,15 @@ func (s *Service) restoreDump(dump dataprovider.BackupData) error {
 	if err != nil {
 		return fmt.Errorf("unable to restore users from file %#v: %v", s.LoadDataFrom, err)
 	}
+	err = httpd.RestoreAPIKeys(dump.APIKeys, s.LoadDataFrom, s.LoadDataMode)
+	if err != nil {
+		return fmt.Errorf("unable to restore API keys from file %#v: %v", s.LoadDataFrom, err)
+	}
+	if s.LoadDataMode == 3 {
+		err = httpd.DeleteMissingObjects(&dump, s.LoadDataFrom, "")
+		if err != nil {
+			return fmt.Errorf("unable to delete objects not included in file %#v: %v", s.LoadDataFrom, err)
+		}
+	}
 	return nil
 }
@@ -94,6 +94,7 @@ func (s *Service) StartPortableMode(sftpdPort, ftpPort, webdavPort int, enabledS
 		} else {
 			binding.Port = 49152 + rand.Intn(15000)
 		}
+		webDavConf.Bindings = []webdavd.Binding{binding}
 		webDavConf.CertificateFile = webDavCert
 		webDavConf.CertificateKeyFile = webDavKey
 		config.SetWebDAVDConfig(webDavConf)
@@ -117,6 +118,9 @@ func (s *Service) getServiceOptionalInfoString() string {
 	var info strings.Builder
 	if config.GetSFTPDConfig().Bindings[0].IsValid() {
 		info.WriteString(fmt.Sprintf("SFTP port: %v ", config.GetSFTPDConfig().Bindings[0].Port))
+		if fingerprints := getPortableHostKeysFingerprints(); len(fingerprints) > 0 {
+			info.WriteString(fmt.Sprintf("host keys fingerprints: %v ", strings.Join(fingerprints, ", ")))
+		}
 	}
 	if config.GetFTPDConfig().Bindings[0].IsValid() {
 		info.WriteString(fmt.Sprintf("FTP port: %v ", config.GetFTPDConfig().Bindings[0].Port))
@@ -132,6 +136,22 @@ func (s *Service) getServiceOptionalInfoString() string {
 	return info.String()
 }
 
+// getPortableHostKeysFingerprints returns the fingerprints for the SFTP host keys.
+// The SFTP server is started asynchronously so we wait a bit for the host keys to be loaded
+func getPortableHostKeysFingerprints() []string {
+	for i := 0; i < 20; i++ {
+		if sftpd.GetStatus().IsActive {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	var fingerprints []string
+	for _, hostKey := range sftpd.GetStatus().HostKeys {
+		fingerprints = append(fingerprints, hostKey.Fingerprint)
+	}
+	return fingerprints
+}
+
 func (s *Service) advertiseServices(advertiseService, advertiseCredentials bool) {
 	var mDNSServiceSFTP *zeroconf.Server
 	var mDNSServiceFTP *zeroconf.Server
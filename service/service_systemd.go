@@ -0,0 +1,144 @@
+// +build linux
+
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	systemdUnitName       = "sftpgo.service"
+	systemdDefaultUnitDir = "/etc/systemd/system"
+)
+
+// SystemdService allows to manage SFTPGo as a systemd unit
+type SystemdService struct {
+	Service Service
+	// User and Group to run the service as. Empty means root
+	User  string
+	Group string
+	// Directory to write the unit file to. Empty means /etc/systemd/system
+	UnitDir string
+}
+
+func (s *SystemdService) getUnitPath() string {
+	unitDir := s.UnitDir
+	if unitDir == "" {
+		unitDir = systemdDefaultUnitDir
+	}
+	return filepath.Join(unitDir, systemdUnitName)
+}
+
+// getUnitContent returns the unit file content, args are the arguments for the serve command
+func (s *SystemdService) getUnitContent(exePath string, args ...string) (string, error) {
+	workingDir, err := filepath.Abs(s.Service.ConfigDir)
+	if err != nil {
+		return "", err
+	}
+	execStart := []string{quoteSystemdArg(exePath)}
+	for _, arg := range args {
+		execStart = append(execStart, quoteSystemdArg(arg))
+	}
+	var unit strings.Builder
+	unit.WriteString("[Unit]\nDescription=SFTPGo Server\nAfter=network.target\n\n[Service]\n")
+	if s.User != "" {
+		unit.WriteString(fmt.Sprintf("User=%v\n", s.User))
+	}
+	if s.Group != "" {
+		unit.WriteString(fmt.Sprintf("Group=%v\n", s.Group))
+	}
+	unit.WriteString("Type=simple\n")
+	unit.WriteString(fmt.Sprintf("WorkingDirectory=%v\n", workingDir))
+	unit.WriteString(fmt.Sprintf("EnvironmentFile=-%v\n", filepath.Join(workingDir, "sftpgo.env")))
+	unit.WriteString(fmt.Sprintf("ExecStart=%v\n", strings.Join(execStart, " ")))
+	unit.WriteString("ExecReload=/bin/kill -s HUP $MAINPID\nKillMode=mixed\nPrivateTmp=true\nRestart=always\n")
+	unit.WriteString("RestartSec=10s\n\n[Install]\nWantedBy=multi-user.target\n")
+	return unit.String(), nil
+}
+
+// Install writes the systemd unit file and enables it.
+// args are the arguments for the serve command
+func (s *SystemdService) Install(args ...string) error {
+	unitPath := s.getUnitPath()
+	if _, err := os.Stat(unitPath); err == nil {
+		return fmt.Errorf("service %v already exists", unitPath)
+	}
+	exePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	content, err := s.getUnitContent(exePath, args...)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(unitPath, []byte(content), 0644); err != nil {
+		return err
+	}
+	if err := runSystemctl("daemon-reload"); err != nil {
+		os.Remove(unitPath)
+		return err
+	}
+	if err := runSystemctl("enable", systemdUnitName); err != nil {
+		os.Remove(unitPath)
+		return err
+	}
+	return nil
+}
+
+// Uninstall stops and disables the service and removes the unit file
+func (s *SystemdService) Uninstall() error {
+	unitPath := s.getUnitPath()
+	if _, err := os.Stat(unitPath); err != nil {
+		return fmt.Errorf("service %v is not installed", unitPath)
+	}
+	if err := runSystemctl("disable", "--now", systemdUnitName); err != nil {
+		return err
+	}
+	if err := os.Remove(unitPath); err != nil {
+		return err
+	}
+	return runSystemctl("daemon-reload")
+}
+
+// Start starts the service
+func (s *SystemdService) Start() error {
+	return runSystemctl("start", systemdUnitName)
+}
+
+// Stop stops the service
+func (s *SystemdService) Stop() error {
+	return runSystemctl("stop", systemdUnitName)
+}
+
+func runSystemctl(args ...string) error {
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		output := strings.TrimSpace(string(out))
+		if output == "" {
+			return fmt.Errorf("systemctl %v failed: %w", strings.Join(args, " "), err)
+		}
+		return fmt.Errorf("systemctl %v failed: %w: %v", strings.Join(args, " "), err, output)
+	}
+	return nil
+}
+
+// quoteSystemdArg quotes the argument if it contains characters that systemd
+// would interpret, such as spaces
+func quoteSystemdArg(arg string) string {
+	if arg == "" {
+		return `""`
+	}
+	if strings.ContainsAny(arg, " \t\"'\\$%;") {
+		// systemd supports C-style escapes inside double quotes, "$" and "%" are special too
+		quoted := strconv.Quote(arg)
+		quoted = strings.ReplaceAll(quoted, "$", "$$")
+		return strings.ReplaceAll(quoted, "%", "%%")
+	}
+	return arg
+}
@@ -0,0 +1,75 @@
+// +build linux
+
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuoteSystemdArg(t *testing.T) {
+	assert.Equal(t, `""`, quoteSystemdArg(""))
+	assert.Equal(t, "serve", quoteSystemdArg("serve"))
+	assert.Equal(t, "/etc/sftpgo/sftpgo.json", quoteSystemdArg("/etc/sftpgo/sftpgo.json"))
+	assert.Equal(t, `"/opt/my dir"`, quoteSystemdArg("/opt/my dir"))
+	assert.Equal(t, `"a\"b"`, quoteSystemdArg(`a"b`))
+	assert.Equal(t, `"a\\b"`, quoteSystemdArg(`a\b`))
+	assert.Equal(t, `"$$HOME"`, quoteSystemdArg("$HOME"))
+	assert.Equal(t, `"100%%"`, quoteSystemdArg("100%"))
+	assert.Equal(t, `"a;b"`, quoteSystemdArg("a;b"))
+	assert.Equal(t, `"a\tb"`, quoteSystemdArg("a\tb"))
+}
+
+func TestSystemdUnitPath(t *testing.T) {
+	s := SystemdService{}
+	assert.Equal(t, filepath.Join(systemdDefaultUnitDir, systemdUnitName), s.getUnitPath())
+	s.UnitDir = os.TempDir()
+	assert.Equal(t, filepath.Join(os.TempDir(), systemdUnitName), s.getUnitPath())
+}
+
+func TestSystemdUnitContent(t *testing.T) {
+	configDir := filepath.Join(os.TempDir(), "sftpgo config")
+	s := SystemdService{
+		Service: Service{
+			ConfigDir: configDir,
+		},
+	}
+	content, err := s.getUnitContent("/usr/bin/sftpgo", "serve")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(content, "[Unit]\nDescription=SFTPGo Server\n"))
+	assert.NotContains(t, content, "User=")
+	assert.NotContains(t, content, "Group=")
+	assert.Contains(t, content, "\nWorkingDirectory="+configDir+"\n")
+	assert.Contains(t, content, "\nEnvironmentFile=-"+filepath.Join(configDir, "sftpgo.env")+"\n")
+	assert.Contains(t, content, "\nExecStart=/usr/bin/sftpgo serve\n")
+	assert.Contains(t, content, "\nExecReload=/bin/kill -s HUP $MAINPID\n")
+	assert.True(t, strings.HasSuffix(content, "[Install]\nWantedBy=multi-user.target\n"))
+
+	s.User = "sftpgo"
+	s.Group = "sftpgrp"
+	content, err = s.getUnitContent("/opt/sftp go/sftpgo", "serve", "--config-file", "sftpgo.json",
+		"--log-file-path", "", "--log-to-journald=true")
+	require.NoError(t, err)
+	assert.Contains(t, content, "\nUser=sftpgo\nGroup=sftpgrp\nType=simple\n")
+	assert.Contains(t, content, `ExecStart="/opt/sftp go/sftpgo" serve --config-file sftpgo.json --log-file-path "" --log-to-journald=true`)
+	// a relative config dir is converted to an absolute path
+	s.Service.ConfigDir = "."
+	content, err = s.getUnitContent("/usr/bin/sftpgo", "serve")
+	require.NoError(t, err)
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	assert.Contains(t, content, "\nWorkingDirectory="+wd+"\n")
+}
+
+func TestSystemdServiceNotInstalled(t *testing.T) {
+	s := SystemdService{
+		UnitDir: filepath.Join(os.TempDir(), "missing_unit_dir"),
+	}
+	err := s.Uninstall()
+	assert.Error(t, err)
+}
@@ -12,12 +12,7 @@ import (
 	"golang.org/x/sys/windows/svc/mgr"
 
 	"github.com/drakkan/sftpgo/common"
-	"github.com/drakkan/sftpgo/dataprovider"
-	"github.com/drakkan/sftpgo/ftpd"
-	"github.com/drakkan/sftpgo/httpd"
 	"github.com/drakkan/sftpgo/logger"
-	"github.com/drakkan/sftpgo/telemetry"
-	"github.com/drakkan/sftpgo/webdavd"
 )
 
 const (
@@ -109,42 +104,28 @@ loop:
 			changes <- c.CurrentStatus
 		case svc.Stop, svc.Shutdown:
 			logger.Debug(logSender, "", "Received service stop request")
-			changes <- svc.Status{State: svc.StopPending}
+			// the wait hint allows the active transfers to complete before the service manager gives up
+			waitHint := time.Duration(common.Config.GracefulShutdownTimeout)*time.Second + 10*time.Second
+			changes <- svc.Status{State: svc.StopPending, WaitHint: uint32(waitHint.Milliseconds())}
+			common.GracefulShutdown()
 			wasStopped <- true
 			s.Service.Stop()
 			break loop
 		case svc.ParamChange:
 			logger.Debug(logSender, "", "Received reload request")
-			err := dataprovider.ReloadConfig()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading dataprovider configuration: %v", err)
-			}
-			err = httpd.ReloadCertificateMgr()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading cert manager: %v", err)
-			}
-			err = ftpd.ReloadCertificateMgr()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading FTPD cert manager: %v", err)
-			}
-			err = webdavd.ReloadCertificateMgr()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading WebDAV cert manager: %v", err)
-			}
-			err = telemetry.ReloadCertificateMgr()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading telemetry cert manager: %v", err)
-			}
-			err = common.ReloadDefender()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading defender's lists: %v", err)
-			}
+			s.Service.reload()
 		case rotateLogCmd:
 			logger.Debug(logSender, "", "Received log file rotation request")
 			err := logger.RotateLogFile()
 			if err != nil {
 				logger.Warn(logSender, "", "error rotating log file: %v", err)
 			}
+			if err := common.RotateAuditLog(); err != nil {
+				logger.Debug(logSender, "", "audit log not rotated: %v", err)
+			}
+			if err := common.RotateTransferLog(); err != nil {
+				logger.Debug(logSender, "", "transfer log not rotated: %v", err)
+			}
 		default:
 			continue loop
 		}
@@ -1,3 +1,3 @@
 package service
 
-func registerSigHup() {}
+func registerSigHup(s *Service) {}
@@ -0,0 +1,23 @@
+// +build !windows
+
+package service
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/logger"
+)
+
+func registerSigTerm(shutdown chan bool) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM)
+	go func() {
+		<-sig
+		logger.Info(logSender, "", "Received termination request")
+		common.GracefulShutdown()
+		shutdown <- true
+	}()
+}
@@ -0,0 +1,4 @@
+package service
+
+// on Windows the active transfers are drained when the service stop request is received
+func registerSigTerm(shutdown chan bool) {}
@@ -7,6 +7,7 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/logger"
 )
 
@@ -20,6 +21,12 @@ func registerSigUSR1() {
 			if err != nil {
 				logger.Warn(logSender, "", "error rotating log file: %v", err)
 			}
+			if err := common.RotateAuditLog(); err != nil {
+				logger.Debug(logSender, "", "audit log not rotated: %v", err)
+			}
+			if err := common.RotateTransferLog(); err != nil {
+				logger.Debug(logSender, "", "transfer log not rotated: %v", err)
+			}
 		}
 	}()
 }
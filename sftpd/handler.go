@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
 
 	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/dataprovider"
@@ -54,6 +55,10 @@ func (c *Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
 
+	if err := c.CheckTransfersLimit(); err != nil {
+		return nil, err
+	}
+
 	p, err := c.Fs.ResolvePath(request.Filepath)
 	if err != nil {
 		return nil, c.GetFsError(err)
@@ -65,8 +70,11 @@ func (c *Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 		return nil, c.GetFsError(err)
 	}
 
-	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, p, request.Filepath, common.TransferDownload,
+	baseTransfer, err := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, p, request.Filepath, common.TransferDownload,
 		0, 0, 0, false, c.Fs)
+	if err != nil {
+		return nil, err
+	}
 	t := newTransfer(baseTransfer, nil, r, nil)
 
 	return t, nil
@@ -90,6 +98,10 @@ func (c *Connection) handleFilewrite(request *sftp.Request) (sftp.WriterAtReader
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
 
+	if err := c.CheckTransfersLimit(); err != nil {
+		return nil, err
+	}
+
 	p, err := c.Fs.ResolvePath(request.Filepath)
 	if err != nil {
 		return nil, c.GetFsError(err)
@@ -117,6 +129,13 @@ func (c *Connection) handleFilewrite(request *sftp.Request) (sftp.WriterAtReader
 		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(request.Filepath)) {
 			return nil, sftp.ErrSSHFxPermissionDenied
 		}
+		pflags := request.Pflags()
+		if statErr != nil && pflags.Append && getOSOpenFlags(pflags)&os.O_TRUNC == 0 {
+			// resume from the partial file kept for an interrupted upload, if any
+			if info, err := c.RestorePartialUpload(p, request.Filepath); err == nil {
+				return c.handleSFTPUploadToExistingFile(pflags, p, filePath, info.Size(), request.Filepath, errForRead)
+			}
+		}
 		return c.handleSFTPUploadToNewFile(p, filePath, request.Filepath, errForRead)
 	}
 
@@ -346,6 +365,9 @@ func (c *Connection) handleSFTPUploadToNewFile(resolvedPath, filePath, requestPa
 		c.Log(logger.LevelInfo, "denying file write due to quota limits")
 		return nil, sftp.ErrSSHFxFailure
 	}
+	if err := c.CheckDirectoryLimit(requestPath); err != nil {
+		return nil, err
+	}
 
 	file, w, cancelFn, err := c.Fs.Create(filePath, 0)
 	if err != nil {
@@ -358,8 +380,11 @@ func (c *Connection) handleSFTPUploadToNewFile(resolvedPath, filePath, requestPa
 	// we can get an error only for resume
 	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, 0)
 
-	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
+	baseTransfer, err := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
 		common.TransferUpload, 0, 0, maxWriteSize, true, c.Fs)
+	if err != nil {
+		return nil, err
+	}
 	t := newTransfer(baseTransfer, w, nil, errForRead)
 
 	return t, nil
@@ -426,15 +451,25 @@ func (c *Connection) handleSFTPUploadToExistingFile(pflags sftp.FileOpenFlags, r
 
 	vfs.SetPathPermissions(c.Fs, filePath, c.User.GetUID(), c.User.GetGID())
 
-	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
+	baseTransfer, err := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
 		common.TransferUpload, minWriteOffset, initialSize, maxWriteSize, false, c.Fs)
+	if err != nil {
+		return nil, err
+	}
 	t := newTransfer(baseTransfer, w, nil, errForRead)
 
 	return t, nil
 }
 
-// Disconnect disconnects the client closing the network connection
+// Disconnect disconnects the client closing the network connection.
+// If the server is shutting down a message is sent on the stderr stream before closing
 func (c *Connection) Disconnect() error {
+	if common.IsShuttingDown() {
+		if channel, ok := c.channel.(ssh.Channel); ok {
+			_, err := channel.Stderr().Write([]byte("the server is shutting down\n"))
+			c.Log(logger.LevelDebug, "shutdown message sent, err: %v", err)
+		}
+	}
 	return c.channel.Close()
 }
 
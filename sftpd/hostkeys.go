@@ -0,0 +1,93 @@
+package sftpd
+
+import (
+	"io/ioutil"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+var loadedHostKeys hostKeysHolder
+
+// hostKeysHolder holds the host keys used for the new connections,
+// they can be reloaded at runtime
+type hostKeysHolder struct {
+	sync.RWMutex
+	paths   []string
+	signers []ssh.Signer
+	status  []HostKey
+}
+
+// load parses the private host keys at the given absolute paths and replaces
+// the current ones. The current keys are not changed if a key cannot be loaded
+func (h *hostKeysHolder) load(paths []string) error {
+	var signers []ssh.Signer
+	var status []HostKey
+
+	for _, hostKey := range paths {
+		logger.Info(logSender, "", "Loading private host key %#v", hostKey)
+
+		privateBytes, err := ioutil.ReadFile(hostKey)
+		if err != nil {
+			return err
+		}
+
+		private, err := ssh.ParsePrivateKey(privateBytes)
+		if err != nil {
+			return err
+		}
+		k := HostKey{
+			Path:        hostKey,
+			Fingerprint: ssh.FingerprintSHA256(private.PublicKey()),
+		}
+		status = append(status, k)
+		signers = append(signers, private)
+		logger.Info(logSender, "", "Host key %#v loaded, type %#v, fingerprint %#v", hostKey,
+			private.PublicKey().Type(), k.Fingerprint)
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	h.paths = paths
+	h.signers = signers
+	h.status = status
+	return nil
+}
+
+func (h *hostKeysHolder) reload() error {
+	h.RLock()
+	paths := h.paths
+	h.RUnlock()
+
+	if len(paths) == 0 {
+		return nil
+	}
+	return h.load(paths)
+}
+
+func (h *hostKeysHolder) getStatus() []HostKey {
+	h.RLock()
+	defer h.RUnlock()
+
+	if len(h.status) == 0 {
+		return nil
+	}
+	result := make([]HostKey, len(h.status))
+	copy(result, h.status)
+	return result
+}
+
+// getServerConfig returns a copy of the given server configuration with the current host keys added
+func (h *hostKeysHolder) getServerConfig(config *ssh.ServerConfig) *ssh.ServerConfig {
+	h.RLock()
+	defer h.RUnlock()
+
+	serverConfig := *config
+	for _, signer := range h.signers {
+		serverConfig.AddHostKey(signer)
+	}
+	return &serverConfig
+}
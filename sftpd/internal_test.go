@@ -159,7 +159,8 @@ func TestUploadResumeInvalidOffset(t *testing.T) {
 	}
 	fs := vfs.NewOsFs("", os.TempDir(), nil)
 	conn := common.NewBaseConnection("", common.ProtocolSFTP, user, fs)
-	baseTransfer := common.NewBaseTransfer(file, conn, nil, file.Name(), testfile, common.TransferUpload, 10, 0, 0, false, fs)
+	baseTransfer, err := common.NewBaseTransfer(file, conn, nil, file.Name(), testfile, common.TransferUpload, 10, 0, 0, false, fs)
+	require.NoError(t, err)
 	transfer := newTransfer(baseTransfer, nil, nil, nil)
 	_, err = transfer.WriteAt([]byte("test"), 0)
 	assert.Error(t, err, "upload with invalid offset must fail")
@@ -187,7 +188,8 @@ func TestReadWriteErrors(t *testing.T) {
 	}
 	fs := vfs.NewOsFs("", os.TempDir(), nil)
 	conn := common.NewBaseConnection("", common.ProtocolSFTP, user, fs)
-	baseTransfer := common.NewBaseTransfer(file, conn, nil, file.Name(), testfile, common.TransferDownload, 0, 0, 0, false, fs)
+	baseTransfer, err := common.NewBaseTransfer(file, conn, nil, file.Name(), testfile, common.TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	transfer := newTransfer(baseTransfer, nil, nil, nil)
 	err = file.Close()
 	assert.NoError(t, err)
@@ -201,7 +203,8 @@ func TestReadWriteErrors(t *testing.T) {
 
 	r, _, err := pipeat.Pipe()
 	assert.NoError(t, err)
-	baseTransfer = common.NewBaseTransfer(nil, conn, nil, file.Name(), testfile, common.TransferDownload, 0, 0, 0, false, fs)
+	baseTransfer, err = common.NewBaseTransfer(nil, conn, nil, file.Name(), testfile, common.TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	transfer = newTransfer(baseTransfer, nil, r, nil)
 	err = transfer.Close()
 	assert.NoError(t, err)
@@ -211,7 +214,8 @@ func TestReadWriteErrors(t *testing.T) {
 	r, w, err := pipeat.Pipe()
 	assert.NoError(t, err)
 	pipeWriter := vfs.NewPipeWriter(w)
-	baseTransfer = common.NewBaseTransfer(nil, conn, nil, file.Name(), testfile, common.TransferDownload, 0, 0, 0, false, fs)
+	baseTransfer, err = common.NewBaseTransfer(nil, conn, nil, file.Name(), testfile, common.TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	transfer = newTransfer(baseTransfer, pipeWriter, nil, nil)
 
 	err = r.Close()
@@ -257,7 +261,8 @@ func TestTransferCancelFn(t *testing.T) {
 	}
 	fs := vfs.NewOsFs("", os.TempDir(), nil)
 	conn := common.NewBaseConnection("", common.ProtocolSFTP, user, fs)
-	baseTransfer := common.NewBaseTransfer(file, conn, cancelFn, file.Name(), testfile, common.TransferDownload, 0, 0, 0, false, fs)
+	baseTransfer, err := common.NewBaseTransfer(file, conn, cancelFn, file.Name(), testfile, common.TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	transfer := newTransfer(baseTransfer, nil, nil, nil)
 
 	errFake := errors.New("fake error, this will trigger cancelFn")
@@ -913,6 +918,44 @@ func TestSystemCommandSizeForPath(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSystemCommandTransfersLimit(t *testing.T) {
+	user := dataprovider.User{
+		Username: "system_command_transfers",
+		HomeDir:  os.TempDir(),
+	}
+	user.Permissions = map[string][]string{
+		"/": {dataprovider.PermAny},
+	}
+	user.Filters.MaxConcurrentTransfers = 2
+	fs, err := user.GetFilesystem("123")
+	assert.NoError(t, err)
+	conn := &Connection{
+		BaseConnection: common.NewBaseConnection("", common.ProtocolSSH, user, fs),
+	}
+	sshCmd := sshCommand{
+		command:    "rsync",
+		connection: conn,
+	}
+	// a system command counts as a single transfer
+	stdinTransfer, stdoutTransfer, stderrTransfer, err := sshCmd.getSystemCommandTransfers(os.TempDir(), "/", 100)
+	require.NoError(t, err)
+	assert.Equal(t, common.TransferUpload, stdinTransfer.GetType())
+	assert.Equal(t, int64(100), stdinTransfer.MaxWriteSize)
+	assert.Equal(t, common.TransferDownload, stdoutTransfer.GetType())
+	assert.Equal(t, common.TransferDownload, stderrTransfer.GetType())
+	assert.Len(t, conn.GetTransfers(), 1)
+	assert.Equal(t, 1, common.Connections.GetActiveTransfers(user.Username))
+	otherTransfer, _, _, err := sshCmd.getSystemCommandTransfers(os.TempDir(), "/", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, common.Connections.GetActiveTransfers(user.Username))
+	_, _, _, err = sshCmd.getSystemCommandTransfers(os.TempDir(), "/", 0)
+	assert.ErrorIs(t, err, common.ErrTooManyTransfers)
+	assert.Len(t, conn.GetTransfers(), 2)
+	conn.RemoveTransfer(stdinTransfer)
+	conn.RemoveTransfer(otherTransfer)
+	assert.Equal(t, 0, common.Connections.GetActiveTransfers(user.Username))
+}
+
 func TestSystemCommandErrors(t *testing.T) {
 	buf := make([]byte, 65535)
 	stdErrBuf := make([]byte, 65535)
@@ -980,8 +1023,9 @@ func TestSystemCommandErrors(t *testing.T) {
 		WriteError:   nil,
 	}
 	sshCmd.connection.channel = &mockSSHChannel
-	baseTransfer := common.NewBaseTransfer(nil, sshCmd.connection.BaseConnection, nil, "", "", common.TransferDownload,
+	baseTransfer, err := common.NewBaseTransfer(nil, sshCmd.connection.BaseConnection, nil, "", "", common.TransferDownload,
 		0, 0, 0, false, fs)
+	require.NoError(t, err)
 	transfer := newTransfer(baseTransfer, nil, nil, nil)
 	destBuff := make([]byte, 65535)
 	dst := bytes.NewBuffer(destBuff)
@@ -1012,6 +1056,7 @@ func TestSystemCommandErrors(t *testing.T) {
 	transfer.MaxWriteSize = -1
 	_, err = transfer.copyFromReaderToWriter(sshCmd.connection.channel, dst)
 	assert.EqualError(t, err, common.ErrQuotaExceeded.Error())
+	sshCmd.connection.RemoveTransfer(transfer)
 	err = os.RemoveAll(homeDir)
 	assert.NoError(t, err)
 }
@@ -1602,8 +1647,9 @@ func TestSCPUploadFiledata(t *testing.T) {
 	file, err := os.Create(testfile)
 	assert.NoError(t, err)
 
-	baseTransfer := common.NewBaseTransfer(file, scpCommand.connection.BaseConnection, nil, file.Name(),
+	baseTransfer, err := common.NewBaseTransfer(file, scpCommand.connection.BaseConnection, nil, file.Name(),
 		"/"+testfile, common.TransferDownload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
 	transfer := newTransfer(baseTransfer, nil, nil, nil)
 
 	err = scpCommand.getUploadFileData(2, transfer)
@@ -1686,8 +1732,9 @@ func TestUploadError(t *testing.T) {
 	fileTempName := "temptestfile"
 	file, err := os.Create(fileTempName)
 	assert.NoError(t, err)
-	baseTransfer := common.NewBaseTransfer(file, connection.BaseConnection, nil, testfile,
+	baseTransfer, err := common.NewBaseTransfer(file, connection.BaseConnection, nil, testfile,
 		testfile, common.TransferUpload, 0, 0, 0, true, fs)
+	require.NoError(t, err)
 	transfer := newTransfer(baseTransfer, nil, nil, nil)
 
 	errFake := errors.New("fake error")
@@ -1736,7 +1783,8 @@ func TestTransferFailingReader(t *testing.T) {
 
 	r, _, err := pipeat.Pipe()
 	assert.NoError(t, err)
-	baseTransfer := common.NewBaseTransfer(nil, connection.BaseConnection, nil, fsPath, filepath.Base(fsPath), common.TransferUpload, 0, 0, 0, false, fs)
+	baseTransfer, err := common.NewBaseTransfer(nil, connection.BaseConnection, nil, fsPath, filepath.Base(fsPath), common.TransferUpload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	errRead := errors.New("read is not allowed")
 	tr := newTransfer(baseTransfer, nil, r, errRead)
 	_, err = tr.ReadAt(buf, 0)
@@ -1795,16 +1843,15 @@ func TestConnectionStatusStruct(t *testing.T) {
 
 func TestLoadHostKeys(t *testing.T) {
 	configDir := ".."
-	serverConfig := &ssh.ServerConfig{}
 	c := Configuration{}
 	c.HostKeys = []string{".", "missing file"}
-	err := c.checkAndLoadHostKeys(configDir, serverConfig)
+	err := c.checkAndLoadHostKeys(configDir)
 	assert.Error(t, err)
 	testfile := filepath.Join(os.TempDir(), "invalidkey")
 	err = ioutil.WriteFile(testfile, []byte("some bytes"), os.ModePerm)
 	assert.NoError(t, err)
 	c.HostKeys = []string{testfile}
-	err = c.checkAndLoadHostKeys(configDir, serverConfig)
+	err = c.checkAndLoadHostKeys(configDir)
 	assert.Error(t, err)
 	err = os.Remove(testfile)
 	assert.NoError(t, err)
@@ -1816,7 +1863,7 @@ func TestLoadHostKeys(t *testing.T) {
 	ed25519KeyName := filepath.Join(keysDir, defaultPrivateEd25519KeyName)
 	nonDefaultKeyName := filepath.Join(keysDir, "akey")
 	c.HostKeys = []string{nonDefaultKeyName, rsaKeyName, ecdsaKeyName, ed25519KeyName}
-	err = c.checkAndLoadHostKeys(configDir, serverConfig)
+	err = c.checkAndLoadHostKeys(configDir)
 	assert.Error(t, err)
 	assert.FileExists(t, rsaKeyName)
 	assert.FileExists(t, ecdsaKeyName)
@@ -1832,16 +1879,16 @@ func TestLoadHostKeys(t *testing.T) {
 		err = os.Chmod(keysDir, 0551)
 		assert.NoError(t, err)
 		c.HostKeys = nil
-		err = c.checkAndLoadHostKeys(keysDir, serverConfig)
+		err = c.checkAndLoadHostKeys(keysDir)
 		assert.Error(t, err)
 		c.HostKeys = []string{rsaKeyName, ecdsaKeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		err = c.checkAndLoadHostKeys(configDir)
 		assert.Error(t, err)
 		c.HostKeys = []string{ecdsaKeyName, rsaKeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		err = c.checkAndLoadHostKeys(configDir)
 		assert.Error(t, err)
 		c.HostKeys = []string{ed25519KeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		err = c.checkAndLoadHostKeys(configDir)
 		assert.Error(t, err)
 		err = os.Chmod(keysDir, 0755)
 		assert.NoError(t, err)
@@ -1850,6 +1897,57 @@ func TestLoadHostKeys(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestBuiltinKeyboardInteractiveAuth(t *testing.T) {
+	c := Configuration{}
+	serverConfig := &ssh.ServerConfig{}
+	// the built-in keyboard interactive authentication must be explicitly enabled
+	c.configureKeyboardInteractiveAuth(serverConfig)
+	assert.Nil(t, serverConfig.KeyboardInteractiveCallback)
+	c.KeyboardInteractiveAuthentication = true
+	c.configureKeyboardInteractiveAuth(serverConfig)
+	assert.NotNil(t, serverConfig.KeyboardInteractiveCallback)
+}
+
+func TestReloadHostKeys(t *testing.T) {
+	keyPath := filepath.Join(os.TempDir(), "reload_host_key")
+	err := utils.GenerateEd25519Keys(keyPath)
+	require.NoError(t, err)
+	holder := &hostKeysHolder{}
+	// nothing to reload if no host key was loaded
+	assert.NoError(t, holder.reload())
+	err = holder.load([]string{keyPath})
+	require.NoError(t, err)
+	status := holder.getStatus()
+	require.Len(t, status, 1)
+	fingerprint := status[0].Fingerprint
+	serverConfig := &ssh.ServerConfig{}
+	holder.getServerConfig(serverConfig)
+	// the host keys are added to a copy of the server configuration
+	assert.Equal(t, &ssh.ServerConfig{}, serverConfig)
+
+	err = os.Remove(keyPath)
+	assert.NoError(t, err)
+	err = utils.GenerateEd25519Keys(keyPath)
+	require.NoError(t, err)
+	err = holder.reload()
+	assert.NoError(t, err)
+	status = holder.getStatus()
+	require.Len(t, status, 1)
+	assert.NotEqual(t, fingerprint, status[0].Fingerprint)
+	fingerprint = status[0].Fingerprint
+	// the loaded keys are preserved if the reload fails
+	err = ioutil.WriteFile(keyPath, []byte("invalid key"), os.ModePerm)
+	assert.NoError(t, err)
+	err = holder.reload()
+	assert.Error(t, err)
+	status = holder.getStatus()
+	require.Len(t, status, 1)
+	assert.Equal(t, fingerprint, status[0].Fingerprint)
+
+	err = os.Remove(keyPath)
+	assert.NoError(t, err)
+}
+
 func TestCertCheckerInitErrors(t *testing.T) {
 	c := Configuration{}
 	c.TrustedUserCAKeys = []string{".", "missing file"}
@@ -1865,6 +1963,79 @@ func TestCertCheckerInitErrors(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCertPrincipalMappings(t *testing.T) {
+	c := Configuration{
+		CertPrincipalMappings: []CertPrincipalMapping{
+			{
+				Principal: "[",
+				Usernames: []string{"user1"},
+			},
+			{
+				Principal: "ops-*",
+			},
+			{
+				Principal: " ops-* ",
+				Usernames: []string{"user1", "user2"},
+			},
+			{
+				Principal: "admin",
+				Usernames: []string{"*"},
+			},
+		},
+	}
+	err := c.initializeCertChecker("")
+	assert.NoError(t, err)
+	require.Len(t, c.CertPrincipalMappings, 2)
+	assert.Equal(t, "ops-*", c.CertPrincipalMappings[0].Principal)
+
+	cert := &ssh.Certificate{
+		ValidPrincipals: []string{"user3", "ops-team"},
+	}
+	principal, isMapped := c.getCertPrincipal("user3", cert)
+	assert.Equal(t, "user3", principal)
+	assert.False(t, isMapped)
+	principal, isMapped = c.getCertPrincipal("user2", cert)
+	assert.Equal(t, "ops-team", principal)
+	assert.True(t, isMapped)
+	principal, isMapped = c.getCertPrincipal("user4", cert)
+	assert.Equal(t, "user4", principal)
+	assert.False(t, isMapped)
+	cert.ValidPrincipals = []string{"admin"}
+	principal, isMapped = c.getCertPrincipal("user4", cert)
+	assert.Equal(t, "admin", principal)
+	assert.True(t, isMapped)
+	cert.ValidPrincipals = nil
+	principal, isMapped = c.getCertPrincipal("user4", cert)
+	assert.Equal(t, "user4", principal)
+	assert.False(t, isMapped)
+}
+
+func TestCertSourceAddress(t *testing.T) {
+	assert.NoError(t, checkCertSourceAddress("127.0.0.1", "127.0.0.1"))
+	assert.NoError(t, checkCertSourceAddress("192.168.1.5", "10.0.0.0/8, 192.168.1.0/24"))
+	assert.NoError(t, checkCertSourceAddress("::1", "127.0.0.1,::1"))
+	assert.Error(t, checkCertSourceAddress("192.168.2.5", "10.0.0.0/8,192.168.1.0/24"))
+	assert.Error(t, checkCertSourceAddress("192.168.1.5", "invalid,192.168.1.0/24"))
+	assert.Error(t, checkCertSourceAddress("invalid", "127.0.0.1"))
+}
+
+func TestPartialAuthCertOptions(t *testing.T) {
+	partialAuthCertOptions.Store("expired", partialAuthOptions{
+		issuedAt: time.Now().Add(-2 * partialAuthOptionsTimeout),
+	})
+	storePartialAuthCertOptions("id", nil)
+	_, ok := partialAuthCertOptions.Load("id")
+	assert.False(t, ok)
+	_, ok = partialAuthCertOptions.Load("expired")
+	assert.True(t, ok)
+	storePartialAuthCertOptions("id", map[string]string{forceCommandCriticalOption: "pwd"})
+	_, ok = partialAuthCertOptions.Load("id")
+	assert.True(t, ok)
+	_, ok = partialAuthCertOptions.Load("expired")
+	assert.False(t, ok)
+	partialAuthCertOptions.Delete("id")
+}
+
 func TestRecursiveCopyErrors(t *testing.T) {
 	permissions := make(map[string][]string)
 	permissions["/"] = []string{dataprovider.PermAny}
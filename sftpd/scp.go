@@ -131,6 +131,12 @@ func (c *scpCommand) handleCreateDir(dirPath string) error {
 		c.sendErrorMessage(common.ErrPermissionDenied)
 		return common.ErrPermissionDenied
 	}
+	if isDir, errDir := vfs.IsDirectory(c.connection.Fs, p); errDir != nil || !isDir {
+		if err = c.connection.CheckDirectoryLimit(dirPath); err != nil {
+			c.sendErrorMessage(err)
+			return err
+		}
+	}
 
 	err = c.createDir(p)
 	if err != nil {
@@ -198,6 +204,12 @@ func (c *scpCommand) handleUploadFile(resolvedPath, filePath string, sizeToRead
 		c.sendErrorMessage(err)
 		return err
 	}
+	if isNewFile {
+		if err := c.connection.CheckDirectoryLimit(requestPath); err != nil {
+			c.sendErrorMessage(err)
+			return err
+		}
+	}
 
 	maxWriteSize, _ := c.connection.GetMaxWriteSize(quotaResult, false, fileSize)
 
@@ -230,8 +242,12 @@ func (c *scpCommand) handleUploadFile(resolvedPath, filePath string, sizeToRead
 
 	vfs.SetPathPermissions(c.connection.Fs, filePath, c.connection.User.GetUID(), c.connection.User.GetGID())
 
-	baseTransfer := common.NewBaseTransfer(file, c.connection.BaseConnection, cancelFn, resolvedPath, requestPath,
+	baseTransfer, err := common.NewBaseTransfer(file, c.connection.BaseConnection, cancelFn, resolvedPath, requestPath,
 		common.TransferUpload, 0, initialSize, maxWriteSize, isNewFile, c.connection.Fs)
+	if err != nil {
+		c.sendErrorMessage(err)
+		return err
+	}
 	t := newTransfer(baseTransfer, w, nil, nil)
 
 	return c.getUploadFileData(sizeToRead, t)
@@ -248,6 +264,11 @@ func (c *scpCommand) handleUpload(uploadFilePath string, sizeToRead int64) error
 		return common.ErrPermissionDenied
 	}
 
+	if err = c.connection.CheckTransfersLimit(); err != nil {
+		c.sendErrorMessage(err)
+		return err
+	}
+
 	p, err := c.connection.Fs.ResolvePath(uploadFilePath)
 	if err != nil {
 		c.connection.Log(logger.LevelWarn, "error uploading file: %#v, err: %v", uploadFilePath, err)
@@ -487,6 +508,11 @@ func (c *scpCommand) handleDownload(filePath string) error {
 		return common.ErrPermissionDenied
 	}
 
+	if err = c.connection.CheckTransfersLimit(); err != nil {
+		c.sendErrorMessage(err)
+		return err
+	}
+
 	file, r, cancelFn, err := c.connection.Fs.Open(p, 0)
 	if err != nil {
 		c.connection.Log(logger.LevelError, "could not open file %#v for reading: %v", p, err)
@@ -494,8 +520,13 @@ func (c *scpCommand) handleDownload(filePath string) error {
 		return err
 	}
 
-	baseTransfer := common.NewBaseTransfer(file, c.connection.BaseConnection, cancelFn, p, filePath,
+	baseTransfer, err := common.NewBaseTransfer(file, c.connection.BaseConnection, cancelFn, p, filePath,
 		common.TransferDownload, 0, 0, 0, false, c.connection.Fs)
+	if err != nil {
+		c.sendErrorMessage(err)
+		return err
+	}
+	baseTransfer.SetExpectedSize(stat.Size())
 	t := newTransfer(baseTransfer, nil, r, nil)
 
 	err = c.sendDownloadFileData(p, stat, t)
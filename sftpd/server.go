@@ -10,9 +10,12 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime/debug"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -20,6 +23,7 @@ import (
 
 	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/geoip"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/metrics"
 	"github.com/drakkan/sftpgo/utils"
@@ -30,10 +34,16 @@ const (
 	defaultPrivateECDSAKeyName   = "id_ecdsa"
 	defaultPrivateEd25519KeyName = "id_ed25519"
 	sourceAddressCriticalOption  = "source-address"
+	forceCommandCriticalOption   = "force-command"
+	internalSFTPCommand          = "internal-sftp"
+	partialAuthOptionsTimeout    = 2 * time.Minute
 )
 
 var (
 	sftpExtensions = []string{"statvfs@openssh.com"}
+	// critical options for certificates used in multi-step authentications,
+	// the key is the SSH session ID
+	partialAuthCertOptions sync.Map
 )
 
 // Binding defines the configuration for a network listener
@@ -61,6 +71,28 @@ func (b *Binding) HasProxy() bool {
 	return b.ApplyProxyConfig && common.Config.ProxyProtocol > 0
 }
 
+// CertPrincipalMapping allows to login as the defined users using a certificate
+// signed by a trusted CA that includes a matching principal
+type CertPrincipalMapping struct {
+	// Principal to match. Shell patterns, as supported by path.Match, are allowed,
+	// for example "ops-*" matches any principal starting with "ops-"
+	Principal string `json:"principal" mapstructure:"principal"`
+	// Usernames allowed for certificates including a matching principal, "*" means any user
+	Usernames []string `json:"usernames" mapstructure:"usernames"`
+}
+
+func (m *CertPrincipalMapping) matches(principal, username string) bool {
+	if matched, err := path.Match(m.Principal, principal); err != nil || !matched {
+		return false
+	}
+	return utils.IsStringInSlice(username, m.Usernames) || utils.IsStringInSlice("*", m.Usernames)
+}
+
+type partialAuthOptions struct {
+	criticalOptions map[string]string
+	issuedAt        time.Time
+}
+
 // Configuration for the SFTP server
 type Configuration struct {
 	// Identification string used by the server
@@ -100,6 +132,9 @@ type Configuration struct {
 	// that are trusted to sign user certificates for authentication.
 	// The paths can be absolute or relative to the configuration directory
 	TrustedUserCAKeys []string `json:"trusted_user_ca_keys" mapstructure:"trusted_user_ca_keys"`
+	// CertPrincipalMappings allows to login, using a certificate signed by a trusted CA, as users
+	// not included in the certificate principals. By default the username must be a certificate principal
+	CertPrincipalMappings []CertPrincipalMapping `json:"cert_principal_mappings" mapstructure:"cert_principal_mappings"`
 	// LoginBannerFile the contents of the specified file, if any, are sent to
 	// the remote user before authentication is allowed.
 	LoginBannerFile string `json:"login_banner_file" mapstructure:"login_banner_file"`
@@ -129,6 +164,9 @@ type Configuration struct {
 	// Absolute path to an external program or an HTTP URL to invoke for keyboard interactive authentication.
 	// Leave empty to disable this authentication mode.
 	KeyboardInteractiveHook string `json:"keyboard_interactive_auth_hook" mapstructure:"keyboard_interactive_auth_hook"`
+	// KeyboardInteractiveAuthentication enables the built-in keyboard interactive authentication if
+	// no hook is configured. It asks for the password and for the TOTP passcode if enabled for the user
+	KeyboardInteractiveAuthentication bool `json:"keyboard_interactive_authentication" mapstructure:"keyboard_interactive_authentication"`
 	// PasswordAuthentication specifies whether password authentication is allowed.
 	PasswordAuthentication bool `json:"password_authentication" mapstructure:"password_authentication"`
 	// Deprecated: please use the same key in common configuration
@@ -207,8 +245,7 @@ func (c *Configuration) Initialize(configDir string) error {
 		return common.ErrNoBinding
 	}
 
-	if err := c.checkAndLoadHostKeys(configDir, serverConfig); err != nil {
-		serviceStatus.HostKeys = nil
+	if err := c.checkAndLoadHostKeys(configDir); err != nil {
 		return err
 	}
 
@@ -324,6 +361,11 @@ func (c *Configuration) configureLoginBanner(serverConfig *ssh.ServerConfig, con
 
 func (c *Configuration) configureKeyboardInteractiveAuth(serverConfig *ssh.ServerConfig) {
 	if c.KeyboardInteractiveHook == "" {
+		if c.KeyboardInteractiveAuthentication {
+			// built-in keyboard interactive authentication, it asks for the password
+			// and for the TOTP passcode if enabled for the user
+			serverConfig.KeyboardInteractiveCallback = c.getKeyboardInteractiveCallback()
+		}
 		return
 	}
 	if !strings.HasPrefix(c.KeyboardInteractiveHook, "http") {
@@ -341,7 +383,12 @@ func (c *Configuration) configureKeyboardInteractiveAuth(serverConfig *ssh.Serve
 			return
 		}
 	}
-	serverConfig.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	serverConfig.KeyboardInteractiveCallback = c.getKeyboardInteractiveCallback()
+}
+
+func (c *Configuration) getKeyboardInteractiveCallback() func(conn ssh.ConnMetadata,
+	client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	return func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
 		sp, err := c.validateKeyboardInteractiveCredentials(conn, client)
 		if err != nil {
 			return nil, &authenticationError{err: fmt.Sprintf("could not validate keyboard interactive credentials: %v", err)}
@@ -351,9 +398,22 @@ func (c *Configuration) configureKeyboardInteractiveAuth(serverConfig *ssh.Serve
 	}
 }
 
-func canAcceptConnection(ip string) bool {
+func canAcceptConnection(conn net.Conn, ip string) bool {
+	if !common.IsIPAllowed(ip) {
+		logger.Log(logger.LevelDebug, common.ProtocolSSH, "", "connection refused, ip %#v is not allowed", ip)
+		return false
+	}
 	if common.IsBanned(ip) {
 		logger.Log(logger.LevelDebug, common.ProtocolSSH, "", "connection refused, ip %#v is banned", ip)
+		common.Tarpit(conn, common.ProtocolSSH)
+		return false
+	}
+	if err := common.LimitRate(common.RateLimiterTargetConnections, common.ProtocolSSH, ip); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolSSH, "", "connection refused, ip %#v: %v", ip, err)
+		return false
+	}
+	if common.IsShuttingDown() {
+		logger.Log(logger.LevelDebug, common.ProtocolSSH, "", "connection refused, the server is shutting down")
 		return false
 	}
 	if !common.Connections.IsNewConnectionAllowed() {
@@ -374,7 +434,7 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 		}
 	}()
 	ipAddr := utils.GetIPFromRemoteAddress(conn.RemoteAddr().String())
-	if !canAcceptConnection(ipAddr) {
+	if !canAcceptConnection(conn, ipAddr) {
 		conn.Close()
 		return
 	}
@@ -382,7 +442,7 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	// we'll set a Deadline for handshake to complete, the default is 2 minutes as OpenSSH
 	conn.SetDeadline(time.Now().Add(handshakeTimeout)) //nolint:errcheck
 
-	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, loadedHostKeys.getServerConfig(config))
 	if err != nil {
 		logger.Debug(logSender, "", "failed to accept an incoming connection: %v", err)
 		checkAuthError(ipAddr, err)
@@ -400,15 +460,20 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 
 	loginType := sconn.Permissions.Extensions["sftpgo_login_method"]
 	connectionID := hex.EncodeToString(sconn.SessionID())
+	forceCommand := sconn.Permissions.CriticalOptions[forceCommandCriticalOption]
 
 	if err = checkRootPath(&user, connectionID); err != nil {
 		return
 	}
+	if err = dataprovider.AddTempCredentialsUse(&user); err != nil {
+		logger.Warn(logSender, connectionID, "login denied for user %#v: %v", user.Username, err)
+		return
+	}
 
 	logger.Log(logger.LevelInfo, common.ProtocolSSH, connectionID,
-		"User id: %d, logged in with: %#v, username: %#v, home_dir: %#v remote addr: %#v",
-		user.ID, loginType, user.Username, user.HomeDir, ipAddr)
-	dataprovider.UpdateLastLogin(&user) //nolint:errcheck
+		"User id: %d, logged in with: %#v, username: %#v, home_dir: %#v remote addr: %#v country: %#v",
+		user.ID, loginType, user.Username, user.HomeDir, ipAddr, geoip.GetCountry(ipAddr))
+	dataprovider.UpdateLastLogin(&user, common.ProtocolSSH) //nolint:errcheck
 
 	sshConnection := common.NewSSHConnection(connectionID, conn)
 	common.Connections.AddSSHConnection(sshConnection)
@@ -445,6 +510,11 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 
 				switch req.Type {
 				case "subsystem":
+					if forceCommand != "" && forceCommand != internalSFTPCommand {
+						logger.Log(logger.LevelInfo, common.ProtocolSSH, connID,
+							"subsystem request refused, the certificate forces the command %#v", forceCommand)
+						break
+					}
 					if string(req.Payload[4:]) == "sftp" {
 						fs, err := user.GetFilesystem(connID)
 						if err == nil {
@@ -455,12 +525,24 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 								RemoteAddr:     conn.RemoteAddr(),
 								channel:        channel,
 							}
+							connection.SetRemoteAddress(conn.RemoteAddr().String())
 							go c.handleSftpConnection(channel, &connection)
 						} else {
 							logger.Debug(logSender, connID, "unable to create filesystem: %v", err)
 						}
 					}
 				case "exec":
+					if forceCommand == internalSFTPCommand {
+						logger.Log(logger.LevelInfo, common.ProtocolSSH, connID,
+							"exec request refused, the certificate allows SFTP only")
+						break
+					}
+					payload := req.Payload
+					if forceCommand != "" {
+						logger.Log(logger.LevelDebug, common.ProtocolSSH, connID,
+							"executing the command %#v forced by the certificate", forceCommand)
+						payload = ssh.Marshal(&sshSubsystemExecMsg{Command: forceCommand})
+					}
 					// protocol will be set later inside processSSHCommand it could be SSH or SCP
 					fs, err := user.GetFilesystem(connID)
 					if err == nil {
@@ -470,7 +552,8 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 							RemoteAddr:     conn.RemoteAddr(),
 							channel:        channel,
 						}
-						ok = processSSHCommand(req.Payload, &connection, c.EnabledSSHCommands)
+						connection.SetRemoteAddress(conn.RemoteAddr().String())
+						ok = processSSHCommand(payload, &connection, c.EnabledSSHCommands)
 					} else {
 						logger.Debug(sshCommandLogSender, connID, "unable to create filesystem: %v", err)
 					}
@@ -535,13 +618,32 @@ func checkAuthError(ip string, err error) {
 			}
 		}
 	} else {
-		logger.ConnectionFailedLog("", ip, dataprovider.LoginMethodNoAuthTryed, common.ProtocolSSH, err.Error())
+		logger.ConnectionFailedLog("", ip, geoip.GetCountry(ip), dataprovider.LoginMethodNoAuthTryed, common.ProtocolSSH,
+			err.Error())
 		metrics.AddNoAuthTryed()
-		common.AddDefenderEvent(ip, common.HostEventNoLoginTried)
+		if isProtocolViolation(err) {
+			common.AddDefenderEvent(ip, common.HostEventProtocolViolation)
+		} else {
+			common.AddDefenderEvent(ip, common.HostEventNoLoginTried)
+		}
 		dataprovider.ExecutePostLoginHook(&dataprovider.User{}, dataprovider.LoginMethodNoAuthTryed, ip, common.ProtocolSSH, err)
 	}
 }
 
+// isProtocolViolation returns true if the handshake failed because the client sent
+// malformed or unexpected data and not because it closed the connection or timed out
+func isProtocolViolation(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	// the client gracefully closed the connection sending a disconnect message
+	return !strings.HasPrefix(err.Error(), "ssh: disconnect")
+}
+
 func checkRootPath(user *dataprovider.User, connectionID string) error {
 	if user.FsConfig.Provider != dataprovider.SFTPFilesystemProvider {
 		// for sftp fs check root path does nothing so don't open a useless SFTP connection
@@ -579,6 +681,14 @@ func loginUser(user *dataprovider.User, loginMethod, publicKey string, conn ssh.
 			return nil, fmt.Errorf("too many open sessions: %v", activeSessions)
 		}
 	}
+	if maxSessions := user.GetMaxSessionsForProtocol(common.ProtocolSSH); maxSessions > 0 {
+		activeSessions := common.Connections.GetActiveProtocolSessions(user.Username, common.ProtocolSSH)
+		if activeSessions >= maxSessions {
+			logger.Debug(logSender, "", "authentication refused for user: %#v, too many open SSH sessions: %v/%v", user.Username,
+				activeSessions, maxSessions)
+			return nil, fmt.Errorf("too many open SSH sessions: %v", activeSessions)
+		}
+	}
 	if !user.IsLoginMethodAllowed(loginMethod, conn.PartialSuccessMethods()) {
 		logger.Debug(logSender, connectionID, "cannot login user %#v, login method %#v is not allowed", user.Username, loginMethod)
 		return nil, fmt.Errorf("Login method %#v is not allowed for user %#v", loginMethod, user.Username)
@@ -702,11 +812,11 @@ func (c *Configuration) checkHostKeyAutoGeneration(configDir string) error {
 }
 
 // If no host keys are defined we try to use or generate the default ones.
-func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh.ServerConfig) error {
+func (c *Configuration) checkAndLoadHostKeys(configDir string) error {
 	if err := c.checkHostKeyAutoGeneration(configDir); err != nil {
 		return err
 	}
-	serviceStatus.HostKeys = nil
+	var hostKeys []string
 	for _, hostKey := range c.HostKeys {
 		if !utils.IsFileInputValid(hostKey) {
 			logger.Warn(logSender, "", "unable to load invalid host key %#v", hostKey)
@@ -716,29 +826,9 @@ func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh
 		if !filepath.IsAbs(hostKey) {
 			hostKey = filepath.Join(configDir, hostKey)
 		}
-		logger.Info(logSender, "", "Loading private host key %#v", hostKey)
-
-		privateBytes, err := ioutil.ReadFile(hostKey)
-		if err != nil {
-			return err
-		}
-
-		private, err := ssh.ParsePrivateKey(privateBytes)
-		if err != nil {
-			return err
-		}
-		k := HostKey{
-			Path:        hostKey,
-			Fingerprint: ssh.FingerprintSHA256(private.PublicKey()),
-		}
-		serviceStatus.HostKeys = append(serviceStatus.HostKeys, k)
-		logger.Info(logSender, "", "Host key %#v loaded, type %#v, fingerprint %#v", hostKey,
-			private.PublicKey().Type(), k.Fingerprint)
-
-		// Add private key to the server configuration.
-		serverConfig.AddHostKey(private)
+		hostKeys = append(hostKeys, hostKey)
 	}
-	return nil
+	return loadedHostKeys.load(hostKeys)
 }
 
 func (c *Configuration) initializeCertChecker(configDir string) error {
@@ -765,9 +855,21 @@ func (c *Configuration) initializeCertChecker(configDir string) error {
 		}
 		c.parsedUserCAKeys = append(c.parsedUserCAKeys, parsedKey)
 	}
+	var mappings []CertPrincipalMapping
+	for _, m := range c.CertPrincipalMappings {
+		m.Principal = strings.TrimSpace(m.Principal)
+		if _, err := path.Match(m.Principal, ""); err != nil || m.Principal == "" || len(m.Usernames) == 0 {
+			logger.Warn(logSender, "", "ignoring invalid certificate principal mapping %+v", m)
+			logger.WarnToConsole("ignoring invalid certificate principal mapping %+v", m)
+			continue
+		}
+		mappings = append(mappings, m)
+	}
+	c.CertPrincipalMappings = mappings
 	c.certChecker = &ssh.CertChecker{
 		SupportedCriticalOptions: []string{
 			sourceAddressCriticalOption,
+			forceCommandCriticalOption,
 		},
 		IsUserAuthority: func(k ssh.PublicKey) bool {
 			for _, key := range c.parsedUserCAKeys {
@@ -781,16 +883,64 @@ func (c *Configuration) initializeCertChecker(configDir string) error {
 	return nil
 }
 
+// getCertPrincipal returns the certificate principal to check for the given username
+// and true if the principal is mapped to the username
+func (c *Configuration) getCertPrincipal(username string, cert *ssh.Certificate) (string, bool) {
+	if len(cert.ValidPrincipals) == 0 || utils.IsStringInSlice(username, cert.ValidPrincipals) {
+		return username, false
+	}
+	for _, principal := range cert.ValidPrincipals {
+		for idx := range c.CertPrincipalMappings {
+			if c.CertPrincipalMappings[idx].matches(principal, username) {
+				return principal, true
+			}
+		}
+	}
+	return username, false
+}
+
+// checkCertSourceAddress returns an error if the given IP address does not match
+// the comma separated IP addresses and networks in the certificate source-address option
+func checkCertSourceAddress(ipAddr, sourceAddrs string) error {
+	ip := net.ParseIP(ipAddr)
+	if ip == nil {
+		return fmt.Errorf("ssh: invalid client address %#v, but source-address match required", ipAddr)
+	}
+	for _, sourceAddr := range strings.Split(sourceAddrs, ",") {
+		sourceAddr = strings.TrimSpace(sourceAddr)
+		if allowedIP := net.ParseIP(sourceAddr); allowedIP != nil {
+			if allowedIP.Equal(ip) {
+				return nil
+			}
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(sourceAddr)
+		if err != nil {
+			return fmt.Errorf("ssh: error parsing source-address restriction %#v: %v", sourceAddr, err)
+		}
+		if ipNet.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("ssh: remote address %v is not allowed because of source-address restriction", ipAddr)
+}
+
 func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
 	var err error
 	var user dataprovider.User
 	var keyID string
 	var sshPerm *ssh.Permissions
 	var certPerm *ssh.Permissions
+	var principalInfo string
 
 	connectionID := hex.EncodeToString(conn.SessionID())
 	method := dataprovider.SSHLoginMethodPublicKey
 	ipAddr := utils.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	if err = common.CheckLoginAttempt(ipAddr, common.ProtocolSSH); err != nil {
+		user.Username = conn.User()
+		updateLoginMetrics(&user, ipAddr, method, err)
+		return nil, err
+	}
 	cert, ok := pubKey.(*ssh.Certificate)
 	if ok {
 		if cert.CertType != ssh.UserCert {
@@ -805,19 +955,34 @@ func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubK
 			updateLoginMetrics(&user, ipAddr, method, err)
 			return nil, err
 		}
-		if err := c.certChecker.CheckCert(conn.User(), cert); err != nil {
+		principal, isMapped := c.getCertPrincipal(conn.User(), cert)
+		if err := c.certChecker.CheckCert(principal, cert); err != nil {
 			user.Username = conn.User()
 			updateLoginMetrics(&user, ipAddr, method, err)
 			return nil, err
 		}
+		if sourceAddrs, ok := cert.CriticalOptions[sourceAddressCriticalOption]; ok {
+			if err := checkCertSourceAddress(ipAddr, sourceAddrs); err != nil {
+				user.Username = conn.User()
+				updateLoginMetrics(&user, ipAddr, method, err)
+				return nil, err
+			}
+		}
+		if isMapped {
+			logger.Debug(logSender, connectionID, "certificate principal %#v mapped to user %#v", principal, conn.User())
+			principalInfo = fmt.Sprintf(" Principal: %v mapped to: %v", principal, conn.User())
+		}
 		certPerm = &cert.Permissions
 	}
 	if user, keyID, err = dataprovider.CheckUserAndPubKey(conn.User(), pubKey.Marshal(), ipAddr, common.ProtocolSSH); err == nil {
 		if user.IsPartialAuth(method) {
 			logger.Debug(logSender, connectionID, "user %#v authenticated with partial success", conn.User())
+			if certPerm != nil {
+				storePartialAuthCertOptions(connectionID, certPerm.CriticalOptions)
+			}
 			return certPerm, ssh.ErrPartialSuccess
 		}
-		sshPerm, err = loginUser(&user, method, keyID, conn)
+		sshPerm, err = loginUser(&user, method, keyID+principalInfo, conn)
 		if err == nil && certPerm != nil {
 			// if we have a SSH user cert we need to merge certificate permissions with our ones
 			// we only set Extensions, so CriticalOptions are always the ones from the certificate
@@ -834,6 +999,37 @@ func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubK
 	return sshPerm, err
 }
 
+// storePartialAuthCertOptions stores the certificate critical options for a partial
+// authentication, they will be applied after the authentication completes
+func storePartialAuthCertOptions(connectionID string, criticalOptions map[string]string) {
+	if len(criticalOptions) == 0 {
+		return
+	}
+	partialAuthCertOptions.Range(func(key, value interface{}) bool {
+		if time.Since(value.(partialAuthOptions).issuedAt) > partialAuthOptionsTimeout {
+			partialAuthCertOptions.Delete(key)
+		}
+		return true
+	})
+	partialAuthCertOptions.Store(connectionID, partialAuthOptions{
+		criticalOptions: criticalOptions,
+		issuedAt:        time.Now(),
+	})
+}
+
+// applyPartialAuthCertOptions sets the critical options, from the certificate used in
+// a previous authentication step, to the given permissions
+func applyPartialAuthCertOptions(conn ssh.ConnMetadata, sshPerm *ssh.Permissions) {
+	if sshPerm == nil || len(conn.PartialSuccessMethods()) == 0 {
+		return
+	}
+	connectionID := hex.EncodeToString(conn.SessionID())
+	if val, ok := partialAuthCertOptions.Load(connectionID); ok {
+		partialAuthCertOptions.Delete(connectionID)
+		sshPerm.CriticalOptions = val.(partialAuthOptions).criticalOptions
+	}
+}
+
 func (c *Configuration) validatePasswordCredentials(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
 	var err error
 	var user dataprovider.User
@@ -844,9 +1040,15 @@ func (c *Configuration) validatePasswordCredentials(conn ssh.ConnMetadata, pass
 		method = dataprovider.SSHLoginMethodKeyAndPassword
 	}
 	ipAddr := utils.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	if err = common.CheckLoginAttempt(ipAddr, common.ProtocolSSH); err != nil {
+		user.Username = conn.User()
+		updateLoginMetrics(&user, ipAddr, method, err)
+		return nil, err
+	}
 	if user, err = dataprovider.CheckUserAndPass(conn.User(), string(pass), ipAddr, common.ProtocolSSH); err == nil {
 		sshPerm, err = loginUser(&user, method, "", conn)
 	}
+	applyPartialAuthCertOptions(conn, sshPerm)
 	user.Username = conn.User()
 	updateLoginMetrics(&user, ipAddr, method, err)
 	return sshPerm, err
@@ -862,10 +1064,16 @@ func (c *Configuration) validateKeyboardInteractiveCredentials(conn ssh.ConnMeta
 		method = dataprovider.SSHLoginMethodKeyAndKeyboardInt
 	}
 	ipAddr := utils.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	if err = common.CheckLoginAttempt(ipAddr, common.ProtocolSSH); err != nil {
+		user.Username = conn.User()
+		updateLoginMetrics(&user, ipAddr, method, err)
+		return nil, err
+	}
 	if user, err = dataprovider.CheckKeyboardInteractiveAuth(conn.User(), c.KeyboardInteractiveHook, client,
 		ipAddr, common.ProtocolSSH); err == nil {
 		sshPerm, err = loginUser(&user, method, "", conn)
 	}
+	applyPartialAuthCertOptions(conn, sshPerm)
 	user.Username = conn.User()
 	updateLoginMetrics(&user, ipAddr, method, err)
 	return sshPerm, err
@@ -874,7 +1082,7 @@ func (c *Configuration) validateKeyboardInteractiveCredentials(conn ssh.ConnMeta
 func updateLoginMetrics(user *dataprovider.User, ip, method string, err error) {
 	metrics.AddLoginAttempt(method)
 	if err != nil {
-		logger.ConnectionFailedLog(user.Username, ip, method, common.ProtocolSSH, err.Error())
+		logger.ConnectionFailedLog(user.Username, ip, geoip.GetCountry(ip), method, common.ProtocolSSH, err.Error())
 		if method != dataprovider.SSHLoginMethodPublicKey {
 			// some clients try all available public keys for a user, we
 			// record failed login key auth only once for session if the
@@ -884,7 +1092,10 @@ func updateLoginMetrics(user *dataprovider.User, ip, method string, err error) {
 				event = common.HostEventUserNotFound
 			}
 			common.AddDefenderEvent(ip, event)
+			common.DelayFailedLogin(ip, user.Username)
 		}
+	} else {
+		common.ResetFailedLogins(ip, user.Username)
 	}
 	metrics.AddLoginResult(method, err)
 	dataprovider.ExecutePostLoginHook(user, method, ip, common.ProtocolSSH, err)
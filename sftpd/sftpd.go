@@ -51,7 +51,15 @@ func (s ServiceStatus) GetSSHCommandsAsString() string {
 
 // GetStatus returns the server status
 func GetStatus() ServiceStatus {
-	return serviceStatus
+	status := serviceStatus
+	status.HostKeys = loadedHostKeys.getStatus()
+	return status
+}
+
+// ReloadHostKeys reloads the host keys from the configured files.
+// The existing connections are not affected
+func ReloadHostKeys() error {
+	return loadedHostKeys.reload()
 }
 
 // GetDefaultSSHCommands returns the SSH commands enabled as default
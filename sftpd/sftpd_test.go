@@ -3,12 +3,14 @@ package sftpd_test
 import (
 	"bufio"
 	"bytes"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
@@ -33,6 +35,7 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/pkg/sftp"
+	"github.com/pquerna/otp/totp"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/ssh"
@@ -40,6 +43,7 @@ import (
 	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/config"
 	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/geoip"
 	"github.com/drakkan/sftpgo/httpdtest"
 	"github.com/drakkan/sftpgo/kms"
 	"github.com/drakkan/sftpgo/logger"
@@ -52,6 +56,7 @@ const (
 	logSender           = "sftpdTesting"
 	sftpServerAddr      = "127.0.0.1:2022"
 	sftpSrvAddr2222     = "127.0.0.1:2222"
+	sftpSrvAddr2226     = "127.0.0.1:2226"
 	defaultUsername     = "test_user_sftp"
 	defaultPassword     = "test_password"
 	defaultSFTPUsername = "test_sftpfs_user"
@@ -112,10 +117,13 @@ iixITGvaNZh/tjAAAACW5pY29sYUBwMQE=
 	// this is testPubKey signed using testCAUserKey but expired.
 	// % ssh-keygen -s ca_user_key -I test_user_sftp -n test_user_sftp -V 20100101123000:20110101123000 -z 4 /tmp/test.pub
 	testCertExpired = "ssh-rsa-cert-v01@openssh.com AAAAHHNzaC1yc2EtY2VydC12MDFAb3BlbnNzaC5jb20AAAAgU3TLP5285k20fBSsdZioI78oJUpaRXFlgx5IPg6gWg8AAAADAQABAAABgQC03jj0D+djk7pxIf/0OhrxrchJTRZklofJ1NoIu4752Sq02mdXmarMVsqJ1cAjV5LBVy3D1F5U6XW4rppkXeVtd04Pxb09ehtH0pRRPaoHHlALiJt8CoMpbKYMA8b3KXPPriGxgGomvtU2T2RMURSwOZbMtpsugfjYSWenyYX+VORYhylWnSXL961LTyC21ehd6d6QnW9G7E5hYMITMY9TuQZz3bROYzXiTsgN0+g6Hn7exFQp50p45StUMfV/SftCMdCxlxuyGny2CrN/vfjO7xxOo2uv7q1qm10Q46KPWJQv+pgZ/OfL+EDjy07n5QVSKHlbx+2nT4Q0EgOSQaCTYwn3YjtABfIxWwgAFdyj6YlPulCL22qU4MYhDcA6PSBwDdf8hvxBfvsiHdM+JcSHvv8/VeJhk6CmnZxGY0fxBupov27z3yEO8nAg8k+6PaUiW1MSUfuGMF/ktB8LOstXsEPXSszuyXiOv4DaryOXUiSn7bmRqKcEFlJusO6aZP0AAAAAAAAABAAAAAEAAAAOdGVzdF91c2VyX3NmdHAAAAASAAAADnRlc3RfdXNlcl9zZnRwAAAAAEs93LgAAAAATR8QOAAAAAAAAACCAAAAFXBlcm1pdC1YMTEtZm9yd2FyZGluZwAAAAAAAAAXcGVybWl0LWFnZW50LWZvcndhcmRpbmcAAAAAAAAAFnBlcm1pdC1wb3J0LWZvcndhcmRpbmcAAAAAAAAACnBlcm1pdC1wdHkAAAAAAAAADnBlcm1pdC11c2VyLXJjAAAAAAAAAAAAAAGXAAAAB3NzaC1yc2EAAAADAQABAAABgQDF5fcwZHiyixmnE6IlOZJpZhWXoh62gN+yadAA0GJ509SAEaZVLPDP8S5RsE8mUikR3wxynVshxHeqMhrkS+RlNbhSlOXDdNg94yTrq/xF8Z/PgKRInvef74k5i7bAIytza7jERzFJ/ujTEy3537T5k5EYQJ15ZQGuvzynSdv+6o99SjI4jFplyQOZ2QcYbEAmhHm5GgQlIiEFG/RlDtLksOulKZxOY3qPzP0AyQxtZJXn/5vG40aW9LTbwxCJqWlgrkFXMqAAVCbuU5YspwhiXmKt1PsldiXw23oloa4caCKN1jzbFiGuZNXEU2Ebx7JIvjQCPaUYwLjEbkRDxDqN/vmwZqBuKYiuG9Eafx+nFSQkr7QYb5b+mT+/1IFHnmeRGn38731kBqtH7tpzC/t+soRX9p2HtJM+9MYhblO2OqTSPGTlxihWUkyiRBekpAhaiHld16TsG+A3bOJHrojGcX+5g6oGarKGLAMcykL1X+rZqT993Mo6d2Z7q43MOXEAAAGUAAAADHJzYS1zaGEyLTUxMgAAAYAlH3hhj8J6xLyVpeLZjblzwDKrxp/MWiH30hQ965ExPrPRcoAZFEKVqOYdj6bp4Q19Q4Yzqdobg3aN5ym2iH0b2TlOY0mM901CAoHbNJyiLs+0KiFRoJ+30EDj/hcKusg6v8ln2yixPagAyQu3zyiWo4t1ZuO3I86xchGlptStxSdHAHPFCfpbhcnzWFZctiMqUutl82C4ROWyjOZcRzdVdWHeN5h8wnooXuvba2VkT8QPmjYYyRGuQ3Hg+ySdh8Tel4wiix1Dg5MX7Wjh4hKEx80No9UPy+0iyZMNc07lsWAtrY6NRxGM5CzB6mklscB8TzFrVSnIl9u3bquLfaCrFt/Mft5dR7Yy4jmF+zUhjia6h6giCZ91J+FZ4hV+WkBtPCvTfrGWoA1BgEB/iI2xOq/NPqJ7UXRoMXk/l0NPgRPT2JS1adegqnt4ddr6IlmPyZxaSEvXhanjKdfMlEFYO1wz7ouqpYUozQVy4KXBlzFlNwyD1hI+k4+/A6AIYeI= nicola@p1"
-	configDir       = ".."
-	osWindows       = "windows"
-	testFileName    = "test_file_sftp.dat"
-	testDLFileName  = "test_download_sftp.dat"
+	// GeoLite2-Country test database with these networks:
+	// 127.0.0.0/8 -> IT, 10.0.0.0/8 -> US, 192.168.1.0/24 -> FR
+	testGeoIPDatabase = "AAABAAAOAAACAAAIAAADAAAlAAAEAAAlAAAlAAAFAAAGAAAlAAAlAAAHAAA1AAAlAAAlAAAJAAAlAAAKAAAlAAALAAAlAAAMAAAlAAANAAAlAABLAAAlAAAPAAAQAAAlAAARAAAlAAASAAAlAAATAAAlAAAUAAAlAAAVAAAlAAAlAAAWAAAXAAAlAAAlAAAYAAAZAAAlAAAlAAAaAAAbAAAlAAAcAAAlAAAdAAAlAAAeAAAlAAAfAAAlAAAgAAAlAAAhAAAlAAAiAAAlAAAjAAAlAAAkAAAlAAAlAABhAAAAAAAAAAAAAAAAAAAAAOFHY291bnRyeeFIaXNvX2NvZGVCVVPhR2NvdW50cnnhSGlzb19jb2RlQklU4Udjb3VudHJ54Uhpc29fY29kZUJGUqvN701heE1pbmQuY29t6VtiaW5hcnlfZm9ybWF0X21ham9yX3ZlcnNpb26hAltiaW5hcnlfZm9ybWF0X21pbm9yX3ZlcnNpb26gS2J1aWxkX2Vwb2NoBAJf7mYATWRhdGFiYXNlX3R5cGVQR2VvTGl0ZTItQ291bnRyeUtkZXNjcmlwdGlvbuFCZW5UU0ZUUEdvIHRlc3QgZGF0YWJhc2VKaXBfdmVyc2lvbqEESWxhbmd1YWdlcwEEQmVuSm5vZGVfY291bnTBJUtyZWNvcmRfc2l6ZaEY"
+	configDir         = ".."
+	osWindows         = "windows"
+	testFileName      = "test_file_sftp.dat"
+	testDLFileName    = "test_download_sftp.dat"
 )
 
 var (
@@ -128,6 +136,8 @@ var (
 	pubKeyPath       string
 	privateKeyPath   string
 	trustedCAUserKey string
+	generatedCAKey   string
+	testCASigner     ssh.Signer
 	gitWrapPath      string
 	extAuthPath      string
 	keyIntAuthPath   string
@@ -218,7 +228,13 @@ func TestMain(m *testing.M) {
 	sftpdConf.KeyboardInteractiveHook = keyIntAuthPath
 
 	createInitialFiles(scriptArgs)
-	sftpdConf.TrustedUserCAKeys = append(sftpdConf.TrustedUserCAKeys, trustedCAUserKey)
+	sftpdConf.TrustedUserCAKeys = append(sftpdConf.TrustedUserCAKeys, trustedCAUserKey, generatedCAKey)
+	sftpdConf.CertPrincipalMappings = []sftpd.CertPrincipalMapping{
+		{
+			Principal: "sftpgo-*",
+			Usernames: []string{defaultUsername},
+		},
+	}
 
 	go func() {
 		logger.Debug(logSender, "", "initializing SFTP server with config %+v", sftpdConf)
@@ -275,14 +291,36 @@ func TestMain(m *testing.M) {
 	}()
 
 	waitTCPListening(sftpdConf.Bindings[0].GetAddress())
+
 	getHostKeysFingerprints(sftpdConf.HostKeys)
 
+	// the configuration is used after the initialization, we need a copy
+	sftpdConfKeyInt := sftpdConf
+	sftpdConfKeyInt.Bindings = []sftpd.Binding{
+		{
+			Port: 2226,
+		},
+	}
+	sftpdConfKeyInt.KeyboardInteractiveHook = ""
+	sftpdConfKeyInt.KeyboardInteractiveAuthentication = true
+	go func() {
+		logger.Debug(logSender, "", "initializing SFTP server with config %+v and built-in keyboard interactive auth",
+			sftpdConfKeyInt)
+		if err := sftpdConfKeyInt.Initialize(configDir); err != nil {
+			logger.ErrorToConsole("could not start SFTP server with built-in keyboard interactive auth: %v", err)
+			os.Exit(1)
+		}
+	}()
+
+	waitTCPListening(sftpdConfKeyInt.Bindings[0].GetAddress())
+
 	exitCode := m.Run()
 	os.Remove(logFilePath)
 	os.Remove(loginBannerFile)
 	os.Remove(pubKeyPath)
 	os.Remove(privateKeyPath)
 	os.Remove(trustedCAUserKey)
+	os.Remove(generatedCAKey)
 	os.Remove(gitWrapPath)
 	os.Remove(extAuthPath)
 	os.Remove(preLoginPath)
@@ -389,6 +427,32 @@ func TestBasicSFTPHandling(t *testing.T) {
 	assert.NotEmpty(t, sshCommands)
 }
 
+func TestReloadHostKeys(t *testing.T) {
+	usePubKey := true
+	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer client.Close()
+		hostKeys := sftpd.GetStatus().HostKeys
+		assert.NotEmpty(t, hostKeys)
+		err = sftpd.ReloadHostKeys()
+		assert.NoError(t, err)
+		assert.Equal(t, hostKeys, sftpd.GetStatus().HostKeys)
+		// the existing connections are not affected
+		assert.NoError(t, checkBasicSFTP(client))
+		newClient, err := getSftpClient(user, usePubKey)
+		if assert.NoError(t, err) {
+			assert.NoError(t, checkBasicSFTP(newClient))
+			newClient.Close()
+		}
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestBasicSFTPFsHandling(t *testing.T) {
 	usePubKey := true
 	baseUser, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
@@ -522,6 +586,40 @@ func TestDefender(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestDefenderProtocolViolation(t *testing.T) {
+	oldConfig := config.GetCommonConfig()
+
+	cfg := config.GetCommonConfig()
+	cfg.DefenderConfig.Enabled = true
+	cfg.DefenderConfig.Threshold = 10
+	cfg.DefenderConfig.ScoreProtocolViolation = 3
+
+	err := common.Initialize(cfg)
+	assert.NoError(t, err)
+
+	conn, err := net.Dial("tcp", sftpServerAddr)
+	if assert.NoError(t, err) {
+		// an overlong identification string is a protocol violation
+		_, err = conn.Write(bytes.Repeat([]byte("a"), 512))
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			return common.GetDefenderScore("127.0.0.1") == 3
+		}, 1*time.Second, 50*time.Millisecond)
+		conn.Close()
+	}
+	// closing the connection without authenticating is scored as invalid
+	conn, err = net.Dial("tcp", sftpServerAddr)
+	if assert.NoError(t, err) {
+		conn.Close()
+		assert.Eventually(t, func() bool {
+			return common.GetDefenderScore("127.0.0.1") == 3+cfg.DefenderConfig.ScoreInvalid
+		}, 1*time.Second, 50*time.Millisecond)
+	}
+
+	err = common.Initialize(oldConfig)
+	assert.NoError(t, err)
+}
+
 func TestOpenReadWrite(t *testing.T) {
 	usePubKey := false
 	u := getTestUser(usePubKey)
@@ -780,6 +878,66 @@ func TestUploadResume(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestPartialUploadResume(t *testing.T) {
+	oldUploadMode := common.Config.UploadMode
+	common.Config.UploadMode = common.UploadModeAtomicWithResume
+	defer func() {
+		common.Config.UploadMode = oldUploadMode
+	}()
+
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Filters.PartialUploads.Policy = dataprovider.PartialUploadsKeep
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer client.Close()
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65535)
+		err = createTestFile(testFilePath, testFileSize)
+		assert.NoError(t, err)
+		data, err := os.ReadFile(testFilePath)
+		assert.NoError(t, err)
+		// simulate an interrupted upload kept with the partial suffix
+		partialPath := filepath.Join(user.GetHomeDir(), testFileName+".partial")
+		err = os.MkdirAll(user.GetHomeDir(), os.ModePerm)
+		assert.NoError(t, err)
+		err = os.WriteFile(partialPath, data[:1000], os.ModePerm)
+		assert.NoError(t, err)
+
+		destFile, err := client.OpenFile(testFileName, os.O_WRONLY|os.O_APPEND)
+		if assert.NoError(t, err) {
+			_, err = destFile.Seek(1000, io.SeekStart)
+			assert.NoError(t, err)
+			_, err = destFile.Write(data[1000:])
+			assert.NoError(t, err)
+			err = destFile.Close()
+			assert.NoError(t, err)
+		}
+		assert.NoFileExists(t, partialPath)
+		info, err := client.Stat(testFileName)
+		if assert.NoError(t, err) {
+			assert.Equal(t, testFileSize, info.Size())
+		}
+		uploaded, err := os.ReadFile(filepath.Join(user.GetHomeDir(), testFileName))
+		assert.NoError(t, err)
+		assert.Equal(t, data, uploaded)
+		// without a partial file a new upload starts
+		err = client.Remove(testFileName)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+
+		err = os.Remove(testFilePath)
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestDirCommands(t *testing.T) {
 	usePubKey := false
 	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
@@ -1341,6 +1499,7 @@ func TestLogin(t *testing.T) {
 		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
 		assert.NoError(t, err)
 		assert.Greater(t, user.LastLogin, int64(0), "last login must be updated after a successful login: %v", user.LastLogin)
+		assert.Equal(t, common.ProtocolSSH, user.LastLoginProtocol)
 	}
 	client, err = getSftpClient(user, true)
 	if assert.NoError(t, err) {
@@ -1441,6 +1600,101 @@ func TestLoginUserCert(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestLoginUserCertPrincipalMapping(t *testing.T) {
+	mappedCert, mappedSigner, err := getSignedUserCert(nil, "sftpgo-ops")
+	assert.NoError(t, err)
+	otherCert, otherSigner, err := getSignedUserCert(nil, "ops")
+	assert.NoError(t, err)
+	u := getTestUser(true)
+	u.PublicKeys = []string{mappedCert, otherCert}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	client, err := getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(mappedSigner)}, "")
+	if assert.NoError(t, err) {
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(otherSigner)}, "")
+	if !assert.Error(t, err) {
+		client.Close()
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	// the mapping is not defined for this user
+	u.Username += "1"
+	user, _, err = httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(mappedSigner)}, "")
+	if !assert.Error(t, err) {
+		client.Close()
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestLoginUserCertForceCommand(t *testing.T) {
+	forcedCert, forcedSigner, err := getSignedUserCert(map[string]string{
+		"force-command": "pwd",
+	}, defaultUsername)
+	assert.NoError(t, err)
+	sftpOnlyCert, sftpOnlySigner, err := getSignedUserCert(map[string]string{
+		"force-command":  "internal-sftp",
+		"source-address": "127.0.0.0/8, ::1",
+	}, defaultUsername)
+	assert.NoError(t, err)
+	u := getTestUser(true)
+	u.Password = defaultPassword
+	u.PublicKeys = []string{forcedCert, sftpOnlyCert}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	// SFTP is not allowed if a command is forced
+	client, err := getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(forcedSigner)}, "")
+	if !assert.Error(t, err) {
+		client.Close()
+	}
+	out, err := runSSHCommandWithAuth("md5sum", user, []ssh.AuthMethod{ssh.PublicKeys(forcedSigner)})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/\n", string(out))
+	}
+	client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(sftpOnlySigner)}, "")
+	if assert.NoError(t, err) {
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	_, err = runSSHCommandWithAuth("pwd", user, []ssh.AuthMethod{ssh.PublicKeys(sftpOnlySigner)})
+	assert.Error(t, err)
+	// the forced command must be honored in multi-step authentication too
+	user.Filters.DeniedLoginMethods = []string{
+		dataprovider.SSHLoginMethodPublicKey,
+		dataprovider.LoginMethodPassword,
+		dataprovider.SSHLoginMethodKeyboardInteractive,
+		dataprovider.SSHLoginMethodKeyAndKeyboardInt,
+	}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	authMethods := []ssh.AuthMethod{
+		ssh.PublicKeys(forcedSigner),
+		ssh.Password(defaultPassword),
+	}
+	client, err = getCustomAuthSftpClient(user, authMethods, "")
+	if !assert.Error(t, err) {
+		client.Close()
+	}
+	out, err = runSSHCommandWithAuth("sha256sum", user, authMethods)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/\n", string(out))
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestMultiStepLoginKeyAndPwd(t *testing.T) {
 	u := getTestUser(true)
 	u.Password = defaultPassword
@@ -1712,6 +1966,255 @@ func TestLoginWithDatabaseCredentials(t *testing.T) {
 	assert.NoError(t, dataprovider.Initialize(providerConf, configDir, true))
 }
 
+func TestBcryptPasswordHashing(t *testing.T) {
+	providerConf := config.GetProviderConf()
+	assert.NoError(t, dataprovider.Close())
+
+	providerConf.PasswordHashing.Algo = "unsupported"
+	err := dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	providerConf.PasswordHashing.Algo = dataprovider.HashingAlgoBcrypt
+	providerConf.PasswordHashing.BcryptOptions.Cost = 100
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	providerConf.PasswordHashing.BcryptOptions.Cost = 4
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
+	usePubKey := false
+	user, body, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err, string(body))
+	dbUser, err := dataprovider.UserExists(user.Username)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(dbUser.Password, "$2a$04$"))
+	client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	user.Password = "wrong password"
+	_, err = getSftpClient(user, usePubKey)
+	assert.Error(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	assert.NoError(t, dataprovider.Close())
+	assert.NoError(t, config.LoadConfig(configDir, ""))
+	providerConf = config.GetProviderConf()
+	assert.NoError(t, dataprovider.Initialize(providerConf, configDir, true))
+}
+
+func TestLDAPAuthConfig(t *testing.T) {
+	providerConf := config.GetProviderConf()
+	assert.NoError(t, dataprovider.Close())
+
+	providerConf.LDAPAuth.Domains = []dataprovider.LDAPDomain{
+		{
+			Name:   "example",
+			URL:    "http://127.0.0.1:3899",
+			BaseDN: "dc=example,dc=com",
+		},
+	}
+	err := dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	providerConf.LDAPAuth.Domains[0].URL = "ldap://127.0.0.1:3899"
+	providerConf.LDAPAuth.Domains[0].UserFilter = "(uid=missing)"
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	providerConf.LDAPAuth.Domains[0].UserFilter = ""
+	providerConf.LDAPAuth.Domains[0].Permissions = []dataprovider.LDAPPermissions{
+		{
+			Path:        "/",
+			Permissions: []string{"invalid"},
+		},
+	}
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	providerConf.LDAPAuth.Domains[0].Permissions[0].Permissions = []string{dataprovider.PermAny}
+	providerConf.LDAPAuth.Domains = append(providerConf.LDAPAuth.Domains, providerConf.LDAPAuth.Domains[0])
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.Error(t, err)
+	providerConf.LDAPAuth.Domains = providerConf.LDAPAuth.Domains[:1]
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
+	usePubKey := false
+	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	// usernames without a matching domain use the configured data provider
+	client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	// the LDAP server is not reachable
+	ldapUser := user
+	ldapUser.Username += "@example"
+	_, err = getSftpClient(ldapUser, usePubKey)
+	assert.Error(t, err)
+	ldapUser.Username = "EXAMPLE\\" + user.Username
+	_, err = getSftpClient(ldapUser, usePubKey)
+	assert.Error(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+
+	assert.NoError(t, dataprovider.Close())
+	assert.NoError(t, config.LoadConfig(configDir, ""))
+	providerConf = config.GetProviderConf()
+	assert.NoError(t, dataprovider.Initialize(providerConf, configDir, true))
+}
+
+func TestUsersCache(t *testing.T) {
+	providerConf := config.GetProviderConf()
+	assert.NoError(t, dataprovider.Close())
+
+	providerConf.UsersCache.ExpirationTime = 60
+	providerConf.UsersCache.MaxSize = 1
+	err := dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
+	usePubKey := false
+	user, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestUser(true)
+	u.Username += "_1"
+	user1, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		client, err := getSftpClient(user, usePubKey)
+		if assert.NoError(t, err) {
+			assert.NoError(t, checkBasicSFTP(client))
+			client.Close()
+		}
+		client, err = getSftpClient(user1, true)
+		if assert.NoError(t, err) {
+			assert.NoError(t, checkBasicSFTP(client))
+			client.Close()
+		}
+	}
+	// updating the user must invalidate the cached entry
+	user.Status = 0
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, err = getSftpClient(user, usePubKey)
+	assert.Error(t, err)
+	user.Status = 1
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+	}
+	user.Password = "wrong password"
+	_, err = getSftpClient(user, usePubKey)
+	assert.Error(t, err)
+	user.Password = defaultPassword
+
+	_, err = httpdtest.RemoveCachedUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.ClearUsersCache(http.StatusOK)
+	assert.NoError(t, err)
+	client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = getSftpClient(user, usePubKey)
+	assert.Error(t, err)
+	_, err = httpdtest.RemoveUser(user1, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user1.GetHomeDir())
+	assert.NoError(t, err)
+
+	assert.NoError(t, dataprovider.Close())
+	assert.NoError(t, config.LoadConfig(configDir, ""))
+	providerConf = config.GetProviderConf()
+	assert.NoError(t, dataprovider.Initialize(providerConf, configDir, true))
+}
+
+func TestCaseInsensitiveUsernames(t *testing.T) {
+	providerConf := config.GetProviderConf()
+	assert.NoError(t, dataprovider.Close())
+
+	providerConf.CaseInsensitiveUsernames = true
+	err := dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Username = "Mixed_Case_User"
+	u.HomeDir = filepath.Join(homeBasePath, "mixed_case_user")
+	err = dataprovider.AddUser(&u)
+	assert.NoError(t, err)
+	user, _, err := httpdtest.GetUserByUsername("MIXED_case_user", http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, "mixed_case_user", user.Username)
+	u.Username = "MIXED_CASE_USER"
+	err = dataprovider.AddUser(&u)
+	assert.Error(t, err)
+
+	for _, username := range []string{"MIXED_CASE_USER", "mixed_case_user", "Mixed_Case_User"} {
+		user.Username = username
+		client, err := getSftpClient(user, usePubKey)
+		if assert.NoError(t, err, username) {
+			assert.NoError(t, checkBasicSFTP(client))
+			client.Close()
+		}
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(filepath.Join(homeBasePath, "mixed_case_user"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, dataprovider.Close())
+	assert.NoError(t, config.LoadConfig(configDir, ""))
+	providerConf = config.GetProviderConf()
+	assert.NoError(t, dataprovider.Initialize(providerConf, configDir, true))
+}
+
+func TestManualUpdateMode(t *testing.T) {
+	providerConf := config.GetProviderConf()
+	if providerConf.Driver == dataprovider.MemoryDataProviderName || providerConf.Driver == dataprovider.BoltDataProviderName {
+		t.Skip("this test is not supported for memory and bolt providers")
+	}
+	assert.NoError(t, dataprovider.Close())
+
+	err := dataprovider.RevertDatabase(providerConf, configDir, 8)
+	assert.NoError(t, err)
+	assert.NoError(t, dataprovider.Close())
+	providerConf.UpdateMode = 1
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "initprovider")
+	}
+	err = dataprovider.InitializeDatabase(providerConf, configDir)
+	assert.NoError(t, err)
+	assert.NoError(t, dataprovider.Close())
+	err = dataprovider.InitializeDatabase(providerConf, configDir)
+	assert.ErrorIs(t, err, dataprovider.ErrNoInitRequired)
+	assert.NoError(t, dataprovider.Close())
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+
+	assert.NoError(t, dataprovider.Close())
+	assert.NoError(t, config.LoadConfig(configDir, ""))
+	providerConf = config.GetProviderConf()
+	assert.NoError(t, dataprovider.Initialize(providerConf, configDir, true))
+}
+
 func TestLoginInvalidFs(t *testing.T) {
 	usePubKey := true
 	u := getTestUser(usePubKey)
@@ -1881,6 +2384,112 @@ func TestLoginAfterUserUpdateEmptyPubKey(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestLoginTOTP(t *testing.T) {
+	secret, _, _, err := dataprovider.GenerateTOTPSecret(defaultUsername)
+	assert.NoError(t, err)
+	u := getTestUser(false)
+	u.Filters.TOTPConfig = dataprovider.TOTPConfig{
+		Enabled:   true,
+		Secret:    kms.NewPlainSecret(secret),
+		Protocols: []string{common.ProtocolSSH},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	codes, recoveryCodes, err := dataprovider.GenerateRecoveryCodes()
+	assert.NoError(t, err)
+	dbUser, err := dataprovider.UserExists(user.Username)
+	assert.NoError(t, err)
+	dbUser.Filters.RecoveryCodes = recoveryCodes
+	err = dataprovider.UpdateUser(&dbUser)
+	assert.NoError(t, err)
+
+	_, err = getSftpClient(user, false)
+	assert.Error(t, err, "login without passcode must fail")
+	passcode, err := totp.GenerateCode(secret, time.Now())
+	assert.NoError(t, err)
+	user.Password = defaultPassword + passcode
+	client, err := getSftpClient(user, false)
+	if assert.NoError(t, err) {
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	// a passcode cannot be reused
+	_, err = getSftpClient(user, false)
+	assert.Error(t, err, "a passcode must be used only once")
+	// a recovery code can be used only once
+	user.Password = defaultPassword + codes[0]
+	client, err = getSftpClient(user, false)
+	if assert.NoError(t, err) {
+		client.Close()
+	}
+	_, err = getSftpClient(user, false)
+	assert.Error(t, err, "a recovery code must be used only once")
+	user.Password = "wrong" + codes[1]
+	_, err = getSftpClient(user, false)
+	assert.Error(t, err)
+	// the recovery code must not be consumed if the password is wrong
+	user.Password = defaultPassword + codes[1]
+	client, err = getSftpClient(user, false)
+	if assert.NoError(t, err) {
+		client.Close()
+	}
+	// built-in keyboard interactive authentication, the passcode for the next
+	// period is accepted too
+	passcode, err = totp.GenerateCode(secret, time.Now().Add(30*time.Second))
+	assert.NoError(t, err)
+	client, err = getBuiltinKeyboardInteractiveSftpClient(user, []string{defaultPassword, passcode})
+	if assert.NoError(t, err) {
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	_, err = getBuiltinKeyboardInteractiveSftpClient(user, []string{defaultPassword, "123"})
+	assert.Error(t, err)
+	_, err = getBuiltinKeyboardInteractiveSftpClient(user, []string{"wrong", passcode})
+	assert.Error(t, err)
+	_, err = getBuiltinKeyboardInteractiveSftpClient(user, []string{defaultPassword, passcode})
+	assert.Error(t, err, "a passcode must be used only once")
+	client, err = getBuiltinKeyboardInteractiveSftpClient(user, []string{defaultPassword, codes[2]})
+	if assert.NoError(t, err) {
+		client.Close()
+	}
+	_, err = getBuiltinKeyboardInteractiveSftpClient(user, []string{defaultPassword, codes[2]})
+	assert.Error(t, err)
+
+	dbUser, err = dataprovider.UserExists(user.Username)
+	assert.NoError(t, err)
+	unused := 0
+	for _, code := range dbUser.Filters.RecoveryCodes {
+		if !code.Used {
+			unused++
+		}
+	}
+	assert.Equal(t, len(codes)-3, unused)
+	// TOTP is not required for other protocols, the built-in keyboard interactive
+	// authentication asks only for the password if TOTP is not required
+	user.Filters.TOTPConfig.Protocols = []string{common.ProtocolFTP}
+	user.Password = defaultPassword
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	client, err = getSftpClient(user, false)
+	if assert.NoError(t, err) {
+		client.Close()
+	}
+	client, err = getBuiltinKeyboardInteractiveSftpClient(user, []string{defaultPassword})
+	if assert.NoError(t, err) {
+		client.Close()
+	}
+	user.Filters.DeniedLoginMethods = []string{dataprovider.LoginMethodPassword}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, err = getBuiltinKeyboardInteractiveSftpClient(user, []string{defaultPassword})
+	assert.Error(t, err, "the built-in keyboard interactive auth requires password login")
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestLoginKeyboardInteractiveAuth(t *testing.T) {
 	if runtime.GOOS == osWindows {
 		t.Skip("this test is not available on Windows")
@@ -2895,19 +3504,80 @@ func TestUploadMaxSize(t *testing.T) {
 	client, err := getSftpClient(user, usePubKey)
 	if assert.NoError(t, err) {
 		defer client.Close()
-		err = sftpUploadFile(testFilePath1, testFileName1, testFileSize1, client)
-		assert.Error(t, err)
+		err = sftpUploadFile(testFilePath1, testFileName1, testFileSize1, client)
+		assert.Error(t, err)
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		// now test overwrite an existing file with a size bigger than the allowed one
+		err = createTestFile(filepath.Join(user.GetHomeDir(), testFileName1), testFileSize1)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath1, testFileName1, testFileSize1, client)
+		assert.Error(t, err)
+	}
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+	err = os.Remove(testFilePath1)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestTransferQuota(t *testing.T) {
+	testFileSize := int64(65535)
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.Filters.TransferQuota = dataprovider.TransferQuota{
+		Period:       dataprovider.TransferQuotaPeriodDay,
+		UploadSize:   testFileSize + 1,
+		DownloadSize: testFileSize + 1,
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+	localDownloadPath := filepath.Join(homeBasePath, testDLFileName)
+	client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer client.Close()
 		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
 		assert.NoError(t, err)
-		// now test overwrite an existing file with a size bigger than the allowed one
-		err = createTestFile(filepath.Join(user.GetHomeDir(), testFileName1), testFileSize1)
+		err = sftpUploadFile(testFilePath, testFileName+".1", testFileSize, client)
+		assert.Error(t, err)
+		err = sftpDownloadFile(testFileName, localDownloadPath, testFileSize, client)
 		assert.NoError(t, err)
-		err = sftpUploadFile(testFilePath1, testFileName1, testFileSize1, client)
+		err = sftpDownloadFile(testFileName, localDownloadPath, testFileSize, client)
 		assert.Error(t, err)
 	}
+	usedUL, usedDL, err := dataprovider.GetUsedTransferQuota(&user)
+	assert.NoError(t, err)
+	assert.Greater(t, usedUL, testFileSize)
+	assert.Greater(t, usedDL, testFileSize)
+	// the used data transfer is preserved on user update
+	user.Filters.TransferQuota.UploadSize = testFileSize * 2
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	usedUL1, usedDL1, err := dataprovider.GetUsedTransferQuota(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, usedUL, usedUL1)
+	assert.Equal(t, usedDL, usedDL1)
+	// remove the limits, transfers must work again
+	user.Filters.TransferQuota = dataprovider.TransferQuota{}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer client.Close()
+		err = sftpUploadFile(testFilePath, testFileName+".1", testFileSize, client)
+		assert.NoError(t, err)
+		err = sftpDownloadFile(testFileName, localDownloadPath, testFileSize, client)
+		assert.NoError(t, err)
+	}
 	err = os.Remove(testFilePath)
 	assert.NoError(t, err)
-	err = os.Remove(testFilePath1)
+	err = os.Remove(localDownloadPath)
 	assert.NoError(t, err)
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
@@ -2974,6 +3644,141 @@ func TestBandwidthAndConnections(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestMaxConcurrentTransfers(t *testing.T) {
+	usePubKey := false
+	testFileSize := int64(131072)
+	u := getTestUser(usePubKey)
+	u.UploadBandwidth = 64
+	u.Filters.MaxConcurrentTransfers = 1
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer client.Close()
+		client1, err := getSftpClient(user, usePubKey)
+		if assert.NoError(t, err) {
+			defer client1.Close()
+			testFilePath := filepath.Join(homeBasePath, testFileName)
+			err = createTestFile(testFilePath, testFileSize)
+			assert.NoError(t, err)
+			c := sftpUploadNonBlocking(testFilePath, testFileName, testFileSize, client)
+			waitForActiveTransfers(t)
+			// the limit applies to all the user sessions
+			err = sftpUploadFile(testFilePath, testFileName+"_1", testFileSize, client1)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), common.ErrTooManyTransfers.Error())
+			}
+			err = <-c
+			assert.NoError(t, err)
+			assert.Eventually(t, func() bool {
+				return common.Connections.GetActiveTransfers(user.Username) == 0
+			}, 1*time.Second, 50*time.Millisecond)
+			localDownloadPath := filepath.Join(homeBasePath, testDLFileName)
+			err = sftpDownloadFile(testFileName, localDownloadPath, testFileSize, client1)
+			assert.NoError(t, err)
+			err = os.Remove(testFilePath)
+			assert.NoError(t, err)
+			err = os.Remove(localDownloadPath)
+			assert.NoError(t, err)
+		}
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestDirectoryLimits(t *testing.T) {
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Filters.DirectoryLimits = []dataprovider.DirectoryLimit{
+		{
+			Path:     "/",
+			MaxFiles: 2,
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer client.Close()
+		testFileSize := int64(65535)
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		err = createTestFile(testFilePath, testFileSize)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		err = client.Mkdir("adir")
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, testFileName+"_1", testFileSize, client)
+		assert.Error(t, err)
+		err = client.Mkdir("adir1")
+		assert.Error(t, err)
+		// overwriting an existing file is allowed
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		// the limit does not apply to sub directories
+		err = sftpUploadFile(testFilePath, path.Join("adir", testFileName), testFileSize, client)
+		assert.NoError(t, err)
+		err = os.Remove(testFilePath)
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestBandwidthLimitsOverride(t *testing.T) {
+	usePubKey := false
+	testFileSize := int64(131072)
+	u := getTestUser(usePubKey)
+	u.UploadBandwidth = 1
+	u.DownloadBandwidth = 1
+	u.Filters.BandwidthLimits = []dataprovider.BandwidthLimit{
+		{
+			Sources: []string{"10.8.0.0/16"},
+		},
+		{
+			Sources:           []string{"127.0.0.0/8", "::1/128"},
+			UploadBandwidth:   0,
+			DownloadBandwidth: 128,
+		},
+	}
+	wantedDownloadElapsed := 1000 * (testFileSize / 1024) / 128
+	// 100 ms tolerance
+	wantedDownloadElapsed -= 100
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer client.Close()
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		err = createTestFile(testFilePath, testFileSize)
+		assert.NoError(t, err)
+		// the upload is unthrottled, with the user bandwidth it would take more than 2 minutes
+		startTime := time.Now()
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		assert.Less(t, time.Since(startTime), 10*time.Second)
+		startTime = time.Now()
+		localDownloadPath := filepath.Join(homeBasePath, testDLFileName)
+		err = sftpDownloadFile(testFileName, localDownloadPath, testFileSize, client)
+		assert.NoError(t, err)
+		elapsed := time.Since(startTime).Nanoseconds() / 1000000
+		assert.GreaterOrEqual(t, elapsed, wantedDownloadElapsed, "download bandwidth throttling not respected")
+		assert.Less(t, time.Since(startTime), 10*time.Second)
+		err = os.Remove(testFilePath)
+		assert.NoError(t, err)
+		err = os.Remove(localDownloadPath)
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 //nolint:dupl
 func TestPatternsFilters(t *testing.T) {
 	usePubKey := true
@@ -5206,6 +6011,11 @@ func TestHashedPasswords(t *testing.T) {
 	pwdMapping["$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK"] = "secret"
 	pwdMapping["$6$459ead56b72e44bc$uog86fUxscjt28BZxqFBE2pp2QD8P/1e98MNF75Z9xJfQvOckZnQ/1YJqiq1XeytPuDieHZvDAMoP7352ELkO1"] = "secret"
 	pwdMapping["$apr1$OBWLeSme$WoJbB736e7kKxMBIAqilb1"] = "password"
+	pwdMapping["{SSHA}fdJJ7muPnTQro0lVYDXRGGmqx69zZnRwZ28xMg=="] = "password"
+	pwdMapping["{SSHA256}rjsQBdUSKRDmjRg86n4AJEeh/vkbo6XWPmm7TIvMhLJzZnRwZ28xMg=="] = "password"
+	pwdMapping["{SSHA512}UyiotSYiS+8Zx0X1QZxzxhOXLDlkVnPyYWE+jD6aRlWE3nQIQAT98kpO1YDj0yr1uRGVSGouJ/Evrr5zNw8KOnNmdHBnbzEy"] = "password"
+	pwdMapping["pbkdf2_sha256$260000$seasalt_Xy$LHAv7hd02L6j9f8bEnu1MJeQ9AwjYgNBXOtu8Dn09bg="] = "password"
+	pwdMapping["pbkdf2_sha1$150000$Gk3mP9$PrWOmcrthm9Ppbx407o/UK3wI2M="] = "password"
 
 	for pwd, clearPwd := range pwdMapping {
 		u := getTestUser(usePubKey)
@@ -5230,6 +6040,31 @@ func TestHashedPasswords(t *testing.T) {
 	}
 }
 
+func TestInvalidHashedPasswords(t *testing.T) {
+	usePubKey := false
+	invalidHashes := []string{
+		"{SSHA}aW52YWxpZA==",
+		"{SSHA256}not base64",
+		"pbkdf2_sha256$260000$salt",
+		"pbkdf2_sha1$iterations$salt$PrWOmcrthm9Ppbx407o/UK3wI2M=",
+	}
+	for _, pwd := range invalidHashes {
+		u := getTestUser(usePubKey)
+		u.Password = pwd
+		user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+		assert.NoError(t, err)
+		user.Password = "password"
+		client, err := getSftpClient(user, usePubKey)
+		if !assert.Error(t, err, "login with invalid hash %#v must fail", pwd) {
+			client.Close()
+		}
+		_, err = httpdtest.RemoveUser(user, http.StatusOK)
+		assert.NoError(t, err)
+		err = os.RemoveAll(user.GetHomeDir())
+		assert.NoError(t, err)
+	}
+}
+
 func TestPasswordsHashPbkdf2Sha256_389DS(t *testing.T) {
 	pbkdf389dsPwd := "{PBKDF2_SHA256}AAAIAMZIKG4ie44zJY4HOXI+upFR74PzWLUQV63jg+zzkbEjCK3N4qW583WF7EdcpeoOMQ4HY3aWEXB6lnXhXJixbJkU4vVSJkL6YCbU3TrD0qn1uUUVSkaIgAOtmZENitwbhYhiWfEzGyAtFqkFd75P5xhWJEog9XhQKYrR0f7S3WGGZq03JRcLJ460xpU97bE/sWRn7sshgkWzLuyrs0I+XRKmK7FJeaA9zd+1m44Y3IVmZ2YLdKATzjRHAIgpBC6i1TWOcpKJT1+feP1C9hrxH8vU9baw9thNiO8jSHaZlwb//KpJFe0ahVnG/1ubiG8cO0+CCqDqXVJR6Vr4QZxHP+4pwooW+4TP/L+HFdyA1y6z4gKfqYnBsmb3sD1R1TbxfH4btTdvgZAnBk9CmR3QASkFXxeTYsrmNd5+9IAHc6dm"
 	pbkdf389dsPwd = pbkdf389dsPwd[15:]
@@ -6334,6 +7169,69 @@ func TestUserFiltersIPMaskConditions(t *testing.T) {
 	assert.True(t, user.IsLoginFromAddrAllowed("invalid"))
 }
 
+func TestUserFiltersCountries(t *testing.T) {
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Filters.AllowedCountries = []string{"US", "FR"}
+	// without a GeoIP database the country cannot be determined
+	assert.False(t, u.IsLoginFromAddrAllowed("127.0.0.1"))
+	u.Filters.AllowedCountries = nil
+	u.Filters.DeniedCountries = []string{"IT"}
+	assert.True(t, u.IsLoginFromAddrAllowed("127.0.0.1"))
+
+	data, err := base64.StdEncoding.DecodeString(testGeoIPDatabase)
+	assert.NoError(t, err)
+	dbPath := filepath.Join(os.TempDir(), "GeoLite2-Country.mmdb")
+	err = ioutil.WriteFile(dbPath, data, os.ModePerm)
+	assert.NoError(t, err)
+	geoIPConf := geoip.Config{
+		DatabaseFile: dbPath,
+	}
+	err = geoIPConf.Initialize(configDir)
+	assert.NoError(t, err)
+	defer func() {
+		geoIPConf = geoip.Config{}
+		err = geoIPConf.Initialize(configDir)
+		assert.NoError(t, err)
+		err = os.Remove(dbPath)
+		assert.NoError(t, err)
+	}()
+
+	assert.False(t, u.IsLoginFromAddrAllowed("127.0.0.1"))
+	assert.True(t, u.IsLoginFromAddrAllowed("10.1.1.1"))
+	assert.True(t, u.IsLoginFromAddrAllowed("172.16.1.1"))
+	u.Filters.AllowedCountries = []string{"US", "FR"}
+	u.Filters.DeniedCountries = nil
+	assert.False(t, u.IsLoginFromAddrAllowed("127.0.0.1"))
+	assert.True(t, u.IsLoginFromAddrAllowed("10.1.1.1"))
+	assert.True(t, u.IsLoginFromAddrAllowed("192.168.1.1"))
+	assert.False(t, u.IsLoginFromAddrAllowed("172.16.1.1"))
+	// the IP filters are still applied
+	u.Filters.DeniedIP = []string{"192.168.1.0/24"}
+	assert.False(t, u.IsLoginFromAddrAllowed("192.168.1.1"))
+	u.Filters.DeniedIP = nil
+
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	client, err := getSftpClient(user, usePubKey)
+	if !assert.Error(t, err, "login from a not allowed country must fail") {
+		client.Close()
+	}
+	user.Filters.AllowedCountries = []string{"IT"}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	client, err = getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestGetVirtualFolderForPath(t *testing.T) {
 	user := getTestUser(true)
 	mappedPath1 := filepath.Join(os.TempDir(), "vpath1")
@@ -6868,6 +7766,54 @@ func TestSSHCopyPermissions(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestRenameAndCopyDirectoryLimits(t *testing.T) {
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Filters.DirectoryLimits = []dataprovider.DirectoryLimit{
+		{
+			Path:     "/limited",
+			MaxFiles: 1,
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer client.Close()
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		testFileSize := int64(65535)
+		err = createTestFile(testFilePath, testFileSize)
+		assert.NoError(t, err)
+		err = client.Mkdir("limited")
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, path.Join("limited", testFileName), testFileSize, client)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		err = client.Rename(testFileName, path.Join("limited", testFileName+"1"))
+		assert.Error(t, err)
+		_, err = runSSHCommand(fmt.Sprintf("sftpgo-copy %v %v", testFileName, path.Join("/limited", testFileName+"1")),
+			user, usePubKey)
+		assert.Error(t, err)
+		_, err = client.Stat(path.Join("limited", testFileName+"1"))
+		assert.Error(t, err)
+		// renaming inside the limited directory is allowed
+		err = client.Rename(path.Join("limited", testFileName), path.Join("limited", testFileName+"1"))
+		assert.NoError(t, err)
+		out, err := runSSHCommand(fmt.Sprintf("sftpgo-copy %v %v", testFileName, testFileName+"1"), user, usePubKey)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "OK\n", string(out))
+		}
+
+		err = os.Remove(testFilePath)
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestSSHCopyQuotaLimits(t *testing.T) {
 	usePubKey := true
 	testFileSize := int64(131072)
@@ -8232,6 +9178,54 @@ func getSignerForUserCert(certBytes []byte) (ssh.Signer, error) {
 	return ssh.NewCertSigner(cert.(*ssh.Certificate), signer)
 }
 
+// getSignedUserCert returns testPubKey signed using the generated CA, the certificate
+// in authorized keys format and a signer to authenticate using it
+func getSignedUserCert(criticalOptions map[string]string, principals ...string) (string, ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(testPrivateKey))
+	if err != nil {
+		return "", nil, err
+	}
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		Serial:          1,
+		CertType:        ssh.UserCert,
+		KeyId:           "test cert",
+		ValidPrincipals: principals,
+		ValidBefore:     ssh.CertTimeInfinity,
+		Permissions: ssh.Permissions{
+			CriticalOptions: criticalOptions,
+		},
+	}
+	if err := cert.SignCert(rand.Reader, testCASigner); err != nil {
+		return "", nil, err
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	return string(ssh.MarshalAuthorizedKey(cert)), certSigner, err
+}
+
+func runSSHCommandWithAuth(command string, user dataprovider.User, authMethods []ssh.AuthMethod) ([]byte, error) {
+	config := &ssh.ClientConfig{
+		User: user.Username,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return nil
+		},
+		Auth: authMethods,
+	}
+	conn, err := ssh.Dial("tcp", sftpServerAddr, config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	sshSession, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	var stdout bytes.Buffer
+	sshSession.Stdout = &stdout
+	err = sshSession.Run(command)
+	return stdout.Bytes(), err
+}
+
 func getSftpClientWithAddr(user dataprovider.User, usePubKey bool, addr string) (*sftp.Client, error) {
 	var sftpClient *sftp.Client
 	config := &ssh.ClientConfig{
@@ -8286,6 +9280,34 @@ func getKeyboardInteractiveSftpClient(user dataprovider.User, answers []string)
 	return sftpClient, err
 }
 
+// getBuiltinKeyboardInteractiveSftpClient answers one question for each round, as
+// asked by the built-in keyboard interactive authentication
+func getBuiltinKeyboardInteractiveSftpClient(user dataprovider.User, answers []string) (*sftp.Client, error) {
+	var sftpClient *sftp.Client
+	round := 0
+	config := &ssh.ClientConfig{
+		User: user.Username,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return nil
+		},
+		Auth: []ssh.AuthMethod{
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				if len(questions) != 1 || round >= len(answers) {
+					return nil, errors.New("unexpected keyboard interactive questions")
+				}
+				round++
+				return []string{answers[round-1]}, nil
+			}),
+		},
+	}
+	conn, err := ssh.Dial("tcp", sftpSrvAddr2226, config)
+	if err != nil {
+		return sftpClient, err
+	}
+	sftpClient, err = sftp.NewClient(conn)
+	return sftpClient, err
+}
+
 func getCustomAuthSftpClient(user dataprovider.User, authMethods []ssh.AuthMethod, addr string) (*sftp.Client, error) {
 	var sftpClient *sftp.Client
 	config := &ssh.ClientConfig{
@@ -8792,4 +9814,17 @@ func createInitialFiles(scriptArgs string) {
 	if err != nil {
 		logger.WarnToConsole("unable to save trusted CA user key: %v", err)
 	}
+	generatedCAKey = filepath.Join(homeBasePath, "generated_ca_user_key")
+	_, caPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		logger.WarnToConsole("unable to generate CA user key: %v", err)
+	}
+	testCASigner, err = ssh.NewSignerFromKey(caPrivateKey)
+	if err != nil {
+		logger.WarnToConsole("unable to create CA user signer: %v", err)
+	}
+	err = ioutil.WriteFile(generatedCAKey, ssh.MarshalAuthorizedKey(testCASigner.PublicKey()), 0600)
+	if err != nil {
+		logger.WarnToConsole("unable to save generated CA user key: %v", err)
+	}
 }
@@ -145,6 +145,9 @@ func (c *sshCommand) handeSFTPGoCopy() error {
 	if err := c.checkCopyPermissions(fsSourcePath, fsDestPath, sshSourcePath, sshDestPath, fi); err != nil {
 		return c.sendErrorResponse(err)
 	}
+	if err := c.connection.CheckDirectoryLimit(sshDestPath); err != nil {
+		return c.sendErrorResponse(err)
+	}
 	filesNum := 0
 	filesSize := int64(0)
 	if fi.IsDir() {
@@ -304,6 +307,21 @@ func (c *sshCommand) handleHashCommands() error {
 	return nil
 }
 
+// getSystemCommandTransfers returns the transfers for the stdin, stdout and stderr streams
+// of a system command. A system command counts as a single transfer: only the stdin transfer
+// is added to the connection, the caller must remove it when the command ends
+func (c *sshCommand) getSystemCommandTransfers(fsPath, sshDestPath string, remainingQuotaSize int64) (*transfer,
+	*transfer, *transfer, error) {
+	baseTransfer, err := common.NewBaseTransfer(nil, c.connection.BaseConnection, nil, fsPath, sshDestPath,
+		common.TransferUpload, 0, 0, remainingQuotaSize, false, c.connection.Fs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return newTransfer(baseTransfer, nil, nil, nil),
+		newTransfer(baseTransfer.NewStreamTransfer(common.TransferDownload), nil, nil, nil),
+		newTransfer(baseTransfer.NewStreamTransfer(common.TransferDownload), nil, nil, nil), nil
+}
+
 func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 	if !vfs.IsLocalOsFs(c.connection.Fs) {
 		return c.sendErrorResponse(errUnsupportedConfig)
@@ -336,6 +354,17 @@ func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 	if err != nil {
 		return c.sendErrorResponse(err)
 	}
+
+	remainingQuotaSize := quotaResult.GetRemainingSize()
+	// the transfer is added before starting the command, so the concurrent
+	// transfers limit applies to system commands too
+	stdinTransfer, stdoutTransfer, stderrTransfer, err := c.getSystemCommandTransfers(command.fsPath, sshDestPath,
+		remainingQuotaSize)
+	if err != nil {
+		return c.sendErrorResponse(err)
+	}
+	defer c.connection.RemoveTransfer(stdinTransfer)
+
 	err = command.cmd.Start()
 	if err != nil {
 		return c.sendErrorResponse(err)
@@ -351,15 +380,10 @@ func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 	var once sync.Once
 	commandResponse := make(chan bool)
 
-	remainingQuotaSize := quotaResult.GetRemainingSize()
-
 	go func() {
 		defer stdin.Close()
-		baseTransfer := common.NewBaseTransfer(nil, c.connection.BaseConnection, nil, command.fsPath, sshDestPath,
-			common.TransferUpload, 0, 0, remainingQuotaSize, false, c.connection.Fs)
-		transfer := newTransfer(baseTransfer, nil, nil, nil)
 
-		w, e := transfer.copyFromReaderToWriter(stdin, c.connection.channel)
+		w, e := stdinTransfer.copyFromReaderToWriter(stdin, c.connection.channel)
 		c.connection.Log(logger.LevelDebug, "command: %#v, copy from remote command to sdtin ended, written: %v, "+
 			"initial remaining quota: %v, err: %v", c.connection.command, w, remainingQuotaSize, e)
 		if e != nil {
@@ -368,11 +392,7 @@ func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 	}()
 
 	go func() {
-		baseTransfer := common.NewBaseTransfer(nil, c.connection.BaseConnection, nil, command.fsPath, sshDestPath,
-			common.TransferDownload, 0, 0, 0, false, c.connection.Fs)
-		transfer := newTransfer(baseTransfer, nil, nil, nil)
-
-		w, e := transfer.copyFromReaderToWriter(c.connection.channel, stdout)
+		w, e := stdoutTransfer.copyFromReaderToWriter(c.connection.channel, stdout)
 		c.connection.Log(logger.LevelDebug, "command: %#v, copy from sdtout to remote command ended, written: %v err: %v",
 			c.connection.command, w, e)
 		if e != nil {
@@ -382,11 +402,7 @@ func (c *sshCommand) executeSystemCommand(command systemCommand) error {
 	}()
 
 	go func() {
-		baseTransfer := common.NewBaseTransfer(nil, c.connection.BaseConnection, nil, command.fsPath, sshDestPath,
-			common.TransferDownload, 0, 0, 0, false, c.connection.Fs)
-		transfer := newTransfer(baseTransfer, nil, nil, nil)
-
-		w, e := transfer.copyFromReaderToWriter(c.connection.channel.(ssh.Channel).Stderr(), stderr)
+		w, e := stderrTransfer.copyFromReaderToWriter(c.connection.channel.(ssh.Channel).Stderr(), stderr)
 		c.connection.Log(logger.LevelDebug, "command: %#v, copy from sdterr to remote command ended, written: %v err: %v",
 			c.connection.command, w, e)
 		// os.ErrClosed means that the command is finished so we don't need to do anything
@@ -41,7 +41,7 @@ func ServeSubSystemConnection(user *dataprovider.User, connectionID string, read
 		return err
 	}
 	fs.CheckRootPath(user.Username, user.GetUID(), user.GetGID())
-	dataprovider.UpdateLastLogin(user) //nolint:errcheck
+	dataprovider.UpdateLastLogin(user, common.ProtocolSFTP) //nolint:errcheck
 
 	connection := &Connection{
 		BaseConnection: common.NewBaseConnection(fs.ConnectionID(), common.ProtocolSFTP, *user, fs),
@@ -95,6 +95,9 @@ func (t *transfer) ReadAt(p []byte, off int64) (n int, err error) {
 	n, err = t.readerAt.ReadAt(p, off)
 	atomic.AddInt64(&t.BytesSent, int64(n))
 
+	if err == nil {
+		err = t.CheckRead()
+	}
 	if err != nil && err != io.EOF {
 		if t.GetType() == common.TransferDownload {
 			t.TransferError(err)
@@ -117,9 +120,10 @@ func (t *transfer) WriteAt(p []byte, off int64) (n int, err error) {
 
 	n, err = t.writerAt.WriteAt(p, off)
 	atomic.AddInt64(&t.BytesReceived, int64(n))
+	t.UpdateChecksum(p[:n], off)
 
-	if t.MaxWriteSize > 0 && err == nil && atomic.LoadInt64(&t.BytesReceived) > t.MaxWriteSize {
-		err = common.ErrQuotaExceeded
+	if err == nil {
+		err = t.CheckWrite()
 	}
 	if err != nil {
 		t.TransferError(err)
@@ -178,8 +182,6 @@ func (t *transfer) setFinished() error {
 // It reads from src until EOF so it does not treat an EOF from Read as an error to be reported.
 // EOF from Write is reported as error
 func (t *transfer) copyFromReaderToWriter(dst io.Writer, src io.Reader) (int64, error) {
-	defer t.Connection.RemoveTransfer(t)
-
 	var written int64
 	var err error
 
@@ -194,6 +196,9 @@ func (t *transfer) copyFromReaderToWriter(dst io.Writer, src io.Reader) (int64,
 		if nr > 0 {
 			nw, ew := dst.Write(buf[0:nr])
 			if nw > 0 {
+				if !isDownload {
+					t.UpdateChecksum(buf[0:nw], written)
+				}
 				written += int64(nw)
 				if isDownload {
 					atomic.StoreInt64(&t.BytesSent, written)
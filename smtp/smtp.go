@@ -0,0 +1,195 @@
+// Package smtp provides support for sending emails
+package smtp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+const (
+	logSender = "smtp"
+)
+
+// EmailContentType defines the supported content types for email body
+type EmailContentType int
+
+// Supported email body content type
+const (
+	EmailContentTypeTextPlain EmailContentType = iota
+	EmailContentTypeTextHTML
+)
+
+var (
+	smtpServer  *mail.SMTPServer
+	from        string
+	errDisabled = errors.New("smtp is not configured")
+)
+
+// Config defines the SMTP configuration to use to send emails
+type Config struct {
+	// Location of SMTP email server. Leave empty to disable email sending capabilities
+	Host string `json:"host" mapstructure:"host"`
+	// Port of SMTP email server
+	Port int `json:"port" mapstructure:"port"`
+	// From address, for example "SFTPGo <sftpgo@example.com>".
+	// Many SMTP servers reject emails without a `From` header so, if not set,
+	// SFTPGo will try to use the username as fallback, this may or may not be appropriate
+	From string `json:"from" mapstructure:"from"`
+	// SMTP username
+	User string `json:"user" mapstructure:"user"`
+	// SMTP password. Leaving both username and password empty the SMTP authentication
+	// will be disabled
+	Password string `json:"password" mapstructure:"password"`
+	// 0 Plain, 1 Login, 2 CRAM-MD5
+	AuthType int `json:"auth_type" mapstructure:"auth_type"`
+	// 0 no encryption, 1 TLS, 2 start TLS
+	Encryption int `json:"encryption" mapstructure:"encryption"`
+	// Domain to use for HELO command, if empty localhost will be used
+	Domain string `json:"domain" mapstructure:"domain"`
+}
+
+// IsEnabled returns true if an SMTP server is configured
+func IsEnabled() bool {
+	return smtpServer != nil
+}
+
+func (c *Config) validate() error {
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("smtp: invalid port %v", c.Port)
+	}
+	if c.AuthType < 0 || c.AuthType > 2 {
+		return fmt.Errorf("smtp: invalid auth type %v", c.AuthType)
+	}
+	if c.Encryption < 0 || c.Encryption > 2 {
+		return fmt.Errorf("smtp: invalid encryption %v", c.Encryption)
+	}
+	if c.From == "" && c.User == "" {
+		return errors.New("smtp: from address and user cannot both be empty")
+	}
+	return nil
+}
+
+// Initialize initializes and validates the SMTP configuration
+func (c *Config) Initialize() error {
+	smtpServer = nil
+	if c.Host == "" {
+		logger.Debug(logSender, "", "smtp server not configured")
+		return nil
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	smtpServer = mail.NewSMTPClient()
+	smtpServer.Host = c.Host
+	smtpServer.Port = c.Port
+	smtpServer.Username = c.User
+	smtpServer.Password = c.Password
+	smtpServer.Authentication = c.getAuthType()
+	smtpServer.Encryption = c.getEncryption()
+	smtpServer.KeepAlive = false
+	smtpServer.ConnectTimeout = 10 * time.Second
+	smtpServer.SendTimeout = 30 * time.Second
+	if c.Domain != "" {
+		smtpServer.Helo = c.Domain
+	}
+	from = c.From
+	if from == "" {
+		from = c.User
+	}
+	logger.Debug(logSender, "", "configured smtp server %#v, port: %v, auth: %v, encryption: %v", c.Host,
+		c.Port, smtpServer.Authentication, smtpServer.Encryption)
+	return nil
+}
+
+func (c *Config) getEncryption() mail.Encryption {
+	switch c.Encryption {
+	case 1:
+		return mail.EncryptionSSLTLS
+	case 2:
+		return mail.EncryptionSTARTTLS
+	default:
+		return mail.EncryptionNone
+	}
+}
+
+func (c *Config) getAuthType() mail.AuthType {
+	if c.User == "" && c.Password == "" {
+		return mail.AuthNone
+	}
+	switch c.AuthType {
+	case 1:
+		return mail.AuthLogin
+	case 2:
+		return mail.AuthCRAMMD5
+	default:
+		return mail.AuthPlain
+	}
+}
+
+// SendEmail tries to send an email using the specified parameters
+func SendEmail(to []string, subject, body string, contentType EmailContentType) error {
+	if smtpServer == nil {
+		return errDisabled
+	}
+	if len(to) == 0 {
+		return errors.New("smtp: at least one recipient is required")
+	}
+	msg := mail.NewMSG()
+	msg.SetFrom(from).AddTo(to...).SetSubject(subject)
+	switch contentType {
+	case EmailContentTypeTextPlain:
+		msg.SetBody(mail.TextPlain, body)
+	case EmailContentTypeTextHTML:
+		msg.SetBody(mail.TextHTML, body)
+	default:
+		return fmt.Errorf("smtp: unsupported body content type %v", contentType)
+	}
+	if msg.Error != nil {
+		return fmt.Errorf("smtp: email error: %w", msg.Error)
+	}
+	client, err := smtpServer.Connect()
+	if err != nil {
+		return fmt.Errorf("smtp: unable to connect: %w", err)
+	}
+	defer client.Close()
+
+	startTime := time.Now()
+	err = msg.Send(client)
+	logger.Debug(logSender, "", "email sent to %v, elapsed: %v, error: %v", to, time.Since(startTime), err)
+	return err
+}
+
+// GetErrorHint returns a human readable hint for an error returned by SendEmail,
+// it allows to distinguish TLS, authentication and connection errors.
+// An empty string is returned if the error cannot be classified
+func GetErrorHint(err error) string {
+	if err == nil {
+		return ""
+	}
+	var recordHeaderErr tls.RecordHeaderError
+	var protoErr *textproto.Error
+	var netErr net.Error
+	errString := strings.ToLower(err.Error())
+	switch {
+	case errors.As(err, &recordHeaderErr) || strings.Contains(errString, "tls:") ||
+		strings.Contains(errString, "x509:") || strings.Contains(errString, "starttls"):
+		return "TLS error: check the configured encryption, 1 means implicit TLS, usually on port 465, and 2 " +
+			"means STARTTLS, usually on port 587, and verify that the server certificate is trusted"
+	case errors.As(err, &protoErr) && (protoErr.Code == 530 || protoErr.Code == 534 || protoErr.Code == 535),
+		strings.Contains(errString, "auth"):
+		return "authentication error: check the configured user, password and auth type"
+	case errors.As(err, &netErr) || strings.Contains(errString, "unable to connect"):
+		return "connection error: check the configured host and port and verify that the SMTP server is reachable"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,179 @@
+package smtp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+type fakeSMTPServer struct {
+	sync.Mutex
+	listener net.Listener
+	messages []string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeSMTPServer{
+		listener: listener,
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) getMessages() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.messages
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost fake SMTP server") //nolint:errcheck
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			tp.PrintfLine("250 localhost") //nolint:errcheck
+		case strings.HasPrefix(cmd, "DATA"):
+			tp.PrintfLine("354 end data with <CR><LF>.<CR><LF>") //nolint:errcheck
+			data, err := tp.ReadDotLines()
+			if err != nil {
+				return
+			}
+			s.Lock()
+			s.messages = append(s.messages, strings.Join(data, "\n"))
+			s.Unlock()
+			tp.PrintfLine("250 OK") //nolint:errcheck
+		case strings.HasPrefix(cmd, "QUIT"):
+			tp.PrintfLine("221 bye") //nolint:errcheck
+			return
+		default:
+			tp.PrintfLine("250 OK") //nolint:errcheck
+		}
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	c := Config{}
+	require.NoError(t, c.Initialize())
+	assert.False(t, IsEnabled())
+	err := SendEmail([]string{"a@example.com"}, "subject", "body", EmailContentTypeTextPlain)
+	assert.ErrorIs(t, err, errDisabled)
+
+	c.Host = "127.0.0.1"
+	assert.Error(t, c.Initialize())
+	c.Port = 25
+	c.AuthType = 3
+	assert.Error(t, c.Initialize())
+	c.AuthType = 1
+	c.Encryption = 3
+	assert.Error(t, c.Initialize())
+	c.Encryption = 2
+	assert.Error(t, c.Initialize())
+	assert.False(t, IsEnabled())
+	c.User = "user@example.com"
+	assert.NoError(t, c.Initialize())
+	assert.True(t, IsEnabled())
+	assert.Equal(t, c.User, from)
+
+	assert.Equal(t, mail.AuthLogin, c.getAuthType())
+	c.AuthType = 2
+	assert.Equal(t, mail.AuthCRAMMD5, c.getAuthType())
+	c.AuthType = 0
+	assert.Equal(t, mail.AuthPlain, c.getAuthType())
+	c.User = ""
+	assert.Equal(t, mail.AuthNone, c.getAuthType())
+	assert.Equal(t, mail.EncryptionSTARTTLS, c.getEncryption())
+	c.Encryption = 1
+	assert.Equal(t, mail.EncryptionSSLTLS, c.getEncryption())
+	c.Encryption = 0
+	assert.Equal(t, mail.EncryptionNone, c.getEncryption())
+
+	c = Config{}
+	require.NoError(t, c.Initialize())
+}
+
+func TestSendEmail(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	defer server.listener.Close()
+
+	c := Config{
+		Host: "127.0.0.1",
+		Port: server.port(),
+		From: "SFTPGo <sftpgo@example.com>",
+	}
+	require.NoError(t, c.Initialize())
+
+	err := SendEmail(nil, "subject", "body", EmailContentTypeTextPlain)
+	assert.Error(t, err)
+	err = SendEmail([]string{"invalid address"}, "subject", "body", EmailContentTypeTextPlain)
+	assert.Error(t, err)
+	err = SendEmail([]string{"a@example.com"}, "subject", "body", EmailContentType(10))
+	assert.Error(t, err)
+
+	err = SendEmail([]string{"a@example.com"}, "test subject", "test body", EmailContentTypeTextPlain)
+	assert.NoError(t, err)
+	err = SendEmail([]string{"a@example.com", "b@example.com"}, "html subject", "<p>html body</p>",
+		EmailContentTypeTextHTML)
+	assert.NoError(t, err)
+
+	messages := server.getMessages()
+	require.Len(t, messages, 2)
+	assert.Contains(t, messages[0], "Subject: test subject")
+	assert.Contains(t, messages[0], "test body")
+	assert.Contains(t, messages[0], "sftpgo@example.com")
+	assert.Contains(t, messages[1], "text/html")
+	assert.Contains(t, messages[1], "b@example.com")
+
+	c.Port = server.port() + 1
+	if c.Port > 65535 {
+		c.Port = 1
+	}
+	require.NoError(t, c.Initialize())
+	err = SendEmail([]string{"a@example.com"}, "subject", "body", EmailContentTypeTextPlain)
+	assert.Error(t, err)
+	assert.Contains(t, GetErrorHint(err), "connection error")
+
+	c = Config{}
+	require.NoError(t, c.Initialize())
+}
+
+func TestGetErrorHint(t *testing.T) {
+	assert.Empty(t, GetErrorHint(nil))
+	assert.Empty(t, GetErrorHint(errors.New("generic error")))
+	assert.Contains(t, GetErrorHint(errors.New("x509: certificate signed by unknown authority")), "TLS error")
+	assert.Contains(t, GetErrorHint(fmt.Errorf("wrapped: %w", tls.RecordHeaderError{Msg: "msg"})), "TLS error")
+	assert.Contains(t, GetErrorHint(&textproto.Error{Code: 535, Msg: "5.7.8 bad credentials"}), "authentication error")
+	assert.Contains(t, GetErrorHint(errors.New("unable to authenticate")), "authentication error")
+	assert.Contains(t, GetErrorHint(&net.OpError{Op: "dial", Err: errors.New("connection refused")}), "connection error")
+}
@@ -0,0 +1,94 @@
+// helpers for the WebAuthn ceremonies, the server sends binary fields encoded
+// as standard or URL safe base64 and expects URL safe base64 without padding
+
+function webAuthnDecode(value) {
+    var b64 = value.replace(/-/g, "+").replace(/_/g, "/");
+    while (b64.length % 4) {
+        b64 += "=";
+    }
+    return Uint8Array.from(atob(b64), function (c) { return c.charCodeAt(0); });
+}
+
+function webAuthnEncode(value) {
+    var bytes = new Uint8Array(value);
+    var str = "";
+    for (var i = 0; i < bytes.length; i++) {
+        str += String.fromCharCode(bytes[i]);
+    }
+    return btoa(str).replace(/\+/g, "-").replace(/\//g, "_").replace(/=/g, "");
+}
+
+function webAuthnDecodeCredentials(credentials) {
+    if (!credentials) {
+        return credentials;
+    }
+    return credentials.map(function (c) {
+        c.id = webAuthnDecode(c.id);
+        return c;
+    });
+}
+
+function webAuthnShowError(err) {
+    var el = document.getElementById("webauthn_error");
+    if (el) {
+        el.textContent = "Security key operation failed: " + err;
+        el.parentElement.classList.remove("d-none");
+    }
+}
+
+// webAuthnRegister creates a new credential and submits the given form
+function webAuthnRegister(options, formID) {
+    if (!window.PublicKeyCredential) {
+        webAuthnShowError("your browser does not support WebAuthn");
+        return;
+    }
+    // work on a copy, so the ceremony can be retried
+    var publicKey = JSON.parse(JSON.stringify(options.publicKey));
+    publicKey.challenge = webAuthnDecode(publicKey.challenge);
+    publicKey.user.id = webAuthnDecode(publicKey.user.id);
+    publicKey.excludeCredentials = webAuthnDecodeCredentials(publicKey.excludeCredentials);
+    navigator.credentials.create({ publicKey: publicKey }).then(function (credential) {
+        var form = document.getElementById(formID);
+        form.elements["webauthn_response"].value = JSON.stringify({
+            id: credential.id,
+            rawId: webAuthnEncode(credential.rawId),
+            type: credential.type,
+            response: {
+                attestationObject: webAuthnEncode(credential.response.attestationObject),
+                clientDataJSON: webAuthnEncode(credential.response.clientDataJSON)
+            }
+        });
+        form.submit();
+    }).catch(function (err) {
+        webAuthnShowError(err);
+    });
+}
+
+// webAuthnLogin gets an assertion from a registered credential and submits the given form
+function webAuthnLogin(options, formID) {
+    if (!window.PublicKeyCredential) {
+        webAuthnShowError("your browser does not support WebAuthn");
+        return;
+    }
+    // work on a copy, so the ceremony can be retried
+    var publicKey = JSON.parse(JSON.stringify(options.publicKey));
+    publicKey.challenge = webAuthnDecode(publicKey.challenge);
+    publicKey.allowCredentials = webAuthnDecodeCredentials(publicKey.allowCredentials);
+    navigator.credentials.get({ publicKey: publicKey }).then(function (assertion) {
+        var form = document.getElementById(formID);
+        form.elements["webauthn_response"].value = JSON.stringify({
+            id: assertion.id,
+            rawId: webAuthnEncode(assertion.rawId),
+            type: assertion.type,
+            response: {
+                authenticatorData: webAuthnEncode(assertion.response.authenticatorData),
+                clientDataJSON: webAuthnEncode(assertion.response.clientDataJSON),
+                signature: webAuthnEncode(assertion.response.signature),
+                userHandle: assertion.response.userHandle ? webAuthnEncode(assertion.response.userHandle) : ""
+            }
+        });
+        form.submit();
+    }).catch(function (err) {
+        webAuthnShowError(err);
+    });
+}
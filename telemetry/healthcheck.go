@@ -0,0 +1,238 @@
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+const (
+	healthChecksPath          = "/healthz/checks"
+	defaultHealthCheckTimeout = 10
+	healthCheckUsersPageSize  = 100
+)
+
+var healthCheckConf HealthCheckConfig
+
+// HealthCheckConfig defines the checks executed by the health checks endpoint.
+// The data provider connectivity is always checked
+type HealthCheckConfig struct {
+	// Directory to check for the available disk space, for example the directory
+	// containing the users home directories. This can be an absolute path or a path
+	// relative to the config dir. Empty means disabled
+	DataDir string `json:"data_dir" mapstructure:"data_dir"`
+	// Minimum available disk space, as MB, required for the data directory.
+	// 0 means that the check fails only if the available space cannot be read
+	MinFreeSpace int64 `json:"min_free_space" mapstructure:"min_free_space"`
+	// Check the reachability of the cloud storage backends used by the users.
+	// Each distinct backend is checked once, by verifying that its bucket, container
+	// or remote root exists
+	CheckCloudBackends bool `json:"check_cloud_backends" mapstructure:"check_cloud_backends"`
+	// Check the configured hooks. For HTTP hooks we check that their host accepts
+	// connections, for external programs that they exist
+	CheckHooks bool `json:"check_hooks" mapstructure:"check_hooks"`
+	// Timeout, as seconds, for each network check. 0 means the default: 10 seconds
+	Timeout int `json:"timeout" mapstructure:"timeout"`
+}
+
+func (c *HealthCheckConfig) getTimeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultHealthCheckTimeout * time.Second
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+// HealthCheckResult defines the result of a single check
+type HealthCheckResult struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HealthStatus defines the result of the health checks
+type HealthStatus struct {
+	Healthy bool                `json:"healthy"`
+	Checks  []HealthCheckResult `json:"checks"`
+}
+
+func (s *HealthStatus) add(name string, err error) {
+	result := HealthCheckResult{
+		Name:    name,
+		Healthy: err == nil,
+	}
+	if err != nil {
+		result.Error = err.Error()
+		s.Healthy = false
+		logger.Warn(logSender, "", "health check %#v failed: %v", name, err)
+	}
+	s.Checks = append(s.Checks, result)
+}
+
+// RunHealthChecks executes the configured health checks
+func RunHealthChecks() HealthStatus {
+	status := HealthStatus{
+		Healthy: true,
+	}
+	providerStatus := dataprovider.GetProviderStatus()
+	if providerStatus.IsActive {
+		status.add("data_provider", nil)
+	} else {
+		status.add("data_provider", errors.New(providerStatus.Error))
+	}
+	if healthCheckConf.DataDir != "" {
+		status.add("disk_space", checkDiskSpace(healthCheckConf.DataDir, healthCheckConf.MinFreeSpace))
+	}
+	if healthCheckConf.CheckHooks {
+		checkHooks(&status)
+	}
+	if healthCheckConf.CheckCloudBackends {
+		checkCloudBackends(&status)
+	}
+	return status
+}
+
+func handleHealthChecks(w http.ResponseWriter, r *http.Request) {
+	status := RunHealthChecks()
+	if !status.Healthy {
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	render.JSON(w, r, status)
+}
+
+func checkDiskSpace(dirName string, minFreeSpace int64) error {
+	fs := vfs.NewOsFs("", dirName, nil)
+	stat, err := fs.GetAvailableDiskSize(dirName)
+	if err != nil {
+		return fmt.Errorf("unable to get the available disk space for %#v: %v", dirName, err)
+	}
+	available := int64(stat.Frsize * stat.Bavail)
+	if available < minFreeSpace*1048576 {
+		return fmt.Errorf("the available disk space for %#v is %v MB, at least %v MB are required", dirName,
+			available/1048576, minFreeSpace)
+	}
+	return nil
+}
+
+func checkHooks(status *HealthStatus) {
+	hooks := dataprovider.GetConfiguredHooks()
+	for name, hook := range map[string]string{
+		"common.actions.hook":      common.Config.Actions.Hook,
+		"common.post_connect_hook": common.Config.PostConnectHook,
+		"common.session_end_hook":  common.Config.SessionEndHook,
+	} {
+		if hook != "" {
+			hooks[name] = hook
+		}
+	}
+	// sort the hooks so the results have a predictable order
+	names := make([]string, 0, len(hooks))
+	for name := range hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		status.add("hook "+name, checkHook(hooks[name]))
+	}
+}
+
+func checkHook(hook string) error {
+	u, err := url.Parse(hook)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(u.Hostname(), port), healthCheckConf.getTimeout())
+		if err != nil {
+			return fmt.Errorf("unable to connect to %#v: %v", u.Host, err)
+		}
+		return conn.Close()
+	}
+	info, err := os.Stat(hook)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%#v is a directory", hook)
+	}
+	return nil
+}
+
+func checkCloudBackends(status *HealthStatus) {
+	checked := make(map[string]bool)
+	for offset := 0; ; offset += healthCheckUsersPageSize {
+		users, err := dataprovider.GetUsers(healthCheckUsersPageSize, offset, dataprovider.OrderASC)
+		if err != nil {
+			status.add("cloud_backends", fmt.Errorf("unable to get the users: %v", err))
+			return
+		}
+		for idx := range users {
+			name := getCloudBackendName(&users[idx])
+			if name == "" || checked[name] {
+				continue
+			}
+			checked[name] = true
+			status.add("cloud backend "+name, checkCloudBackend(users[idx]))
+		}
+		if len(users) < healthCheckUsersPageSize {
+			return
+		}
+	}
+}
+
+// getCloudBackendName returns a name that identifies the cloud backend used by
+// the given user or an empty string if the user has a local filesystem
+func getCloudBackendName(user *dataprovider.User) string {
+	switch user.FsConfig.Provider {
+	case dataprovider.S3FilesystemProvider:
+		return fmt.Sprintf("s3 %v/%v", user.FsConfig.S3Config.Endpoint, user.FsConfig.S3Config.Bucket)
+	case dataprovider.GCSFilesystemProvider:
+		return fmt.Sprintf("gcs %v", user.FsConfig.GCSConfig.Bucket)
+	case dataprovider.AzureBlobFilesystemProvider:
+		return fmt.Sprintf("azblob %v/%v/%v", user.FsConfig.AzBlobConfig.Endpoint,
+			user.FsConfig.AzBlobConfig.AccountName, user.FsConfig.AzBlobConfig.Container)
+	case dataprovider.SFTPFilesystemProvider:
+		return fmt.Sprintf("sftp %v", user.FsConfig.SFTPConfig.Endpoint)
+	default:
+		return ""
+	}
+}
+
+// checkCloudBackend checks that the root of the user filesystem, the bucket for
+// object storages, is reachable. The check is abandoned after the configured timeout
+func checkCloudBackend(user dataprovider.User) error {
+	errCh := make(chan error, 1)
+	go func() {
+		fs, err := user.GetFilesystem("healthcheck")
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer fs.Close()
+
+		_, err = fs.Stat(".")
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(healthCheckConf.getTimeout()):
+		return errors.New("timeout")
+	}
+}
@@ -22,6 +22,7 @@ func initializeRouter(enableProfiler bool) {
 		r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
 			render.PlainText(w, r, "ok")
 		})
+		r.Get(healthChecksPath, handleHealthChecks)
 	})
 
 	router.Group(func(router chi.Router) {
@@ -1,5 +1,6 @@
 // Package telemetry provides telemetry information for SFTPGo, such as:
 //		- health information (for health checks)
+//		- detailed health checks (for load balancer probes)
 //		- metrics
 // 		- profiling information
 package telemetry
@@ -63,6 +64,8 @@ type Conf struct {
 	// any invalid name will be silently ignored.
 	// The order matters, the ciphers listed first will be the preferred ones.
 	TLSCipherSuites []string `json:"tls_cipher_suites" mapstructure:"tls_cipher_suites"`
+	// Checks executed by the "/healthz/checks" endpoint
+	HealthCheck HealthCheckConfig `json:"health_check" mapstructure:"health_check"`
 }
 
 // ShouldBind returns true if there service must be started
@@ -87,6 +90,8 @@ func (c Conf) Initialize(configDir string) error {
 	}
 	certificateFile := getConfigPath(c.CertificateFile, configDir)
 	certificateKeyFile := getConfigPath(c.CertificateKeyFile, configDir)
+	healthCheckConf = c.HealthCheck
+	healthCheckConf.DataDir = getConfigPath(c.HealthCheck.DataDir, configDir)
 	initializeRouter(c.EnableProfiler)
 	httpServer := &http.Server{
 		Handler:        router,
@@ -1,6 +1,7 @@
 package telemetry
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -12,6 +13,8 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/kms"
 )
 
 const (
@@ -155,3 +158,115 @@ func TestRouter(t *testing.T) {
 	err = os.Remove(authUserFile)
 	require.NoError(t, err)
 }
+
+func TestHealthChecks(t *testing.T) {
+	providerConf := dataprovider.Config{
+		Driver: dataprovider.MemoryDataProviderName,
+	}
+	providerConf.PasswordHashing.Argon2Options.Memory = 65536
+	providerConf.PasswordHashing.Argon2Options.Iterations = 1
+	providerConf.PasswordHashing.Argon2Options.Parallelism = 2
+	err := dataprovider.Initialize(providerConf, ".", true)
+	require.NoError(t, err)
+	oldConfig := common.Config
+	defer func() {
+		healthCheckConf = HealthCheckConfig{}
+		common.Config = oldConfig
+		err := dataprovider.Close()
+		require.NoError(t, err)
+	}()
+
+	httpAuth, err = common.NewBasicAuthProvider("")
+	require.NoError(t, err)
+	initializeRouter(false)
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+
+	getHealthStatus := func(expectedStatusCode int) HealthStatus {
+		req, err := http.NewRequest(http.MethodGet, healthChecksPath, nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		testServer.Config.Handler.ServeHTTP(rr, req)
+		require.Equal(t, expectedStatusCode, rr.Code)
+		var status HealthStatus
+		err = json.Unmarshal(rr.Body.Bytes(), &status)
+		require.NoError(t, err)
+		return status
+	}
+
+	healthCheckConf = HealthCheckConfig{
+		DataDir: os.TempDir(),
+	}
+	status := getHealthStatus(http.StatusOK)
+	require.True(t, status.Healthy)
+	require.Len(t, status.Checks, 2)
+	require.Equal(t, "data_provider", status.Checks[0].Name)
+	require.Equal(t, "disk_space", status.Checks[1].Name)
+
+	healthCheckConf.MinFreeSpace = 1 << 40
+	status = getHealthStatus(http.StatusServiceUnavailable)
+	require.False(t, status.Healthy)
+	require.True(t, status.Checks[0].Healthy)
+	require.False(t, status.Checks[1].Healthy)
+	require.Contains(t, status.Checks[1].Error, "MB are required")
+
+	hookFile := filepath.Join(os.TempDir(), "hook.sh")
+	err = ioutil.WriteFile(hookFile, []byte("#!/bin/sh\n"), os.ModePerm)
+	require.NoError(t, err)
+	testServerURL := testServer.URL
+	healthCheckConf = HealthCheckConfig{
+		CheckHooks: true,
+		Timeout:    1,
+	}
+	common.Config.PostConnectHook = testServerURL + "/hook"
+	common.Config.SessionEndHook = hookFile
+	status = getHealthStatus(http.StatusOK)
+	require.Len(t, status.Checks, 3)
+	require.Equal(t, "hook common.post_connect_hook", status.Checks[1].Name)
+	require.Equal(t, "hook common.session_end_hook", status.Checks[2].Name)
+
+	err = os.Remove(hookFile)
+	require.NoError(t, err)
+	status = getHealthStatus(http.StatusServiceUnavailable)
+	require.True(t, status.Checks[1].Healthy)
+	require.False(t, status.Checks[2].Healthy)
+	require.Error(t, checkHook(os.TempDir()))
+	require.Error(t, checkHook("http://127.0.0.1:1/hook"))
+
+	common.Config = oldConfig
+	healthCheckConf = HealthCheckConfig{
+		CheckCloudBackends: true,
+		Timeout:            1,
+	}
+	user := dataprovider.User{
+		Username:    "health_user",
+		Password:    "password",
+		HomeDir:     filepath.Join(os.TempDir(), "health_user"),
+		Status:      1,
+		Permissions: map[string][]string{"/": {dataprovider.PermAny}},
+	}
+	err = dataprovider.AddUser(&user)
+	require.NoError(t, err)
+	// local filesystems are not checked
+	status = getHealthStatus(http.StatusOK)
+	require.Len(t, status.Checks, 1)
+
+	user.FsConfig.Provider = dataprovider.SFTPFilesystemProvider
+	user.FsConfig.SFTPConfig.Endpoint = "127.0.0.1:1"
+	user.FsConfig.SFTPConfig.Username = "sftp_user"
+	user.FsConfig.SFTPConfig.Password = kms.NewPlainSecret("sftp_pwd")
+	require.Equal(t, "sftp 127.0.0.1:1", getCloudBackendName(&user))
+	require.Error(t, checkCloudBackend(user))
+	user.FsConfig.Provider = dataprovider.S3FilesystemProvider
+	user.FsConfig.S3Config.Bucket = "bucket"
+	require.Equal(t, "s3 /bucket", getCloudBackendName(&user))
+	user.FsConfig.Provider = dataprovider.GCSFilesystemProvider
+	user.FsConfig.GCSConfig.Bucket = "bucket"
+	require.Equal(t, "gcs bucket", getCloudBackendName(&user))
+	user.FsConfig.Provider = dataprovider.AzureBlobFilesystemProvider
+	user.FsConfig.AzBlobConfig.Container = "container"
+	require.Equal(t, "azblob //container", getCloudBackendName(&user))
+
+	err = dataprovider.DeleteUser(user.Username)
+	require.NoError(t, err)
+}
@@ -209,108 +209,136 @@ func DecryptData(data string) (string, error) {
 	return string(plaintext), nil
 }
 
-// GenerateRSAKeys generate rsa private and public keys and write the
-// private key to specified file and the public key to the specified
-// file adding the .pub suffix
-func GenerateRSAKeys(file string) error {
-	if err := createDirPathIfMissing(file, 0700); err != nil {
-		return err
-	}
-	key, err := rsa.GenerateKey(rand.Reader, 4096)
-	if err != nil {
-		return err
-	}
-
-	o, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return err
-	}
-	defer o.Close()
+// Supported SSH key types
+const (
+	SSHKeyTypeRSA     = "rsa"
+	SSHKeyTypeECDSA   = "ecdsa"
+	SSHKeyTypeEd25519 = "ed25519"
+)
 
-	priv := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
-	}
+// GenerateSSHKeyPair generates a private key of the specified type and returns it
+// PEM encoded together with the public key in authorized_keys format
+func GenerateSSHKeyPair(keyType string) ([]byte, []byte, error) {
+	return GenerateSSHKeyPairWithSize(keyType, 0)
+}
 
-	if err := pem.Encode(o, priv); err != nil {
-		return err
+// GenerateSSHKeyPairWithSize is like GenerateSSHKeyPair but allows to specify the key size
+// in bits: 2048, 3072 or 4096 for RSA keys and 256, 384 or 521 for ECDSA keys. Ed25519 keys
+// have a fixed size. 0 means the default size: 4096 for RSA and 256 for ECDSA keys
+func GenerateSSHKeyPairWithSize(keyType string, bits int) ([]byte, []byte, error) {
+	var priv *pem.Block
+	var pubKey interface{}
+
+	switch keyType {
+	case SSHKeyTypeRSA:
+		if bits == 0 {
+			bits = 4096
+		}
+		if bits != 2048 && bits != 3072 && bits != 4096 {
+			return nil, nil, fmt.Errorf("unsupported RSA key size %v, supported sizes: 2048, 3072, 4096", bits)
+		}
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, nil, err
+		}
+		priv = &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}
+		pubKey = &key.PublicKey
+	case SSHKeyTypeECDSA:
+		var curve elliptic.Curve
+		switch bits {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, nil, fmt.Errorf("unsupported ECDSA key size %v, supported sizes: 256, 384, 521", bits)
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyBytes, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		priv = &pem.Block{
+			Type:  "EC PRIVATE KEY",
+			Bytes: keyBytes,
+		}
+		pubKey = &key.PublicKey
+	case SSHKeyTypeEd25519:
+		if bits != 0 && bits != 256 {
+			return nil, nil, fmt.Errorf("unsupported Ed25519 key size %v, Ed25519 keys have a fixed size", bits)
+		}
+		publicKey, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		priv = &pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: keyBytes,
+		}
+		pubKey = publicKey
+	default:
+		return nil, nil, fmt.Errorf("unsupported key type %#v", keyType)
 	}
 
-	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	pub, err := ssh.NewPublicKey(pubKey)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	return ioutil.WriteFile(file+".pub", ssh.MarshalAuthorizedKey(pub), 0600)
+	return pem.EncodeToMemory(priv), ssh.MarshalAuthorizedKey(pub), nil
+}
+
+// GenerateRSAKeys generate rsa private and public keys and write the
+// private key to specified file and the public key to the specified
+// file adding the .pub suffix
+func GenerateRSAKeys(file string) error {
+	return writeSSHKeyPair(file, SSHKeyTypeRSA)
 }
 
 // GenerateECDSAKeys generate ecdsa private and public keys and write the
 // private key to specified file and the public key to the specified
 // file adding the .pub suffix
 func GenerateECDSAKeys(file string) error {
-	if err := createDirPathIfMissing(file, 0700); err != nil {
-		return err
-	}
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return err
-	}
-
-	keyBytes, err := x509.MarshalECPrivateKey(key)
-	if err != nil {
-		return err
-	}
-	priv := &pem.Block{
-		Type:  "EC PRIVATE KEY",
-		Bytes: keyBytes,
-	}
-
-	o, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return err
-	}
-	defer o.Close()
-
-	if err := pem.Encode(o, priv); err != nil {
-		return err
-	}
-
-	pub, err := ssh.NewPublicKey(&key.PublicKey)
-	if err != nil {
-		return err
-	}
-	return ioutil.WriteFile(file+".pub", ssh.MarshalAuthorizedKey(pub), 0600)
+	return writeSSHKeyPair(file, SSHKeyTypeECDSA)
 }
 
 // GenerateEd25519Keys generate ed25519 private and public keys and write the
 // private key to specified file and the public key to the specified
 // file adding the .pub suffix
 func GenerateEd25519Keys(file string) error {
-	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
-	if err != nil {
-		return err
-	}
-	keyBytes, err := x509.MarshalPKCS8PrivateKey(privKey)
-	if err != nil {
+	return writeSSHKeyPair(file, SSHKeyTypeEd25519)
+}
+
+func writeSSHKeyPair(file, keyType string) error {
+	return WriteSSHKeyPair(file, keyType, 0)
+}
+
+// WriteSSHKeyPair generates a private key of the specified type and size and writes
+// it to the specified file. The public key is written to the same file adding the
+// .pub suffix. Please take a look at GenerateSSHKeyPairWithSize for the supported sizes
+func WriteSSHKeyPair(file, keyType string, bits int) error {
+	if err := createDirPathIfMissing(file, 0700); err != nil {
 		return err
 	}
-	priv := &pem.Block{
-		Type:  "PRIVATE KEY",
-		Bytes: keyBytes,
-	}
-	o, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	priv, pub, err := GenerateSSHKeyPairWithSize(keyType, bits)
 	if err != nil {
 		return err
 	}
-	defer o.Close()
-
-	if err := pem.Encode(o, priv); err != nil {
-		return err
-	}
-	pub, err := ssh.NewPublicKey(pubKey)
-	if err != nil {
+	if err := ioutil.WriteFile(file, priv, 0600); err != nil {
 		return err
 	}
-	return ioutil.WriteFile(file+".pub", ssh.MarshalAuthorizedKey(pub), 0600)
+	return ioutil.WriteFile(file+".pub", pub, 0600)
 }
 
 // GetDirsForSFTPPath returns all the directory for the given path in reverse order
@@ -391,7 +419,7 @@ func createDirPathIfMissing(file string, perm os.FileMode) error {
 func GenerateRandomBytes(length int) []byte {
 	b := make([]byte, length)
 	_, err := io.ReadFull(rand.Reader, b)
-	if err != nil {
+	if err == nil {
 		return b
 	}
 
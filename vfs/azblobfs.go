@@ -105,7 +105,15 @@ func NewAzBlobFs(connectionID, localTempDir string, config AzBlobFsConfig) (Fs,
 		return fs, nil
 	}
 
-	credential, err := azblob.NewSharedKeyCredential(fs.config.AccountName, fs.config.AccountKey.GetPayload())
+	accountKey := fs.config.AccountKey.GetPayload()
+	if fs.config.hasSharedCredentials() {
+		var err error
+		accountKey, err = readSharedCredentials(fs.config.AccountKeyFile, fs.config.AccountKeyEnv)
+		if err != nil {
+			return fs, err
+		}
+	}
+	credential, err := azblob.NewSharedKeyCredential(fs.config.AccountName, accountKey)
 	if err != nil {
 		return fs, fmt.Errorf("invalid credentials: %v", err)
 	}
@@ -0,0 +1,147 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// credentialsEnvReservedPrefix defines the prefix for the environment variables
+// used to configure SFTPGo, they cannot be referenced as credentials
+const credentialsEnvReservedPrefix = "SFTPGO_"
+
+var (
+	credentialsEnvRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// directory containing the credentials files that can be referenced
+	sharedCredentialsDir string
+	// required prefix for the environment variables that can be referenced
+	sharedCredentialsEnvPrefix string
+)
+
+// SetSharedCredentialsConfig sets the directory containing the credentials files
+// and the prefix for the environment variables that can be referenced in the
+// filesystem configurations. Empty values do not allow the related references.
+// The directory must be an absolute path
+func SetSharedCredentialsConfig(dir, envPrefix string) error {
+	if dir != "" && !filepath.IsAbs(dir) {
+		return fmt.Errorf("the shared credentials directory %#v must be an absolute path", dir)
+	}
+	if envPrefix != "" && !credentialsEnvRegex.MatchString(envPrefix) {
+		return fmt.Errorf("invalid shared credentials environment variables prefix %#v", envPrefix)
+	}
+	if dir != "" {
+		dir = filepath.Clean(dir)
+	}
+	sharedCredentialsDir = dir
+	sharedCredentialsEnvPrefix = envPrefix
+	return nil
+}
+
+// validateSharedCredentials validates the references to credentials stored
+// outside the user configuration, inside a file or an environment variable
+func validateSharedCredentials(file, envName string) error {
+	if file != "" && envName != "" {
+		return errors.New("the credentials file and the credentials environment variable cannot be set together")
+	}
+	if file != "" {
+		return validateCredentialsFile(file)
+	}
+	return validateCredentialsEnv(envName)
+}
+
+func validateCredentialsFile(file string) error {
+	if !filepath.IsAbs(file) {
+		return fmt.Errorf("the credentials file %#v must be an absolute path", file)
+	}
+	if sharedCredentialsDir == "" {
+		return errors.New("credentials files are not allowed, the shared credentials directory is not configured")
+	}
+	if !isCredentialsFileAllowed(filepath.Clean(file), sharedCredentialsDir) {
+		return fmt.Errorf("the credentials file %#v is not inside the shared credentials directory %#v",
+			file, sharedCredentialsDir)
+	}
+	return nil
+}
+
+// isCredentialsFileAllowed returns true if the given cleaned absolute path is inside dir
+func isCredentialsFileAllowed(file, dir string) bool {
+	rel, err := filepath.Rel(dir, file)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+func validateCredentialsEnv(envName string) error {
+	if !credentialsEnvRegex.MatchString(envName) {
+		return fmt.Errorf("invalid credentials environment variable name %#v", envName)
+	}
+	if strings.HasPrefix(strings.ToUpper(envName), credentialsEnvReservedPrefix) {
+		return fmt.Errorf("the environment variables starting with %#v cannot be used as credentials",
+			credentialsEnvReservedPrefix)
+	}
+	if sharedCredentialsEnvPrefix == "" {
+		return errors.New("credentials environment variables are not allowed, the prefix is not configured")
+	}
+	if !strings.HasPrefix(envName, sharedCredentialsEnvPrefix) {
+		return fmt.Errorf("the credentials environment variable %#v must start with %#v", envName,
+			sharedCredentialsEnvPrefix)
+	}
+	return nil
+}
+
+// resolveCredentialsFile checks that the given file, after resolving the
+// symlinks, is still inside the shared credentials directory
+func resolveCredentialsFile(file string) (string, error) {
+	if err := validateCredentialsFile(file); err != nil {
+		return "", err
+	}
+	resolvedDir, err := filepath.EvalSymlinks(sharedCredentialsDir)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve the shared credentials directory: %w", err)
+	}
+	resolvedFile, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve the credentials file %#v: %w", file, err)
+	}
+	if !isCredentialsFileAllowed(resolvedFile, resolvedDir) {
+		return "", fmt.Errorf("the credentials file %#v is not inside the shared credentials directory %#v",
+			file, sharedCredentialsDir)
+	}
+	return resolvedFile, nil
+}
+
+// readSharedCredentials returns the credentials read from the given file, if
+// not empty, or from the given environment variable. The credentials are read
+// each time a filesystem is created so the rotated credentials are used for
+// the new connections. The references are checked again, the configured
+// allowlist could be changed after saving the user
+func readSharedCredentials(file, envName string) (string, error) {
+	if file != "" {
+		resolvedFile, err := resolveCredentialsFile(file)
+		if err != nil {
+			return "", err
+		}
+		content, err := ioutil.ReadFile(resolvedFile)
+		if err != nil {
+			return "", fmt.Errorf("unable to read the credentials file %#v: %w", file, err)
+		}
+		credentials := strings.TrimSpace(string(content))
+		if credentials == "" {
+			return "", fmt.Errorf("the credentials file %#v is empty", file)
+		}
+		return credentials, nil
+	}
+	if err := validateCredentialsEnv(envName); err != nil {
+		return "", err
+	}
+	credentials := strings.TrimSpace(os.Getenv(envName))
+	if credentials == "" {
+		return "", fmt.Errorf("the credentials environment variable %#v is not set or empty", envName)
+	}
+	return credentials, nil
+}
@@ -64,6 +64,13 @@ func NewGCSFs(connectionID, localTempDir string, config GCSFsConfig) (Fs, error)
 	ctx := context.Background()
 	if fs.config.AutomaticCredentials > 0 {
 		fs.svc, err = storage.NewClient(ctx)
+	} else if fs.config.hasSharedCredentials() {
+		var creds string
+		creds, err = readSharedCredentials(fs.config.SharedCredentialsFile, fs.config.CredentialsEnv)
+		if err != nil {
+			return fs, err
+		}
+		fs.svc, err = storage.NewClient(ctx, option.WithCredentialsJSON([]byte(creds)))
 	} else if !fs.config.Credentials.IsEmpty() {
 		if fs.config.Credentials.IsEncrypted() {
 			err = fs.config.Credentials.Decrypt()
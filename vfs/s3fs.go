@@ -61,7 +61,23 @@ func NewS3Fs(connectionID, localTempDir string, config S3FsConfig) (Fs, error) {
 		awsConfig.WithRegion(fs.config.Region)
 	}
 
-	if !fs.config.AccessSecret.IsEmpty() {
+	if fs.config.CredentialsFile != "" {
+		credentialsFile, err := resolveCredentialsFile(fs.config.CredentialsFile)
+		if err != nil {
+			return fs, err
+		}
+		awsConfig.Credentials = credentials.NewSharedCredentials(credentialsFile, fs.config.CredentialsProfile)
+	} else if fs.config.AccessKeyEnv != "" {
+		accessKey, err := readSharedCredentials("", fs.config.AccessKeyEnv)
+		if err != nil {
+			return fs, err
+		}
+		accessSecret, err := readSharedCredentials("", fs.config.AccessSecretEnv)
+		if err != nil {
+			return fs, err
+		}
+		awsConfig.Credentials = credentials.NewStaticCredentials(accessKey, accessSecret, "")
+	} else if !fs.config.AccessSecret.IsEmpty() {
 		if fs.config.AccessSecret.IsEncrypted() {
 			err := fs.config.AccessSecret.Decrypt()
 			if err != nil {
@@ -122,8 +122,20 @@ type S3FsConfig struct {
 	Region       string      `json:"region,omitempty"`
 	AccessKey    string      `json:"access_key,omitempty"`
 	AccessSecret *kms.Secret `json:"access_secret,omitempty"`
-	Endpoint     string      `json:"endpoint,omitempty"`
-	StorageClass string      `json:"storage_class,omitempty"`
+	// Absolute path to an AWS shared credentials file. The credentials are read
+	// when the filesystem is created, so they can be shared between several users
+	// and rotated without updating each user. It cannot be used together with
+	// access_key/access_secret
+	CredentialsFile string `json:"credentials_file,omitempty"`
+	// Profile to use from the shared credentials file. Empty means "default"
+	CredentialsProfile string `json:"credentials_profile,omitempty"`
+	// Names of the environment variables containing the access key and the
+	// access secret. They cannot be used together with credentials_file or
+	// access_key/access_secret
+	AccessKeyEnv    string `json:"access_key_env,omitempty"`
+	AccessSecretEnv string `json:"access_secret_env,omitempty"`
+	Endpoint        string `json:"endpoint,omitempty"`
+	StorageClass    string `json:"storage_class,omitempty"`
 	// The buffer size (in MB) to use for multipart uploads. The minimum allowed part size is 5MB,
 	// and if this value is set to zero, the default value (5MB) for the AWS SDK will be used.
 	// The minimum allowed value is 5.
@@ -136,7 +148,40 @@ type S3FsConfig struct {
 	UploadConcurrency int `json:"upload_concurrency,omitempty"`
 }
 
+func (c *S3FsConfig) hasSharedCredentials() bool {
+	return c.CredentialsFile != "" || c.AccessKeyEnv != "" || c.AccessSecretEnv != ""
+}
+
+func (c *S3FsConfig) checkSharedCredentials() error {
+	if c.AccessKey != "" || !c.AccessSecret.IsEmpty() {
+		return errors.New("access_key/access_secret cannot be set together with credentials_file or " +
+			"access_key_env/access_secret_env")
+	}
+	if c.CredentialsFile != "" {
+		if c.AccessKeyEnv != "" || c.AccessSecretEnv != "" {
+			return errors.New("credentials_file cannot be set together with access_key_env/access_secret_env")
+		}
+		return validateCredentialsFile(c.CredentialsFile)
+	}
+	if c.CredentialsProfile != "" {
+		return errors.New("credentials_profile requires credentials_file")
+	}
+	if c.AccessKeyEnv == "" || c.AccessSecretEnv == "" {
+		return errors.New("access_key_env and access_secret_env must be set together")
+	}
+	if err := validateCredentialsEnv(c.AccessKeyEnv); err != nil {
+		return err
+	}
+	return validateCredentialsEnv(c.AccessSecretEnv)
+}
+
 func (c *S3FsConfig) checkCredentials() error {
+	if c.hasSharedCredentials() {
+		return c.checkSharedCredentials()
+	}
+	if c.CredentialsProfile != "" {
+		return errors.New("credentials_profile requires credentials_file")
+	}
 	if c.AccessKey == "" && !c.AccessSecret.IsEmpty() {
 		return errors.New("access_key cannot be empty with access_secret not empty")
 	}
@@ -208,6 +253,15 @@ type GCSFsConfig struct {
 	KeyPrefix      string      `json:"key_prefix,omitempty"`
 	CredentialFile string      `json:"-"`
 	Credentials    *kms.Secret `json:"credentials,omitempty"`
+	// Absolute path to a JSON file containing the service account credentials,
+	// it is not the file where SFTPGo saves the credentials set for the user.
+	// The credentials are read when the filesystem is created, so they can be
+	// shared between several users and rotated without updating each user.
+	// The file must be inside the configured shared credentials directory
+	SharedCredentialsFile string `json:"shared_credentials_file,omitempty"`
+	// Name of the environment variable containing the service account credentials
+	// as JSON. It cannot be used together with shared_credentials_file
+	CredentialsEnv string `json:"credentials_env,omitempty"`
 	// 0 explicit, 1 automatic
 	AutomaticCredentials int    `json:"automatic_credentials,omitempty"`
 	StorageClass         string `json:"storage_class,omitempty"`
@@ -230,9 +284,20 @@ func (c *GCSFsConfig) Validate(credentialsFilePath string) error {
 			c.KeyPrefix += "/"
 		}
 	}
+	return c.checkCredentials(credentialsFilePath)
+}
+
+func (c *GCSFsConfig) checkCredentials(credentialsFilePath string) error {
 	if c.Credentials.IsEncrypted() && !c.Credentials.IsValid() {
 		return errors.New("invalid encrypted credentials")
 	}
+	if c.hasSharedCredentials() {
+		if c.AutomaticCredentials > 0 || !c.Credentials.IsEmpty() {
+			return errors.New("credentials and automatic_credentials cannot be set together with " +
+				"credentials_file or credentials_env")
+		}
+		return validateSharedCredentials(c.SharedCredentialsFile, c.CredentialsEnv)
+	}
 	if !c.Credentials.IsValidInput() && c.AutomaticCredentials == 0 {
 		fi, err := os.Stat(credentialsFilePath)
 		if err != nil {
@@ -245,6 +310,10 @@ func (c *GCSFsConfig) Validate(credentialsFilePath string) error {
 	return nil
 }
 
+func (c *GCSFsConfig) hasSharedCredentials() bool {
+	return c.SharedCredentialsFile != "" || c.CredentialsEnv != ""
+}
+
 // AzBlobFsConfig defines the configuration for Azure Blob Storage based filesystem
 type AzBlobFsConfig struct {
 	Container string `json:"container,omitempty"`
@@ -253,6 +322,12 @@ type AzBlobFsConfig struct {
 	// Storage Account Key leave blank to use SAS URL.
 	// The access key is stored encrypted based on the kms configuration
 	AccountKey *kms.Secret `json:"account_key,omitempty"`
+	// Absolute path to a file, or name of an environment variable, containing the
+	// account key. The account key is read when the filesystem is created, so it
+	// can be shared between several users and rotated without updating each user.
+	// They cannot be used together with account_key
+	AccountKeyFile string `json:"account_key_file,omitempty"`
+	AccountKeyEnv  string `json:"account_key_env,omitempty"`
 	// Optional endpoint. Default is "blob.core.windows.net".
 	// If you use the emulator the endpoint must include the protocol,
 	// for example "http://127.0.0.1:10000"
@@ -293,7 +368,20 @@ func (c *AzBlobFsConfig) EncryptCredentials(additionalData string) error {
 	return nil
 }
 
+func (c *AzBlobFsConfig) hasSharedCredentials() bool {
+	return c.AccountKeyFile != "" || c.AccountKeyEnv != ""
+}
+
 func (c *AzBlobFsConfig) checkCredentials() error {
+	if c.hasSharedCredentials() {
+		if c.AccountName == "" {
+			return errors.New("account_name cannot be empty")
+		}
+		if !c.AccountKey.IsEmpty() {
+			return errors.New("account_key cannot be set together with account_key_file or account_key_env")
+		}
+		return validateSharedCredentials(c.AccountKeyFile, c.AccountKeyEnv)
+	}
 	if c.AccountName == "" || !c.AccountKey.IsValidInput() {
 		return errors.New("credentials cannot be empty or invalid")
 	}
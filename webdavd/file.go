@@ -176,6 +176,9 @@ func (f *webDavFile) Read(p []byte) (n int, err error) {
 	n, err = f.reader.Read(p)
 	atomic.AddInt64(&f.BytesSent, int64(n))
 
+	if err == nil {
+		err = f.CheckRead()
+	}
 	if err != nil && err != io.EOF {
 		f.TransferError(err)
 		return
@@ -193,10 +196,10 @@ func (f *webDavFile) Write(p []byte) (n int, err error) {
 	f.Connection.UpdateLastActivity()
 
 	n, err = f.writer.Write(p)
-	atomic.AddInt64(&f.BytesReceived, int64(n))
+	f.UpdateChecksum(p[:n], atomic.AddInt64(&f.BytesReceived, int64(n))-int64(n))
 
-	if f.MaxWriteSize > 0 && err == nil && atomic.LoadInt64(&f.BytesReceived) > f.MaxWriteSize {
-		err = common.ErrQuotaExceeded
+	if err == nil {
+		err = f.CheckWrite()
 	}
 	if err != nil {
 		f.TransferError(err)
@@ -218,6 +221,9 @@ func (f *webDavFile) updateStatInfo() error {
 		info = f.Fs.(*vfs.CryptFs).ConvertFileInfo(info)
 	}
 	f.info = info
+	if f.GetType() == common.TransferDownload {
+		f.SetExpectedSize(info.Size())
+	}
 	return nil
 }
 
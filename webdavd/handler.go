@@ -157,6 +157,13 @@ func (c *Connection) getFile(fsPath, virtualPath string) (webdav.File, error) {
 	var r *pipeat.PipeReaderAt
 	var cancelFn func()
 
+	// files are opened for stat and readdir too, only real downloads are limited
+	if c.request != nil && c.request.Method == http.MethodGet {
+		if err = c.CheckTransfersLimit(); err != nil {
+			return nil, err
+		}
+	}
+
 	// for cloud fs we open the file when we receive the first read to avoid to download the first part of
 	// the file if it was opened only to do a stat or a readdir and so it is not a real download
 	if vfs.IsLocalOrSFTPFs(c.Fs) {
@@ -167,8 +174,11 @@ func (c *Connection) getFile(fsPath, virtualPath string) (webdav.File, error) {
 		}
 	}
 
-	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, fsPath, virtualPath, common.TransferDownload,
+	baseTransfer, err := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, fsPath, virtualPath, common.TransferDownload,
 		0, 0, 0, false, c.Fs)
+	if err != nil {
+		return nil, err
+	}
 
 	return newWebDavFile(baseTransfer, nil, r), nil
 }
@@ -179,6 +189,10 @@ func (c *Connection) putFile(fsPath, virtualPath string) (webdav.File, error) {
 		return nil, c.GetPermissionDeniedError()
 	}
 
+	if err := c.CheckTransfersLimit(); err != nil {
+		return nil, err
+	}
+
 	filePath := fsPath
 	if common.Config.IsAtomicUploadEnabled() && c.Fs.IsAtomicUploadSupported() {
 		filePath = c.Fs.GetAtomicUploadPath(fsPath)
@@ -216,6 +230,9 @@ func (c *Connection) handleUploadToNewFile(resolvedPath, filePath, requestPath s
 		c.Log(logger.LevelInfo, "denying file write due to quota limits")
 		return nil, common.ErrQuotaExceeded
 	}
+	if err := c.CheckDirectoryLimit(requestPath); err != nil {
+		return nil, err
+	}
 	file, w, cancelFn, err := c.Fs.Create(filePath, 0)
 	if err != nil {
 		c.Log(logger.LevelWarn, "error creating file %#v: %+v", resolvedPath, err)
@@ -227,8 +244,11 @@ func (c *Connection) handleUploadToNewFile(resolvedPath, filePath, requestPath s
 	// we can get an error only for resume
 	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, 0)
 
-	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
+	baseTransfer, err := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
 		common.TransferUpload, 0, 0, maxWriteSize, true, c.Fs)
+	if err != nil {
+		return nil, err
+	}
 
 	return newWebDavFile(baseTransfer, w, nil), nil
 }
@@ -277,8 +297,11 @@ func (c *Connection) handleUploadToExistingFile(resolvedPath, filePath string, f
 
 	vfs.SetPathPermissions(c.Fs, filePath, c.User.GetUID(), c.User.GetGID())
 
-	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
+	baseTransfer, err := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
 		common.TransferUpload, 0, initialSize, maxWriteSize, false, c.Fs)
+	if err != nil {
+		return nil, err
+	}
 
 	return newWebDavFile(baseTransfer, w, nil), nil
 }
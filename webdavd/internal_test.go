@@ -19,10 +19,12 @@ import (
 
 	"github.com/eikenb/pipeat"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/net/webdav"
 
 	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/vfs"
 )
 
@@ -437,32 +439,46 @@ func TestRemoteAddress(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Empty(t, req.RemoteAddr)
 
+	proxyAddr := "10.8.0.1"
 	remoteAddr1 := "100.100.100.100"
 	remoteAddr2 := "172.172.172.172"
 
+	server := webDavServer{}
+	req.RemoteAddr = proxyAddr + ":1234"
 	req.Header.Set("X-Forwarded-For", remoteAddr1)
-	checkRemoteAddress(req)
+	// proxy headers are ignored if no trusted proxy is configured
+	server.checkRemoteAddress(req)
+	assert.Equal(t, proxyAddr+":1234", req.RemoteAddr)
+
+	server.binding.ProxyAllowed = []string{"10.8.0.0/30"}
+	err = server.binding.parseTrustedProxies()
+	assert.NoError(t, err)
+	server.checkRemoteAddress(req)
 	assert.Equal(t, remoteAddr1, req.RemoteAddr)
-	req.RemoteAddr = ""
+	req.RemoteAddr = proxyAddr
 
 	req.Header.Set("X-Forwarded-For", fmt.Sprintf("%v, %v", remoteAddr2, remoteAddr1))
-	checkRemoteAddress(req)
-	assert.Equal(t, remoteAddr2, req.RemoteAddr)
+	server.checkRemoteAddress(req)
+	assert.Equal(t, remoteAddr1, req.RemoteAddr)
+	req.RemoteAddr = "10.8.0.5"
+	server.checkRemoteAddress(req)
+	assert.Equal(t, "10.8.0.5", req.RemoteAddr)
 
 	req.Header.Del("X-Forwarded-For")
-	req.RemoteAddr = ""
+	req.RemoteAddr = proxyAddr
 	req.Header.Set("X-Real-IP", remoteAddr1)
-	checkRemoteAddress(req)
-	assert.Equal(t, remoteAddr1, req.RemoteAddr)
-	req.RemoteAddr = ""
+	server.checkRemoteAddress(req)
+	assert.Equal(t, proxyAddr, req.RemoteAddr)
 
-	oldValue := common.Config.ProxyProtocol
-	common.Config.ProxyProtocol = 1
-
-	checkRemoteAddress(req)
-	assert.Empty(t, req.RemoteAddr)
+	server.binding.ClientIPProxyHeader = "X-Real-IP"
+	err = server.binding.parseTrustedProxies()
+	assert.NoError(t, err)
+	server.checkRemoteAddress(req)
+	assert.Equal(t, remoteAddr1, req.RemoteAddr)
 
-	common.Config.ProxyProtocol = oldValue
+	server.binding.ClientIPProxyHeader = "X-Client-IP"
+	err = server.binding.parseTrustedProxies()
+	assert.Error(t, err)
 }
 
 func TestConnWithNilRequest(t *testing.T) {
@@ -661,10 +677,11 @@ func TestContentType(t *testing.T) {
 	}
 	testFilePath := filepath.Join(user.HomeDir, testFile)
 	ctx := context.Background()
-	baseTransfer := common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
+	baseTransfer, err := common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
 		common.TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	fs = newMockOsFs(nil, false, fs.ConnectionID(), user.GetHomeDir(), nil)
-	err := ioutil.WriteFile(testFilePath, []byte(""), os.ModePerm)
+	err = ioutil.WriteFile(testFilePath, []byte(""), os.ModePerm)
 	assert.NoError(t, err)
 	davFile := newWebDavFile(baseTransfer, nil, nil)
 	davFile.Fs = fs
@@ -709,11 +726,12 @@ func TestTransferReadWriteErrors(t *testing.T) {
 		BaseConnection: common.NewBaseConnection(fs.ConnectionID(), common.ProtocolWebDAV, user, fs),
 	}
 	testFilePath := filepath.Join(user.HomeDir, testFile)
-	baseTransfer := common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
+	baseTransfer, err := common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
 		common.TransferUpload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	davFile := newWebDavFile(baseTransfer, nil, nil)
 	p := make([]byte, 1)
-	_, err := davFile.Read(p)
+	_, err = davFile.Read(p)
 	assert.EqualError(t, err, common.ErrOpUnsupported.Error())
 
 	r, w, err := pipeat.Pipe()
@@ -727,16 +745,18 @@ func TestTransferReadWriteErrors(t *testing.T) {
 	err = w.Close()
 	assert.NoError(t, err)
 
-	baseTransfer = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
+	baseTransfer, err = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
 		common.TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	davFile = newWebDavFile(baseTransfer, nil, nil)
 	_, err = davFile.Read(p)
 	assert.True(t, os.IsNotExist(err))
 	_, err = davFile.Stat()
 	assert.True(t, os.IsNotExist(err))
 
-	baseTransfer = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
+	baseTransfer, err = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
 		common.TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	err = ioutil.WriteFile(testFilePath, []byte(""), os.ModePerm)
 	assert.NoError(t, err)
 	f, err := os.Open(testFilePath)
@@ -760,8 +780,9 @@ func TestTransferReadWriteErrors(t *testing.T) {
 	r, w, err = pipeat.Pipe()
 	assert.NoError(t, err)
 	mockFs := newMockOsFs(nil, false, fs.ConnectionID(), user.HomeDir, r)
-	baseTransfer = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
+	baseTransfer, err = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
 		common.TransferDownload, 0, 0, 0, false, mockFs)
+	require.NoError(t, err)
 	davFile = newWebDavFile(baseTransfer, nil, nil)
 
 	writeContent := []byte("content\r\n")
@@ -780,8 +801,9 @@ func TestTransferReadWriteErrors(t *testing.T) {
 	err = davFile.Close()
 	assert.NoError(t, err)
 
-	baseTransfer = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
+	baseTransfer, err = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
 		common.TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	davFile = newWebDavFile(baseTransfer, nil, nil)
 	davFile.writer = f
 	err = davFile.Close()
@@ -803,16 +825,18 @@ func TestTransferSeek(t *testing.T) {
 	}
 	testFilePath := filepath.Join(user.HomeDir, testFile)
 	testFileContents := []byte("content")
-	baseTransfer := common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
+	baseTransfer, err := common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
 		common.TransferUpload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	davFile := newWebDavFile(baseTransfer, nil, nil)
-	_, err := davFile.Seek(0, io.SeekStart)
+	_, err = davFile.Seek(0, io.SeekStart)
 	assert.EqualError(t, err, common.ErrOpUnsupported.Error())
 	err = davFile.Close()
 	assert.NoError(t, err)
 
-	baseTransfer = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
+	baseTransfer, err = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
 		common.TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	davFile = newWebDavFile(baseTransfer, nil, nil)
 	_, err = davFile.Seek(0, io.SeekCurrent)
 	assert.True(t, os.IsNotExist(err))
@@ -825,15 +849,17 @@ func TestTransferSeek(t *testing.T) {
 		err = f.Close()
 		assert.NoError(t, err)
 	}
-	baseTransfer = common.NewBaseTransfer(f, connection.BaseConnection, nil, testFilePath, testFile,
+	baseTransfer, err = common.NewBaseTransfer(f, connection.BaseConnection, nil, testFilePath, testFile,
 		common.TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	davFile = newWebDavFile(baseTransfer, nil, nil)
 	_, err = davFile.Seek(0, io.SeekStart)
 	assert.Error(t, err)
 	davFile.Connection.RemoveTransfer(davFile.BaseTransfer)
 
-	baseTransfer = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
+	baseTransfer, err = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
 		common.TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	davFile = newWebDavFile(baseTransfer, nil, nil)
 	res, err := davFile.Seek(0, io.SeekStart)
 	assert.NoError(t, err)
@@ -847,15 +873,17 @@ func TestTransferSeek(t *testing.T) {
 	err = davFile.updateStatInfo()
 	assert.Nil(t, err)
 
-	baseTransfer = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath+"1", testFile,
+	baseTransfer, err = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath+"1", testFile,
 		common.TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	davFile = newWebDavFile(baseTransfer, nil, nil)
 	_, err = davFile.Seek(0, io.SeekEnd)
 	assert.True(t, os.IsNotExist(err))
 	davFile.Connection.RemoveTransfer(davFile.BaseTransfer)
 
-	baseTransfer = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
+	baseTransfer, err = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath, testFile,
 		common.TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 	davFile = newWebDavFile(baseTransfer, nil, nil)
 	davFile.reader = f
 	davFile.Fs = newMockOsFs(nil, true, fs.ConnectionID(), user.GetHomeDir(), nil)
@@ -869,8 +897,9 @@ func TestTransferSeek(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, int64(5), res)
 
-	baseTransfer = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath+"1", testFile,
+	baseTransfer, err = common.NewBaseTransfer(nil, connection.BaseConnection, nil, testFilePath+"1", testFile,
 		common.TransferDownload, 0, 0, 0, false, fs)
+	require.NoError(t, err)
 
 	davFile = newWebDavFile(baseTransfer, nil, nil)
 	davFile.Fs = newMockOsFs(nil, true, fs.ConnectionID(), user.GetHomeDir(), nil)
@@ -968,6 +997,13 @@ func TestBasicUsersCache(t *testing.T) {
 		cachedUser = result.(*dataprovider.CachedUser)
 		assert.False(t, cachedUser.IsExpired())
 	}
+	// an expired account must not be served from the cache
+	cachedUser.User.ExpirationDate = utils.GetTimeAsMsSinceEpoch(now.Add(-1 * time.Hour))
+	assert.True(t, cachedUser.IsExpired())
+	dataprovider.CacheWebDAVUser(cachedUser, c.Cache.Users.MaxSize)
+	_, isCached, _, err = server.authenticate(req, ipAddr)
+	assert.NoError(t, err)
+	assert.False(t, isCached)
 	// cache is invalidated after a user modification
 	err = dataprovider.UpdateUser(&user)
 	assert.NoError(t, err)
@@ -11,7 +11,6 @@ import (
 	"path"
 	"path/filepath"
 	"runtime/debug"
-	"strings"
 	"time"
 
 	"github.com/go-chi/chi/middleware"
@@ -21,15 +20,14 @@ import (
 
 	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/geoip"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/metrics"
 	"github.com/drakkan/sftpgo/utils"
 )
 
 var (
-	err401        = errors.New("Unauthorized")
-	xForwardedFor = http.CanonicalHeaderKey("X-Forwarded-For")
-	xRealIP       = http.CanonicalHeaderKey("X-Real-IP")
+	err401 = errors.New("Unauthorized")
 )
 
 type webDavServer struct {
@@ -137,17 +135,32 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, common.ErrGenericFailure.Error(), http.StatusInternalServerError)
 		}
 	}()
+	if common.IsShuttingDown() {
+		logger.Log(logger.LevelDebug, common.ProtocolWebDAV, "", "request refused, the server is shutting down")
+		http.Error(w, common.ErrConnectionDenied.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if !common.Connections.IsNewConnectionAllowed() {
 		logger.Log(logger.LevelDebug, common.ProtocolFTP, "", "connection refused, configured limit reached")
 		http.Error(w, common.ErrConnectionDenied.Error(), http.StatusServiceUnavailable)
 		return
 	}
-	checkRemoteAddress(r)
+	s.checkRemoteAddress(r)
 	ipAddr := utils.GetIPFromRemoteAddress(r.RemoteAddr)
+	if !common.IsIPAllowed(ipAddr) {
+		logger.Log(logger.LevelDebug, common.ProtocolWebDAV, "", "request refused, ip %#v is not allowed", ipAddr)
+		http.Error(w, common.ErrConnectionDenied.Error(), http.StatusForbidden)
+		return
+	}
 	if common.IsBanned(ipAddr) {
 		http.Error(w, common.ErrConnectionDenied.Error(), http.StatusForbidden)
 		return
 	}
+	if err := common.LimitRate(common.RateLimiterTargetConnections, common.ProtocolWebDAV, ipAddr); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolWebDAV, "", "request refused, ip %#v: %v", ipAddr, err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
 	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolWebDAV); err != nil {
 		http.Error(w, common.ErrConnectionDenied.Error(), http.StatusForbidden)
 		return
@@ -182,10 +195,11 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		BaseConnection: common.NewBaseConnection(connectionID, common.ProtocolWebDAV, user, fs),
 		request:        r,
 	}
+	connection.SetRemoteAddress(r.RemoteAddr)
 	common.Connections.Add(connection)
 	defer common.Connections.Remove(connection.GetID())
 
-	dataprovider.UpdateLastLogin(&user) //nolint:errcheck
+	dataprovider.UpdateLastLogin(&user, common.ProtocolWebDAV) //nolint:errcheck
 
 	if s.checkRequestMethod(ctx, r, connection) {
 		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
@@ -222,12 +236,22 @@ func (s *webDavServer) authenticate(r *http.Request, ip string) (dataprovider.Us
 			return user, false, nil, dataprovider.ErrInvalidCredentials
 		}
 	}
+	if err = common.CheckLoginAttempt(ip, common.ProtocolWebDAV); err != nil {
+		user.Username = username
+		updateLoginMetrics(&user, ip, err)
+		return user, false, nil, err
+	}
 	user, err = dataprovider.CheckUserAndPass(username, password, ip, common.ProtocolWebDAV)
 	if err != nil {
 		user.Username = username
 		updateLoginMetrics(&user, ip, err)
 		return user, false, nil, err
 	}
+	// for temporary credentials a use is recorded only if the user is not cached
+	if err = dataprovider.AddTempCredentialsUse(&user); err != nil {
+		updateLoginMetrics(&user, ip, err)
+		return user, false, nil, err
+	}
 	lockSystem := webdav.NewMemLS()
 	if password != "" {
 		cachedUser := &dataprovider.CachedUser{
@@ -276,6 +300,14 @@ func (s *webDavServer) validateUser(user *dataprovider.User, r *http.Request) (s
 			return connID, fmt.Errorf("too many open sessions: %v", activeSessions)
 		}
 	}
+	if maxSessions := user.GetMaxSessionsForProtocol(common.ProtocolWebDAV); maxSessions > 0 {
+		activeSessions := common.Connections.GetActiveProtocolSessions(user.Username, common.ProtocolWebDAV)
+		if activeSessions >= maxSessions {
+			logger.Debug(logSender, connID, "authentication refused for user: %#v, too many open WebDAV sessions: %v/%v", user.Username,
+				activeSessions, maxSessions)
+			return connID, fmt.Errorf("too many open WebDAV sessions: %v", activeSessions)
+		}
+	}
 	if dataprovider.GetQuotaTracking() > 0 && user.HasOverlappedMappedPaths() {
 		logger.Debug(logSender, connectionID, "cannot login user %#v, overlapping mapped folders are allowed only with quota tracking disabled",
 			user.Username)
@@ -313,24 +345,10 @@ func writeLog(r *http.Request, err error) {
 		Send()
 }
 
-func checkRemoteAddress(r *http.Request) {
-	if common.Config.ProxyProtocol != 0 {
-		return
-	}
-
-	var ip string
-
-	if xrip := r.Header.Get(xRealIP); xrip != "" {
-		ip = xrip
-	} else if xff := r.Header.Get(xForwardedFor); xff != "" {
-		i := strings.Index(xff, ", ")
-		if i == -1 {
-			i = len(xff)
-		}
-		ip = strings.TrimSpace(xff[:i])
-	}
-
-	if len(ip) > 0 {
+// checkRemoteAddress sets the client IP address from the proxy header,
+// if the request comes from a trusted proxy
+func (s *webDavServer) checkRemoteAddress(r *http.Request) {
+	if ip := s.binding.trustedProxies.GetClientIP(r); ip != "" {
 		r.RemoteAddr = ip
 	}
 }
@@ -338,12 +356,16 @@ func checkRemoteAddress(r *http.Request) {
 func updateLoginMetrics(user *dataprovider.User, ip string, err error) {
 	metrics.AddLoginAttempt(dataprovider.LoginMethodPassword)
 	if err != nil {
-		logger.ConnectionFailedLog(user.Username, ip, dataprovider.LoginMethodPassword, common.ProtocolWebDAV, err.Error())
+		logger.ConnectionFailedLog(user.Username, ip, geoip.GetCountry(ip), dataprovider.LoginMethodPassword,
+			common.ProtocolWebDAV, err.Error())
 		event := common.HostEventLoginFailed
 		if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
 			event = common.HostEventUserNotFound
 		}
 		common.AddDefenderEvent(ip, event)
+		common.DelayFailedLogin(ip, user.Username)
+	} else {
+		common.ResetFailedLogins(ip, user.Username)
 	}
 	metrics.AddLoginResult(dataprovider.LoginMethodPassword, err)
 	dataprovider.ExecutePostLoginHook(user, dataprovider.LoginMethodPassword, ip, common.ProtocolWebDAV, err)
@@ -86,6 +86,28 @@ type Binding struct {
 	// any invalid name will be silently ignored.
 	// The order matters, the ciphers listed first will be the preferred ones.
 	TLSCipherSuites []string `json:"tls_cipher_suites" mapstructure:"tls_cipher_suites"`
+	// List of IP addresses and IP ranges, in CIDR notation, of the trusted reverse proxies.
+	// The client IP address is read from the ClientIPProxyHeader only for the requests
+	// coming from these proxies, for the other requests the proxy headers are ignored
+	ProxyAllowed []string `json:"proxy_allowed" mapstructure:"proxy_allowed"`
+	// The header to read the client IP address from, for the requests coming from a
+	// trusted proxy. Supported headers: X-Forwarded-For, X-Real-IP, CF-Connecting-IP.
+	// Empty means X-Forwarded-For
+	ClientIPProxyHeader string `json:"client_ip_proxy_header" mapstructure:"client_ip_proxy_header"`
+	// For X-Forwarded-For, the number of addresses to skip starting from the right.
+	// 0 means the address added by the trusted proxy, increase it if the request
+	// goes through multiple trusted proxies
+	ClientIPHeaderDepth int `json:"client_ip_header_depth" mapstructure:"client_ip_header_depth"`
+	trustedProxies      *common.TrustedProxies
+}
+
+func (b *Binding) parseTrustedProxies() error {
+	trustedProxies, err := common.NewTrustedProxies(b.ProxyAllowed, b.ClientIPProxyHeader, b.ClientIPHeaderDepth)
+	if err != nil {
+		return fmt.Errorf("invalid proxy configuration for binding %#v: %w", b.GetAddress(), err)
+	}
+	b.trustedProxies = trustedProxies
+	return nil
 }
 
 // GetAddress returns the binding address
@@ -172,6 +194,12 @@ func (c *Configuration) Initialize(configDir string) error {
 	}
 	compressor := middleware.NewCompressor(5, "text/*")
 
+	for idx := range c.Bindings {
+		if err := c.Bindings[idx].parseTrustedProxies(); err != nil {
+			return err
+		}
+	}
+
 	serviceStatus = ServiceStatus{
 		Bindings: nil,
 	}
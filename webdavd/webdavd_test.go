@@ -282,6 +282,15 @@ func TestInitialization(t *testing.T) {
 	cfg.CARevocationLists = nil
 	err = cfg.Initialize(configDir)
 	assert.Error(t, err)
+
+	cfg.CertificateFile = ""
+	cfg.CertificateKeyFile = ""
+	cfg.Bindings[0].ProxyAllowed = []string{"10.8.0.1"}
+	cfg.Bindings[0].ClientIPProxyHeader = "X-Client-IP"
+	err = cfg.Initialize(configDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid proxy configuration")
+	}
 }
 
 func TestBasicHandling(t *testing.T) {